@@ -22,11 +22,18 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ServiceClient interface {
-	ExecuteOnLeader(ctx context.Context, in *ExecuteOnLeaderRequest, opts ...grpc.CallOption) (*Empty, error)
+	ExecuteOnLeader(ctx context.Context, in *ExecuteOnLeaderRequest, opts ...grpc.CallOption) (*ExecuteOnLeaderResponse, error)
 	ReinstallNode(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
 	IsLeader(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*IsLeaderResponse, error)
-	ConsensusJoin(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*Empty, error)
+	ConsensusJoin(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusJoinResponse, error)
 	ConsensusRemove(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*Empty, error)
+	Replicate(ctx context.Context, opts ...grpc.CallOption) (Service_ReplicateClient, error)
+	ReadKey(ctx context.Context, in *ReadKeyRequest, opts ...grpc.CallOption) (*ReadKeyResponse, error)
+	ClusterEvents(ctx context.Context, in *ClusterEventsRequest, opts ...grpc.CallOption) (Service_ClusterEventsClient, error)
+	PutStream(ctx context.Context, opts ...grpc.CallOption) (Service_PutStreamClient, error)
+	GetStream(ctx context.Context, in *GetStreamRequest, opts ...grpc.CallOption) (Service_GetStreamClient, error)
+	Write(ctx context.Context, opts ...grpc.CallOption) (Service_WriteClient, error)
+	PullBackup(ctx context.Context, in *PullBackupRequest, opts ...grpc.CallOption) (Service_PullBackupClient, error)
 }
 
 type serviceClient struct {
@@ -37,8 +44,8 @@ func NewServiceClient(cc grpc.ClientConnInterface) ServiceClient {
 	return &serviceClient{cc}
 }
 
-func (c *serviceClient) ExecuteOnLeader(ctx context.Context, in *ExecuteOnLeaderRequest, opts ...grpc.CallOption) (*Empty, error) {
-	out := new(Empty)
+func (c *serviceClient) ExecuteOnLeader(ctx context.Context, in *ExecuteOnLeaderRequest, opts ...grpc.CallOption) (*ExecuteOnLeaderResponse, error) {
+	out := new(ExecuteOnLeaderResponse)
 	err := c.cc.Invoke(ctx, "/proto.Service/ExecuteOnLeader", in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -64,8 +71,8 @@ func (c *serviceClient) IsLeader(ctx context.Context, in *Empty, opts ...grpc.Ca
 	return out, nil
 }
 
-func (c *serviceClient) ConsensusJoin(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*Empty, error) {
-	out := new(Empty)
+func (c *serviceClient) ConsensusJoin(ctx context.Context, in *ConsensusRequest, opts ...grpc.CallOption) (*ConsensusJoinResponse, error) {
+	out := new(ConsensusJoinResponse)
 	err := c.cc.Invoke(ctx, "/proto.Service/ConsensusJoin", in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -82,15 +89,223 @@ func (c *serviceClient) ConsensusRemove(ctx context.Context, in *ConsensusReques
 	return out, nil
 }
 
+func (c *serviceClient) Replicate(ctx context.Context, opts ...grpc.CallOption) (Service_ReplicateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[0], "/proto.Service/Replicate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceReplicateClient{stream}
+	return x, nil
+}
+
+type Service_ReplicateClient interface {
+	Send(*ReplicateRequest) error
+	Recv() (*ReplicateResponse, error)
+	grpc.ClientStream
+}
+
+type serviceReplicateClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceReplicateClient) Send(m *ReplicateRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *serviceReplicateClient) Recv() (*ReplicateResponse, error) {
+	m := new(ReplicateResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) ReadKey(ctx context.Context, in *ReadKeyRequest, opts ...grpc.CallOption) (*ReadKeyResponse, error) {
+	out := new(ReadKeyResponse)
+	err := c.cc.Invoke(ctx, "/proto.Service/ReadKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *serviceClient) ClusterEvents(ctx context.Context, in *ClusterEventsRequest, opts ...grpc.CallOption) (Service_ClusterEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[1], "/proto.Service/ClusterEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceClusterEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_ClusterEventsClient interface {
+	Recv() (*ClusterEvent, error)
+	grpc.ClientStream
+}
+
+type serviceClusterEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceClusterEventsClient) Recv() (*ClusterEvent, error) {
+	m := new(ClusterEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) PutStream(ctx context.Context, opts ...grpc.CallOption) (Service_PutStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[2], "/proto.Service/PutStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &servicePutStreamClient{stream}
+	return x, nil
+}
+
+type Service_PutStreamClient interface {
+	Send(*PutStreamRequest) error
+	CloseAndRecv() (*PutStreamResponse, error)
+	grpc.ClientStream
+}
+
+type servicePutStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *servicePutStreamClient) Send(m *PutStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *servicePutStreamClient) CloseAndRecv() (*PutStreamResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PutStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) GetStream(ctx context.Context, in *GetStreamRequest, opts ...grpc.CallOption) (Service_GetStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[3], "/proto.Service/GetStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceGetStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_GetStreamClient interface {
+	Recv() (*GetStreamResponse, error)
+	grpc.ClientStream
+}
+
+type serviceGetStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceGetStreamClient) Recv() (*GetStreamResponse, error) {
+	m := new(GetStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) Write(ctx context.Context, opts ...grpc.CallOption) (Service_WriteClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[4], "/proto.Service/Write", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceWriteClient{stream}
+	return x, nil
+}
+
+type Service_WriteClient interface {
+	Send(*WriteRequest) error
+	Recv() (*WriteResponse, error)
+	grpc.ClientStream
+}
+
+type serviceWriteClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceWriteClient) Send(m *WriteRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *serviceWriteClient) Recv() (*WriteResponse, error) {
+	m := new(WriteResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) PullBackup(ctx context.Context, in *PullBackupRequest, opts ...grpc.CallOption) (Service_PullBackupClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[5], "/proto.Service/PullBackup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &servicePullBackupClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_PullBackupClient interface {
+	Recv() (*PullBackupChunk, error)
+	grpc.ClientStream
+}
+
+type servicePullBackupClient struct {
+	grpc.ClientStream
+}
+
+func (x *servicePullBackupClient) Recv() (*PullBackupChunk, error) {
+	m := new(PullBackupChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ServiceServer is the server API for Service service.
 // All implementations must embed UnimplementedServiceServer
 // for forward compatibility
 type ServiceServer interface {
-	ExecuteOnLeader(context.Context, *ExecuteOnLeaderRequest) (*Empty, error)
+	ExecuteOnLeader(context.Context, *ExecuteOnLeaderRequest) (*ExecuteOnLeaderResponse, error)
 	ReinstallNode(context.Context, *Empty) (*Empty, error)
 	IsLeader(context.Context, *Empty) (*IsLeaderResponse, error)
-	ConsensusJoin(context.Context, *ConsensusRequest) (*Empty, error)
+	ConsensusJoin(context.Context, *ConsensusRequest) (*ConsensusJoinResponse, error)
 	ConsensusRemove(context.Context, *ConsensusRequest) (*Empty, error)
+	Replicate(Service_ReplicateServer) error
+	ReadKey(context.Context, *ReadKeyRequest) (*ReadKeyResponse, error)
+	ClusterEvents(*ClusterEventsRequest, Service_ClusterEventsServer) error
+	PutStream(Service_PutStreamServer) error
+	GetStream(*GetStreamRequest, Service_GetStreamServer) error
+	Write(Service_WriteServer) error
+	PullBackup(*PullBackupRequest, Service_PullBackupServer) error
 	mustEmbedUnimplementedServiceServer()
 }
 
@@ -98,7 +313,7 @@ type ServiceServer interface {
 type UnimplementedServiceServer struct {
 }
 
-func (UnimplementedServiceServer) ExecuteOnLeader(context.Context, *ExecuteOnLeaderRequest) (*Empty, error) {
+func (UnimplementedServiceServer) ExecuteOnLeader(context.Context, *ExecuteOnLeaderRequest) (*ExecuteOnLeaderResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ExecuteOnLeader not implemented")
 }
 func (UnimplementedServiceServer) ReinstallNode(context.Context, *Empty) (*Empty, error) {
@@ -107,12 +322,33 @@ func (UnimplementedServiceServer) ReinstallNode(context.Context, *Empty) (*Empty
 func (UnimplementedServiceServer) IsLeader(context.Context, *Empty) (*IsLeaderResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method IsLeader not implemented")
 }
-func (UnimplementedServiceServer) ConsensusJoin(context.Context, *ConsensusRequest) (*Empty, error) {
+func (UnimplementedServiceServer) ConsensusJoin(context.Context, *ConsensusRequest) (*ConsensusJoinResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ConsensusJoin not implemented")
 }
 func (UnimplementedServiceServer) ConsensusRemove(context.Context, *ConsensusRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ConsensusRemove not implemented")
 }
+func (UnimplementedServiceServer) Replicate(Service_ReplicateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedServiceServer) ReadKey(context.Context, *ReadKeyRequest) (*ReadKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadKey not implemented")
+}
+func (UnimplementedServiceServer) ClusterEvents(*ClusterEventsRequest, Service_ClusterEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ClusterEvents not implemented")
+}
+func (UnimplementedServiceServer) PutStream(Service_PutStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutStream not implemented")
+}
+func (UnimplementedServiceServer) GetStream(*GetStreamRequest, Service_GetStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetStream not implemented")
+}
+func (UnimplementedServiceServer) Write(Service_WriteServer) error {
+	return status.Errorf(codes.Unimplemented, "method Write not implemented")
+}
+func (UnimplementedServiceServer) PullBackup(*PullBackupRequest, Service_PullBackupServer) error {
+	return status.Errorf(codes.Unimplemented, "method PullBackup not implemented")
+}
 func (UnimplementedServiceServer) mustEmbedUnimplementedServiceServer() {}
 
 // UnsafeServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -216,6 +452,165 @@ func _Service_ConsensusRemove_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Service_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ServiceServer).Replicate(&serviceReplicateServer{stream})
+}
+
+type Service_ReplicateServer interface {
+	Send(*ReplicateResponse) error
+	Recv() (*ReplicateRequest, error)
+	grpc.ServerStream
+}
+
+type serviceReplicateServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceReplicateServer) Send(m *ReplicateResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *serviceReplicateServer) Recv() (*ReplicateRequest, error) {
+	m := new(ReplicateRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Service_ReadKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServiceServer).ReadKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Service/ReadKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServiceServer).ReadKey(ctx, req.(*ReadKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Service_ClusterEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ClusterEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).ClusterEvents(m, &serviceClusterEventsServer{stream})
+}
+
+type Service_ClusterEventsServer interface {
+	Send(*ClusterEvent) error
+	grpc.ServerStream
+}
+
+type serviceClusterEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceClusterEventsServer) Send(m *ClusterEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_PutStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ServiceServer).PutStream(&servicePutStreamServer{stream})
+}
+
+type Service_PutStreamServer interface {
+	SendAndClose(*PutStreamResponse) error
+	Recv() (*PutStreamRequest, error)
+	grpc.ServerStream
+}
+
+type servicePutStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *servicePutStreamServer) SendAndClose(m *PutStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *servicePutStreamServer) Recv() (*PutStreamRequest, error) {
+	m := new(PutStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Service_GetStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetStreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).GetStream(m, &serviceGetStreamServer{stream})
+}
+
+type Service_GetStreamServer interface {
+	Send(*GetStreamResponse) error
+	grpc.ServerStream
+}
+
+type serviceGetStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceGetStreamServer) Send(m *GetStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_Write_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ServiceServer).Write(&serviceWriteServer{stream})
+}
+
+type Service_WriteServer interface {
+	Send(*WriteResponse) error
+	Recv() (*WriteRequest, error)
+	grpc.ServerStream
+}
+
+type serviceWriteServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceWriteServer) Send(m *WriteResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *serviceWriteServer) Recv() (*WriteRequest, error) {
+	m := new(WriteRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Service_PullBackup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PullBackupRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).PullBackup(m, &servicePullBackupServer{stream})
+}
+
+type Service_PullBackupServer interface {
+	Send(*PullBackupChunk) error
+	grpc.ServerStream
+}
+
+type servicePullBackupServer struct {
+	grpc.ServerStream
+}
+
+func (x *servicePullBackupServer) Send(m *PullBackupChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Service_ServiceDesc is the grpc.ServiceDesc for Service service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -243,7 +638,44 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ConsensusRemove",
 			Handler:    _Service_ConsensusRemove_Handler,
 		},
+		{
+			MethodName: "ReadKey",
+			Handler:    _Service_ReadKey_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Replicate",
+			Handler:       _Service_Replicate_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ClusterEvents",
+			Handler:       _Service_ClusterEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PutStream",
+			Handler:       _Service_PutStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetStream",
+			Handler:       _Service_GetStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Write",
+			Handler:       _Service_Write_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "PullBackup",
+			Handler:       _Service_PullBackup_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/proto/proto.proto",
 }
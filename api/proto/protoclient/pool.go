@@ -0,0 +1,164 @@
+package protoclient
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/protocol"
+	"sync"
+	"time"
+)
+
+// retryServiceConfig retries the reads which failed with UNAVAILABLE, with exponential backoff.
+//
+// UNAVAILABLE is also returned by the server for a call it processed, like a write whose leader lost its leadership
+// after appending it, so only the methods which don't change the state are retried, they are safe to run twice.
+// The writes are retried by the callers, which know which errors guarantee they weren't applied.
+const retryServiceConfig = `{
+	"methodConfig": [{
+		"name": [
+			{"service": "proto.Service", "method": "IsLeader"},
+			{"service": "proto.Service", "method": "ReadKey"},
+			{"service": "proto.Service", "method": "GetStream"},
+			{"service": "proto.Service", "method": "ClusterEvents"},
+			{"service": "proto.Service", "method": "PullBackup"}
+		],
+		"retryPolicy": {
+			"MaxAttempts": 4,
+			"InitialBackoff": "0.1s",
+			"MaxBackoff": "1s",
+			"BackoffMultiplier": 2.0,
+			"RetryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+
+// connPool keeps a single connection per peer, which is shared by all the calls to it.
+//
+// Dialing a new connection for every call adds latency, and causes connection storms during fail-overs.
+type connPool struct {
+	mu    sync.Mutex
+	peers map[string]*peer
+}
+
+// peer is a pooled connection with its circuit breaker.
+type peer struct {
+	conn    *grpc.ClientConn
+	breaker *breaker
+}
+
+// pool is the package's connection pool, shared by every Connection.
+var pool = &connPool{peers: make(map[string]*peer)}
+
+// get returns the pooled connection to addr, dialing it if there isn't one or if it was shut down.
+func (p *connPool) get(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing, ok := p.peers[addr]
+	if ok && existing.conn.GetState() != connectivity.Shutdown {
+		return existing.conn, nil
+	}
+
+	b := newBreaker()
+	conn, errDial := grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(retryServiceConfig),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                10 * time.Second,
+			Timeout:             3 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.Config{BaseDelay: 100 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: 5 * time.Second},
+			MinConnectTimeout: 1 * time.Second,
+		}),
+		grpc.WithChainUnaryInterceptor(protocol.UnaryClientInterceptor, b.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(protocol.StreamClientInterceptor),
+	)
+	if errDial != nil {
+		return nil, errDial
+	}
+
+	p.peers[addr] = &peer{conn: conn, breaker: b}
+	return conn, nil
+}
+
+// breaker is a circuit breaker: after too many consecutive failures,
+// it fails the calls immediately during a cooldown, instead of waiting for them to time out.
+//
+// Once the cooldown passes, a single call is let through to check if the peer recovered.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+const (
+	breakerThreshold = 5
+	breakerCooldown  = 5 * time.Second
+)
+
+func newBreaker() *breaker {
+	return &breaker{}
+}
+
+// allow returns whether a call can be made.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerThreshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record registers the result of a call.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !isPeerFailure(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+func (b *breaker) unaryInterceptor(
+	ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+) error {
+	if !b.allow() {
+		// It carries its code, so the callers know the call wasn't sent, unlike the errors of the connection.
+		return errcode.Status(errcode.New(errcode.Unavailable, "circuit breaker is open for "+cc.Target())).Err()
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	b.record(err)
+	return err
+}
+
+// isPeerFailure returns whether an error means that the peer couldn't be reached,
+// errors returned by the peer itself don't count.
+func isPeerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := status.Code(err)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
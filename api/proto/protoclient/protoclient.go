@@ -7,6 +7,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"nubedb/api/proto"
+	"nubedb/cluster/protocol"
 	"time"
 )
 
@@ -16,16 +17,21 @@ type ConnCleaner interface {
 }
 
 // Connection represents a connection to a gRPC server.
+//
+// The underlying gRPC connection is pooled and shared with other Connections to the same server.
 type Connection struct {
 	Conn          *grpc.ClientConn
 	Client        proto.ServiceClient
 	Ctx           context.Context
 	cancelCtxCall context.CancelFunc
+	pooled        bool
 }
 
-// Cleanup closes the connection and cancels the context.
+// Cleanup cancels the context, and closes the connection if it isn't pooled.
 func (c *Connection) Cleanup() {
-	_ = c.Conn.Close()
+	if !c.pooled {
+		_ = c.Conn.Close()
+	}
 	c.cancelCtxCall()
 }
 
@@ -40,28 +46,55 @@ func (c *Connection) Cleanup() {
 //	defer conn.Cleanup()
 func NewConnection(addr string) (*Connection, error) {
 	const (
-		dialTimeout       = 1 * time.Second
 		timeoutGrpcCall   = 3 * time.Second
 		errGrpcConnection = "grpc connection failed"
 	)
 
-	// Create a context with a timeout for dialing the server.
+	// Get the pooled connection to the server.
+	connPooled, errPool := pool.get(addr)
+	if errPool != nil {
+		return nil, errorskit.Wrap(errPool, errGrpcConnection)
+	}
+
+	// Create a context with a timeout for executing gRPC calls.
+	ctxExecuteCall, cancelCtxExecuteCall := context.WithTimeout(context.Background(), timeoutGrpcCall)
+
+	return &Connection{
+		Conn:          connPooled,                         // set the gRPC connection
+		Client:        proto.NewServiceClient(connPooled), // create a new service client
+		Ctx:           ctxExecuteCall,                     // set the context for executing gRPC calls
+		cancelCtxCall: cancelCtxExecuteCall,               // set the cancel function for the context included before
+		pooled:        true,
+	}, nil
+}
+
+// NewStreamConnection creates a new connection to a gRPC server, to be used for long-lived streams.
+//
+// Unlike NewConnection, the context of the calls doesn't have a timeout, it's only canceled on Cleanup.
+func NewStreamConnection(addr string) (*Connection, error) {
+	const (
+		dialTimeout       = 1 * time.Second
+		errGrpcConnection = "grpc connection failed"
+	)
+
 	ctxDial, cancelCtxDial := context.WithTimeout(context.Background(), dialTimeout)
 	defer cancelCtxDial()
 
-	// Dial the server using the context.
-	connDial, errDial := grpc.DialContext(ctxDial, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	connDial, errDial := grpc.DialContext(ctxDial, addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(protocol.UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(protocol.StreamClientInterceptor),
+	)
 	if errDial != nil {
 		return nil, errorskit.Wrap(errDial, errGrpcConnection)
 	}
 
-	// Create a context with a timeout for executing gRPC calls.
-	ctxExecuteCall, cancelCtxExecuteCall := context.WithTimeout(context.Background(), timeoutGrpcCall)
+	ctxStream, cancelCtxStream := context.WithCancel(context.Background())
 
 	return &Connection{
-		Conn:          connDial,                         // set the gRPC connection
-		Client:        proto.NewServiceClient(connDial), // create a new service client
-		Ctx:           ctxExecuteCall,                   // set the context for executing gRPC calls
-		cancelCtxCall: cancelCtxExecuteCall,             // set the cancel function for the context included before
+		Conn:          connDial,
+		Client:        proto.NewServiceClient(connDial),
+		Ctx:           ctxStream,
+		cancelCtxCall: cancelCtxStream,
 	}, nil
 }
@@ -0,0 +1,65 @@
+package protoserver
+
+import (
+	"bufio"
+	"log"
+	"nubedb/api/proto"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/peerauth"
+)
+
+// PullBackup streams a copy of the data of a shard of this node, so a new node can start from it
+// instead of catching up from the leader.
+//
+// If a cluster secret is configured, the caller must prove it knows it, or present a join token.
+func (srv *server) PullBackup(req *proto.PullBackupRequest, stream proto.Service_PullBackupServer) error {
+	_, errAuth := peerauth.VerifyJoin(stream.Context(), req.NodeID, "")
+	if errAuth != nil {
+		log.Printf("[proto] (PullBackup) refused sending a backup to node %s: %v\n", req.NodeID, errAuth)
+		return errAuth
+	}
+	if srv.Node.IsWitness() {
+		return consensus.ErrWitnessRead
+	}
+	s, errShard := srv.shardOf(stream.Context())
+	if errShard != nil {
+		return errShard
+	}
+
+	log.Printf("[proto] (PullBackup) sending a backup of shard %v to node %s\n", s.ID, req.NodeID)
+	w := &backupChunkWriter{stream: stream, appliedIndex: s.Consensus.AppliedIndex()}
+	bw := bufio.NewWriterSize(w, streamMessageSize)
+	errCopy := s.FSM.CopyTo(bw)
+	if errCopy != nil {
+		return errCopy
+	}
+	errFlush := bw.Flush()
+	if errFlush != nil {
+		return errFlush
+	}
+	if !w.sent {
+		// The shard is empty, the applied index is still sent.
+		return stream.Send(&proto.PullBackupChunk{AppliedIndex: w.appliedIndex})
+	}
+	return nil
+}
+
+// backupChunkWriter sends the data written to it as PullBackupChunks, the first one carrying the applied index.
+type backupChunkWriter struct {
+	stream       proto.Service_PullBackupServer
+	appliedIndex uint64
+	sent         bool
+}
+
+func (w *backupChunkWriter) Write(p []byte) (int, error) {
+	chunk := &proto.PullBackupChunk{Data: p}
+	if !w.sent {
+		chunk.AppliedIndex = w.appliedIndex
+	}
+	errSend := w.stream.Send(chunk)
+	if errSend != nil {
+		return 0, errSend
+	}
+	w.sent = true
+	return len(p), nil
+}
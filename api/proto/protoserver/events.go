@@ -0,0 +1,51 @@
+package protoserver
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"nubedb/api/proto"
+	"nubedb/cluster/consensus"
+)
+
+// ClusterEvents streams the changes of the consensus observed by this node, until the client cancels the stream.
+func (srv *server) ClusterEvents(req *proto.ClusterEventsRequest, stream proto.Service_ClusterEventsServer) error {
+	types, errTypes := consensus.EventTypes(req.Types)
+	if errTypes != nil {
+		return status.Error(codes.InvalidArgument, errTypes.Error())
+	}
+
+	events, unsubscribe := srv.Node.SubscribeEvents()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-events:
+			if types != nil && !types[e.Type] {
+				continue
+			}
+			errSend := stream.Send(eventToProto(e))
+			if errSend != nil {
+				return errSend
+			}
+		}
+	}
+}
+
+func eventToProto(e consensus.ClusterEvent) *proto.ClusterEvent {
+	event := &proto.ClusterEvent{
+		Type:         e.Type,
+		Node:         e.Node,
+		Shard:        int32(e.Shard),
+		TimeUnixNano: e.Time.UnixNano(),
+		State:        e.State,
+		LeaderID:     e.LeaderID,
+		PeerID:       e.PeerID,
+		PeerAddress:  e.PeerAddress,
+		Removed:      e.Removed,
+	}
+	if e.LastContact != nil {
+		event.LastContactUnixNano = e.LastContact.UnixNano()
+	}
+	return event
+}
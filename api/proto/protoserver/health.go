@@ -0,0 +1,39 @@
+package protoserver
+
+import (
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"log"
+	"nubedb/cluster/consensus"
+	"time"
+)
+
+// serviceName is the full name of nubedb's gRPC service, as reported by the health service.
+const serviceName = "proto.Service"
+
+// watchHealth keeps the health service updated, blocks indefinitely.
+//
+// The node is reported as NOT_SERVING while the consensus doesn't have a leader,
+// since it can't accept writes in that state, or while it's in maintenance mode.
+func watchHealth(healthSrv *health.Server, node *consensus.Node) {
+	const interval = 1 * time.Second
+	lastStatus := healthpb.HealthCheckResponse_UNKNOWN
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		status := healthpb.HealthCheckResponse_SERVING
+		if _, leaderID := node.Consensus.LeaderWithID(); leaderID == "" || node.InMaintenance() {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if status == lastStatus {
+			continue
+		}
+
+		log.Println("[proto] health status changed to:", status.String())
+		// The empty service name represents the status of the whole server.
+		healthSrv.SetServingStatus("", status)
+		healthSrv.SetServingStatus(serviceName, status)
+		lastStatus = status
+	}
+}
@@ -2,27 +2,37 @@ package protoserver
 
 import (
 	"context"
-	"errors"
 	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"nubedb/api/proto"
 	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/peerauth"
+	"nubedb/cluster/shard"
+	"nubedb/internal/config"
 )
 
 // ExecuteOnLeader executes a command on the Raft leader.
 //
 // The leader is the node that is currently responsible for coordinating updates to the network.
-func (srv *server) ExecuteOnLeader(ctx context.Context, req *proto.ExecuteOnLeaderRequest) (*proto.Empty, error) {
+func (srv *server) ExecuteOnLeader(ctx context.Context, req *proto.ExecuteOnLeaderRequest) (*proto.ExecuteOnLeaderResponse, error) {
 	log.Println("[proto] (ExecuteOnLeader) request received, processing...")
 
-	// Applies the command to the leader
-	errExecute := cluster.ApplyLeaderFuture(srv.Node.Consensus, req.Payload)
+	s, errShard := srv.shardOf(ctx)
+	if errShard != nil {
+		return &proto.ExecuteOnLeaderResponse{}, errShard
+	}
+
+	// Applies the command to the leader, it stops waiting for it once the forwarding node gives up
+	result, index, errExecute := cluster.ApplyForwarded(ctx, s.Consensus, req.Payload)
 	if errExecute != nil {
-		return &proto.Empty{}, errExecute
+		return &proto.ExecuteOnLeaderResponse{}, errExecute
 	}
 
 	log.Println("[proto] (ExecuteOnLeader) request successful")
-	return &proto.Empty{}, nil
+	return &proto.ExecuteOnLeaderResponse{Index: index, Result: result}, nil
 }
 
 // IsLeader checks if the node is currently the Raft leader.
@@ -34,37 +44,100 @@ func (srv *server) IsLeader(ctx context.Context, req *proto.Empty) (*proto.IsLea
 }
 
 // ConsensusJoin adds a new node to the Raft consensus network.
-func (srv *server) ConsensusJoin(ctx context.Context, req *proto.ConsensusRequest) (*proto.Empty, error) {
+//
+// Joins to a shard other than shard 0 are forwarded to the shard's leader if this node isn't it.
+// If a cluster secret is configured, the joining node must prove it knows it, or present a join token,
+// in which case its node key is sent back to it, never the secret.
+func (srv *server) ConsensusJoin(ctx context.Context, req *proto.ConsensusRequest) (*proto.ConsensusJoinResponse, error) {
 	log.Println("[proto] (ConsensusJoin) request received, processing...")
 
+	withToken, errAuth := peerauth.VerifyJoin(ctx, req.NodeID, req.NodeConsensusAddr)
+	if errAuth != nil {
+		log.Printf("[proto] (ConsensusJoin) refused node %s: %v\n", req.NodeID, errAuth)
+		return &proto.ConsensusJoinResponse{}, status.Error(codes.Unauthenticated, errAuth.Error())
+	}
+
+	s, errShard := srv.shardOf(ctx)
+	if errShard != nil {
+		return &proto.ConsensusJoinResponse{}, errShard
+	}
+	if s.ID != 0 && s.Consensus.State() != raft.Leader {
+		_, leaderID := s.Consensus.LeaderWithID()
+		if leaderID == "" {
+			return &proto.ConsensusJoinResponse{}, cluster.ErrNoLeader
+		}
+		errForward := cluster.ShardJoin(s.ID, req.NodeID, req.NodeConsensusAddr, config.MakeGrpcAddress(string(leaderID)))
+		return joinResponse(req.NodeID, withToken), errForward
+	}
+
 	// Checks if the node is already part of the network
-	consensusCfg := srv.Node.Consensus.GetConfiguration().Configuration()
+	consensusCfg := s.Consensus.GetConfiguration().Configuration()
 	for _, s := range consensusCfg.Servers {
 		if req.NodeID == string(s.ID) {
-			return &proto.Empty{}, errors.New("node was already part of the network")
+			return &proto.ConsensusJoinResponse{}, consensus.ErrAlreadyJoined
 		}
 	}
 
 	// Add the new node to the network
-	future := srv.Node.Consensus.AddVoter(raft.ServerID(req.NodeID), raft.ServerAddress(req.NodeConsensusAddr), 0, 0)
+	// The node may be named differently than its host, so the leader can reach it before it reads the new configuration.
+	consensus.RegisterNodeAddress(req.NodeID, req.NodeConsensusAddr)
+	future := s.Consensus.AddVoter(raft.ServerID(req.NodeID), raft.ServerAddress(req.NodeConsensusAddr), 0, 0)
 	if future.Error() != nil {
-		return &proto.Empty{}, future.Error()
+		return &proto.ConsensusJoinResponse{}, future.Error()
 	}
 
 	log.Println("[proto] (ConsensusJoin) request successful")
-	return &proto.Empty{}, nil
+	return joinResponse(req.NodeID, withToken), nil
+}
+
+// joinResponse returns the response to a join, with the node's key if it joined with a join token.
+func joinResponse(nodeID string, withToken bool) *proto.ConsensusJoinResponse {
+	if !withToken {
+		return &proto.ConsensusJoinResponse{}
+	}
+	return &proto.ConsensusJoinResponse{NodeKey: peerauth.NodeKey(nodeID)}
 }
 
 // ConsensusRemove removes a node from the Raft consensus network.
+//
+// Removing a node from shard 0 also removes it from the other shards.
+// If a cluster secret is configured, the caller must prove it knows it.
 func (srv *server) ConsensusRemove(ctx context.Context, req *proto.ConsensusRequest) (*proto.Empty, error) {
 	log.Println("[proto] (ConsensusRemove) request received, processing...")
 
+	errAuth := peerauth.Verify(ctx, req.NodeID, "")
+	if errAuth != nil {
+		log.Printf("[proto] (ConsensusRemove) refused removing node %s: %v\n", req.NodeID, errAuth)
+		return &proto.Empty{}, status.Error(codes.Unauthenticated, errAuth.Error())
+	}
+
+	s, errShard := srv.shardOf(ctx)
+	if errShard != nil {
+		return &proto.Empty{}, errShard
+	}
+
 	// Removes the node from the network
-	future := srv.Node.Consensus.RemoveServer(raft.ServerID(req.NodeID), 0, 0)
+	future := s.Consensus.RemoveServer(raft.ServerID(req.NodeID), 0, 0)
 	if future.Error() != nil {
 		return &proto.Empty{}, future.Error()
 	}
+	if s.ID == 0 {
+		srv.Node.RemoveFromShards(req.NodeID)
+	}
 
 	log.Println("[proto] (ConsensusRemove) request successful")
 	return &proto.Empty{}, nil
 }
+
+// shardOf returns the shard of this node an incoming call is for.
+func (srv *server) shardOf(ctx context.Context) (*consensus.Shard, error) {
+	id, errID := shard.FromContext(ctx)
+	if errID != nil {
+		return nil, status.Error(codes.InvalidArgument, errID.Error())
+	}
+	s, errShard := srv.Node.Shard(id)
+	if errShard != nil {
+		return nil, status.Error(codes.NotFound, errShard.Error())
+	}
+	return s, nil
+}
@@ -2,19 +2,32 @@
 package protoserver
 
 import (
+	"github.com/hashicorp/raft"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+	"log"
 	"net"
 	"nubedb/api/proto"
 	"nubedb/cluster/consensus"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/protocol"
+	"nubedb/cluster/valuecrypt"
+	"nubedb/internal/accesslog"
 	"nubedb/internal/app"
 	"nubedb/internal/config"
+	"nubedb/pkg/unixsock"
+	"time"
 )
 
 // server represents the gRPC server.
 type server struct {
 	proto.UnimplementedServiceServer
-	Config config.Config
-	Node   *consensus.Node
+	Config  config.Config
+	Node    *consensus.Node
+	Crypter *valuecrypt.Crypter
 }
 
 // Start starts the gRPC server.
@@ -26,12 +39,60 @@ func Start(a *app.App) error {
 
 	// Create the server model.
 	srvModel := &server{
-		Config: a.Config,
-		Node:   a.Node,
+		Config:  a.Config,
+		Node:    a.Node,
+		Crypter: a.Crypter,
 	}
 
-	protoServer := grpc.NewServer()
+	// Allow the keepalive pings the pooled client connections send.
+	// Calls from nodes with an incompatible protocol version are refused, and the errors carry their code.
+	// A sample of the calls is logged.
+	protoServer := grpc.NewServer(
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             5 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.ChainUnaryInterceptor(
+			errcode.UnaryServerInterceptor,
+			accesslog.UnaryServerInterceptor(srvModel.forwardsWrite),
+			protocol.UnaryServerInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			errcode.StreamServerInterceptor,
+			accesslog.StreamServerInterceptor(srvModel.forwardsWrite),
+			protocol.StreamServerInterceptor,
+		),
+	)
 	proto.RegisterServiceServer(protoServer, srvModel) // register the server model
 
+	// Register the standard health and reflection services, so load balancers and tools like grpcurl work.
+	healthSrv := health.NewServer()
+	healthpb.RegisterHealthServer(protoServer, healthSrv)
+	reflection.Register(protoServer)
+	go watchHealth(healthSrv, a.Node)
+
+	// Sidecars can use the unix socket, so they don't need network access to the node.
+	if a.Config.Grpc.UnixSocket != "" {
+		unixListen, errUnix := unixsock.Listen(a.Config.Grpc.UnixSocket)
+		if errUnix != nil {
+			return errUnix
+		}
+		go func() {
+			errServe := protoServer.Serve(unixListen)
+			if errServe != nil {
+				log.Println("[proto] unix socket listener stopped:", errServe)
+			}
+		}()
+	}
+
 	return protoServer.Serve(listen)
 }
+
+// forwardsWrite returns whether a call of a gRPC method is a write which is sent to the leader of its key,
+// since this node isn't the leader.
+func (srv *server) forwardsWrite(method string, key string) bool {
+	if method != "Write" && method != "PutStream" {
+		return false
+	}
+	return srv.Node.ShardFor(key).Consensus.State() != raft.Leader
+}
@@ -0,0 +1,26 @@
+package protoserver
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"nubedb/api/proto"
+	"nubedb/cluster/consensus"
+)
+
+// ReadKey reads a key from the node's local storage, it's used by the quorum reads to compare the replicas.
+func (srv *server) ReadKey(ctx context.Context, req *proto.ReadKeyRequest) (*proto.ReadKeyResponse, error) {
+	if srv.Node.IsWitness() {
+		return nil, consensus.ErrWitnessRead
+	}
+	s, errShard := srv.shardOf(ctx)
+	if errShard != nil {
+		return nil, errShard
+	}
+
+	read, errRead := s.ReadLocal(req.Key)
+	if errRead != nil {
+		return nil, status.Error(codes.Internal, errRead.Error())
+	}
+	return &proto.ReadKeyResponse{Found: read.Found, Value: read.Value, AppliedIndex: read.AppliedIndex}, nil
+}
@@ -0,0 +1,85 @@
+package protoserver
+
+import (
+	"encoding/json"
+	"github.com/narvikd/errorskit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"nubedb/api/proto"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/peerauth"
+)
+
+// Replicate receives the changes of another cluster and applies them on this one.
+//
+// Every batch received is acknowledged with the index of its last change, once all of them are applied.
+//
+// If a cluster secret is configured, the source must prove it knows it for its source ID when the stream is opened,
+// and can't send the changes of another source through the same stream.
+func (srv *server) Replicate(stream proto.Service_ReplicateServer) error {
+	const operationType = "REPLICATE"
+	if srv.Node.InMaintenance() {
+		return consensus.ErrMaintenance
+	}
+	if srv.Node.IsReadOnly() {
+		return consensus.ErrReadOnly
+	}
+	log.Println("[proto] (Replicate) stream opened, receiving changes...")
+
+	var sourceID string
+	for {
+		req, errRecv := stream.Recv()
+		if errRecv == io.EOF {
+			log.Println("[proto] (Replicate) stream closed by the source")
+			return nil
+		}
+		if errRecv != nil {
+			return errRecv
+		}
+		// The proof is only checked once, since it expires while the stream stays open.
+		if sourceID == "" {
+			errAuth := peerauth.Verify(stream.Context(), req.SourceID, "")
+			if errAuth != nil {
+				log.Printf("[proto] (Replicate) refused the changes of source '%s': %v\n", req.SourceID, errAuth)
+				return errAuth
+			}
+			sourceID = req.SourceID
+		}
+		if req.SourceID != sourceID {
+			return peerauth.ErrUnauthorized
+		}
+
+		var lastIndex uint64
+		for _, b := range req.Changes {
+			change := fsm.ChangeEvent{}
+			errUnmarshal := json.Unmarshal(b, &change)
+			if errUnmarshal != nil {
+				return errorskit.Wrap(errUnmarshal, "couldn't unmarshal replicated change")
+			}
+
+			// The keys of a replicated backup would be spread across the shards, they can't be applied to one.
+			if change.Operation == "RESTOREDB" && srv.Node.IsSharded() {
+				return status.Error(codes.FailedPrecondition, "replicated backups can't be applied when sharding is enabled")
+			}
+
+			errExecute := cluster.Execute(srv.Node.ShardFor(change.Key).Consensus, &fsm.Payload{
+				Key:       req.SourceID,
+				Value:     change,
+				Operation: operationType,
+			})
+			if errExecute != nil {
+				return errorskit.Wrap(errExecute, "couldn't apply replicated change")
+			}
+			lastIndex = change.Index
+		}
+
+		errSend := stream.Send(&proto.ReplicateResponse{LastIndex: lastIndex})
+		if errSend != nil {
+			return errSend
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package protoserver
+
+import (
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"nubedb/api/proto"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/valuecrypt"
+)
+
+// streamMessageSize is the maximum size in bytes of the data of each message sent by GetStream.
+const streamMessageSize = 256 * 1024
+
+// PutStream sets a key to the value streamed by the client, applying its chunks as they're received,
+// so large values aren't held in memory.
+func (srv *server) PutStream(stream proto.Service_PutStreamServer) error {
+	first, errRecv := stream.Recv()
+	if errRecv != nil {
+		return errRecv
+	}
+	if first.Key == "" || first.ContentType == "" {
+		return status.Error(codes.InvalidArgument, "the key and the content type of the value are required")
+	}
+	if errKey := fsm.CheckUserKey(first.Key); errKey != nil {
+		return errKey
+	}
+	if srv.Node.InMaintenance() {
+		return consensus.ErrMaintenance
+	}
+	if srv.Node.IsReadOnly() {
+		return consensus.ErrReadOnly
+	}
+
+	s := srv.Node.ShardFor(first.Key)
+	if srv.Crypter.IsEncrypted(s.FSM, first.Key) {
+		return valuecrypt.ErrStreamEncrypted
+	}
+
+	r := &putStreamReader{stream: stream, data: first.Data}
+	index, errExecute := cluster.ExecuteStream(s.Consensus, first.Key, first.ContentType, r)
+	if errExecute != nil {
+		return errExecute
+	}
+	return stream.SendAndClose(&proto.PutStreamResponse{Index: index})
+}
+
+// GetStream streams the value of a key in its content type, reading its chunks as they're sent.
+func (srv *server) GetStream(req *proto.GetStreamRequest, stream proto.Service_GetStreamServer) error {
+	if srv.Node.IsWitness() {
+		return consensus.ErrWitnessRead
+	}
+	s := srv.Node.ShardFor(req.Key)
+	if srv.Crypter.IsEncrypted(s.FSM, req.Key) {
+		return valuecrypt.ErrStreamEncrypted
+	}
+
+	r, contentType, errOpen := s.FSM.OpenValue(req.Key)
+	if errOpen != nil {
+		if errors.Is(errOpen, engine.ErrKeyNotFound) {
+			return status.Error(codes.NotFound, "key doesn't exist")
+		}
+		return status.Error(codes.Internal, errOpen.Error())
+	}
+	if contentType == "" {
+		contentType = fsm.ContentTypeJSON
+	}
+
+	res := &proto.GetStreamResponse{ContentType: contentType}
+	buf := make([]byte, streamMessageSize)
+	for {
+		n, errRead := io.ReadFull(r, buf)
+		if n > 0 || res.ContentType != "" {
+			res.Data = buf[:n]
+			errSend := stream.Send(res)
+			if errSend != nil {
+				return errSend
+			}
+			res = &proto.GetStreamResponse{}
+		}
+		if errors.Is(errRead, io.EOF) || errors.Is(errRead, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if errRead != nil {
+			return status.Error(codes.Internal, errRead.Error())
+		}
+	}
+}
+
+// putStreamReader reads the data of the messages received by PutStream.
+type putStreamReader struct {
+	stream proto.Service_PutStreamServer
+	data   []byte
+}
+
+func (r *putStreamReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		req, errRecv := r.stream.Recv()
+		if errRecv != nil {
+			return 0, errRecv
+		}
+		r.data = req.Data
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
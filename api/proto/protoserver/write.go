@@ -0,0 +1,184 @@
+package protoserver
+
+import (
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"hash/fnv"
+	"io"
+	"nubedb/api/proto"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/settings"
+	"nubedb/cluster/valuecrypt"
+	"sync"
+	"time"
+)
+
+// writeQueueSize is the number of writes of a Write stream each worker buffers, the stream stops being read when it's full.
+const writeQueueSize = 64
+
+// Write applies the writes streamed by the client, acknowledging each one asynchronously with the index it was committed at,
+// so ingestion jobs don't pay a round trip per key.
+//
+// Up to WriteStreamConcurrency writes are applied at once. The writes of a key are applied in the order they were
+// received, but the acknowledgements of different keys can be sent in any order.
+// Once the client closes its side, the stream is closed after acknowledging all the writes received.
+func (srv *server) Write(stream proto.Service_WriteServer) error {
+	workers := srv.Config.Grpc.WriteStreamConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		sendMu  sync.Mutex
+		errSend = make(chan error, 1)
+		queues  = make([]chan *proto.WriteRequest, workers)
+	)
+	for i := range queues {
+		queues[i] = make(chan *proto.WriteRequest, writeQueueSize)
+		wg.Add(1)
+		go func(queue chan *proto.WriteRequest) {
+			defer wg.Done()
+			for req := range queue {
+				// The writes still queued when the client goes away aren't applied.
+				if stream.Context().Err() != nil {
+					continue
+				}
+				res := srv.applyWrite(req)
+				sendMu.Lock()
+				err := stream.Send(res)
+				sendMu.Unlock()
+				if err != nil {
+					select {
+					case errSend <- err:
+					default:
+					}
+				}
+			}
+		}(queues[i])
+	}
+	stop := func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}
+
+	for {
+		req, errRecv := stream.Recv()
+		if errors.Is(errRecv, io.EOF) {
+			stop()
+			return nil
+		}
+		if errRecv != nil {
+			stop()
+			return errRecv
+		}
+		select {
+		case err := <-errSend:
+			stop()
+			return err
+		default:
+		}
+		queues[writeWorker(req.Key, workers)] <- req
+	}
+}
+
+// writeWorker returns the worker which applies the writes of a key, so they are applied in order.
+func writeWorker(key string, workers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// applyWrite applies a write received by Write, returning its acknowledgement.
+//
+// The writes handed off are acknowledged as applied, with index 0.
+func (srv *server) applyWrite(req *proto.WriteRequest) *proto.WriteResponse {
+	res := &proto.WriteResponse{Id: req.Id}
+	index, errWrite := srv.write(req)
+	if errWrite != nil {
+		st := errcode.Status(errWrite)
+		res.Code = uint32(st.Code())
+		res.Error = st.Message()
+		res.ErrorCode = string(errcode.Of(errWrite))
+		res.Retryable = errcode.Retryable(errcode.Of(errWrite))
+		return res
+	}
+	res.Index = index
+	return res
+}
+
+func (srv *server) write(req *proto.WriteRequest) (uint64, error) {
+	if req.Key == "" {
+		return 0, status.Error(codes.InvalidArgument, "the key is required")
+	}
+	if errKey := fsm.CheckUserKey(req.Key); errKey != nil {
+		return 0, errKey
+	}
+	if srv.Node.InMaintenance() {
+		return 0, consensus.ErrMaintenance
+	}
+	if srv.Node.IsReadOnly() {
+		return 0, consensus.ErrReadOnly
+	}
+	errLimit := settings.CheckWrite(len(req.Value))
+	if errLimit != nil {
+		return 0, errLimit
+	}
+
+	s := srv.Node.ShardFor(req.Key)
+	payload := &fsm.Payload{Key: req.Key, Operation: req.Operation}
+	switch req.Operation {
+	case "", "SET":
+		payload.Operation = "SET"
+		value, errEncode := fsm.EncodeValue(req.ContentType, req.Value)
+		if errEncode != nil {
+			return 0, status.Error(codes.InvalidArgument, errEncode.Error())
+		}
+		payload.ContentType = req.ContentType
+		// The value is checked before it's encrypted, since the nodes can't check it afterwards.
+		errSchema := s.FSM.ValidateSchema(payload.Key, payload.ContentType, value)
+		if errSchema != nil {
+			return 0, errSchema
+		}
+		encrypted, errEncrypt := srv.Crypter.Encrypt(s.FSM, payload.Key, value)
+		if errEncrypt != nil {
+			return 0, errEncrypt
+		}
+		payload.Value = encrypted
+	case "APPEND":
+		if req.ContentType != "" {
+			return 0, status.Error(codes.InvalidArgument, "only JSON values can be appended")
+		}
+		if srv.Crypter.IsEncrypted(s.FSM, payload.Key) {
+			return 0, valuecrypt.ErrAppendEncrypted
+		}
+		value, errEncode := fsm.EncodeValue("", req.Value)
+		if errEncode != nil {
+			return 0, status.Error(codes.InvalidArgument, errEncode.Error())
+		}
+		payload.Value = value
+	case "DELETE":
+		// In soft delete mode the value is retained, the time of the deletion is sent so every node retains it equally.
+		if srv.Config.SoftDelete.Retention > 0 {
+			payload.Operation = "SOFTDELETE"
+			payload.Value = time.Now().UTC().Format(time.RFC3339Nano)
+		}
+	default:
+		return 0, status.Error(codes.InvalidArgument, "operation not recognized: "+req.Operation)
+	}
+
+	index, errCluster := cluster.ExecuteIndex(s.Consensus, payload)
+	if errors.Is(errCluster, cluster.ErrHandedOff) {
+		return 0, nil
+	}
+	if errCluster != nil {
+		return 0, errCluster
+	}
+	return index, nil
+}
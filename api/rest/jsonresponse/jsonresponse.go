@@ -1,6 +1,22 @@
 package jsonresponse
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"github.com/gofiber/fiber/v2"
+	"math"
+	"nubedb/cluster/errcode"
+	"strconv"
+	"time"
+)
+
+// ErrorBody is the body of the error responses, clients should branch on its code instead of its message.
+type ErrorBody struct {
+	Message string       `json:"message"`
+	Code    errcode.Code `json:"code"`
+	// Retryable is whether the request can be retried as is, later or on another node.
+	Retryable bool `json:"retryable"`
+	// Leader is the id of the leader, when the request should be sent to it.
+	Leader string `json:"leader,omitempty"`
+}
 
 // OK returns a successful response with status code 200
 func OK(ctx *fiber.Ctx, message string, data any) error {
@@ -10,23 +26,78 @@ func OK(ctx *fiber.Ctx, message string, data any) error {
 	})
 }
 
-// NotFound returns a not found response with status code 404
-func NotFound(ctx *fiber.Ctx, message string) error {
-	return ctx.Status(404).JSON(&fiber.Map{
+// Page returns a page of a paginated list with status code 200, and the token of the next page if it isn't the last one
+func Page(ctx *fiber.Ctx, message string, data any, nextPageToken string) error {
+	res := fiber.Map{
+		"message": message,
+		"data":    data,
+	}
+	if nextPageToken != "" {
+		res["nextPageToken"] = nextPageToken
+	}
+	return ctx.Status(200).JSON(&res)
+}
+
+// Accepted returns a response with status code 202, for requests which will be processed later
+func Accepted(ctx *fiber.Ctx, message string) error {
+	return ctx.Status(202).JSON(&fiber.Map{
 		"message": message,
 	})
 }
 
+// Forbidden returns a forbidden response with status code 403
+func Forbidden(ctx *fiber.Ctx, message string) error {
+	return Error(ctx, errcode.PermissionDenied, message, "")
+}
+
+// NotFound returns a not found response with status code 404
+func NotFound(ctx *fiber.Ctx, message string) error {
+	return Error(ctx, errcode.NotFound, message, "")
+}
+
+// Conflict returns a conflict response with status code 409
+func Conflict(ctx *fiber.Ctx, message string) error {
+	return Error(ctx, errcode.Conflict, message, "")
+}
+
 // BadRequest returns a bad request response with status code http status 400
 func BadRequest(ctx *fiber.Ctx, message string) error {
-	return ctx.Status(400).JSON(&fiber.Map{
-		"message": message,
-	})
+	return Error(ctx, errcode.InvalidArgument, message, "")
 }
 
 // ServerError returns a server error response with status code 500
 func ServerError(ctx *fiber.Ctx, message string) error {
-	return ctx.Status(500).JSON(&fiber.Map{
-		"message": message,
+	return Error(ctx, errcode.Internal, message, "")
+}
+
+// ServiceUnavailable returns a service unavailable response with status code 503,
+// telling the client how many seconds it should wait before retrying with the Retry-After header.
+func ServiceUnavailable(ctx *fiber.Ctx, message string, retryAfter time.Duration) error {
+	SetRetryAfter(ctx, retryAfter)
+	return Error(ctx, errcode.Unavailable, message, "")
+}
+
+// SetRetryAfter tells the client how many seconds it should wait before retrying with the Retry-After header,
+// at least 1.
+func SetRetryAfter(ctx *fiber.Ctx, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+}
+
+// GatewayTimeout returns a response with status code 504, for requests which didn't complete within their deadline
+func GatewayTimeout(ctx *fiber.Ctx, message string) error {
+	return Error(ctx, errcode.DeadlineExceeded, message, "")
+}
+
+// Error returns an error response with the status code of an error code, and the leader's id if it isn't empty
+func Error(ctx *fiber.Ctx, code errcode.Code, message string, leader string) error {
+	return ctx.Status(errcode.HTTPStatus(code)).JSON(&ErrorBody{
+		Message:   message,
+		Code:      code,
+		Retryable: errcode.Retryable(code),
+		Leader:    leader,
 	})
 }
@@ -0,0 +1,83 @@
+// Package rest is responsible for serving the REST API.
+package rest
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/errorskit"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"log"
+	"net"
+	"nubedb/internal/config"
+	"nubedb/pkg/unixsock"
+	"strings"
+)
+
+// Listen serves the REST API on addr, over HTTPS if TLS is configured, blocks until the server stops.
+//
+// TLS can use a certificate and key files, or certificates obtained automatically from Let's Encrypt.
+//
+// If a unix socket is configured, the API is also served on it, without TLS since it's only reachable locally.
+func Listen(app *fiber.App, addr string, cfg config.RestCfg) error {
+	if cfg.UnixSocket != "" {
+		unixLn, errUnix := unixsock.Listen(cfg.UnixSocket)
+		if errUnix != nil {
+			return errUnix
+		}
+		log.Printf("[api] serving on unix socket %s\n", cfg.UnixSocket)
+		go func() {
+			errServe := app.Listener(unixLn)
+			if errServe != nil {
+				log.Println("[api] unix socket listener stopped:", errServe)
+			}
+		}()
+	}
+
+	tlsCfg, errTLS := newTLSConfig(cfg)
+	if errTLS != nil {
+		return errTLS
+	}
+	if tlsCfg == nil {
+		return app.Listen(addr)
+	}
+
+	ln, errListen := net.Listen("tcp", addr)
+	if errListen != nil {
+		return errorskit.Wrap(errListen, "couldn't listen")
+	}
+	log.Printf("[api] serving HTTPS on %s\n", addr)
+	return app.Listener(tls.NewListener(ln, tlsCfg))
+}
+
+// newTLSConfig returns the TLS configuration of the server, or nil if TLS isn't configured.
+func newTLSConfig(cfg config.RestCfg) (*tls.Config, error) {
+	switch {
+	case cfg.TLSCertFile != "" || cfg.TLSKeyFile != "":
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, errors.New("both the TLS certificate and key files are required")
+		}
+		cert, errCert := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if errCert != nil {
+			return nil, errorskit.Wrap(errCert, "couldn't load TLS certificate")
+		}
+		return &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}, nil
+
+	case cfg.AutocertDomains != "":
+		// The certificates are validated with the TLS-ALPN-01 challenge, so the server must be reachable on port 443.
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(cfg.AutocertDomains, ",")...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		tlsCfg := m.TLSConfig()
+		tlsCfg.MinVersion = tls.VersionTLS12
+		tlsCfg.NextProtos = []string{"http/1.1", acme.ALPNProto}
+		return tlsCfg, nil
+
+	default:
+		return nil, nil
+	}
+}
@@ -4,19 +4,29 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"io"
+	"nubedb/internal/config"
 )
 
+// streamedRoutes are the routes which read their request's body as a stream, so they aren't limited by the body limit.
+var streamedRoutes = map[string]bool{
+	"/store/stream": true,
+}
+
 // InitMiddlewares initializes/registers all the app middlewares.
-func InitMiddlewares(app *fiber.App) {
-	initCorsMW(app)
+func InitMiddlewares(app *fiber.App, cfg config.RestCfg) {
+	initCorsMW(app, cfg)
 	initRecoverMW(app)
+	initBodyLimitMW(app)
 }
 
-// initCorsMW is set to allow all.
-func initCorsMW(app *fiber.App) {
+// initCorsMW initializes the CORS MW, by default it's set to allow all.
+func initCorsMW(app *fiber.App, cfg config.RestCfg) {
 	app.Use(
 		cors.New(cors.Config{
-			AllowCredentials: true,
+			AllowOrigins:     cfg.CORSAllowOrigins,
+			AllowHeaders:     cfg.CORSAllowHeaders,
+			AllowCredentials: cfg.CORSAllowCredentials,
 		}),
 	)
 }
@@ -28,3 +38,28 @@ func initCorsMW(app *fiber.App) {
 func initRecoverMW(app *fiber.App) {
 	app.Use(recover.New())
 }
+
+// initBodyLimitMW initializes the body limit MW.
+//
+// Since the request body streaming is enabled, fasthttp streams the bodies bigger than the body limit
+// instead of rejecting them, so they're rejected here, but for the streamed routes.
+func initBodyLimitMW(app *fiber.App) {
+	app.Use(func(ctx *fiber.Ctx) error {
+		stream := ctx.Context().RequestBodyStream()
+		if stream == nil || streamedRoutes[ctx.Path()] {
+			return ctx.Next()
+		}
+
+		limit := ctx.App().Config().BodyLimit
+		body, errRead := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+		if errRead != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "couldn't read the request body")
+		}
+		if len(body) > limit {
+			ctx.Context().SetConnectionClose()
+			return fiber.ErrRequestEntityTooLarge
+		}
+		ctx.Request().SetBody(body)
+		return ctx.Next()
+	})
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/hashicorp/raft"
+	"net/http"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/accesslog"
+	"time"
+)
+
+// accessLog logs a sample of the requests once they are served, with the route instead of the path,
+// so the names in the path aren't logged either.
+func (a *ApiCtx) accessLog(fiberCtx *fiber.Ctx) error {
+	start := time.Now()
+	errNext := fiberCtx.Next()
+
+	status := fiberCtx.Response().StatusCode()
+	if errNext != nil {
+		// The error handler sets the response once the middlewares returned.
+		status = http.StatusInternalServerError
+		var fiberErr *fiber.Error
+		if errors.As(errNext, &fiberErr) {
+			status = fiberErr.Code
+		}
+	}
+	if !accesslog.Sampled(status >= http.StatusBadRequest) {
+		return errNext
+	}
+
+	method := fiberCtx.Method()
+	key := requestKey(fiberCtx)
+	isWrite := method != http.MethodGet && method != http.MethodHead
+	accesslog.Log(accesslog.Entry{
+		Protocol:  "rest",
+		Method:    method + " " + fiberCtx.Route().Path,
+		Key:       key,
+		Duration:  time.Since(start),
+		Status:    status,
+		Code:      responseCode(fiberCtx, status, errNext),
+		Forwarded: isWrite && a.Node.ShardFor(key).Consensus.State() != raft.Leader,
+	})
+	return errNext
+}
+
+// requestKey returns the key of a request, from its query or its JSON body.
+func requestKey(fiberCtx *fiber.Ctx) string {
+	if key := fiberCtx.Query("key"); key != "" {
+		return key
+	}
+	// The streamed bodies were already read by the handler.
+	if fiberCtx.Request().IsBodyStream() || len(fiberCtx.Body()) == 0 {
+		return ""
+	}
+	var body struct {
+		Key string `json:"key"`
+	}
+	_ = json.Unmarshal(fiberCtx.Body(), &body)
+	return body.Key
+}
+
+// responseCode returns the error code of a failed response, or OK.
+func responseCode(fiberCtx *fiber.Ctx, status int, errNext error) string {
+	if errNext != nil {
+		return string(errcode.Of(errNext))
+	}
+	if status < http.StatusBadRequest {
+		return "OK"
+	}
+	var body jsonresponse.ErrorBody
+	errDecode := json.Unmarshal(fiberCtx.Response().Body(), &body)
+	if errDecode != nil || body.Code == "" {
+		return "-"
+	}
+	return string(body.Code)
+}
@@ -0,0 +1,83 @@
+package route
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus"
+	"nubedb/internal/metrics"
+)
+
+func (a *ApiCtx) raftConfiguration(fiberCtx *fiber.Ctx) error {
+	peers, err := a.Node.Peers()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "consensus configuration retrieved successfully", peers)
+}
+
+// raftRecover receives a peers.json document, and restarts the node to recover the consensus with it.
+func (a *ApiCtx) raftRecover(fiberCtx *fiber.Ctx) error {
+	var peers []consensus.Peer
+	errUnmarshal := json.Unmarshal(fiberCtx.Body(), &peers)
+	if errUnmarshal != nil {
+		return jsonresponse.BadRequest(fiberCtx, "couldn't parse consensus configuration: "+errUnmarshal.Error())
+	}
+
+	errSchedule := a.Node.ScheduleRecovery(peers)
+	if errSchedule != nil {
+		return jsonresponse.BadRequest(fiberCtx, errSchedule.Error())
+	}
+
+	// The node is restarted after the response is sent.
+	defer func() {
+		go a.Node.RestartToRecover()
+	}()
+	return jsonresponse.OK(fiberCtx, "consensus configuration saved, the node is restarting to recover it", peers)
+}
+
+func (a *ApiCtx) hotKeys(fiberCtx *fiber.Ctx) error {
+	const defaultLimit = 10
+	limit := fiberCtx.QueryInt("limit", defaultLimit)
+	return jsonresponse.OK(fiberCtx, "hot keys retrieved successfully", metrics.GetHotKeys(limit))
+}
+
+func (a *ApiCtx) storageStats(fiberCtx *fiber.Ctx) error {
+	stats, err := a.Node.StorageStats()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "storage stats retrieved successfully", stats)
+}
+
+// maintenanceEnter puts the node in maintenance mode, the leadership is transferred if transferLeadership=true.
+func (a *ApiCtx) maintenanceEnter(fiberCtx *fiber.Ctx) error {
+	transferLeadership := fiberCtx.Query("transferLeadership") == "true"
+	errMaintenance := a.Node.EnterMaintenance(transferLeadership)
+	if errMaintenance != nil {
+		return jsonresponse.ServerError(fiberCtx, errMaintenance.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "node is in maintenance mode", "")
+}
+
+func (a *ApiCtx) maintenanceExit(fiberCtx *fiber.Ctx) error {
+	a.Node.ExitMaintenance()
+	return jsonresponse.OK(fiberCtx, "node resumed accepting traffic", "")
+}
+
+// reload reloads the configuration, applying the settings which can be changed at runtime.
+func (a *ApiCtx) reload(fiberCtx *fiber.Ctx) error {
+	errReload := a.Reload()
+	if errReload != nil {
+		return jsonresponse.BadRequest(fiberCtx, errReload.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "configuration reloaded successfully", "")
+}
+
+// maintenanceGuard refuses client traffic while the node is in maintenance mode.
+func (a *ApiCtx) maintenanceGuard(fiberCtx *fiber.Ctx) error {
+	if a.Node.InMaintenance() {
+		return a.clusterError(fiberCtx, consensus.ErrMaintenance)
+	}
+	return fiberCtx.Next()
+}
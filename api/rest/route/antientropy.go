@@ -0,0 +1,44 @@
+package route
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/antientropy"
+	"nubedb/cluster/consensus/fsm"
+)
+
+func (a *ApiCtx) antiEntropyReports(fiberCtx *fiber.Ctx) error {
+	reports := antientropy.Reports()
+	if len(reports) <= 0 {
+		return jsonresponse.NotFound(fiberCtx, "no shard was checked by this node yet")
+	}
+	return jsonresponse.OK(fiberCtx, "reports retrieved successfully", reports)
+}
+
+func (a *ApiCtx) antiEntropyVerify(fiberCtx *fiber.Ctx) error {
+	reports, err := antientropy.Verify(a.Node, a.Config.AntiEntropy)
+	if err != nil {
+		return a.clusterError(fiberCtx, err)
+	}
+	return jsonresponse.OK(fiberCtx, "replicas verified successfully", reports)
+}
+
+// antiEntropyDigest returns a digest computed by this node, it's polled by the leader verifying the replicas.
+func (a *ApiCtx) antiEntropyDigest(fiberCtx *fiber.Ctx) error {
+	if a.Node.IsWitness() {
+		return jsonresponse.OK(fiberCtx, "this node is a witness, it doesn't store data", fiber.Map{"witness": true})
+	}
+	s, errShard := a.Node.Shard(fiberCtx.QueryInt("shard", 0))
+	if errShard != nil {
+		return jsonresponse.BadRequest(fiberCtx, errShard.Error())
+	}
+	d, errDigest := s.FSM.GetDigest(fiberCtx.Params("id"))
+	if errDigest != nil {
+		if errors.Is(errDigest, fsm.ErrDigestNotFound) {
+			return jsonresponse.NotFound(fiberCtx, errDigest.Error())
+		}
+		return jsonresponse.ServerError(fiberCtx, errDigest.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "digest retrieved successfully", d)
+}
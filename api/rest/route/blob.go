@@ -0,0 +1,40 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+)
+
+// blobEnable puts a bucket in blob mode, so its keys with the same value share a single copy of it.
+func (a *ApiCtx) blobEnable(fiberCtx *fiber.Ctx) error {
+	const operationType = "ENABLEBLOBS"
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "blob mode enabled successfully", "")
+}
+
+func (a *ApiCtx) blobDisable(fiberCtx *fiber.Ctx) error {
+	const operationType = "DISABLEBLOBS"
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "blob mode disabled successfully", "")
+}
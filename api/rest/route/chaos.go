@@ -0,0 +1,57 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/chaos"
+	"time"
+)
+
+// chaosFaults are the faults of the node, with the delay as a duration string (ex: 200ms).
+type chaosFaults struct {
+	ApplyDelay      string  `json:"applyDelay"`
+	ForwardDropRate float64 `json:"forwardDropRate"`
+	FSMPaused       bool    `json:"fsmPaused"`
+}
+
+func newChaosFaults(f chaos.Faults) chaosFaults {
+	return chaosFaults{
+		ApplyDelay:      f.ApplyDelay.String(),
+		ForwardDropRate: f.ForwardDropRate,
+		FSMPaused:       f.FSMPaused,
+	}
+}
+
+func (a *ApiCtx) chaosGet(fiberCtx *fiber.Ctx) error {
+	return jsonresponse.OK(fiberCtx, "chaos faults retrieved successfully", newChaosFaults(chaos.Get()))
+}
+
+// chaosSet replaces the faults injected into the node.
+func (a *ApiCtx) chaosSet(fiberCtx *fiber.Ctx) error {
+	req := new(chaosFaults)
+	errParse := fiberCtx.BodyParser(req)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+
+	var delay time.Duration
+	if req.ApplyDelay != "" {
+		d, errDelay := time.ParseDuration(req.ApplyDelay)
+		if errDelay != nil {
+			return jsonresponse.BadRequest(fiberCtx, "couldn't parse applyDelay: "+errDelay.Error())
+		}
+		delay = d
+	}
+
+	f := chaos.Faults{ApplyDelay: delay, ForwardDropRate: req.ForwardDropRate, FSMPaused: req.FSMPaused}
+	errSet := chaos.Set(f)
+	if errSet != nil {
+		return jsonresponse.BadRequest(fiberCtx, errSet.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "chaos faults set", newChaosFaults(f))
+}
+
+func (a *ApiCtx) chaosReset(fiberCtx *fiber.Ctx) error {
+	chaos.Reset()
+	return jsonresponse.OK(fiberCtx, "chaos faults removed", "")
+}
@@ -0,0 +1,173 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/protocol"
+	"time"
+)
+
+// nodeStatus is the status of the node within the cluster.
+type nodeStatus struct {
+	NodeID   string             `json:"nodeID"`
+	State    string             `json:"state"`
+	LeaderID string             `json:"leaderID"`
+	Apply    consensus.ApplyLag `json:"apply"`
+	// ProtocolVersion is the protocol version the node speaks, it's used to follow rolling upgrades.
+	ProtocolVersion int  `json:"protocolVersion"`
+	Witness         bool `json:"witness"`
+	// LastContact is the time since the node last heard from the leader, as reported by raft.
+	LastContact string `json:"lastContact"`
+}
+
+func (a *ApiCtx) clusterStatus(fiberCtx *fiber.Ctx) error {
+	_, leaderID := a.Node.Consensus.LeaderWithID()
+	status := nodeStatus{
+		NodeID:          a.Config.CurrentNode.ID,
+		State:           a.Node.Consensus.State().String(),
+		LeaderID:        string(leaderID),
+		Apply:           a.Node.ApplyLag(),
+		ProtocolVersion: protocol.Version,
+		Witness:         a.Node.IsWitness(),
+		LastContact:     a.Node.Consensus.Stats()["last_contact"],
+	}
+	return jsonresponse.OK(fiberCtx, "cluster status retrieved successfully", status)
+}
+
+// verifyLeader checks that the node is still the leader of a shard by contacting a quorum,
+// giving up after timeout (ex: 2s). It's answered with a 503 if the leadership couldn't be verified.
+func (a *ApiCtx) verifyLeader(fiberCtx *fiber.Ctx) error {
+	const defaultTimeout = 5 * time.Second
+	timeout := defaultTimeout
+	if raw := fiberCtx.Query("timeout"); raw != "" {
+		d, errParse := time.ParseDuration(raw)
+		if errParse != nil {
+			return jsonresponse.BadRequest(fiberCtx, "couldn't parse timeout: "+errParse.Error())
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(fiberCtx.UserContext(), timeout)
+	defer cancel()
+	v, err := a.Node.VerifyLeader(ctx, fiberCtx.QueryInt("shard", 0))
+	if err != nil {
+		if errors.Is(err, consensus.ErrShardNotFound) {
+			return jsonresponse.BadRequest(fiberCtx, err.Error())
+		}
+		return a.clusterError(fiberCtx, err)
+	}
+	if !v.Verified {
+		msg := fmt.Sprintf("couldn't verify the leadership after %s: %s", v.Latency, v.Error)
+		return jsonresponse.ServiceUnavailable(fiberCtx, msg, 1*time.Second)
+	}
+	return jsonresponse.OK(fiberCtx, "leadership verified", v)
+}
+
+func (a *ApiCtx) clusterTopology(fiberCtx *fiber.Ctx) error {
+	topology, err := a.Node.Topology()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "cluster topology retrieved successfully", topology)
+}
+
+// readGuard refuses reads while the node's FSM is further behind the commit index than the configured maximum,
+// so clients don't read stale data from a lagging node.
+//
+// Reads with X-Min-Index wait until the node applied the indexes of the client's writes.
+//
+// Witness nodes refuse all the reads, since they don't store data.
+func (a *ApiCtx) readGuard(fiberCtx *fiber.Ctx) error {
+	if a.Node.IsWitness() {
+		return jsonresponse.ServiceUnavailable(fiberCtx, "witness nodes don't store data, read from another node", 0)
+	}
+
+	if raw := fiberCtx.Get(headerMinIndex); raw != "" {
+		errWait := a.waitMinIndex(fiberCtx.UserContext(), raw)
+		if errors.Is(errWait, errInvalidMinIndex) {
+			return jsonresponse.BadRequest(fiberCtx, errWait.Error())
+		}
+		if errors.Is(errWait, context.DeadlineExceeded) {
+			return jsonresponse.GatewayTimeout(fiberCtx, errWait.Error())
+		}
+		if errWait != nil {
+			return jsonresponse.ServiceUnavailable(fiberCtx, errWait.Error(), 1*time.Second)
+		}
+	}
+
+	maxLag := a.Config.Reads.MaxApplyLag
+	if maxLag == 0 {
+		return fiberCtx.Next()
+	}
+
+	lag := a.Node.ApplyLag().Lag
+	if lag > maxLag {
+		msg := fmt.Sprintf("node is %v logs behind, which exceeds the maximum of %v", lag, maxLag)
+		return jsonresponse.ServiceUnavailable(fiberCtx, msg, 1*time.Second)
+	}
+	return fiberCtx.Next()
+}
+
+// decommission starts removing a node from the cluster, the settle timeout can be set with timeout (ex: 30s).
+func (a *ApiCtx) decommission(fiberCtx *fiber.Ctx) error {
+	const defaultSettleTimeout = 1 * time.Minute
+	settleTimeout := defaultSettleTimeout
+	if raw := fiberCtx.Query("timeout"); raw != "" {
+		d, errParse := time.ParseDuration(raw)
+		if errParse != nil {
+			return jsonresponse.BadRequest(fiberCtx, "couldn't parse timeout: "+errParse.Error())
+		}
+		settleTimeout = d
+	}
+
+	progress, err := a.Node.Decommission(fiberCtx.Params("id"), settleTimeout)
+	if err != nil {
+		if errors.Is(err, consensus.ErrNotLeader) {
+			return a.clusterError(fiberCtx, err)
+		}
+		return jsonresponse.BadRequest(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "decommission started", progress)
+}
+
+func (a *ApiCtx) decommissionProgress(fiberCtx *fiber.Ctx) error {
+	progress, err := a.Node.GetDecommission(fiberCtx.Params("id"))
+	if err != nil {
+		return jsonresponse.NotFound(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "decommission progress retrieved successfully", progress)
+}
+
+// replace starts replacing a node by the one in new, the catch-up timeout can be set with timeout (ex: 5m).
+func (a *ApiCtx) replace(fiberCtx *fiber.Ctx) error {
+	const defaultCatchUpTimeout = 5 * time.Minute
+	catchUpTimeout := defaultCatchUpTimeout
+	if raw := fiberCtx.Query("timeout"); raw != "" {
+		d, errParse := time.ParseDuration(raw)
+		if errParse != nil {
+			return jsonresponse.BadRequest(fiberCtx, "couldn't parse timeout: "+errParse.Error())
+		}
+		catchUpTimeout = d
+	}
+
+	progress, err := a.Node.Replace(fiberCtx.Params("id"), fiberCtx.Query("new"), catchUpTimeout)
+	if err != nil {
+		if errors.Is(err, consensus.ErrNotLeader) || errors.Is(err, consensus.ErrReplaceInProgress) {
+			return a.clusterError(fiberCtx, err)
+		}
+		return jsonresponse.BadRequest(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "replacement started", progress)
+}
+
+func (a *ApiCtx) replaceProgress(fiberCtx *fiber.Ctx) error {
+	progress, err := a.Node.GetReplace(fiberCtx.Params("id"))
+	if err != nil {
+		return jsonresponse.NotFound(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "replacement progress retrieved successfully", progress)
+}
@@ -0,0 +1,94 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/cluster/consensus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of the consistency tokens.
+//
+// A token is the index a write was committed at, prefixed with its shard as <shard>:<index> for shards other than 0,
+// since every shard has its own consensus log.
+const (
+	headerCommitIndex = "X-Commit-Index"
+	headerMinIndex    = "X-Min-Index"
+)
+
+// setCommitIndex returns the consistency token of a write, clients can send it back in X-Min-Index to read their writes.
+func setCommitIndex(fiberCtx *fiber.Ctx, s *consensus.Shard, index uint64) {
+	if index == 0 {
+		return
+	}
+	token := strconv.FormatUint(index, 10)
+	if s.ID != 0 {
+		token = strconv.Itoa(s.ID) + ":" + token
+	}
+	fiberCtx.Set(headerCommitIndex, token)
+}
+
+// parseMinIndex parses a comma separated list of consistency tokens, returning the highest index of each shard.
+func parseMinIndex(raw string) (map[int]uint64, error) {
+	indexes := make(map[int]uint64)
+	for _, token := range strings.Split(raw, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		shardID := 0
+		rawIndex := token
+		if before, after, found := strings.Cut(token, ":"); found {
+			id, errID := strconv.Atoi(before)
+			if errID != nil || id < 0 {
+				return nil, fmt.Errorf("invalid shard in token '%s'", token)
+			}
+			shardID = id
+			rawIndex = after
+		}
+		index, errIndex := strconv.ParseUint(rawIndex, 10, 64)
+		if errIndex != nil {
+			return nil, fmt.Errorf("invalid index in token '%s'", token)
+		}
+		if index > indexes[shardID] {
+			indexes[shardID] = index
+		}
+	}
+	return indexes, nil
+}
+
+// errInvalidMinIndex is returned when X-Min-Index isn't a list of consistency tokens.
+var errInvalidMinIndex = errors.New("couldn't parse " + headerMinIndex)
+
+// waitMinIndex blocks until this node applied the indexes of the consistency tokens, so a read reflects the client's writes.
+func (a *ApiCtx) waitMinIndex(ctx context.Context, raw string) error {
+	indexes, errParse := parseMinIndex(raw)
+	if errParse != nil {
+		return fmt.Errorf("%w: %v", errInvalidMinIndex, errParse)
+	}
+
+	deadline := time.Now().Add(a.Config.Reads.MinIndexTimeout)
+	// The wait ends with the request's deadline, if it's sooner.
+	requestDeadline, hasDeadline := ctx.Deadline()
+	if hasDeadline && requestDeadline.Before(deadline) {
+		deadline = requestDeadline
+	}
+	for shardID, index := range indexes {
+		s, errShard := a.Node.Shard(shardID)
+		if errShard != nil {
+			return fmt.Errorf("%w: %v", errInvalidMinIndex, errShard)
+		}
+		errWait := s.WaitApplied(index, time.Until(deadline))
+		if errWait != nil && hasDeadline && !time.Now().Before(requestDeadline) {
+			return fmt.Errorf("%w, shard %v applied %v of %v", context.DeadlineExceeded, shardID, s.Consensus.AppliedIndex(), index)
+		}
+		if errWait != nil {
+			return fmt.Errorf("%w, shard %v applied %v of %v", errWait, shardID, s.Consensus.AppliedIndex(), index)
+		}
+	}
+	return nil
+}
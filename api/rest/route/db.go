@@ -3,14 +3,20 @@ package route
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/narvikd/fiberparser"
 	"io"
 	"nubedb/api/rest/jsonresponse"
 	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/engine"
 	"nubedb/cluster/consensus/fsm"
-	"strings"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/valuecrypt"
+	"strconv"
+	"time"
 )
 
 func (a *ApiCtx) storeGet(fiberCtx *fiber.Ctx) error {
@@ -20,65 +26,338 @@ func (a *ApiCtx) storeGet(fiberCtx *fiber.Ctx) error {
 		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
 	}
 
-	value, errGet := a.Node.FSM.Get(payload.Key)
+	switch fiberCtx.Query("consistency") {
+	case "":
+	case "quorum":
+		return a.storeGetQuorum(fiberCtx, payload.Key)
+	default:
+		return jsonresponse.BadRequest(fiberCtx, "consistency must be empty or quorum")
+	}
+
+	s := a.Node.ShardFor(payload.Key)
+	if contentType := s.FSM.ContentType(payload.Key); contentType != "" {
+		return a.storeGetTyped(fiberCtx, s, payload.Key, contentType)
+	}
+	value, errGet := s.FSM.Get(payload.Key)
+	if errGet != nil {
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
+			return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
+		}
+		if errors.Is(errGet, engine.ErrCorrupted) {
+			return jsonresponse.Error(fiberCtx, errcode.DataCorrupted, "the value stored in this node is corrupted: "+errGet.Error(), "")
+		}
+		return jsonresponse.ServerError(fiberCtx, "couldn't get key from DB: "+errGet.Error())
+	}
+
+	value, errDecrypt := a.Crypter.Decrypt(s.FSM, payload.Key, value)
+	if errDecrypt != nil {
+		return jsonresponse.ServerError(fiberCtx, errDecrypt.Error())
+	}
+
+	return a.storeGetFiltered(fiberCtx, s, payload.Key, value)
+}
+
+// storeGetTyped returns the value of a key with a declared content type as it was set, with its content type.
+func (a *ApiCtx) storeGetTyped(fiberCtx *fiber.Ctx, s *consensus.Shard, key string, contentType string) error {
+	var (
+		value  []byte
+		errGet error
+	)
+	// Encrypted values must be decrypted before they're decoded, so they can't be read as they are stored.
+	if a.Crypter.IsEncrypted(s.FSM, key) {
+		var stored any
+		stored, errGet = s.FSM.Get(key)
+		if errGet == nil {
+			stored, errGet = a.Crypter.Decrypt(s.FSM, key, stored)
+		}
+		if errGet == nil {
+			value, errGet = fsm.DecodeValue(contentType, stored)
+		}
+	} else {
+		value, contentType, errGet = s.FSM.GetRaw(key)
+	}
 	if errGet != nil {
-		if strings.Contains(strings.ToLower(errGet.Error()), "key not found") {
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
 			return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
 		}
+		if errors.Is(errGet, engine.ErrCorrupted) {
+			return jsonresponse.Error(fiberCtx, errcode.DataCorrupted, "the value stored in this node is corrupted: "+errGet.Error(), "")
+		}
 		return jsonresponse.ServerError(fiberCtx, "couldn't get key from DB: "+errGet.Error())
 	}
 
+	fiberCtx.Set(fiber.HeaderContentType, contentType)
+	return fiberCtx.Status(fiber.StatusOK).Send(value)
+}
+
+// storeGetQuorum reads a key from a majority of the replicas, returning the value of the one which applied the most logs.
+//
+// How the replicas diverged is returned in the X-Quorum-Result header.
+func (a *ApiCtx) storeGetQuorum(fiberCtx *fiber.Ctx, key string) error {
+	res, errRead := a.Node.QuorumGet(key)
+	if errRead != nil {
+		if errors.Is(errRead, consensus.ErrNoQuorum) {
+			return jsonresponse.ServiceUnavailable(fiberCtx, errRead.Error(), 1*time.Second)
+		}
+		return jsonresponse.ServerError(fiberCtx, "couldn't read key from the replicas: "+errRead.Error())
+	}
+	fiberCtx.Set("X-Quorum-Result", res.Result)
+	fiberCtx.Set("X-Applied-Index", strconv.FormatUint(res.AppliedIndex, 10))
+	if !res.Found {
+		return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
+	}
+
+	var value any
+	errUnmarshal := json.Unmarshal(res.Value, &value)
+	if errUnmarshal != nil {
+		return jsonresponse.ServerError(fiberCtx, "couldn't decode the value read from the replicas: "+errUnmarshal.Error())
+	}
+	s := a.Node.ShardFor(key)
+	value, errDecrypt := a.Crypter.Decrypt(s.FSM, key, value)
+	if errDecrypt != nil {
+		return jsonresponse.ServerError(fiberCtx, errDecrypt.Error())
+	}
+	return a.storeGetFiltered(fiberCtx, s, key, value)
+}
+
+// storeGetFiltered returns a value after passing it to the read hook of its bucket, which can hide it.
+func (a *ApiCtx) storeGetFiltered(fiberCtx *fiber.Ctx, s *consensus.Shard, key string, value any) error {
+	value, visible, errHook := s.FSM.FilterRead(key, value)
+	if errHook != nil {
+		return jsonresponse.ServerError(fiberCtx, errHook.Error())
+	}
+	if !visible {
+		return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
+	}
 	return jsonresponse.OK(fiberCtx, "data retrieved successfully", value)
 }
 
+func (a *ApiCtx) storeExists(fiberCtx *fiber.Ctx) error {
+	key := fiberCtx.Query("key")
+	if key == "" {
+		return jsonresponse.BadRequest(fiberCtx, "key is a required query parameter")
+	}
+
+	// The fast check only answers from the keys' bloom filter, so it can't return the metadata.
+	if fiberCtx.Query("fast") == "true" {
+		if !a.Node.ShardFor(key).FSM.FastExists(key) {
+			return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
+		}
+		if fiberCtx.Method() == fiber.MethodHead {
+			return fiberCtx.SendStatus(fiber.StatusOK)
+		}
+		return jsonresponse.OK(fiberCtx, "key may exist", fiber.Map{"key": key})
+	}
+
+	meta, errExists := a.Node.ShardFor(key).FSM.Exists(key)
+	if errExists != nil {
+		if errors.Is(errExists, engine.ErrKeyNotFound) {
+			return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
+		}
+		return jsonresponse.ServerError(fiberCtx, "couldn't check key on DB: "+errExists.Error())
+	}
+
+	// HEAD requests only receive the metadata as headers
+	if fiberCtx.Method() == fiber.MethodHead {
+		fiberCtx.Set("X-Key-Size", strconv.FormatInt(meta.Size, 10))
+		fiberCtx.Set("X-Key-Version", strconv.FormatUint(meta.Version, 10))
+		return fiberCtx.SendStatus(fiber.StatusOK)
+	}
+
+	return jsonresponse.OK(fiberCtx, "key exists", meta)
+}
+
+// storeGetKeys returns all the keys, or only the ones starting with prefix.
 func (a *ApiCtx) storeGetKeys(fiberCtx *fiber.Ctx) error {
-	keys := a.Node.FSM.GetKeys()
-	if len(keys) <= 0 {
+	page, errPage := a.readPage(fiberCtx, "keys", "prefix")
+	if errPage != nil {
+		return jsonresponse.BadRequest(fiberCtx, errPage.Error())
+	}
+
+	// Every shard returns up to a page of keys and one more, so the page is complete once they are merged,
+	// and it's known whether there's a next one.
+	shardLimit := 0
+	if page.limit > 0 {
+		shardLimit = page.limit + 1
+	}
+	var keys []string
+	for _, s := range a.Node.Shards() {
+		keys = append(keys, s.FSM.GetKeysAfter(page.option("prefix"), page.after, shardLimit)...)
+	}
+	keys, token, errToken := a.cutPage(page, keys)
+	if errToken != nil {
+		return jsonresponse.ServerError(fiberCtx, errToken.Error())
+	}
+	if len(keys) <= 0 && page.isFirst() {
 		return jsonresponse.NotFound(fiberCtx, "no keys in DB")
 	}
-	return jsonresponse.OK(fiberCtx, "data retrieved successfully", keys)
+	return jsonresponse.Page(fiberCtx, "data retrieved successfully", keys, token)
 }
 
+// storeSet sets a key from a JSON body with its key and value.
+//
+// If the key is sent as a query parameter instead, the body is the value, stored with the request's content type,
+// which is returned when the key is read.
 func (a *ApiCtx) storeSet(fiberCtx *fiber.Ctx) error {
 	const operationType = "SET"
 
+	payload := new(fsm.Payload)
+	if key := fiberCtx.Query("key"); key != "" {
+		contentType := fiberCtx.Get(fiber.HeaderContentType)
+		if contentType == "" {
+			return jsonresponse.BadRequest(fiberCtx, "the content type of the value is required")
+		}
+		value, errEncode := fsm.EncodeValue(contentType, fiberCtx.Body())
+		if errEncode != nil {
+			return jsonresponse.BadRequest(fiberCtx, errEncode.Error())
+		}
+		payload.Key = key
+		payload.Value = value
+		payload.ContentType = contentType
+	} else {
+		errParse := fiberparser.ParseAndValidate(fiberCtx, payload)
+		if errParse != nil {
+			return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+		}
+		// The content type is only declared with the value in the body.
+		payload.ContentType = ""
+	}
+	payload.Operation = operationType
+	if errKey := fsm.CheckUserKey(payload.Key); errKey != nil {
+		return a.clusterError(fiberCtx, errKey)
+	}
+
+	s := a.Node.ShardFor(payload.Key)
+	// The value is checked before it's encrypted, since the nodes can't check it afterwards.
+	errSchema := s.FSM.ValidateSchema(payload.Key, payload.ContentType, payload.Value)
+	if errSchema != nil {
+		return a.clusterError(fiberCtx, errSchema)
+	}
+	value, errEncrypt := a.Crypter.Encrypt(s.FSM, payload.Key, payload.Value)
+	if errEncrypt != nil {
+		return a.clusterError(fiberCtx, errEncrypt)
+	}
+	payload.Value = value
+
+	index, errCluster := cluster.ExecuteIndexContext(fiberCtx.UserContext(), s.Consensus, payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	setCommitIndex(fiberCtx, s, index)
+
+	return jsonresponse.OK(fiberCtx, "data persisted successfully", "")
+}
+
+func (a *ApiCtx) storeAppend(fiberCtx *fiber.Ctx) error {
+	const operationType = "APPEND"
+
 	payload := new(fsm.Payload)
 	errParse := fiberparser.ParseAndValidate(fiberCtx, payload)
 	if errParse != nil {
 		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
 	}
 	payload.Operation = operationType
+	if errKey := fsm.CheckUserKey(payload.Key); errKey != nil {
+		return a.clusterError(fiberCtx, errKey)
+	}
 
-	errCluster := cluster.Execute(a.Node.Consensus, payload)
+	s := a.Node.ShardFor(payload.Key)
+	if a.Crypter.IsEncrypted(s.FSM, payload.Key) {
+		return a.clusterError(fiberCtx, valuecrypt.ErrAppendEncrypted)
+	}
+
+	index, errCluster := cluster.ExecuteIndexContext(fiberCtx.UserContext(), s.Consensus, payload)
 	if errCluster != nil {
-		return jsonresponse.ServerError(fiberCtx, errCluster.Error())
+		return a.clusterError(fiberCtx, errCluster)
 	}
+	setCommitIndex(fiberCtx, s, index)
 
-	return jsonresponse.OK(fiberCtx, "data persisted successfully", "")
+	return jsonresponse.OK(fiberCtx, "data appended successfully", "")
+}
+
+// storeGetList returns a page of a list, the tokens of its pages hold the offset of the next one.
+func (a *ApiCtx) storeGetList(fiberCtx *fiber.Ctx) error {
+	page, errPage := a.readPage(fiberCtx, "list", "key")
+	if errPage != nil {
+		return jsonresponse.BadRequest(fiberCtx, errPage.Error())
+	}
+	key := page.option("key")
+	if key == "" {
+		return jsonresponse.BadRequest(fiberCtx, "key is a required query parameter")
+	}
+	offset := fiberCtx.QueryInt("offset", 0)
+	if !page.isFirst() {
+		offset, _ = strconv.Atoi(page.after)
+	}
+	if offset < 0 {
+		return jsonresponse.BadRequest(fiberCtx, "offset and limit can't be negative")
+	}
+
+	list, total, errGet := a.Node.ShardFor(key).FSM.GetList(key, offset, page.limit)
+	if errGet != nil {
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
+			return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
+		}
+		if errors.Is(errGet, fsm.ErrNotAppendable) {
+			return jsonresponse.Error(fiberCtx, errcode.NotAppendable, "value stored in key is not a list", "")
+		}
+		return jsonresponse.ServerError(fiberCtx, "couldn't get list from DB: "+errGet.Error())
+	}
+
+	var token string
+	if page.limit > 0 && offset+len(list) < total {
+		var errToken error
+		token, errToken = a.nextPage(page, strconv.Itoa(offset+len(list)))
+		if errToken != nil {
+			return jsonresponse.ServerError(fiberCtx, errToken.Error())
+		}
+	}
+	return jsonresponse.Page(fiberCtx, "data retrieved successfully", &fiber.Map{
+		"items":  list,
+		"offset": offset,
+		"total":  total,
+	}, token)
 }
 
 func (a *ApiCtx) storeDelete(fiberCtx *fiber.Ctx) error {
-	const operationType = "DELETE"
+	const (
+		operationType     = "DELETE"
+		softOperationType = "SOFTDELETE"
+	)
 
 	payload := new(fsm.Payload)
 	errParse := fiberparser.ParseAndValidate(fiberCtx, payload)
 	if errParse != nil {
 		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
 	}
+	if errKey := fsm.CheckUserKey(payload.Key); errKey != nil {
+		return a.clusterError(fiberCtx, errKey)
+	}
 	payload.Operation = operationType
+	payload.Value = nil
+	// In soft delete mode the value is retained, the time of the deletion is sent so every node retains it equally.
+	if a.Config.SoftDelete.Retention > 0 {
+		payload.Operation = softOperationType
+		payload.Value = time.Now().UTC().Format(time.RFC3339Nano)
+	}
 
-	errCluster := cluster.Execute(a.Node.Consensus, payload)
+	s := a.Node.ShardFor(payload.Key)
+	index, errCluster := cluster.ExecuteIndexContext(fiberCtx.UserContext(), s.Consensus, payload)
 	if errCluster != nil {
-		if strings.Contains(strings.ToLower(errCluster.Error()), "key not found") {
+		if errcode.Of(errCluster) == errcode.NotFound {
 			return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
 		}
-		return jsonresponse.ServerError(fiberCtx, errCluster.Error())
+		return a.clusterError(fiberCtx, errCluster)
 	}
+	setCommitIndex(fiberCtx, s, index)
 
 	return jsonresponse.OK(fiberCtx, "data deleted successfully", "")
 }
 
 func (a *ApiCtx) storeBackup(fiberCtx *fiber.Ctx) error {
+	if a.Node.IsSharded() {
+		return jsonresponse.BadRequest(fiberCtx, errShardedBackup)
+	}
 	backup, err := a.Node.FSM.BackupDB()
 	if err != nil {
 		return jsonresponse.ServerError(fiberCtx, "couldn't backup DB: "+err.Error())
@@ -101,6 +380,9 @@ func (a *ApiCtx) restoreBackup(fiberCtx *fiber.Ctx) error {
 		key           = "backup"
 		operationType = "RESTOREDB"
 	)
+	if a.Node.IsSharded() {
+		return jsonresponse.BadRequest(fiberCtx, errShardedBackup)
+	}
 	// This error handles the case when the file isn't received
 	formFile, errFormFile := fiberCtx.FormFile(key)
 	if errFormFile != nil {
@@ -128,9 +410,9 @@ func (a *ApiCtx) restoreBackup(fiberCtx *fiber.Ctx) error {
 		Operation: operationType,
 		Value:     json.RawMessage(buf),
 	}
-	errCluster := cluster.Execute(a.Node.Consensus, payload)
+	errCluster := cluster.ExecuteContext(fiberCtx.UserContext(), a.Node.Consensus, payload)
 	if errCluster != nil {
-		return jsonresponse.ServerError(fiberCtx, errCluster.Error())
+		return a.clusterError(fiberCtx, errCluster)
 	}
 
 	keys := a.Node.FSM.GetKeys()
@@ -0,0 +1,45 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"time"
+)
+
+// errDeadline is answered when a request didn't complete within its deadline, a write may still be applied.
+var errDeadline = errors.New("the request didn't complete within its deadline, if it was a write it may still be applied")
+
+// adminRoutes are the routes outside of /admin and /cluster with the deadline of the administrative requests,
+// since they move the whole database.
+var adminRoutes = map[string]bool{
+	"/store/backup":  true,
+	"/store/restore": true,
+}
+
+// deadlineGuard sets the deadline of the request depending on its route, the cluster stops waiting for the
+// operations of the requests whose deadline passed, and they are answered with a 504.
+func (a *ApiCtx) deadlineGuard(fiberCtx *fiber.Ctx) error {
+	d := a.routeDeadline(fiberCtx)
+	if d <= 0 {
+		return fiberCtx.Next()
+	}
+	ctx, cancel := context.WithTimeout(fiberCtx.UserContext(), d)
+	defer cancel()
+	fiberCtx.SetUserContext(ctx)
+	return fiberCtx.Next()
+}
+
+// routeDeadline returns the deadline of a request: the administrative one, the read one or the write one.
+func (a *ApiCtx) routeDeadline(fiberCtx *fiber.Ctx) time.Duration {
+	path := fiberCtx.Path()
+	switch {
+	case strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/cluster/"), adminRoutes[path]:
+		return a.Config.Rest.AdminDeadline
+	case fiberCtx.Method() == fiber.MethodGet, fiberCtx.Method() == fiber.MethodHead:
+		return a.Config.Rest.ReadDeadline
+	default:
+		return a.Config.Rest.WriteDeadline
+	}
+}
@@ -0,0 +1,75 @@
+package route
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/errcode"
+	"runtime/pprof"
+	"runtime/trace"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTraceDuration is how long an execution trace is captured for if the duration isn't set.
+	defaultTraceDuration = 5 * time.Second
+	// maxTraceDuration is the maximum duration of an execution trace, since it's held in memory.
+	maxTraceDuration = 1 * time.Minute
+)
+
+// debugGuard refuses the requests to the runtime diagnostics which don't carry the debug token as a bearer token.
+func (a *ApiCtx) debugGuard(fiberCtx *fiber.Ctx) error {
+	token := strings.TrimPrefix(fiberCtx.Get(fiber.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.Config.Rest.DebugToken)) != 1 {
+		return jsonresponse.Error(fiberCtx, errcode.Unauthenticated, "the debug token is missing or invalid", "")
+	}
+	return fiberCtx.Next()
+}
+
+// debugGoroutines dumps the stacks of all the goroutines as text, the goroutines with the same stack are grouped
+// if grouped=true is set.
+func (a *ApiCtx) debugGoroutines(fiberCtx *fiber.Ctx) error {
+	debug := 2
+	if fiberCtx.Query("grouped") == "true" {
+		debug = 1
+	}
+	var buf bytes.Buffer
+	errWrite := pprof.Lookup("goroutine").WriteTo(&buf, debug)
+	if errWrite != nil {
+		return jsonresponse.ServerError(fiberCtx, "couldn't dump the goroutines: "+errWrite.Error())
+	}
+	fiberCtx.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	return fiberCtx.Send(buf.Bytes())
+}
+
+// debugTrace captures an execution trace of the node for a duration, and returns it to be opened with go tool trace.
+//
+// The capture stops early if the request's deadline passes first. Only one trace can be captured at a time.
+func (a *ApiCtx) debugTrace(fiberCtx *fiber.Ctx) error {
+	d, errDuration := queryDuration(fiberCtx, "duration", defaultTraceDuration)
+	if errDuration != nil {
+		return jsonresponse.BadRequest(fiberCtx, errDuration.Error())
+	}
+	if d <= 0 || d > maxTraceDuration {
+		return jsonresponse.BadRequest(fiberCtx, fmt.Sprintf("the duration must be between 0 and %v", maxTraceDuration))
+	}
+
+	var buf bytes.Buffer
+	errStart := trace.Start(&buf)
+	if errStart != nil {
+		return jsonresponse.Conflict(fiberCtx, "couldn't start the trace: "+errStart.Error())
+	}
+	select {
+	case <-time.After(d):
+	case <-fiberCtx.UserContext().Done():
+	}
+	trace.Stop()
+
+	name := fmt.Sprintf("trace-%s-%s.out", a.Config.CurrentNode.ID, time.Now().UTC().Format("20060102T150405Z"))
+	fiberCtx.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	fiberCtx.Set(fiber.HeaderContentDisposition, `attachment; filename="`+name+`"`)
+	return fiberCtx.Send(buf.Bytes())
+}
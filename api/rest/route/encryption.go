@@ -0,0 +1,30 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+)
+
+func (a *ApiCtx) bucketEncrypt(fiberCtx *fiber.Ctx) error {
+	const operationType = "SETBUCKETKEY"
+	bucket := fiberCtx.Query("bucket")
+
+	shards := a.bucketShards(bucket)
+	wrapped, errKey := a.Crypter.NewWrappedDataKey()
+	if errKey != nil {
+		return jsonresponse.ServerError(fiberCtx, errKey.Error())
+	}
+
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Value:     wrapped,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), shards, payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "bucket encrypted successfully, new values will be encrypted", "")
+}
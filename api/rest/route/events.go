@@ -0,0 +1,60 @@
+package route
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus"
+	"strings"
+	"time"
+)
+
+// clusterEvents streams the changes of the consensus observed by this node as server-sent events,
+// until the client disconnects. ?types= filters them, as a comma separated list.
+func (a *ApiCtx) clusterEvents(fiberCtx *fiber.Ctx) error {
+	// A comment is sent periodically, so the disconnected clients are detected even if there aren't events.
+	const keepAlive = 15 * time.Second
+
+	var filter []string
+	if fiberCtx.Query("types") != "" {
+		filter = strings.Split(fiberCtx.Query("types"), ",")
+	}
+	types, errTypes := consensus.EventTypes(filter)
+	if errTypes != nil {
+		return jsonresponse.BadRequest(fiberCtx, errTypes.Error())
+	}
+
+	fiberCtx.Set(fiber.HeaderContentType, "text/event-stream")
+	fiberCtx.Set(fiber.HeaderCacheControl, "no-cache")
+	fiberCtx.Set(fiber.HeaderConnection, "keep-alive")
+
+	events, unsubscribe := a.Node.SubscribeEvents()
+	conn := fiberCtx.Context().Conn()
+	fiberCtx.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			// The server's write timeout would end the stream, so the deadline is extended on every write.
+			_ = conn.SetWriteDeadline(time.Now().Add(2 * keepAlive))
+			var errWrite error
+			select {
+			case e := <-events:
+				if types != nil && !types[e.Type] {
+					continue
+				}
+				b, _ := json.Marshal(e)
+				_, errWrite = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+			case <-ticker.C:
+				_, errWrite = w.WriteString(": keep-alive\n\n")
+			}
+			if errWrite != nil || w.Flush() != nil {
+				return
+			}
+		}
+	}))
+	return nil
+}
@@ -0,0 +1,41 @@
+package route
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/pkg/featureflag"
+)
+
+// flagsBucket is the bucket where the feature flag documents are stored, each one under flags/<name>.
+const flagsBucket = "flags"
+
+// flagEvaluate evaluates a stored feature flag for the attributes sent in attrs (ex: attrs=country:ES,userId:42).
+func (a *ApiCtx) flagEvaluate(fiberCtx *fiber.Ctx) error {
+	name := fiberCtx.Params("name")
+	attrs, errAttrs := featureflag.ParseAttrs(fiberCtx.Query("attrs"))
+	if errAttrs != nil {
+		return jsonresponse.BadRequest(fiberCtx, errAttrs.Error())
+	}
+
+	key := flagsBucket + "/" + name
+	s := a.Node.ShardFor(key)
+	doc, errGet := s.FSM.Get(key)
+	if errGet != nil {
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
+			return jsonresponse.NotFound(fiberCtx, "flag doesn't exist")
+		}
+		return jsonresponse.ServerError(fiberCtx, "couldn't get flag from DB: "+errGet.Error())
+	}
+	doc, errDecrypt := a.Crypter.Decrypt(s.FSM, key, doc)
+	if errDecrypt != nil {
+		return jsonresponse.ServerError(fiberCtx, errDecrypt.Error())
+	}
+
+	flag, errFlag := featureflag.Parse(doc)
+	if errFlag != nil {
+		return jsonresponse.ServerError(fiberCtx, "the stored flag is invalid: "+errFlag.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "flag evaluated successfully", flag.Evaluate(name, attrs))
+}
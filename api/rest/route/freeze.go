@@ -0,0 +1,54 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+	"time"
+)
+
+func (a *ApiCtx) freezeGet(fiberCtx *fiber.Ctx) error {
+	f, err := a.Node.FSM.GetFreeze()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "freeze retrieved successfully", f)
+}
+
+// freezeSet freezes the writes of every shard, the reads continue to be served.
+func (a *ApiCtx) freezeSet(fiberCtx *fiber.Ctx) error {
+	const operationType = "FREEZE"
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if len(fiberCtx.Body()) > 0 {
+		errParse := fiberCtx.BodyParser(&req)
+		if errParse != nil {
+			return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+		}
+	}
+
+	now := time.Now().UTC()
+	payload := &fsm.Payload{
+		Key:       operationType,
+		Value:     fsm.Freeze{Reason: req.Reason, Since: &now},
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	return jsonresponse.OK(fiberCtx, "writes frozen successfully", "")
+}
+
+func (a *ApiCtx) freezeDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "UNFREEZE"
+
+	payload := &fsm.Payload{Key: operationType, Operation: operationType}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	return jsonresponse.OK(fiberCtx, "writes unfrozen successfully", "")
+}
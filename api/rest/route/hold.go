@@ -0,0 +1,64 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+	"time"
+)
+
+func (a *ApiCtx) holdList(fiberCtx *fiber.Ctx) error {
+	holds, err := a.Node.FSM.GetHolds()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, "couldn't get holds from DB: "+err.Error())
+	}
+	if len(holds) <= 0 {
+		return jsonresponse.NotFound(fiberCtx, "there aren't any holds")
+	}
+	return jsonresponse.OK(fiberCtx, "holds retrieved successfully", holds)
+}
+
+// holdSet makes a key or a prefix read-only on every shard, until the hold is deleted.
+func (a *ApiCtx) holdSet(fiberCtx *fiber.Ctx) error {
+	const operationType = "SETHOLD"
+
+	hold := new(fsm.Hold)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, hold)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+	now := time.Now().UTC()
+	hold.Since = &now
+
+	payload := &fsm.Payload{
+		Key:       hold.Key,
+		Value:     hold,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	return jsonresponse.OK(fiberCtx, "hold saved successfully", hold)
+}
+
+func (a *ApiCtx) holdDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "DELETEHOLD"
+
+	key := fiberCtx.Query("key")
+	if key == "" {
+		return jsonresponse.BadRequest(fiberCtx, "key is a required query parameter")
+	}
+
+	payload := &fsm.Payload{
+		Key:       key,
+		Value:     fsm.Hold{Key: key, Prefix: fiberCtx.Query("prefix") == "true"},
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	return jsonresponse.OK(fiberCtx, "hold deleted successfully", "")
+}
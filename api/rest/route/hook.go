@@ -0,0 +1,65 @@
+package route
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+)
+
+func (a *ApiCtx) hookGet(fiberCtx *fiber.Ctx) error {
+	bucket := fiberCtx.Query("bucket")
+	hook, err := a.bucketShards(bucket)[0].FSM.GetHook(bucket)
+	if err != nil {
+		if errors.Is(err, fsm.ErrHookNotFound) {
+			return jsonresponse.NotFound(fiberCtx, err.Error())
+		}
+		return jsonresponse.ServerError(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "hook retrieved successfully", hook)
+}
+
+// hookSet sets the Lua scripts run on the values written to and read from a bucket.
+func (a *ApiCtx) hookSet(fiberCtx *fiber.Ctx) error {
+	const operationType = "SETHOOK"
+
+	hook := new(fsm.Hook)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, hook)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+	errValid := hook.Validate()
+	if errValid != nil {
+		return jsonresponse.BadRequest(fiberCtx, errValid.Error())
+	}
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Value:     hook,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "hook set successfully", "")
+}
+
+func (a *ApiCtx) hookDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "DELETEHOOK"
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "hook deleted successfully", "")
+}
@@ -0,0 +1,102 @@
+package route
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+)
+
+func (a *ApiCtx) indexCreate(fiberCtx *fiber.Ctx) error {
+	const operationType = "CREATEINDEX"
+
+	idx := new(fsm.Index)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, idx)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+
+	payload := &fsm.Payload{
+		Key:       idx.Name,
+		Value:     idx,
+		Operation: operationType,
+	}
+	// Every shard indexes its own keys.
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "index created successfully", "")
+}
+
+func (a *ApiCtx) indexDrop(fiberCtx *fiber.Ctx) error {
+	const operationType = "DROPINDEX"
+
+	name := fiberCtx.Query("name")
+	if name == "" {
+		return jsonresponse.BadRequest(fiberCtx, "name is a required query parameter")
+	}
+
+	payload := &fsm.Payload{
+		Key:       name,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "index dropped successfully", "")
+}
+
+func (a *ApiCtx) indexList(fiberCtx *fiber.Ctx) error {
+	indexes, err := a.Node.FSM.GetIndexes()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, "couldn't get indexes from DB: "+err.Error())
+	}
+	if len(indexes) <= 0 {
+		return jsonresponse.NotFound(fiberCtx, "no indexes in DB")
+	}
+	return jsonresponse.OK(fiberCtx, "data retrieved successfully", indexes)
+}
+
+func (a *ApiCtx) storeQuery(fiberCtx *fiber.Ctx) error {
+	page, errPage := a.readPage(fiberCtx, "query", "index", "value")
+	if errPage != nil {
+		return jsonresponse.BadRequest(fiberCtx, errPage.Error())
+	}
+	index := page.option("index")
+	if index == "" {
+		return jsonresponse.BadRequest(fiberCtx, "index is a required query parameter")
+	}
+
+	result := make(map[string]any)
+	for _, s := range a.Node.Shards() {
+		shardResult, errQuery := s.FSM.Query(index, page.option("value"))
+		if errQuery != nil {
+			if errors.Is(errQuery, fsm.ErrIndexNotFound) {
+				return jsonresponse.NotFound(fiberCtx, "index doesn't exist")
+			}
+			return jsonresponse.ServerError(fiberCtx, "couldn't query DB: "+errQuery.Error())
+		}
+		for k, v := range shardResult {
+			result[k] = v
+		}
+	}
+
+	result, token, errToken := a.cutPageResult(page, result)
+	if errToken != nil {
+		return jsonresponse.ServerError(fiberCtx, errToken.Error())
+	}
+	for k, v := range result {
+		decrypted, errDecrypt := a.Crypter.Decrypt(a.Node.ShardFor(k).FSM, k, v)
+		if errDecrypt != nil {
+			return jsonresponse.ServerError(fiberCtx, errDecrypt.Error())
+		}
+		result[k] = decrypted
+	}
+
+	return jsonresponse.Page(fiberCtx, "data retrieved successfully", result, token)
+}
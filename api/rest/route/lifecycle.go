@@ -0,0 +1,53 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"time"
+)
+
+// preStopTimeout is how long preStop waits for other nodes to take the leadership by default.
+const preStopTimeout = 30 * time.Second
+
+// preStop prepares the node to be stopped, it's meant to be a Kubernetes preStop hook.
+//
+// With expectReplacement, the node is kept in the consensus while it's offline, for a pod coming back with the same identity.
+func (a *ApiCtx) preStop(fiberCtx *fiber.Ctx) error {
+	expectReplacement, errExpect := queryDuration(fiberCtx, "expectReplacement", 0)
+	if errExpect != nil {
+		return jsonresponse.BadRequest(fiberCtx, "invalid expectReplacement: "+errExpect.Error())
+	}
+	timeout, errTimeout := queryDuration(fiberCtx, "timeout", preStopTimeout)
+	if errTimeout != nil {
+		return jsonresponse.BadRequest(fiberCtx, "invalid timeout: "+errTimeout.Error())
+	}
+
+	errStop := a.Node.PrepareStop(expectReplacement, timeout)
+	if errStop != nil {
+		return jsonresponse.ServerError(fiberCtx, errStop.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "node is ready to be stopped", "")
+}
+
+// ready answers with a 503 until the node can serve traffic, it's meant to be a Kubernetes readiness probe.
+//
+// maxLag overrides the maximum number of logs the node can be behind, and minIndex requires the node to have applied it.
+func (a *ApiCtx) ready(fiberCtx *fiber.Ctx) error {
+	maxLag := uint64(fiberCtx.QueryInt("maxLag", int(a.Config.Reads.ReadyMaxApplyLag)))
+	errReady := a.Node.Ready(maxLag)
+	if errReady != nil {
+		return jsonresponse.ServiceUnavailable(fiberCtx, errReady.Error(), 1*time.Second)
+	}
+	if minIndex := uint64(fiberCtx.QueryInt("minIndex", 0)); a.Node.ApplyLag().AppliedIndex < minIndex {
+		return jsonresponse.ServiceUnavailable(fiberCtx, "node didn't apply the index yet", 1*time.Second)
+	}
+	return jsonresponse.OK(fiberCtx, "node is ready", a.Node.ApplyLag())
+}
+
+func queryDuration(fiberCtx *fiber.Ctx, key string, def time.Duration) (time.Duration, error) {
+	raw := fiberCtx.Query(key)
+	if raw == "" {
+		return def, nil
+	}
+	return time.ParseDuration(raw)
+}
@@ -0,0 +1,102 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/protocol"
+	"nubedb/internal/buildinfo"
+	"nubedb/internal/config"
+	"path/filepath"
+	"sort"
+)
+
+// nodeInfo describes the node: the binary it runs, the versions it speaks, where it stores its data,
+// and the features it has enabled, so the nodes of a heterogeneous cluster can be compared during an upgrade.
+type nodeInfo struct {
+	NodeID string `json:"nodeID"`
+	buildinfo.Info
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	PayloadVersion     int      `json:"payloadVersion"`
+	PayloadEncoding    string   `json:"payloadEncoding"`
+	StorageEngine      string   `json:"storageEngine"`
+	LogStore           string   `json:"logStore"`
+	DataDir            string   `json:"dataDir"`
+	StorageDir         string   `json:"storageDir"`
+	LogDir             string   `json:"logDir"`
+	SnapshotDir        string   `json:"snapshotDir"`
+	Shards             int      `json:"shards"`
+	Witness            bool     `json:"witness"`
+	ReadOnly           bool     `json:"readOnly"`
+	Features           []string `json:"features"`
+}
+
+func (a *ApiCtx) nodeInfo(fiberCtx *fiber.Ctx) error {
+	paths := a.Node.Paths()
+	info := nodeInfo{
+		NodeID:             a.Config.CurrentNode.ID,
+		Info:               buildinfo.Get(),
+		ProtocolVersion:    protocol.Version,
+		MinProtocolVersion: protocol.MinCompatible,
+		PayloadVersion:     fsm.PayloadVersion,
+		PayloadEncoding:    a.Config.Consensus.PayloadEncoding,
+		StorageEngine:      a.Config.Storage.Engine,
+		LogStore:           a.Config.Consensus.LogStore,
+		DataDir:            absPath(paths.Main),
+		StorageDir:         absPath(paths.Storage),
+		LogDir:             absPath(paths.Logs),
+		SnapshotDir:        absPath(paths.SnapshotStore()),
+		Shards:             len(a.Node.Shards()),
+		Witness:            a.Node.IsWitness(),
+		ReadOnly:           a.Node.IsReadOnly(),
+		Features:           enabledFeatures(a.Config),
+	}
+	return jsonresponse.OK(fiberCtx, "node info retrieved successfully", info)
+}
+
+// absPath returns the absolute path of a path, or the path as is if it can't be resolved.
+func absPath(p string) string {
+	abs, errAbs := filepath.Abs(p)
+	if errAbs != nil {
+		return p
+	}
+	return abs
+}
+
+// enabledFeatures returns the optional features enabled in a node's configuration, sorted by name.
+func enabledFeatures(cfg config.Config) []string {
+	enabled := map[string]bool{
+		"accessLog":        cfg.Metrics.AccessLogSampleEvery > 0 || cfg.Metrics.AccessLogErrors,
+		"alerts":           cfg.Alert.NoLeaderAfter > 0 || cfg.Alert.QuorumLossAfter > 0,
+		"antiEntropy":      cfg.AntiEntropy.Interval > 0,
+		"backupShipping":   cfg.Backup.Store.Provider != "",
+		"bloomFilter":      cfg.Storage.BloomKeys > 0,
+		"cdc":              cfg.CDC.Sink != "",
+		"chaos":            cfg.Chaos.Enabled,
+		"chunking":         cfg.Storage.ChunkSize > 0,
+		"cloudJoin":        cfg.Cluster.CloudJoin != "",
+		"coalescing":       cfg.Coalescing.Window > 0,
+		"diskWatch":        cfg.Storage.MinFreeBytes > 0,
+		"encryptionAtRest": cfg.Encryption.Enabled(),
+		"grpcUnixSocket":   cfg.Grpc.UnixSocket != "",
+		"handoff":          cfg.Handoff.Enabled,
+		"peerAuth":         cfg.Cluster.Secret != "" || cfg.Cluster.JoinToken != "",
+		"readCache":        cfg.Storage.ReadCacheSize > 0,
+		"replication":      cfg.Replication.Target != "",
+		"restUnixSocket":   cfg.Rest.UnixSocket != "",
+		"sharding":         cfg.Sharding.Shards > 1,
+		"singleNode":       cfg.Cluster.SingleNode,
+		"softDelete":       cfg.SoftDelete.Retention > 0,
+		"tls":              cfg.Rest.TLSCertFile != "" || cfg.Rest.AutocertDomains != "",
+		"valueEncryption":  len(cfg.Encryption.ValueKey) > 0,
+	}
+	features := make([]string, 0, len(enabled))
+	for name, on := range enabled {
+		if on {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
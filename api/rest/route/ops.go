@@ -0,0 +1,25 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
+	"strconv"
+)
+
+func (a *ApiCtx) opsList(fiberCtx *fiber.Ctx) error {
+	return jsonresponse.OK(fiberCtx, "operations in flight retrieved successfully", cluster.InFlight())
+}
+
+// opKill stops waiting for an operation in flight on this node, its client receives an ABORTED error.
+func (a *ApiCtx) opKill(fiberCtx *fiber.Ctx) error {
+	id, errID := strconv.ParseUint(fiberCtx.Params("id"), 10, 64)
+	if errID != nil {
+		return jsonresponse.BadRequest(fiberCtx, "the id must be a positive integer")
+	}
+	errKill := cluster.KillOp(id)
+	if errKill != nil {
+		return a.clusterError(fiberCtx, errKill)
+	}
+	return jsonresponse.OK(fiberCtx, "operation killed", "")
+}
@@ -0,0 +1,108 @@
+package route
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"nubedb/internal/config"
+	"nubedb/pkg/pagetoken"
+	"sort"
+)
+
+// pageRequest is the pagination of a request to a list endpoint.
+type pageRequest struct {
+	endpoint string
+	// after is the last key of the previous page, empty on the first one.
+	after   string
+	options map[string]string
+	// limit is the maximum number of items of the page, 0 returns all of them, without a token.
+	limit int
+}
+
+// pageTokenSecret returns the secret the page tokens are signed with, a random one if it isn't configured.
+func pageTokenSecret(cfg config.Config) []byte {
+	if cfg.Rest.PageTokenSecret != "" {
+		return []byte(cfg.Rest.PageTokenSecret)
+	}
+	log.Println("[api] NUBEDB_REST_PAGE_TOKEN_SECRET isn't set, the page tokens will only work on this node")
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}
+
+// readPage reads the pagination of a request to an endpoint from its pageToken,
+// or for the first page, from its limit and the query parameters named by options.
+//
+// The next pages keep the options of the first one, so they can't change midway.
+func (a *ApiCtx) readPage(fiberCtx *fiber.Ctx, endpoint string, options ...string) (*pageRequest, error) {
+	if raw := fiberCtx.Query("pageToken"); raw != "" {
+		token, errDecode := a.PageTokens.Decode(raw)
+		if errDecode != nil {
+			return nil, errDecode
+		}
+		if token.Endpoint != endpoint {
+			return nil, fmt.Errorf("%w: it was issued by another endpoint", pagetoken.ErrInvalid)
+		}
+		return &pageRequest{endpoint: endpoint, after: token.After, options: token.Options, limit: token.Limit}, nil
+	}
+
+	limit := fiberCtx.QueryInt("limit", 0)
+	if limit < 0 {
+		return nil, errors.New("limit can't be negative")
+	}
+	p := &pageRequest{endpoint: endpoint, options: make(map[string]string), limit: limit}
+	for _, name := range options {
+		p.options[name] = fiberCtx.Query(name)
+	}
+	return p, nil
+}
+
+func (p *pageRequest) option(name string) string {
+	return p.options[name]
+}
+
+// isFirst returns whether the request is for the first page.
+func (p *pageRequest) isFirst() bool {
+	return p.after == ""
+}
+
+// cutPage sorts the keys, and returns the ones after the page's start up to its limit,
+// with the token of the next page, empty if it's the last one.
+func (a *ApiCtx) cutPage(p *pageRequest, keys []string) ([]string, string, error) {
+	sort.Strings(keys)
+	start := sort.Search(len(keys), func(i int) bool {
+		return keys[i] > p.after
+	})
+	keys = keys[start:]
+	if p.limit <= 0 || len(keys) <= p.limit {
+		return keys, "", nil
+	}
+
+	keys = keys[:p.limit]
+	token, errEncode := a.nextPage(p, keys[len(keys)-1])
+	return keys, token, errEncode
+}
+
+// cutPageResult is cutPage for the results keyed by their key.
+func (a *ApiCtx) cutPageResult(p *pageRequest, result map[string]any) (map[string]any, string, error) {
+	keys := make([]string, 0, len(result))
+	for k := range result {
+		keys = append(keys, k)
+	}
+	keys, token, errToken := a.cutPage(p, keys)
+	if errToken != nil {
+		return nil, "", errToken
+	}
+	cut := make(map[string]any, len(keys))
+	for _, k := range keys {
+		cut[k] = result[k]
+	}
+	return cut, token, nil
+}
+
+// nextPage returns the token of the page which starts after a key.
+func (a *ApiCtx) nextPage(p *pageRequest, after string) (string, error) {
+	return a.PageTokens.Encode(pagetoken.Token{Endpoint: p.endpoint, After: after, Options: p.options, Limit: p.limit})
+}
@@ -0,0 +1,35 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus"
+)
+
+func (a *ApiCtx) readOnlyGet(fiberCtx *fiber.Ctx) error {
+	res := struct {
+		ReadOnly bool `json:"readOnly"`
+	}{ReadOnly: a.Node.IsReadOnly()}
+	return jsonresponse.OK(fiberCtx, "read-only mode retrieved successfully", res)
+}
+
+// readOnlySet makes the node refuse the writes it receives, it's only for this node and it isn't kept across restarts.
+func (a *ApiCtx) readOnlySet(fiberCtx *fiber.Ctx) error {
+	a.Node.SetReadOnly(true)
+	return jsonresponse.OK(fiberCtx, "node is read-only", "")
+}
+
+func (a *ApiCtx) readOnlyDelete(fiberCtx *fiber.Ctx) error {
+	a.Node.SetReadOnly(false)
+	return jsonresponse.OK(fiberCtx, "node accepts writes again", "")
+}
+
+// readOnlyGuard refuses the writes while the node is read-only, the reads are served.
+func (a *ApiCtx) readOnlyGuard(fiberCtx *fiber.Ctx) error {
+	method := fiberCtx.Method()
+	if a.Node.IsReadOnly() && method != http.MethodGet && method != http.MethodHead {
+		return a.clusterError(fiberCtx, consensus.ErrReadOnly)
+	}
+	return fiberCtx.Next()
+}
@@ -1,10 +1,19 @@
 package route
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/pprof"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
 	"nubedb/cluster/consensus"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/valuecrypt"
 	"nubedb/internal/app"
 	"nubedb/internal/config"
+	"nubedb/internal/metrics"
+	"nubedb/pkg/pagetoken"
+	"time"
 )
 
 // ApiCtx is a simple struct to include a collection of tools that a route could need to operate, for example a DB.
@@ -12,6 +21,10 @@ type ApiCtx struct {
 	Config     config.Config
 	HttpServer *fiber.App
 	Node       *consensus.Node
+	Crypter    *valuecrypt.Crypter
+	PageTokens *pagetoken.Signer
+	// Reload reloads the configuration of the node.
+	Reload func() error
 }
 
 // newRouteCtx returns a pointer of a new instance of ApiCtx.
@@ -20,6 +33,9 @@ func newRouteCtx(app *app.App) *ApiCtx {
 		Config:     app.Config,
 		HttpServer: app.HttpServer,
 		Node:       app.Node,
+		Crypter:    app.Crypter,
+		PageTokens: pagetoken.NewSigner(pageTokenSecret(app.Config)),
+		Reload:     app.Reload,
 	}
 	return &routeCtx
 }
@@ -30,15 +46,157 @@ func Register(app *app.App) {
 }
 
 func routes(app *fiber.App, route *ApiCtx) {
-	app.Get("/store", route.storeGet)
-	app.Get("/store/keys", route.storeGetKeys)
+	app.Use(route.accessLog)
+	app.Use(route.deadlineGuard)
+
+	// The data plane is refused while the node is in maintenance mode.
+	app.Use("/store", route.maintenanceGuard)
+	app.Use("/search", route.maintenanceGuard)
+	app.Use("/flags", route.maintenanceGuard)
+	app.Use("/series", route.maintenanceGuard)
+	// And its writes while it's read-only.
+	app.Use("/store", route.readOnlyGuard)
+	app.Use("/search", route.readOnlyGuard)
+	app.Use("/series", route.readOnlyGuard)
+	app.Use("/store", route.settingsGuard)
+	app.Use("/series", route.settingsGuard)
+
+	// HEAD must be registered before GET, since fiber also registers GET routes as HEAD
+	app.Head("/store", route.readGuard, route.storeExists)
+	app.Get("/store", route.readGuard, route.storeGet)
+	app.Get("/store/keys", route.readGuard, route.storeGetKeys)
+	app.Get("/store/exists", route.readGuard, route.storeExists)
+	app.Get("/store/list", route.readGuard, route.storeGetList)
+	app.Get("/store/stream", route.readGuard, route.storeStreamGet)
 
 	app.Post("/store", route.storeSet)
+	app.Post("/store/append", route.storeAppend)
+	app.Post("/store/do", route.storeDo)
+	app.Post("/store/stream", route.storeStreamSet)
 	app.Delete("/store", route.storeDelete)
+	app.Get("/store/deleted", route.readGuard, route.storeDeleted)
+	app.Post("/store/undelete", route.storeUndelete)
+
+	app.Get("/store/query", route.readGuard, route.storeQuery)
+	app.Get("/store/indexes", route.readGuard, route.indexList)
+	app.Post("/store/indexes", route.indexCreate)
+	app.Delete("/store/indexes", route.indexDrop)
+
+	app.Post("/store/encryption", route.bucketEncrypt)
+	app.Post("/store/blobs", route.blobEnable)
+	app.Delete("/store/blobs", route.blobDisable)
+	app.Get("/store/schemas", route.readGuard, route.schemaGet)
+	app.Post("/store/schemas", route.schemaSet)
+	app.Delete("/store/schemas", route.schemaDelete)
+	app.Get("/store/hooks", route.readGuard, route.hookGet)
+	app.Post("/store/hooks", route.hookSet)
+	app.Delete("/store/hooks", route.hookDelete)
+
+	app.Get("/search", route.readGuard, route.search)
+	app.Post("/search/buckets", route.searchEnable)
+	app.Delete("/search/buckets", route.searchDisable)
 
-	app.Get("/store/backup", route.storeBackup)
+	app.Get("/flags/:name", route.readGuard, route.flagEvaluate)
+
+	app.Get("/series/:name", route.readGuard, route.seriesGet)
+	app.Post("/series/:name", route.seriesAppend)
+	app.Delete("/series/:name", route.seriesDelete)
+
+	app.Get("/store/backup", route.readGuard, route.storeBackup)
 	app.Post("/store/restore", route.restoreBackup)
 
 	app.Get("/consensus", route.consensusState)
+	app.Get("/node/info", route.nodeInfo)
+	app.Get("/cluster/status", route.clusterStatus)
+	app.Get("/cluster/topology", route.clusterTopology)
+	app.Get("/cluster/verify-leader", route.verifyLeader)
+	app.Get("/cluster/events", route.clusterEvents)
+	app.Post("/cluster/decommission/:id", route.decommission)
+	app.Get("/cluster/decommission/:id", route.decommissionProgress)
+	app.Post("/cluster/replace/:id", route.replace)
+	app.Get("/cluster/replace/:id", route.replaceProgress)
+	app.Get("/metrics", metrics.Handler())
+	app.Get("/admin/raft/configuration", route.raftConfiguration)
+	app.Post("/admin/raft/recover", route.raftRecover)
+	app.Get("/admin/stats/hotkeys", route.hotKeys)
+	app.Get("/admin/stats/storage", route.storageStats)
+	app.Get("/admin/ops", route.opsList)
+	app.Delete("/admin/ops/:id", route.opKill)
+	app.Post("/admin/maintenance", route.maintenanceEnter)
+	app.Post("/admin/maintenance/resume", route.maintenanceExit)
+	app.Post("/admin/prestop", route.preStop)
+	app.Post("/admin/reload", route.reload)
+	app.Get("/admin/readonly", route.readOnlyGet)
+	app.Post("/admin/readonly", route.readOnlySet)
+	app.Delete("/admin/readonly", route.readOnlyDelete)
+	app.Get("/admin/freeze", route.freezeGet)
+	app.Post("/admin/freeze", route.freezeSet)
+	app.Delete("/admin/freeze", route.freezeDelete)
+	app.Get("/admin/holds", route.holdList)
+	app.Post("/admin/holds", route.holdSet)
+	app.Delete("/admin/holds", route.holdDelete)
+	app.Get("/admin/tenants", route.tenantList)
+	app.Post("/admin/tenants", route.tenantSet)
+	app.Delete("/admin/tenants", route.tenantDelete)
+	app.Get("/admin/settings", route.settingsList)
+	app.Post("/admin/settings", route.settingsSet)
+	app.Delete("/admin/settings", route.settingsDelete)
+	app.Get("/admin/webhooks", route.webhookList)
+	app.Post("/admin/webhooks", route.webhookSet)
+	app.Delete("/admin/webhooks", route.webhookDelete)
+	app.Get("/admin/shards", route.shardList)
+	app.Post("/admin/shards/split", route.shardSplit)
+	app.Post("/admin/shards/merge", route.shardMerge)
+	app.Post("/admin/shards/rebalance", route.shardRebalance)
+	app.Post("/admin/shards/:id/replicas", route.shardMoveReplica)
+	app.Get("/admin/antientropy", route.antiEntropyReports)
+	app.Post("/admin/antientropy", route.antiEntropyVerify)
+	app.Get("/admin/antientropy/digests/:id", route.antiEntropyDigest)
 	app.Get("/healthcheck", route.healthCheck)
+	app.Get("/ready", route.ready)
+
+	// The fault injection is only exposed on nodes which enabled it.
+	if route.Config.Chaos.Enabled {
+		app.Get("/admin/chaos", route.chaosGet)
+		app.Post("/admin/chaos", route.chaosSet)
+		app.Delete("/admin/chaos", route.chaosReset)
+	}
+
+	// The runtime diagnostics are only exposed on nodes with a debug token, and they require it.
+	if route.Config.Rest.DebugToken != "" {
+		app.Use("/admin/debug", route.debugGuard)
+		app.Use(pprof.New(pprof.Config{Prefix: "/admin"}))
+		app.Get("/admin/debug/goroutines", route.debugGoroutines)
+		app.Get("/admin/debug/trace", route.debugTrace)
+	}
+}
+
+// clusterError returns the response for an error returned by the cluster, with the status code of its error code.
+//
+// Writes buffered to be applied once a leader is elected are answered with a 202.
+// The retryable errors answered with a 503 tell the client when to retry with the Retry-After header,
+// and the ones which must be sent to the leader carry its id.
+func (a *ApiCtx) clusterError(fiberCtx *fiber.Ctx, err error) error {
+	if errors.Is(err, cluster.ErrHandedOff) {
+		return jsonresponse.Accepted(fiberCtx, err.Error())
+	}
+	code := errcode.Of(err)
+	message := errcode.Message(err)
+	if code == errcode.DeadlineExceeded {
+		message = errDeadline.Error()
+	}
+
+	switch code {
+	case errcode.Unavailable, errcode.SlotMoved:
+		jsonresponse.SetRetryAfter(fiberCtx, cluster.RetryAfter())
+	case errcode.Maintenance, errcode.Frozen, errcode.RateLimited:
+		jsonresponse.SetRetryAfter(fiberCtx, 1*time.Second)
+	}
+
+	var leader string
+	if code == errcode.NotLeader {
+		_, leaderID := a.Node.Consensus.LeaderWithID()
+		leader = string(leaderID)
+	}
+	return jsonresponse.Error(fiberCtx, code, message, leader)
 }
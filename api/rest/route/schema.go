@@ -0,0 +1,66 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/pkg/jsonschema"
+)
+
+func (a *ApiCtx) schemaGet(fiberCtx *fiber.Ctx) error {
+	bucket := fiberCtx.Query("bucket")
+	schema, err := a.bucketShards(bucket)[0].FSM.GetSchema(bucket)
+	if err != nil {
+		if errors.Is(err, fsm.ErrSchemaNotFound) {
+			return jsonresponse.NotFound(fiberCtx, err.Error())
+		}
+		return jsonresponse.ServerError(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "schema retrieved successfully", schema)
+}
+
+// schemaSet sets the JSON Schema the values set in a bucket must match, the body is the schema.
+func (a *ApiCtx) schemaSet(fiberCtx *fiber.Ctx) error {
+	const operationType = "SETSCHEMA"
+
+	_, errCompile := jsonschema.Compile(fiberCtx.Body())
+	if errCompile != nil {
+		return jsonresponse.BadRequest(fiberCtx, errCompile.Error())
+	}
+	var schema any
+	errUnmarshal := json.Unmarshal(fiberCtx.Body(), &schema)
+	if errUnmarshal != nil {
+		return jsonresponse.BadRequest(fiberCtx, errUnmarshal.Error())
+	}
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Value:     schema,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "schema set successfully", "")
+}
+
+func (a *ApiCtx) schemaDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "DELETESCHEMA"
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "schema deleted successfully", "")
+}
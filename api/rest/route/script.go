@@ -0,0 +1,43 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+)
+
+// storeDo runs a Lua script against the keys of a bucket, applying all its writes atomically.
+func (a *ApiCtx) storeDo(fiberCtx *fiber.Ctx) error {
+	const operationType = "DO"
+
+	bucket := fiberCtx.Query("bucket")
+	if bucket == "" {
+		return jsonresponse.BadRequest(fiberCtx, "bucket is a required query parameter")
+	}
+	script := new(fsm.Script)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, script)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+	errValid := script.Validate()
+	if errValid != nil {
+		return jsonresponse.BadRequest(fiberCtx, errValid.Error())
+	}
+
+	// The key is the bucket's prefix, so the script is applied by the bucket's shard, and a hold on it refuses it.
+	payload := &fsm.Payload{
+		Key:       bucket + "/",
+		Value:     script,
+		Operation: operationType,
+	}
+	s := a.Node.ShardFor(payload.Key)
+	result, index, errCluster := cluster.ExecuteResultContext(fiberCtx.UserContext(), s.Consensus, payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	setCommitIndex(fiberCtx, s, index)
+
+	return jsonresponse.OK(fiberCtx, "script run successfully", result)
+}
@@ -0,0 +1,72 @@
+package route
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+)
+
+func (a *ApiCtx) search(fiberCtx *fiber.Ctx) error {
+	page, errPage := a.readPage(fiberCtx, "search", "q", "bucket")
+	if errPage != nil {
+		return jsonresponse.BadRequest(fiberCtx, errPage.Error())
+	}
+	query := page.option("q")
+	if query == "" {
+		return jsonresponse.BadRequest(fiberCtx, "q is a required query parameter")
+	}
+
+	bucket := page.option("bucket")
+	result := make(map[string]any)
+	for _, s := range a.bucketShards(bucket) {
+		shardResult, errSearch := s.FSM.Search(bucket, query)
+		if errSearch != nil {
+			if errors.Is(errSearch, fsm.ErrSearchNotEnabled) {
+				return jsonresponse.NotFound(fiberCtx, errSearch.Error())
+			}
+			return jsonresponse.ServerError(fiberCtx, "couldn't search DB: "+errSearch.Error())
+		}
+		for k, v := range shardResult {
+			result[k] = v
+		}
+	}
+
+	result, token, errToken := a.cutPageResult(page, result)
+	if errToken != nil {
+		return jsonresponse.ServerError(fiberCtx, errToken.Error())
+	}
+	return jsonresponse.Page(fiberCtx, "data retrieved successfully", result, token)
+}
+
+func (a *ApiCtx) searchEnable(fiberCtx *fiber.Ctx) error {
+	const operationType = "ENABLESEARCH"
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "search enabled successfully", "")
+}
+
+func (a *ApiCtx) searchDisable(fiberCtx *fiber.Ctx) error {
+	const operationType = "DISABLESEARCH"
+
+	bucket := fiberCtx.Query("bucket")
+	payload := &fsm.Payload{
+		Key:       bucket,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.bucketShards(bucket), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "search disabled successfully", "")
+}
@@ -0,0 +1,91 @@
+package route
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+	"time"
+)
+
+// seriesAppendRequest is the body of a request appending points to a time series.
+type seriesAppendRequest struct {
+	Points []fsm.SeriesPoint `json:"points" validate:"required,min=1"`
+}
+
+func (a *ApiCtx) seriesAppend(fiberCtx *fiber.Ctx) error {
+	const operationType = "SERIESAPPEND"
+
+	req := new(seriesAppendRequest)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, req)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+
+	name := fiberCtx.Params("name")
+	payload := &fsm.Payload{
+		Key:       name,
+		Value:     req.Points,
+		Operation: operationType,
+	}
+	s := a.Node.ShardFor(name)
+	index, errCluster := cluster.ExecuteIndexContext(fiberCtx.UserContext(), s.Consensus, payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	setCommitIndex(fiberCtx, s, index)
+
+	return jsonresponse.OK(fiberCtx, "points appended successfully", "")
+}
+
+// seriesGet returns the points of a time series between the from and to query parameters, in RFC 3339.
+//
+// Without from, the points are returned from the first one, and without to, until the last one.
+func (a *ApiCtx) seriesGet(fiberCtx *fiber.Ctx) error {
+	from, errFrom := parseSeriesTime(fiberCtx.Query("from"), time.Unix(0, 0))
+	if errFrom != nil {
+		return jsonresponse.BadRequest(fiberCtx, "from must be a RFC 3339 time")
+	}
+	to, errTo := parseSeriesTime(fiberCtx.Query("to"), time.Unix(1<<62, 0))
+	if errTo != nil {
+		return jsonresponse.BadRequest(fiberCtx, "to must be a RFC 3339 time")
+	}
+
+	name := fiberCtx.Params("name")
+	points, errGet := a.Node.ShardFor(name).FSM.GetSeries(name, from, to)
+	if errGet != nil {
+		if errors.Is(errGet, fsm.ErrSeriesNotFound) {
+			return jsonresponse.NotFound(fiberCtx, errGet.Error())
+		}
+		return jsonresponse.ServerError(fiberCtx, "couldn't get time series from DB: "+errGet.Error())
+	}
+
+	return jsonresponse.OK(fiberCtx, "data retrieved successfully", points)
+}
+
+func (a *ApiCtx) seriesDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "SERIESDELETE"
+
+	name := fiberCtx.Params("name")
+	payload := &fsm.Payload{
+		Key:       name,
+		Operation: operationType,
+	}
+	s := a.Node.ShardFor(name)
+	index, errCluster := cluster.ExecuteIndexContext(fiberCtx.UserContext(), s.Consensus, payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	setCommitIndex(fiberCtx, s, index)
+
+	return jsonresponse.OK(fiberCtx, "time series deleted successfully", "")
+}
+
+func parseSeriesTime(raw string, fallback time.Time) (time.Time, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+	return time.Parse(time.RFC3339Nano, raw)
+}
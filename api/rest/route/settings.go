@@ -0,0 +1,77 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/settings"
+)
+
+func (a *ApiCtx) settingsList(fiberCtx *fiber.Ctx) error {
+	set, err := a.Node.FSM.GetSettings()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, "couldn't get settings from DB: "+err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "settings retrieved successfully", settings.List(set))
+}
+
+// settingsSet sets a setting for the whole cluster, every node applies it instead of its configured value.
+func (a *ApiCtx) settingsSet(fiberCtx *fiber.Ctx) error {
+	const operationType = "SETSETTING"
+
+	var req struct {
+		Name  string `json:"name" validate:"required"`
+		Value string `json:"value" validate:"required"`
+	}
+	errParse := fiberparser.ParseAndValidate(fiberCtx, &req)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+	errValidate := settings.Validate(req.Name, req.Value)
+	if errValidate != nil {
+		return jsonresponse.BadRequest(fiberCtx, errValidate.Error())
+	}
+
+	payload := &fsm.Payload{Key: req.Name, Value: req.Value, Operation: operationType}
+	errCluster := cluster.ExecuteContext(fiberCtx.UserContext(), a.Node.Consensus, payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	return jsonresponse.OK(fiberCtx, "setting saved successfully", req)
+}
+
+// settingsDelete unsets a setting, so every node applies its configured value again.
+func (a *ApiCtx) settingsDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "DELETESETTING"
+
+	name := fiberCtx.Query("name")
+	if name == "" {
+		return jsonresponse.BadRequest(fiberCtx, "name is a required query parameter")
+	}
+
+	errCluster := cluster.ExecuteContext(fiberCtx.UserContext(), a.Node.Consensus, &fsm.Payload{Key: name, Operation: operationType})
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	return jsonresponse.OK(fiberCtx, "setting deleted successfully", "")
+}
+
+// settingsGuard refuses the writes exceeding the cluster's rate limit or maximum value size.
+//
+// The size is the request's content length, so the streamed bodies aren't read.
+func (a *ApiCtx) settingsGuard(fiberCtx *fiber.Ctx) error {
+	if fiberCtx.Method() == fiber.MethodGet || fiberCtx.Method() == fiber.MethodHead {
+		return fiberCtx.Next()
+	}
+	size := fiberCtx.Request().Header.ContentLength()
+	if size < 0 {
+		size = 0
+	}
+	errWrite := settings.CheckWrite(size)
+	if errWrite != nil {
+		return a.clusterError(fiberCtx, errWrite)
+	}
+	return fiberCtx.Next()
+}
@@ -0,0 +1,119 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+)
+
+// errShardedBackup is returned by the backup routes when sharding is enabled,
+// since a backup of a single shard wouldn't contain all the keys.
+const errShardedBackup = "backups through the API aren't supported when sharding is enabled"
+
+// bucketShards returns the shards storing the keys of a bucket.
+//
+// The default bucket's keys are spread across all the shards, since they don't share a routing key.
+func (a *ApiCtx) bucketShards(bucket string) []*consensus.Shard {
+	if bucket == "" {
+		return a.Node.Shards()
+	}
+	return []*consensus.Shard{a.Node.ShardFor(bucket)}
+}
+
+// executeOnShards executes a payload on several shards, stopping at the first one which fails.
+//
+// It's used for the settings every shard needs, like the indexes or the tenants.
+func executeOnShards(ctx context.Context, shards []*consensus.Shard, payload *fsm.Payload) error {
+	for _, s := range shards {
+		p := *payload
+		errCluster := cluster.ExecuteContext(ctx, s.Consensus, &p)
+		if errCluster != nil {
+			return errCluster
+		}
+	}
+	return nil
+}
+
+func (a *ApiCtx) shardList(fiberCtx *fiber.Ctx) error {
+	info, err := a.Node.ShardsInfo()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, err.Error())
+	}
+	return jsonresponse.OK(fiberCtx, "shards retrieved successfully", info)
+}
+
+// shardSplit moves the upper half of the slots of a shard to the target shard.
+func (a *ApiCtx) shardSplit(fiberCtx *fiber.Ctx) error {
+	id, target, errQuery := shardAndTarget(fiberCtx)
+	if errQuery != nil {
+		return jsonresponse.BadRequest(fiberCtx, errQuery.Error())
+	}
+	slots, err := a.Node.SplitShard(id, target)
+	if err != nil {
+		return a.shardError(fiberCtx, err)
+	}
+	return jsonresponse.OK(fiberCtx, "shard split successfully", slots)
+}
+
+// shardMerge moves all the slots of a shard to the target shard.
+func (a *ApiCtx) shardMerge(fiberCtx *fiber.Ctx) error {
+	id, target, errQuery := shardAndTarget(fiberCtx)
+	if errQuery != nil {
+		return jsonresponse.BadRequest(fiberCtx, errQuery.Error())
+	}
+	slots, err := a.Node.MergeShard(id, target)
+	if err != nil {
+		return a.shardError(fiberCtx, err)
+	}
+	return jsonresponse.OK(fiberCtx, "shard merged successfully", slots)
+}
+
+// shardRebalance moves slots between the shards until all of them have the same number of slots.
+func (a *ApiCtx) shardRebalance(fiberCtx *fiber.Ctx) error {
+	moves, err := a.Node.Rebalance()
+	if err != nil {
+		return a.shardError(fiberCtx, err)
+	}
+	return jsonresponse.OK(fiberCtx, "shards rebalanced successfully", moves)
+}
+
+// shardMoveReplica moves the replica of a shard from a node to another one.
+func (a *ApiCtx) shardMoveReplica(fiberCtx *fiber.Ctx) error {
+	id, errParam := fiberCtx.ParamsInt("id")
+	if errParam != nil {
+		return jsonresponse.BadRequest(fiberCtx, "invalid shard: "+fiberCtx.Params("id"))
+	}
+	from, to := fiberCtx.Query("from"), fiberCtx.Query("to")
+	if from == "" || to == "" {
+		return jsonresponse.BadRequest(fiberCtx, "from and to can't be empty")
+	}
+	err := a.Node.MoveShardReplica(id, from, to)
+	if err != nil {
+		return a.shardError(fiberCtx, err)
+	}
+	return jsonresponse.OK(fiberCtx, "shard replica moved successfully", "")
+}
+
+func shardAndTarget(fiberCtx *fiber.Ctx) (int, int, error) {
+	id := fiberCtx.QueryInt("shard", -1)
+	target := fiberCtx.QueryInt("target", -1)
+	if id < 0 || target < 0 {
+		return 0, 0, errors.New("shard and target must be valid shard IDs")
+	}
+	if id == target {
+		return 0, 0, errors.New("shard and target can't be the same shard")
+	}
+	return id, target, nil
+}
+
+// shardError returns the response for an error returned by a shard operation.
+func (a *ApiCtx) shardError(fiberCtx *fiber.Ctx, err error) error {
+	if errors.Is(err, consensus.ErrShardNotFound) {
+		return jsonresponse.BadRequest(fiberCtx, err.Error())
+	}
+	return a.clusterError(fiberCtx, err)
+}
@@ -0,0 +1,71 @@
+package route
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/valuecrypt"
+)
+
+// storeStreamSet sets a key to the value streamed in the request's body, in the request's content type,
+// applying its chunks as they're received, so large values aren't held in memory.
+func (a *ApiCtx) storeStreamSet(fiberCtx *fiber.Ctx) error {
+	key := fiberCtx.Query("key")
+	if key == "" {
+		return jsonresponse.BadRequest(fiberCtx, "key is a required query parameter")
+	}
+	contentType := fiberCtx.Get(fiber.HeaderContentType)
+	if contentType == "" {
+		return jsonresponse.BadRequest(fiberCtx, "the content type of the value is required")
+	}
+	if errKey := fsm.CheckUserKey(key); errKey != nil {
+		return a.clusterError(fiberCtx, errKey)
+	}
+
+	s := a.Node.ShardFor(key)
+	if a.Crypter.IsEncrypted(s.FSM, key) {
+		return a.clusterError(fiberCtx, valuecrypt.ErrStreamEncrypted)
+	}
+
+	index, errCluster := cluster.ExecuteStream(s.Consensus, key, contentType, fiberCtx.Context().RequestBodyStream())
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	setCommitIndex(fiberCtx, s, index)
+
+	return jsonresponse.OK(fiberCtx, "data persisted successfully", "")
+}
+
+// storeStreamGet streams the value of a key in its content type, reading its chunks as they're sent.
+//
+// The values without a declared content type are sent as JSON.
+func (a *ApiCtx) storeStreamGet(fiberCtx *fiber.Ctx) error {
+	key := fiberCtx.Query("key")
+	if key == "" {
+		return jsonresponse.BadRequest(fiberCtx, "key is a required query parameter")
+	}
+
+	s := a.Node.ShardFor(key)
+	if a.Crypter.IsEncrypted(s.FSM, key) {
+		return a.clusterError(fiberCtx, valuecrypt.ErrStreamEncrypted)
+	}
+
+	r, contentType, errOpen := s.FSM.OpenValue(key)
+	if errOpen != nil {
+		if errors.Is(errOpen, engine.ErrKeyNotFound) {
+			return jsonresponse.NotFound(fiberCtx, "key doesn't exist")
+		}
+		return jsonresponse.ServerError(fiberCtx, "couldn't get key from DB: "+errOpen.Error())
+	}
+	if contentType == "" {
+		contentType = fsm.ContentTypeJSON
+	}
+
+	// The size isn't known in advance, so the value is sent with the chunked transfer encoding.
+	fiberCtx.Set(fiber.HeaderContentType, contentType)
+	fiberCtx.Status(fiber.StatusOK).Context().SetBodyStream(r, -1)
+	return nil
+}
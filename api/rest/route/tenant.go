@@ -0,0 +1,82 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+)
+
+func (a *ApiCtx) tenantList(fiberCtx *fiber.Ctx) error {
+	var tenants []fsm.TenantInfo
+	for _, s := range a.Node.Shards() {
+		shardTenants, err := s.FSM.GetTenants()
+		if err != nil {
+			return jsonresponse.ServerError(fiberCtx, "couldn't get tenants from DB: "+err.Error())
+		}
+		tenants = mergeTenants(tenants, shardTenants)
+	}
+	return jsonresponse.OK(fiberCtx, "tenants retrieved successfully", tenants)
+}
+
+func (a *ApiCtx) tenantSet(fiberCtx *fiber.Ctx) error {
+	const operationType = "SETTENANT"
+
+	tenant := new(fsm.Tenant)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, tenant)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+	// Each shard only counts the usage of its own buckets, so it enforces an even part of the quotas.
+	tenant.Shards = len(a.Node.Shards())
+
+	payload := &fsm.Payload{
+		Key:       tenant.Name,
+		Value:     tenant,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "tenant saved successfully", "")
+}
+
+func (a *ApiCtx) tenantDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "DELETETENANT"
+
+	name := fiberCtx.Query("name")
+	if name == "" {
+		return jsonresponse.BadRequest(fiberCtx, "name is a required query parameter")
+	}
+
+	payload := &fsm.Payload{
+		Key:       name,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "tenant deleted successfully", "")
+}
+
+// mergeTenants adds the usage of the tenants of a shard to the ones of the previous shards.
+//
+// Every shard stores the same tenants, with the usage of the buckets it stores.
+func mergeTenants(tenants []fsm.TenantInfo, shardTenants []fsm.TenantInfo) []fsm.TenantInfo {
+	if tenants == nil {
+		return shardTenants
+	}
+	for _, st := range shardTenants {
+		for i := range tenants {
+			if tenants[i].Name == st.Name {
+				tenants[i].Usage.Bytes += st.Usage.Bytes
+				tenants[i].Usage.Keys += st.Usage.Keys
+			}
+		}
+	}
+	return tenants
+}
@@ -0,0 +1,72 @@
+package route
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+)
+
+func (a *ApiCtx) storeUndelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "UNDELETE"
+
+	if a.Config.SoftDelete.Retention <= 0 {
+		return jsonresponse.BadRequest(fiberCtx, "soft delete is disabled")
+	}
+
+	payload := new(fsm.Payload)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, payload)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+	if errKey := fsm.CheckUserKey(payload.Key); errKey != nil {
+		return a.clusterError(fiberCtx, errKey)
+	}
+	payload.Operation = operationType
+	payload.Value = nil
+
+	s := a.Node.ShardFor(payload.Key)
+	index, errCluster := cluster.ExecuteIndexContext(fiberCtx.UserContext(), s.Consensus, payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+	setCommitIndex(fiberCtx, s, index)
+
+	return jsonresponse.OK(fiberCtx, "data undeleted successfully", "")
+}
+
+func (a *ApiCtx) storeDeleted(fiberCtx *fiber.Ctx) error {
+	page, errPage := a.readPage(fiberCtx, "deleted")
+	if errPage != nil {
+		return jsonresponse.BadRequest(fiberCtx, errPage.Error())
+	}
+
+	byKey := make(map[string]fsm.Tombstone)
+	for _, s := range a.Node.Shards() {
+		shardTombstones, err := s.FSM.GetTombstones()
+		if err != nil {
+			return jsonresponse.ServerError(fiberCtx, "couldn't get deleted keys from DB: "+err.Error())
+		}
+		for _, t := range shardTombstones {
+			byKey[t.Key] = t
+		}
+	}
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	keys, token, errToken := a.cutPage(page, keys)
+	if errToken != nil {
+		return jsonresponse.ServerError(fiberCtx, errToken.Error())
+	}
+	if len(keys) <= 0 && page.isFirst() {
+		return jsonresponse.NotFound(fiberCtx, "no deleted keys in DB")
+	}
+
+	tombstones := make([]fsm.Tombstone, 0, len(keys))
+	for _, k := range keys {
+		tombstones = append(tombstones, byKey[k])
+	}
+	return jsonresponse.Page(fiberCtx, "data retrieved successfully", tombstones, token)
+}
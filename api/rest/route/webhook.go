@@ -0,0 +1,83 @@
+package route
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gofiber/fiber/v2"
+	"github.com/narvikd/fiberparser"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus/fsm"
+)
+
+// webhookList returns the webhooks without their secrets, every shard stores the same webhooks.
+func (a *ApiCtx) webhookList(fiberCtx *fiber.Ctx) error {
+	hooks, err := a.Node.FSM.GetWebhooks()
+	if err != nil {
+		return jsonresponse.ServerError(fiberCtx, "couldn't get webhooks from DB: "+err.Error())
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return jsonresponse.OK(fiberCtx, "webhooks retrieved successfully", hooks)
+}
+
+// webhookSet creates or updates a webhook, if it doesn't have a secret one is generated and returned.
+func (a *ApiCtx) webhookSet(fiberCtx *fiber.Ctx) error {
+	const operationType = "SETWEBHOOK"
+
+	hook := new(fsm.Webhook)
+	errParse := fiberparser.ParseAndValidate(fiberCtx, hook)
+	if errParse != nil {
+		return jsonresponse.BadRequest(fiberCtx, errParse.Error())
+	}
+	if hook.Secret == "" {
+		secret, errSecret := newWebhookSecret()
+		if errSecret != nil {
+			return jsonresponse.ServerError(fiberCtx, "couldn't generate secret: "+errSecret.Error())
+		}
+		hook.Secret = secret
+	}
+
+	payload := &fsm.Payload{
+		Key:       hook.Name,
+		Value:     hook,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "webhook saved successfully", hook)
+}
+
+func (a *ApiCtx) webhookDelete(fiberCtx *fiber.Ctx) error {
+	const operationType = "DELETEWEBHOOK"
+
+	name := fiberCtx.Query("name")
+	if name == "" {
+		return jsonresponse.BadRequest(fiberCtx, "name is a required query parameter")
+	}
+
+	payload := &fsm.Payload{
+		Key:       name,
+		Operation: operationType,
+	}
+	errCluster := executeOnShards(fiberCtx.UserContext(), a.Node.Shards(), payload)
+	if errCluster != nil {
+		return a.clusterError(fiberCtx, errCluster)
+	}
+
+	return jsonresponse.OK(fiberCtx, "webhook deleted successfully", "")
+}
+
+// newWebhookSecret returns a random hex encoded secret to sign the changes sent to a webhook.
+func newWebhookSecret() (string, error) {
+	const secretSize = 32
+	b := make([]byte, secretSize)
+	_, errRead := rand.Read(b)
+	if errRead != nil {
+		return "", errRead
+	}
+	return hex.EncodeToString(b), nil
+}
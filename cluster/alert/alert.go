@@ -0,0 +1,252 @@
+// Package alert fires alerts when a shard stays without a leader, or the cluster can't reach a quorum,
+// for longer than configured, or the node is read-only because it's low on disk space,
+// and again once the condition is resolved.
+//
+// The leader changes are received from the consensus observers, and the quorum is checked periodically.
+// Every alert is logged at error level with structured fields, and optionally POSTed to a webhook and passed to a script.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-hclog"
+	"net/http"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/diskwatch"
+	"nubedb/internal/config"
+	"nubedb/internal/metrics"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Conditions which are alerted.
+const (
+	NoLeader   = "no_leader"
+	QuorumLoss = "quorum_loss"
+	DiskLow    = "disk_low"
+)
+
+// Statuses of the alerts.
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+const (
+	// checkInterval is how often the conditions are evaluated.
+	checkInterval = 1 * time.Second
+	// quorumCheckInterval is how often the quorum is checked, since it resolves every server of the consensus.
+	quorumCheckInterval = 5 * time.Second
+)
+
+// quorumShard is the shard the quorum alerts refer to, the quorum is checked on the consensus of shard 0.
+const quorumShard = 0
+
+// Alert is a condition which started firing or was resolved.
+type Alert struct {
+	Condition string    `json:"condition"`
+	Status    string    `json:"status"`
+	Node      string    `json:"node"`
+	Shard     int       `json:"shard"`
+	Since     time.Time `json:"since"`
+	// Duration is how long the condition lasted when the alert was sent.
+	Duration string `json:"duration"`
+}
+
+// condition tracks since when a condition holds, and whether its alert was fired.
+type condition struct {
+	since time.Time
+	fired bool
+}
+
+type alerter struct {
+	node   *consensus.Node
+	cfg    config.AlertCfg
+	logger hclog.Logger
+	client *http.Client
+	// noLeader holds the conditions of each shard.
+	noLeader        map[int]*condition
+	quorumLoss      condition
+	lastQuorumCheck time.Time
+	// watchDisk is whether the disk space is watched, its alert fires as soon as the node becomes read-only.
+	watchDisk bool
+	diskLow   condition
+}
+
+// Start watches the leader of every shard and the quorum of the cluster, and the disk space of the node
+// if watchDisk is set, firing the alerts, blocks indefinitely.
+func Start(node *consensus.Node, cfg config.AlertCfg, watchDisk bool) {
+	if cfg.NoLeaderAfter <= 0 && cfg.QuorumLossAfter <= 0 && !watchDisk {
+		return
+	}
+	a := &alerter{
+		node:      node,
+		cfg:       cfg,
+		logger:    hclog.New(&hclog.LoggerOptions{Name: "alert", Output: os.Stderr}),
+		client:    &http.Client{Timeout: cfg.Timeout},
+		noLeader:  make(map[int]*condition),
+		watchDisk: watchDisk,
+	}
+	now := time.Now()
+	for _, s := range node.Shards() {
+		c := new(condition)
+		if _, leaderID := s.Consensus.LeaderWithID(); leaderID == "" {
+			c.since = now
+		}
+		a.noLeader[s.ID] = c
+	}
+
+	events, unsubscribe := node.SubscribeEvents()
+	defer unsubscribe()
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-events:
+			if e.Type == consensus.EventLeaderChange {
+				a.leaderChanged(e)
+			}
+		case <-ticker.C:
+			a.check()
+		}
+	}
+}
+
+// leaderChanged starts the no leader condition when the leader is lost, and resolves it when there's a new one.
+func (a *alerter) leaderChanged(e consensus.ClusterEvent) {
+	c, ok := a.noLeader[e.Shard]
+	if !ok {
+		return
+	}
+	if e.LeaderID == "" {
+		if c.since.IsZero() {
+			c.since = e.Time
+		}
+		return
+	}
+	a.resolve(NoLeader, e.Shard, c)
+}
+
+// check fires the alerts of the conditions which lasted too long.
+func (a *alerter) check() {
+	if a.cfg.NoLeaderAfter > 0 {
+		for shardID, c := range a.noLeader {
+			if !c.since.IsZero() && time.Since(c.since) >= a.cfg.NoLeaderAfter {
+				a.fire(NoLeader, shardID, c)
+			}
+		}
+	}
+
+	if a.watchDisk {
+		if diskwatch.IsLow() {
+			if a.diskLow.since.IsZero() {
+				a.diskLow.since = time.Now()
+			}
+			a.fire(DiskLow, quorumShard, &a.diskLow)
+		} else {
+			a.resolve(DiskLow, quorumShard, &a.diskLow)
+		}
+	}
+
+	if a.cfg.QuorumLossAfter <= 0 || time.Since(a.lastQuorumCheck) < quorumCheckInterval {
+		return
+	}
+	a.lastQuorumCheck = time.Now()
+	if a.node.IsQuorumPossible(false) {
+		a.resolve(QuorumLoss, quorumShard, &a.quorumLoss)
+		return
+	}
+	if a.quorumLoss.since.IsZero() {
+		a.quorumLoss.since = time.Now()
+	}
+	if time.Since(a.quorumLoss.since) >= a.cfg.QuorumLossAfter {
+		a.fire(QuorumLoss, quorumShard, &a.quorumLoss)
+	}
+}
+
+func (a *alerter) fire(name string, shardID int, c *condition) {
+	if c.fired {
+		return
+	}
+	c.fired = true
+	a.send(Alert{Condition: name, Status: Firing, Node: a.node.ID, Shard: shardID, Since: c.since,
+		Duration: time.Since(c.since).Round(time.Second).String()})
+}
+
+// resolve clears a condition, sending the resolved alert if it was fired.
+func (a *alerter) resolve(name string, shardID int, c *condition) {
+	if c.fired {
+		a.send(Alert{Condition: name, Status: Resolved, Node: a.node.ID, Shard: shardID, Since: c.since,
+			Duration: time.Since(c.since).Round(time.Second).String()})
+	}
+	*c = condition{}
+}
+
+// send runs the actions of an alert. The failures of the webhook and the script are logged, without retrying them.
+func (a *alerter) send(alert Alert) {
+	metrics.RecordAlert(alert.Condition, alert.Status)
+	fields := []any{
+		"condition", alert.Condition, "status", alert.Status, "node", alert.Node, "shard", alert.Shard,
+		"since", alert.Since.Format(time.RFC3339), "duration", alert.Duration,
+	}
+	if alert.Status == Firing {
+		a.logger.Error("cluster alert firing", fields...)
+	} else {
+		a.logger.Info("cluster alert resolved", fields...)
+	}
+
+	b, errMarshal := json.Marshal(alert)
+	if errMarshal != nil {
+		a.logger.Error("couldn't encode alert", "error", errMarshal)
+		return
+	}
+	if a.cfg.WebhookURL != "" {
+		errWebhook := a.postWebhook(b)
+		if errWebhook != nil {
+			a.logger.Error("couldn't send alert to the webhook", "condition", alert.Condition, "error", errWebhook)
+		}
+	}
+	if a.cfg.Exec != "" {
+		errExec := a.runScript(alert, b)
+		if errExec != nil {
+			a.logger.Error("couldn't run the alert script", "condition", alert.Condition, "error", errExec)
+		}
+	}
+}
+
+func (a *alerter) postWebhook(body []byte) error {
+	res, errPost := a.client.Post(a.cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if errPost != nil {
+		return errPost
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status code %v", res.StatusCode)
+	}
+	return nil
+}
+
+// runScript runs the alert script, with the alert as JSON in its stdin and its fields in NUBEDB_ALERT_* variables.
+func (a *alerter) runScript(alert Alert, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, a.cfg.Exec)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"NUBEDB_ALERT_CONDITION="+alert.Condition,
+		"NUBEDB_ALERT_STATUS="+alert.Status,
+		"NUBEDB_ALERT_NODE="+alert.Node,
+		"NUBEDB_ALERT_SHARD="+strconv.Itoa(alert.Shard),
+		"NUBEDB_ALERT_SINCE="+alert.Since.Format(time.RFC3339),
+		"NUBEDB_ALERT_DURATION="+alert.Duration,
+	)
+	out, errRun := cmd.CombinedOutput()
+	if errRun != nil {
+		return fmt.Errorf("%w: %s", errRun, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+// Package antientropy verifies that the replicas of each shard store the same data,
+// to find the divergences caused by bugs or disk corruption, which the consensus can't detect.
+//
+// The leader of each shard replicates a DIGEST log, so every replica computes a merkle tree of its keyspace
+// after applying the same logs. The digests are then collected and compared: the replicas which disagree
+// with the majority are reported, and optionally reinstalled, so they are rebuilt from a snapshot.
+package antientropy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"log"
+	"net/http"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/config"
+	"nubedb/internal/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotLeader is returned when a check is requested on a node which doesn't lead any shard.
+var ErrNotLeader = errcode.New(errcode.NotLeader, "this node isn't the leader of any shard, send the request to a leader")
+
+// Replica is the result of the check of a replica.
+type Replica struct {
+	Node    string `json:"node"`
+	Witness bool   `json:"witness,omitempty"`
+	Root    string `json:"root,omitempty"`
+	Keys    int    `json:"keys"`
+	Error   string `json:"error,omitempty"`
+	// Divergent is true when the replica's data differs from the majority's.
+	Divergent bool `json:"divergent"`
+	// Buckets are the buckets of the digest which differ from the majority's.
+	Buckets  []int `json:"buckets,omitempty"`
+	Repaired bool  `json:"repaired"`
+}
+
+// Report is the result of the check of a shard.
+type Report struct {
+	Shard    int       `json:"shard"`
+	DigestID string    `json:"digestId"`
+	Result   string    `json:"result"`
+	At       time.Time `json:"at"`
+	Replicas []Replica `json:"replicas"`
+}
+
+// reports holds the last report of each shard checked by this node.
+var reports = struct {
+	mu      sync.Mutex
+	byShard map[int]Report
+}{byShard: make(map[int]Report)}
+
+// Start checks the shards this node leads every interval, blocks indefinitely. A zero interval disables it.
+func Start(node *consensus.Node, cfg config.AntiEntropyCfg) {
+	if cfg.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_, errVerify := Verify(node, cfg)
+		if errVerify != nil && !errors.Is(errVerify, ErrNotLeader) {
+			log.Println("[antientropy] couldn't verify the replicas:", errVerify)
+		}
+	}
+}
+
+// Verify checks the replicas of the shards this node leads, returning their reports.
+func Verify(node *consensus.Node, cfg config.AntiEntropyCfg) ([]Report, error) {
+	var checked []Report
+	for _, s := range node.Shards() {
+		if s.Consensus.State() != raft.Leader {
+			continue
+		}
+		report, errShard := verifyShard(node, s, cfg)
+		if errShard != nil {
+			return checked, fmt.Errorf("shard %v: %w", s.ID, errShard)
+		}
+		checked = append(checked, report)
+	}
+	if len(checked) <= 0 {
+		return nil, ErrNotLeader
+	}
+	return checked, nil
+}
+
+// Reports returns the last report of each shard checked by this node, sorted by shard.
+func Reports() []Report {
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+	list := make([]Report, 0, len(reports.byShard))
+	for _, r := range reports.byShard {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Shard < list[j].Shard })
+	return list
+}
+
+func verifyShard(node *consensus.Node, s *consensus.Shard, cfg config.AntiEntropyCfg) (Report, error) {
+	servers, errServers := s.Replicas()
+	if errServers != nil {
+		return Report{}, errServers
+	}
+	id := fmt.Sprintf("%v-%v", s.ID, time.Now().UnixNano())
+	errDigest := cluster.Execute(s.Consensus, &fsm.Payload{Key: id, Operation: "DIGEST"})
+	if errDigest != nil {
+		return Report{}, errDigest
+	}
+
+	var voters []string
+	for _, srv := range servers {
+		if srv.Suffrage == raft.Voter {
+			voters = append(voters, string(srv.ID))
+		}
+	}
+	digests := make([]*fsm.Digest, len(voters))
+	replicas := make([]Replica, len(voters))
+	var wg sync.WaitGroup
+	for i, voter := range voters {
+		wg.Add(1)
+		go func(i int, voter string) {
+			defer wg.Done()
+			replicas[i] = Replica{Node: voter}
+			d, witness, errGet := waitDigest(node, s, voter, id, cfg.Timeout)
+			switch {
+			case errGet != nil:
+				replicas[i].Error = errGet.Error()
+			case witness:
+				replicas[i].Witness = true
+			default:
+				digests[i] = &d
+				replicas[i].Root = d.Root
+				replicas[i].Keys = d.Keys
+			}
+		}(i, voter)
+	}
+	wg.Wait()
+
+	report := Report{Shard: s.ID, DigestID: id, At: time.Now(), Replicas: replicas}
+	report.Result = compare(report.Replicas, digests)
+	divergent := 0
+	for i := range report.Replicas {
+		r := &report.Replicas[i]
+		if !r.Divergent {
+			continue
+		}
+		divergent++
+		log.Printf("[antientropy] replica '%s' of shard %v diverged from the majority in %v buckets\n",
+			r.Node, s.ID, len(r.Buckets))
+		if cfg.Repair {
+			r.Repaired = repair(node, r.Node)
+		}
+	}
+	metrics.RecordAntiEntropyCheck(report.Result, divergent)
+
+	reports.mu.Lock()
+	reports.byShard[s.ID] = report
+	reports.mu.Unlock()
+	return report, nil
+}
+
+// compare flags the replicas which differ from the majority, returning the result of the check.
+//
+// Without a majority of the replicas storing data agreeing, no replica is flagged, since it's unknown which is right.
+func compare(replicas []Replica, digests []*fsm.Digest) string {
+	dataReplicas := 0
+	votes := make(map[string]int)
+	var majority *fsm.Digest
+	for i, d := range digests {
+		if replicas[i].Witness {
+			continue
+		}
+		dataReplicas++
+		if d == nil {
+			continue
+		}
+		votes[d.Root]++
+	}
+	for _, d := range digests {
+		if d != nil && votes[d.Root] > dataReplicas/2 {
+			majority = d
+			break
+		}
+	}
+
+	result := metrics.AntiEntropyConsistent
+	if len(votes) > 1 {
+		result = metrics.AntiEntropyDivergent
+	}
+	for i, d := range digests {
+		if d == nil {
+			if !replicas[i].Witness && result == metrics.AntiEntropyConsistent {
+				result = metrics.AntiEntropyIncomplete
+			}
+			continue
+		}
+		if majority == nil || d.Root == majority.Root {
+			continue
+		}
+		replicas[i].Divergent = true
+		for b := range d.Buckets {
+			if d.Buckets[b] != majority.Buckets[b] {
+				replicas[i].Buckets = append(replicas[i].Buckets, b)
+			}
+		}
+	}
+	return result
+}
+
+// repair reinstalls a divergent replica, so it rejoins the cluster and is rebuilt from a snapshot.
+//
+// The leader doesn't reinstall itself, since it would leave the shard without a leader while it's checked.
+func repair(node *consensus.Node, id string) bool {
+	if id == node.ID {
+		log.Println("[antientropy] this node diverged, it must be reinstalled manually after transferring the leadership")
+		return false
+	}
+	errReinstall := cluster.RequestNodeReinstall(config.MakeGrpcAddress(id))
+	if errReinstall != nil {
+		log.Printf("[antientropy] couldn't reinstall '%s': %v\n", id, errReinstall)
+		return false
+	}
+	log.Printf("[antientropy] reinstall of '%s' requested\n", id)
+	return true
+}
+
+// waitDigest waits until a replica computed a digest, which happens once it applies the DIGEST log.
+func waitDigest(node *consensus.Node, s *consensus.Shard, id string, digestID string, timeout time.Duration) (fsm.Digest, bool, error) {
+	const pollInterval = 500 * time.Millisecond
+	deadline := time.Now().Add(timeout)
+	for {
+		d, witness, errGet := getDigest(node, s, id, digestID)
+		if errGet == nil || !errors.Is(errGet, fsm.ErrDigestNotFound) || time.Now().After(deadline) {
+			return d, witness, errGet
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func getDigest(node *consensus.Node, s *consensus.Shard, id string, digestID string) (fsm.Digest, bool, error) {
+	if id == node.ID {
+		if node.IsWitness() {
+			return fsm.Digest{}, true, nil
+		}
+		d, errGet := s.FSM.GetDigest(digestID)
+		return d, false, errGet
+	}
+
+	const timeout = 5 * time.Second
+	client := config.NewNodeAPIClient(timeout)
+	url := config.MakeApiURL(id, fmt.Sprintf("/admin/antientropy/digests/%s?shard=%v", digestID, s.ID))
+	res, errReq := client.Get(url)
+	if errReq != nil {
+		return fsm.Digest{}, false, errReq
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fsm.Digest{}, false, fsm.ErrDigestNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return fsm.Digest{}, false, fmt.Errorf("node answered with status code %v", res.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Witness bool `json:"witness"`
+			fsm.Digest
+		} `json:"data"`
+	}
+	errDecode := json.NewDecoder(res.Body).Decode(&body)
+	if errDecode != nil {
+		return fsm.Digest{}, false, errDecode
+	}
+	return body.Data.Digest, body.Data.Witness, nil
+}
@@ -0,0 +1,160 @@
+// Package backup is responsible for shipping the database backups to an object storage,
+// and for restoring them from it.
+package backup
+
+import (
+	"context"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"io"
+	"log"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/config"
+	"nubedb/pkg/objectstore"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// nameLayout is the time layout used in the backups names, so sorting them by name also sorts them by time.
+	nameLayout = "20060102T150405Z"
+	nameSuffix = ".json"
+	// timeout is the maximum time an operation on the object storage can take.
+	timeout = 10 * time.Minute
+)
+
+// ErrNoBackups is returned when the object storage doesn't have any backup.
+var ErrNoBackups = errcode.New(errcode.NotFound, "no backups found in the object storage")
+
+// StartShipping ships a backup of the database to the object storage while the node is the leader,
+// blocks indefinitely.
+//
+// If no object storage is configured, it returns immediately.
+func StartShipping(consensus *raft.Raft, dbFSM *fsm.DatabaseFSM, cfg config.BackupCfg) error {
+	if cfg.Store.Provider == "" {
+		return nil
+	}
+
+	store, errStore := objectstore.New(cfg.Store)
+	if errStore != nil {
+		return errStore
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if consensus.State() != raft.Leader {
+			continue
+		}
+		name, errShip := Ship(store, dbFSM, cfg.Prefix)
+		if errShip != nil {
+			log.Println("[backup] couldn't ship backup:", errShip)
+			continue
+		}
+		log.Println("[backup] backup shipped:", name)
+
+		errPrune := Prune(store, cfg.Prefix, cfg.Retain)
+		if errPrune != nil {
+			log.Println("[backup] couldn't prune old backups:", errPrune)
+		}
+	}
+
+	return nil
+}
+
+// Ship uploads a new backup of the database to the object storage and returns its name.
+//
+// The backup is streamed while it's written, so it isn't held in memory.
+func Ship(store objectstore.Store, dbFSM *fsm.DatabaseFSM, prefix string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		_ = pw.CloseWithError(dbFSM.WriteBackup(pw))
+	}()
+
+	name := prefix + time.Now().UTC().Format(nameLayout) + nameSuffix
+	errPut := store.Put(ctx, name, pr, -1)
+	if errPut != nil {
+		return "", errorskit.Wrap(errPut, "couldn't upload backup")
+	}
+
+	return name, nil
+}
+
+// Prune deletes the oldest backups, keeping the latest retain ones.
+//
+// Only the objects named like the backups are considered, the other ones under prefix are left untouched.
+func Prune(store objectstore.Store, prefix string, retain int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	names, errList := list(ctx, store, prefix)
+	if errList != nil {
+		return errList
+	}
+
+	for i := 0; i < len(names)-retain; i++ {
+		errDelete := store.Delete(ctx, names[i])
+		if errDelete != nil {
+			return errorskit.Wrap(errDelete, "couldn't delete backup "+names[i])
+		}
+	}
+
+	return nil
+}
+
+// RestoreLatest restores the latest backup from the object storage into the database and returns its name.
+//
+// The backup is restored locally, without going through the consensus, while it's downloaded.
+func RestoreLatest(store objectstore.Store, dbFSM *fsm.DatabaseFSM, prefix string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	names, errList := list(ctx, store, prefix)
+	if errList != nil {
+		return "", errList
+	}
+	if len(names) <= 0 {
+		return "", ErrNoBackups
+	}
+	name := names[len(names)-1]
+
+	r, errGet := store.Get(ctx, name)
+	if errGet != nil {
+		return "", errorskit.Wrap(errGet, "couldn't download backup "+name)
+	}
+	defer r.Close()
+
+	errRestore := dbFSM.RestoreBackup(r)
+	if errRestore != nil {
+		return "", errorskit.Wrap(errRestore, "couldn't restore backup "+name)
+	}
+	return name, nil
+}
+
+// list returns the names of the backups under prefix, sorted from the oldest to the latest.
+func list(ctx context.Context, store objectstore.Store, prefix string) ([]string, error) {
+	objects, errList := store.List(ctx, prefix)
+	if errList != nil {
+		return nil, errorskit.Wrap(errList, "couldn't list backups")
+	}
+
+	var names []string
+	for _, name := range objects {
+		ts, isBackup := strings.CutSuffix(strings.TrimPrefix(name, prefix), nameSuffix)
+		if !isBackup {
+			continue
+		}
+		if _, errParse := time.Parse(nameLayout, ts); errParse != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,118 @@
+// Package cdc is responsible for publishing the committed changes of the database to external systems.
+//
+// Changes are published with at-least-once delivery: the leader publishes the pending changes,
+// and only then, it replicates a checkpoint with the last published index.
+//
+// If the leader goes down between both steps, the next leader will publish those changes again.
+package cdc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"log"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"time"
+)
+
+// consumerName is the name used to store the checkpoint of the CDC publisher.
+const consumerName = "cdc"
+
+// Publisher publishes changes to an external system.
+type Publisher interface {
+	// Publish must only return once all the changes are acknowledged by the external system.
+	Publish(changes []fsm.ChangeEvent) error
+	Close() error
+}
+
+// newPublisher returns the publisher for the configured sink.
+func newPublisher(cfg config.CDCCfg) (Publisher, error) {
+	switch cfg.Sink {
+	case "nats":
+		return newNatsPublisher(cfg)
+	case "kafka":
+		return newKafkaPublisher(cfg), nil
+	default:
+		return nil, fmt.Errorf("cdc sink not recognized: %s", cfg.Sink)
+	}
+}
+
+// Start publishes the changes of the database while the node is the leader, blocks indefinitely.
+//
+// If no sink is configured, it returns immediately.
+func Start(node *consensus.Node, cfg config.CDCCfg) error {
+	if cfg.Sink == "" {
+		return nil
+	}
+
+	publisher, errPublisher := newPublisher(cfg)
+	if errPublisher != nil {
+		return errPublisher
+	}
+	defer publisher.Close()
+
+	Tail(node, consumerName, publisher, cfg.Interval, cfg.BatchSize)
+	return nil
+}
+
+// Tail publishes the changes of the database while the node is the leader, blocks indefinitely.
+//
+// Every consumer has its own replicated checkpoint, so the same changes can be published to multiple systems.
+func Tail(node *consensus.Node, consumer string, publisher Publisher, interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if node.Consensus.State() != raft.Leader {
+			continue
+		}
+		err := PublishPending(node.Consensus, node.FSM, consumer, publisher, batchSize)
+		if err != nil {
+			log.Printf("[%s] couldn't publish changes: %v\n", consumer, err)
+		}
+	}
+}
+
+// PublishPending publishes the changes of a consensus after the checkpoint, and replicates the new checkpoint.
+//
+// If the publisher wasn't registered yet, it registers itself starting from the last applied index.
+func PublishPending(
+	consensus *raft.Raft, dbFSM *fsm.DatabaseFSM, consumer string, publisher Publisher, batchSize int,
+) error {
+	checkpoint, errCheckpoint := dbFSM.GetCheckpoint(consumer)
+	if errCheckpoint != nil {
+		if errors.Is(errCheckpoint, engine.ErrKeyNotFound) {
+			log.Printf("[%s] registering publisher, changes will be published from now on\n", consumer)
+			return setCheckpoint(consensus, consumer, consensus.AppliedIndex())
+		}
+		return errorskit.Wrap(errCheckpoint, "couldn't get checkpoint")
+	}
+
+	changes, errChanges := dbFSM.GetChanges(checkpoint, batchSize)
+	if errChanges != nil {
+		return errChanges
+	}
+	if len(changes) <= 0 {
+		return nil
+	}
+
+	errPublish := publisher.Publish(changes)
+	if errPublish != nil {
+		return errorskit.Wrap(errPublish, "couldn't publish to sink")
+	}
+
+	return setCheckpoint(consensus, consumer, changes[len(changes)-1].Index)
+}
+
+func setCheckpoint(consensus *raft.Raft, consumer string, index uint64) error {
+	const operationType = "CHECKPOINT"
+	return cluster.Execute(consensus, &fsm.Payload{
+		Key:       consumer,
+		Value:     index,
+		Operation: operationType,
+	})
+}
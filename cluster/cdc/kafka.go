@@ -0,0 +1,50 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/segmentio/kafka-go"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"strings"
+	"time"
+)
+
+// kafkaPublisher publishes the changes as messages on a Kafka topic, using the key of the change as the message key.
+//
+// Since the messages of the same key go to the same partition, their order is kept.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(cfg config.CDCCfg) *kafkaPublisher {
+	return &kafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(cfg.Address, ",")...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+// Publish publishes the changes, it only returns once all the in-sync replicas acknowledged them.
+func (p *kafkaPublisher) Publish(changes []fsm.ChangeEvent) error {
+	const timeout = 10 * time.Second
+	msgs := make([]kafka.Message, 0, len(changes))
+	for _, change := range changes {
+		b, errMarshal := json.Marshal(change)
+		if errMarshal != nil {
+			return errMarshal
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(change.Key), Value: b})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, msgs...)
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
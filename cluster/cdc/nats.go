@@ -0,0 +1,45 @@
+package cdc
+
+import (
+	"encoding/json"
+	"github.com/narvikd/errorskit"
+	"github.com/nats-io/nats.go"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"time"
+)
+
+// natsPublisher publishes the changes as messages on a NATS subject.
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func newNatsPublisher(cfg config.CDCCfg) (*natsPublisher, error) {
+	conn, errConn := nats.Connect(cfg.Address, nats.Name("nubedb-cdc"), nats.MaxReconnects(-1))
+	if errConn != nil {
+		return nil, errorskit.Wrap(errConn, "couldn't connect to nats")
+	}
+	return &natsPublisher{conn: conn, subject: cfg.Topic}, nil
+}
+
+// Publish publishes the changes and flushes the connection, so it only returns once the server received them.
+func (p *natsPublisher) Publish(changes []fsm.ChangeEvent) error {
+	const flushTimeout = 5 * time.Second
+	for _, change := range changes {
+		b, errMarshal := json.Marshal(change)
+		if errMarshal != nil {
+			return errMarshal
+		}
+		errPublish := p.conn.Publish(p.subject, b)
+		if errPublish != nil {
+			return errPublish
+		}
+	}
+	return p.conn.FlushTimeout(flushTimeout)
+}
+
+func (p *natsPublisher) Close() error {
+	p.conn.Close()
+	return nil
+}
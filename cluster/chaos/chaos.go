@@ -0,0 +1,98 @@
+// Package chaos injects artificial failures into the node, so operators can rehearse failure modes in staging clusters.
+//
+// The faults can only be set if chaos was enabled, otherwise the hooks are no-ops.
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"nubedb/cluster/errcode"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDisabled is returned when setting faults on a node which doesn't have chaos enabled.
+var ErrDisabled = errcode.New(errcode.NotEnabled, "chaos isn't enabled on this node")
+
+// Faults are the failures injected into the node.
+type Faults struct {
+	// ApplyDelay delays the application of every consensus log to the FSM.
+	ApplyDelay time.Duration
+	// ForwardDropRate is the fraction of the writes forwarded to the leader which are dropped, from 0 to 1.
+	ForwardDropRate float64
+	// FSMPaused stops applying the consensus logs to the FSM, until it's unset.
+	FSMPaused bool
+}
+
+var (
+	enabled atomic.Bool
+	mu      sync.Mutex
+	resumed = sync.NewCond(&mu)
+	faults  Faults
+)
+
+// Enable allows setting faults on the node.
+func Enable() {
+	enabled.Store(true)
+}
+
+// Enabled returns whether faults can be set on the node.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Set replaces the faults injected into the node.
+func Set(f Faults) error {
+	if !Enabled() {
+		return ErrDisabled
+	}
+	if f.ApplyDelay < 0 || f.ForwardDropRate < 0 || f.ForwardDropRate > 1 {
+		return errors.New("the apply delay can't be negative, and the forward drop rate must be between 0 and 1")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	faults = f
+	if !f.FSMPaused {
+		resumed.Broadcast()
+	}
+	return nil
+}
+
+// Get returns the faults injected into the node.
+func Get() Faults {
+	mu.Lock()
+	defer mu.Unlock()
+	return faults
+}
+
+// Reset stops injecting faults.
+func Reset() {
+	_ = Set(Faults{})
+}
+
+// BeforeApply is called by the FSM before applying a log, it blocks while the FSM is paused, and sleeps the apply delay.
+func BeforeApply() {
+	if !Enabled() {
+		return
+	}
+
+	mu.Lock()
+	for faults.FSMPaused {
+		resumed.Wait()
+	}
+	delay := faults.ApplyDelay
+	mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// DropForward returns whether a write forwarded to the leader should be dropped.
+func DropForward() bool {
+	if !Enabled() {
+		return false
+	}
+	rate := Get().ForwardDropRate
+	return rate > 0 && rand.Float64() < rate
+}
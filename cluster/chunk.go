@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/hashicorp/raft"
+	"io"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+)
+
+// ErrStreamingDisabled is returned when a value is streamed while the chunking is disabled.
+var ErrStreamingDisabled = errcode.New(errcode.NotEnabled, "values can't be streamed while the chunking is disabled")
+
+// chunkSize is the size in bytes from which the values of the sets are split in chunks,
+// it's set once on startup with ConfigureChunking. 0 disables the chunking.
+var chunkSize = 0
+
+// ConfigureChunking sets the size in bytes from which the values of the sets are split in chunks,
+// so a large value doesn't use a single consensus log. 0 disables the chunking.
+func ConfigureChunking(size int) {
+	chunkSize = size
+}
+
+// executeChunked applies a set whose value is bigger than the chunk size as its chunks, and then as its manifest,
+// returning the index of the manifest. It returns false if the value isn't big enough to be split.
+//
+// Every chunk is retried on its own, but the set isn't handed off if the cluster doesn't have a leader.
+func executeChunked(ctx context.Context, consensus *raft.Raft, payload *fsm.Payload) (uint64, bool, error) {
+	chunks, manifest, errSplit := fsm.SplitValue(payload, chunkSize)
+	if errSplit != nil {
+		return 0, true, errSplit
+	}
+	if manifest == nil {
+		return 0, false, nil
+	}
+
+	for _, chunk := range chunks {
+		errChunk := withRetries(ctx, func() error {
+			_, _, errExecute := execute(ctx, consensus, chunk)
+			return errExecute
+		})
+		if errChunk != nil {
+			return 0, true, errChunk
+		}
+	}
+
+	var index uint64
+	err := withRetries(ctx, func() error {
+		var errExecute error
+		_, index, errExecute = execute(ctx, consensus, manifest)
+		return errExecute
+	})
+	return index, true, err
+}
+
+// ExecuteStream sets a key to the value read from r in a content type, applying its chunks as they're read,
+// so the value isn't held in memory. It returns the index of the manifest.
+//
+// Like EncodeValue, the values of the content types which aren't JSON are base64 encoded,
+// and the JSON values are checked once they're joined by the FSM.
+func ExecuteStream(consensus *raft.Raft, key string, contentType string, r io.Reader) (uint64, error) {
+	if chunkSize <= 0 {
+		return 0, ErrStreamingDisabled
+	}
+	id, errID := fsm.NewChunkID()
+	if errID != nil {
+		return 0, errID
+	}
+	w := &chunkWriter{consensus: consensus, key: key, id: id, buf: make([]byte, 0, chunkSize)}
+
+	if fsm.IsJSON(contentType) {
+		_, errCopy := io.Copy(w, r)
+		if errCopy != nil {
+			return 0, errCopy
+		}
+	} else {
+		errEncode := w.writeBase64String(r)
+		if errEncode != nil {
+			return 0, errEncode
+		}
+	}
+	return w.commit(contentType)
+}
+
+// chunkWriter applies the value written to it as chunks of chunkSize.
+type chunkWriter struct {
+	consensus *raft.Raft
+	key       string
+	id        string
+	buf       []byte
+	chunks    int
+	size      int64
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			errFlush := w.flush()
+			if errFlush != nil {
+				return written, errFlush
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeBase64String writes the value read from r as the JSON string of its base64 encoding.
+func (w *chunkWriter) writeBase64String(r io.Reader) error {
+	_, errOpen := w.Write([]byte(`"`))
+	if errOpen != nil {
+		return errOpen
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	_, errCopy := io.Copy(encoder, r)
+	if errCopy != nil {
+		return errCopy
+	}
+	errClose := encoder.Close()
+	if errClose != nil {
+		return errClose
+	}
+	_, errEnd := w.Write([]byte(`"`))
+	return errEnd
+}
+
+// flush applies the buffered part of the value as a chunk.
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	chunk := fsm.ChunkPayload(w.key, w.id, w.chunks, w.buf)
+	errChunk := withRetries(context.Background(), func() error {
+		_, _, errExecute := execute(context.Background(), w.consensus, chunk)
+		return errExecute
+	})
+	if errChunk != nil {
+		return errChunk
+	}
+	w.chunks++
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// commit applies the rest of the value and the manifest, returning the index of the manifest.
+func (w *chunkWriter) commit(contentType string) (uint64, error) {
+	errFlush := w.flush()
+	if errFlush != nil {
+		return 0, errFlush
+	}
+	manifest := fsm.ManifestPayload(w.key, fsm.ChunkManifest{ID: w.id, Chunks: w.chunks, Size: w.size}, nil, contentType)
+
+	var index uint64
+	err := withRetries(context.Background(), func() error {
+		var errExecute error
+		_, index, errExecute = execute(context.Background(), w.consensus, manifest)
+		return errExecute
+	})
+	return index, err
+}
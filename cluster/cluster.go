@@ -2,15 +2,25 @@
 package cluster
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"github.com/hashicorp/raft"
 	"github.com/narvikd/errorskit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"nubedb/api/proto"
 	"nubedb/api/proto/protoclient"
+	"nubedb/cluster/chaos"
 	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/diskwatch"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/peerauth"
+	"nubedb/cluster/protocol"
+	"nubedb/cluster/shard"
 	"nubedb/internal/config"
+	"nubedb/internal/metrics"
 	"nubedb/pkg/resolver"
 	"time"
 )
@@ -21,46 +31,201 @@ const (
 	errGrpcTalkNode   = "failed to get an ok response from the Node via grpc"
 )
 
+// Execute applies a payload on the cluster, forwarding it to the leader if the node isn't one.
+//
+// If it fails due to a leader election, it's retried following the policy set with ConfigureRetries.
+// If it still fails and the handoff is enabled, the write is buffered and ErrHandedOff is returned.
 func Execute(consensus *raft.Raft, payload *fsm.Payload) error {
-	payloadData, errMarshal := json.Marshal(&payload)
+	return ExecuteContext(context.Background(), consensus, payload)
+}
+
+// ExecuteContext is Execute, giving up when ctx is done, like when the deadline of a request passes.
+//
+// A write given up on may still be applied, if it was committed.
+func ExecuteContext(ctx context.Context, consensus *raft.Raft, payload *fsm.Payload) error {
+	_, err := ExecuteIndexContext(ctx, consensus, payload)
+	return err
+}
+
+// ExecuteIndex is Execute, also returning the index of the consensus log the payload was committed at.
+//
+// The index is 0 if it's unknown, like for writes handed off, or forwarded to a leader which doesn't report it.
+//
+// Sets bigger than the size set with ConfigureChunking are split in chunks.
+func ExecuteIndex(consensus *raft.Raft, payload *fsm.Payload) (uint64, error) {
+	return ExecuteIndexContext(context.Background(), consensus, payload)
+}
+
+// ExecuteIndexContext is ExecuteIndex, giving up when ctx is done.
+//
+// It's tracked as an operation in flight until it returns, so it can be killed with KillOp.
+func ExecuteIndexContext(ctx context.Context, consensus *raft.Raft, payload *fsm.Payload) (uint64, error) {
+	ctx, done := trackOp(ctx, consensus, payload.Operation, payload.Key, false)
+	index, err := executeIndex(ctx, consensus, payload)
+	return index, done(err)
+}
+
+func executeIndex(ctx context.Context, consensus *raft.Raft, payload *fsm.Payload) (uint64, error) {
+	if payload.Operation == "SET" && chunkSize > 0 {
+		index, chunked, errChunked := executeChunked(ctx, consensus, payload)
+		if chunked {
+			return index, errChunked
+		}
+	}
+
+	var index uint64
+	err := withRetries(ctx, func() error {
+		var errExecute error
+		_, index, errExecute = execute(ctx, consensus, payload)
+		return errExecute
+	})
+	if IsUnavailable(err) && handoff.push(consensus, payload) {
+		return 0, ErrHandedOff
+	}
+	return index, err
+}
+
+// ExecuteResult is ExecuteIndex, also returning the data the operation returned, encoded as JSON, like the result of DO.
+//
+// The payload isn't chunked nor handed off.
+func ExecuteResult(consensus *raft.Raft, payload *fsm.Payload) (json.RawMessage, uint64, error) {
+	return ExecuteResultContext(context.Background(), consensus, payload)
+}
+
+// ExecuteResultContext is ExecuteResult, giving up when ctx is done.
+//
+// It's tracked as an operation in flight until it returns, so it can be killed with KillOp.
+func ExecuteResultContext(ctx context.Context, consensus *raft.Raft, payload *fsm.Payload) (json.RawMessage, uint64, error) {
+	ctx, done := trackOp(ctx, consensus, payload.Operation, payload.Key, false)
+	var (
+		result json.RawMessage
+		index  uint64
+	)
+	err := withRetries(ctx, func() error {
+		var errExecute error
+		result, index, errExecute = execute(ctx, consensus, payload)
+		return errExecute
+	})
+	return result, index, done(err)
+}
+
+// execute applies a payload on the cluster once.
+func execute(ctx context.Context, consensus *raft.Raft, payload *fsm.Payload) (json.RawMessage, uint64, error) {
+	if errDisk := diskwatch.Check(); errDisk != nil {
+		return nil, 0, errDisk
+	}
+	payload.ProtocolVersion = protocol.Version
+	payloadData, errMarshal := fsm.EncodePayload(payload)
 	if errMarshal != nil {
-		return errorskit.Wrap(errMarshal, "couldn't marshal data to send it to the DB cluster")
+		return nil, 0, errorskit.Wrap(errMarshal, "couldn't marshal data to send it to the DB cluster")
 	}
 
 	if consensus.State() != raft.Leader {
-		return forwardLeaderFuture(consensus, payload)
+		return forwardLeaderFuture(ctx, consensus, payload)
 	}
-
-	return ApplyLeaderFuture(consensus, payloadData)
+	setOpStage(ctx, OpApplying, "")
+	return ApplyLeaderFuture(ctx, consensus, payloadData)
 }
 
-// ApplyLeaderFuture applies a command on the Leader of the cluster.
+// ApplyLeaderFuture applies a command on the Leader of the cluster, returning the data the operation returned
+// encoded as JSON, if any, and the index it was committed at.
+//
+// If the coalescing is enabled, writes to the same key received within its window are merged.
+//
+// It stops waiting for the command to be applied when ctx is done, returning its error.
+//
+// It's refused if the Leader is low on disk space, or is a witness.
 //
 // Should only be executed if the Node is a Leader.
-func ApplyLeaderFuture(consensus *raft.Raft, payloadData []byte) error {
+func ApplyLeaderFuture(ctx context.Context, consensus *raft.Raft, payloadData []byte) (json.RawMessage, uint64, error) {
+	if errDisk := diskwatch.Check(); errDisk != nil {
+		return nil, 0, errDisk
+	}
+	if window, ok := coalescing.enabled(); ok {
+		if key, coalescable := coalescableKey(payloadData); coalescable {
+			index, errApply := coalescing.apply(ctx, consensus, key, payloadData, window)
+			return nil, index, errApply
+		}
+	}
+	return applyLeaderFuture(ctx, consensus, payloadData)
+}
+
+// applyLeaderFuture applies a command on the Leader of the cluster, without coalescing it.
+func applyLeaderFuture(ctx context.Context, consensus *raft.Raft, payloadData []byte) (json.RawMessage, uint64, error) {
+	future, errApply := applyCommand(ctx, consensus, payloadData)
+	if errApply != nil {
+		return nil, 0, errApply
+	}
+	return futureResult(future)
+}
+
+// applyCommand applies a command on the Leader of the cluster, returning its future once it's applied.
+//
+// An error means the command wasn't applied, or it's unknown if it was, not that the FSM refused it.
+func applyCommand(ctx context.Context, consensus *raft.Raft, payloadData []byte) (raft.ApplyFuture, error) {
 	const timeout = 500 * time.Millisecond
 
 	if consensus.State() != raft.Leader {
-		return errors.New("node is not a leader")
+		return nil, errNotLeader
+	}
+	if witness.Load() {
+		return nil, ErrWitnessLeader
 	}
 
 	future := consensus.Apply(payloadData, timeout)
-	if future.Error() != nil {
-		return errorskit.Wrap(future.Error(), errDBCluster+" At future")
+	errFuture := waitFuture(ctx, future)
+	if errFuture != nil {
+		return nil, errorskit.Wrap(errFuture, errDBCluster+" At future")
 	}
+	return future, nil
+}
 
+// futureResult returns the data the operation of an applied command returned encoded as JSON, if any,
+// and the index it was committed at, or the error the FSM refused it with.
+func futureResult(future raft.ApplyFuture) (json.RawMessage, uint64, error) {
 	response := future.Response().(*fsm.ApplyRes)
 	if response.Error != nil {
-		return errorskit.Wrap(response.Error, errDBCluster+" At response")
+		return nil, 0, errorskit.Wrap(response.Error, errDBCluster+" At response")
+	}
+	if response.Data == nil {
+		return nil, future.Index(), nil
 	}
 
-	return nil
+	result, errMarshal := json.Marshal(response.Data)
+	if errMarshal != nil {
+		return nil, future.Index(), errorskit.Wrap(errMarshal, "operation applied, but couldn't marshal its result")
+	}
+	return result, future.Index(), nil
 }
 
-func forwardLeaderFuture(consensus *raft.Raft, payload *fsm.Payload) error {
+// waitFuture waits for a future to be applied, returning ctx's error if it's done first.
+func waitFuture(ctx context.Context, future raft.Future) error {
+	if ctx.Done() == nil {
+		return future.Error()
+	}
+	applied := make(chan error, 1)
+	go func() {
+		applied <- future.Error()
+	}()
+	select {
+	case err := <-applied:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func forwardLeaderFuture(ctx context.Context, consensus *raft.Raft, payload *fsm.Payload) (json.RawMessage, uint64, error) {
 	_, leaderID := consensus.LeaderWithID()
 	if string(leaderID) == "" {
-		return errors.New("leader id was empty")
+		return nil, 0, ErrNoLeader
+	}
+	setOpStage(ctx, OpForwarding, string(leaderID))
+	defer metrics.Track(metrics.ComponentGrpcForward, payload.Operation, payload.Key, time.Now())
+
+	if chaos.DropForward() {
+		// It's dropped before being sent, so it's retried like failing to connect to the leader.
+		return nil, 0, fmt.Errorf("%w: chaos: write forwarded to the leader was dropped", errLeaderUnreachable)
 	}
 
 	leaderGrpcAddr := config.MakeGrpcAddress(string(leaderID))
@@ -68,25 +233,46 @@ func forwardLeaderFuture(consensus *raft.Raft, payload *fsm.Payload) error {
 		leaderID, leaderGrpcAddr,
 	)
 
-	payloadData, errMarshal := json.Marshal(&payload)
+	payloadData, errMarshal := fsm.EncodePayload(payload)
 	if errMarshal != nil {
-		return errorskit.Wrap(errMarshal, "couldn't marshal data to send it to the Leader's DB cluster")
+		return nil, 0, errorskit.Wrap(errMarshal, "couldn't marshal data to send it to the Leader's DB cluster")
 	}
 
 	conn, errConn := protoclient.NewConnection(leaderGrpcAddr)
 	if errConn != nil {
-		return errConn
+		return nil, 0, fmt.Errorf("%w: %v", errLeaderUnreachable, errConn)
 	}
 	defer conn.Cleanup()
 
-	_, errTalk := conn.Client.ExecuteOnLeader(conn.Ctx, &proto.ExecuteOnLeaderRequest{
+	// The leader stops waiting for the write when the request's deadline passes, or when it's killed.
+	callCtx := conn.Ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithDeadline(callCtx, deadline)
+		defer cancel()
+	}
+	callCtx, cancelCall := context.WithCancel(callCtx)
+	defer cancelCall()
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancelCall()
+		case <-callCtx.Done():
+		}
+	}()
+	res, errTalk := conn.Client.ExecuteOnLeader(shard.WithShard(callCtx, shardOf(consensus)), &proto.ExecuteOnLeaderRequest{
 		Payload: payloadData,
 	})
 	if errTalk != nil {
-		return errorskit.Wrap(errTalk, errGrpcTalkLeader)
+		// The errors of the connection don't tell whether the leader received the write,
+		// unlike the ones the leader returned, which carry their code.
+		if status.Code(errTalk) == codes.Unavailable && !errcode.Carried(errTalk) {
+			return nil, 0, fmt.Errorf("%w: %v", errForwardOutcomeUnknown, errTalk)
+		}
+		return nil, 0, errorskit.Wrap(errTalk, errGrpcTalkLeader)
 	}
 
-	return nil
+	return res.GetResult(), res.GetIndex(), nil
 }
 
 // IsLeader takes a GRPC address and returns if the node reports back as a Leader
@@ -105,24 +291,28 @@ func IsLeader(addr string) (bool, error) {
 	return res.IsLeader, nil
 }
 
-func ConsensusJoin(nodeID string, nodeConsensusAddr string, leaderGrpcAddr string) error {
+// ConsensusJoin asks the leader to add a node to the consensus.
+//
+// It returns the node key if the node joined with a join token, empty otherwise.
+func ConsensusJoin(nodeID string, nodeConsensusAddr string, leaderGrpcAddr string) (string, error) {
 	// TODO: Maybe add a message to know one node is contacting the other for this operation
 
 	conn, errConn := protoclient.NewConnection(leaderGrpcAddr)
 	if errConn != nil {
-		return errConn
+		return "", errConn
 	}
 	defer conn.Cleanup()
 
-	_, errTalk := conn.Client.ConsensusJoin(conn.Ctx, &proto.ConsensusRequest{
+	ctx := peerauth.WithProof(conn.Ctx, nodeID, nodeConsensusAddr)
+	res, errTalk := conn.Client.ConsensusJoin(ctx, &proto.ConsensusRequest{
 		NodeID:            nodeID,
 		NodeConsensusAddr: nodeConsensusAddr,
 	})
 	if errTalk != nil {
-		return errorskit.Wrap(errTalk, errGrpcTalkLeader)
+		return "", errorskit.Wrap(errTalk, errGrpcTalkLeader)
 	}
 
-	return nil
+	return res.NodeKey, nil
 }
 
 func ConsensusRemove(nodeID string, leaderGrpcAddr string) error {
@@ -134,7 +324,8 @@ func ConsensusRemove(nodeID string, leaderGrpcAddr string) error {
 	}
 	defer conn.Cleanup()
 
-	_, errTalk := conn.Client.ConsensusRemove(conn.Ctx, &proto.ConsensusRequest{
+	ctx := peerauth.WithProof(conn.Ctx, nodeID, "")
+	_, errTalk := conn.Client.ConsensusRemove(ctx, &proto.ConsensusRequest{
 		NodeID: nodeID,
 	})
 	if errTalk != nil {
@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"github.com/hashicorp/raft"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/metrics"
+	"sync"
+	"time"
+)
+
+// coalescedOperations are the operations which can be coalesced, since a later one fully replaces the previous ones.
+var coalescedOperations = map[string]bool{"SET": true}
+
+// coalescedWrite is a write waiting to be applied, which later writes to the same key replace.
+type coalescedWrite struct {
+	payloadData []byte
+	done        chan struct{}
+	index       uint64
+	err         error
+}
+
+// coalescedWrites are the writes to a key received within a window, in the order they were received.
+type coalescedWrites struct {
+	writes []*coalescedWrite
+}
+
+// coalescer merges the writes to the same key the leader receives within a window into a single log,
+// the last one received wins, and the ones it replaced get its result.
+//
+// A write the FSM refuses gets its own error, and the write before it is applied instead,
+// so each write is answered as if they were applied one after the other.
+type coalescer struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[string]*coalescedWrites
+}
+
+// coalescing is the coalescer used by ApplyLeaderFuture, its window is set once on startup with ConfigureCoalescing.
+var coalescing = &coalescer{pending: make(map[string]*coalescedWrites)}
+
+// ConfigureCoalescing sets how long the leader waits for more writes to the same key before applying one,
+// every write which can be coalesced is delayed by it. 0 disables the coalescing.
+func ConfigureCoalescing(window time.Duration) {
+	coalescing.mu.Lock()
+	defer coalescing.mu.Unlock()
+	coalescing.window = window
+}
+
+// enabled returns the window, and whether the coalescing is enabled.
+func (c *coalescer) enabled() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.window, c.window > 0
+}
+
+// apply applies a write, merging it with the other writes to the same key received within the window.
+func (c *coalescer) apply(ctx context.Context, consensus *raft.Raft, key string, payloadData []byte, window time.Duration) (uint64, error) {
+	w := &coalescedWrite{payloadData: payloadData, done: make(chan struct{})}
+	c.mu.Lock()
+	if pending, ok := c.pending[key]; ok {
+		pending.writes = append(pending.writes, w)
+		c.mu.Unlock()
+		metrics.RecordCoalescedWrite()
+		select {
+		case <-w.done:
+			return w.index, w.err
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	pending := &coalescedWrites{writes: []*coalescedWrite{w}}
+	c.pending[key] = pending
+	c.mu.Unlock()
+
+	time.Sleep(window)
+
+	c.mu.Lock()
+	delete(c.pending, key)
+	writes := pending.writes
+	c.mu.Unlock()
+
+	// The writes are applied for every write coalesced into them, whatever happens to the first one's request.
+	applyCoalesced(consensus, writes)
+	return w.index, w.err
+}
+
+// applyCoalesced applies the last of the writes to a key, and answers all of them.
+//
+// The writes before the one applied get its index. If the FSM refuses it, it gets its error,
+// and the write before it is applied instead. If it's unknown whether it was applied, the ones before it get its error,
+// since applying them could overwrite it.
+func applyCoalesced(consensus *raft.Raft, writes []*coalescedWrite) {
+	for i := len(writes) - 1; i >= 0; i-- {
+		future, errApply := applyCommand(context.Background(), consensus, writes[i].payloadData)
+		if errApply != nil {
+			for _, w := range writes[:i+1] {
+				w.err = errApply
+			}
+			break
+		}
+		_, index, errResult := futureResult(future)
+		writes[i].index, writes[i].err = index, errResult
+		if errResult == nil {
+			for _, w := range writes[:i] {
+				w.index = index
+			}
+			break
+		}
+	}
+	for _, w := range writes {
+		close(w.done)
+	}
+}
+
+// coalescableKey returns the key of a write if it can be coalesced,
+// only the SETs which aren't scheduled fully replace the previous ones.
+func coalescableKey(payloadData []byte) (string, bool) {
+	p, errDecode := fsm.DecodePayload(payloadData)
+	if errDecode != nil || !coalescedOperations[p.Operation] || p.NotBefore != nil {
+		return "", false
+	}
+	return p.Key, true
+}
@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLeader returns a single node consensus, once it's the leader.
+func newLeader(t *testing.T) (*raft.Raft, *fsm.DatabaseFSM) {
+	t.Helper()
+	dbFSM, errFSM := fsm.New(engine.NewMemory(), 0, 0)
+	if errFSM != nil {
+		t.Fatal(errFSM)
+	}
+	cfg := raft.DefaultConfig()
+	cfg.LocalID = "node1"
+	cfg.Logger = hclog.NewNullLogger()
+	cfg.HeartbeatTimeout = 50 * time.Millisecond
+	cfg.ElectionTimeout = 50 * time.Millisecond
+	cfg.LeaderLeaseTimeout = 50 * time.Millisecond
+	addr, transport := raft.NewInmemTransport("")
+	store := raft.NewInmemStore()
+	consensus, errRaft := raft.NewRaft(cfg, dbFSM, store, store, raft.NewInmemSnapshotStore(), transport)
+	if errRaft != nil {
+		t.Fatal(errRaft)
+	}
+	t.Cleanup(func() { _ = consensus.Shutdown().Error() })
+
+	servers := raft.Configuration{Servers: []raft.Server{{ID: cfg.LocalID, Address: addr}}}
+	if errBootstrap := consensus.BootstrapCluster(servers).Error(); errBootstrap != nil {
+		t.Fatal(errBootstrap)
+	}
+	select {
+	case <-consensus.LeaderCh():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the node wasn't elected")
+	}
+	return consensus, dbFSM
+}
+
+func encode(t *testing.T, p *fsm.Payload) []byte {
+	t.Helper()
+	data, errEncode := fsm.EncodePayload(p)
+	if errEncode != nil {
+		t.Fatal(errEncode)
+	}
+	return data
+}
+
+func TestCoalescedWritesGetTheirOwnResult(t *testing.T) {
+	consensus, dbFSM := newLeader(t)
+	schema := map[string]any{"type": "string"}
+	_, _, errSchema := applyLeaderFuture(context.Background(), consensus, encode(t, &fsm.Payload{
+		Key: "users", Operation: "SETSCHEMA", Value: schema,
+	}))
+	if errSchema != nil {
+		t.Fatal(errSchema)
+	}
+
+	c := &coalescer{pending: make(map[string]*coalescedWrites)}
+	// The last write doesn't match the schema, so the one before it is applied, and replaces the first one.
+	values := []any{"first", "second", 3}
+	indexes := make([]uint64, len(values))
+	errs := make([]error, len(values))
+	var wg sync.WaitGroup
+	for i, v := range values {
+		wg.Add(1)
+		go func(i int, v any) {
+			defer wg.Done()
+			payloadData := encode(t, &fsm.Payload{Key: "users/1", Operation: "SET", Value: v})
+			indexes[i], errs[i] = c.apply(context.Background(), consensus, "users/1", payloadData, 200*time.Millisecond)
+		}(i, v)
+		// The writes must be received in order.
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("got errors %v, want the valid writes to succeed", errs)
+	}
+	if !errors.Is(errs[2], fsm.ErrSchemaViolation) {
+		t.Fatalf("got %v, want the last write to fail with its own error", errs[2])
+	}
+	if indexes[0] == 0 || indexes[0] != indexes[1] {
+		t.Fatalf("got indexes %v, want the first write to get the index of the one which replaced it", indexes)
+	}
+	got, errGet := dbFSM.Get("users/1")
+	if errGet != nil || got != "second" {
+		t.Fatalf("got (%v, %v), want the last valid write", got, errGet)
+	}
+}
+
+func TestScheduledSetsAreNotCoalesced(t *testing.T) {
+	notBefore := time.Now().Add(time.Hour)
+	if _, ok := coalescableKey(encode(t, &fsm.Payload{Key: "k", Operation: "SET", Value: "v"})); !ok {
+		t.Fatal("a plain SET wasn't coalesced")
+	}
+	if _, ok := coalescableKey(encode(t, &fsm.Payload{Key: "k", Operation: "SET", Value: "v", NotBefore: &notBefore})); ok {
+		t.Fatal("a scheduled SET was coalesced")
+	}
+}
@@ -0,0 +1,82 @@
+package consensus
+
+import (
+	"github.com/hashicorp/raft"
+	"nubedb/cluster"
+	"nubedb/discover"
+	"nubedb/internal/config"
+	"sort"
+	"time"
+)
+
+// bootstrapInterval is how often the nodes are discovered while waiting for the expected ones.
+const bootstrapInterval = 2 * time.Second
+
+// bootstrapExpected boots a new cluster once the expected number of nodes are discovered,
+// or joins the cluster if it already has a leader.
+//
+// The first nodes in order of ID are the ones bootstrapped, each of them bootstraps the same configuration,
+// so it doesn't matter which one starts first. The rest of the nodes join the leader they elect.
+// The discovered nodes must be the same twice in a row, so the nodes starting at once see each other.
+func (n *Node) bootstrapExpected() error {
+	var previous []string
+	for {
+		leaderID, errSearchLeader := n.SearchLeader()
+		if errSearchLeader == nil {
+			_, errJoin := cluster.ConsensusJoin(n.ID, config.MakeConsensusAddr(n.ID), config.MakeGrpcAddress(leaderID))
+			return errJoin
+		}
+
+		ids, errSearch := discover.SearchNodes(n.ID)
+		if errSearch != nil {
+			n.logger.Warn("couldn't discover the nodes to bootstrap", "error", errSearch)
+		}
+		ids = append(ids, n.ID)
+		sort.Strings(ids)
+
+		if len(ids) >= n.bootstrapExpect && equalIDs(ids, previous) {
+			expected := ids[:n.bootstrapExpect]
+			if containsID(expected, n.ID) {
+				n.logger.Info("bootstrapping the cluster", "nodes", expected)
+				n.bootstrapIDs = expected
+				return n.Consensus.BootstrapCluster(raft.Configuration{Servers: bootstrapServers(expected, 0)}).Error()
+			}
+		}
+		n.logger.Info("waiting for the nodes to bootstrap", "discovered", len(ids), "expected", n.bootstrapExpect)
+		previous = ids
+		clock.Sleep(bootstrapInterval)
+	}
+}
+
+// bootstrapServers returns the consensus servers of a shard with the given nodes.
+func bootstrapServers(ids []string, shard int) []raft.Server {
+	servers := make([]raft.Server, 0, len(ids))
+	for _, id := range ids {
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(id),
+			Address: raft.ServerAddress(config.MakeShardConsensusAddr(id, shard)),
+		})
+	}
+	return servers
+}
+
+func equalIDs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsID(ids []string, id string) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package consensus
+
+import "time"
+
+// Clock is the source of time of the consensus package's own timers, like the waits of the decommissions,
+// so they can be replaced by a virtual clock.
+//
+// Raft's internal timers, like the election and heartbeat timeouts, always use the system's clock,
+// so replacing it doesn't make a cluster deterministic.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// realClock is the system's clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// clock is the clock used by the package, it's only replaced with SetClock.
+var clock Clock = realClock{}
+
+// SetClock replaces the clock of the consensus package, it must be called before starting any node.
+func SetClock(c Clock) {
+	clock = c
+}
@@ -2,39 +2,84 @@ package consensus
 
 import (
 	"errors"
+	"fmt"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
-	"github.com/hashicorp/raft-boltdb/v2"
 	"github.com/narvikd/errorskit"
 	"github.com/narvikd/filekit"
-	"net"
+	"log"
 	"nubedb/cluster"
+	"nubedb/cluster/backup"
+	"nubedb/cluster/consensus/engine"
 	"nubedb/cluster/consensus/fsm"
-	"nubedb/discover"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/peerauth"
+	"nubedb/cluster/shard"
 	"nubedb/internal/config"
+	"nubedb/pkg/objectstore"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// StorageDirName is the name of the directory, inside the node's storage dir, where the FSM's data is stored.
+	StorageDirName = "localdb"
+	// ConsensusDBName is the name of the file, inside the node's logs dir, where the consensus logs are stored.
+	ConsensusDBName = "consensus.db"
+	// SnapshotsDirName is the name of the directory, inside the node's snapshots dir, where the snapshots are stored.
+	SnapshotsDirName = "snapshots"
+	// SQLiteDBName is the name of the file, inside the storage dir, where the sqlite storage engine stores the data.
+	SQLiteDBName = "nubedb.sqlite"
+)
+
+// ErrAlreadyJoined is returned by the leader when a node joins a network it's already part of.
+var ErrAlreadyJoined = errcode.New(errcode.Conflict, "node was already part of the network")
+
 // Node struct defines the properties of a node
 type Node struct {
 	sync.RWMutex
-	Consensus            *raft.Raft
-	FSM                  *fsm.DatabaseFSM
-	ID                   string `json:"id" validate:"required"`
-	ConsensusAddress     string `json:"address"`
-	MainDir              string
-	storageDir           string
-	snapshotsDir         string
-	consensusDBPath      string
+	Consensus        *raft.Raft
+	FSM              *fsm.DatabaseFSM
+	ID               string `json:"id" validate:"required"`
+	ConsensusAddress string `json:"address"`
+	MainDir          string
+	paths            Paths
+	logStoreKind     string
+	// tuning holds the settings of the consensus which can be changed at runtime with Tune.
+	tuning               config.ConsensusCfg
+	witness              bool
+	maintenance          atomic.Bool
+	readOnly             atomic.Bool
+	decommissions        map[string]*DecommissionProgress
+	replacements         map[string]*ReplaceProgress
 	logger               hclog.Logger
 	chans                *Chans
+	events               *eventHub
+	encryption           config.EncryptionCfg
+	cachedLeaderID       string
 	unBlockingInProgress bool
+	shards               []*Shard
+	ring                 *shard.Ring
+	shardMapMu           sync.Mutex
+	shardMap             shard.Map
+	shardMapIndex        uint64
+	rebalanceMu          sync.Mutex
+	// bootstrapExpect is the number of nodes a new cluster is bootstrapped with, 0 bootstraps it with bootstrap-node.
+	// bootstrapIDs are the nodes this node bootstrapped the cluster with, if it did.
+	bootstrapExpect int
+	bootstrapIDs    []string
+	// singleNode makes the node the only one of its cluster.
+	singleNode bool
+	// restarts receives the reason of the restarts the node asks for, check Restarts.
+	restarts chan string
+	// stopped is closed when the node is shut down, so its background goroutines return.
+	stopped  chan struct{}
+	stopOnce sync.Once
 }
 
 // Chans struct defines the channels used for the observers
@@ -62,25 +107,111 @@ func (n *Node) SetUnBlockingInProgress(b bool) {
 
 // New initializes and returns a new Node
 func New(cfg config.Config) (*Node, error) {
-	n, errNode := newNode(cfg.CurrentNode.ID, cfg.CurrentNode.ConsensusAddress)
+	if cfg.CurrentNode.Witness {
+		// Witness nodes don't store data, so there isn't an FSM's store to check.
+		cfg.Storage.Engine = engine.Memory
+	}
+	paths := NodePaths(cfg.CurrentNode.ID, cfg.Storage, cfg.Consensus)
+	errCheck := checkStores(paths, cfg.Storage, cfg.Consensus, cfg.Encryption, cfg.Integrity.VerifyChecksums)
+	if errCheck != nil {
+		if !cfg.Integrity.SelfHeal {
+			return nil, errorskit.Wrap(errCheck, "integrity check failed")
+		}
+		// The node rejoins the cluster without data, and gets it from the latest backup, which must be restored on boot,
+		// and the logs after it from the leader. The snapshots are empty, so the leader can't send the older keys.
+		log.Println("[consensus] integrity check failed, self healing:", errCheck)
+		errQuarantine := quarantine(paths)
+		if errQuarantine != nil {
+			return nil, errQuarantine
+		}
+	}
+
+	n, errNode := newNode(cfg.CurrentNode.ID, cfg.CurrentNode.ConsensusAddress, paths, cfg.Storage, cfg.Consensus, cfg.Encryption)
 	if errNode != nil {
 		return nil, errNode
 	}
 
+	if cfg.CurrentNode.Witness {
+		n.FSM = fsm.NewWitness()
+		n.witness = true
+	}
+	n.readOnly.Store(cfg.CurrentNode.ReadOnly)
+	n.bootstrapExpect = cfg.Cluster.BootstrapExpect
+	n.singleNode = cfg.Cluster.SingleNode
+
+	if cfg.Backup.RestoreOnBoot && !n.witness && n.FSM.IsEmpty() {
+		errRestore := n.restoreFromObjectStore(cfg.Backup)
+		if errRestore != nil {
+			return nil, errRestore
+		}
+	}
+
 	errRaft := n.setRaft()
 	if errRaft != nil {
 		return nil, errRaft
 	}
+	peerauth.SetMembership(n.IsMember)
+
+	errShards := n.startShards(cfg)
+	if errShards != nil {
+		return nil, errShards
+	}
+	errMetrics := n.registerApplyLagMetrics()
+	if errMetrics != nil {
+		return nil, errMetrics
+	}
+	go n.watchNodeHosts()
 
 	return n, nil
 }
 
-// newNode initializes and returns a new Node with the given id and address
-func newNode(id string, address string) (*Node, error) {
-	dir := path.Join("data", id)
-	storageDir := path.Join(dir, "localdb")
+// IsMember returns whether a node is a server of the node's main consensus.
+func (n *Node) IsMember(nodeID string) bool {
+	future := n.Consensus.GetConfiguration()
+	if future.Error() != nil {
+		return false
+	}
+	for _, srv := range future.Configuration().Servers {
+		if string(srv.ID) == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreFromObjectStore restores the latest backup from the object storage into the node's FSM.
+//
+// It's used to rebuild a node which lost its data, before it joins the consensus.
+func (n *Node) restoreFromObjectStore(cfg config.BackupCfg) error {
+	store, errStore := objectstore.New(cfg.Store)
+	if errStore != nil {
+		return errStore
+	}
+
+	name, errRestore := backup.RestoreLatest(store, n.FSM, cfg.Prefix)
+	if errRestore != nil {
+		if errors.Is(errRestore, backup.ErrNoBackups) {
+			log.Println("[backup] node started without data, but there aren't backups to restore")
+			return nil
+		}
+		return errorskit.Wrap(errRestore, "couldn't restore from object storage")
+	}
+
+	log.Println("[backup] node restored from backup:", name)
+	return nil
+}
+
+// newNode initializes and returns a new Node with the given id and address, storing its data in paths
+func newNode(
+	id string, address string, paths Paths, storage config.StorageCfg, consensusCfg config.ConsensusCfg,
+	enc config.EncryptionCfg,
+) (*Node, error) {
+	errDirs := createDirs(paths)
+	if errDirs != nil {
+		return nil, errDirs
+	}
 
-	f, errDB := newFSM(storageDir)
+	f, errDB := newFSM(paths.Storage, storage, enc)
 	if errDB != nil {
 		return nil, errDB
 	}
@@ -89,29 +220,77 @@ func newNode(id string, address string) (*Node, error) {
 		FSM:              f,
 		ID:               id,
 		ConsensusAddress: address,
-		MainDir:          dir,
-		storageDir:       storageDir,
-		snapshotsDir:     dir, // This isn't a typo, it will create a snapshots dir inside the dir automatically
-		consensusDBPath:  filepath.Join(dir, "consensus.db"),
+		MainDir:          paths.Main,
+		paths:            paths,
+		logStoreKind:     consensusCfg.LogStore,
+		tuning:           consensusCfg,
 		chans:            new(Chans),
+		events:           newEventHub(),
+		encryption:       enc,
+		restarts:         make(chan string, 1),
+		stopped:          make(chan struct{}),
 	}
+	return n, nil
+}
 
-	errDir := filekit.CreateDirs(n.MainDir, false)
-	if errDir != nil {
-		return nil, errDir
+// createDirs creates the dirs a node stores its data in.
+func createDirs(paths Paths) error {
+	for _, dir := range paths.Roots() {
+		errDir := filekit.CreateDirs(dir, false)
+		if errDir != nil {
+			return errDir
+		}
 	}
+	return nil
+}
 
-	return n, nil
+// Paths returns the directories where the node stores its data.
+func (n *Node) Paths() Paths {
+	return n.paths
+}
+
+// MainDir returns the directory where the data of a node is stored, except the dirs configured to be elsewhere.
+func MainDir(id string) string {
+	return path.Join("data", id)
+}
+
+// NodeKeyPath returns the path the node key received by a node which joined with a join token is kept at.
+func NodeKeyPath(id string) string {
+	return path.Join(MainDir(id), "node-key")
 }
 
-// newFSM initializes a new fsm
-func newFSM(dir string) (*fsm.DatabaseFSM, error) {
-	db, err := badger.Open(badger.DefaultOptions(dir))
-	if err != nil {
-		return nil, errorskit.Wrap(err, "couldn't open badgerDB")
+// newFSM initializes a new fsm, on top of the configured storage engine
+func newFSM(dir string, storage config.StorageCfg, enc config.EncryptionCfg) (*fsm.DatabaseFSM, error) {
+	e, errEngine := OpenEngine(dir, storage, enc)
+	if errEngine != nil {
+		return nil, errEngine
+	}
+	return fsm.New(e, storage.ReadCacheSize, storage.BloomKeys)
+}
+
+// OpenEngine opens the configured storage engine in dir, it's exported for the offline tools which write the data.
+func OpenEngine(dir string, storage config.StorageCfg, enc config.EncryptionCfg) (engine.Engine, error) {
+	switch storage.Engine {
+	case engine.Badger:
+		db, err := OpenBadger(dir, enc)
+		if err != nil {
+			return nil, err
+		}
+		go badgerGC(db)
+		return engine.NewBadger(db), nil
+	case engine.Pebble:
+		db, err := OpenPebble(dir, enc)
+		if err != nil {
+			return nil, err
+		}
+		return engine.NewPebble(db), nil
+	case engine.SQLite:
+		return OpenSQLite(dir, enc)
+	case engine.Memory:
+		return engine.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("storage engine not recognized: %s", storage.Engine)
 	}
-	go badgerGC(db)
-	return fsm.New(db), nil
 }
 
 func badgerGC(db *badger.DB) {
@@ -132,47 +311,37 @@ func badgerGC(db *badger.DB) {
 
 // setRaft initializes and starts a new consensus instance using the node's configuration.
 func (n *Node) setRaft() error {
-	const (
-		timeout            = 10 * time.Second
-		maxConnectionsPool = 10
-		retainedSnapshots  = 3
-		// Note: This defaults to a very high value and can cause spam to disk if it's too low.
-		// TODO: Find appropriate value
-		snapshotThreshold = 2
-	)
-
-	// Resolve the TCP address for use in Raft's consensus.
-	tcpAddr, errAddr := net.ResolveTCPAddr("tcp", n.ConsensusAddress)
-	if errAddr != nil {
-		return errorskit.Wrap(errAddr, "couldn't resolve addr")
-	}
+	const retainedSnapshots = 3
 
 	// Create the transport
-	transport, errTransport := raft.NewTCPTransport(n.ConsensusAddress, tcpAddr, maxConnectionsPool, timeout, os.Stderr)
+	transport, errTransport := newTCPTransport(n.ConsensusAddress)
 	if errTransport != nil {
-		return errorskit.Wrap(errTransport, "couldn't create transport")
+		return errTransport
 	}
 
 	// Create the log DB
-	dbStore, errRaftStore := raftboltdb.NewBoltStore(n.consensusDBPath)
+	dbStore, errRaftStore := OpenLogStore(n.paths.LogStore(n.logStoreKind), n.logStoreKind, n.encryption, false)
 	if errRaftStore != nil {
-		return errorskit.Wrap(errRaftStore, "couldn't create consensus db")
+		return errRaftStore
 	}
 
-	// Create the snapshot store
-	snaps, errSnapStore := raft.NewFileSnapshotStore(n.snapshotsDir, retainedSnapshots, os.Stderr)
+	// Create the snapshot store, it creates a snapshots dir inside the dir.
+	snaps, errSnapStore := raft.NewFileSnapshotStore(n.paths.Snapshots, retainedSnapshots, os.Stderr)
 	if errSnapStore != nil {
 		return errorskit.Wrap(errSnapStore, "couldn't create consensus snapshot storage")
 	}
 
 	// Sett the rest of the configuration for the consensus.
-	nodeID := raft.ServerID(n.ID)
-	cfg := raft.DefaultConfig()
-	cfg.LocalID = nodeID
-	cfg.SnapshotInterval = timeout
-	cfg.SnapshotThreshold = snapshotThreshold
+	cfg := NewRaftConfig(n.ID)
+	applyTuning(cfg, n.tuning)
 	n.setConsensusLogger(cfg)
 
+	// Recover the configuration if it was requested, since the cluster lost its quorum.
+	errRecover := n.recoverConfiguration(cfg, dbStore, snaps, transport)
+	if errRecover != nil {
+		return errRecover
+	}
+
 	// Create a new Raft instance and set it.
 	r, errRaft := raft.NewRaft(cfg, n.FSM, dbStore, dbStore, snaps, transport)
 	if errRaft != nil {
@@ -181,7 +350,7 @@ func (n *Node) setRaft() error {
 	n.Consensus = r
 
 	// Start the consensus process for this node.
-	errStartConsensus := n.startConsensus(string(nodeID))
+	errStartConsensus := n.startConsensus()
 	if errStartConsensus != nil {
 		return errStartConsensus
 	}
@@ -192,11 +361,27 @@ func (n *Node) setRaft() error {
 	}
 	// Register the observers
 	n.registerObservers()
+	n.registerEventsObserver(0, n.Consensus)
 	return nil
 }
 
+// NewRaftConfig returns the consensus configuration of a node, without its logger.
+func NewRaftConfig(id string) *raft.Config {
+	const (
+		snapshotInterval = 10 * time.Second
+		// Note: This defaults to a very high value and can cause spam to disk if it's too low.
+		// TODO: Find appropriate value
+		snapshotThreshold = 2
+	)
+	cfg := raft.DefaultConfig()
+	cfg.LocalID = raft.ServerID(id)
+	cfg.SnapshotInterval = snapshotInterval
+	cfg.SnapshotThreshold = snapshotThreshold
+	return cfg
+}
+
 // startConsensus boots up the consensus process for the node, by adding it to an existing or new cluster.
-func (n *Node) startConsensus(currentNodeID string) error {
+func (n *Node) startConsensus() error {
 	// Define the bootstrapping leader ID, this is useful in case the consensus hasn't been started yet.
 	const bootstrappingLeader = "bootstrap-node"
 
@@ -206,13 +391,25 @@ func (n *Node) startConsensus(currentNodeID string) error {
 		n.logger.Info("consensus already bootstrapped")
 		return nil
 	}
+	if n.singleNode || n.bootstrapExpect > 0 {
+		if len(consensusCfg.Servers) > 0 {
+			n.logger.Info("consensus already bootstrapped")
+			return nil
+		}
+		if n.singleNode {
+			n.logger.Info("bootstrapping the cluster as a single node")
+			n.bootstrapIDs = []string{n.ID}
+			return n.Consensus.BootstrapCluster(raft.Configuration{Servers: bootstrapServers(n.bootstrapIDs, 0)}).Error()
+		}
+		return n.bootstrapExpected()
+	}
 
 	// Define the list of bootstrapping servers.
 	bootstrappingServers := newConsensusServerList(bootstrappingLeader)
 
 	// Search for an existing leader and use it to overwrite the bootstrapping list.
 	// This is used in case bootstrappingLeader is down, or if it isn't the leader.
-	leaderID, errSearchLeader := discover.SearchLeader(currentNodeID)
+	leaderID, errSearchLeader := n.SearchLeader()
 	if errSearchLeader == nil {
 		bootstrappingServers = newConsensusServerList(leaderID)
 	}
@@ -232,10 +429,9 @@ func (n *Node) startConsensus(currentNodeID string) error {
 		return nil
 	}
 
-	errJoin := joinNodeToExistingConsensus(currentNodeID)
+	errJoin := n.joinNodeToExistingConsensus()
 	if errJoin != nil {
-		errLower := strings.ToLower(errJoin.Error())
-		if strings.Contains(errLower, "was already part of the network") {
+		if errcode.Of(errJoin) == errcode.Conflict {
 			return nil
 		}
 		return errorskit.Wrap(errJoin, "while bootstrapping")
@@ -244,12 +440,18 @@ func (n *Node) startConsensus(currentNodeID string) error {
 	return nil
 }
 
-func joinNodeToExistingConsensus(nodeID string) error {
-	leaderID, errSearchLeader := discover.SearchLeader(nodeID)
+func (n *Node) joinNodeToExistingConsensus() error {
+	leaderID, errSearchLeader := n.SearchLeader()
 	if errSearchLeader != nil {
 		return errSearchLeader
 	}
-	return cluster.ConsensusJoin(nodeID, config.MakeConsensusAddr(nodeID), config.MakeGrpcAddress(leaderID))
+	nodeKey, errJoin := cluster.ConsensusJoin(n.ID, config.MakeConsensusAddr(n.ID), config.MakeGrpcAddress(leaderID))
+	if errJoin != nil || nodeKey == "" {
+		return errJoin
+	}
+	// The node joined with a join token, it keeps its key so it can authenticate itself to the other nodes.
+	peerauth.SetNodeKey(n.ID, nodeKey)
+	return peerauth.SaveNodeKey(NodeKeyPath(n.ID), nodeKey)
 }
 
 func newConsensusServerList(nodeID string) []raft.Server {
@@ -277,7 +479,7 @@ func (n *Node) waitForClusterReadiness() error {
 			break
 		}
 		n.logger.Error("it is not possible to reach Quorum due to lack of nodes. Retrying...")
-		time.Sleep(sleepTime)
+		clock.Sleep(sleepTime)
 	}
 	return nil
 }
@@ -0,0 +1,166 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"net/http"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/config"
+	"time"
+)
+
+// Steps of a decommission.
+const (
+	DecommissionDemoting = "demoting"
+	DecommissionSettling = "settling"
+	DecommissionRemoving = "removing"
+	DecommissionDone     = "done"
+	DecommissionFailed   = "failed"
+)
+
+var (
+	// ErrNotLeader is returned when an operation which must be done by the leader is requested to a follower.
+	ErrNotLeader = errcode.New(errcode.NotLeader, "this node isn't the leader, send the request to the leader")
+	// ErrDecommissionNotFound is returned when a node hasn't been decommissioned by this node.
+	ErrDecommissionNotFound = errcode.New(errcode.NotFound, "there isn't a decommission of that node")
+	// ErrDecommissionInProgress is returned when decommissioning a node which is already being decommissioned.
+	ErrDecommissionInProgress = errcode.New(errcode.Conflict, "node is already being decommissioned")
+)
+
+// DecommissionProgress is the progress of a node's decommission.
+type DecommissionProgress struct {
+	NodeID    string    `json:"nodeID"`
+	Step      string    `json:"step"`
+	Message   string    `json:"message"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Decommission starts removing a node from the cluster, it must be called on the leader.
+//
+// The node is demoted to non-voter first, so the quorum doesn't depend on it while it's removed.
+// Then, it waits until the node applied all the logs committed when it was demoted, and it's removed.
+//
+// It runs in the background, its progress can be checked with GetDecommission.
+func (n *Node) Decommission(id string, settleTimeout time.Duration) (DecommissionProgress, error) {
+	if n.Consensus.State() != raft.Leader {
+		return DecommissionProgress{}, ErrNotLeader
+	}
+	if id == n.ID {
+		return DecommissionProgress{}, errors.New("the leader can't be decommissioned, transfer the leadership first")
+	}
+	if !n.isNodeInConsensusServers(id) {
+		return DecommissionProgress{}, fmt.Errorf("node '%s' isn't part of the consensus", id)
+	}
+
+	n.Lock()
+	if n.decommissions == nil {
+		n.decommissions = make(map[string]*DecommissionProgress)
+	}
+	if p, ok := n.decommissions[id]; ok && p.Step != DecommissionDone && p.Step != DecommissionFailed {
+		n.Unlock()
+		return *p, ErrDecommissionInProgress
+	}
+	now := clock.Now()
+	progress := &DecommissionProgress{NodeID: id, StartedAt: now, UpdatedAt: now}
+	n.decommissions[id] = progress
+	n.Unlock()
+
+	n.setDecommissionStep(progress, DecommissionDemoting, "demoting the node to non-voter")
+	go n.decommission(progress, settleTimeout)
+	return n.GetDecommission(id)
+}
+
+// GetDecommission returns the progress of a node's decommission started on this node.
+func (n *Node) GetDecommission(id string) (DecommissionProgress, error) {
+	n.RLock()
+	defer n.RUnlock()
+	p, ok := n.decommissions[id]
+	if !ok {
+		return DecommissionProgress{}, ErrDecommissionNotFound
+	}
+	return *p, nil
+}
+
+func (n *Node) decommission(progress *DecommissionProgress, settleTimeout time.Duration) {
+	id := raft.ServerID(progress.NodeID)
+	errDemote := n.Consensus.DemoteVoter(id, 0, 0).Error()
+	if errDemote != nil {
+		n.setDecommissionStep(progress, DecommissionFailed, "couldn't demote the node: "+errDemote.Error())
+		return
+	}
+
+	target := n.ApplyLag().CommitIndex
+	n.setDecommissionStep(progress, DecommissionSettling, fmt.Sprintf("waiting for the node to apply index %v", target))
+	errSettle := waitForApplied(progress.NodeID, target, settleTimeout)
+	if errSettle != nil {
+		// The node is removed anyway, since it no longer counts for the quorum.
+		n.logger.Warn("decommissioned node didn't settle: " + errSettle.Error())
+	}
+
+	n.setDecommissionStep(progress, DecommissionRemoving, "removing the node from the consensus")
+	errRemove := n.Consensus.RemoveServer(id, 0, 0).Error()
+	if errRemove != nil {
+		n.setDecommissionStep(progress, DecommissionFailed, "couldn't remove the node: "+errRemove.Error())
+		return
+	}
+	n.RemoveFromShards(progress.NodeID)
+
+	msg := "node removed from the consensus"
+	if errSettle != nil {
+		msg += ", without confirming it settled: " + errSettle.Error()
+	}
+	n.setDecommissionStep(progress, DecommissionDone, msg)
+}
+
+func (n *Node) setDecommissionStep(progress *DecommissionProgress, step string, msg string) {
+	n.Lock()
+	defer n.Unlock()
+	progress.Step = step
+	progress.Message = msg
+	progress.UpdatedAt = clock.Now()
+	n.logger.Info(fmt.Sprintf("decommission of '%s': %s", progress.NodeID, msg))
+}
+
+// waitForApplied polls a node's status until it applied the target index.
+func waitForApplied(id string, target uint64, timeout time.Duration) error {
+	const interval = 500 * time.Millisecond
+	client := config.NewNodeAPIClient(interval)
+	deadline := clock.Now().Add(timeout)
+	url := config.MakeApiURL(id, "/cluster/status")
+
+	var lastErr error
+	for clock.Now().Before(deadline) {
+		applied, errStatus := getAppliedIndex(client, url)
+		if errStatus == nil && applied >= target {
+			return nil
+		}
+		lastErr = errStatus
+		if lastErr == nil {
+			lastErr = fmt.Errorf("node applied index %v of %v", applied, target)
+		}
+		clock.Sleep(interval)
+	}
+	return lastErr
+}
+
+func getAppliedIndex(client *http.Client, url string) (uint64, error) {
+	res, errGet := client.Get(url)
+	if errGet != nil {
+		return 0, errGet
+	}
+	defer res.Body.Close()
+
+	var status struct {
+		Data struct {
+			Apply ApplyLag `json:"apply"`
+		} `json:"data"`
+	}
+	errDecode := json.NewDecoder(res.Body).Decode(&status)
+	if errDecode != nil {
+		return 0, errDecode
+	}
+	return status.Data.Apply.AppliedIndex, nil
+}
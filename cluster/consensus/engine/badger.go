@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/narvikd/errorskit"
+	"io"
+)
+
+// badgerEngine is an Engine backed by badgerDB, which persists the data on disk.
+type badgerEngine struct {
+	db *badger.DB
+}
+
+// badgerTxn is a badger transaction.
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+// NewBadger returns an Engine backed by an opened badgerDB.
+func NewBadger(db *badger.DB) Engine {
+	return &badgerEngine{db: db}
+}
+
+func (e *badgerEngine) NewTransaction(update bool) Txn {
+	return &badgerTxn{txn: e.db.NewTransaction(update)}
+}
+
+func (e *badgerEngine) Snapshot(w io.Writer) error {
+	txn := e.NewTransaction(false)
+	defer txn.Discard()
+	return writeSnapshot(txn, w)
+}
+
+const (
+	// restoreBufferSize is the size of the buffers of key-values sent to the stream writer.
+	restoreBufferSize = 4 << 20
+	// restoreStreamSize is the size of the key ranges restored by each stream, the streams build their tables in parallel.
+	restoreStreamSize = 64 << 20
+)
+
+// Restore drops all the data and loads the snapshot with a stream writer, which builds the tables directly
+// instead of writing the key-values through the memtables, splitting the snapshot in key ranges built in parallel.
+//
+// The snapshot's keys must be sorted, as Snapshot writes them.
+func (e *badgerEngine) Restore(r io.Reader) error {
+	sw := e.db.NewStreamWriter()
+	errPrepare := sw.Prepare()
+	if errPrepare != nil {
+		sw.Cancel()
+		return errorskit.Wrap(errPrepare, "couldn't drop badgerDB data")
+	}
+
+	buf := z.NewBuffer(restoreBufferSize, "nubedb.Restore")
+	defer func() {
+		_ = buf.Release()
+	}()
+	var (
+		streamID   uint32 = 1
+		streamSize int
+		lastKey    []byte
+	)
+	errRead := readSnapshot(r, func(key []byte, value []byte) error {
+		if lastKey != nil && bytes.Compare(key, lastKey) <= 0 {
+			return fmt.Errorf("snapshot keys aren't sorted, '%s' comes after '%s'", key, lastKey)
+		}
+		lastKey = key
+		badger.KVToBuffer(&pb.KV{Key: key, Value: value, Version: 1, StreamId: streamID}, buf)
+		streamSize += len(key) + len(value)
+		if streamSize >= restoreStreamSize {
+			// The stream is closed so its last table is built while the next range is written.
+			badger.KVToBuffer(&pb.KV{StreamId: streamID, StreamDone: true}, buf)
+			streamID++
+			streamSize = 0
+		}
+		if buf.LenNoPadding() < restoreBufferSize {
+			return nil
+		}
+		errWrite := sw.Write(buf)
+		buf.Reset()
+		return errWrite
+	})
+	if errRead == nil {
+		errRead = sw.Write(buf)
+	}
+	if errRead != nil {
+		sw.Cancel()
+		return errRead
+	}
+	return sw.Flush()
+}
+
+func (e *badgerEngine) Size() (int64, int64) {
+	return e.db.Size()
+}
+
+func (e *badgerEngine) Close() error {
+	return e.db.Close()
+}
+
+func (t *badgerTxn) Get(key []byte) ([]byte, error) {
+	item, errGet := t.txn.Get(key)
+	if errGet != nil {
+		return nil, badgerErr(errGet)
+	}
+	return item.ValueCopy(nil)
+}
+
+// Meta uses a key-only iteration, so the value is never read from the value log.
+func (t *badgerTxn) Meta(key []byte) (Meta, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = key
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Seek(key)
+	if !it.Valid() || !bytes.Equal(it.Item().Key(), key) {
+		return Meta{}, ErrKeyNotFound
+	}
+
+	item := it.Item()
+	return Meta{
+		Size:      item.ValueSize(),
+		Version:   item.Version(),
+		ExpiresAt: item.ExpiresAt(),
+	}, nil
+}
+
+func (t *badgerTxn) Set(key []byte, value []byte) error {
+	return badgerErr(t.txn.Set(key, value))
+}
+
+func (t *badgerTxn) Delete(key []byte) error {
+	return badgerErr(t.txn.Delete(key))
+}
+
+func (t *badgerTxn) Iterate(opts IterOptions, fn func(key []byte, value []byte) error) error {
+	itOpts := badger.DefaultIteratorOptions
+	itOpts.PrefetchValues = !opts.KeysOnly
+	itOpts.Prefix = opts.Prefix
+	it := t.txn.NewIterator(itOpts)
+	defer it.Close()
+
+	start := opts.Prefix
+	if bytes.Compare(opts.Start, start) > 0 {
+		start = opts.Start
+	}
+	for it.Seek(start); it.Valid(); it.Next() {
+		item := it.Item()
+		var value []byte
+		if !opts.KeysOnly {
+			v, errVal := item.ValueCopy(nil)
+			if errVal != nil {
+				return errVal
+			}
+			value = v
+		}
+		errFn := fn(item.KeyCopy(nil), value)
+		if errors.Is(errFn, ErrStop) {
+			return nil
+		}
+		if errFn != nil {
+			return errFn
+		}
+	}
+	return nil
+}
+
+func (t *badgerTxn) Commit() error {
+	return badgerErr(t.txn.Commit())
+}
+
+func (t *badgerTxn) Discard() {
+	t.txn.Discard()
+}
+
+// badgerErr translates badger's errors into the engine's ones.
+func badgerErr(err error) error {
+	switch {
+	case errors.Is(err, badger.ErrKeyNotFound):
+		return ErrKeyNotFound
+	case errors.Is(err, badger.ErrReadOnlyTxn):
+		return ErrReadOnlyTxn
+	default:
+		return err
+	}
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"io"
+	"nubedb/pkg/bloom"
+	"sync"
+)
+
+// bloomFalsePositiveRate is the false positive rate of the keys' bloom filter.
+const bloomFalsePositiveRate = 0.01
+
+// KeyFilter answers whether a key may exist without reading the engine.
+type KeyFilter interface {
+	// MayContain returns false if the key doesn't exist, and true if it may exist.
+	MayContain(key []byte) bool
+	// Rebuild replaces the filter with one built from the keys in the engine, dropping the deleted keys.
+	Rebuild() error
+}
+
+// bloomEngine is an Engine which maintains a bloom filter of its keys.
+//
+// Deleted keys aren't removed from the filter, since bloom filters can't remove items,
+// they stay as false positives until the filter is rebuilt.
+type bloomEngine struct {
+	Engine
+	capacity uint64
+	// mu is held for reading while the written keys are added and committed,
+	// and for writing while a rebuild starts and ends, so no committed key is missed by a rebuild.
+	mu     sync.RWMutex
+	filter *bloom.Filter
+	// next is the filter being rebuilt, the committed keys are added to it too.
+	next *bloom.Filter
+}
+
+// bloomTxn is a transaction of a bloomEngine, which adds the keys it wrote to the filter when it's committed.
+type bloomTxn struct {
+	Txn
+	e       *bloomEngine
+	written [][]byte
+}
+
+// WithBloom wraps an Engine with a bloom filter of its keys, sized for capacity keys,
+// which grows when it's exceeded. The filter is built from the keys already in the engine.
+func WithBloom(e Engine, capacity uint64) (Engine, error) {
+	b := &bloomEngine{Engine: e, capacity: capacity}
+	errRebuild := b.Rebuild()
+	if errRebuild != nil {
+		return nil, errRebuild
+	}
+	return b, nil
+}
+
+func (e *bloomEngine) NewTransaction(update bool) Txn {
+	return &bloomTxn{Txn: e.Engine.NewTransaction(update), e: e}
+}
+
+func (e *bloomEngine) Restore(r io.Reader) error {
+	errRestore := e.Engine.Restore(r)
+	errRebuild := e.Rebuild()
+	if errRestore != nil {
+		return errRestore
+	}
+	return errRebuild
+}
+
+func (e *bloomEngine) MayContain(key []byte) bool {
+	e.mu.RLock()
+	filter := e.filter
+	e.mu.RUnlock()
+	return filter.MayContain(key)
+}
+
+func (e *bloomEngine) Rebuild() error {
+	next := bloom.New(e.capacity, bloomFalsePositiveRate)
+	e.mu.Lock()
+	e.next = next
+	e.mu.Unlock()
+
+	txn := e.Engine.NewTransaction(false)
+	defer txn.Discard()
+	errIterate := txn.Iterate(IterOptions{KeysOnly: true}, func(key []byte, _ []byte) error {
+		next.Add(key)
+		return nil
+	})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.next = nil
+	if errIterate != nil {
+		return errIterate
+	}
+	e.filter = next
+	return nil
+}
+
+func (t *bloomTxn) Set(key []byte, value []byte) error {
+	t.written = append(t.written, append([]byte(nil), key...))
+	return t.Txn.Set(key, value)
+}
+
+// Commit adds the written keys to the filter before they are persisted, so they are never reported as absent.
+func (t *bloomTxn) Commit() error {
+	if len(t.written) <= 0 {
+		return t.Txn.Commit()
+	}
+	t.e.mu.RLock()
+	defer t.e.mu.RUnlock()
+	for _, key := range t.written {
+		if t.e.filter != nil {
+			t.e.filter.Add(key)
+		}
+		if t.e.next != nil {
+			t.e.next.Add(key)
+		}
+	}
+	return t.Txn.Commit()
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"container/list"
+	"io"
+	"nubedb/internal/metrics"
+	"sync"
+)
+
+// cacheEngine is an Engine with an LRU cache of the values read by the read-only transactions.
+type cacheEngine struct {
+	Engine
+	cache *lruCache
+}
+
+// cacheTxn is a transaction of a cacheEngine.
+//
+// Read-only transactions read through the cache, update transactions bypass it,
+// and invalidate the keys they wrote when they are committed.
+//
+// A read-only transaction only reads the values cached by transactions which started at its generation or before,
+// the newer ones can have values written after its snapshot, so it reads those keys from the engine.
+type cacheTxn struct {
+	Txn
+	cache   *lruCache
+	update  bool
+	written map[string]bool
+	// generation is the cache's generation when the transaction started.
+	generation uint64
+}
+
+// WithCache wraps an Engine with an LRU cache of up to maxBytes of keys and values, for read-heavy workloads.
+func WithCache(e Engine, maxBytes int64) Engine {
+	return &cacheEngine{Engine: e, cache: newLRUCache(maxBytes)}
+}
+
+func (e *cacheEngine) NewTransaction(update bool) Txn {
+	// The generation is read before the snapshot is taken,
+	// so a value written after it can't be cached by the transaction with the generation of the write.
+	generation := e.cache.currentGeneration()
+	t := &cacheTxn{Txn: e.Engine.NewTransaction(update), cache: e.cache, update: update, generation: generation}
+	if update {
+		t.written = make(map[string]bool)
+	}
+	return t
+}
+
+func (e *cacheEngine) Restore(r io.Reader) error {
+	defer e.cache.purge()
+	return e.Engine.Restore(r)
+}
+
+func (t *cacheTxn) Get(key []byte) ([]byte, error) {
+	if t.update {
+		return t.Txn.Get(key)
+	}
+	if value, ok := t.cache.get(string(key), t.generation); ok {
+		return value, nil
+	}
+	value, errGet := t.Txn.Get(key)
+	if errGet != nil {
+		return nil, errGet
+	}
+	t.cache.add(string(key), value, t.generation)
+	return value, nil
+}
+
+func (t *cacheTxn) Set(key []byte, value []byte) error {
+	if t.update {
+		t.written[string(key)] = true
+	}
+	return t.Txn.Set(key, value)
+}
+
+func (t *cacheTxn) Delete(key []byte) error {
+	if t.update {
+		t.written[string(key)] = true
+	}
+	return t.Txn.Delete(key)
+}
+
+// Commit invalidates the written keys after they are persisted,
+// so the reads which started before can't cache the old values afterwards.
+//
+// While the commit is in progress the written keys bypass the cache,
+// since the transactions which start meanwhile may or may not see the new values.
+func (t *cacheTxn) Commit() error {
+	if !t.update || len(t.written) == 0 {
+		return t.Txn.Commit()
+	}
+	t.cache.startWrite(t.written)
+	errCommit := t.Txn.Commit()
+	t.cache.invalidate(t.written)
+	return errCommit
+}
+
+// lruCache is a least recently used cache bounded by the size of its keys and values.
+type lruCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	order    *list.List
+	// generation is increased on every invalidation, values read before it aren't added.
+	generation uint64
+	// writing counts the commits in progress writing each key, which bypass the cache until they are invalidated.
+	writing map[string]int
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+	// generation is the generation of the transaction which read the value,
+	// the key wasn't written since then or it would have been invalidated.
+	generation uint64
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		writing:  make(map[string]int),
+	}
+}
+
+func (c *lruCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// get returns a copy of a cached value, if it was read by a transaction which started at generation or before,
+// and the key isn't being written.
+func (c *lruCache) get(key string, generation uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok || c.writing[key] > 0 || elem.Value.(*lruEntry).generation > generation {
+		metrics.RecordCacheRead(false)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	metrics.RecordCacheRead(true)
+	return append([]byte(nil), elem.Value.(*lruEntry).value...), true
+}
+
+// add caches a value read in a transaction started at a generation,
+// it's discarded if there was an invalidation since then, since it could be stale.
+func (c *lruCache) add(key string, value []byte, generation uint64) {
+	size := int64(len(key) + len(value))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || size > c.maxBytes || c.writing[key] > 0 {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	entry := &lruEntry{key: key, value: append([]byte(nil), value...), generation: generation}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// startWrite makes the keys being committed bypass the cache, until they are invalidated.
+func (c *lruCache) startWrite(keys map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range keys {
+		c.writing[k]++
+	}
+}
+
+// invalidate removes the keys written by a commit, ending their write.
+func (c *lruCache) invalidate(keys map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for k := range keys {
+		if elem, ok := c.entries[k]; ok {
+			c.remove(elem)
+		}
+		if c.writing[k]--; c.writing[k] <= 0 {
+			delete(c.writing, k)
+		}
+	}
+}
+
+func (c *lruCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.size = 0
+}
+
+func (c *lruCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.key) + len(entry.value))
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"testing"
+)
+
+func newCachedBadger(t *testing.T) Engine {
+	t.Helper()
+	db, errOpen := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if errOpen != nil {
+		t.Fatal(errOpen)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return WithCache(NewBadger(db), 1<<20)
+}
+
+func set(t *testing.T, e Engine, key string, value string) {
+	t.Helper()
+	txn := e.NewTransaction(true)
+	defer txn.Discard()
+	if errSet := txn.Set([]byte(key), []byte(value)); errSet != nil {
+		t.Fatal(errSet)
+	}
+	if errCommit := txn.Commit(); errCommit != nil {
+		t.Fatal(errCommit)
+	}
+}
+
+func get(t *testing.T, txn Txn, key string) string {
+	t.Helper()
+	value, errGet := txn.Get([]byte(key))
+	if errGet != nil {
+		t.Fatal(errGet)
+	}
+	return string(value)
+}
+
+func TestCacheKeepsSnapshotIsolation(t *testing.T) {
+	e := newCachedBadger(t)
+	set(t, e, "k", "v1")
+
+	older := e.NewTransaction(false)
+	defer older.Discard()
+	set(t, e, "k", "v2")
+
+	// A newer transaction caches the new value, which the older one must not read.
+	newer := e.NewTransaction(false)
+	defer newer.Discard()
+	if got := get(t, newer, "k"); got != "v2" {
+		t.Fatalf("the newer transaction read %q, want %q", got, "v2")
+	}
+	if got := get(t, older, "k"); got != "v1" {
+		t.Errorf("the older transaction read %q, want %q", got, "v1")
+	}
+}
+
+func TestCacheServesOlderValuesToNewerTransactions(t *testing.T) {
+	e := newCachedBadger(t)
+	set(t, e, "k", "v1")
+
+	first := e.NewTransaction(false)
+	get(t, first, "k")
+	first.Discard()
+	set(t, e, "other", "v")
+
+	// The key wasn't written since it was cached, so the newer transaction reads it from the cache.
+	second := e.NewTransaction(false)
+	defer second.Discard()
+	if got := get(t, second, "k"); got != "v1" {
+		t.Errorf("read %q, want %q", got, "v1")
+	}
+}
+
+func TestCacheIsInvalidatedByWrites(t *testing.T) {
+	e := newCachedBadger(t)
+	set(t, e, "k", "v1")
+
+	first := e.NewTransaction(false)
+	get(t, first, "k")
+	first.Discard()
+	set(t, e, "k", "v2")
+
+	second := e.NewTransaction(false)
+	defer second.Discard()
+	if got := get(t, second, "k"); got != "v2" {
+		t.Errorf("read %q, want %q", got, "v2")
+	}
+}
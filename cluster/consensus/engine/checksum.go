@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/metrics"
+)
+
+const (
+	// checksumMarker is the first byte of the values stored with a checksum.
+	//
+	// It's never valid in UTF-8, so it can't be the first byte of the values stored before the checksums were added,
+	// which are always JSON or text.
+	checksumMarker byte = 0xff
+	// checksumHeaderSize is the size of the marker and the checksum stored before each value.
+	checksumHeaderSize = 1 + crc32.Size
+)
+
+// ErrCorrupted is returned when a stored value doesn't match its checksum.
+var ErrCorrupted = errcode.New(errcode.DataCorrupted, "value is corrupted, its checksum doesn't match")
+
+// crcTable is the Castagnoli table, which is hardware accelerated on most platforms.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumEngine is an Engine which stores a checksum of each key-value with its value,
+// and verifies it every time the value is read.
+type checksumEngine struct {
+	Engine
+}
+
+// checksumTxn is a transaction of a checksumEngine.
+type checksumTxn struct {
+	Txn
+}
+
+// WithChecksums wraps an Engine, so the values are stored with a checksum which is verified when they are read,
+// or restored from a snapshot.
+//
+// The values stored before without a checksum are still read, but they can't be verified.
+func WithChecksums(e Engine) Engine {
+	return &checksumEngine{Engine: e}
+}
+
+func (e *checksumEngine) NewTransaction(update bool) Txn {
+	return &checksumTxn{Txn: e.Engine.NewTransaction(update)}
+}
+
+// Restore verifies the checksums of the snapshot's values while they are restored,
+// failing the restore at the first corrupted value.
+func (e *checksumEngine) Restore(r io.Reader) error {
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(pw)
+		errRead := readSnapshot(r, func(key []byte, value []byte) error {
+			_, errVerify := verifyChecksum(key, value)
+			if errVerify != nil {
+				return errVerify
+			}
+			return writeSnapshotEntry(bw, key, value)
+		})
+		if errRead == nil {
+			errRead = bw.Flush()
+		}
+		_ = pw.CloseWithError(errRead)
+	}()
+
+	errRestore := e.Engine.Restore(pr)
+	_ = pr.Close()
+	return errRestore
+}
+
+func (t *checksumTxn) Get(key []byte) ([]byte, error) {
+	stored, errGet := t.Txn.Get(key)
+	if errGet != nil {
+		return nil, errGet
+	}
+	return verifyChecksum(key, stored)
+}
+
+// Meta returns the size of the value without its checksum.
+//
+// The value isn't read, so the values stored before without a checksum are reported smaller, until they are set again.
+func (t *checksumTxn) Meta(key []byte) (Meta, error) {
+	meta, errMeta := t.Txn.Meta(key)
+	if errMeta != nil {
+		return Meta{}, errMeta
+	}
+	if meta.Size >= checksumHeaderSize {
+		meta.Size -= checksumHeaderSize
+	}
+	return meta, nil
+}
+
+func (t *checksumTxn) Set(key []byte, value []byte) error {
+	return t.Txn.Set(key, addChecksum(key, value))
+}
+
+func (t *checksumTxn) Iterate(opts IterOptions, fn func(key []byte, value []byte) error) error {
+	return t.Txn.Iterate(opts, func(key []byte, stored []byte) error {
+		if opts.KeysOnly {
+			return fn(key, nil)
+		}
+		value, errVerify := verifyChecksum(key, stored)
+		if errVerify != nil {
+			return errVerify
+		}
+		return fn(key, value)
+	})
+}
+
+// addChecksum returns the value prefixed with the marker and the checksum of the key-value.
+func addChecksum(key []byte, value []byte) []byte {
+	stored := make([]byte, checksumHeaderSize, checksumHeaderSize+len(value))
+	stored[0] = checksumMarker
+	binary.BigEndian.PutUint32(stored[1:checksumHeaderSize], checksum(key, value))
+	return append(stored, value...)
+}
+
+// verifyChecksum returns the value of a stored key-value, or ErrCorrupted if it doesn't match its checksum.
+//
+// The key is part of the checksum, so a value stored under another key is also detected.
+func verifyChecksum(key []byte, stored []byte) ([]byte, error) {
+	if len(stored) == 0 || stored[0] != checksumMarker {
+		return stored, nil
+	}
+	if len(stored) < checksumHeaderSize {
+		metrics.RecordCorruption()
+		return nil, fmt.Errorf("%w: key '%s' is truncated", ErrCorrupted, key)
+	}
+
+	value := stored[checksumHeaderSize:]
+	if binary.BigEndian.Uint32(stored[1:checksumHeaderSize]) != checksum(key, value) {
+		metrics.RecordCorruption()
+		return nil, fmt.Errorf("%w: key '%s'", ErrCorrupted, key)
+	}
+	return value, nil
+}
+
+func checksum(key []byte, value []byte) uint32 {
+	return crc32.Update(crc32.Checksum(key, crcTable), crcTable, value)
+}
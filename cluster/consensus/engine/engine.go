@@ -0,0 +1,83 @@
+// Package engine abstracts the key-value storage used by the FSM, so it can be backed by different databases.
+package engine
+
+import (
+	"errors"
+	"io"
+	"nubedb/cluster/errcode"
+)
+
+var (
+	// ErrKeyNotFound is returned when a key doesn't exist.
+	ErrKeyNotFound = errcode.New(errcode.NotFound, "Key not found")
+	// ErrStop can be returned by an iteration function to stop the iteration without an error.
+	ErrStop = errors.New("stop iteration")
+	// ErrReadOnlyTxn is returned when writing in a read-only transaction.
+	ErrReadOnlyTxn = errors.New("transaction is read-only")
+)
+
+// Names of the available engines.
+const (
+	Badger = "badger"
+	Pebble = "pebble"
+	SQLite = "sqlite"
+	Memory = "memory"
+)
+
+// Engine is a transactional, ordered, key-value storage.
+type Engine interface {
+	// NewTransaction creates a transaction, update must be true to write on it.
+	//
+	// Transactions must always be discarded, writes are only persisted if Commit is called before.
+	NewTransaction(update bool) Txn
+	// Snapshot writes all the key-values of the engine to w, in a format any engine can restore.
+	Snapshot(w io.Writer) error
+	// Restore replaces all the key-values of the engine with the ones of a snapshot.
+	Restore(r io.Reader) error
+	// Size returns the size of the engine's index and of its values, in bytes.
+	// Engines which don't separate them return everything as the index size.
+	Size() (int64, int64)
+	// Close closes the engine, it can't be used afterwards.
+	Close() error
+}
+
+// Txn is a transaction of an Engine.
+type Txn interface {
+	// Get returns a copy of the value of a key, or ErrKeyNotFound.
+	Get(key []byte) ([]byte, error)
+	// Meta returns the metadata of a key without reading its value, or ErrKeyNotFound.
+	Meta(key []byte) (Meta, error)
+	// Set sets the value of a key.
+	Set(key []byte, value []byte) error
+	// Delete deletes a key, deleting a key which doesn't exist isn't an error.
+	Delete(key []byte) error
+	// Iterate calls fn with copies of the key-values matching opts, in ascending key order.
+	//
+	// If fn returns an error the iteration stops, and the error is returned, unless it's ErrStop.
+	// Writes can't be done inside fn, the keys must be collected and written after the iteration.
+	Iterate(opts IterOptions, fn func(key []byte, value []byte) error) error
+	// Commit persists the writes of the transaction.
+	Commit() error
+	// Discard releases the transaction, it's a no-op after Commit.
+	Discard()
+}
+
+// IterOptions defines which key-values are iterated.
+type IterOptions struct {
+	// Prefix only iterates the keys starting with it.
+	Prefix []byte
+	// Start only iterates the keys equal or greater than it.
+	Start []byte
+	// KeysOnly doesn't read the values, fn receives a nil value.
+	KeysOnly bool
+}
+
+// Meta holds the metadata of a stored key.
+type Meta struct {
+	// Size is the size of the value in bytes.
+	Size int64
+	// Version is increased every time the key is written.
+	Version uint64
+	// ExpiresAt is the unix time when the key expires, 0 if it doesn't.
+	ExpiresAt uint64
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// memoryEngine is an Engine which keeps the data in memory, it's lost when the process exits.
+//
+// Its transactions read the latest committed data, and their writes are applied atomically on commit.
+type memoryEngine struct {
+	mu      sync.RWMutex
+	data    map[string][]byte
+	meta    map[string]Meta
+	version uint64
+}
+
+// memoryTxn is a transaction of memoryEngine, its writes are buffered until it's committed.
+type memoryTxn struct {
+	e      *memoryEngine
+	update bool
+	// writes holds the pending writes, a nil value is a delete.
+	writes map[string][]byte
+}
+
+// NewMemory returns an empty Engine which keeps the data in memory.
+func NewMemory() Engine {
+	return &memoryEngine{
+		data: make(map[string][]byte),
+		meta: make(map[string]Meta),
+	}
+}
+
+func (e *memoryEngine) NewTransaction(update bool) Txn {
+	return &memoryTxn{e: e, update: update, writes: make(map[string][]byte)}
+}
+
+func (e *memoryEngine) Snapshot(w io.Writer) error {
+	txn := e.NewTransaction(false)
+	defer txn.Discard()
+	return writeSnapshot(txn, w)
+}
+
+func (e *memoryEngine) Restore(r io.Reader) error {
+	data := make(map[string][]byte)
+	errRead := readSnapshot(r, func(key []byte, value []byte) error {
+		data[string(key)] = value
+		return nil
+	})
+	if errRead != nil {
+		return errRead
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.version++
+	e.data = data
+	e.meta = make(map[string]Meta, len(data))
+	for k, v := range data {
+		e.meta[k] = Meta{Size: int64(len(v)), Version: e.version}
+	}
+	return nil
+}
+
+func (e *memoryEngine) Size() (int64, int64) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var size int64
+	for k, v := range e.data {
+		size += int64(len(k) + len(v))
+	}
+	return size, 0
+}
+
+func (e *memoryEngine) Close() error {
+	return nil
+}
+
+func (t *memoryTxn) Get(key []byte) ([]byte, error) {
+	if value, ok := t.writes[string(key)]; ok {
+		if value == nil {
+			return nil, ErrKeyNotFound
+		}
+		return append([]byte{}, value...), nil
+	}
+
+	t.e.mu.RLock()
+	defer t.e.mu.RUnlock()
+	value, ok := t.e.data[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (t *memoryTxn) Meta(key []byte) (Meta, error) {
+	if value, ok := t.writes[string(key)]; ok {
+		if value == nil {
+			return Meta{}, ErrKeyNotFound
+		}
+		return Meta{Size: int64(len(value))}, nil
+	}
+
+	t.e.mu.RLock()
+	defer t.e.mu.RUnlock()
+	meta, ok := t.e.meta[string(key)]
+	if !ok {
+		return Meta{}, ErrKeyNotFound
+	}
+	return meta, nil
+}
+
+func (t *memoryTxn) Set(key []byte, value []byte) error {
+	if !t.update {
+		return ErrReadOnlyTxn
+	}
+	t.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *memoryTxn) Delete(key []byte) error {
+	if !t.update {
+		return ErrReadOnlyTxn
+	}
+	t.writes[string(key)] = nil
+	return nil
+}
+
+func (t *memoryTxn) Iterate(opts IterOptions, fn func(key []byte, value []byte) error) error {
+	// The matching key-values are copied first, so fn runs without holding the lock.
+	matches := make(map[string][]byte)
+	t.e.mu.RLock()
+	for k, v := range t.e.data {
+		if t.matches(k, opts) {
+			matches[k] = v
+		}
+	}
+	t.e.mu.RUnlock()
+	for k, v := range t.writes {
+		if !t.matches(k, opts) {
+			continue
+		}
+		if v == nil {
+			delete(matches, k)
+			continue
+		}
+		matches[k] = v
+	}
+
+	keys := make([]string, 0, len(matches))
+	for k := range matches {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var value []byte
+		if !opts.KeysOnly {
+			value = append([]byte{}, matches[k]...)
+		}
+		errFn := fn([]byte(k), value)
+		if errors.Is(errFn, ErrStop) {
+			return nil
+		}
+		if errFn != nil {
+			return errFn
+		}
+	}
+	return nil
+}
+
+func (t *memoryTxn) matches(k string, opts IterOptions) bool {
+	return bytes.HasPrefix([]byte(k), opts.Prefix) && bytes.Compare([]byte(k), opts.Start) >= 0
+}
+
+func (t *memoryTxn) Commit() error {
+	if len(t.writes) == 0 {
+		return nil
+	}
+	if !t.update {
+		return ErrReadOnlyTxn
+	}
+
+	t.e.mu.Lock()
+	defer t.e.mu.Unlock()
+	t.e.version++
+	for k, v := range t.writes {
+		if v == nil {
+			delete(t.e.data, k)
+			delete(t.e.meta, k)
+			continue
+		}
+		t.e.data[k] = v
+		t.e.meta[k] = Meta{Size: int64(len(v)), Version: t.e.version}
+	}
+	t.writes = make(map[string][]byte)
+	return nil
+}
+
+func (t *memoryTxn) Discard() {
+	t.writes = nil
+}
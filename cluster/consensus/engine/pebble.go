@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/narvikd/errorskit"
+	"io"
+)
+
+// pebbleEngine is an Engine backed by Pebble, which persists the data on disk.
+type pebbleEngine struct {
+	db *pebble.DB
+}
+
+// pebbleTxn is a Pebble transaction.
+//
+// Update transactions are indexed batches, so they read their own writes.
+// Read-only transactions read from a snapshot, so they see a consistent view of the data.
+type pebbleTxn struct {
+	reader pebble.Reader
+	batch  *pebble.Batch
+	snap   *pebble.Snapshot
+}
+
+// NewPebble returns an Engine backed by an opened Pebble DB.
+func NewPebble(db *pebble.DB) Engine {
+	return &pebbleEngine{db: db}
+}
+
+func (e *pebbleEngine) NewTransaction(update bool) Txn {
+	if update {
+		batch := e.db.NewIndexedBatch()
+		return &pebbleTxn{reader: batch, batch: batch}
+	}
+	snap := e.db.NewSnapshot()
+	return &pebbleTxn{reader: snap, snap: snap}
+}
+
+func (e *pebbleEngine) Snapshot(w io.Writer) error {
+	txn := e.NewTransaction(false)
+	defer txn.Discard()
+	return writeSnapshot(txn, w)
+}
+
+// Restore deletes all the keys and loads the snapshot in a single batch, so the replacement is atomic.
+func (e *pebbleEngine) Restore(r io.Reader) error {
+	txn := e.NewTransaction(true)
+	defer txn.Discard()
+
+	var keys [][]byte
+	errIterate := txn.Iterate(IterOptions{KeysOnly: true}, func(key []byte, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if errIterate != nil {
+		return errIterate
+	}
+	for _, k := range keys {
+		errDelete := txn.Delete(k)
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+
+	errRead := readSnapshot(r, txn.Set)
+	if errRead != nil {
+		return errRead
+	}
+	return txn.Commit()
+}
+
+// Size returns all the disk space used by Pebble as the index size, since it doesn't separate the values.
+func (e *pebbleEngine) Size() (int64, int64) {
+	return int64(e.db.Metrics().DiskSpaceUsage()), 0
+}
+
+func (e *pebbleEngine) Close() error {
+	return e.db.Close()
+}
+
+func (t *pebbleTxn) Get(key []byte) ([]byte, error) {
+	value, closer, errGet := t.reader.Get(key)
+	if errGet != nil {
+		return nil, pebbleErr(errGet)
+	}
+	defer closer.Close()
+	return append([]byte{}, value...), nil
+}
+
+// Meta reads the value to know its size, since Pebble doesn't keep it apart from the value.
+//
+// Pebble doesn't expose the versions of the keys, so the version is always 0.
+func (t *pebbleTxn) Meta(key []byte) (Meta, error) {
+	value, closer, errGet := t.reader.Get(key)
+	if errGet != nil {
+		return Meta{}, pebbleErr(errGet)
+	}
+	defer closer.Close()
+	return Meta{Size: int64(len(value))}, nil
+}
+
+func (t *pebbleTxn) Set(key []byte, value []byte) error {
+	if t.batch == nil {
+		return ErrReadOnlyTxn
+	}
+	return t.batch.Set(key, value, nil)
+}
+
+func (t *pebbleTxn) Delete(key []byte) error {
+	if t.batch == nil {
+		return ErrReadOnlyTxn
+	}
+	return t.batch.Delete(key, nil)
+}
+
+func (t *pebbleTxn) Iterate(opts IterOptions, fn func(key []byte, value []byte) error) error {
+	it := t.reader.NewIter(&pebble.IterOptions{
+		LowerBound: opts.Prefix,
+		UpperBound: prefixUpperBound(opts.Prefix),
+	})
+	defer it.Close()
+
+	var valid bool
+	if len(opts.Start) > 0 {
+		valid = it.SeekGE(opts.Start)
+	} else {
+		valid = it.First()
+	}
+	for ; valid; valid = it.Next() {
+		var value []byte
+		if !opts.KeysOnly {
+			value = append([]byte{}, it.Value()...)
+		}
+		errFn := fn(append([]byte{}, it.Key()...), value)
+		if errors.Is(errFn, ErrStop) {
+			return nil
+		}
+		if errFn != nil {
+			return errFn
+		}
+	}
+	return it.Error()
+}
+
+func (t *pebbleTxn) Commit() error {
+	if t.batch == nil {
+		return nil
+	}
+	// Writes aren't synced, like in badger, since the consensus logs are the durable record of them.
+	errCommit := t.batch.Commit(pebble.NoSync)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit pebble batch")
+	}
+	return nil
+}
+
+func (t *pebbleTxn) Discard() {
+	if t.batch != nil {
+		_ = t.batch.Close()
+		t.batch = nil
+	}
+	if t.snap != nil {
+		_ = t.snap.Close()
+		t.snap = nil
+	}
+}
+
+// prefixUpperBound returns the smallest key greater than all the keys starting with prefix,
+// nil if there isn't one.
+func prefixUpperBound(prefix []byte) []byte {
+	upper := append([]byte{}, prefix...)
+	for i := len(upper) - 1; i >= 0; i-- {
+		upper[i]++
+		if upper[i] != 0 {
+			return upper[:i+1]
+		}
+	}
+	return nil
+}
+
+// pebbleErr translates Pebble's errors into the engine's ones.
+func pebbleErr(err error) error {
+	if errors.Is(err, pebble.ErrNotFound) {
+		return ErrKeyNotFound
+	}
+	return err
+}
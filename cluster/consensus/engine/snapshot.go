@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"io"
+)
+
+// The snapshot format is a sequence of key-values, each one written as:
+// uvarint(len(key)) key uvarint(len(value)) value
+
+// writeSnapshot writes all the key-values of a transaction to w.
+func writeSnapshot(txn Txn, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	errIterate := txn.Iterate(IterOptions{}, func(key []byte, value []byte) error {
+		return writeSnapshotEntry(bw, key, value)
+	})
+	if errIterate != nil {
+		return errorskit.Wrap(errIterate, "couldn't write snapshot")
+	}
+	return bw.Flush()
+}
+
+// writeSnapshotEntry writes a key-value in the snapshot format.
+func writeSnapshotEntry(w io.Writer, key []byte, value []byte) error {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, b := range [][]byte{key, value} {
+		n := binary.PutUvarint(lenBuf, uint64(len(b)))
+		_, errLen := w.Write(lenBuf[:n])
+		if errLen != nil {
+			return errLen
+		}
+		_, errData := w.Write(b)
+		if errData != nil {
+			return errData
+		}
+	}
+	return nil
+}
+
+// readSnapshot reads the key-values of a snapshot, calling fn for each one.
+func readSnapshot(r io.Reader, fn func(key []byte, value []byte) error) error {
+	br := bufio.NewReader(r)
+	for {
+		key, errKey := readChunk(br)
+		if errors.Is(errKey, io.EOF) {
+			return nil
+		}
+		if errKey != nil {
+			return errorskit.Wrap(errKey, "couldn't read snapshot key")
+		}
+		value, errValue := readChunk(br)
+		if errValue != nil {
+			return errorskit.Wrap(errValue, "couldn't read snapshot value")
+		}
+		errFn := fn(key, value)
+		if errFn != nil {
+			return errFn
+		}
+	}
+}
+
+func readChunk(br *bufio.Reader) ([]byte, error) {
+	n, errLen := binary.ReadUvarint(br)
+	if errLen != nil {
+		return nil, errLen
+	}
+	b := make([]byte, n)
+	_, errRead := io.ReadFull(br, b)
+	if errRead != nil {
+		return nil, errRead
+	}
+	return b, nil
+}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"io"
+	_ "modernc.org/sqlite" // Pure Go driver, so nubedb can still be cross compiled without cgo
+	"net/url"
+)
+
+// sqliteSchema stores the key-values in a single table, BLOBs are compared byte by byte, so they are sorted like in badger.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS kv (
+	key BLOB PRIMARY KEY,
+	value BLOB NOT NULL,
+	version INTEGER NOT NULL
+) WITHOUT ROWID`
+
+// sqliteEngine is an Engine backed by a single SQLite file.
+type sqliteEngine struct {
+	db *sql.DB
+}
+
+// sqliteTxn is a SQLite transaction, err holds the error of beginning it, which is returned by all its methods.
+type sqliteTxn struct {
+	tx     *sql.Tx
+	update bool
+	err    error
+}
+
+// OpenSQLite opens, or creates, the SQLite database stored in a file.
+//
+// The WAL journal is used, so the transactions reading don't block the one writing.
+func OpenSQLite(path string) (Engine, error) {
+	q := url.Values{}
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "busy_timeout(10000)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	// Write transactions take the lock when they begin, so two of them can't deadlock upgrading their locks.
+	q.Add("_txlock", "immediate")
+
+	db, errOpen := sql.Open("sqlite", fmt.Sprintf("file:%s?%s", path, q.Encode()))
+	if errOpen != nil {
+		return nil, errorskit.Wrap(errOpen, "couldn't open sqlite DB")
+	}
+	_, errSchema := db.Exec(sqliteSchema)
+	if errSchema != nil {
+		_ = db.Close()
+		return nil, errorskit.Wrap(errSchema, "couldn't create sqlite schema")
+	}
+	return &sqliteEngine{db: db}, nil
+}
+
+func (e *sqliteEngine) NewTransaction(update bool) Txn {
+	tx, errBegin := e.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: !update})
+	if errBegin != nil {
+		return &sqliteTxn{err: errorskit.Wrap(errBegin, "couldn't begin sqlite transaction")}
+	}
+	if !update {
+		// SQLite takes the snapshot on the first read, not when the transaction begins,
+		// so it's read now, and the transaction sees the data committed before it was created, like in the other engines.
+		var exists int
+		errSnapshot := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM kv)").Scan(&exists)
+		if errSnapshot != nil {
+			_ = tx.Rollback()
+			return &sqliteTxn{err: errorskit.Wrap(errSnapshot, "couldn't begin sqlite transaction")}
+		}
+	}
+	return &sqliteTxn{tx: tx, update: update}
+}
+
+func (e *sqliteEngine) Snapshot(w io.Writer) error {
+	txn := e.NewTransaction(false)
+	defer txn.Discard()
+	return writeSnapshot(txn, w)
+}
+
+// Restore replaces all the key-values in a single transaction, so the replacement is atomic.
+func (e *sqliteEngine) Restore(r io.Reader) error {
+	txn := e.NewTransaction(true)
+	defer txn.Discard()
+
+	t := txn.(*sqliteTxn)
+	if t.err != nil {
+		return t.err
+	}
+	_, errDelete := t.tx.Exec("DELETE FROM kv")
+	if errDelete != nil {
+		return errorskit.Wrap(errDelete, "couldn't delete sqlite data")
+	}
+
+	errRead := readSnapshot(r, txn.Set)
+	if errRead != nil {
+		return errRead
+	}
+	return txn.Commit()
+}
+
+// Size returns the size of the database file as the index size, since SQLite doesn't separate the values.
+func (e *sqliteEngine) Size() (int64, int64) {
+	var pages, pageSize int64
+	_ = e.db.QueryRow("PRAGMA page_count").Scan(&pages)
+	_ = e.db.QueryRow("PRAGMA page_size").Scan(&pageSize)
+	return pages * pageSize, 0
+}
+
+func (e *sqliteEngine) Close() error {
+	return e.db.Close()
+}
+
+func (t *sqliteTxn) Get(key []byte) ([]byte, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	var value []byte
+	errScan := t.tx.QueryRow("SELECT value FROM kv WHERE key = ?", key).Scan(&value)
+	if errScan != nil {
+		return nil, sqliteErr(errScan)
+	}
+	return value, nil
+}
+
+func (t *sqliteTxn) Meta(key []byte) (Meta, error) {
+	if t.err != nil {
+		return Meta{}, t.err
+	}
+	var meta Meta
+	errScan := t.tx.QueryRow("SELECT length(value), version FROM kv WHERE key = ?", key).Scan(&meta.Size, &meta.Version)
+	if errScan != nil {
+		return Meta{}, sqliteErr(errScan)
+	}
+	return meta, nil
+}
+
+func (t *sqliteTxn) Set(key []byte, value []byte) error {
+	if t.err != nil {
+		return t.err
+	}
+	if !t.update {
+		return ErrReadOnlyTxn
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	_, errExec := t.tx.Exec(
+		`INSERT INTO kv (key, value, version) VALUES (?, ?, 1)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, version = kv.version + 1`,
+		key, value,
+	)
+	return errExec
+}
+
+func (t *sqliteTxn) Delete(key []byte) error {
+	if t.err != nil {
+		return t.err
+	}
+	if !t.update {
+		return ErrReadOnlyTxn
+	}
+	_, errExec := t.tx.Exec("DELETE FROM kv WHERE key = ?", key)
+	return errExec
+}
+
+func (t *sqliteTxn) Iterate(opts IterOptions, fn func(key []byte, value []byte) error) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	query := "SELECT key, value FROM kv WHERE key >= ?"
+	if opts.KeysOnly {
+		query = "SELECT key, NULL FROM kv WHERE key >= ?"
+	}
+	start := opts.Prefix
+	if string(opts.Start) > string(start) {
+		start = opts.Start
+	}
+	args := []any{nonNil(start)}
+	if upper := prefixUpperBound(opts.Prefix); upper != nil {
+		query += " AND key < ?"
+		args = append(args, upper)
+	}
+	query += " ORDER BY key"
+
+	rows, errQuery := t.tx.Query(query, args...)
+	if errQuery != nil {
+		return errQuery
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value []byte
+		errScan := rows.Scan(&key, &value)
+		if errScan != nil {
+			return errScan
+		}
+		errFn := fn(key, value)
+		if errors.Is(errFn, ErrStop) {
+			return nil
+		}
+		if errFn != nil {
+			return errFn
+		}
+	}
+	return rows.Err()
+}
+
+func (t *sqliteTxn) Commit() error {
+	if t.err != nil {
+		return t.err
+	}
+	errCommit := t.tx.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit sqlite transaction")
+	}
+	return nil
+}
+
+func (t *sqliteTxn) Discard() {
+	if t.tx != nil {
+		// Rolling back a committed transaction is a no-op, it only returns sql.ErrTxDone.
+		_ = t.tx.Rollback()
+	}
+}
+
+// nonNil returns an empty slice instead of nil, since a nil BLOB is NULL, and comparing with NULL never matches.
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// sqliteErr translates SQLite's errors into the engine's ones.
+func sqliteErr(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrKeyNotFound
+	}
+	return err
+}
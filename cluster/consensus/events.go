@@ -0,0 +1,136 @@
+package consensus
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	"sync"
+	"time"
+)
+
+// Types of the cluster events.
+const (
+	EventStateChange     = "state_change"
+	EventLeaderChange    = "leader_change"
+	EventPeerChange      = "peer_change"
+	EventFailedHeartbeat = "failed_heartbeat"
+)
+
+// eventsBuffer is how many events a subscriber can fall behind before its events are dropped.
+const eventsBuffer = 64
+
+// ClusterEvent is a change of the consensus observed by this node.
+type ClusterEvent struct {
+	Type  string    `json:"type"`
+	Node  string    `json:"node"`
+	Shard int       `json:"shard"`
+	Time  time.Time `json:"time"`
+	// State is the new state of this node, for state changes.
+	State string `json:"state,omitempty"`
+	// LeaderID is the new leader, empty if there's none, for leader changes.
+	LeaderID string `json:"leaderId,omitempty"`
+	// PeerID is the peer added, removed or not answering the heartbeats, for peer changes and failed heartbeats.
+	PeerID      string `json:"peerId,omitempty"`
+	PeerAddress string `json:"peerAddress,omitempty"`
+	Removed     bool   `json:"removed,omitempty"`
+	// LastContact is the last time the leader heard from the peer, for failed heartbeats.
+	LastContact *time.Time `json:"lastContact,omitempty"`
+}
+
+// EventTypes validates a list of event types and returns them as a set. An empty list returns nil, which selects all of them.
+func EventTypes(types []string) (map[string]bool, error) {
+	if len(types) <= 0 {
+		return nil, nil
+	}
+	set := make(map[string]bool, len(types))
+	for _, t := range types {
+		switch t {
+		case EventStateChange, EventLeaderChange, EventPeerChange, EventFailedHeartbeat:
+			set[t] = true
+		default:
+			return nil, fmt.Errorf("event type not recognized: '%s', it must be %s, %s, %s or %s",
+				t, EventStateChange, EventLeaderChange, EventPeerChange, EventFailedHeartbeat)
+		}
+	}
+	return set, nil
+}
+
+// eventHub fans out the cluster events to their subscribers.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan ClusterEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan ClusterEvent]struct{})}
+}
+
+// SubscribeEvents returns a channel receiving the cluster events observed from now on, and a function to unsubscribe.
+//
+// The events of a subscriber which doesn't keep up are dropped, so the consensus is never blocked.
+func (n *Node) SubscribeEvents() (<-chan ClusterEvent, func()) {
+	ch := make(chan ClusterEvent, eventsBuffer)
+	n.events.mu.Lock()
+	n.events.subscribers[ch] = struct{}{}
+	n.events.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			n.events.mu.Lock()
+			delete(n.events.subscribers, ch)
+			n.events.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+func (n *Node) publishEvent(e ClusterEvent) {
+	n.events.mu.Lock()
+	defer n.events.mu.Unlock()
+	for ch := range n.events.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// registerEventsObserver registers an observer publishing the changes of a shard's consensus as cluster events.
+func (n *Node) registerEventsObserver(shardID int, r *raft.Raft) {
+	observations := make(chan raft.Observation, eventsBuffer)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.RaftState, raft.LeaderObservation, raft.PeerObservation, raft.FailedHeartbeatObservation:
+			return true
+		default:
+			return false
+		}
+	})
+	r.RegisterObserver(observer)
+
+	go func() {
+		for o := range observations {
+			e := ClusterEvent{Node: n.ID, Shard: shardID, Time: time.Now()}
+			switch obs := o.Data.(type) {
+			case raft.RaftState:
+				e.Type = EventStateChange
+				e.State = obs.String()
+			case raft.LeaderObservation:
+				e.Type = EventLeaderChange
+				e.LeaderID = string(obs.LeaderID)
+			case raft.PeerObservation:
+				e.Type = EventPeerChange
+				e.PeerID = string(obs.Peer.ID)
+				e.PeerAddress = string(obs.Peer.Address)
+				e.Removed = obs.Removed
+			case raft.FailedHeartbeatObservation:
+				e.Type = EventFailedHeartbeat
+				e.PeerID = string(obs.PeerID)
+				lastContact := obs.LastContact
+				e.LastContact = &lastContact
+			}
+			n.publishEvent(e)
+		}
+	}()
+}
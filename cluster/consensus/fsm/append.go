@@ -0,0 +1,135 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/metrics"
+)
+
+// ErrNotAppendable is returned when appending to a value which isn't a list or a blob (string).
+var ErrNotAppendable = errcode.New(errcode.NotAppendable, "value is not a list or a string, it can't be appended to")
+
+// appendValue is a DatabaseFSM's method which appends a value to the one stored in a key.
+//
+// If the stored value is a JSON array, the value is added as a new element.
+//
+// If both the stored value and the value are strings, they are concatenated as a blob.
+//
+// If the key doesn't exist, a new list is created with the value as its only element.
+func (dbFSM DatabaseFSM) appendValue(k string, value any) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	stored, errGet := getFullValue(txn, k)
+	if errGet != nil && !errors.Is(errGet, engine.ErrKeyNotFound) {
+		return errGet
+	}
+	// The values of other content types are stored base64 encoded, appending to them would corrupt them.
+	// The values split in chunks are too large to be rewritten on every append.
+	if !IsJSON(getContentType(txn, k)) || isChunked(txn, k) {
+		return ErrNotAppendable
+	}
+
+	newValue, errAppend := appendJSON(stored, value)
+	if errAppend != nil {
+		return errAppend
+	}
+	newValue, errAppend = runWriteHook(txn, k, "", newValue)
+	if errAppend != nil {
+		return errAppend
+	}
+	errSchema := validateSchema(txn, k, "", newValue)
+	if errSchema != nil {
+		return errSchema
+	}
+
+	errQuota := updateTenantUsage(txn, k, stored, newValue)
+	if errQuota != nil {
+		return errQuota
+	}
+
+	errIndexes := updateIndexes(txn, k, stored, newValue)
+	if errIndexes != nil {
+		return errorskit.Wrap(errIndexes, "couldn't update indexes on append")
+	}
+
+	errSet := setStored(txn, k, newValue)
+	if errSet != nil {
+		return errSet
+	}
+
+	errCommit := dbFSM.commit(txn, nil)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return nil
+}
+
+// appendJSON appends value to the stored JSON document, following the rules described in appendValue.
+func appendJSON(stored []byte, value any) ([]byte, error) {
+	if len(stored) <= 0 {
+		return json.Marshal([]any{value})
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(stored, &list) == nil {
+		element, errMarshal := json.Marshal(value)
+		if errMarshal != nil {
+			return nil, errorskit.Wrap(errMarshal, "couldn't marshal value on append")
+		}
+		return json.Marshal(append(list, element))
+	}
+
+	var blob string
+	valueStr, isStr := value.(string)
+	if json.Unmarshal(stored, &blob) == nil && isStr {
+		return json.Marshal(blob + valueStr)
+	}
+
+	return nil, ErrNotAppendable
+}
+
+// GetList is a DatabaseFSM's method which gets a page of a list stored in a key from the LOCAL NODE.
+//
+// It returns the elements between offset and offset+limit, and the total number of elements in the list.
+//
+// A limit equal or lower than 0 returns all the elements after offset.
+func (dbFSM DatabaseFSM) GetList(k string, offset int, limit int) ([]json.RawMessage, int, error) {
+	metrics.RecordRead(k)
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	if !isVisible(txn, k) {
+		return nil, 0, engine.ErrKeyNotFound
+	}
+
+	stored, errGet := getFullValue(txn, k)
+	if errGet != nil {
+		return nil, 0, errGet
+	}
+
+	var list []json.RawMessage
+	errUnmarshal := json.Unmarshal(stored, &list)
+	if errUnmarshal != nil {
+		return nil, 0, ErrNotAppendable
+	}
+
+	total := len(list)
+	if offset < 0 || offset >= total {
+		return []json.RawMessage{}, total, nil
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+
+	return list[offset:end], total, nil
+}
+
+// getTxnValue returns a copy of the value stored for a key, inside the given transaction.
+func getTxnValue(txn engine.Txn, k string) ([]byte, error) {
+	return txn.Get([]byte(k))
+}
@@ -1,69 +1,204 @@
 package fsm
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/narvikd/errorskit"
+	"io"
+	"nubedb/cluster/consensus/engine"
 )
 
+// rawBackupField holds, base64 encoded, the internal values which aren't JSON, like the content types or the blobs,
+// so they can be included in the JSON of a backup.
+const rawBackupField = "$raw"
+
+// CopyTo writes all the key-values of the storage engine to w as they're stored, internal ones included,
+// in the engine's snapshot format, which any engine can restore with RestoreCopy.
+func (dbFSM DatabaseFSM) CopyTo(w io.Writer) error {
+	return dbFSM.db.Snapshot(w)
+}
+
+// RestoreCopy replaces all the key-values of the storage engine with a copy written by CopyTo,
+// verifying their checksums.
+func (dbFSM DatabaseFSM) RestoreCopy(r io.Reader) error {
+	return dbFSM.db.Restore(r)
+}
+
 func (dbFSM DatabaseFSM) BackupDB() ([]byte, error) {
-	m := make(map[string]any)
+	var buf bytes.Buffer
+	errWrite := dbFSM.WriteBackup(&buf)
+	if errWrite != nil {
+		return nil, errWrite
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteBackup is a DatabaseFSM's method which writes the backup returned by BackupDB to w, key by key,
+// so a large database isn't held in memory.
+func (dbFSM DatabaseFSM) WriteBackup(w io.Writer) error {
+	bw := bufio.NewWriter(w)
 	txn := dbFSM.db.NewTransaction(false)
 	defer txn.Discard()
 
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer it.Close()
-	for it.Rewind(); it.Valid(); it.Next() {
-		item := it.Item()
-
-		// Get the key and value
-		key := item.KeyCopy(nil)
-		var value []byte
-		errVal := item.Value(func(val []byte) error {
-			value = append([]byte{}, val...)
-			return nil
-		})
-		if errVal != nil {
-			return nil, errVal
+	sep := "{"
+	errIterate := txn.Iterate(engine.IterOptions{}, func(key []byte, value []byte) error {
+		var entry any = json.RawMessage(value)
+		if IsInternalKey(key) && !json.Valid(value) {
+			entry = map[string]string{rawBackupField: base64.StdEncoding.EncodeToString(value)}
+		}
+		k, errKey := json.Marshal(string(key))
+		if errKey != nil {
+			return errKey
 		}
+		v, errValue := json.Marshal(entry)
+		if errValue != nil {
+			return errorskit.Wrap(errValue, "couldn't marshal backup")
+		}
+		_, _ = bw.WriteString(sep)
+		_, _ = bw.Write(k)
+		_ = bw.WriteByte(':')
+		_, errWrite := bw.Write(v)
+		sep = ","
+		return errWrite
+	})
+	if errIterate != nil {
+		return errIterate
+	}
+	if sep == "{" {
+		_, _ = bw.WriteString(sep)
+	}
+	_ = bw.WriteByte('}')
+	return bw.Flush()
+}
 
-		// json.RawMessage prevents "any" types to be converted to string
-		m[string(key)] = json.RawMessage(value)
+// RestoreBackup is a DatabaseFSM's method which restores a backup written by WriteBackup, reading it key by key,
+// and storing it in batches, so a large backup isn't held in memory.
+//
+// It's restored locally, without going through the consensus.
+func (dbFSM DatabaseFSM) RestoreBackup(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	errOpen := expectDelim(dec, '{')
+	if errOpen != nil {
+		return errOpen
 	}
 
-	b, errJson := json.Marshal(m)
-	if errJson != nil {
-		return nil, errorskit.Wrap(errJson, "couldn't marshal backup")
+	batch := make(map[string]any)
+	for dec.More() {
+		tok, errToken := dec.Token()
+		if errToken != nil {
+			return errorskit.Wrap(errToken, "couldn't read backup")
+		}
+		k, _ := tok.(string)
+		var v any
+		errDecode := dec.Decode(&v)
+		if errDecode != nil {
+			return errorskit.Wrap(errDecode, fmt.Sprintf("couldn't read the value of key '%s' of the backup", k))
+		}
+		batch[k] = v
+		if len(batch) >= batchKeys {
+			errBatch := dbFSM.restoreBatch(batch)
+			if errBatch != nil {
+				return errBatch
+			}
+			batch = make(map[string]any)
+		}
+	}
+	errClose := expectDelim(dec, '}')
+	if errClose != nil {
+		return errClose
+	}
+	errBatch := dbFSM.restoreBatch(batch)
+	if errBatch != nil {
+		return errBatch
+	}
+
+	return dbFSM.reindex()
+}
+
+// restoreBatch stores a batch of the keys of a backup in a transaction.
+func (dbFSM DatabaseFSM) restoreBatch(batch map[string]any) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errRestore := restoreKeys(txn, batch)
+	if errRestore != nil {
+		return errRestore
+	}
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
 	}
+	return nil
+}
 
-	return b, nil
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, errToken := dec.Token()
+	if errToken != nil {
+		return errorskit.Wrap(errToken, "couldn't read backup")
+	}
+	if tok != delim {
+		return fmt.Errorf("backup is malformed, expected '%v' but got '%v'", delim, tok)
+	}
+	return nil
 }
 
 func (dbFSM DatabaseFSM) RestoreDB(contents any) error {
-	m := contents.(map[string]any)
 	txn := dbFSM.db.NewTransaction(true)
 	defer txn.Discard()
 
+	errRestore := restoreKeys(txn, contents)
+	if errRestore != nil {
+		return errRestore
+	}
+
+	errCommit := dbFSM.commit(txn, nil)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return dbFSM.reindex()
+}
+
+// restoreKeys sets the keys of a backup inside a transaction, the indexes must be rebuilt once it's committed.
+func restoreKeys(txn engine.Txn, contents any) error {
+	m := contents.(map[string]any)
 	for k, v := range m {
-		dbValue, errMarshalValue := json.Marshal(v)
-		if errMarshalValue != nil {
-			errMsg := fmt.Sprintf("couldn't restore key '%s'. Err: %v", k, errMarshalValue)
-			return errors.New(errMsg)
+		dbValue, errRaw := rawBackupValue(k, v)
+		if errRaw != nil {
+			return errorskit.Wrap(errRaw, fmt.Sprintf("couldn't restore key '%s'", k))
+		}
+		if dbValue == nil {
+			var errMarshalValue error
+			dbValue, errMarshalValue = json.Marshal(v)
+			if errMarshalValue != nil {
+				errMsg := fmt.Sprintf("couldn't restore key '%s'. Err: %v", k, errMarshalValue)
+				return errors.New(errMsg)
+			}
 		}
 
 		errSet := txn.Set([]byte(k), dbValue)
 		if errSet != nil {
 			return errorskit.Wrap(errSet, "couldn't set on restore")
 		}
-
 	}
+	return nil
+}
 
-	errCommit := txn.Commit()
-	if errCommit != nil {
-		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+// rawBackupValue returns the value of an internal key which was backed up base64 encoded, nil if it wasn't.
+func rawBackupValue(k string, v any) ([]byte, error) {
+	if !IsInternalKey([]byte(k)) {
+		return nil, nil
 	}
-
-	return nil
+	wrapper, isMap := v.(map[string]any)
+	if !isMap || len(wrapper) != 1 {
+		return nil, nil
+	}
+	encoded, isStr := wrapper[rawBackupField].(string)
+	if !isStr {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(encoded)
 }
@@ -0,0 +1,86 @@
+package fsm
+
+import (
+	"nubedb/cluster/consensus/engine"
+)
+
+const (
+	// batchKeys is the maximum number of keys read by each transaction of the operations over many keys,
+	// like rebuilding the indexes, so they don't exceed the engines' transaction limits.
+	batchKeys = 1000
+	// batchBytes is the size of the key-values from which a batch is processed without waiting for more keys,
+	// so the batches of large values aren't held in memory at once.
+	batchBytes = 4 << 20
+)
+
+// inBatches calls fn with the key-values matching opts in batches, each one inside its own write transaction,
+// which is committed after fn returns, instead of holding all of them in a single transaction.
+//
+// fn can write any key, the next batch starts after the last key of the previous one.
+func (dbFSM DatabaseFSM) inBatches(opts engine.IterOptions, fn func(txn engine.Txn, keys [][]byte, values [][]byte) error) error {
+	for {
+		var (
+			keys, values [][]byte
+			size         int
+			full         bool
+		)
+		txn := dbFSM.db.NewTransaction(true)
+		err := txn.Iterate(opts, func(key []byte, value []byte) error {
+			keys = append(keys, key)
+			values = append(values, value)
+			size += len(key) + len(value)
+			if len(keys) >= batchKeys || size >= batchBytes {
+				full = true
+				return engine.ErrStop
+			}
+			return nil
+		})
+		if err == nil && len(keys) > 0 {
+			err = fn(txn, keys, values)
+		}
+		if err == nil {
+			err = txn.Commit()
+		}
+		txn.Discard()
+		if err != nil || !full {
+			return err
+		}
+		// The smallest key after the last one of the batch.
+		opts.Start = append(keys[len(keys)-1], 0)
+	}
+}
+
+// deletePrefix deletes all the keys starting with prefix, in batches.
+func (dbFSM DatabaseFSM) deletePrefix(prefix string) error {
+	opts := engine.IterOptions{Prefix: []byte(prefix), KeysOnly: true}
+	return dbFSM.inBatches(opts, func(txn engine.Txn, keys [][]byte, _ [][]byte) error {
+		for _, k := range keys {
+			errDelete := txn.Delete(k)
+			if errDelete != nil {
+				return errDelete
+			}
+		}
+		return nil
+	})
+}
+
+// userValues returns the values of the user keys of a batch, with the ones stored in chunks or as blobs joined.
+func userValues(txn engine.Txn, keys [][]byte, values [][]byte, indirect map[string]bool) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	for i, key := range keys {
+		if IsInternalKey(key) {
+			continue
+		}
+		k := string(key)
+		if !indirect[k] {
+			result[k] = values[i]
+			continue
+		}
+		full, errFull := getFullValue(txn, k)
+		if errFull != nil {
+			return nil, errFull
+		}
+		result[k] = full
+	}
+	return result, nil
+}
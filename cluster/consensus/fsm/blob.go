@@ -0,0 +1,211 @@
+package fsm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"strconv"
+)
+
+const (
+	// blobDefPrefix is the prefix under which the buckets in blob mode are stored.
+	blobDefPrefix = InternalPrefix + "blob/def/"
+	// blobPrefix is the prefix under which the blobs are stored, by the SHA-256 of their value.
+	blobPrefix = InternalPrefix + "blob/data/"
+	// blobRefsPrefix is the prefix under which the number of keys pointing to each blob is stored.
+	blobRefsPrefix = InternalPrefix + "blob/refs/"
+	// blobRefPrefix is the prefix under which the blob a key points to is stored.
+	blobRefPrefix = InternalPrefix + "blob/ref/"
+)
+
+// ErrBlobsNotEnabled is returned when disabling the blob mode of a bucket which doesn't have it enabled.
+var ErrBlobsNotEnabled = errcode.New(errcode.NotEnabled, "blob mode is not enabled for the bucket")
+
+// BlobRef is stored as the value of a key of a bucket in blob mode, pointing to the blob holding its value.
+type BlobRef struct {
+	Hash string `json:"blob"`
+	// Size is the size of the value encoded as JSON.
+	Size int64 `json:"size"`
+}
+
+// enableBlobs is a DatabaseFSM's method which enables the blob mode for a bucket, moving its existing values to blobs.
+func (dbFSM DatabaseFSM) enableBlobs(bucket string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	errSet := txn.Set([]byte(blobDefPrefix+bucket), []byte("true"))
+	if errSet != nil {
+		return errSet
+	}
+
+	values, errValues := getBucketValues(txn, bucket)
+	if errValues != nil {
+		return errValues
+	}
+	for k, v := range values {
+		// The values split in chunks are already stored apart from their key.
+		if isChunked(txn, k) {
+			continue
+		}
+		errStore := setStored(txn, k, v)
+		if errStore != nil {
+			return errStore
+		}
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// disableBlobs is a DatabaseFSM's method which disables the blob mode for a bucket, moving its values back to their keys.
+func (dbFSM DatabaseFSM) disableBlobs(bucket string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	if !isBlobsEnabled(txn, bucket) {
+		return ErrBlobsNotEnabled
+	}
+	errDelete := txn.Delete([]byte(blobDefPrefix + bucket))
+	if errDelete != nil {
+		return errDelete
+	}
+
+	values, errValues := getBucketValues(txn, bucket)
+	if errValues != nil {
+		return errValues
+	}
+	for k, v := range values {
+		if isChunked(txn, k) {
+			continue
+		}
+		errStore := setStored(txn, k, v)
+		if errStore != nil {
+			return errStore
+		}
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// IsBlobsEnabled is a DatabaseFSM's method which returns whether a bucket is in blob mode in the LOCAL NODE.
+func (dbFSM DatabaseFSM) IsBlobsEnabled(bucket string) bool {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return isBlobsEnabled(txn, bucket)
+}
+
+func isBlobsEnabled(txn engine.Txn, bucket string) bool {
+	_, errGet := txn.Get([]byte(blobDefPrefix + bucket))
+	return errGet == nil
+}
+
+// setStored sets the value of a key, encoded as JSON.
+//
+// The values of the buckets in blob mode are stored in the blob of their SHA-256, shared by all the keys with the same value,
+// and the key is set to a BlobRef. The blob the key pointed to before is released.
+func setStored(txn engine.Txn, k string, value []byte) error {
+	if !isBlobsEnabled(txn, BucketOf(k)) {
+		errRelease := releaseBlob(txn, k)
+		if errRelease != nil {
+			return errRelease
+		}
+		return txn.Set([]byte(k), value)
+	}
+
+	sum := sha256.Sum256(value)
+	hash := hex.EncodeToString(sum[:])
+	current, errCurrent := getTxnValue(txn, blobRefPrefix+k)
+	if errCurrent == nil && string(current) == hash {
+		return nil
+	}
+	errRelease := releaseBlob(txn, k)
+	if errRelease != nil {
+		return errRelease
+	}
+
+	refs := blobRefs(txn, hash)
+	if refs == 0 {
+		errBlob := txn.Set([]byte(blobPrefix+hash), value)
+		if errBlob != nil {
+			return errBlob
+		}
+	}
+	errRefs := txn.Set([]byte(blobRefsPrefix+hash), []byte(strconv.Itoa(refs+1)))
+	if errRefs != nil {
+		return errRefs
+	}
+	errMark := txn.Set([]byte(blobRefPrefix+k), []byte(hash))
+	if errMark != nil {
+		return errMark
+	}
+
+	ref, errMarshal := json.Marshal(BlobRef{Hash: hash, Size: int64(len(value))})
+	if errMarshal != nil {
+		return errMarshal
+	}
+	return txn.Set([]byte(k), ref)
+}
+
+// releaseBlob removes the pointer of a key to its blob, removing the blob if no other key points to it.
+func releaseBlob(txn engine.Txn, k string) error {
+	hash, errGet := getTxnValue(txn, blobRefPrefix+k)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return nil
+	}
+	if errGet != nil {
+		return errGet
+	}
+	errMark := txn.Delete([]byte(blobRefPrefix + k))
+	if errMark != nil {
+		return errMark
+	}
+
+	refs := blobRefs(txn, string(hash)) - 1
+	if refs > 0 {
+		return txn.Set([]byte(blobRefsPrefix+string(hash)), []byte(strconv.Itoa(refs)))
+	}
+	errRefs := txn.Delete([]byte(blobRefsPrefix + string(hash)))
+	if errRefs != nil {
+		return errRefs
+	}
+	return txn.Delete([]byte(blobPrefix + string(hash)))
+}
+
+// isBlob returns whether the value of a key is stored in a blob.
+func isBlob(txn engine.Txn, k string) bool {
+	_, errGet := getTxnValue(txn, blobRefPrefix+k)
+	return errGet == nil
+}
+
+// getBlob returns the value of a key stored in a blob.
+func getBlob(txn engine.Txn, hash []byte) ([]byte, error) {
+	value, errGet := getTxnValue(txn, blobPrefix+string(hash))
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return nil, fmt.Errorf("blob %s wasn't found", hash)
+	}
+	return value, errGet
+}
+
+func blobRefs(txn engine.Txn, hash string) int {
+	b, errGet := getTxnValue(txn, blobRefsPrefix+hash)
+	if errGet != nil {
+		return 0
+	}
+	refs, errAtoi := strconv.Atoi(string(b))
+	if errAtoi != nil {
+		return 0
+	}
+	return refs
+}
@@ -0,0 +1,17 @@
+package fsm
+
+import "strings"
+
+// bucketSep separates the bucket from the rest of the key.
+const bucketSep = "/"
+
+// BucketOf returns the bucket of a key, which is the part of the key before the first "/".
+//
+// Keys without a "/" belong to the default bucket, which is an empty string.
+func BucketOf(k string) string {
+	bucket, _, found := strings.Cut(k, bucketSep)
+	if !found {
+		return ""
+	}
+	return bucket
+}
@@ -0,0 +1,61 @@
+package fsm
+
+import (
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/errcode"
+)
+
+// bucketKeyPrefix is the prefix under which the wrapped (encrypted) data keys of the buckets are stored.
+const bucketKeyPrefix = InternalPrefix + "bucketkey/"
+
+// ErrBucketEncrypted is returned when encrypting a bucket which is already encrypted.
+var ErrBucketEncrypted = errcode.New(errcode.Encrypted, "bucket is already encrypted")
+
+// setBucketKey is a DatabaseFSM's method which stores the wrapped data key of a bucket.
+//
+// The key is wrapped by the node that received the request, so it never enters the consensus in plaintext.
+//
+// It's refused if the bucket already has a key, replacing it would make the values encrypted with it unreadable.
+// It's checked when the key is applied, so two requests encrypting the bucket at once can't both succeed.
+func (dbFSM DatabaseFSM) setBucketKey(bucket string, value any) error {
+	wrapped, ok := value.(string)
+	if !ok || wrapped == "" {
+		return errors.New("bucket key must be a non-empty string")
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	if isEncryptedBucket(txn, bucket) {
+		return ErrBucketEncrypted
+	}
+	hook, errHook := getHook(txn, bucket)
+	if errHook == nil && hook.OnWrite != "" {
+		return ErrHookEncrypted
+	}
+
+	errSet := txn.Set([]byte(bucketKeyPrefix+bucket), []byte(wrapped))
+	if errSet != nil {
+		return errSet
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return nil
+}
+
+// GetBucketKey is a DatabaseFSM's method which returns the wrapped data key of a bucket from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetBucketKey(bucket string) (string, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	value, errGet := getTxnValue(txn, bucketKeyPrefix+bucket)
+	if errGet != nil {
+		return "", errGet
+	}
+	return string(value), nil
+}
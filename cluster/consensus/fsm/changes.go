@@ -0,0 +1,288 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"strconv"
+	"time"
+)
+
+const (
+	// changesPrefix is the prefix under which the committed changes are recorded, ordered by their raft index.
+	changesPrefix = InternalPrefix + "changes/"
+	// checkpointPrefix is the prefix under which the consumers of the changes store their checkpoint.
+	checkpointPrefix = InternalPrefix + "checkpoint/"
+)
+
+// ChangeEvent represents a committed change on the database.
+type ChangeEvent struct {
+	Index     uint64          `json:"index"`
+	Time      time.Time       `json:"time"`
+	Operation string          `json:"operation"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	// NotBefore is the time from which the key set is visible to the reads, if it was scheduled.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	// ContentType is the content type declared for the value set, if it was declared.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// UnmarshalJSON decodes a change, including the ones recorded by older versions, whose fields were in snake case.
+func (e *ChangeEvent) UnmarshalJSON(b []byte) error {
+	type changeEvent ChangeEvent
+	var decoded struct {
+		changeEvent
+		LegacyNotBefore   *time.Time `json:"not_before,omitempty"`
+		LegacyContentType string     `json:"content_type,omitempty"`
+	}
+	errUnmarshal := json.Unmarshal(b, &decoded)
+	if errUnmarshal != nil {
+		return errUnmarshal
+	}
+	*e = ChangeEvent(decoded.changeEvent)
+	if e.NotBefore == nil {
+		e.NotBefore = decoded.LegacyNotBefore
+	}
+	if e.ContentType == "" {
+		e.ContentType = decoded.LegacyContentType
+	}
+	return nil
+}
+
+// recordedOperations are the operations that are recorded as changes, internal operations are excluded.
+var recordedOperations = map[string]bool{
+	"SET":        true,
+	"SETCHUNKED": true,
+	"APPEND":     true,
+	"DELETE":     true,
+	"SOFTDELETE": true,
+	"UNDELETE":   true,
+	"RESTOREDB":  true,
+	"DO":         true,
+}
+
+// recordedAs are the operations recorded as another one, since for the consumers they have the same effect.
+var recordedAs = map[string]string{
+	"SETCHUNKED": "SET",
+	"SOFTDELETE": "DELETE",
+	"UNDELETE":   "SET",
+}
+
+// appliedChange is the log being applied by an operation which is recorded as a change.
+type appliedChange struct {
+	log     *raft.Log
+	payload *Payload
+}
+
+// commit is a DatabaseFSM's method which commits the transaction of an operation,
+// recording in it the change being applied, if any, so a crash can't apply the operation without its change.
+//
+// result is the result of a DO, nil for the other operations.
+func (dbFSM DatabaseFSM) commit(txn engine.Txn, result *ScriptResult) error {
+	if dbFSM.change != nil {
+		errRecord := recordChange(txn, dbFSM.change.log, dbFSM.change.payload, result)
+		if errRecord != nil {
+			return errorskit.Wrap(errRecord, "couldn't record the change")
+		}
+	}
+	return txn.Commit()
+}
+
+// recordChange records an applied payload in the changes log, inside the transaction which applies it.
+//
+// Changes are only recorded while there's at least one registered consumer,
+// since the consumers are replicated, every node records the same changes.
+//
+// The value recorded is the one stored after the operation, so consumers don't need to know how to apply it.
+// The writes of a script are recorded as a single DO change, with its ScriptChanges as the value.
+func recordChange(txn engine.Txn, log *raft.Log, p *Payload, result *ScriptResult) error {
+	if len(getPrefixKeys(txn, []byte(checkpointPrefix))) <= 0 {
+		return nil
+	}
+
+	event := ChangeEvent{
+		Index:     log.Index,
+		Time:      log.AppendedAt,
+		Operation: p.Operation,
+		Key:       p.Key,
+		NotBefore: p.NotBefore,
+	}
+	if op, ok := recordedAs[p.Operation]; ok {
+		event.Operation = op
+	}
+	switch event.Operation {
+	case "SET", "APPEND":
+		value, errGet := getFullValue(txn, p.Key)
+		if errGet != nil {
+			return errGet
+		}
+		event.Value = value
+		event.ContentType = getContentType(txn, p.Key)
+	case "DO":
+		value, errValue := scriptChanges(txn, result)
+		if errValue != nil {
+			return errValue
+		}
+		event.Value = value
+	case "RESTOREDB":
+		value, errMarshal := json.Marshal(p.Value)
+		if errMarshal != nil {
+			return errMarshal
+		}
+		event.Value = value
+	}
+
+	b, errMarshal := json.Marshal(event)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal change")
+	}
+	return txn.Set(changeKey(log.Index), b)
+}
+
+// setCheckpoint is a DatabaseFSM's method which stores the last change index processed by a consumer.
+//
+// Registering the first checkpoint starts the recording of changes,
+// and the changes already processed by every consumer are removed.
+func (dbFSM DatabaseFSM) setCheckpoint(consumer string, value any) error {
+	index, errIndex := toIndex(value)
+	if errIndex != nil {
+		return errIndex
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	errSet := txn.Set([]byte(checkpointPrefix+consumer), []byte(strconv.FormatUint(index, 10)))
+	if errSet != nil {
+		return errSet
+	}
+
+	checkpoints, errCheckpoints := getCheckpoints(txn)
+	if errCheckpoints != nil {
+		return errCheckpoints
+	}
+	lowest := index
+	for _, c := range checkpoints {
+		if c < lowest {
+			lowest = c
+		}
+	}
+
+	for _, k := range getPrefixKeys(txn, []byte(changesPrefix)) {
+		if string(k) > string(changeKey(lowest)) {
+			break
+		}
+		errDelete := txn.Delete(k)
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return nil
+}
+
+// GetCheckpoint is a DatabaseFSM's method which returns the checkpoint of a consumer from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetCheckpoint(consumer string) (uint64, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	value, errGet := getTxnValue(txn, checkpointPrefix+consumer)
+	if errGet != nil {
+		return 0, errGet
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+// GetChanges is a DatabaseFSM's method which returns up to limit changes recorded after an index from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetChanges(afterIndex uint64, limit int) ([]ChangeEvent, error) {
+	var changes []ChangeEvent
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	if limit <= 0 {
+		return changes, nil
+	}
+
+	opts := engine.IterOptions{Prefix: []byte(changesPrefix), Start: changeKey(afterIndex + 1)}
+	errIterate := txn.Iterate(opts, func(_ []byte, value []byte) error {
+		var event ChangeEvent
+		errUnmarshal := json.Unmarshal(value, &event)
+		if errUnmarshal != nil {
+			return errorskit.Wrap(errUnmarshal, "couldn't read change")
+		}
+		changes = append(changes, event)
+		if len(changes) >= limit {
+			return engine.ErrStop
+		}
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errIterate
+	}
+
+	return changes, nil
+}
+
+func getCheckpoints(txn engine.Txn) ([]uint64, error) {
+	var checkpoints []uint64
+	errIterate := txn.Iterate(engine.IterOptions{Prefix: []byte(checkpointPrefix)}, func(_ []byte, value []byte) error {
+		c, errParse := strconv.ParseUint(string(value), 10, 64)
+		if errParse != nil {
+			return errorskit.Wrap(errParse, "couldn't parse checkpoint")
+		}
+		checkpoints = append(checkpoints, c)
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errIterate
+	}
+
+	return checkpoints, nil
+}
+
+// scriptChanges returns the writes of a script encoded as the value of its change.
+func scriptChanges(txn engine.Txn, result *ScriptResult) (json.RawMessage, error) {
+	changes := ScriptChanges{Set: make(map[string]json.RawMessage)}
+	if result != nil {
+		for _, k := range result.Set {
+			value, errGet := getFullValue(txn, k)
+			if errGet != nil {
+				return nil, errGet
+			}
+			changes.Set[k] = value
+		}
+		changes.Deleted = result.Deleted
+	}
+	return json.Marshal(changes)
+}
+
+// changeKey returns the key of a change, the index is zero padded so the changes are sorted by it.
+func changeKey(index uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", changesPrefix, index))
+}
+
+// toIndex converts a payload's value into a raft index.
+func toIndex(value any) (uint64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v < 0 {
+			return 0, errors.New("index can't be negative")
+		}
+		return uint64(v), nil
+	case json.Number:
+		return strconv.ParseUint(v.String(), 10, 64)
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("index must be a number, got: %T", value)
+	}
+}
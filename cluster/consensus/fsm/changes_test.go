@@ -0,0 +1,57 @@
+package fsm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestChangeEventUnmarshal(t *testing.T) {
+	notBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "camel case",
+			data: `{"index":1,"operation":"SET","key":"k","notBefore":"2030-01-02T03:04:05Z","contentType":"text/plain"}`,
+		},
+		{
+			name: "snake case of older versions",
+			data: `{"index":1,"operation":"SET","key":"k","not_before":"2030-01-02T03:04:05Z","content_type":"text/plain"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ChangeEvent
+			if errUnmarshal := json.Unmarshal([]byte(tt.data), &got); errUnmarshal != nil {
+				t.Fatal(errUnmarshal)
+			}
+			if got.Index != 1 || got.Key != "k" || got.Operation != "SET" {
+				t.Errorf("got %+v", got)
+			}
+			if got.NotBefore == nil || !got.NotBefore.Equal(notBefore) {
+				t.Errorf("got not before %v, want %v", got.NotBefore, notBefore)
+			}
+			if got.ContentType != "text/plain" {
+				t.Errorf("got content type %q, want text/plain", got.ContentType)
+			}
+		})
+	}
+}
+
+func TestChangeEventRoundTrip(t *testing.T) {
+	notBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := ChangeEvent{Index: 1, Operation: "SET", Key: "k", NotBefore: &notBefore, ContentType: "text/plain"}
+	data, errMarshal := json.Marshal(want)
+	if errMarshal != nil {
+		t.Fatal(errMarshal)
+	}
+	var got ChangeEvent
+	if errUnmarshal := json.Unmarshal(data, &got); errUnmarshal != nil {
+		t.Fatal(errUnmarshal)
+	}
+	if got.ContentType != want.ContentType || got.NotBefore == nil || !got.NotBefore.Equal(notBefore) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,345 @@
+package fsm
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// chunkPrefix is the prefix under which the chunks of the large values are stored.
+	//
+	// Each chunk has the form: chunkPrefix + key + chunkSep + upload ID + chunkSep + sequence
+	chunkPrefix = InternalPrefix + "chunk/"
+	// chunkedPrefix is the prefix under which the manifest of a key whose value is split in chunks is stored.
+	chunkedPrefix = InternalPrefix + "chunked/"
+	chunkSep      = "\x00"
+)
+
+// ErrChunksMissing is returned when the manifest of a value is set without all its chunks,
+// like when another write to the key removed them, the write must be retried.
+var ErrChunksMissing = errcode.New(errcode.Aborted, "chunks of the value are missing, the write must be retried")
+
+// ChunkManifest is stored as the value of a key whose value is split in chunks.
+type ChunkManifest struct {
+	// ID identifies the upload of the chunks, so the chunks of concurrent writes to the key don't mix.
+	ID     string `json:"id" codec:"id"`
+	Chunks int    `json:"chunks" codec:"chunks"`
+	// Size is the size of the value encoded as JSON.
+	Size int64 `json:"size" codec:"size"`
+}
+
+// Chunk is a part of a large value, which is written before its manifest.
+type Chunk struct {
+	ID  string `json:"id" codec:"id"`
+	Seq int    `json:"seq" codec:"seq"`
+	// Data is the part of the value encoded as JSON, base64 encoded.
+	Data string `json:"data" codec:"data"`
+}
+
+// SplitValue splits the value of a SET bigger than chunkSize into the SETCHUNK payloads of its chunks,
+// and the SETCHUNKED payload of its manifest, which must be applied after all of them.
+//
+// It returns nil payloads if the value isn't bigger than chunkSize.
+func SplitValue(p *Payload, chunkSize int) ([]*Payload, *Payload, error) {
+	value, errMarshal := json.Marshal(p.Value)
+	if errMarshal != nil {
+		return nil, nil, errorskit.Wrap(errMarshal, "couldn't marshal value to split it")
+	}
+	if chunkSize <= 0 || len(value) <= chunkSize {
+		return nil, nil, nil
+	}
+
+	id, errID := NewChunkID()
+	if errID != nil {
+		return nil, nil, errID
+	}
+
+	var chunks []*Payload
+	for start := 0; start < len(value); start += chunkSize {
+		end := start + chunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		chunks = append(chunks, ChunkPayload(p.Key, id, len(chunks), value[start:end]))
+	}
+	manifest := ManifestPayload(p.Key, ChunkManifest{ID: id, Chunks: len(chunks), Size: int64(len(value))}, p.NotBefore, p.ContentType)
+	return chunks, manifest, nil
+}
+
+// NewChunkID returns a random ID for the upload of the chunks of a value.
+func NewChunkID() (string, error) {
+	b := make([]byte, 16)
+	_, errRand := rand.Read(b)
+	if errRand != nil {
+		return "", errRand
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ChunkPayload returns the SETCHUNK payload of a part of a value encoded as JSON.
+func ChunkPayload(k string, id string, seq int, data []byte) *Payload {
+	return &Payload{
+		Key:       k,
+		Operation: "SETCHUNK",
+		Value:     Chunk{ID: id, Seq: seq, Data: base64.StdEncoding.EncodeToString(data)},
+	}
+}
+
+// ManifestPayload returns the SETCHUNKED payload of a manifest, which must be applied after all its chunks.
+func ManifestPayload(k string, m ChunkManifest, notBefore *time.Time, contentType string) *Payload {
+	return &Payload{
+		Key:         k,
+		Operation:   "SETCHUNKED",
+		Value:       m,
+		NotBefore:   notBefore,
+		ContentType: contentType,
+	}
+}
+
+// setChunk is a DatabaseFSM's method which stores a chunk of a large value, it isn't read until its manifest is set.
+func (dbFSM DatabaseFSM) setChunk(k string, value any) error {
+	var chunk Chunk
+	errDecode := decodeJSON(value, &chunk)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode chunk")
+	}
+	if chunk.ID == "" || chunk.Seq < 0 {
+		return errors.New("chunk must have an ID and a sequence")
+	}
+	_, errData := base64.StdEncoding.DecodeString(chunk.Data)
+	if errData != nil {
+		return errorskit.Wrap(errData, "couldn't decode chunk data")
+	}
+
+	// The data is stored as a JSON string, so the chunks are valid JSON like the rest of the values.
+	data, errMarshal := json.Marshal(chunk.Data)
+	if errMarshal != nil {
+		return errMarshal
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set(chunkKey(k, chunk.ID, chunk.Seq), data)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// setChunked is a DatabaseFSM's method which sets a key to a value split in chunks, once all of them are stored.
+//
+// The manifest is stored as the key's value, but the indexes and the tenants' usage are updated with the joined value.
+func (dbFSM DatabaseFSM) setChunked(k string, value any, notBefore *time.Time, contentType string) error {
+	var m ChunkManifest
+	errDecode := decodeJSON(value, &m)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode chunk manifest")
+	}
+	manifest, errMarshal := json.Marshal(m)
+	if errMarshal != nil {
+		return errMarshal
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	newValue, errJoin := joinChunks(txn, k, manifest)
+	if errJoin != nil {
+		return errJoin
+	}
+	errValid := validateStored(contentType, newValue)
+	if errValid != nil {
+		return errValid
+	}
+	errHook := checkChunkedHook(txn, k, contentType, newValue)
+	if errHook != nil {
+		return errHook
+	}
+	errSchema := validateSchema(txn, k, contentType, newValue)
+	if errSchema != nil {
+		return errSchema
+	}
+	oldValue, errGet := getFullValue(txn, k)
+	if errGet != nil && !errors.Is(errGet, engine.ErrKeyNotFound) {
+		return errGet
+	}
+	errQuota := updateTenantUsage(txn, k, oldValue, newValue)
+	if errQuota != nil {
+		return errQuota
+	}
+	errIndexes := updateIndexes(txn, k, oldValue, newValue)
+	if errIndexes != nil {
+		return errorskit.Wrap(errIndexes, "couldn't update indexes on set")
+	}
+
+	errBlob := releaseBlob(txn, k)
+	if errBlob != nil {
+		return errBlob
+	}
+	errSet := txn.Set([]byte(k), manifest)
+	if errSet != nil {
+		return errSet
+	}
+	errDrop := dropChunks(txn, k, m.ID)
+	if errDrop != nil {
+		return errDrop
+	}
+	errMark := txn.Set([]byte(chunkedPrefix+k), manifest)
+	if errMark != nil {
+		return errMark
+	}
+	errSchedule := setNotBefore(txn, k, notBefore)
+	if errSchedule != nil {
+		return errSchedule
+	}
+	errContentType := setContentType(txn, k, contentType)
+	if errContentType != nil {
+		return errContentType
+	}
+
+	errCommit := dbFSM.commit(txn, nil)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// isChunked returns whether the value of a key is split in chunks.
+func isChunked(txn engine.Txn, k string) bool {
+	_, errGet := getTxnValue(txn, chunkedPrefix+k)
+	return errGet == nil
+}
+
+// dropChunks removes the chunked mark of a key, and the chunks of its uploads but keep's and its tombstone's,
+// so the chunks of the overwritten values and of the uploads which were never completed are removed.
+func dropChunks(txn engine.Txn, k string, keep string) error {
+	errDelete := txn.Delete([]byte(chunkedPrefix + k))
+	if errDelete != nil {
+		return errDelete
+	}
+
+	// The chunks of a soft deleted value are retained with its tombstone.
+	retained := ""
+	tombstone, errTombstone := getTombstone(txn, k)
+	if errTombstone == nil && tombstone.Chunked {
+		var m ChunkManifest
+		if json.Unmarshal(tombstone.Value, &m) == nil {
+			retained = m.ID
+		}
+	}
+
+	prefix := chunkPrefix + k + chunkSep
+	for _, ck := range getPrefixKeys(txn, []byte(prefix)) {
+		id, _, _ := strings.Cut(string(ck[len(prefix):]), chunkSep)
+		if id == keep || id == retained {
+			continue
+		}
+		errDeleteChunk := txn.Delete(ck)
+		if errDeleteChunk != nil {
+			return errDeleteChunk
+		}
+	}
+	return nil
+}
+
+// deleteChunks removes the chunks of the upload of a manifest.
+func deleteChunks(txn engine.Txn, k string, manifest []byte) error {
+	var m ChunkManifest
+	errUnmarshal := json.Unmarshal(manifest, &m)
+	if errUnmarshal != nil {
+		return errorskit.Wrap(errUnmarshal, "couldn't decode chunk manifest")
+	}
+	for _, ck := range getPrefixKeys(txn, []byte(chunkPrefix+k+chunkSep+m.ID+chunkSep)) {
+		errDelete := txn.Delete(ck)
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+	return nil
+}
+
+// getFullValue returns the value of a key, joining its chunks if it was split, or reading its blob if it's stored in one.
+func getFullValue(txn engine.Txn, k string) ([]byte, error) {
+	value, errGet := getTxnValue(txn, k)
+	if errGet != nil {
+		return nil, errGet
+	}
+	hash, errHash := getTxnValue(txn, blobRefPrefix+k)
+	if errHash == nil {
+		return getBlob(txn, hash)
+	}
+	if !errors.Is(errHash, engine.ErrKeyNotFound) {
+		return nil, errHash
+	}
+	manifest, errManifest := getTxnValue(txn, chunkedPrefix+k)
+	if errors.Is(errManifest, engine.ErrKeyNotFound) {
+		return value, nil
+	}
+	if errManifest != nil {
+		return nil, errManifest
+	}
+	return joinChunks(txn, k, manifest)
+}
+
+// indirectKeys returns the keys whose value isn't stored in the key,
+// because it's split in chunks or stored in a blob, so getFullValue must be used to read it.
+func indirectKeys(txn engine.Txn) map[string]bool {
+	keys := make(map[string]bool)
+	for _, prefix := range []string{chunkedPrefix, blobRefPrefix} {
+		for _, mark := range getPrefixKeys(txn, []byte(prefix)) {
+			keys[string(mark[len(prefix):])] = true
+		}
+	}
+	return keys
+}
+
+// joinChunks returns the value of a key split in chunks.
+func joinChunks(txn engine.Txn, k string, manifest []byte) ([]byte, error) {
+	var m ChunkManifest
+	errUnmarshal := json.Unmarshal(manifest, &m)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't decode chunk manifest")
+	}
+	value := make([]byte, 0, m.Size)
+	for seq := 0; seq < m.Chunks; seq++ {
+		data, errData := getChunk(txn, k, m.ID, seq)
+		if errors.Is(errData, engine.ErrKeyNotFound) {
+			return nil, fmt.Errorf("%w: chunk %v of key '%s' wasn't found", ErrChunksMissing, seq, k)
+		}
+		if errData != nil {
+			return nil, errorskit.Wrap(errData, fmt.Sprintf("couldn't read chunk %v of key '%s'", seq, k))
+		}
+		value = append(value, data...)
+	}
+	if int64(len(value)) != m.Size {
+		return nil, fmt.Errorf("the chunks of key '%s' hold %v bytes of %v, they're corrupted", k, len(value), m.Size)
+	}
+	return value, nil
+}
+
+func getChunk(txn engine.Txn, k string, id string, seq int) ([]byte, error) {
+	stored, errGet := getTxnValue(txn, string(chunkKey(k, id, seq)))
+	if errGet != nil {
+		return nil, errGet
+	}
+	var encoded string
+	errUnmarshal := json.Unmarshal(stored, &encoded)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't decode chunk")
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func chunkKey(k string, id string, seq int) []byte {
+	return []byte(chunkPrefix + k + chunkSep + id + chunkSep + strconv.Itoa(seq))
+}
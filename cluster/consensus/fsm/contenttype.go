@@ -0,0 +1,170 @@
+package fsm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-msgpack/codec"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/metrics"
+	"strings"
+	"time"
+)
+
+// contentTypePrefix is the prefix under which the content type declared for a key's value is stored.
+const contentTypePrefix = InternalPrefix + "contenttype/"
+
+// Content types with a known serialization, the values of any other content type are stored as they are received.
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeMsgpack = "application/msgpack"
+)
+
+// ErrInvalidValue is returned when a value can't be decoded with its content type.
+var ErrInvalidValue = errcode.New(errcode.InvalidArgument, "value isn't valid for its content type")
+
+// IsJSON returns whether the values of a content type are stored as JSON, which is the case of the empty one.
+func IsJSON(contentType string) bool {
+	mediaType := mediaTypeOf(contentType)
+	return mediaType == "" || mediaType == ContentTypeJSON
+}
+
+// EncodeValue converts a value received with a content type to the one sent in the payload.
+//
+// JSON values are decoded, the values of the other content types are base64 encoded,
+// so they are still stored and replicated as JSON.
+func EncodeValue(contentType string, body []byte) (any, error) {
+	switch mediaTypeOf(contentType) {
+	case "", ContentTypeJSON:
+		var value any
+		errUnmarshal := json.Unmarshal(body, &value)
+		if errUnmarshal != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidValue, errUnmarshal)
+		}
+		return value, nil
+	case ContentTypeMsgpack, "application/x-msgpack":
+		errValid := validateMsgpack(body)
+		if errValid != nil {
+			return nil, errValid
+		}
+	}
+	return base64.StdEncoding.EncodeToString(body), nil
+}
+
+// validateStored checks that a value stored as JSON can be decoded with its content type,
+// since the values set in chunks are received already encoded.
+func validateStored(contentType string, stored []byte) error {
+	if IsJSON(contentType) {
+		if !json.Valid(stored) {
+			return fmt.Errorf("%w: the value isn't valid JSON", ErrInvalidValue)
+		}
+		return nil
+	}
+	value, errDecode := decodeStored(contentType, stored)
+	if errDecode != nil {
+		return errDecode
+	}
+	switch mediaTypeOf(contentType) {
+	case ContentTypeMsgpack, "application/x-msgpack":
+		return validateMsgpack(value)
+	}
+	return nil
+}
+
+func validateMsgpack(body []byte) error {
+	var value any
+	errDecode := codec.NewDecoderBytes(body, msgpackHandle).Decode(&value)
+	if errDecode != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, errDecode)
+	}
+	return nil
+}
+
+// DecodeValue converts a value read with Get to the bytes of its content type.
+func DecodeValue(contentType string, value any) ([]byte, error) {
+	if IsJSON(contentType) {
+		return json.Marshal(value)
+	}
+	s, isStr := value.(string)
+	if !isStr {
+		return nil, fmt.Errorf("%w: a %s value must be stored as a base64 string", ErrInvalidValue, contentType)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// GetRaw is a DatabaseFSM's method which returns the value of a key in its content type, and the content type,
+// empty if it wasn't declared.
+//
+// Unlike Get, JSON values are returned as they are stored, without unmarshalling them.
+func (dbFSM DatabaseFSM) GetRaw(k string) ([]byte, string, error) {
+	defer metrics.Track(metrics.ComponentBadgerGet, "GET", k, time.Now())
+	metrics.RecordRead(k)
+	if !dbFSM.mayExist(k) {
+		return nil, "", engine.ErrKeyNotFound
+	}
+
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	if !isVisible(txn, k) {
+		return nil, "", engine.ErrKeyNotFound
+	}
+	stored, errGet := getFullValue(txn, k)
+	if errGet != nil {
+		return nil, "", errGet
+	}
+
+	contentType := getContentType(txn, k)
+	if IsJSON(contentType) {
+		return stored, contentType, nil
+	}
+	value, errDecode := decodeStored(contentType, stored)
+	if errDecode != nil {
+		return nil, "", errDecode
+	}
+	return value, contentType, nil
+}
+
+// decodeStored returns the bytes of a value of a content type which isn't JSON, from the base64 string it's stored as.
+func decodeStored(contentType string, stored []byte) ([]byte, error) {
+	var encoded string
+	errUnmarshal := json.Unmarshal(stored, &encoded)
+	if errUnmarshal != nil {
+		return nil, fmt.Errorf("%w: a %s value must be stored as a base64 string", ErrInvalidValue, contentType)
+	}
+	value, errDecode := base64.StdEncoding.DecodeString(encoded)
+	if errDecode != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidValue, errDecode)
+	}
+	return value, nil
+}
+
+// ContentType is a DatabaseFSM's method which returns the content type declared for a key in the LOCAL NODE,
+// empty if it wasn't declared.
+func (dbFSM DatabaseFSM) ContentType(k string) string {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return getContentType(txn, k)
+}
+
+// setContentType stores the content type declared for a key, or removes it if it's empty.
+func setContentType(txn engine.Txn, k string, contentType string) error {
+	if contentType == "" {
+		return txn.Delete([]byte(contentTypePrefix + k))
+	}
+	return txn.Set([]byte(contentTypePrefix+k), []byte(contentType))
+}
+
+func getContentType(txn engine.Txn, k string) string {
+	b, errGet := getTxnValue(txn, contentTypePrefix+k)
+	if errGet != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// mediaTypeOf returns the media type of a content type, without its parameters, like the charset.
+func mediaTypeOf(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
@@ -2,6 +2,7 @@ package fsm
 
 import (
 	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
 )
 
 // delete is a DatabaseFSM's method which deletes a key-value pair from the database.
@@ -9,21 +10,53 @@ func (dbFSM DatabaseFSM) delete(k string) error {
 	txn := dbFSM.db.NewTransaction(true)
 	defer txn.Discard()
 
+	errDelete := deleteKey(txn, k)
+	if errDelete != nil {
+		return errDelete
+	}
+
+	errCommit := dbFSM.commit(txn, nil)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return nil
+}
+
+// deleteKey deletes a key with its schedule, content type, chunks and blob, it returns an error if it doesn't exist.
+func deleteKey(txn engine.Txn, k string) error {
 	// Get the value for the key to check if it exists (it will return an error if it doesn't)
-	_, errGet := txn.Get([]byte(k))
+	oldValue, errGet := getFullValue(txn, k)
 	if errGet != nil {
 		return errGet
 	}
 
+	errQuota := updateTenantUsage(txn, k, oldValue, nil)
+	if errQuota != nil {
+		return errQuota
+	}
+
+	errIndexes := updateIndexes(txn, k, oldValue, nil)
+	if errIndexes != nil {
+		return errorskit.Wrap(errIndexes, "couldn't update indexes on delete")
+	}
+
 	errDelete := txn.Delete([]byte(k))
 	if errDelete != nil {
 		return errDelete
 	}
 
-	errCommit := txn.Commit()
-	if errCommit != nil {
-		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	errSchedule := setNotBefore(txn, k, nil)
+	if errSchedule != nil {
+		return errSchedule
 	}
-
-	return nil
+	errContentType := setContentType(txn, k, "")
+	if errContentType != nil {
+		return errContentType
+	}
+	errChunks := dropChunks(txn, k, "")
+	if errChunks != nil {
+		return errChunks
+	}
+	return releaseBlob(txn, k)
 }
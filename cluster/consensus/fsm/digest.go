@@ -0,0 +1,142 @@
+package fsm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"hash/fnv"
+	"log"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"sync"
+	"time"
+)
+
+const (
+	// DigestBuckets is the number of leaves of the digests' merkle tree, the keys are spread across them by their hash.
+	DigestBuckets = 256
+	// maxDigests is the number of digests each node keeps, the oldest ones are dropped.
+	maxDigests = 16
+)
+
+// ErrDigestNotFound is returned when a digest wasn't computed by this node, or it was already dropped.
+var ErrDigestNotFound = errcode.New(errcode.NotFound, "digest not found")
+
+// Digest is a merkle tree of the whole keyspace of a node, computed from the data at the index of the DIGEST log,
+// so all the replicas compute it over the same logs and their digests can be compared.
+type Digest struct {
+	ID string `json:"id"`
+	// Root is the hash of all the buckets, the replicas with the same root have the same data.
+	Root string `json:"root"`
+	// Buckets are the hashes of the key-values in each bucket, to find where the replicas diverge.
+	Buckets []string  `json:"buckets"`
+	Keys    int       `json:"keys"`
+	At      time.Time `json:"at"`
+	// err is the error which stopped the digest from being computed.
+	err error
+}
+
+// digestStore keeps the last digests computed by the node, they aren't stored in the database,
+// since they describe the database itself.
+type digestStore struct {
+	mu      sync.Mutex
+	digests map[string]Digest
+	order   []string
+}
+
+func newDigestStore() *digestStore {
+	return &digestStore{digests: make(map[string]Digest)}
+}
+
+func (s *digestStore) add(d Digest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.digests[d.ID]; !ok {
+		s.order = append(s.order, d.ID)
+	}
+	s.digests[d.ID] = d
+	for len(s.order) > maxDigests {
+		delete(s.digests, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *digestStore) get(id string) (Digest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.digests[id]
+	return d, ok
+}
+
+// computeDigest is a DatabaseFSM's method which computes the digest of all the key-values, including the internal ones.
+//
+// The read transaction is taken while the log is applied, so the digest is of the data at its index,
+// but the keyspace is read in the background, since it's as slow as a full scan of the database
+// and it would block the logs applied after it. GetDigest finds the digest once it's computed.
+func (dbFSM DatabaseFSM) computeDigest(id string) {
+	txn := dbFSM.db.NewTransaction(false)
+	go func() {
+		defer txn.Discard()
+		d, errDigest := digestOf(id, txn)
+		if errDigest != nil {
+			log.Printf("[antientropy] couldn't compute the digest '%s': %v\n", id, errDigest)
+			d = Digest{ID: id, err: errDigest}
+		}
+		dbFSM.digests.add(d)
+	}()
+}
+
+// digestOf hashes the key-values read by txn.
+func digestOf(id string, txn engine.Txn) (Digest, error) {
+	buckets := make([]hash.Hash, DigestBuckets)
+	for i := range buckets {
+		buckets[i] = sha256.New()
+	}
+	keys := 0
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	errIterate := txn.Iterate(engine.IterOptions{}, func(key []byte, value []byte) error {
+		h := buckets[bucketOf(key)]
+		// The lengths are hashed too, so the boundaries between the keys and values are part of the digest.
+		for _, b := range [][]byte{key, value} {
+			n := binary.PutUvarint(lenBuf, uint64(len(b)))
+			_, _ = h.Write(lenBuf[:n])
+			_, _ = h.Write(b)
+		}
+		keys++
+		return nil
+	})
+	if errIterate != nil {
+		return Digest{}, errIterate
+	}
+
+	root := sha256.New()
+	d := Digest{ID: id, Buckets: make([]string, DigestBuckets), Keys: keys, At: time.Now()}
+	for i, h := range buckets {
+		sum := h.Sum(nil)
+		_, _ = root.Write(sum)
+		d.Buckets[i] = hex.EncodeToString(sum)
+	}
+	d.Root = hex.EncodeToString(root.Sum(nil))
+	return d, nil
+}
+
+// GetDigest is a DatabaseFSM's method which returns a digest computed by the LOCAL NODE.
+//
+// ErrDigestNotFound is returned while it's still being computed too.
+func (dbFSM DatabaseFSM) GetDigest(id string) (Digest, error) {
+	d, ok := dbFSM.digests.get(id)
+	if !ok {
+		return Digest{}, ErrDigestNotFound
+	}
+	if d.err != nil {
+		return Digest{}, d.err
+	}
+	return d, nil
+}
+
+func bucketOf(key []byte) int {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return int(h.Sum32() % DigestBuckets)
+}
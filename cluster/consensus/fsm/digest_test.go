@@ -0,0 +1,72 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/hashicorp/raft"
+	"nubedb/cluster/consensus/engine"
+	"testing"
+	"time"
+)
+
+func newBadgerFSM(t *testing.T) *DatabaseFSM {
+	t.Helper()
+	db, errOpen := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if errOpen != nil {
+		t.Fatal(errOpen)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	dbFSM, errFSM := New(engine.NewBadger(db), 0, 0)
+	if errFSM != nil {
+		t.Fatal(errFSM)
+	}
+	return dbFSM
+}
+
+func apply(t *testing.T, dbFSM *DatabaseFSM, index uint64, p *Payload) {
+	t.Helper()
+	data, errEncode := EncodePayload(p)
+	if errEncode != nil {
+		t.Fatal(errEncode)
+	}
+	res := dbFSM.Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: data})
+	if r, ok := res.(*ApplyRes); !ok || r.Error != nil {
+		t.Fatalf("applying %s '%s' returned %v", p.Operation, p.Key, res)
+	}
+}
+
+func waitDigest(t *testing.T, dbFSM *DatabaseFSM, id string) Digest {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		d, errGet := dbFSM.GetDigest(id)
+		if errGet == nil {
+			return d
+		}
+		if !errors.Is(errGet, ErrDigestNotFound) || time.Now().After(deadline) {
+			t.Fatalf("digest '%s': %v", id, errGet)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDigestIsOfTheDataAtItsIndex(t *testing.T) {
+	dbFSM := newBadgerFSM(t)
+	apply(t, dbFSM, 1, &Payload{Key: "a", Operation: "SET", Value: "1"})
+	apply(t, dbFSM, 2, &Payload{Key: "digest", Operation: "DIGEST"})
+	// Applied while the digest may still be computed, they must not be part of it.
+	for i := uint64(3); i < 100; i++ {
+		apply(t, dbFSM, i, &Payload{Key: fmt.Sprintf("k%v", i), Operation: "SET", Value: "v"})
+	}
+
+	// The same logs up to the digest, without the ones applied after it.
+	reference := newBadgerFSM(t)
+	apply(t, reference, 1, &Payload{Key: "a", Operation: "SET", Value: "1"})
+	apply(t, reference, 2, &Payload{Key: "digest", Operation: "DIGEST"})
+
+	got, want := waitDigest(t, dbFSM, "digest"), waitDigest(t, reference, "digest")
+	if got.Root != want.Root || got.Keys != want.Keys {
+		t.Errorf("got a digest of %v keys with root %s, want %v keys with root %s", got.Keys, got.Root, want.Keys, want.Root)
+	}
+}
@@ -0,0 +1,87 @@
+package fsm
+
+import (
+	"encoding/json"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/internal/metrics"
+)
+
+// KeyMeta holds the metadata of a stored key, without its value.
+type KeyMeta struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size"`
+	Version   uint64 `json:"version"`
+	ExpiresAt uint64 `json:"expiresAt"`
+}
+
+// Exists is a DatabaseFSM's method which checks if a key exists in the LOCAL NODE and returns its metadata.
+//
+// The value is never read, only the metadata the storage engine keeps about the key.
+func (dbFSM DatabaseFSM) Exists(k string) (KeyMeta, error) {
+	if !dbFSM.mayExist(k) {
+		return KeyMeta{}, engine.ErrKeyNotFound
+	}
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	if !isVisible(txn, k) {
+		return KeyMeta{}, engine.ErrKeyNotFound
+	}
+
+	meta, errMeta := txn.Meta([]byte(k))
+	if errMeta != nil {
+		return KeyMeta{}, errMeta
+	}
+
+	// The size of a value split in chunks is the one of the joined value, not of its manifest.
+	if manifest, errManifest := getTxnValue(txn, chunkedPrefix+k); errManifest == nil {
+		var m ChunkManifest
+		if json.Unmarshal(manifest, &m) == nil {
+			meta.Size = m.Size
+		}
+	}
+	// Likewise, the size of a value stored in a blob is the one of the blob.
+	if isBlob(txn, k) {
+		var ref BlobRef
+		if stored, errStored := getTxnValue(txn, k); errStored == nil && json.Unmarshal(stored, &ref) == nil {
+			meta.Size = ref.Size
+		}
+	}
+
+	return KeyMeta{
+		Key:       k,
+		Size:      meta.Size,
+		Version:   meta.Version,
+		ExpiresAt: meta.ExpiresAt,
+	}, nil
+}
+
+// FastExists is a DatabaseFSM's method which returns false if a key definitely doesn't exist in the LOCAL NODE,
+// answered by the keys' bloom filter without reading the storage engine.
+//
+// True means the key may exist: the filter has false positives, like the deleted keys until it's rebuilt,
+// or the scheduled keys which aren't visible yet. If the filter is disabled, the storage engine is read instead.
+func (dbFSM DatabaseFSM) FastExists(k string) bool {
+	if dbFSM.keys == nil {
+		_, errExists := dbFSM.Exists(k)
+		return errExists == nil
+	}
+	return dbFSM.mayExist(k)
+}
+
+// mayExist returns false if the keys' bloom filter rules out a key, always true if it's disabled.
+func (dbFSM DatabaseFSM) mayExist(k string) bool {
+	if dbFSM.keys == nil {
+		return true
+	}
+	mayExist := dbFSM.keys.MayContain([]byte(k))
+	metrics.RecordBloomLookup(mayExist)
+	return mayExist
+}
+
+// rebuildKeyFilter rebuilds the keys' bloom filter from the stored keys, dropping the deleted ones.
+func (dbFSM DatabaseFSM) rebuildKeyFilter() error {
+	if dbFSM.keys == nil {
+		return nil
+	}
+	return dbFSM.keys.Rebuild()
+}
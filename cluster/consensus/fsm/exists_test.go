@@ -0,0 +1,29 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExistsSizeIsOfTheValue(t *testing.T) {
+	dbFSM := newBadgerFSM(t)
+	for i, v := range []string{"", "a", "value", strings.Repeat("v", 4096)} {
+		k := fmt.Sprintf("key%v", i)
+		apply(t, dbFSM, uint64(i+1), &Payload{Key: k, Operation: "SET", Value: v})
+
+		txn := dbFSM.db.NewTransaction(false)
+		value, errGet := txn.Get([]byte(k))
+		txn.Discard()
+		if errGet != nil {
+			t.Fatal(errGet)
+		}
+		meta, errExists := dbFSM.Exists(k)
+		if errExists != nil {
+			t.Fatal(errExists)
+		}
+		if meta.Size != int64(len(value)) {
+			t.Errorf("key '%s': got size %v, want the value's %v", k, meta.Size, len(value))
+		}
+	}
+}
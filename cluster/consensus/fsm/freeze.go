@@ -0,0 +1,92 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"time"
+)
+
+// freezeKey holds the freeze of the writes, it only exists while they are frozen.
+const freezeKey = InternalPrefix + "freeze"
+
+// ErrFrozen is returned when a write is applied while the writes are frozen.
+var ErrFrozen = errcode.New(errcode.Frozen, "writes are frozen")
+
+// frozenOperations are the operations refused while the writes are frozen: the ones which write keys.
+//
+// The settings, like the indexes or the webhooks, and the checkpoints of the consumers can still be changed.
+var frozenOperations = map[string]bool{
+	"SET": true, "APPEND": true, "DELETE": true, "SOFTDELETE": true, "UNDELETE": true, "SETCHUNK": true, "SETCHUNKED": true,
+	"SERIESAPPEND": true, "SERIESDELETE": true, "DO": true,
+	"RESTOREDB": true, "REPLICATE": true, "PURGETOMBSTONES": true, "PURGESLOTS": true,
+}
+
+// Freeze is the state of the freeze of the writes.
+type Freeze struct {
+	Frozen bool       `json:"frozen"`
+	Reason string     `json:"reason,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// freeze is a DatabaseFSM's method which freezes the writes, the time is the one the freeze was requested at.
+func (dbFSM DatabaseFSM) freeze(value any) error {
+	var f Freeze
+	errDecode := decodeJSON(value, &f)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode freeze")
+	}
+	f.Frozen = true
+
+	b, errMarshal := json.Marshal(f)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal freeze")
+	}
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set([]byte(freezeKey), b)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// unfreeze is a DatabaseFSM's method which accepts the writes again.
+func (dbFSM DatabaseFSM) unfreeze() error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errDelete := txn.Delete([]byte(freezeKey))
+	if errDelete != nil {
+		return errDelete
+	}
+	return txn.Commit()
+}
+
+// GetFreeze returns whether the writes are frozen, and why.
+func (dbFSM DatabaseFSM) GetFreeze() (Freeze, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	b, errGet := txn.Get([]byte(freezeKey))
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return Freeze{}, nil
+	}
+	if errGet != nil {
+		return Freeze{}, errGet
+	}
+	var f Freeze
+	errUnmarshal := json.Unmarshal(b, &f)
+	if errUnmarshal != nil {
+		return Freeze{}, errorskit.Wrap(errUnmarshal, "couldn't unmarshal freeze")
+	}
+	return f, nil
+}
+
+// isFrozen returns whether the writes are frozen, they are considered frozen if it can't be read.
+func (dbFSM DatabaseFSM) isFrozen() bool {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	_, errMeta := txn.Meta([]byte(freezeKey))
+	return !errors.Is(errMeta, engine.ErrKeyNotFound)
+}
@@ -1,17 +1,30 @@
 package fsm
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/hashicorp/raft"
 	"github.com/narvikd/errorskit"
 	"io"
+	"log"
+	"nubedb/cluster/chaos"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/protocol"
+	"nubedb/cluster/shard"
+	"nubedb/internal/metrics"
+	"time"
 )
 
 // DatabaseFSM represents the finite state machine implementation for the database
 type DatabaseFSM struct {
-	db *badger.DB
+	db engine.Engine
+	// keys is the bloom filter of the keys, nil if it's disabled.
+	keys engine.KeyFilter
+	// witness discards all the applied operations, since witness nodes don't store data.
+	witness bool
+	// digests are the last digests of the keyspace computed by this node.
+	digests *digestStore
+	// change is the log being applied, while it's an operation recorded as a change, check commit.
+	change *appliedChange
 }
 
 // snapshot's is a struct that represents the snapshot of the state machine.
@@ -23,9 +36,23 @@ type snapshot struct{}
 
 // Payload is the Payload sent for use in raft.Apply
 type Payload struct {
-	Key       string `json:"key" validate:"required"`
-	Value     any    `json:"value"`
-	Operation string `json:"operation"`
+	Key       string `json:"key" validate:"required" codec:"key"`
+	Value     any    `json:"value" codec:"value"`
+	Operation string `json:"operation" codec:"operation"`
+	// ProtocolVersion is the protocol version of the node which wrote the payload, 0 for older nodes.
+	ProtocolVersion int `json:"protocolVersion,omitempty" codec:"protocolVersion,omitempty"`
+	// Version is the version of the payload's schema, check PayloadVersion.
+	Version int `json:"v,omitempty" codec:"v,omitempty"`
+	// NotBefore hides the key set by a SET from the reads until the given time.
+	NotBefore *time.Time `json:"notBefore,omitempty" codec:"notBefore,omitempty"`
+	// ContentType is the content type declared for the value set by a SET, check EncodeValue.
+	ContentType string `json:"contentType,omitempty" codec:"contentType,omitempty"`
+}
+
+// dataOperations are the operations which write a key, instead of changing the database's configuration.
+var dataOperations = map[string]bool{
+	"SET": true, "APPEND": true, "DELETE": true, "SOFTDELETE": true, "UNDELETE": true, "SETCHUNK": true, "SETCHUNKED": true,
+	"SERIESAPPEND": true, "SERIESDELETE": true, "DO": true,
 }
 
 // ApplyRes represents the response from raft.Apply
@@ -34,11 +61,33 @@ type ApplyRes struct {
 	Error error
 }
 
-// New creates a new instance of DatabaseFSM.
+// New creates a new instance of DatabaseFSM, storing its values with checksums on db,
+// and caching up to readCacheSize bytes of the read values in memory. 0 disables the cache.
+//
+// A bloom filter of the keys, sized for bloomKeys keys, answers FastExists. 0 disables it.
 //
 // Check DatabaseFSM for more info
-func New(db *badger.DB) *DatabaseFSM {
-	return &DatabaseFSM{db: db}
+func New(db engine.Engine, readCacheSize int64, bloomKeys uint64) (*DatabaseFSM, error) {
+	db = engine.WithChecksums(db)
+	if readCacheSize > 0 {
+		db = engine.WithCache(db, readCacheSize)
+	}
+	if bloomKeys <= 0 {
+		return &DatabaseFSM{db: db, digests: newDigestStore()}, nil
+	}
+
+	db, errBloom := engine.WithBloom(db, bloomKeys)
+	if errBloom != nil {
+		return nil, errorskit.Wrap(errBloom, "couldn't build the keys' bloom filter")
+	}
+	return &DatabaseFSM{db: db, keys: db.(engine.KeyFilter), digests: newDigestStore()}, nil
+}
+
+// NewWitness creates a DatabaseFSM for a witness node, which discards all the applied operations.
+//
+// It's backed by an empty in-memory engine, so reading from it is safe, but it never contains data.
+func NewWitness() *DatabaseFSM {
+	return &DatabaseFSM{db: engine.NewMemory(), witness: true, digests: newDigestStore()}
 }
 
 // Apply processes a Raft log entry
@@ -46,66 +95,250 @@ func (dbFSM DatabaseFSM) Apply(log *raft.Log) any {
 	// Process the Raft log entry based on its type
 	switch log.Type {
 	case raft.LogCommand:
-		p := new(Payload)
-		errUnMarshal := json.Unmarshal(log.Data, p)
-		if errUnMarshal != nil {
-			return errorskit.Wrap(errUnMarshal, "couldn't unmarshal storage payload")
-		}
-
-		// Process the log entry based on the operation type
-		// &ApplyRes struct is used to represent the response from the Apply method of the Raft log
-		switch p.Operation {
-		case "SET":
-			return &ApplyRes{
-				Error: dbFSM.set(p.Key, p.Value),
-			}
-		case "DELETE":
-			return &ApplyRes{
-				Error: dbFSM.delete(p.Key),
-			}
-		case "RESTOREDB":
-			return &ApplyRes{
-				Error: dbFSM.RestoreDB(p.Value),
+		if dbFSM.witness {
+			return &ApplyRes{}
+		}
+		p, errDecode := DecodePayload(log.Data)
+		if errDecode != nil {
+			return errDecode
+		}
+		refuseIncompatible(log.Index, p.ProtocolVersion)
+		chaos.BeforeApply()
+		defer metrics.Track(metrics.ComponentFSMApply, p.Operation, p.Key, time.Now())
+		if dataOperations[p.Operation] {
+			metrics.RecordWrite(p.Key)
+			// The keys of the slots moved to another shard can't be written, the write must be sent to it.
+			if !dbFSM.ownsSlot(shard.SlotOf(p.Key)) {
+				return &ApplyRes{Error: ErrSlotMoved}
 			}
-		default:
-			return &ApplyRes{
-				Error: fmt.Errorf("operation type not recognized: %v", p.Operation),
+			errHold := dbFSM.checkHold(p.Key)
+			if errHold != nil {
+				return &ApplyRes{Error: errHold}
 			}
 		}
+		if frozenOperations[p.Operation] && dbFSM.isFrozen() {
+			return &ApplyRes{Error: ErrFrozen}
+		}
+
+		if recordedOperations[p.Operation] {
+			dbFSM.change = &appliedChange{log: log, payload: p}
+		}
+		return dbFSM.applyPayload(p)
 	default:
 		return fmt.Errorf("raft command not recognized: %v", log.Type)
 	}
 }
 
+// refuseIncompatible stops the node if a log was written with an incompatible protocol version.
+//
+// The log is committed, so skipping it would make this node diverge from the others.
+// The node stops instead, and applies it once it's upgraded.
+func refuseIncompatible(index uint64, version int) {
+	errVersion := protocol.Check(version)
+	if errVersion != nil {
+		log.Fatalf("[fsm] can't apply log %v: %v\n", index, errVersion)
+	}
+}
+
+// applyPayload applies a payload to the database based on its operation type.
+func (dbFSM DatabaseFSM) applyPayload(p *Payload) *ApplyRes {
+	// Process the log entry based on the operation type
+	// &ApplyRes struct is used to represent the response from the Apply method of the Raft log
+	switch p.Operation {
+	case "SET":
+		return &ApplyRes{
+			Error: dbFSM.setScheduled(p.Key, p.Value, p.NotBefore, p.ContentType),
+		}
+	case "SETCHUNK":
+		return &ApplyRes{
+			Error: dbFSM.setChunk(p.Key, p.Value),
+		}
+	case "SETCHUNKED":
+		return &ApplyRes{
+			Error: dbFSM.setChunked(p.Key, p.Value, p.NotBefore, p.ContentType),
+		}
+	case "APPEND":
+		return &ApplyRes{
+			Error: dbFSM.appendValue(p.Key, p.Value),
+		}
+	case "DELETE":
+		return &ApplyRes{
+			Error: dbFSM.delete(p.Key),
+		}
+	case "SOFTDELETE":
+		return &ApplyRes{
+			Error: dbFSM.softDelete(p.Key, p.Value),
+		}
+	case "UNDELETE":
+		return &ApplyRes{
+			Error: dbFSM.undelete(p.Key),
+		}
+	case "SERIESAPPEND":
+		return &ApplyRes{
+			Error: dbFSM.appendSeries(p.Key, p.Value),
+		}
+	case "SERIESDELETE":
+		return &ApplyRes{
+			Error: dbFSM.deleteSeries(p.Key),
+		}
+	case "PURGETOMBSTONES":
+		return &ApplyRes{
+			Error: dbFSM.purgeTombstones(p.Value),
+		}
+	case "CREATEINDEX":
+		return &ApplyRes{
+			Error: dbFSM.createIndex(p.Value),
+		}
+	case "DROPINDEX":
+		return &ApplyRes{
+			Error: dbFSM.dropIndex(p.Key),
+		}
+	case "ENABLESEARCH":
+		return &ApplyRes{
+			Error: dbFSM.enableSearch(p.Key),
+		}
+	case "DISABLESEARCH":
+		return &ApplyRes{
+			Error: dbFSM.disableSearch(p.Key),
+		}
+	case "ENABLEBLOBS":
+		return &ApplyRes{
+			Error: dbFSM.enableBlobs(p.Key),
+		}
+	case "DISABLEBLOBS":
+		return &ApplyRes{
+			Error: dbFSM.disableBlobs(p.Key),
+		}
+	case "SETSCHEMA":
+		return &ApplyRes{
+			Error: dbFSM.setSchema(p.Key, p.Value),
+		}
+	case "DELETESCHEMA":
+		return &ApplyRes{
+			Error: dbFSM.deleteSchema(p.Key),
+		}
+	case "SETHOOK":
+		return &ApplyRes{
+			Error: dbFSM.setHook(p.Key, p.Value),
+		}
+	case "DELETEHOOK":
+		return &ApplyRes{
+			Error: dbFSM.deleteHook(p.Key),
+		}
+	case "DO":
+		result, errScript := dbFSM.runScript(BucketOf(p.Key), p.Value)
+		return &ApplyRes{
+			Data:  result,
+			Error: errScript,
+		}
+	case "CHECKPOINT":
+		return &ApplyRes{
+			Error: dbFSM.setCheckpoint(p.Key, p.Value),
+		}
+	case "REPLICATE":
+		return &ApplyRes{
+			Error: dbFSM.applyReplicated(p.Key, p.Value),
+		}
+	case "SETBUCKETKEY":
+		return &ApplyRes{
+			Error: dbFSM.setBucketKey(p.Key, p.Value),
+		}
+	case "SETTENANT":
+		return &ApplyRes{
+			Error: dbFSM.setTenant(p.Value),
+		}
+	case "DELETETENANT":
+		return &ApplyRes{
+			Error: dbFSM.deleteTenant(p.Key),
+		}
+	case "RESTOREDB":
+		return &ApplyRes{
+			Error: dbFSM.RestoreDB(p.Value),
+		}
+	case "SETWEBHOOK":
+		return &ApplyRes{
+			Error: dbFSM.setWebhook(p.Value),
+		}
+	case "DELETEWEBHOOK":
+		return &ApplyRes{
+			Error: dbFSM.deleteWebhook(p.Key),
+		}
+	case "SETSHARDMAP":
+		return &ApplyRes{
+			Error: dbFSM.setShardMap(p.Value),
+		}
+	case "OWNSLOTS":
+		return &ApplyRes{
+			Error: dbFSM.setSlotsOwned(p.Value, true),
+		}
+	case "DISOWNSLOTS":
+		return &ApplyRes{
+			Error: dbFSM.setSlotsOwned(p.Value, false),
+		}
+	case "PURGESLOTS":
+		return &ApplyRes{
+			Error: dbFSM.purgeSlots(p.Value),
+		}
+	case "FREEZE":
+		return &ApplyRes{
+			Error: dbFSM.freeze(p.Value),
+		}
+	case "UNFREEZE":
+		return &ApplyRes{
+			Error: dbFSM.unfreeze(),
+		}
+	case "SETHOLD":
+		return &ApplyRes{
+			Error: dbFSM.setHold(p.Value),
+		}
+	case "DELETEHOLD":
+		return &ApplyRes{
+			Error: dbFSM.deleteHold(p.Value),
+		}
+	case "SETSETTING":
+		return &ApplyRes{
+			Error: dbFSM.setSetting(p.Key, p.Value),
+		}
+	case "DELETESETTING":
+		return &ApplyRes{
+			Error: dbFSM.deleteSetting(p.Key),
+		}
+	case "EXPECTREPLACEMENT":
+		return &ApplyRes{
+			Error: dbFSM.expectReplacement(p.Value),
+		}
+	case "DIGEST":
+		dbFSM.computeDigest(p.Key)
+		return &ApplyRes{}
+	default:
+		return &ApplyRes{
+			Error: fmt.Errorf("operation type not recognized: %v", p.Operation),
+		}
+	}
+}
+
 // Restore restores the finite state machine from a snapshot.
 //
 // io.ReadCloser represents a snapshot of the state machine that needs to be restored.
+// Its payloads are decoded in parallel, and stored in batches.
 func (dbFSM DatabaseFSM) Restore(snap io.ReadCloser) error {
-	d := json.NewDecoder(snap)
-	// Loops through the snapshot data and decodes each key-value pair into a Payload struct.
-	for d.More() {
-		dbValue := new(Payload)
-		errDecode := d.Decode(&dbValue)
-		if errDecode != nil {
-			return errorskit.Wrap(errDecode, "couldn't decode snapshot")
-		}
+	restored, errRestore := dbFSM.restorePayloads(snap)
+	if errRestore != nil {
+		return errRestore
+	}
 
-		errSet := dbFSM.set(dbValue.Key, dbValue.Value)
-		if errSet != nil {
-			return errorskit.Wrap(errSet, "couldn't restore key while restoring a snapshot")
+	// The search and index entries are rebuilt from the restored values, so they are consistent across replicas.
+	// The snapshots taken by nubedb are empty, since the data is persisted by the storage engine, so restoring one
+	// on boot doesn't change any value, and the keyspace isn't reindexed.
+	if restored > 0 {
+		errReindex := dbFSM.reindex()
+		if errReindex != nil {
+			return errorskit.Wrap(errReindex, "couldn't rebuild indexes while restoring a snapshot")
 		}
 	}
-
-	// Once the loop has completed, the program has to call the Token method on the decoder to check
-	// if it finds the closing bracket from the data stream.
-	// This particular token should not return an error, meaning that the program has reached the end of the data stream
-	// and all other tokens have been extracted.
-	// If it indeed returns an error, it would mean that an unexpected token was encountered during the Snapshot
-	// restoration, such as a closing brace in the wrong place.
-	// It could also be an indicator that the end of the input stream was reached before the closing bracket was found.
-	_, errToken := d.Token()
-	if errToken != nil && errToken != io.EOF { // If we reach the end of the stream, it isn't an error
-		return errorskit.Wrap(errToken, "couldn't restore snapshot due to json malformation")
+	errRebuild := dbFSM.rebuildKeyFilter()
+	if errRebuild != nil {
+		return errorskit.Wrap(errRebuild, "couldn't rebuild the keys' bloom filter while restoring a snapshot")
 	}
 
 	return snap.Close()
@@ -3,32 +3,35 @@ package fsm
 import (
 	"encoding/json"
 	"errors"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/internal/metrics"
+	"time"
 )
 
 // Get is a DatabaseFSM's method which gets a value from a key from the LOCAL NODE.
 //
 // This method isn't committed since there's no need for it.
+//
+// The scheduled keys aren't found until their time.
 func (dbFSM DatabaseFSM) Get(k string) (any, error) {
+	defer metrics.Track(metrics.ComponentBadgerGet, "GET", k, time.Now())
+	metrics.RecordRead(k)
 	var result any
-	dbResultValue := make([]byte, 0)
+	if !dbFSM.mayExist(k) {
+		return nil, engine.ErrKeyNotFound
+	}
 
 	txn := dbFSM.db.NewTransaction(false)
 	defer txn.Discard()
-	dbResult, errGet := txn.Get([]byte(k))
+	if !isVisible(txn, k) {
+		return nil, engine.ErrKeyNotFound
+	}
+	dbResultValue, errGet := getFullValue(txn, k)
 	if errGet != nil {
 		return nil, errGet
 	}
 
-	errDBResultValue := dbResult.Value(func(val []byte) error {
-		dbResultValue = append(dbResultValue, val...)
-		return nil
-	})
-	if errDBResultValue != nil {
-		return nil, errDBResultValue
-	}
-
 	if dbResultValue == nil || len(dbResultValue) <= 0 {
 		return nil, errors.New("no result for key")
 	}
@@ -51,12 +54,50 @@ func (dbFSM DatabaseFSM) GetKeys() []string {
 	txn := dbFSM.db.NewTransaction(false)
 	defer txn.Discard()
 
-	it := txn.NewIterator(badger.DefaultIteratorOptions)
-	defer it.Close()
+	hidden := hiddenKeys(txn)
+	_ = txn.Iterate(engine.IterOptions{KeysOnly: true}, func(key []byte, _ []byte) error {
+		if !IsInternalKey(key) && !hidden[string(key)] {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	return keys
+}
 
-	for it.Rewind(); it.Valid(); it.Next() {
-		key := it.Item().KeyCopy(nil)
-		keys = append(keys, string(key))
+// GetKeysAfter is a DatabaseFSM's method which returns, in order, up to limit keys starting with prefix
+// and greater than after from the LOCAL NODE. A limit equal or lower than 0 returns all of them.
+func (dbFSM DatabaseFSM) GetKeysAfter(prefix string, after string, limit int) []string {
+	var keys []string
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	hidden := hiddenKeys(txn)
+	opts := engine.IterOptions{Prefix: []byte(prefix), KeysOnly: true}
+	if after != "" {
+		opts.Start = []byte(after + "\x00")
 	}
+	_ = txn.Iterate(opts, func(key []byte, _ []byte) error {
+		if IsInternalKey(key) || hidden[string(key)] {
+			return nil
+		}
+		keys = append(keys, string(key))
+		if limit > 0 && len(keys) >= limit {
+			return engine.ErrStop
+		}
+		return nil
+	})
 	return keys
 }
+
+// IsEmpty is a DatabaseFSM's method which returns whether the LOCAL NODE doesn't have any key stored.
+func (dbFSM DatabaseFSM) IsEmpty() bool {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	empty := true
+	_ = txn.Iterate(engine.IterOptions{KeysOnly: true}, func(_ []byte, _ []byte) error {
+		empty = false
+		return engine.ErrStop
+	})
+	return empty
+}
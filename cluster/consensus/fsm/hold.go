@@ -0,0 +1,135 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"strings"
+	"time"
+)
+
+const (
+	// holdPrefix is the prefix under which the holds are stored.
+	holdPrefix = InternalPrefix + "hold/"
+	// holdKeyPrefix and holdPrefixPrefix store the holds of a key and of a prefix, by the key or prefix they hold.
+	holdKeyPrefix    = holdPrefix + "key/"
+	holdPrefixPrefix = holdPrefix + "prefix/"
+)
+
+var (
+	// ErrKeyHeld is returned when a key on hold is written.
+	ErrKeyHeld = errcode.New(errcode.KeyHeld, "key is on hold, it's read-only")
+	// ErrHoldNotFound is returned when a hold doesn't exist.
+	ErrHoldNotFound = errcode.New(errcode.NotFound, "hold not found")
+)
+
+// Hold makes a key, or all the keys starting with a prefix, read-only.
+type Hold struct {
+	Key string `json:"key" validate:"required"`
+	// Prefix holds all the keys starting with Key, instead of only Key.
+	Prefix bool       `json:"prefix"`
+	Reason string     `json:"reason,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+func holdStoreKey(key string, prefix bool) string {
+	if prefix {
+		return holdPrefixPrefix + key
+	}
+	return holdKeyPrefix + key
+}
+
+// setHold is a DatabaseFSM's method which creates or updates a hold.
+func (dbFSM DatabaseFSM) setHold(value any) error {
+	var hold Hold
+	errDecode := decodeJSON(value, &hold)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode hold")
+	}
+	if hold.Key == "" {
+		return errors.New("the hold's key can't be empty")
+	}
+
+	b, errMarshal := json.Marshal(hold)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal hold")
+	}
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set([]byte(holdStoreKey(hold.Key, hold.Prefix)), b)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// deleteHold is a DatabaseFSM's method which releases a hold.
+func (dbFSM DatabaseFSM) deleteHold(value any) error {
+	var hold Hold
+	errDecode := decodeJSON(value, &hold)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode hold")
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	k := holdStoreKey(hold.Key, hold.Prefix)
+	_, errMeta := txn.Meta([]byte(k))
+	if errors.Is(errMeta, engine.ErrKeyNotFound) {
+		return ErrHoldNotFound
+	}
+	if errMeta != nil {
+		return errMeta
+	}
+	errDelete := txn.Delete([]byte(k))
+	if errDelete != nil {
+		return errDelete
+	}
+	return txn.Commit()
+}
+
+// GetHolds is a DatabaseFSM's method which returns all the holds from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetHolds() ([]Hold, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	holds := make([]Hold, 0)
+	errIterate := txn.Iterate(engine.IterOptions{Prefix: []byte(holdPrefix)}, func(_ []byte, value []byte) error {
+		var hold Hold
+		errUnmarshal := json.Unmarshal(value, &hold)
+		if errUnmarshal != nil {
+			return errorskit.Wrap(errUnmarshal, "couldn't unmarshal hold")
+		}
+		holds = append(holds, hold)
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errIterate
+	}
+	return holds, nil
+}
+
+// checkHold returns ErrKeyHeld if a key is on hold, either by itself or by one of its prefixes.
+func (dbFSM DatabaseFSM) checkHold(key string) error {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	errIterate := txn.Iterate(engine.IterOptions{Prefix: []byte(holdPrefix), KeysOnly: true}, func(k []byte, _ []byte) error {
+		stored := string(k)
+		held := false
+		switch {
+		case strings.HasPrefix(stored, holdKeyPrefix):
+			held = strings.TrimPrefix(stored, holdKeyPrefix) == key
+		case strings.HasPrefix(stored, holdPrefixPrefix):
+			held = strings.HasPrefix(key, strings.TrimPrefix(stored, holdPrefixPrefix))
+		}
+		if held {
+			return fmt.Errorf("%w: held by '%s'", ErrKeyHeld, strings.TrimPrefix(stored, holdPrefix))
+		}
+		return nil
+	})
+	return errIterate
+}
@@ -0,0 +1,214 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"nubedb/pkg/lua"
+	"reflect"
+)
+
+// hookPrefix is the prefix under which the hooks of the buckets are stored.
+const hookPrefix = InternalPrefix + "hook/"
+
+var (
+	// ErrHookRejected is returned when the write hook of a bucket rejects a value, or fails.
+	ErrHookRejected = errcode.New(errcode.HookRejected, "value was rejected by the hook of its bucket")
+	// ErrHookNotFound is returned when a bucket doesn't have a hook.
+	ErrHookNotFound = errcode.New(errcode.NotFound, "bucket doesn't have a hook")
+	// ErrHookEncrypted is returned when setting a write hook on an encrypted bucket, or encrypting a bucket with one,
+	// since the nodes can't read the values they would pass to it.
+	ErrHookEncrypted = errcode.New(errcode.Encrypted, "the values of encrypted buckets can't be passed to a write hook")
+)
+
+// Hook holds the Lua scripts run on the values of a bucket, with the globals key and value.
+//
+// Check the lua package for the subset of Lua supported.
+type Hook struct {
+	// OnWrite is run by every node when a value is set or appended to, and returns the value to store.
+	// Calling error rejects the write.
+	OnWrite string `json:"onWrite,omitempty"`
+	// OnRead is run by the node serving a read, and returns the value to return. Returning nil hides the key.
+	OnRead string `json:"onRead,omitempty"`
+}
+
+// setHook is a DatabaseFSM's method which sets the hook of a bucket.
+func (dbFSM DatabaseFSM) setHook(bucket string, value any) error {
+	var hook Hook
+	errDecode := decodeJSON(value, &hook)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode hook")
+	}
+	errValid := hook.Validate()
+	if errValid != nil {
+		return errValid
+	}
+	b, errMarshal := json.Marshal(hook)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal hook")
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	if hook.OnWrite != "" && isEncryptedBucket(txn, bucket) {
+		return ErrHookEncrypted
+	}
+	errSet := txn.Set([]byte(hookPrefix+bucket), b)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// deleteHook is a DatabaseFSM's method which removes the hook of a bucket.
+func (dbFSM DatabaseFSM) deleteHook(bucket string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	_, errGet := getTxnValue(txn, hookPrefix+bucket)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return ErrHookNotFound
+	}
+	if errGet != nil {
+		return errGet
+	}
+	errDelete := txn.Delete([]byte(hookPrefix + bucket))
+	if errDelete != nil {
+		return errDelete
+	}
+	return txn.Commit()
+}
+
+// GetHook is a DatabaseFSM's method which returns the hook of a bucket from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetHook(bucket string) (Hook, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return getHook(txn, bucket)
+}
+
+// Validate checks that the hook has a script, and that its scripts compile.
+func (h Hook) Validate() error {
+	if h.OnWrite == "" && h.OnRead == "" {
+		return errors.New("the hook must have an onWrite or an onRead script")
+	}
+	for name, src := range map[string]string{"onWrite": h.OnWrite, "onRead": h.OnRead} {
+		if src == "" {
+			continue
+		}
+		_, errCompile := lua.Compile(src)
+		if errCompile != nil {
+			return fmt.Errorf("couldn't compile %s: %w", name, errCompile)
+		}
+	}
+	return nil
+}
+
+// FilterRead is a DatabaseFSM's method which runs the read hook of a key's bucket, in the LOCAL NODE, on its value.
+//
+// It returns the value to return, and false if the hook hides the key.
+func (dbFSM DatabaseFSM) FilterRead(k string, value any) (any, bool, error) {
+	hook, errHook := dbFSM.GetHook(BucketOf(k))
+	if errHook != nil && !errors.Is(errHook, ErrHookNotFound) {
+		return nil, false, errHook
+	}
+	if hook.OnRead == "" {
+		return value, true, nil
+	}
+	result, errRun := runHook(hook.OnRead, k, value)
+	if errRun != nil {
+		return nil, false, fmt.Errorf("the read hook of the bucket failed: %w", errRun)
+	}
+	return result, result != nil, nil
+}
+
+// runWriteHook runs the write hook of a key's bucket on a value stored as JSON, returning the value to store.
+func runWriteHook(txn engine.Txn, k string, contentType string, stored []byte) ([]byte, error) {
+	hook, errHook := getHook(txn, BucketOf(k))
+	if errHook != nil && !errors.Is(errHook, ErrHookNotFound) {
+		return nil, errHook
+	}
+	if hook.OnWrite == "" || isEncryptedBucket(txn, BucketOf(k)) {
+		return stored, nil
+	}
+	if !IsJSON(contentType) {
+		return nil, fmt.Errorf("%w: the values of content type '%s' can't be passed to it", ErrHookRejected, contentType)
+	}
+
+	var value any
+	errUnmarshal := json.Unmarshal(stored, &value)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal value for the hook")
+	}
+	result, errRun := runHook(hook.OnWrite, k, value)
+	if errRun != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHookRejected, errRun)
+	}
+	if result == nil {
+		return nil, fmt.Errorf("%w: the hook didn't return a value", ErrHookRejected)
+	}
+	transformed, errMarshal := json.Marshal(result)
+	if errMarshal != nil {
+		return nil, errorskit.Wrap(errMarshal, "couldn't marshal the value returned by the hook")
+	}
+	return transformed, nil
+}
+
+// checkChunkedHook runs the write hook of a key's bucket on a value written in chunks, which can only be validated,
+// since its chunks are already stored.
+func checkChunkedHook(txn engine.Txn, k string, contentType string, stored []byte) error {
+	transformed, errHook := runWriteHook(txn, k, contentType, stored)
+	if errHook != nil {
+		return errHook
+	}
+	if bytes.Equal(stored, transformed) {
+		return nil
+	}
+	// The value is compared decoded, since the streamed values are stored as they were received.
+	var before, after any
+	errBefore := json.Unmarshal(stored, &before)
+	errAfter := json.Unmarshal(transformed, &after)
+	if errBefore != nil || errAfter != nil || !reflect.DeepEqual(before, after) {
+		return fmt.Errorf("%w: the values written in chunks can't be transformed", ErrHookRejected)
+	}
+	return nil
+}
+
+func runHook(src string, k string, value any) (any, error) {
+	script, errCompile := lua.Compile(src)
+	if errCompile != nil {
+		return nil, errCompile
+	}
+	results, errRun := runLua(script, map[string]any{"key": k, "value": value})
+	if errRun != nil {
+		return nil, errRun
+	}
+	if len(results) <= 0 {
+		return nil, nil
+	}
+	return results[0], nil
+}
+
+func getHook(txn engine.Txn, bucket string) (Hook, error) {
+	var hook Hook
+	b, errGet := getTxnValue(txn, hookPrefix+bucket)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return hook, ErrHookNotFound
+	}
+	if errGet != nil {
+		return hook, errGet
+	}
+	errUnmarshal := json.Unmarshal(b, &hook)
+	if errUnmarshal != nil {
+		return hook, errorskit.Wrap(errUnmarshal, "couldn't unmarshal hook")
+	}
+	return hook, nil
+}
+
+func isEncryptedBucket(txn engine.Txn, bucket string) bool {
+	_, errGet := getTxnValue(txn, bucketKeyPrefix+bucket)
+	return errGet == nil
+}
@@ -0,0 +1,339 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"strings"
+)
+
+const (
+	// InternalPrefix is the prefix of the keys nubedb uses to store its own metadata.
+	InternalPrefix = "__nubedb/"
+	// indexDefPrefix is the prefix under which index definitions are stored.
+	indexDefPrefix = InternalPrefix + "idx/def/"
+	// indexEntryPrefix is the prefix under which index entries are stored.
+	//
+	// Each entry has the form: indexEntryPrefix + name + sep + fieldValue + sep + key
+	indexEntryPrefix = InternalPrefix + "idx/entry/"
+	indexSep         = "\x00"
+)
+
+// ErrIndexNotFound is returned when an index doesn't exist.
+var ErrIndexNotFound = errcode.New(errcode.NotFound, "index not found")
+
+// ErrReservedKey is returned when a client writes a key under InternalPrefix.
+var ErrReservedKey = errcode.New(errcode.ReservedKey, "keys under "+InternalPrefix+" are reserved for nubedb's metadata")
+
+// Index represents a secondary index declared over a JSON field of the values.
+type Index struct {
+	Name  string `json:"name" validate:"required"`
+	Field string `json:"field" validate:"required"`
+}
+
+// IsInternalKey returns whether a key belongs to nubedb's internal metadata.
+func IsInternalKey(k []byte) bool {
+	return bytes.HasPrefix(k, []byte(InternalPrefix))
+}
+
+// CheckUserKey returns ErrReservedKey if a client can't write k, since it belongs to nubedb's metadata,
+// so the features storing their metadata there don't collide with the clients' keys.
+func CheckUserKey(k string) error {
+	if IsInternalKey([]byte(k)) {
+		return ErrReservedKey
+	}
+	return nil
+}
+
+// createIndex is a DatabaseFSM's method which declares a new index and indexes all the existing keys.
+func (dbFSM DatabaseFSM) createIndex(value any) error {
+	idx, errIdx := decodeIndex(value)
+	if errIdx != nil {
+		return errIdx
+	}
+
+	def, errMarshal := json.Marshal(idx)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal index definition")
+	}
+	// The entries of an index declared before with the same name are dropped, since it could be over another field.
+	errStale := dbFSM.deletePrefix(indexEntryPrefix + idx.Name + indexSep)
+	if errStale != nil {
+		return errStale
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set([]byte(indexDefPrefix+idx.Name), def)
+	if errSet != nil {
+		return errSet
+	}
+	indirect := indirectKeys(txn)
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	// The existing keys are indexed in batches, since they may not fit in a transaction.
+	return dbFSM.inBatches(engine.IterOptions{}, func(txn engine.Txn, keys [][]byte, values [][]byte) error {
+		batch, errValues := userValues(txn, keys, values, indirect)
+		if errValues != nil {
+			return errValues
+		}
+		for k, v := range batch {
+			errEntry := setIndexEntry(txn, idx, k, v)
+			if errEntry != nil {
+				return errEntry
+			}
+		}
+		return nil
+	})
+}
+
+// dropIndex is a DatabaseFSM's method which removes an index and all its entries.
+func (dbFSM DatabaseFSM) dropIndex(name string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	_, errGet := txn.Get([]byte(indexDefPrefix + name))
+	if errGet != nil {
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
+			return ErrIndexNotFound
+		}
+		return errGet
+	}
+	errDelete := txn.Delete([]byte(indexDefPrefix + name))
+	if errDelete != nil {
+		return errDelete
+	}
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return dbFSM.deletePrefix(indexEntryPrefix + name + indexSep)
+}
+
+// updateIndexes keeps the index entries of a key in sync with its value inside the given transaction.
+//
+// oldValue or newValue can be nil if the key didn't exist, or if it's being deleted.
+func updateIndexes(txn engine.Txn, k string, oldValue []byte, newValue []byte) error {
+	if IsInternalKey([]byte(k)) {
+		return nil
+	}
+
+	indexes, errIndexes := getIndexes(txn)
+	if errIndexes != nil {
+		return errIndexes
+	}
+
+	for _, idx := range indexes {
+		if oldValue != nil {
+			if fieldValue, ok := extractField(oldValue, idx.Field); ok {
+				errDelete := txn.Delete(indexEntryKey(idx.Name, fieldValue, k))
+				if errDelete != nil {
+					return errDelete
+				}
+			}
+		}
+		if newValue != nil {
+			errEntry := setIndexEntry(txn, idx, k, newValue)
+			if errEntry != nil {
+				return errEntry
+			}
+		}
+	}
+
+	return updateSearchIndex(txn, k, oldValue, newValue)
+}
+
+// reindex is a DatabaseFSM's method which rebuilds all the index and search entries,
+// and the tenants' usage, from the stored values.
+//
+// It's used after restoring a backup, since the backup could have been taken before an index was declared.
+// The keys are reindexed in batches, since they may not fit in a transaction.
+func (dbFSM DatabaseFSM) reindex() error {
+	for _, prefix := range []string{indexEntryPrefix, searchEntryPrefix} {
+		errStale := dbFSM.deletePrefix(prefix)
+		if errStale != nil {
+			return errStale
+		}
+	}
+
+	// The tenants' usage is also recalculated, without enforcing their quotas since the values are already stored.
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errReset := resetTenantUsages(txn)
+	if errReset != nil {
+		return errReset
+	}
+	indirect := indirectKeys(txn)
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return dbFSM.inBatches(engine.IterOptions{}, func(txn engine.Txn, keys [][]byte, values [][]byte) error {
+		batch, errValues := userValues(txn, keys, values, indirect)
+		if errValues != nil {
+			return errValues
+		}
+		for k, v := range batch {
+			errIndexes := updateIndexes(txn, k, nil, v)
+			if errIndexes != nil {
+				return errIndexes
+			}
+			errUsage := changeTenantUsage(txn, k, nil, v, false)
+			if errUsage != nil {
+				return errUsage
+			}
+		}
+		return nil
+	})
+}
+
+// GetIndexes is a DatabaseFSM's method which returns all the declared indexes from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetIndexes() ([]Index, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return getIndexes(txn)
+}
+
+// Query is a DatabaseFSM's method which returns all the key-values from the LOCAL NODE
+// whose indexed field is equal to value.
+func (dbFSM DatabaseFSM) Query(indexName string, value string) (map[string]any, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	_, errGet := txn.Get([]byte(indexDefPrefix + indexName))
+	if errGet != nil {
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
+			return nil, ErrIndexNotFound
+		}
+		return nil, errGet
+	}
+
+	prefix := []byte(indexEntryPrefix + indexName + indexSep + value + indexSep)
+	result := make(map[string]any)
+	for _, entry := range getPrefixKeys(txn, prefix) {
+		k := string(bytes.TrimPrefix(entry, prefix))
+		if !isVisible(txn, k) {
+			continue
+		}
+		stored, errValue := getFullValue(txn, k)
+		if errValue != nil {
+			return nil, errorskit.Wrap(errValue, fmt.Sprintf("couldn't get indexed key '%s'", k))
+		}
+		var v any
+		errUnmarshal := json.Unmarshal(stored, &v)
+		if errUnmarshal != nil {
+			return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal query results from DB")
+		}
+		result[k] = v
+	}
+
+	return result, nil
+}
+
+func getIndexes(txn engine.Txn) ([]Index, error) {
+	var indexes []Index
+	errIterate := txn.Iterate(engine.IterOptions{Prefix: []byte(indexDefPrefix)}, func(_ []byte, value []byte) error {
+		var idx Index
+		errUnmarshal := json.Unmarshal(value, &idx)
+		if errUnmarshal != nil {
+			return errorskit.Wrap(errUnmarshal, "couldn't read index definition")
+		}
+		indexes = append(indexes, idx)
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errIterate
+	}
+
+	return indexes, nil
+}
+
+func setIndexEntry(txn engine.Txn, idx Index, k string, value []byte) error {
+	fieldValue, ok := extractField(value, idx.Field)
+	if !ok {
+		return nil
+	}
+	// The key is stored as the value of the entry too, as a JSON string, so backups can be restored.
+	entryValue, errMarshal := json.Marshal(k)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	return txn.Set(indexEntryKey(idx.Name, fieldValue, k), entryValue)
+}
+
+func indexEntryKey(name string, fieldValue string, k string) []byte {
+	return []byte(indexEntryPrefix + name + indexSep + fieldValue + indexSep + k)
+}
+
+// extractField returns the string representation of a field (using dot notation, ex: user.email) of a JSON value.
+//
+// Strings are returned as they are, any other type is returned as its JSON representation.
+func extractField(value []byte, field string) (string, bool) {
+	var current any
+	if json.Unmarshal(value, &current) != nil {
+		return "", false
+	}
+
+	for _, part := range strings.Split(field, ".") {
+		m, isMap := current.(map[string]any)
+		if !isMap {
+			return "", false
+		}
+		current, isMap = m[part]
+		if !isMap {
+			return "", false
+		}
+	}
+
+	switch v := current.(type) {
+	case string:
+		return v, true
+	case map[string]any, []any, nil:
+		// Objects, arrays and nulls can't be indexed
+		return "", false
+	default:
+		b, errMarshal := json.Marshal(v)
+		if errMarshal != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+}
+
+func decodeIndex(value any) (Index, error) {
+	var idx Index
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return idx, errorskit.Wrap(errMarshal, "couldn't marshal index")
+	}
+	errUnmarshal := json.Unmarshal(b, &idx)
+	if errUnmarshal != nil {
+		return idx, errorskit.Wrap(errUnmarshal, "couldn't unmarshal index")
+	}
+	if idx.Name == "" || idx.Field == "" {
+		return idx, errors.New("index name and field are required")
+	}
+	if strings.Contains(idx.Name, indexSep) {
+		return idx, errors.New("index name contains an invalid character")
+	}
+	return idx, nil
+}
+
+// getPrefixKeys returns a copy of all the keys that start with prefix.
+func getPrefixKeys(txn engine.Txn, prefix []byte) [][]byte {
+	var keys [][]byte
+	_ = txn.Iterate(engine.IterOptions{Prefix: prefix, KeysOnly: true}, func(key []byte, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys
+}
@@ -0,0 +1,241 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/narvikd/errorskit"
+	"reflect"
+	"time"
+)
+
+// PayloadVersion is the version of the payload's schema written by this node.
+//
+// To change the schema, increase it, register a decoder for the new version,
+// and a migration from the previous version, so the old consensus logs can still be replayed.
+const PayloadVersion = 3
+
+// Encodings of the payloads.
+const (
+	PayloadEncodingJSON    = "json"
+	PayloadEncodingMsgpack = "msgpack"
+)
+
+// msgpackMarker is the first byte of the payloads encoded with msgpack, it can't be the first byte of a JSON payload.
+const msgpackMarker byte = 0x00
+
+// payloadEncoding is the encoding used to write the payloads, set with SetPayloadEncoding.
+//
+// It's JSON by default, since the nodes of the older versions can only decode JSON payloads,
+// msgpack must only be enabled once every node of the cluster decodes it.
+var payloadEncoding = PayloadEncodingJSON
+
+// msgpackHandle decodes the maps and strings inside the values with the same types encoding/json does.
+var msgpackHandle = &codec.MsgpackHandle{
+	RawToString: true,
+	WriteExt:    true,
+	BasicHandle: codec.BasicHandle{
+		DecodeOptions: codec.DecodeOptions{MapType: reflect.TypeOf(map[string]any{})},
+	},
+}
+
+// SetPayloadEncoding sets the encoding used to write the payloads, the payloads are always decoded with either.
+func SetPayloadEncoding(encoding string) error {
+	switch encoding {
+	case PayloadEncodingJSON, PayloadEncodingMsgpack:
+		payloadEncoding = encoding
+		return nil
+	default:
+		return fmt.Errorf("payload encoding not recognized: %s", encoding)
+	}
+}
+
+// payloadDecoder decodes a payload serialized with a given schema version.
+type payloadDecoder func(data []byte) (*Payload, error)
+
+// payloadMigration upgrades a payload decoded with a version to the next one.
+type payloadMigration func(p *Payload) error
+
+var (
+	// payloadDecoders holds the decoder of each payload version.
+	//
+	// Version 0 is the one of the payloads written before the payloads were versioned, which is the same as version 1.
+	// Version 2 renamed the JSON field not_before to notBefore, and version 3 content_type to contentType.
+	payloadDecoders = map[int]payloadDecoder{
+		0: decodeJSONPayloadV1,
+		1: decodeJSONPayloadV1,
+		2: decodeJSONPayloadV2,
+		3: decodeJSONPayload,
+	}
+	// payloadMigrations holds the migration from each version to the next one.
+	payloadMigrations = map[int]payloadMigration{
+		0: func(_ *Payload) error { return nil },
+		1: func(_ *Payload) error { return nil },
+		2: func(_ *Payload) error { return nil },
+	}
+)
+
+// EncodePayload serializes a payload with the current schema version, using the configured encoding.
+func EncodePayload(p *Payload) ([]byte, error) {
+	p.Version = PayloadVersion
+	if payloadEncoding == PayloadEncodingMsgpack {
+		value, errValue := toGenericValue(p.Value)
+		if errValue != nil {
+			return nil, errValue
+		}
+		encoded := *p
+		encoded.Value = value
+
+		var b []byte
+		errEncode := codec.NewEncoderBytes(&b, msgpackHandle).Encode(&encoded)
+		if errEncode != nil {
+			return nil, errorskit.Wrap(errEncode, "couldn't encode payload")
+		}
+		return append([]byte{msgpackMarker}, b...), nil
+	}
+
+	b, errMarshal := json.Marshal(p)
+	if errMarshal != nil {
+		return nil, errorskit.Wrap(errMarshal, "couldn't marshal payload")
+	}
+	return b, nil
+}
+
+// DecodePayload deserializes a payload written with any encoding and any known schema version,
+// and migrates it to the current one.
+func DecodePayload(data []byte) (*Payload, error) {
+	p, errDecode := decodePayload(data)
+	if errDecode != nil {
+		return nil, errDecode
+	}
+
+	for v := p.Version; v < PayloadVersion; v++ {
+		migrate, ok := payloadMigrations[v]
+		if !ok {
+			return nil, fmt.Errorf("there isn't a migration for payload version %v", v)
+		}
+		errMigrate := migrate(p)
+		if errMigrate != nil {
+			return nil, errorskit.Wrap(errMigrate, fmt.Sprintf("couldn't migrate payload from version %v", v))
+		}
+	}
+	p.Version = PayloadVersion
+	return p, nil
+}
+
+// decodePayload decodes a payload with the decoder of its encoding and version, without migrating it.
+func decodePayload(data []byte) (*Payload, error) {
+	if len(data) > 0 && data[0] == msgpackMarker {
+		// Msgpack payloads were introduced after versioning them, so their version is always set.
+		p := new(Payload)
+		errDecode := codec.NewDecoderBytes(data[1:], msgpackHandle).Decode(p)
+		if errDecode != nil {
+			return nil, errorskit.Wrap(errDecode, "couldn't decode msgpack payload")
+		}
+		if p.Version > PayloadVersion {
+			return nil, fmt.Errorf("payload version %v not recognized, the newest known is %v", p.Version, PayloadVersion)
+		}
+		p.Value = toJSONTypes(p.Value)
+		return p, nil
+	}
+
+	var header struct {
+		Version int `json:"v"`
+	}
+	errHeader := json.Unmarshal(data, &header)
+	if errHeader != nil {
+		return nil, errorskit.Wrap(errHeader, "couldn't read payload version")
+	}
+	decode, ok := payloadDecoders[header.Version]
+	if !ok {
+		return nil, fmt.Errorf("payload version %v not recognized, the newest known is %v", header.Version, PayloadVersion)
+	}
+	return decode(data)
+}
+
+func decodeJSONPayload(data []byte) (*Payload, error) {
+	p := new(Payload)
+	errUnmarshal := json.Unmarshal(data, p)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal storage payload")
+	}
+	return p, nil
+}
+
+// decodeJSONPayloadV1 decodes a payload of version 1, whose JSON fields were in snake case.
+func decodeJSONPayloadV1(data []byte) (*Payload, error) {
+	type payload Payload
+	var v1 struct {
+		payload
+		NotBefore *time.Time `json:"not_before,omitempty"`
+	}
+	errUnmarshal := json.Unmarshal(data, &v1)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal storage payload")
+	}
+	p := Payload(v1.payload)
+	p.NotBefore = v1.NotBefore
+	return &p, nil
+}
+
+// decodeJSONPayloadV2 decodes a payload of version 2, whose content type was still in snake case,
+// the payloads of version 1 predate the content types.
+func decodeJSONPayloadV2(data []byte) (*Payload, error) {
+	type payload Payload
+	var v2 struct {
+		payload
+		ContentType string `json:"content_type,omitempty"`
+	}
+	errUnmarshal := json.Unmarshal(data, &v2)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal storage payload")
+	}
+	p := Payload(v2.payload)
+	p.ContentType = v2.ContentType
+	return &p, nil
+}
+
+// toGenericValue converts a value which isn't made of the types encoding/json decodes into,
+// like a struct or a json.RawMessage, into them, so it's encoded in msgpack the same way as in JSON.
+func toGenericValue(value any) (any, error) {
+	switch value.(type) {
+	case nil, bool, float64, string, []any, map[string]any:
+		return value, nil
+	}
+
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return nil, errorskit.Wrap(errMarshal, "couldn't marshal payload value")
+	}
+	var generic any
+	errUnmarshal := json.Unmarshal(b, &generic)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal payload value")
+	}
+	return generic, nil
+}
+
+// toJSONTypes converts the numbers of a value decoded from msgpack to float64,
+// so the operations see the same types whichever encoding the payload used.
+func toJSONTypes(value any) any {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case []byte:
+		return string(v)
+	case []any:
+		for i := range v {
+			v[i] = toJSONTypes(v[i])
+		}
+		return v
+	case map[string]any:
+		for k := range v {
+			v[k] = toJSONTypes(v[k])
+		}
+		return v
+	default:
+		return value
+	}
+}
@@ -0,0 +1,158 @@
+package fsm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// benchmarkPayload is a SET with a nested object value, like most of the writes.
+func benchmarkPayload() *Payload {
+	return &Payload{
+		Key:       "users/1234",
+		Operation: "SET",
+		Value: map[string]any{
+			"name":  "Ada",
+			"age":   float64(36),
+			"admin": true,
+			"tags":  []any{"math", "engines"},
+			"address": map[string]any{
+				"city":    "London",
+				"country": "UK",
+			},
+		},
+	}
+}
+
+// withPayloadEncoding runs fn with the payloads written with encoding, restoring the previous one afterwards.
+func withPayloadEncoding(t testing.TB, encoding string, fn func()) {
+	previous := payloadEncoding
+	if errSet := SetPayloadEncoding(encoding); errSet != nil {
+		t.Fatal(errSet)
+	}
+	defer func() { payloadEncoding = previous }()
+	fn()
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	notBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		payload *Payload
+	}{
+		{name: "object value", payload: benchmarkPayload()},
+		{name: "nil value", payload: &Payload{Key: "k", Operation: "DELETE"}},
+		{name: "scheduled", payload: &Payload{Key: "k", Operation: "SET", Value: "v", NotBefore: &notBefore}},
+		{name: "content type", payload: &Payload{Key: "k", Operation: "SET", Value: "aGk=", ContentType: "text/plain"}},
+	}
+	for _, encoding := range []string{PayloadEncodingJSON, PayloadEncodingMsgpack} {
+		for _, tt := range tests {
+			t.Run(encoding+"/"+tt.name, func(t *testing.T) {
+				withPayloadEncoding(t, encoding, func() {
+					data, errEncode := EncodePayload(tt.payload)
+					if errEncode != nil {
+						t.Fatal(errEncode)
+					}
+					got, errDecode := DecodePayload(data)
+					if errDecode != nil {
+						t.Fatal(errDecode)
+					}
+					if got.NotBefore != nil && tt.payload.NotBefore != nil && got.NotBefore.Equal(*tt.payload.NotBefore) {
+						got.NotBefore = tt.payload.NotBefore
+					}
+					if !reflect.DeepEqual(got, tt.payload) {
+						t.Errorf("got %+v, want %+v", got, tt.payload)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestDecodePayloadUnversionedJSON(t *testing.T) {
+	got, errDecode := DecodePayload([]byte(`{"key":"k","value":1,"operation":"SET"}`))
+	if errDecode != nil {
+		t.Fatal(errDecode)
+	}
+	if got.Key != "k" || got.Value != float64(1) || got.Version != PayloadVersion {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestDecodePayloadUnknownVersion(t *testing.T) {
+	_, errDecode := DecodePayload([]byte(`{"key":"k","operation":"SET","v":99}`))
+	if errDecode == nil {
+		t.Error("expected an error for an unknown payload version")
+	}
+}
+
+func BenchmarkEncodePayload(b *testing.B) {
+	for _, encoding := range []string{PayloadEncodingJSON, PayloadEncodingMsgpack} {
+		b.Run(encoding, func(b *testing.B) {
+			withPayloadEncoding(b, encoding, func() {
+				p := benchmarkPayload()
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					data, errEncode := EncodePayload(p)
+					if errEncode != nil {
+						b.Fatal(errEncode)
+					}
+					b.SetBytes(int64(len(data)))
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkDecodePayload(b *testing.B) {
+	for _, encoding := range []string{PayloadEncodingJSON, PayloadEncodingMsgpack} {
+		b.Run(encoding, func(b *testing.B) {
+			withPayloadEncoding(b, encoding, func() {
+				data, errEncode := EncodePayload(benchmarkPayload())
+				if errEncode != nil {
+					b.Fatal(errEncode)
+				}
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, errDecode := DecodePayload(data)
+					if errDecode != nil {
+						b.Fatal(errDecode)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestDecodePayloadV1SnakeCase(t *testing.T) {
+	got, errDecode := DecodePayload([]byte(`{"key":"k","value":"v","operation":"SET","v":1,"not_before":"2030-01-02T03:04:05Z"}`))
+	if errDecode != nil {
+		t.Fatal(errDecode)
+	}
+	want := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if got.NotBefore == nil || !got.NotBefore.Equal(want) {
+		t.Errorf("got not before %v, want %v", got.NotBefore, want)
+	}
+	if got.Version != PayloadVersion {
+		t.Errorf("got version %v, want %v", got.Version, PayloadVersion)
+	}
+}
+
+func TestDecodePayloadV2SnakeCaseContentType(t *testing.T) {
+	got, errDecode := DecodePayload([]byte(
+		`{"key":"k","value":"aGk=","operation":"SET","v":2,"notBefore":"2030-01-02T03:04:05Z","content_type":"text/plain"}`))
+	if errDecode != nil {
+		t.Fatal(errDecode)
+	}
+	if got.ContentType != "text/plain" {
+		t.Errorf("got content type %q, want text/plain", got.ContentType)
+	}
+	if got.NotBefore == nil {
+		t.Error("got no not before")
+	}
+	if got.Version != PayloadVersion {
+		t.Errorf("got version %v, want %v", got.Version, PayloadVersion)
+	}
+}
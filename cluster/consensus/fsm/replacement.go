@@ -0,0 +1,59 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"time"
+)
+
+// replacementPrefix holds the nodes expected to be replaced, by their IDs.
+const replacementPrefix = InternalPrefix + "replacement/"
+
+// Replacement is a node going away which is expected to come back with the same identity,
+// like a Kubernetes pod being rescheduled, so it's kept in the consensus configuration until Until.
+type Replacement struct {
+	NodeID string    `json:"nodeID"`
+	Until  time.Time `json:"until"`
+}
+
+// expectReplacement is a DatabaseFSM's method which records that a node is expected to be replaced.
+//
+// The deadline is the one computed by the node that requested it, so every node keeps it equally.
+func (dbFSM DatabaseFSM) expectReplacement(value any) error {
+	var r Replacement
+	errDecode := decodeJSON(value, &r)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode replacement")
+	}
+	if r.NodeID == "" {
+		return errors.New("the node expected to be replaced is required")
+	}
+
+	b, errMarshal := json.Marshal(r)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal replacement")
+	}
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set([]byte(replacementPrefix+r.NodeID), b)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// ExpectedReplacement returns until when a node is expected to come back, if it is.
+func (dbFSM DatabaseFSM) ExpectedReplacement(nodeID string) (time.Time, bool) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	b, errGet := txn.Get([]byte(replacementPrefix + nodeID))
+	if errGet != nil {
+		return time.Time{}, false
+	}
+	var r Replacement
+	if json.Unmarshal(b, &r) != nil {
+		return time.Time{}, false
+	}
+	return r.Until, true
+}
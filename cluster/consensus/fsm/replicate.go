@@ -0,0 +1,134 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"sort"
+	"strconv"
+)
+
+// replicationPrefix is the prefix under which the last index replicated from every source cluster is stored.
+const replicationPrefix = InternalPrefix + "replication/"
+
+// applyReplicated is a DatabaseFSM's method which applies a change received from another cluster.
+//
+// Changes are applied idempotently: if the change's index was already applied from that source, it's skipped.
+// The change and the index replicated are written in the same transaction, so a crash can't apply one without the other.
+func (dbFSM DatabaseFSM) applyReplicated(source string, value any) error {
+	change, errChange := decodeChange(value)
+	if errChange != nil {
+		return errChange
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	last, errLast := getReplicatedIndex(txn, source)
+	if errLast != nil && !errors.Is(errLast, engine.ErrKeyNotFound) {
+		return errLast
+	}
+	if change.Index <= last {
+		return nil
+	}
+
+	errApply := applyChange(txn, change)
+	if errApply != nil {
+		return errApply
+	}
+	errSet := txn.Set([]byte(replicationPrefix+source), []byte(strconv.FormatUint(change.Index, 10)))
+	if errSet != nil {
+		return errSet
+	}
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	if change.Operation == "RESTOREDB" {
+		return dbFSM.reindex()
+	}
+	return nil
+}
+
+// applyChange applies a change inside a transaction, using the value it recorded after the operation was done.
+func applyChange(txn engine.Txn, change ChangeEvent) error {
+	switch change.Operation {
+	case "SET", "APPEND":
+		return storeScheduled(txn, change.Key, change.Value, change.NotBefore, change.ContentType)
+	case "DELETE":
+		errDelete := deleteKey(txn, change.Key)
+		if errDelete != nil && !errors.Is(errDelete, engine.ErrKeyNotFound) {
+			return errDelete
+		}
+		return nil
+	case "DO":
+		return applyScriptChanges(txn, change.Value)
+	case "RESTOREDB":
+		var contents any
+		errUnmarshal := json.Unmarshal(change.Value, &contents)
+		if errUnmarshal != nil {
+			return errorskit.Wrap(errUnmarshal, "couldn't unmarshal replicated backup")
+		}
+		return restoreKeys(txn, contents)
+	default:
+		return errors.New("replicated operation not recognized: " + change.Operation)
+	}
+}
+
+// applyScriptChanges applies the writes recorded by a script inside a transaction.
+func applyScriptChanges(txn engine.Txn, value json.RawMessage) error {
+	var changes ScriptChanges
+	errUnmarshal := json.Unmarshal(value, &changes)
+	if errUnmarshal != nil {
+		return errorskit.Wrap(errUnmarshal, "couldn't unmarshal replicated script changes")
+	}
+	keys := make([]string, 0, len(changes.Set))
+	for k := range changes.Set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		errSet := storeScheduled(txn, k, changes.Set[k], nil, "")
+		if errSet != nil {
+			return errSet
+		}
+	}
+	for _, k := range changes.Deleted {
+		errDelete := deleteKey(txn, k)
+		if errDelete != nil && !errors.Is(errDelete, engine.ErrKeyNotFound) {
+			return errDelete
+		}
+	}
+	return nil
+}
+
+// GetReplicatedIndex is a DatabaseFSM's method which returns the last index replicated from a source cluster
+// from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetReplicatedIndex(source string) (uint64, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return getReplicatedIndex(txn, source)
+}
+
+func getReplicatedIndex(txn engine.Txn, source string) (uint64, error) {
+	value, errGet := getTxnValue(txn, replicationPrefix+source)
+	if errGet != nil {
+		return 0, errGet
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+func decodeChange(value any) (ChangeEvent, error) {
+	var change ChangeEvent
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return change, errorskit.Wrap(errMarshal, "couldn't marshal change")
+	}
+	errUnmarshal := json.Unmarshal(b, &change)
+	if errUnmarshal != nil {
+		return change, errorskit.Wrap(errUnmarshal, "couldn't unmarshal change")
+	}
+	return change, nil
+}
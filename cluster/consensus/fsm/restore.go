@@ -0,0 +1,185 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"io"
+	"nubedb/cluster/consensus/engine"
+	"runtime"
+)
+
+const (
+	// restoreBatchKeys is the maximum number of keys restored in a transaction.
+	restoreBatchKeys = 256
+	// restoreBatchBytes is the size of the payloads from which a batch is restored without waiting for more keys,
+	// so its transaction doesn't get too big.
+	restoreBatchBytes = 4 << 20
+)
+
+// restoreBatch is a batch of a snapshot's payloads, which are decoded by a worker and then stored in a transaction.
+type restoreBatch struct {
+	raws   []json.RawMessage
+	size   int
+	keys   []string
+	values [][]byte
+	err    error
+	// done is closed once the batch is decoded.
+	done chan struct{}
+}
+
+// restorePayloads sets the key-values of the payloads of a snapshot, returning how many were restored.
+//
+// The payloads are decoded in parallel, by a worker per CPU, and stored in batched transactions
+// in the order of the snapshot, instead of in a transaction per key.
+func (dbFSM DatabaseFSM) restorePayloads(r io.Reader) (int, error) {
+	workers := runtime.GOMAXPROCS(0)
+	toDecode := make(chan *restoreBatch, workers)
+	pending := make(chan *restoreBatch, workers*2)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for b := range toDecode {
+				b.decode()
+			}
+		}()
+	}
+	go readRestoreBatches(r, toDecode, pending, stop)
+
+	restored := 0
+	for b := range pending {
+		<-b.done
+		if b.err != nil {
+			return restored, b.err
+		}
+		errApply := dbFSM.applyRestoreBatch(b)
+		if errApply != nil {
+			return restored, errApply
+		}
+		restored += len(b.keys)
+	}
+	return restored, nil
+}
+
+// readRestoreBatches splits the payloads of a snapshot in batches, sending each one to be decoded,
+// and in order to pending to be stored. It stops when stop is closed.
+func readRestoreBatches(r io.Reader, toDecode chan<- *restoreBatch, pending chan<- *restoreBatch, stop <-chan struct{}) {
+	defer close(toDecode)
+	defer close(pending)
+
+	send := func(b *restoreBatch) bool {
+		select {
+		case pending <- b:
+		case <-stop:
+			return false
+		}
+		if b.err != nil {
+			return false
+		}
+		select {
+		case toDecode <- b:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+	fail := func(err error) {
+		b := &restoreBatch{err: err, done: make(chan struct{})}
+		close(b.done)
+		send(b)
+	}
+
+	d := json.NewDecoder(r)
+	b := newRestoreBatch()
+	for d.More() {
+		var raw json.RawMessage
+		errRaw := d.Decode(&raw)
+		if errRaw != nil {
+			fail(errorskit.Wrap(errRaw, "couldn't decode snapshot"))
+			return
+		}
+		b.raws = append(b.raws, raw)
+		b.size += len(raw)
+		if len(b.raws) >= restoreBatchKeys || b.size >= restoreBatchBytes {
+			if !send(b) {
+				return
+			}
+			b = newRestoreBatch()
+		}
+	}
+	if len(b.raws) > 0 && !send(b) {
+		return
+	}
+
+	// The closing token of the stream must be found, otherwise the snapshot is malformed,
+	// like if the end of the stream was reached before its closing bracket.
+	_, errToken := d.Token()
+	if errToken != nil && errToken != io.EOF { // If we reach the end of the stream, it isn't an error
+		fail(errorskit.Wrap(errToken, "couldn't restore snapshot due to json malformation"))
+	}
+}
+
+func newRestoreBatch() *restoreBatch {
+	return &restoreBatch{done: make(chan struct{})}
+}
+
+// decode decodes the payloads of the batch, migrating the ones written with an older schema version,
+// and encodes their values as they are stored.
+func (b *restoreBatch) decode() {
+	defer close(b.done)
+	b.keys = make([]string, 0, len(b.raws))
+	b.values = make([][]byte, 0, len(b.raws))
+	for _, raw := range b.raws {
+		p, errDecode := DecodePayload(raw)
+		if errDecode != nil {
+			b.err = errorskit.Wrap(errDecode, "couldn't decode snapshot")
+			return
+		}
+		dbValue, errMarshal := json.Marshal(p.Value)
+		if errMarshal != nil {
+			b.err = errorskit.Wrap(errMarshal, "couldn't marshal value on set")
+			return
+		}
+		if len(dbValue) <= 0 {
+			b.err = errors.New("value was empty")
+			return
+		}
+		b.keys = append(b.keys, p.Key)
+		b.values = append(b.values, dbValue)
+	}
+	b.raws = nil
+}
+
+// applyRestoreBatch stores the key-values of a decoded batch in a transaction.
+//
+// The indexes aren't updated, since they are rebuilt once all the batches are stored.
+func (dbFSM DatabaseFSM) applyRestoreBatch(b *restoreBatch) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	for i, k := range b.keys {
+		errSet := restoreValue(txn, k, b.values[i])
+		if errSet != nil {
+			return errorskit.Wrap(errSet, "couldn't restore key while restoring a snapshot")
+		}
+	}
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction while restoring a snapshot")
+	}
+	return nil
+}
+
+// restoreValue sets the value of a key, encoded as JSON, updating the usage of its tenant.
+func restoreValue(txn engine.Txn, k string, dbValue []byte) error {
+	oldValue, errGet := getFullValue(txn, k)
+	if errGet != nil && !errors.Is(errGet, engine.ErrKeyNotFound) {
+		return errGet
+	}
+	errQuota := updateTenantUsage(txn, k, oldValue, dbValue)
+	if errQuota != nil {
+		return errQuota
+	}
+	return setStored(txn, k, dbValue)
+}
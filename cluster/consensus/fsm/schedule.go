@@ -0,0 +1,133 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"strconv"
+	"time"
+)
+
+// scheduledPrefix is the prefix under which the time from which a scheduled key is visible is stored.
+const scheduledPrefix = InternalPrefix + "scheduled/"
+
+// setScheduled is a DatabaseFSM's method which adds a key-value pair to the database,
+// which isn't visible to the reads until notBefore.
+//
+// The schedule is stored even if notBefore already passed, so every replica stores the same data
+// whenever it applies the write. A nil notBefore makes the key visible immediately.
+//
+// The content type declared for the value replaces the previous one, empty if it's JSON and wasn't declared.
+//
+// The value is first passed to the write hook of its bucket.
+func (dbFSM DatabaseFSM) setScheduled(k string, value any, notBefore *time.Time, contentType string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	errSet := storeScheduled(txn, k, value, notBefore, contentType)
+	if errSet != nil {
+		return errSet
+	}
+
+	errCommit := dbFSM.commit(txn, nil)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// storeScheduled sets a key inside a transaction, as described in setScheduled.
+func storeScheduled(txn engine.Txn, k string, value any, notBefore *time.Time, contentType string) error {
+	dbValue, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal value on set")
+	}
+	if len(dbValue) <= 0 {
+		return errors.New("value was empty")
+	}
+	dbValue, errHook := runWriteHook(txn, k, contentType, dbValue)
+	if errHook != nil {
+		return errHook
+	}
+
+	errStore := storeValue(txn, k, dbValue)
+	if errStore != nil {
+		return errStore
+	}
+	stored, errGet := getFullValue(txn, k)
+	if errGet != nil {
+		return errGet
+	}
+	errSchema := validateSchema(txn, k, contentType, stored)
+	if errSchema != nil {
+		return errSchema
+	}
+	errSchedule := setNotBefore(txn, k, notBefore)
+	if errSchedule != nil {
+		return errSchedule
+	}
+	errChunks := dropChunks(txn, k, "")
+	if errChunks != nil {
+		return errChunks
+	}
+	return setContentType(txn, k, contentType)
+}
+
+// NotBefore is a DatabaseFSM's method which returns the time from which a key is visible in the LOCAL NODE,
+// nil if it isn't scheduled.
+func (dbFSM DatabaseFSM) NotBefore(k string) *time.Time {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	notBefore, errGet := getNotBefore(txn, k)
+	if errGet != nil {
+		return nil
+	}
+	return &notBefore
+}
+
+// setNotBefore stores the time from which a key is visible, or removes it if notBefore is nil.
+func setNotBefore(txn engine.Txn, k string, notBefore *time.Time) error {
+	if notBefore == nil {
+		return txn.Delete([]byte(scheduledPrefix + k))
+	}
+	return txn.Set([]byte(scheduledPrefix+k), []byte(strconv.FormatInt(notBefore.UnixNano(), 10)))
+}
+
+func getNotBefore(txn engine.Txn, k string) (time.Time, error) {
+	b, errGet := getTxnValue(txn, scheduledPrefix+k)
+	if errGet != nil {
+		return time.Time{}, errGet
+	}
+	nanos, errParse := strconv.ParseInt(string(b), 10, 64)
+	if errParse != nil {
+		return time.Time{}, errorskit.Wrap(errParse, "couldn't parse the schedule of key '"+k+"'")
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// isVisible returns whether a key can be read: it isn't scheduled, or its time passed on this node's clock.
+func isVisible(txn engine.Txn, k string) bool {
+	notBefore, errGet := getNotBefore(txn, k)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return true
+	}
+	if errGet != nil {
+		return false
+	}
+	return !time.Now().Before(notBefore)
+}
+
+// hiddenKeys returns the scheduled keys which aren't visible yet on this node's clock.
+func hiddenKeys(txn engine.Txn) map[string]bool {
+	hidden := make(map[string]bool)
+	now := time.Now().UnixNano()
+	_ = txn.Iterate(engine.IterOptions{Prefix: []byte(scheduledPrefix)}, func(key []byte, value []byte) error {
+		nanos, errParse := strconv.ParseInt(string(value), 10, 64)
+		if errParse != nil || now < nanos {
+			hidden[string(key[len(scheduledPrefix):])] = true
+		}
+		return nil
+	})
+	return hidden
+}
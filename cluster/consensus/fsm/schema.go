@@ -0,0 +1,122 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"nubedb/pkg/jsonschema"
+)
+
+// schemaPrefix is the prefix under which the JSON Schemas the values of the buckets must match are stored.
+const schemaPrefix = InternalPrefix + "schema/"
+
+var (
+	// ErrSchemaViolation is returned when setting a value which doesn't match the schema of its bucket.
+	ErrSchemaViolation = errcode.New(errcode.SchemaViolation, "value doesn't match the schema of its bucket")
+	// ErrSchemaNotFound is returned when a bucket doesn't have a schema.
+	ErrSchemaNotFound = errcode.New(errcode.NotFound, "bucket doesn't have a schema")
+)
+
+// setSchema is a DatabaseFSM's method which sets the JSON Schema the values of a bucket must match.
+//
+// The values already stored aren't checked, only the ones set afterwards.
+func (dbFSM DatabaseFSM) setSchema(bucket string, value any) error {
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal schema")
+	}
+	_, errCompile := jsonschema.Compile(b)
+	if errCompile != nil {
+		return errCompile
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set([]byte(schemaPrefix+bucket), b)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// deleteSchema is a DatabaseFSM's method which removes the JSON Schema of a bucket.
+func (dbFSM DatabaseFSM) deleteSchema(bucket string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	_, errGet := getTxnValue(txn, schemaPrefix+bucket)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return ErrSchemaNotFound
+	}
+	if errGet != nil {
+		return errGet
+	}
+	errDelete := txn.Delete([]byte(schemaPrefix + bucket))
+	if errDelete != nil {
+		return errDelete
+	}
+	return txn.Commit()
+}
+
+// GetSchema is a DatabaseFSM's method which returns the JSON Schema of a bucket from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetSchema(bucket string) (json.RawMessage, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	b, errGet := getTxnValue(txn, schemaPrefix+bucket)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return nil, ErrSchemaNotFound
+	}
+	if errGet != nil {
+		return nil, errGet
+	}
+	return b, nil
+}
+
+// ValidateSchema is a DatabaseFSM's method which checks a value about to be set in a key against the schema of its bucket
+// in the LOCAL NODE, so it can be checked before it's encrypted.
+func (dbFSM DatabaseFSM) ValidateSchema(k string, contentType string, value any) error {
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal value")
+	}
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return checkSchema(txn, k, contentType, b)
+}
+
+// validateSchema checks a value stored as JSON against the schema of its key's bucket.
+//
+// The values of the encrypted buckets are checked by the node that received the write, before encrypting them.
+func validateSchema(txn engine.Txn, k string, contentType string, stored []byte) error {
+	if isEncryptedBucket(txn, BucketOf(k)) {
+		return nil
+	}
+	return checkSchema(txn, k, contentType, stored)
+}
+
+func checkSchema(txn engine.Txn, k string, contentType string, stored []byte) error {
+	b, errGet := getTxnValue(txn, schemaPrefix+BucketOf(k))
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return nil
+	}
+	if errGet != nil {
+		return errGet
+	}
+	if !IsJSON(contentType) {
+		return fmt.Errorf("%w: the values of content type '%s' can't be checked", ErrSchemaViolation, contentType)
+	}
+
+	schema, errCompile := jsonschema.Compile(b)
+	if errCompile != nil {
+		return errorskit.Wrap(errCompile, "couldn't compile the schema of the bucket")
+	}
+	errValidate := schema.ValidateJSON(stored)
+	if errValidate != nil {
+		return fmt.Errorf("%w: %v", ErrSchemaViolation, errValidate)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"nubedb/pkg/lua"
+	"strings"
+)
+
+var (
+	// ErrScriptFailed is returned when a script run with DO fails, none of its writes are applied.
+	ErrScriptFailed = errcode.New(errcode.ScriptFailed, "script failed")
+	// ErrScriptEncrypted is returned when running a script on an encrypted bucket, since the nodes can't read its values.
+	ErrScriptEncrypted = errcode.New(errcode.Encrypted, "the values of encrypted buckets can't be read by a script")
+)
+
+// Script is a Lua script run with DO against the keys of a bucket, all its writes are applied atomically.
+//
+// Check the lua package for the subset of Lua supported, and the README for the functions the script can call.
+type Script struct {
+	Source string `json:"source" validate:"required"`
+	// Args is passed to the script as the global args.
+	Args any `json:"args,omitempty"`
+}
+
+// ScriptResult is the result of a script run with DO.
+type ScriptResult struct {
+	// Result is the first value returned by the script.
+	Result any `json:"result"`
+	// Set and Deleted are the keys set and deleted by the script, in the order they were first written.
+	Set     []string `json:"set,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// ScriptChanges are the writes of a script, recorded in the changes log as a single DO change.
+type ScriptChanges struct {
+	Set     map[string]json.RawMessage `json:"set,omitempty"`
+	Deleted []string                   `json:"deleted,omitempty"`
+}
+
+// Validate checks that the script compiles.
+func (s Script) Validate() error {
+	_, errCompile := lua.Compile(s.Source)
+	if errCompile != nil {
+		return fmt.Errorf("couldn't compile script: %w", errCompile)
+	}
+	return nil
+}
+
+// scriptRun is the state of a script running inside a transaction.
+type scriptRun struct {
+	dbFSM  DatabaseFSM
+	txn    engine.Txn
+	bucket string
+	// written holds, for every key written, whether it was last deleted.
+	// order holds the keys in the order they were first written.
+	written map[string]bool
+	order   []string
+}
+
+// runScript is a DatabaseFSM's method which runs a script against the keys of a bucket within one transaction.
+//
+// The script reads the values as they are stored, including the scheduled ones before their time,
+// so every node gets the same result. If it fails, none of its writes are applied.
+func (dbFSM DatabaseFSM) runScript(bucket string, value any) (*ScriptResult, error) {
+	var script Script
+	errDecode := decodeJSON(value, &script)
+	if errDecode != nil {
+		return nil, errorskit.Wrap(errDecode, "couldn't decode script")
+	}
+	compiled, errCompile := lua.Compile(script.Source)
+	if errCompile != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScriptFailed, errCompile)
+	}
+	if bucket == "" || IsInternalKey([]byte(bucket+bucketSep)) {
+		return nil, fmt.Errorf("%w: scripts must run against a bucket", ErrScriptFailed)
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	if isEncryptedBucket(txn, bucket) {
+		return nil, ErrScriptEncrypted
+	}
+
+	run := &scriptRun{dbFSM: dbFSM, txn: txn, bucket: bucket, written: make(map[string]bool)}
+	results, errRun := runLua(compiled, map[string]any{
+		"bucket": bucket,
+		"args":   script.Args,
+		"get":    lua.Function(run.get),
+		"set":    lua.Function(run.set),
+		"delete": lua.Function(run.delete),
+		"keys":   lua.Function(run.keys),
+	})
+	if errRun != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScriptFailed, errRun)
+	}
+
+	result := &ScriptResult{}
+	if len(results) > 0 {
+		result.Result = results[0]
+	}
+	for _, k := range run.order {
+		if run.written[k] {
+			result.Deleted = append(result.Deleted, k)
+			continue
+		}
+		result.Set = append(result.Set, k)
+	}
+
+	errCommit := dbFSM.commit(txn, result)
+	if errCommit != nil {
+		return nil, errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return result, nil
+}
+
+// runLua runs a compiled script, returning a panic of the interpreter as an error,
+// so a script can't crash the node while its log is applied.
+func runLua(script *lua.Script, globals map[string]any) (results []any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the script crashed the interpreter: %v", r)
+		}
+	}()
+	return script.Run(globals)
+}
+
+// get returns the value of a key, nil if it doesn't exist.
+func (r *scriptRun) get(args ...any) (any, error) {
+	k, errKey := r.keyArg(args)
+	if errKey != nil {
+		return nil, errKey
+	}
+	stored, errGet := getFullValue(r.txn, k)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if errGet != nil {
+		return nil, errGet
+	}
+	if contentType := getContentType(r.txn, k); !IsJSON(contentType) {
+		return nil, fmt.Errorf("the values of content type '%s' can't be passed to a script", contentType)
+	}
+
+	var value any
+	errUnmarshal := json.Unmarshal(stored, &value)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal value")
+	}
+	return value, nil
+}
+
+// set sets the value of a key, passing it to the hook and the schema of the bucket as a SET.
+func (r *scriptRun) set(args ...any) (any, error) {
+	k, errKey := r.keyArg(args)
+	if errKey != nil {
+		return nil, errKey
+	}
+	if len(args) < 2 || args[1] == nil {
+		return nil, errors.New("set needs a value, use delete to remove a key")
+	}
+	errHold := r.dbFSM.checkHold(k)
+	if errHold != nil {
+		return nil, errHold
+	}
+
+	dbValue, errMarshal := json.Marshal(args[1])
+	if errMarshal != nil {
+		return nil, errorskit.Wrap(errMarshal, "couldn't marshal value")
+	}
+	dbValue, errHook := runWriteHook(r.txn, k, "", dbValue)
+	if errHook != nil {
+		return nil, errHook
+	}
+	errSchema := validateSchema(r.txn, k, "", dbValue)
+	if errSchema != nil {
+		return nil, errSchema
+	}
+
+	errSet := storeValue(r.txn, k, dbValue)
+	if errSet != nil {
+		return nil, errSet
+	}
+	errSchedule := setNotBefore(r.txn, k, nil)
+	if errSchedule != nil {
+		return nil, errSchedule
+	}
+	errChunks := dropChunks(r.txn, k, "")
+	if errChunks != nil {
+		return nil, errChunks
+	}
+	errContentType := setContentType(r.txn, k, "")
+	if errContentType != nil {
+		return nil, errContentType
+	}
+	r.record(k, false)
+	return nil, nil
+}
+
+// delete deletes a key, returning whether it existed.
+func (r *scriptRun) delete(args ...any) (any, error) {
+	k, errKey := r.keyArg(args)
+	if errKey != nil {
+		return nil, errKey
+	}
+	errHold := r.dbFSM.checkHold(k)
+	if errHold != nil {
+		return nil, errHold
+	}
+
+	errDelete := deleteKey(r.txn, k)
+	if errors.Is(errDelete, engine.ErrKeyNotFound) {
+		return false, nil
+	}
+	if errDelete != nil {
+		return nil, errDelete
+	}
+	r.record(k, true)
+	return true, nil
+}
+
+// keys returns the keys starting with a prefix of the bucket in order, up to an optional limit.
+func (r *scriptRun) keys(args ...any) (any, error) {
+	prefix, errKey := r.keyArg(args)
+	if errKey != nil {
+		return nil, errKey
+	}
+	limit := 0
+	if len(args) > 1 && args[1] != nil {
+		n, isNum := args[1].(float64)
+		if !isNum || n < 0 {
+			return nil, errors.New("the limit of keys must be a positive number")
+		}
+		limit = int(n)
+	}
+
+	keys := make([]any, 0)
+	errIterate := r.txn.Iterate(engine.IterOptions{Prefix: []byte(prefix), KeysOnly: true}, func(key []byte, _ []byte) error {
+		keys = append(keys, string(key))
+		if limit > 0 && len(keys) >= limit {
+			return engine.ErrStop
+		}
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errIterate
+	}
+	return keys, nil
+}
+
+// keyArg returns the key passed as the first argument, which must be in the script's bucket.
+func (r *scriptRun) keyArg(args []any) (string, error) {
+	if len(args) < 1 {
+		return "", errors.New("a key is required")
+	}
+	k, isStr := args[0].(string)
+	if !isStr {
+		return "", errors.New("the key must be a string")
+	}
+	if !strings.HasPrefix(k, r.bucket+bucketSep) {
+		return "", fmt.Errorf("key '%s' isn't in bucket '%s'", k, r.bucket)
+	}
+	return k, nil
+}
+
+func (r *scriptRun) record(k string, deleted bool) {
+	if _, ok := r.written[k]; !ok {
+		r.order = append(r.order, k)
+	}
+	r.written[k] = deleted
+}
@@ -0,0 +1,20 @@
+package fsm
+
+import (
+	"nubedb/pkg/lua"
+	"strings"
+	"testing"
+)
+
+func TestRunLuaRecoversPanics(t *testing.T) {
+	script, errCompile := lua.Compile("return crash()")
+	if errCompile != nil {
+		t.Fatal(errCompile)
+	}
+	_, errRun := runLua(script, map[string]any{"crash": lua.Function(func(args ...any) (any, error) {
+		panic("boom")
+	})})
+	if errRun == nil || !strings.Contains(errRun.Error(), "boom") {
+		t.Errorf("got error %v, want the panic as an error", errRun)
+	}
+}
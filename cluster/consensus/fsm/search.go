@@ -0,0 +1,263 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// searchDefPrefix is the prefix under which the buckets with full-text search enabled are stored.
+	searchDefPrefix = InternalPrefix + "fts/def/"
+	// searchEntryPrefix is the prefix under which the inverted index is stored.
+	//
+	// Each entry has the form: searchEntryPrefix + bucket + sep + token + sep + key
+	searchEntryPrefix = InternalPrefix + "fts/entry/"
+	// minTokenLen is the minimum length a word needs to have to be indexed.
+	minTokenLen = 2
+)
+
+// ErrSearchNotEnabled is returned when searching on a bucket which doesn't have full-text search enabled.
+var ErrSearchNotEnabled = errcode.New(errcode.NotEnabled, "full-text search is not enabled for the bucket")
+
+// enableSearch is a DatabaseFSM's method which enables full-text search for a bucket and indexes its existing keys.
+func (dbFSM DatabaseFSM) enableSearch(bucket string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	errSet := txn.Set([]byte(searchDefPrefix+bucket), []byte("true"))
+	if errSet != nil {
+		return errSet
+	}
+	indirect := indirectKeys(txn)
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	// The existing keys are indexed in batches, since they may not fit in a transaction.
+	opts := engine.IterOptions{}
+	if bucket != "" {
+		opts.Prefix = []byte(bucket + bucketSep)
+	}
+	return dbFSM.inBatches(opts, func(txn engine.Txn, keys [][]byte, values [][]byte) error {
+		batch, errValues := userValues(txn, keys, values, indirect)
+		if errValues != nil {
+			return errValues
+		}
+		for k, v := range batch {
+			if BucketOf(k) != bucket {
+				continue
+			}
+			errEntry := setSearchEntries(txn, bucket, k, v)
+			if errEntry != nil {
+				return errEntry
+			}
+		}
+		return nil
+	})
+}
+
+// disableSearch is a DatabaseFSM's method which disables full-text search for a bucket and removes its index.
+func (dbFSM DatabaseFSM) disableSearch(bucket string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	if !isSearchEnabled(txn, bucket) {
+		return ErrSearchNotEnabled
+	}
+	errDelete := txn.Delete([]byte(searchDefPrefix + bucket))
+	if errDelete != nil {
+		return errDelete
+	}
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+
+	return dbFSM.deletePrefix(searchEntryPrefix + bucket + indexSep)
+}
+
+// Search is a DatabaseFSM's method which returns the key-values of a bucket from the LOCAL NODE
+// that contain all the words of the query.
+func (dbFSM DatabaseFSM) Search(bucket string, query string) (map[string]any, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	if !isSearchEnabled(txn, bucket) {
+		return nil, ErrSearchNotEnabled
+	}
+
+	result := make(map[string]any)
+	tokens := tokenize(query)
+	if len(tokens) <= 0 {
+		return result, nil
+	}
+
+	// Intersects the keys of every token
+	var matches map[string]bool
+	for _, token := range tokens {
+		prefix := []byte(searchEntryPrefix + bucket + indexSep + token + indexSep)
+		current := make(map[string]bool)
+		for _, entry := range getPrefixKeys(txn, prefix) {
+			k := string(bytes.TrimPrefix(entry, prefix))
+			if matches == nil || matches[k] {
+				current[k] = true
+			}
+		}
+		matches = current
+	}
+
+	for k := range matches {
+		if !isVisible(txn, k) {
+			continue
+		}
+		stored, errValue := getFullValue(txn, k)
+		if errValue != nil {
+			return nil, errorskit.Wrap(errValue, fmt.Sprintf("couldn't get indexed key '%s'", k))
+		}
+		var v any
+		errUnmarshal := json.Unmarshal(stored, &v)
+		if errUnmarshal != nil {
+			return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal search results from DB")
+		}
+		result[k] = v
+	}
+
+	return result, nil
+}
+
+// updateSearchIndex keeps the full-text search entries of a key in sync with its value inside the given transaction.
+func updateSearchIndex(txn engine.Txn, k string, oldValue []byte, newValue []byte) error {
+	bucket := BucketOf(k)
+	if !isSearchEnabled(txn, bucket) {
+		return nil
+	}
+
+	for _, token := range tokenizeValue(oldValue) {
+		errDelete := txn.Delete(searchEntryKey(bucket, token, k))
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+
+	return setSearchEntries(txn, bucket, k, newValue)
+}
+
+func setSearchEntries(txn engine.Txn, bucket string, k string, value []byte) error {
+	entryValue, errMarshal := json.Marshal(k)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	for _, token := range tokenizeValue(value) {
+		errSet := txn.Set(searchEntryKey(bucket, token, k), entryValue)
+		if errSet != nil {
+			return errSet
+		}
+	}
+	return nil
+}
+
+// IsSearchEnabled is a DatabaseFSM's method which returns whether the search is enabled for a bucket
+// in the LOCAL NODE.
+func (dbFSM DatabaseFSM) IsSearchEnabled(bucket string) bool {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return isSearchEnabled(txn, bucket)
+}
+
+func isSearchEnabled(txn engine.Txn, bucket string) bool {
+	_, errGet := txn.Get([]byte(searchDefPrefix + bucket))
+	return errGet == nil
+}
+
+func searchEntryKey(bucket string, token string, k string) []byte {
+	return []byte(searchEntryPrefix + bucket + indexSep + token + indexSep + k)
+}
+
+// getBucketValues returns a copy of all the user key-values of a bucket.
+func getBucketValues(txn engine.Txn, bucket string) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	opts := engine.IterOptions{}
+	if bucket != "" {
+		opts.Prefix = []byte(bucket + bucketSep)
+	}
+	errIterate := txn.Iterate(opts, func(key []byte, value []byte) error {
+		k := string(key)
+		if !IsInternalKey(key) && BucketOf(k) == bucket {
+			values[k] = value
+		}
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errIterate
+	}
+	for k := range indirectKeys(txn) {
+		if BucketOf(k) != bucket {
+			continue
+		}
+		full, errFull := getFullValue(txn, k)
+		if errFull != nil {
+			return nil, errFull
+		}
+		values[k] = full
+	}
+
+	return values, nil
+}
+
+// tokenizeValue returns the unique tokens of all the strings contained in a JSON value.
+func tokenizeValue(value []byte) []string {
+	if value == nil {
+		return nil
+	}
+	var v any
+	if json.Unmarshal(value, &v) != nil {
+		return nil
+	}
+
+	var sb strings.Builder
+	collectStrings(v, &sb)
+	return tokenize(sb.String())
+}
+
+// collectStrings writes every string contained in a JSON value into the builder.
+func collectStrings(v any, sb *strings.Builder) {
+	switch val := v.(type) {
+	case string:
+		sb.WriteString(val)
+		sb.WriteString(" ")
+	case []any:
+		for _, e := range val {
+			collectStrings(e, sb)
+		}
+	case map[string]any:
+		for _, e := range val {
+			collectStrings(e, sb)
+		}
+	}
+}
+
+// tokenize splits a text into its unique lowercase words, sorted.
+func tokenize(text string) []string {
+	seen := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) < minTokenLen || seen[w] {
+			continue
+		}
+		seen[w] = true
+		tokens = append(tokens, w)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
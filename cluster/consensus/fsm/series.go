@@ -0,0 +1,231 @@
+package fsm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"io"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// seriesPrefix is the prefix under which the blocks of the time series are stored.
+	//
+	// Each block has the form: seriesPrefix + name + "/" + start of the block in unix seconds, zero padded.
+	seriesPrefix = InternalPrefix + "series/"
+	// seriesBlockDuration is the time span of the points stored in each block.
+	//
+	// It can't be configured, since every node must split the points in the same blocks.
+	seriesBlockDuration = time.Hour
+)
+
+var (
+	// ErrInvalidSeries is returned when appending points which can't be stored in a time series.
+	ErrInvalidSeries = errcode.New(errcode.InvalidArgument, "invalid time series")
+	// ErrSeriesNotFound is returned when reading or deleting a time series without points.
+	ErrSeriesNotFound = errcode.New(errcode.NotFound, "time series doesn't exist")
+)
+
+// SeriesPoint is a timestamped value of a time series.
+type SeriesPoint struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// appendSeries is a DatabaseFSM's method which adds points to a time series.
+//
+// The points are stored in blocks of seriesBlockDuration, each compressed as a whole.
+// A point with the same time as a stored one replaces it, so retried appends don't duplicate points.
+func (dbFSM DatabaseFSM) appendSeries(name string, value any) error {
+	errName := validateSeriesName(name)
+	if errName != nil {
+		return errName
+	}
+	var points []SeriesPoint
+	errDecode := decodeJSON(value, &points)
+	if errDecode != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSeries, errDecode)
+	}
+	if len(points) <= 0 {
+		return fmt.Errorf("%w: no points to append", ErrInvalidSeries)
+	}
+
+	blocks := make(map[int64][]SeriesPoint)
+	for _, point := range points {
+		if point.Time.Unix() < 0 {
+			return fmt.Errorf("%w: points before 1970 can't be stored", ErrInvalidSeries)
+		}
+		start := point.Time.Truncate(seriesBlockDuration).Unix()
+		blocks[start] = append(blocks[start], point)
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	for start, added := range blocks {
+		k := seriesBlockKey(name, start)
+		stored, errGet := getSeriesBlock(txn, k)
+		if errGet != nil && !errors.Is(errGet, engine.ErrKeyNotFound) {
+			return errGet
+		}
+		block, errCompress := compressSeriesBlock(mergeSeriesPoints(stored, added))
+		if errCompress != nil {
+			return errCompress
+		}
+		errSet := txn.Set([]byte(k), block)
+		if errSet != nil {
+			return errSet
+		}
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// deleteSeries is a DatabaseFSM's method which removes all the points of a time series.
+func (dbFSM DatabaseFSM) deleteSeries(name string) error {
+	errName := validateSeriesName(name)
+	if errName != nil {
+		return errName
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	keys := getPrefixKeys(txn, []byte(seriesPrefix+name+bucketSep))
+	if len(keys) <= 0 {
+		return ErrSeriesNotFound
+	}
+	for _, k := range keys {
+		errDelete := txn.Delete(k)
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// GetSeries is a DatabaseFSM's method which returns the points of a time series between from and to, both included,
+// from the LOCAL NODE, sorted by time.
+//
+// Only the blocks overlapping the range are decompressed.
+func (dbFSM DatabaseFSM) GetSeries(name string, from time.Time, to time.Time) ([]SeriesPoint, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	prefix := seriesPrefix + name + bucketSep
+	keys := getPrefixKeys(txn, []byte(prefix))
+	if len(keys) <= 0 {
+		return nil, ErrSeriesNotFound
+	}
+
+	points := make([]SeriesPoint, 0)
+	for _, k := range keys {
+		start, errParse := strconv.ParseInt(string(k[len(prefix):]), 10, 64)
+		if errParse != nil {
+			return nil, errorskit.Wrap(errParse, "couldn't parse series block: "+string(k))
+		}
+		blockStart := time.Unix(start, 0)
+		if blockStart.After(to) || !blockStart.Add(seriesBlockDuration).After(from) {
+			continue
+		}
+
+		block, errGet := getSeriesBlock(txn, string(k))
+		if errGet != nil {
+			return nil, errGet
+		}
+		for _, point := range block {
+			if !point.Time.Before(from) && !point.Time.After(to) {
+				points = append(points, point)
+			}
+		}
+	}
+	return points, nil
+}
+
+// mergeSeriesPoints returns the points of a block with the added ones, sorted by time,
+// the added points replace the stored ones with the same time.
+func mergeSeriesPoints(stored []SeriesPoint, added []SeriesPoint) []SeriesPoint {
+	byTime := make(map[int64]SeriesPoint, len(stored)+len(added))
+	for _, point := range stored {
+		byTime[point.Time.UnixNano()] = point
+	}
+	for _, point := range added {
+		byTime[point.Time.UnixNano()] = SeriesPoint{Time: point.Time.UTC(), Value: point.Value}
+	}
+
+	merged := make([]SeriesPoint, 0, len(byTime))
+	for _, point := range byTime {
+		merged = append(merged, point)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+	return merged
+}
+
+func getSeriesBlock(txn engine.Txn, k string) ([]SeriesPoint, error) {
+	stored, errGet := getTxnValue(txn, k)
+	if errGet != nil {
+		return nil, errGet
+	}
+	reader, errReader := gzip.NewReader(bytes.NewReader(stored))
+	if errReader != nil {
+		return nil, errorskit.Wrap(errReader, "couldn't decompress series block")
+	}
+	b, errRead := io.ReadAll(reader)
+	if errRead != nil {
+		return nil, errorskit.Wrap(errRead, "couldn't decompress series block")
+	}
+	var points []SeriesPoint
+	errUnmarshal := json.Unmarshal(b, &points)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal series block")
+	}
+	return points, nil
+}
+
+func compressSeriesBlock(points []SeriesPoint) ([]byte, error) {
+	b, errMarshal := json.Marshal(points)
+	if errMarshal != nil {
+		return nil, errorskit.Wrap(errMarshal, "couldn't marshal series block")
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, errWrite := writer.Write(b)
+	if errWrite != nil {
+		return nil, errWrite
+	}
+	errClose := writer.Close()
+	if errClose != nil {
+		return nil, errClose
+	}
+	return buf.Bytes(), nil
+}
+
+func validateSeriesName(name string) error {
+	if name == "" || strings.Contains(name, bucketSep) {
+		return fmt.Errorf("%w: the name can't be empty or contain '%s'", ErrInvalidSeries, bucketSep)
+	}
+	return nil
+}
+
+func seriesBlockKey(name string, start int64) string {
+	return fmt.Sprintf("%s%s%s%020d", seriesPrefix, name, bucketSep, start)
+}
@@ -1,33 +1,26 @@
 package fsm
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
 )
 
-// set is a DatabaseFSM's method which adds a key-value pair to the database.
-func (dbFSM DatabaseFSM) set(k string, value any) error {
-	dbValue, errMarshal := json.Marshal(value)
-	if errMarshal != nil {
-		return errorskit.Wrap(errMarshal, "couldn't marshal value on set")
+// storeValue sets the value of a key, encoded as JSON, updating the usage of its tenant and the indexes.
+func storeValue(txn engine.Txn, k string, dbValue []byte) error {
+	oldValue, errGet := getFullValue(txn, k)
+	if errGet != nil && !errors.Is(errGet, engine.ErrKeyNotFound) {
+		return errGet
 	}
-
-	if dbValue == nil || len(dbValue) <= 0 {
-		return errors.New("value was empty")
-	}
-
-	txn := dbFSM.db.NewTransaction(true)
-	defer txn.Discard()
-	errSet := txn.Set([]byte(k), dbValue)
-	if errSet != nil {
-		return errSet
+	errQuota := updateTenantUsage(txn, k, oldValue, dbValue)
+	if errQuota != nil {
+		return errQuota
 	}
 
-	errCommit := txn.Commit()
-	if errCommit != nil {
-		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	errIndexes := updateIndexes(txn, k, oldValue, dbValue)
+	if errIndexes != nil {
+		return errorskit.Wrap(errIndexes, "couldn't update indexes on set")
 	}
 
-	return nil
+	return setStored(txn, k, dbValue)
 }
@@ -0,0 +1,69 @@
+package fsm
+
+import (
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"strings"
+)
+
+// settingsPrefix is the prefix under which the cluster-wide settings are stored, by their names.
+const settingsPrefix = InternalPrefix + "settings/"
+
+// ErrSettingNotFound is returned when deleting a setting which isn't set.
+var ErrSettingNotFound = errcode.New(errcode.NotFound, "setting not found")
+
+// setSetting is a DatabaseFSM's method which sets a cluster-wide setting, the value is checked before it's applied.
+func (dbFSM DatabaseFSM) setSetting(name string, value any) error {
+	v, isStr := value.(string)
+	if !isStr {
+		return errors.New("the value of a setting must be a string")
+	}
+	if name == "" {
+		return errors.New("the setting's name can't be empty")
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set([]byte(settingsPrefix+name), []byte(v))
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// deleteSetting is a DatabaseFSM's method which unsets a cluster-wide setting, so the nodes use their configured value.
+func (dbFSM DatabaseFSM) deleteSetting(name string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	k := []byte(settingsPrefix + name)
+	_, errMeta := txn.Meta(k)
+	if errors.Is(errMeta, engine.ErrKeyNotFound) {
+		return ErrSettingNotFound
+	}
+	if errMeta != nil {
+		return errMeta
+	}
+	errDelete := txn.Delete(k)
+	if errDelete != nil {
+		return errDelete
+	}
+	return txn.Commit()
+}
+
+// GetSettings is a DatabaseFSM's method which returns the cluster-wide settings set, by their names.
+func (dbFSM DatabaseFSM) GetSettings() (map[string]string, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	settings := make(map[string]string)
+	errIterate := txn.Iterate(engine.IterOptions{Prefix: []byte(settingsPrefix)}, func(k []byte, value []byte) error {
+		settings[strings.TrimPrefix(string(k), settingsPrefix)] = string(value)
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errorskit.Wrap(errIterate, "couldn't iterate settings")
+	}
+	return settings, nil
+}
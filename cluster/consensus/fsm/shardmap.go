@@ -0,0 +1,210 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/shard"
+	"strconv"
+)
+
+const (
+	// shardMapKey is the key under which shard 0 stores the shard map.
+	shardMapKey = InternalPrefix + "shard/map"
+	// disownedSlotPrefix is the prefix under which a shard stores the slots it no longer owns.
+	disownedSlotPrefix = InternalPrefix + "shard/disowned/"
+)
+
+var (
+	// ErrSlotMoved is returned when writing a key whose slot was moved, or is being moved, to another shard.
+	ErrSlotMoved = errcode.New(errcode.SlotMoved, "the key's slot was moved to another shard, retry the write")
+	// ErrShardMapConflict is returned when the shard map was changed by another operation in the meantime.
+	ErrShardMapConflict = errcode.New(errcode.Conflict, "the shard map was changed by another operation")
+)
+
+// setShardMap is a DatabaseFSM's method which replaces the shard map,
+// if the new one is based on the current version.
+func (dbFSM DatabaseFSM) setShardMap(value any) error {
+	var m shard.Map
+	errDecode := decodeJSON(value, &m)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode shard map")
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	current, errCurrent := getShardMap(txn)
+	if errCurrent != nil {
+		return errCurrent
+	}
+	if m.Version != current.Version+1 {
+		return ErrShardMapConflict
+	}
+
+	b, errMarshal := json.Marshal(m)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal shard map")
+	}
+	errSet := txn.Set([]byte(shardMapKey), b)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// ShardMap is a DatabaseFSM's method which returns the shard map from the LOCAL NODE,
+// an empty map if the slots were never moved.
+func (dbFSM DatabaseFSM) ShardMap() (shard.Map, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	return getShardMap(txn)
+}
+
+func getShardMap(txn engine.Txn) (shard.Map, error) {
+	var m shard.Map
+	b, errGet := getTxnValue(txn, shardMapKey)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return m, nil
+	}
+	if errGet != nil {
+		return m, errGet
+	}
+	errUnmarshal := json.Unmarshal(b, &m)
+	if errUnmarshal != nil {
+		return m, errorskit.Wrap(errUnmarshal, "couldn't unmarshal shard map")
+	}
+	return m, nil
+}
+
+// setSlotsOwned is a DatabaseFSM's method which sets whether the shard owns some slots.
+//
+// The writes to the keys of the slots a shard doesn't own are refused, so once a slot starts moving,
+// its keys can be copied to the new shard without missing a write.
+func (dbFSM DatabaseFSM) setSlotsOwned(value any, owned bool) error {
+	slots, errSlots := decodeSlots(value)
+	if errSlots != nil {
+		return errSlots
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	for _, slot := range slots {
+		k := []byte(disownedSlotPrefix + strconv.Itoa(slot))
+		var errSet error
+		if owned {
+			errSet = txn.Delete(k)
+		} else {
+			errSet = txn.Set(k, []byte{1})
+		}
+		if errSet != nil {
+			return errSet
+		}
+	}
+	return txn.Commit()
+}
+
+// purgeSlots is a DatabaseFSM's method which deletes the keys of slots the shard no longer owns.
+func (dbFSM DatabaseFSM) purgeSlots(value any) error {
+	slots, errSlots := decodeSlots(value)
+	if errSlots != nil {
+		return errSlots
+	}
+	set := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if dbFSM.ownsSlot(slot) {
+			return fmt.Errorf("slot %v can't be purged, it's still owned by this shard", slot)
+		}
+		set[slot] = true
+	}
+
+	var keys []string
+	errIterate := dbFSM.IterateSlots(set, func(k string, _ []byte) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if errIterate != nil {
+		return errIterate
+	}
+	for _, k := range keys {
+		errDelete := dbFSM.delete(k)
+		if errDelete != nil && !errors.Is(errDelete, engine.ErrKeyNotFound) {
+			return errDelete
+		}
+	}
+	// The purged keys would be false positives of the bloom filter forever.
+	return dbFSM.rebuildKeyFilter()
+}
+
+// IterateSlots is a DatabaseFSM's method which calls fn with the key-values of some slots from the LOCAL NODE.
+//
+// The values split in chunks or stored in blobs are read, after the rest of the keys.
+func (dbFSM DatabaseFSM) IterateSlots(slots map[int]bool, fn func(k string, value []byte) error) error {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	indirect := indirectKeys(txn)
+	errIterate := txn.Iterate(engine.IterOptions{}, func(key []byte, value []byte) error {
+		k := string(key)
+		if IsInternalKey(key) || indirect[k] || !slots[shard.SlotOf(k)] {
+			return nil
+		}
+		return fn(k, value)
+	})
+	if errIterate != nil {
+		return errIterate
+	}
+
+	for k := range indirect {
+		if !slots[shard.SlotOf(k)] {
+			continue
+		}
+		value, errJoin := getFullValue(txn, k)
+		if errJoin != nil {
+			return errJoin
+		}
+		errFn := fn(k, value)
+		if errFn != nil {
+			return errFn
+		}
+	}
+	return nil
+}
+
+// OwnsSlot is a DatabaseFSM's method which returns whether the LOCAL NODE accepts writes to the keys of a slot.
+func (dbFSM DatabaseFSM) OwnsSlot(slot int) bool {
+	return dbFSM.ownsSlot(slot)
+}
+
+// ownsSlot returns whether the shard accepts writes to the keys of a slot.
+func (dbFSM DatabaseFSM) ownsSlot(slot int) bool {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	_, errMeta := txn.Meta([]byte(disownedSlotPrefix + strconv.Itoa(slot)))
+	return errors.Is(errMeta, engine.ErrKeyNotFound)
+}
+
+func decodeSlots(value any) ([]int, error) {
+	var slots []int
+	errDecode := decodeJSON(value, &slots)
+	if errDecode != nil {
+		return nil, errorskit.Wrap(errDecode, "couldn't decode slots")
+	}
+	for _, slot := range slots {
+		if slot < 0 || slot >= shard.Slots {
+			return nil, fmt.Errorf("slot out of range: %v", slot)
+		}
+	}
+	return slots, nil
+}
+
+// decodeJSON decodes a payload's value into v, through JSON.
+func decodeJSON(value any, v any) error {
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	return json.Unmarshal(b, v)
+}
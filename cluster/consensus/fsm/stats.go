@@ -0,0 +1,37 @@
+package fsm
+
+import (
+	"nubedb/cluster/consensus/engine"
+)
+
+// StorageStats holds the disk usage of the FSM's database.
+type StorageStats struct {
+	LSMBytes  int64            `json:"lsmBytes"`
+	VlogBytes int64            `json:"vlogBytes"`
+	Buckets   map[string]int64 `json:"buckets"`
+	// Blobs is the number of blobs of the buckets in blob mode, and BlobKeys the number of keys pointing to them.
+	Blobs    int64 `json:"blobs"`
+	BlobKeys int64 `json:"blobKeys"`
+}
+
+// GetStorageStats is a DatabaseFSM's method which returns the size of the database of the LOCAL NODE,
+// the number of keys of each bucket, and the number of blobs.
+//
+// The sizes are the ones the storage engine last calculated, badger does it periodically.
+func (dbFSM DatabaseFSM) GetStorageStats() StorageStats {
+	stats := StorageStats{Buckets: make(map[string]int64)}
+	stats.LSMBytes, stats.VlogBytes = dbFSM.db.Size()
+
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	_ = txn.Iterate(engine.IterOptions{KeysOnly: true}, func(key []byte, _ []byte) error {
+		if !IsInternalKey(key) {
+			stats.Buckets[BucketOf(string(key))]++
+		}
+		return nil
+	})
+	stats.Blobs = int64(len(getPrefixKeys(txn, []byte(blobPrefix))))
+	stats.BlobKeys = int64(len(getPrefixKeys(txn, []byte(blobRefPrefix))))
+	return stats
+}
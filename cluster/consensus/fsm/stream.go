@@ -0,0 +1,108 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"io"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/internal/metrics"
+	"time"
+)
+
+// OpenValue is a DatabaseFSM's method which returns a reader of the value of a key in its content type,
+// and the content type, empty if it wasn't declared.
+//
+// The chunks of a value split in chunks are read one at a time as the reader is read, so the value isn't held in memory.
+// Reading fails with ErrChunksMissing if the value is overwritten meanwhile.
+func (dbFSM DatabaseFSM) OpenValue(k string) (io.Reader, string, error) {
+	defer metrics.Track(metrics.ComponentBadgerGet, "GET", k, time.Now())
+	metrics.RecordRead(k)
+	if !dbFSM.mayExist(k) {
+		return nil, "", engine.ErrKeyNotFound
+	}
+
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+	if !isVisible(txn, k) {
+		return nil, "", engine.ErrKeyNotFound
+	}
+	if _, errGet := getTxnValue(txn, k); errGet != nil {
+		return nil, "", errGet
+	}
+	contentType := getContentType(txn, k)
+
+	manifest, errManifest := getTxnValue(txn, chunkedPrefix+k)
+	if errors.Is(errManifest, engine.ErrKeyNotFound) {
+		stored, errGet := getFullValue(txn, k)
+		if errGet != nil {
+			return nil, "", errGet
+		}
+		if IsJSON(contentType) {
+			return bytes.NewReader(stored), contentType, nil
+		}
+		value, errDecode := decodeStored(contentType, stored)
+		if errDecode != nil {
+			return nil, "", errDecode
+		}
+		return bytes.NewReader(value), contentType, nil
+	}
+	if errManifest != nil {
+		return nil, "", errManifest
+	}
+
+	var m ChunkManifest
+	errUnmarshal := json.Unmarshal(manifest, &m)
+	if errUnmarshal != nil {
+		return nil, "", errorskit.Wrap(errUnmarshal, "couldn't decode chunk manifest")
+	}
+	var r io.Reader = &chunkReader{dbFSM: dbFSM, k: k, m: m}
+	if IsJSON(contentType) {
+		return r, contentType, nil
+	}
+
+	// The value is stored as the JSON string of its base64 encoding, which is decoded without its quotes.
+	quote := make([]byte, 1)
+	_, errQuote := io.ReadFull(r, quote)
+	if errQuote != nil {
+		return nil, "", errQuote
+	}
+	if quote[0] != '"' || m.Size < 2 {
+		return nil, "", fmt.Errorf("%w: a %s value must be stored as a base64 string", ErrInvalidValue, contentType)
+	}
+	return base64.NewDecoder(base64.StdEncoding, io.LimitReader(r, m.Size-2)), contentType, nil
+}
+
+// chunkReader reads the value of a key split in chunks, reading each chunk in its own transaction.
+type chunkReader struct {
+	dbFSM DatabaseFSM
+	k     string
+	m     ChunkManifest
+	seq   int
+	buf   []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.seq >= r.m.Chunks {
+			return 0, io.EOF
+		}
+		txn := r.dbFSM.db.NewTransaction(false)
+		data, errGet := getChunk(txn, r.k, r.m.ID, r.seq)
+		txn.Discard()
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
+			return 0, fmt.Errorf("%w: chunk %v of key '%s' wasn't found", ErrChunksMissing, r.seq, r.k)
+		}
+		if errGet != nil {
+			return 0, errorskit.Wrap(errGet, fmt.Sprintf("couldn't read chunk %v of key '%s'", r.seq, r.k))
+		}
+		r.buf = data
+		r.seq++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
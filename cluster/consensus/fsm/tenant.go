@@ -0,0 +1,358 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"strings"
+)
+
+const (
+	// tenantDefPrefix is the prefix under which tenant definitions are stored.
+	tenantDefPrefix = InternalPrefix + "tenant/def/"
+	// tenantBucketPrefix is the prefix under which the tenant owning each bucket is stored.
+	tenantBucketPrefix = InternalPrefix + "tenant/bucket/"
+	// tenantUsagePrefix is the prefix under which the storage used by each tenant is stored.
+	tenantUsagePrefix = InternalPrefix + "tenant/usage/"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a write would make a tenant exceed its quota.
+	ErrQuotaExceeded = errcode.New(errcode.QuotaExceeded, "tenant quota exceeded")
+	// ErrTenantNotFound is returned when a tenant doesn't exist.
+	ErrTenantNotFound = errcode.New(errcode.NotFound, "tenant not found")
+	// ErrBucketOwned is returned when a tenant is saved with a bucket which belongs to another tenant.
+	ErrBucketOwned = errcode.New(errcode.Conflict, "bucket already belongs to another tenant")
+)
+
+// Tenant groups buckets under storage quotas, so a cluster can be shared safely.
+//
+// A quota equal or lower than 0 is unlimited.
+type Tenant struct {
+	Name     string   `json:"name" validate:"required"`
+	Buckets  []string `json:"buckets" validate:"required"`
+	MaxBytes int64    `json:"maxBytes"`
+	MaxKeys  int64    `json:"maxKeys"`
+	// Shards is the number of shards the quotas are split evenly across, since each shard only counts the usage
+	// of the buckets it stores. It's set by the node when the tenant is saved, 0 doesn't split them.
+	Shards int `json:"shards,omitempty"`
+}
+
+// shardQuota returns the part of a quota enforced by each shard.
+func (t Tenant) shardQuota(quota int64) int64 {
+	if quota <= 0 || t.Shards <= 1 {
+		return quota
+	}
+	return (quota + int64(t.Shards) - 1) / int64(t.Shards)
+}
+
+// TenantUsage is the storage used by a tenant, the bytes include both the keys and the values.
+type TenantUsage struct {
+	Bytes int64 `json:"bytes"`
+	Keys  int64 `json:"keys"`
+}
+
+// TenantInfo is a tenant with its current usage.
+type TenantInfo struct {
+	Tenant
+	Usage TenantUsage `json:"usage"`
+}
+
+// setTenant is a DatabaseFSM's method which creates or updates a tenant, and recalculates its usage.
+func (dbFSM DatabaseFSM) setTenant(value any) error {
+	tenant, errTenant := decodeTenant(value)
+	if errTenant != nil {
+		return errTenant
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	for _, bucket := range tenant.Buckets {
+		owner, errOwner := getTxnValue(txn, tenantBucketPrefix+bucket)
+		if errOwner == nil && string(owner) != tenant.Name {
+			return fmt.Errorf("%w: bucket '%s' belongs to tenant '%s'", ErrBucketOwned, bucket, owner)
+		}
+		if errOwner != nil && !errors.Is(errOwner, engine.ErrKeyNotFound) {
+			return errOwner
+		}
+	}
+
+	// The buckets removed from the tenant are released.
+	errRelease := releaseTenantBuckets(txn, tenant.Name)
+	if errRelease != nil {
+		return errRelease
+	}
+
+	def, errMarshal := json.Marshal(tenant)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal tenant definition")
+	}
+	errSet := txn.Set([]byte(tenantDefPrefix+tenant.Name), def)
+	if errSet != nil {
+		return errSet
+	}
+
+	usage := TenantUsage{}
+	for _, bucket := range tenant.Buckets {
+		errBucket := txn.Set([]byte(tenantBucketPrefix+bucket), []byte(tenant.Name))
+		if errBucket != nil {
+			return errBucket
+		}
+		bucketUsage, errUsage := getBucketUsage(txn, bucket)
+		if errUsage != nil {
+			return errUsage
+		}
+		usage.Bytes += bucketUsage.Bytes
+		usage.Keys += bucketUsage.Keys
+	}
+
+	errUsage := setTenantUsage(txn, tenant.Name, usage)
+	if errUsage != nil {
+		return errUsage
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// deleteTenant is a DatabaseFSM's method which deletes a tenant, the data of its buckets is kept.
+func (dbFSM DatabaseFSM) deleteTenant(name string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	_, errGet := getTxnValue(txn, tenantDefPrefix+name)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return ErrTenantNotFound
+	}
+	if errGet != nil {
+		return errGet
+	}
+
+	errRelease := releaseTenantBuckets(txn, name)
+	if errRelease != nil {
+		return errRelease
+	}
+	for _, k := range []string{tenantDefPrefix + name, tenantUsagePrefix + name} {
+		errDelete := txn.Delete([]byte(k))
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+
+	errCommit := txn.Commit()
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// GetTenants is a DatabaseFSM's method which returns all the tenants with their usage from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetTenants() ([]TenantInfo, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	tenants := make([]TenantInfo, 0)
+	for _, k := range getPrefixKeys(txn, []byte(tenantDefPrefix)) {
+		tenant, errTenant := getTenant(txn, string(k[len(tenantDefPrefix):]))
+		if errTenant != nil {
+			return nil, errTenant
+		}
+		usage, errUsage := getTenantUsage(txn, tenant.Name)
+		if errUsage != nil {
+			return nil, errUsage
+		}
+		tenants = append(tenants, TenantInfo{Tenant: tenant, Usage: usage})
+	}
+	return tenants, nil
+}
+
+// updateTenantUsage updates the usage of the tenant owning the key's bucket, if there is one.
+//
+// It returns ErrQuotaExceeded if the write would make the tenant exceed its quota,
+// writes that reduce the usage are always allowed.
+func updateTenantUsage(txn engine.Txn, k string, oldValue []byte, newValue []byte) error {
+	return changeTenantUsage(txn, k, oldValue, newValue, true)
+}
+
+// changeTenantUsage updates the usage of the tenant owning the key's bucket, enforcing its quota if enforce is true.
+func changeTenantUsage(txn engine.Txn, k string, oldValue []byte, newValue []byte, enforce bool) error {
+	if IsInternalKey([]byte(k)) {
+		return nil
+	}
+	owner, errOwner := getTxnValue(txn, tenantBucketPrefix+BucketOf(k))
+	if errors.Is(errOwner, engine.ErrKeyNotFound) {
+		return nil
+	}
+	if errOwner != nil {
+		return errOwner
+	}
+	name := string(owner)
+
+	usage, errUsage := getTenantUsage(txn, name)
+	if errUsage != nil {
+		return errUsage
+	}
+	deltaBytes, deltaKeys := entrySize(k, newValue)-entrySize(k, oldValue), int64(0)
+	if oldValue == nil && newValue != nil {
+		deltaKeys = 1
+	}
+	if oldValue != nil && newValue == nil {
+		deltaKeys = -1
+	}
+	usage.Bytes += deltaBytes
+	usage.Keys += deltaKeys
+
+	if enforce {
+		tenant, errTenant := getTenant(txn, name)
+		if errTenant != nil {
+			return errTenant
+		}
+		maxBytes, maxKeys := tenant.shardQuota(tenant.MaxBytes), tenant.shardQuota(tenant.MaxKeys)
+		if deltaBytes > 0 && maxBytes > 0 && usage.Bytes > maxBytes {
+			return fmt.Errorf("%w: tenant '%s' would use %v bytes of the %v of its shard", ErrQuotaExceeded, name,
+				usage.Bytes, maxBytes)
+		}
+		if deltaKeys > 0 && maxKeys > 0 && usage.Keys > maxKeys {
+			return fmt.Errorf("%w: tenant '%s' would have %v keys of the %v of its shard", ErrQuotaExceeded, name,
+				usage.Keys, maxKeys)
+		}
+	}
+
+	return setTenantUsage(txn, name, usage)
+}
+
+// resetTenantUsages sets the usage of all the tenants to zero, so it can be recalculated.
+func resetTenantUsages(txn engine.Txn) error {
+	for _, k := range getPrefixKeys(txn, []byte(tenantDefPrefix)) {
+		errUsage := setTenantUsage(txn, string(k[len(tenantDefPrefix):]), TenantUsage{})
+		if errUsage != nil {
+			return errUsage
+		}
+	}
+	return nil
+}
+
+// releaseTenantBuckets removes the ownership of all the buckets of a tenant.
+func releaseTenantBuckets(txn engine.Txn, name string) error {
+	tenant, errTenant := getTenant(txn, name)
+	if errors.Is(errTenant, engine.ErrKeyNotFound) {
+		return nil
+	}
+	if errTenant != nil {
+		return errTenant
+	}
+	for _, bucket := range tenant.Buckets {
+		errDelete := txn.Delete([]byte(tenantBucketPrefix + bucket))
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+	return nil
+}
+
+// getBucketUsage returns the storage used by the keys of a bucket.
+func getBucketUsage(txn engine.Txn, bucket string) (TenantUsage, error) {
+	usage := TenantUsage{}
+	// The values are read, since the size in the key's metadata could include the engine's own overhead.
+	indirect := indirectKeys(txn)
+	errIterate := txn.Iterate(engine.IterOptions{Prefix: []byte(bucket + bucketSep)}, func(key []byte, value []byte) error {
+		if !indirect[string(key)] {
+			usage.Bytes += entrySize(string(key), value)
+		}
+		usage.Keys++
+		return nil
+	})
+	if errIterate != nil {
+		return usage, errIterate
+	}
+	// The values split in chunks or stored in blobs count as a whole.
+	for k := range indirect {
+		if BucketOf(k) != bucket {
+			continue
+		}
+		full, errFull := getFullValue(txn, k)
+		if errFull != nil {
+			return usage, errFull
+		}
+		usage.Bytes += entrySize(k, full)
+	}
+	return usage, nil
+}
+
+func getTenant(txn engine.Txn, name string) (Tenant, error) {
+	var tenant Tenant
+	def, errGet := getTxnValue(txn, tenantDefPrefix+name)
+	if errGet != nil {
+		return tenant, errGet
+	}
+	errUnmarshal := json.Unmarshal(def, &tenant)
+	if errUnmarshal != nil {
+		return tenant, errorskit.Wrap(errUnmarshal, "couldn't unmarshal tenant definition")
+	}
+	return tenant, nil
+}
+
+func getTenantUsage(txn engine.Txn, name string) (TenantUsage, error) {
+	var usage TenantUsage
+	stored, errGet := getTxnValue(txn, tenantUsagePrefix+name)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return usage, nil
+	}
+	if errGet != nil {
+		return usage, errGet
+	}
+	errUnmarshal := json.Unmarshal(stored, &usage)
+	if errUnmarshal != nil {
+		return usage, errorskit.Wrap(errUnmarshal, "couldn't unmarshal tenant usage")
+	}
+	return usage, nil
+}
+
+func setTenantUsage(txn engine.Txn, name string, usage TenantUsage) error {
+	b, errMarshal := json.Marshal(usage)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal tenant usage")
+	}
+	return txn.Set([]byte(tenantUsagePrefix+name), b)
+}
+
+// entrySize returns the bytes a key and its value use, 0 if there isn't a value.
+func entrySize(k string, value []byte) int64 {
+	if value == nil {
+		return 0
+	}
+	return int64(len(k) + len(value))
+}
+
+func decodeTenant(value any) (Tenant, error) {
+	var tenant Tenant
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return tenant, errorskit.Wrap(errMarshal, "couldn't marshal tenant")
+	}
+	errUnmarshal := json.Unmarshal(b, &tenant)
+	if errUnmarshal != nil {
+		return tenant, errorskit.Wrap(errUnmarshal, "couldn't unmarshal tenant")
+	}
+	if tenant.Name == "" || len(tenant.Buckets) == 0 {
+		return tenant, errors.New("tenant name and buckets are required")
+	}
+	seen := make(map[string]bool)
+	for _, bucket := range tenant.Buckets {
+		if bucket == "" {
+			return tenant, errors.New("the default bucket can't belong to a tenant")
+		}
+		if strings.Contains(bucket, bucketSep) || seen[bucket] {
+			return tenant, fmt.Errorf("invalid or repeated bucket: %s", bucket)
+		}
+		seen[bucket] = true
+	}
+	return tenant, nil
+}
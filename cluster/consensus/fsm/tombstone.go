@@ -0,0 +1,257 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"time"
+)
+
+// tombstonePrefix is the prefix under which the soft deleted values are retained.
+const tombstonePrefix = InternalPrefix + "tombstone/"
+
+var (
+	// ErrTombstoneNotFound is returned when undeleting a key which wasn't soft deleted, or whose tombstone was purged.
+	ErrTombstoneNotFound = errcode.New(errcode.NotFound, "key wasn't deleted or its retention expired")
+	// ErrKeyExists is returned when undeleting a key which was set again after being deleted.
+	ErrKeyExists = errcode.New(errcode.Conflict, "key exists, it can't be undeleted")
+)
+
+// Tombstone is a soft deleted value, retained until it's purged.
+type Tombstone struct {
+	Key         string          `json:"key"`
+	Value       json.RawMessage `json:"value"`
+	ContentType string          `json:"contentType,omitempty"`
+	// Chunked reports whether the value is the manifest of a value split in chunks, which are retained with it.
+	Chunked   bool      `json:"chunked,omitempty"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// softDelete is a DatabaseFSM's method which deletes a key, retaining its value in a tombstone so it can be undeleted.
+//
+// deletedAt comes from the payload instead of the local clock, so every node writes the same tombstone.
+func (dbFSM DatabaseFSM) softDelete(k string, deletedAt any) error {
+	at, errTime := decodeTime(deletedAt)
+	if errTime != nil {
+		return errTime
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	stored, errGet := getTxnValue(txn, k)
+	if errGet != nil {
+		return errGet
+	}
+	oldValue, errFull := getFullValue(txn, k)
+	if errFull != nil {
+		return errFull
+	}
+
+	errQuota := updateTenantUsage(txn, k, oldValue, nil)
+	if errQuota != nil {
+		return errQuota
+	}
+	errIndexes := updateIndexes(txn, k, oldValue, nil)
+	if errIndexes != nil {
+		return errorskit.Wrap(errIndexes, "couldn't update indexes on delete")
+	}
+
+	// The values stored in blobs are retained in the tombstone, so their blobs can be released.
+	chunked := isChunked(txn, k)
+	if !chunked {
+		stored = oldValue
+	}
+	tombstone, errMarshal := json.Marshal(Tombstone{
+		Key:         k,
+		Value:       stored,
+		ContentType: getContentType(txn, k),
+		Chunked:     chunked,
+		DeletedAt:   at,
+	})
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal tombstone")
+	}
+	errSet := txn.Set([]byte(tombstonePrefix+k), tombstone)
+	if errSet != nil {
+		return errSet
+	}
+
+	errDelete := txn.Delete([]byte(k))
+	if errDelete != nil {
+		return errDelete
+	}
+	errSchedule := setNotBefore(txn, k, nil)
+	if errSchedule != nil {
+		return errSchedule
+	}
+	errContentType := setContentType(txn, k, "")
+	if errContentType != nil {
+		return errContentType
+	}
+	// The tombstone is already set, so its chunks are retained.
+	errChunks := dropChunks(txn, k, "")
+	if errChunks != nil {
+		return errChunks
+	}
+	errBlob := releaseBlob(txn, k)
+	if errBlob != nil {
+		return errBlob
+	}
+
+	errCommit := dbFSM.commit(txn, nil)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// undelete is a DatabaseFSM's method which restores the value of a soft deleted key from its tombstone.
+func (dbFSM DatabaseFSM) undelete(k string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	tombstone, errTombstone := getTombstone(txn, k)
+	if errTombstone != nil {
+		return errTombstone
+	}
+
+	_, errGet := getTxnValue(txn, k)
+	if errGet == nil {
+		return ErrKeyExists
+	}
+	if !errors.Is(errGet, engine.ErrKeyNotFound) {
+		return errGet
+	}
+
+	value := []byte(tombstone.Value)
+	if tombstone.Chunked {
+		joined, errJoin := joinChunks(txn, k, tombstone.Value)
+		if errJoin != nil {
+			return errJoin
+		}
+		value = joined
+	}
+
+	errQuota := updateTenantUsage(txn, k, nil, value)
+	if errQuota != nil {
+		return errQuota
+	}
+	errIndexes := updateIndexes(txn, k, nil, value)
+	if errIndexes != nil {
+		return errorskit.Wrap(errIndexes, "couldn't update indexes on undelete")
+	}
+
+	var errSet error
+	if tombstone.Chunked {
+		errSet = txn.Set([]byte(k), tombstone.Value)
+	} else {
+		errSet = setStored(txn, k, tombstone.Value)
+	}
+	if errSet != nil {
+		return errSet
+	}
+	errContentType := setContentType(txn, k, tombstone.ContentType)
+	if errContentType != nil {
+		return errContentType
+	}
+	if tombstone.Chunked {
+		errMark := txn.Set([]byte(chunkedPrefix+k), tombstone.Value)
+		if errMark != nil {
+			return errMark
+		}
+	}
+	errDelete := txn.Delete([]byte(tombstonePrefix + k))
+	if errDelete != nil {
+		return errDelete
+	}
+
+	errCommit := dbFSM.commit(txn, nil)
+	if errCommit != nil {
+		return errorskit.Wrap(errCommit, "couldn't commit transaction")
+	}
+	return nil
+}
+
+// purgeTombstones is a DatabaseFSM's method which hard deletes the tombstones of the keys deleted before a cutoff time.
+//
+// They are purged in batches, so a large number of tombstones doesn't exceed the engines' transaction limits.
+func (dbFSM DatabaseFSM) purgeTombstones(cutoff any) error {
+	before, errTime := decodeTime(cutoff)
+	if errTime != nil {
+		return errTime
+	}
+
+	opts := engine.IterOptions{Prefix: []byte(tombstonePrefix)}
+	return dbFSM.inBatches(opts, func(txn engine.Txn, keys [][]byte, values [][]byte) error {
+		for i, k := range keys {
+			var tombstone Tombstone
+			errUnmarshal := json.Unmarshal(values[i], &tombstone)
+			if errUnmarshal != nil {
+				return errorskit.Wrap(errUnmarshal, "couldn't unmarshal tombstone")
+			}
+			if !tombstone.DeletedAt.Before(before) {
+				continue
+			}
+			errDelete := txn.Delete(k)
+			if errDelete != nil {
+				return errDelete
+			}
+			if tombstone.Chunked {
+				errChunks := deleteChunks(txn, tombstone.Key, tombstone.Value)
+				if errChunks != nil {
+					return errChunks
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// GetTombstones is a DatabaseFSM's method which returns the soft deleted keys from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetTombstones() ([]Tombstone, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	tombstones := make([]Tombstone, 0)
+	for _, k := range getPrefixKeys(txn, []byte(tombstonePrefix)) {
+		tombstone, errTombstone := getTombstone(txn, string(k[len(tombstonePrefix):]))
+		if errTombstone != nil {
+			return nil, errTombstone
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, nil
+}
+
+func getTombstone(txn engine.Txn, k string) (Tombstone, error) {
+	var tombstone Tombstone
+	stored, errGet := getTxnValue(txn, tombstonePrefix+k)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return tombstone, ErrTombstoneNotFound
+	}
+	if errGet != nil {
+		return tombstone, errGet
+	}
+	errUnmarshal := json.Unmarshal(stored, &tombstone)
+	if errUnmarshal != nil {
+		return tombstone, errorskit.Wrap(errUnmarshal, "couldn't unmarshal tombstone")
+	}
+	return tombstone, nil
+}
+
+// decodeTime decodes a time sent in a payload, as a RFC 3339 string.
+func decodeTime(value any) (time.Time, error) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("time must be a string, got: %T", value)
+	}
+	t, errParse := time.Parse(time.RFC3339Nano, s)
+	if errParse != nil {
+		return time.Time{}, errorskit.Wrap(errParse, "couldn't parse time")
+	}
+	return t, nil
+}
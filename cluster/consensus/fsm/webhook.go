@@ -0,0 +1,104 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+)
+
+const (
+	// webhookPrefix is the prefix under which the webhooks are stored.
+	webhookPrefix = InternalPrefix + "webhook/"
+	// WebhooksConsumer is the name of the consumer of the changes which delivers the webhooks.
+	WebhooksConsumer = "webhooks"
+)
+
+// ErrWebhookNotFound is returned when a webhook doesn't exist.
+var ErrWebhookNotFound = errcode.New(errcode.NotFound, "webhook not found")
+
+// Webhook is a URL which receives the changes of the keys starting with a prefix.
+//
+// The changes are signed with the webhook's secret, so the receiver can verify they were sent by the cluster.
+type Webhook struct {
+	Name   string `json:"name" validate:"required"`
+	Prefix string `json:"prefix"`
+	URL    string `json:"url" validate:"required,url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// setWebhook is a DatabaseFSM's method which creates or updates a webhook.
+func (dbFSM DatabaseFSM) setWebhook(value any) error {
+	var hook Webhook
+	errDecode := decodeJSON(value, &hook)
+	if errDecode != nil {
+		return errorskit.Wrap(errDecode, "couldn't decode webhook")
+	}
+	if hook.Name == "" || hook.URL == "" {
+		return errors.New("the webhook's name and url can't be empty")
+	}
+
+	b, errMarshal := json.Marshal(hook)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal webhook")
+	}
+
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+	errSet := txn.Set([]byte(webhookPrefix+hook.Name), b)
+	if errSet != nil {
+		return errSet
+	}
+	return txn.Commit()
+}
+
+// deleteWebhook is a DatabaseFSM's method which deletes a webhook.
+//
+// Deleting the last webhook also deletes the checkpoint of its consumer, so the changes stop being recorded for it.
+func (dbFSM DatabaseFSM) deleteWebhook(name string) error {
+	txn := dbFSM.db.NewTransaction(true)
+	defer txn.Discard()
+
+	_, errGet := getTxnValue(txn, webhookPrefix+name)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return ErrWebhookNotFound
+	}
+	if errGet != nil {
+		return errGet
+	}
+	errDelete := txn.Delete([]byte(webhookPrefix + name))
+	if errDelete != nil {
+		return errDelete
+	}
+
+	if len(getPrefixKeys(txn, []byte(webhookPrefix))) <= 0 {
+		errCheckpoint := txn.Delete([]byte(checkpointPrefix + WebhooksConsumer))
+		if errCheckpoint != nil {
+			return errCheckpoint
+		}
+	}
+
+	return txn.Commit()
+}
+
+// GetWebhooks is a DatabaseFSM's method which returns all the webhooks, with their secrets, from the LOCAL NODE.
+func (dbFSM DatabaseFSM) GetWebhooks() ([]Webhook, error) {
+	txn := dbFSM.db.NewTransaction(false)
+	defer txn.Discard()
+
+	hooks := make([]Webhook, 0)
+	errIterate := txn.Iterate(engine.IterOptions{Prefix: []byte(webhookPrefix)}, func(_ []byte, value []byte) error {
+		var hook Webhook
+		errUnmarshal := json.Unmarshal(value, &hook)
+		if errUnmarshal != nil {
+			return errorskit.Wrap(errUnmarshal, "couldn't unmarshal webhook")
+		}
+		hooks = append(hooks, hook)
+		return nil
+	})
+	if errIterate != nil {
+		return nil, errIterate
+	}
+	return hooks, nil
+}
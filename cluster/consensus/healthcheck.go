@@ -14,7 +14,7 @@ func (n *Node) IsHealthy() bool {
 	consensusServers := n.Consensus.GetConfiguration().Configuration().Servers
 	stats := n.Consensus.Stats()
 
-	if len(consensusServers) <= 1 {
+	if len(consensusServers) <= 1 && !n.singleNode {
 		n.logger.Warn(prefixErr + "only one server in configuration")
 		return false
 	}
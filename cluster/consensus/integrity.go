@@ -0,0 +1,113 @@
+package consensus
+
+import (
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"github.com/narvikd/filekit"
+	"go.etcd.io/bbolt"
+	"log"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/internal/config"
+	"os"
+	"time"
+)
+
+// checkStores verifies the FSM's and the consensus' stores of a node open cleanly.
+//
+// If verifyChecksums is true, the checksums of all the FSM's data are also verified, which is slow for big datasets.
+func checkStores(
+	paths Paths, storage config.StorageCfg, consensusCfg config.ConsensusCfg, enc config.EncryptionCfg, verifyChecksums bool,
+) error {
+	errLogs := checkLogStore(paths.LogStore(consensusCfg.LogStore), consensusCfg.LogStore, enc)
+	if errLogs != nil {
+		return errLogs
+	}
+
+	storageDir := paths.Storage
+	switch storage.Engine {
+	case engine.Badger:
+		db, errOpen := OpenBadger(storageDir, enc)
+		if errOpen != nil {
+			return errOpen
+		}
+		defer db.Close()
+		if verifyChecksums {
+			errVerify := db.VerifyChecksum()
+			if errVerify != nil {
+				return errorskit.Wrap(errVerify, "badgerDB checksum verification failed")
+			}
+		}
+	case engine.Pebble:
+		// Pebble verifies the checksums of the blocks it reads, opening it replays and checks its WAL.
+		db, errOpen := OpenPebble(storageDir, enc)
+		if errOpen != nil {
+			return errOpen
+		}
+		defer db.Close()
+	case engine.SQLite:
+		db, errOpen := OpenSQLite(storageDir, enc)
+		if errOpen != nil {
+			return errOpen
+		}
+		defer db.Close()
+	}
+	return nil
+}
+
+// checkLogStore verifies the consistency of the consensus' store, if it exists.
+//
+// bolt can panic when opening a corrupted file, those panics are returned as errors.
+// The ones of the goroutine tx.Check runs the check in can't be recovered, and crash the node.
+func checkLogStore(dbPath string, kind string, enc config.EncryptionCfg) (err error) {
+	if !filekit.FileExist(dbPath) {
+		return nil
+	}
+	if kind == LogStoreBadger {
+		store, errOpen := openBadgerLogStore(dbPath, enc, false)
+		if errOpen != nil {
+			return errOpen
+		}
+		return store.Close()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("consensus db is corrupted: %v", r)
+		}
+	}()
+
+	db, errOpen := bbolt.Open(dbPath, 0o600, &bbolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if errOpen != nil {
+		return errorskit.Wrap(errOpen, "couldn't open consensus db")
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		// The channel is drained, otherwise the check's goroutine would block sending the next error.
+		var errFirst error
+		for errCheck := range tx.Check() {
+			if errFirst == nil {
+				errFirst = errorskit.Wrap(errCheck, "consensus db is corrupted")
+			}
+		}
+		return errFirst
+	})
+}
+
+// quarantine moves the node's dirs aside, so the node starts without data and rejoins the cluster as a fresh node.
+//
+// The quarantined data is kept for inspection, it must be deleted manually.
+func quarantine(paths Paths) error {
+	now := time.Now().Unix()
+	for _, dir := range paths.Roots() {
+		if !filekit.FileExist(dir) {
+			continue
+		}
+		quarantineDir := fmt.Sprintf("%s.corrupted-%v", dir, now)
+		errRename := os.Rename(dir, quarantineDir)
+		if errRename != nil {
+			return errorskit.Wrap(errRename, "couldn't quarantine corrupted data")
+		}
+		log.Printf("[consensus] corrupted data quarantined in '%s'\n", quarantineDir)
+	}
+	return nil
+}
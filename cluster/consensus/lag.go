@@ -0,0 +1,79 @@
+package consensus
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/metrics"
+	"strconv"
+	"time"
+)
+
+// ErrApplyTimeout is returned when the FSM didn't apply an index within the timeout.
+var ErrApplyTimeout = errcode.New(errcode.DeadlineExceeded, "the node didn't apply the requested index in time")
+
+// waitAppliedInterval is how often WaitApplied checks the applied index.
+const waitAppliedInterval = 5 * time.Millisecond
+
+// ApplyLag represents how far behind the node's FSM is from the commit index it knows.
+type ApplyLag struct {
+	CommitIndex  uint64 `json:"commitIndex"`
+	AppliedIndex uint64 `json:"appliedIndex"`
+	Lag          uint64 `json:"lag"`
+}
+
+// ApplyLag returns the difference between the leader's commit index, as known by this node,
+// and the last index applied to this node's FSM, of shard 0.
+//
+// Followers learn the commit index from the leader's heartbeats, so it's slightly behind the leader's.
+func (n *Node) ApplyLag() ApplyLag {
+	return applyLagOf(n.Consensus)
+}
+
+// ApplyLag returns the apply lag of the shard on this node, check Node.ApplyLag.
+func (s *Shard) ApplyLag() ApplyLag {
+	return applyLagOf(s.Consensus)
+}
+
+func applyLagOf(consensus *raft.Raft) ApplyLag {
+	stats := consensus.Stats()
+	// Safe to ignore these errors since raft always reports them as numbers
+	commitIndex, _ := strconv.ParseUint(stats["commit_index"], 10, 64)
+	appliedIndex, _ := strconv.ParseUint(stats["applied_index"], 10, 64)
+
+	lag := ApplyLag{CommitIndex: commitIndex, AppliedIndex: appliedIndex}
+	if commitIndex > appliedIndex {
+		lag.Lag = commitIndex - appliedIndex
+	}
+	return lag
+}
+
+// registerApplyLagMetrics exposes the apply lag of every shard of the node as a metric, labeled with its shard.
+func (n *Node) registerApplyLagMetrics() error {
+	for _, s := range n.shards {
+		s := s
+		errRegister := metrics.RegisterGaugeFunc(
+			"fsm_apply_lag", "Number of committed consensus logs not yet applied to the FSM.",
+			map[string]string{"shard": strconv.Itoa(s.ID)},
+			func() float64 {
+				return float64(s.ApplyLag().Lag)
+			},
+		)
+		if errRegister != nil {
+			return errorskit.Wrap(errRegister, "couldn't register the apply lag metric of shard "+strconv.Itoa(s.ID))
+		}
+	}
+	return nil
+}
+
+// WaitApplied blocks until the shard's FSM has applied index, so a read reflects a write committed at it.
+func (s *Shard) WaitApplied(index uint64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for s.Consensus.AppliedIndex() < index {
+		if time.Now().After(deadline) {
+			return ErrApplyTimeout
+		}
+		time.Sleep(waitAppliedInterval)
+	}
+	return nil
+}
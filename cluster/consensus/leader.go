@@ -0,0 +1,46 @@
+package consensus
+
+import (
+	"errors"
+	"nubedb/cluster"
+	"nubedb/discover"
+	"nubedb/internal/config"
+)
+
+// setCachedLeader sets the ID of the last known leader, it's kept updated by the leader changes observer.
+func (n *Node) setCachedLeader(id string) {
+	n.Lock()
+	defer n.Unlock()
+	n.cachedLeaderID = id
+}
+
+// getCachedLeader returns the ID of the last known leader.
+func (n *Node) getCachedLeader() string {
+	n.RLock()
+	defer n.RUnlock()
+	return n.cachedLeaderID
+}
+
+// SearchLeader returns the ID of the leader, excluding the current node.
+//
+// It uses the last known leader if it still reports as one, and only falls back to discovering it on the network
+// when it doesn't (since that requires scanning the whole network). A single node doesn't search for it.
+func (n *Node) SearchLeader() (string, error) {
+	if n.singleNode {
+		return "", errors.New(discover.ErrLeaderNotFound)
+	}
+	cached := n.getCachedLeader()
+	if cached != "" && cached != n.ID {
+		isLeader, err := cluster.IsLeader(config.MakeGrpcAddress(cached))
+		if err == nil && isLeader {
+			return cached, nil
+		}
+	}
+
+	leaderID, errSearch := discover.SearchLeader(n.ID)
+	if errSearch != nil {
+		return "", errSearch
+	}
+	n.setCachedLeader(leaderID)
+	return leaderID, nil
+}
@@ -0,0 +1,132 @@
+package consensus
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"log"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"os"
+	"time"
+)
+
+// Exit codes of the node, so its supervisor, like Kubernetes, can tell why it exited.
+const (
+	// ExitStopped is returned when the node was stopped and shut down cleanly.
+	ExitStopped = 0
+	// ExitFailure is returned when the node failed, it may need an operator to look at its logs.
+	ExitFailure = 1
+	// ExitRestart is returned when the node exits to be restarted, like after resetting it or to recover its consensus.
+	ExitRestart = 3
+)
+
+// ErrNotReady is returned when the node can't serve traffic yet.
+var ErrNotReady = errcode.New(errcode.Unavailable, "node isn't ready")
+
+// leaderTransferInterval is how often PrepareStop checks whether the leadership was transferred.
+const leaderTransferInterval = 100 * time.Millisecond
+
+// PrepareStop prepares the node to be stopped: it stops accepting client traffic, and transfers the leadership
+// of the shards it leads, waiting up to timeout for other nodes to take it.
+//
+// If expectReplacement is set, the node is kept in the consensus configuration for that long while it's offline,
+// so a node coming back with the same identity doesn't have to join again.
+func (n *Node) PrepareStop(expectReplacement time.Duration, timeout time.Duration) error {
+	n.maintenance.Store(true)
+	n.logger.Warn("node is preparing to stop")
+
+	if expectReplacement > 0 {
+		const operationType = "EXPECTREPLACEMENT"
+		payload := &fsm.Payload{
+			Key:       operationType,
+			Value:     fsm.Replacement{NodeID: n.ID, Until: clock.Now().Add(expectReplacement).UTC()},
+			Operation: operationType,
+		}
+		errExpect := cluster.Execute(n.Consensus, payload)
+		if errExpect != nil {
+			return errorskit.Wrap(errExpect, "couldn't record the expected replacement")
+		}
+	}
+
+	deadline := clock.Now().Add(timeout)
+	for _, s := range n.shards {
+		if s.Consensus.State() != raft.Leader {
+			continue
+		}
+		errTransfer := s.Consensus.LeadershipTransfer().Error()
+		if errTransfer != nil {
+			return errorskit.Wrap(errTransfer, fmt.Sprintf("couldn't transfer the leadership of shard %v", s.ID))
+		}
+		for {
+			_, leaderID := s.Consensus.LeaderWithID()
+			if leaderID != "" && string(leaderID) != n.ID {
+				break
+			}
+			if clock.Now().After(deadline) {
+				return fmt.Errorf("no other node took the leadership of shard %v in time", s.ID)
+			}
+			clock.Sleep(leaderTransferInterval)
+		}
+	}
+	return nil
+}
+
+// Ready returns whether the node can serve traffic: every shard has a leader, the node isn't in maintenance,
+// and its FSM isn't more than maxLag logs behind the commit index. 0 disables the lag check.
+func (n *Node) Ready(maxLag uint64) error {
+	if n.InMaintenance() {
+		return fmt.Errorf("%w: %v", ErrNotReady, ErrMaintenance)
+	}
+	for _, s := range n.shards {
+		if _, leaderID := s.Consensus.LeaderWithID(); leaderID == "" {
+			return fmt.Errorf("%w: shard %v has no leader", ErrNotReady, s.ID)
+		}
+	}
+	if lag := n.ApplyLag().Lag; maxLag > 0 && lag > maxLag {
+		return fmt.Errorf("%w: it's %v logs behind, which exceeds the maximum of %v", ErrNotReady, lag, maxLag)
+	}
+	return nil
+}
+
+// Shutdown shuts down the consensus of every shard, so the node can exit.
+func (n *Node) Shutdown() error {
+	n.stopOnce.Do(func() { close(n.stopped) })
+	var errShutdown error
+	for _, s := range n.shards {
+		err := s.Consensus.Shutdown().Error()
+		if err != nil && errShutdown == nil {
+			errShutdown = errorskit.Wrap(err, fmt.Sprintf("couldn't shutdown the consensus of shard %v", s.ID))
+		}
+	}
+	return errShutdown
+}
+
+// isReplacementExpected returns whether a node is expected to come back with the same identity.
+func (n *Node) isReplacementExpected(id string) bool {
+	until, ok := n.FSM.ExpectedReplacement(id)
+	return ok && clock.Now().Before(until)
+}
+
+// Restarts returns the channel which receives the reason of the restarts the node asks for, like to recover its consensus.
+//
+// The process must shut the node down like when it's stopped, and exit with ExitRestart,
+// so the node's supervisor restarts it.
+func (n *Node) Restarts() <-chan string {
+	return n.restarts
+}
+
+// requestRestart asks the process to restart the node, a restart already requested is kept.
+func (n *Node) requestRestart(reason string) {
+	select {
+	case n.restarts <- reason:
+	default:
+	}
+}
+
+// exitToRestart exits with ExitRestart, so the node's supervisor restarts it.
+func exitToRestart(msg string) {
+	log.Println(msg)
+	os.Exit(ExitRestart)
+}
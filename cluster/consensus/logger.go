@@ -20,7 +20,7 @@ func (n *Node) setConsensusLogger(cfg *raft.Config) {
 	fw := newConsensusFilterWriter()
 	l := hclog.New(&hclog.LoggerOptions{
 		Name:   "consensus",
-		Level:  hclog.LevelFromString("DEBUG"),
+		Level:  hclog.LevelFromString(n.tuning.LogLevel),
 		Output: fw,
 	})
 
@@ -0,0 +1,249 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/raft-boltdb/v2"
+	"github.com/narvikd/errorskit"
+	"go.etcd.io/bbolt"
+	"nubedb/internal/config"
+	"path/filepath"
+	"time"
+)
+
+// Kinds of log stores the consensus can use.
+const (
+	LogStoreBolt   = "bolt"
+	LogStoreBadger = "badger"
+	LogStoreMemory = "memory"
+)
+
+// ConsensusBadgerDirName is the name of the directory, inside the node's main dir,
+// where the consensus logs are stored when using the badger log store.
+const ConsensusBadgerDirName = "consensus"
+
+var (
+	// badgerLogPrefix is the prefix of the keys of the logs, followed by their big endian index so they are sorted.
+	badgerLogPrefix = []byte("l")
+	// badgerStablePrefix is the prefix of the keys of the stable store.
+	badgerStablePrefix = []byte("s")
+)
+
+// rawLogStore is the consensus' log and stable store, without encryption.
+type rawLogStore interface {
+	raft.LogStore
+	raft.StableStore
+	Close() error
+}
+
+// inmemLogStore keeps the consensus logs in memory, they are lost when the process exits.
+// It's the stable store too, so the term and vote are lost with them, the config refuses it for a node,
+// it's only meant for tests.
+type inmemLogStore struct {
+	*raft.InmemStore
+}
+
+// badgerLogStore keeps the consensus logs in a badgerDB, which batches the writes to disk better than bolt.
+type badgerLogStore struct {
+	db *badger.DB
+}
+
+// LogStorePath returns the path, inside the node's logs dir, where a kind of log store keeps the consensus logs.
+func LogStorePath(dir string, kind string) string {
+	if kind == LogStoreBadger {
+		return filepath.Join(dir, ConsensusBadgerDirName)
+	}
+	return filepath.Join(dir, ConsensusDBName)
+}
+
+// openRawLogStore opens a kind of log store.
+func openRawLogStore(path string, kind string, enc config.EncryptionCfg, readOnly bool) (rawLogStore, error) {
+	switch kind {
+	case LogStoreBolt, "":
+		opts := raftboltdb.Options{Path: path}
+		if readOnly {
+			opts.BoltOptions = &bbolt.Options{ReadOnly: true, Timeout: 1 * time.Second}
+		}
+		store, errStore := raftboltdb.New(opts)
+		if errStore != nil {
+			return nil, errorskit.Wrap(errStore, "couldn't open consensus db")
+		}
+		return store, nil
+	case LogStoreBadger:
+		return openBadgerLogStore(path, enc, readOnly)
+	case LogStoreMemory:
+		return inmemLogStore{InmemStore: raft.NewInmemStore()}, nil
+	default:
+		return nil, fmt.Errorf("consensus log store not recognized: %s", kind)
+	}
+}
+
+func (s inmemLogStore) Close() error {
+	return nil
+}
+
+// openBadgerLogStore opens the badger log store.
+//
+// The logs are already encrypted by LogStore, badger's encryption is only used for the stable store's values.
+func openBadgerLogStore(dir string, enc config.EncryptionCfg, readOnly bool) (*badgerLogStore, error) {
+	const encryptionIndexCacheSize = 10 << 20 // 10MB
+	// The consensus requires the logs to be durable before they are acknowledged.
+	opts := badger.DefaultOptions(dir).
+		WithSyncWrites(true).
+		WithReadOnly(readOnly)
+	if enc.Enabled() {
+		opts = opts.
+			WithEncryptionKey(enc.Key).
+			WithEncryptionKeyRotationDuration(enc.DataKeyRotation).
+			WithIndexCacheSize(encryptionIndexCacheSize)
+	}
+
+	db, errOpen := badger.Open(opts)
+	if errOpen != nil {
+		return nil, errorskit.Wrap(errOpen, "couldn't open consensus badgerDB")
+	}
+	// The space of the compacted logs is only reclaimed by badger's value log GC.
+	if !readOnly {
+		go badgerGC(db)
+	}
+	return &badgerLogStore{db: db}, nil
+}
+
+func (s *badgerLogStore) FirstIndex() (uint64, error) {
+	return s.edgeIndex(false)
+}
+
+func (s *badgerLogStore) LastIndex() (uint64, error) {
+	return s.edgeIndex(true)
+}
+
+// edgeIndex returns the first or the last index stored, 0 if there aren't logs.
+func (s *badgerLogStore) edgeIndex(last bool) (uint64, error) {
+	var index uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = badgerLogPrefix
+		opts.Reverse = last
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		seek := badgerLogPrefix
+		if last {
+			seek = logKey(^uint64(0))
+		}
+		it.Seek(seek)
+		if it.Valid() {
+			index = binary.BigEndian.Uint64(it.Item().Key()[len(badgerLogPrefix):])
+		}
+		return nil
+	})
+	return index, err
+}
+
+func (s *badgerLogStore) GetLog(index uint64, log *raft.Log) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		item, errGet := txn.Get(logKey(index))
+		if errors.Is(errGet, badger.ErrKeyNotFound) {
+			return raft.ErrLogNotFound
+		}
+		if errGet != nil {
+			return errGet
+		}
+		return item.Value(func(val []byte) error {
+			return codec.NewDecoder(bytes.NewReader(val), &codec.MsgpackHandle{}).Decode(log)
+		})
+	})
+}
+
+func (s *badgerLogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores the logs in a write batch, so a single sync is done for all of them.
+func (s *badgerLogStore) StoreLogs(logs []*raft.Log) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, l := range logs {
+		var buf bytes.Buffer
+		errEncode := codec.NewEncoder(&buf, &codec.MsgpackHandle{}).Encode(l)
+		if errEncode != nil {
+			return errorskit.Wrap(errEncode, "couldn't encode consensus log")
+		}
+		errSet := wb.Set(logKey(l.Index), buf.Bytes())
+		if errSet != nil {
+			return errSet
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *badgerLogStore) DeleteRange(min uint64, max uint64) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for i := min; i <= max && i >= min; i++ {
+		errDelete := wb.Delete(logKey(i))
+		if errDelete != nil {
+			return errDelete
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *badgerLogStore) Set(key []byte, val []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(stableKey(key), val)
+	})
+}
+
+// Get returns an error when the key doesn't exist, like raft-boltdb does.
+func (s *badgerLogStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, errGet := txn.Get(stableKey(key))
+		if errors.Is(errGet, badger.ErrKeyNotFound) {
+			return errors.New("not found")
+		}
+		if errGet != nil {
+			return errGet
+		}
+		v, errVal := item.ValueCopy(nil)
+		value = v
+		return errVal
+	})
+	return value, err
+}
+
+func (s *badgerLogStore) SetUint64(key []byte, val uint64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, val)
+	return s.Set(key, b)
+}
+
+func (s *badgerLogStore) GetUint64(key []byte) (uint64, error) {
+	b, errGet := s.Get(key)
+	if errGet != nil {
+		return 0, errGet
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (s *badgerLogStore) Close() error {
+	return s.db.Close()
+}
+
+func logKey(index uint64) []byte {
+	k := make([]byte, len(badgerLogPrefix)+8)
+	copy(k, badgerLogPrefix)
+	binary.BigEndian.PutUint64(k[len(badgerLogPrefix):], index)
+	return k
+}
+
+func stableKey(key []byte) []byte {
+	return append(append([]byte{}, badgerStablePrefix...), key...)
+}
@@ -0,0 +1,37 @@
+package consensus
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/errcode"
+)
+
+// ErrMaintenance is returned when a node in maintenance mode receives client traffic.
+var ErrMaintenance = errcode.New(errcode.Maintenance, "node is in maintenance mode, send the request to another node")
+
+// InMaintenance returns whether the node is in maintenance mode, where it refuses client traffic
+// but keeps participating in the consensus.
+func (n *Node) InMaintenance() bool {
+	return n.maintenance.Load()
+}
+
+// EnterMaintenance puts the node in maintenance mode.
+//
+// If transferLeadership is true and the node is the leader, the leadership is transferred to another node first.
+func (n *Node) EnterMaintenance(transferLeadership bool) error {
+	if transferLeadership && n.Consensus.State() == raft.Leader {
+		errTransfer := n.Consensus.LeadershipTransfer().Error()
+		if errTransfer != nil {
+			return errorskit.Wrap(errTransfer, "couldn't transfer leadership")
+		}
+	}
+	n.maintenance.Store(true)
+	n.logger.Warn("node entered maintenance mode")
+	return nil
+}
+
+// ExitMaintenance makes the node accept client traffic again.
+func (n *Node) ExitMaintenance() {
+	n.maintenance.Store(false)
+	n.logger.Info("node exited maintenance mode")
+}
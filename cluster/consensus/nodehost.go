@@ -0,0 +1,51 @@
+package consensus
+
+import (
+	"net"
+	"nubedb/internal/config"
+	"time"
+)
+
+// nodeHostsInterval is how often the hosts of the nodes are read from the consensus configurations.
+const nodeHostsInterval = 5 * time.Second
+
+// watchNodeHosts keeps the hosts of the nodes up to date with the addresses in the consensus configurations,
+// so the nodes whose ID isn't their host, like the ones configured with an advertise IP, are reached at it.
+//
+// It returns once the node is shut down.
+func (n *Node) watchNodeHosts() {
+	ticker := time.NewTicker(nodeHostsInterval)
+	defer ticker.Stop()
+	for {
+		n.registerNodeHosts()
+		select {
+		case <-ticker.C:
+		case <-n.stopped:
+			return
+		}
+	}
+}
+
+// registerNodeHosts records the hosts of the servers of every shard's consensus configuration.
+func (n *Node) registerNodeHosts() {
+	for _, s := range n.Shards() {
+		future := s.Consensus.GetConfiguration()
+		if future.Error() != nil {
+			n.logger.Warn("couldn't read the consensus configuration to register the hosts of the nodes",
+				"shard", s.ID, "error", future.Error())
+			continue
+		}
+		for _, srv := range future.Configuration().Servers {
+			RegisterNodeAddress(string(srv.ID), string(srv.Address))
+		}
+	}
+}
+
+// RegisterNodeAddress records the host of a node from one of its addresses, like its consensus address.
+func RegisterNodeAddress(nodeID string, address string) {
+	host, _, errSplit := net.SplitHostPort(address)
+	if errSplit != nil {
+		return
+	}
+	config.RegisterNodeHost(nodeID, host)
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/narvikd/filekit"
 	"log"
 	"nubedb/cluster"
-	"nubedb/discover"
 	"nubedb/internal/config"
 	"time"
 )
@@ -46,8 +45,10 @@ func (n *Node) registerNodeChangesChan() {
 	go func() {
 		// Blocks until something enters the channel
 		for o := range n.chans.nodeChanges {
-			n.logger.Info("Node Changed to role: " + o.Data.(raft.RaftState).String())
+			state := o.Data.(raft.RaftState)
+			n.logger.Info("Node Changed to role: " + state.String())
 			n.checkIfNodeNeedsUnblock()
+			n.stepDownIfWitness(state)
 		}
 	}()
 }
@@ -63,6 +64,7 @@ func (n *Node) registerLeaderChangesChan() {
 		for o := range n.chans.leaderChanges {
 			obs := o.Data.(raft.LeaderObservation)
 			leaderID := string(obs.LeaderID)
+			n.setCachedLeader(leaderID)
 			if leaderID != "" {
 				n.logger.Info("New Leader: " + leaderID)
 			} else {
@@ -83,6 +85,10 @@ func (n *Node) registerFailedHBChangesChan() {
 		// Blocks until something enters the channel
 		for o := range n.chans.failedHBChanges {
 			obs := o.Data.(raft.FailedHeartbeatObservation)
+			// A node expected to come back with the same identity keeps its place, until it's overdue.
+			if n.isReplacementExpected(string(obs.PeerID)) {
+				continue
+			}
 			warnMsg := fmt.Sprintf("REMOVING NODE '%v' from the Leader due to being offline...", obs.PeerID)
 			n.logger.Warn(warnMsg)
 			n.Consensus.RemoveServer(obs.PeerID, 0, 0)
@@ -125,7 +131,7 @@ func (n *Node) checkIfNodeNeedsUnblock() {
 	if leaderID != "" {
 		return
 	}
-	time.Sleep(timeout)
+	clock.Sleep(timeout)
 	_, leaderID = n.Consensus.LeaderWithID()
 	if leaderID != "" {
 		return
@@ -152,7 +158,7 @@ func (n *Node) ReinstallNode() {
 		errorskit.FatalWrap(future.Error(), errPanic+"couldn't shut down")
 	}
 
-	leader, errSearchLeader := discover.SearchLeader(n.ID)
+	leader, errSearchLeader := n.SearchLeader()
 	if errSearchLeader != nil {
 		errorskit.FatalWrap(errSearchLeader, errPanic+"couldn't search for leader")
 	}
@@ -163,12 +169,14 @@ func (n *Node) ReinstallNode() {
 		errorskit.FatalWrap(errConsensusRemove, errPanic+"couldn't remove from consensus")
 	}
 
-	errDeleteDirs := filekit.DeleteDirs(n.MainDir)
-	if errDeleteDirs != nil {
-		errorskit.FatalWrap(errDeleteDirs, errPanic+"couldn't delete dirs")
+	for _, dir := range n.paths.Roots() {
+		errDeleteDirs := filekit.DeleteDirs(dir)
+		if errDeleteDirs != nil {
+			errorskit.FatalWrap(errDeleteDirs, errPanic+"couldn't delete dirs")
+		}
 	}
 
-	log.Fatalln("Node successfully reset. Restarting...")
+	exitToRestart("Node successfully reset. Restarting...")
 }
 
 func (n *Node) isNodeInConsensusServers(id string) bool {
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"nubedb/internal/config"
+	"path"
+	"strconv"
+)
+
+// Paths are the directories where a node stores its data.
+//
+// The FSM's data, the consensus logs and the snapshots can each be on their own volume,
+// so the logs, which are synced on every write, can live on a faster disk than the bulk of the data.
+type Paths struct {
+	// Main is the node's main dir, it holds everything which isn't in the other dirs, like the peers file.
+	Main string
+	// Storage is the dir of the FSM's data.
+	Storage string
+	// Logs is the dir the consensus logs store is kept in.
+	Logs string
+	// Snapshots is the dir the snapshots dir is created in.
+	Snapshots string
+}
+
+// NodePaths returns the directories where a node stores its data.
+//
+// The dirs which aren't configured are inside the node's main dir, the configured ones get a subdir named after the node,
+// so the nodes of a host can share a volume.
+func NodePaths(id string, storage config.StorageCfg, consensusCfg config.ConsensusCfg) Paths {
+	mainDir := MainDir(id)
+	return Paths{
+		Main:      mainDir,
+		Storage:   path.Join(volumeDir(storage.Dir, mainDir, id), StorageDirName),
+		Logs:      volumeDir(consensusCfg.LogDir, mainDir, id),
+		Snapshots: volumeDir(consensusCfg.SnapshotDir, mainDir, id),
+	}
+}
+
+// volumeDir returns the dir of a node inside a configured dir, or its main dir if it isn't configured.
+func volumeDir(dir string, mainDir string, id string) string {
+	if dir == "" {
+		return mainDir
+	}
+	return path.Join(dir, id)
+}
+
+// Shard returns the directories where a shard stores its data, inside a shards dir in each of the node's dirs.
+func (p Paths) Shard(id int) Paths {
+	shardDir := path.Join(ShardsDirName, strconv.Itoa(id))
+	return Paths{
+		Main:      path.Join(p.Main, shardDir),
+		Storage:   path.Join(path.Dir(p.Storage), shardDir, StorageDirName),
+		Logs:      path.Join(p.Logs, shardDir),
+		Snapshots: path.Join(p.Snapshots, shardDir),
+	}
+}
+
+// LogStore returns the path of a kind of consensus logs store.
+func (p Paths) LogStore(kind string) string {
+	return LogStorePath(p.Logs, kind)
+}
+
+// SnapshotStore returns the path of the snapshots dir.
+func (p Paths) SnapshotStore() string {
+	return path.Join(p.Snapshots, SnapshotsDirName)
+}
+
+// Roots returns the distinct dirs the node's data is stored in, the main dir first.
+func (p Paths) Roots() []string {
+	roots := []string{p.Main}
+	for _, dir := range []string{path.Dir(p.Storage), p.Logs, p.Snapshots} {
+		if !containsID(roots, dir) {
+			roots = append(roots, dir)
+		}
+	}
+	return roots
+}
@@ -0,0 +1,162 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/config"
+	"nubedb/internal/metrics"
+)
+
+var (
+	// ErrNoQuorum is returned when a majority of the replicas of a shard couldn't be read.
+	ErrNoQuorum = errcode.New(errcode.Unavailable, "couldn't read from a majority of the replicas")
+	// ErrWitnessRead is returned when a witness is asked to read a key, since it doesn't store data.
+	ErrWitnessRead = errcode.New(errcode.Unavailable, "witness nodes don't store data")
+)
+
+// ReplicaRead is the value of a key read from a replica, and the index its shard had applied before reading it.
+type ReplicaRead struct {
+	Node         string          `json:"node"`
+	Found        bool            `json:"found"`
+	Value        json.RawMessage `json:"value,omitempty"`
+	AppliedIndex uint64          `json:"appliedIndex"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// QuorumRead is the result of reading a key from a majority of the replicas of its shard.
+type QuorumRead struct {
+	// Found, Value and AppliedIndex are the ones of the replica which had applied the most logs.
+	Found        bool            `json:"found"`
+	Value        json.RawMessage `json:"value,omitempty"`
+	AppliedIndex uint64          `json:"appliedIndex"`
+	// Result is one of the metrics.QuorumRead results.
+	Result   string        `json:"result"`
+	Replicas []ReplicaRead `json:"replicas"`
+}
+
+// ReadLocal reads a key from the shard's local storage, with the index the shard had applied before reading it,
+// so the value is at least as recent as that index.
+func (s *Shard) ReadLocal(key string) (ReplicaRead, error) {
+	read := ReplicaRead{AppliedIndex: s.Consensus.AppliedIndex()}
+	value, errGet := s.FSM.Get(key)
+	if errors.Is(errGet, engine.ErrKeyNotFound) {
+		return read, nil
+	}
+	if errGet != nil {
+		return ReplicaRead{}, errGet
+	}
+
+	b, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return ReplicaRead{}, errMarshal
+	}
+	read.Found = true
+	read.Value = b
+	return read, nil
+}
+
+// QuorumGet reads a key from all the voters of its shard, and returns the value of the replica
+// which had applied the most logs, once a majority of them answered.
+//
+// The answers are compared, and the divergence between them is counted in a metric.
+// It isn't a linearizable read: a write committed while the replicas are read may or may not be returned.
+func (n *Node) QuorumGet(key string) (QuorumRead, error) {
+	s := n.ShardFor(key)
+	servers, errServers := s.Replicas()
+	if errServers != nil {
+		return QuorumRead{}, errServers
+	}
+	voters := make([]raft.Server, 0, len(servers))
+	for _, srv := range servers {
+		if srv.Suffrage == raft.Voter {
+			voters = append(voters, srv)
+		}
+	}
+	quorum := len(voters)/2 + 1
+
+	reads := make(chan ReplicaRead, len(voters))
+	for _, srv := range voters {
+		go func(id string) {
+			read, errRead := n.readReplica(s, id, key)
+			read.Node = id
+			if errRead != nil {
+				read.Error = errRead.Error()
+			}
+			reads <- read
+		}(string(srv.ID))
+	}
+
+	var (
+		answered []ReplicaRead
+		failed   []ReplicaRead
+	)
+	for i := 0; i < len(voters) && len(answered) < quorum; i++ {
+		read := <-reads
+		if read.Error != "" {
+			failed = append(failed, read)
+			continue
+		}
+		answered = append(answered, read)
+	}
+	if len(answered) < quorum {
+		return QuorumRead{}, fmt.Errorf("%w: %v of %v answered, the majority is %v, errors: %v",
+			ErrNoQuorum, len(answered), len(voters), quorum, failed)
+	}
+
+	res := resolveQuorumRead(answered)
+	metrics.RecordQuorumRead(res.Result)
+	return res, nil
+}
+
+func (n *Node) readReplica(s *Shard, id string, key string) (ReplicaRead, error) {
+	if id != n.ID {
+		res, errRead := cluster.ReadKey(s.ID, key, config.MakeGrpcAddress(id))
+		if errRead != nil {
+			return ReplicaRead{}, errRead
+		}
+		return ReplicaRead{Found: res.Found, Value: res.Value, AppliedIndex: res.AppliedIndex}, nil
+	}
+	if n.IsWitness() {
+		return ReplicaRead{}, ErrWitnessRead
+	}
+	return s.ReadLocal(key)
+}
+
+// resolveQuorumRead picks the answer of the replica which had applied the most logs,
+// and classifies how the answers diverged.
+func resolveQuorumRead(reads []ReplicaRead) QuorumRead {
+	latest := reads[0]
+	for _, read := range reads[1:] {
+		if read.AppliedIndex > latest.AppliedIndex {
+			latest = read
+		}
+	}
+
+	res := QuorumRead{
+		Found:        latest.Found,
+		Value:        latest.Value,
+		AppliedIndex: latest.AppliedIndex,
+		Result:       metrics.QuorumReadConsistent,
+		Replicas:     reads,
+	}
+	for i, a := range reads {
+		for _, b := range reads[i+1:] {
+			if a.Found == b.Found && bytes.Equal(a.Value, b.Value) {
+				continue
+			}
+			// Replicas which applied the same logs must have the same data, otherwise they diverged.
+			if a.AppliedIndex == b.AppliedIndex {
+				res.Result = metrics.QuorumReadConflict
+				return res
+			}
+			res.Result = metrics.QuorumReadStale
+		}
+	}
+	return res
+}
@@ -0,0 +1,22 @@
+package consensus
+
+import "nubedb/cluster/errcode"
+
+// ErrReadOnly is returned when a read-only node receives a write.
+var ErrReadOnly = errcode.New(errcode.ReadOnly, "node is read-only, send the writes to another node")
+
+// IsReadOnly returns whether the node is read-only, where it refuses the writes it receives from clients
+// but keeps serving reads, and applying the writes received by the other nodes.
+func (n *Node) IsReadOnly() bool {
+	return n.readOnly.Load()
+}
+
+// SetReadOnly makes the node read-only, or makes it accept writes again.
+func (n *Node) SetReadOnly(readOnly bool) {
+	n.readOnly.Store(readOnly)
+	if readOnly {
+		n.logger.Warn("node is read-only")
+		return
+	}
+	n.logger.Info("node accepts writes again")
+}
@@ -0,0 +1,330 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/shard"
+	"nubedb/internal/config"
+	"sort"
+	"time"
+)
+
+// ErrNotSharded is returned by the shard operations when sharding isn't enabled.
+var ErrNotSharded = errcode.New(errcode.NotEnabled, "sharding isn't enabled")
+
+// ShardInfo is the state of a shard, as seen by this node.
+type ShardInfo struct {
+	ID       int      `json:"id"`
+	Slots    int      `json:"slots"`
+	Leader   string   `json:"leader"`
+	Replicas []string `json:"replicas"`
+}
+
+// ShardsInfo is the state of all the shards, with the slots being moved between them.
+type ShardsInfo struct {
+	MapVersion uint64      `json:"mapVersion"`
+	Shards     []ShardInfo `json:"shards"`
+	// Moving maps the slots being moved to the shard they are moved to.
+	Moving map[int]int `json:"moving"`
+}
+
+// ShardsInfo returns the state of all the shards.
+func (n *Node) ShardsInfo() (ShardsInfo, error) {
+	m := n.ShardMap()
+	info := ShardsInfo{MapVersion: m.Version, Moving: m.Moving}
+	for _, s := range n.shards {
+		servers, errServers := s.Replicas()
+		if errServers != nil {
+			return ShardsInfo{}, errServers
+		}
+		replicas := make([]string, 0, len(servers))
+		for _, srv := range servers {
+			replicas = append(replicas, string(srv.ID))
+		}
+		_, leaderID := s.Consensus.LeaderWithID()
+		info.Shards = append(info.Shards, ShardInfo{
+			ID:       s.ID,
+			Slots:    len(m.SlotsOf(n.ring, s.ID)),
+			Leader:   string(leaderID),
+			Replicas: replicas,
+		})
+	}
+	return info, nil
+}
+
+// SplitShard moves the upper half of the slots of a hot shard to another shard, returning the moved slots.
+func (n *Node) SplitShard(id int, target int) ([]int, error) {
+	slots := n.ShardMap().SlotsOf(n.ring, id)
+	if len(slots) < 2 {
+		return nil, fmt.Errorf("shard %v doesn't have enough slots to be split", id)
+	}
+	return n.moveSlotsTo(slots[len(slots)/2:], target)
+}
+
+// MergeShard moves all the slots of a cold shard to another shard, returning the moved slots.
+//
+// The merged shard keeps running, without slots, so it can be used as the target of a later split.
+func (n *Node) MergeShard(id int, target int) ([]int, error) {
+	slots := n.ShardMap().SlotsOf(n.ring, id)
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("shard %v doesn't have slots to merge", id)
+	}
+	return n.moveSlotsTo(slots, target)
+}
+
+// Rebalance moves slots between the shards until all of them have the same number of slots,
+// returning the moved slots with the shard they were moved to.
+func (n *Node) Rebalance() (map[int]int, error) {
+	moves := n.ShardMap().Balance(n.ring)
+	return moves, n.MoveSlots(moves)
+}
+
+func (n *Node) moveSlotsTo(slots []int, target int) ([]int, error) {
+	moves := make(map[int]int, len(slots))
+	for _, slot := range slots {
+		moves[slot] = target
+	}
+	return slots, n.MoveSlots(moves)
+}
+
+// MoveSlots moves slots to other shards, moves is a map of slot to target shard.
+//
+// The slots are moved in groups with the same source and target shard. For each group:
+//  1. The move is recorded in the shard map, so it can be followed.
+//  2. The target shard starts accepting writes to the slots, and the source shard refuses them,
+//     the refused writes fail with fsm.ErrSlotMoved, which clients retry.
+//  3. The keys of the slots, and the settings of their buckets, are copied from the source shard to the target one.
+//  4. The shard map is updated, so the slots are routed to the target shard, and the source shard's copy is purged.
+//
+// Only the writes of the slots being moved are refused, and only while they are copied.
+// If a move fails, its slots keep refusing writes until the move is retried.
+func (n *Node) MoveSlots(moves map[int]int) error {
+	if !n.IsSharded() {
+		return ErrNotSharded
+	}
+	n.rebalanceMu.Lock()
+	defer n.rebalanceMu.Unlock()
+
+	m := n.ShardMap()
+	groups := make(map[[2]int][]int)
+	for slot, target := range moves {
+		if slot < 0 || slot >= shard.Slots {
+			return fmt.Errorf("slot out of range: %v", slot)
+		}
+		if _, errShard := n.Shard(target); errShard != nil {
+			return errShard
+		}
+		source := m.Locate(n.ring, slot)
+		if source == target {
+			continue
+		}
+		groups[[2]int{source, target}] = append(groups[[2]int{source, target}], slot)
+	}
+
+	for pair, slots := range groups {
+		sort.Ints(slots)
+		errMove := n.moveSlots(n.shards[pair[0]], n.shards[pair[1]], slots)
+		if errMove != nil {
+			return errorskit.Wrap(errMove, fmt.Sprintf("couldn't move slots from shard %v to shard %v", pair[0], pair[1]))
+		}
+	}
+	return nil
+}
+
+func (n *Node) moveSlots(source *Shard, target *Shard, slots []int) error {
+	errMap := n.updateShardMap(func(m *shard.Map) {
+		for _, slot := range slots {
+			m.Moving[slot] = target.ID
+		}
+	})
+	if errMap != nil {
+		return errMap
+	}
+
+	errOwn := cluster.Execute(target.Consensus, &fsm.Payload{Key: "slots", Value: slots, Operation: "OWNSLOTS"})
+	if errOwn != nil {
+		return errOwn
+	}
+	errDisown := cluster.Execute(source.Consensus, &fsm.Payload{Key: "slots", Value: slots, Operation: "DISOWNSLOTS"})
+	if errDisown != nil {
+		return errDisown
+	}
+	// The writes applied before the slots were disowned must be in this node's copy of the source shard.
+	errWait := waitForDisowned(source, slots)
+	if errWait != nil {
+		return errWait
+	}
+
+	errCopy := copySlots(source, target, slots)
+	if errCopy != nil {
+		return errCopy
+	}
+
+	errMap = n.updateShardMap(func(m *shard.Map) {
+		for _, slot := range slots {
+			delete(m.Moving, slot)
+			m.Owners[slot] = target.ID
+			if n.ring.Locate(slot) == target.ID {
+				delete(m.Owners, slot)
+			}
+		}
+	})
+	if errMap != nil {
+		return errMap
+	}
+
+	return cluster.Execute(source.Consensus, &fsm.Payload{Key: "slots", Value: slots, Operation: "PURGESLOTS"})
+}
+
+// updateShardMap applies a change to the shard map, through shard 0.
+//
+// It fails with fsm.ErrShardMapConflict if the map was changed by another node in the meantime.
+func (n *Node) updateShardMap(change func(m *shard.Map)) error {
+	m := n.ShardMap().Clone()
+	m.Version++
+	change(&m)
+	return cluster.Execute(n.Consensus, &fsm.Payload{Key: "shardmap", Value: m, Operation: "SETSHARDMAP"})
+}
+
+// waitForDisowned waits until this node's copy of a shard refuses the writes to all the slots.
+func waitForDisowned(s *Shard, slots []int) error {
+	const (
+		timeout  = 30 * time.Second
+		interval = 100 * time.Millisecond
+	)
+	deadline := clock.Now().Add(timeout)
+	for clock.Now().Before(deadline) {
+		disowned := true
+		for _, slot := range slots {
+			if s.FSM.OwnsSlot(slot) {
+				disowned = false
+				break
+			}
+		}
+		if disowned {
+			return nil
+		}
+		clock.Sleep(interval)
+	}
+	return fmt.Errorf("shard %v didn't apply the move of the slots in %s", s.ID, timeout)
+}
+
+// copySlots copies the keys of some slots from this node's copy of a shard to another shard,
+// with the settings of their buckets.
+func copySlots(source *Shard, target *Shard, slots []int) error {
+	set := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		set[slot] = true
+	}
+
+	buckets := make(map[string]bool)
+	return source.FSM.IterateSlots(set, func(k string, value []byte) error {
+		bucket := fsm.BucketOf(k)
+		if bucket != "" && !buckets[bucket] {
+			buckets[bucket] = true
+			errBucket := copyBucketSettings(source, target, bucket)
+			if errBucket != nil {
+				return errBucket
+			}
+		}
+		return cluster.Execute(target.Consensus, &fsm.Payload{
+			Key:         k,
+			Value:       json.RawMessage(value),
+			Operation:   "SET",
+			NotBefore:   source.FSM.NotBefore(k),
+			ContentType: source.FSM.ContentType(k),
+		})
+	})
+}
+
+// copyBucketSettings copies the encryption key, the search setting and the blob mode of a bucket to another shard.
+func copyBucketSettings(source *Shard, target *Shard, bucket string) error {
+	wrapped, errKey := source.FSM.GetBucketKey(bucket)
+	if errKey == nil {
+		_, errTargetKey := target.FSM.GetBucketKey(bucket)
+		if errTargetKey != nil {
+			errSet := cluster.Execute(target.Consensus, &fsm.Payload{Key: bucket, Value: wrapped, Operation: "SETBUCKETKEY"})
+			if errSet != nil && !errors.Is(errSet, fsm.ErrBucketEncrypted) {
+				return errSet
+			}
+		}
+	}
+	if source.FSM.IsSearchEnabled(bucket) && !target.FSM.IsSearchEnabled(bucket) {
+		errSearch := cluster.Execute(target.Consensus, &fsm.Payload{Key: bucket, Operation: "ENABLESEARCH"})
+		if errSearch != nil {
+			return errSearch
+		}
+	}
+	if source.FSM.IsBlobsEnabled(bucket) && !target.FSM.IsBlobsEnabled(bucket) {
+		errBlobs := cluster.Execute(target.Consensus, &fsm.Payload{Key: bucket, Operation: "ENABLEBLOBS"})
+		if errBlobs != nil {
+			return errBlobs
+		}
+	}
+	hook, errHook := source.FSM.GetHook(bucket)
+	if errHook == nil {
+		errSetHook := cluster.Execute(target.Consensus, &fsm.Payload{Key: bucket, Value: hook, Operation: "SETHOOK"})
+		if errSetHook != nil {
+			return errSetHook
+		}
+	}
+	raw, errSchema := source.FSM.GetSchema(bucket)
+	if errSchema != nil {
+		return nil
+	}
+	// The schema is decoded, so it's sent as a document with any payload encoding.
+	var schema any
+	errUnmarshal := json.Unmarshal(raw, &schema)
+	if errUnmarshal != nil {
+		return errUnmarshal
+	}
+	return cluster.Execute(target.Consensus, &fsm.Payload{Key: bucket, Value: schema, Operation: "SETSCHEMA"})
+}
+
+// MoveShardReplica moves a replica of a shard from a node to another one, adding the new replica first,
+// so the shard doesn't lose a replica in the meantime.
+//
+// The replicas of shard 0 can't be moved, since it's the node's main consensus, nodes are decommissioned instead.
+func (n *Node) MoveShardReplica(id int, from string, to string) error {
+	if !n.IsSharded() {
+		return ErrNotSharded
+	}
+	if id == 0 {
+		return errors.New("the replicas of shard 0 can't be moved, decommission the node instead")
+	}
+	s, errShard := n.Shard(id)
+	if errShard != nil {
+		return errShard
+	}
+	if !s.HasReplica(from) {
+		return fmt.Errorf("node '%s' isn't a replica of shard %v", from, id)
+	}
+	if s.HasReplica(to) {
+		return fmt.Errorf("node '%s' is already a replica of shard %v", to, id)
+	}
+
+	if s.Consensus.State() == raft.Leader {
+		errAdd := s.Consensus.AddVoter(raft.ServerID(to), raft.ServerAddress(config.MakeShardConsensusAddr(to, id)), 0, 0).Error()
+		if errAdd != nil {
+			return errAdd
+		}
+		return s.Consensus.RemoveServer(raft.ServerID(from), 0, 0).Error()
+	}
+
+	_, leaderID := s.Consensus.LeaderWithID()
+	if leaderID == "" {
+		return cluster.ErrNoLeader
+	}
+	leaderGrpcAddr := config.MakeGrpcAddress(string(leaderID))
+	errJoin := cluster.ShardJoin(id, to, config.MakeShardConsensusAddr(to, id), leaderGrpcAddr)
+	if errJoin != nil {
+		return errJoin
+	}
+	return cluster.ShardRemove(id, from, leaderGrpcAddr)
+}
@@ -0,0 +1,112 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"github.com/narvikd/filekit"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// PeersFileName is the name of the file, inside the node's main dir, with the configuration to recover on boot.
+	PeersFileName = "peers.json"
+	// recoveredPeersFileName is the name the peers file is renamed to, once it has been recovered.
+	recoveredPeersFileName = "peers.info"
+)
+
+// Peer is a server of the consensus, in the same format raft uses for its peers.json recovery file.
+//
+// Its fields keep the names of that format, like non_voter, so the configurations can be written by hand
+// or copied from raft's documentation, instead of using the camelCase of nubedb's API.
+type Peer struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	NonVoter bool   `json:"non_voter"`
+}
+
+// Peers returns the consensus configuration the node currently has.
+func (n *Node) Peers() ([]Peer, error) {
+	future := n.Consensus.GetConfiguration()
+	if future.Error() != nil {
+		return nil, errorskit.Wrap(future.Error(), "couldn't get consensus configuration")
+	}
+
+	servers := future.Configuration().Servers
+	peers := make([]Peer, 0, len(servers))
+	for _, srv := range servers {
+		peers = append(peers, Peer{
+			ID:       string(srv.ID),
+			Address:  string(srv.Address),
+			NonVoter: srv.Suffrage != raft.Voter,
+		})
+	}
+	return peers, nil
+}
+
+// ScheduleRecovery validates and saves the configuration the consensus will be recovered with,
+// which is applied with raft.RecoverCluster the next time the node boots.
+//
+// It's meant to be used on every surviving node of a cluster which lost its quorum permanently,
+// with the same configuration on all of them.
+func (n *Node) ScheduleRecovery(peers []Peer) error {
+	if len(peers) == 0 {
+		return errors.New("the configuration to recover must have at least one server")
+	}
+	b, errMarshal := json.Marshal(peers)
+	if errMarshal != nil {
+		return errorskit.Wrap(errMarshal, "couldn't marshal consensus configuration")
+	}
+
+	// The configuration is written to a temporary file first, so an invalid one is never recovered.
+	tmpPath := filepath.Join(n.MainDir, PeersFileName+".tmp")
+	errWrite := os.WriteFile(tmpPath, b, 0o600)
+	if errWrite != nil {
+		return errorskit.Wrap(errWrite, "couldn't write consensus configuration")
+	}
+	_, errRead := raft.ReadConfigJSON(tmpPath)
+	if errRead != nil {
+		_ = os.Remove(tmpPath)
+		return errorskit.Wrap(errRead, "invalid consensus configuration")
+	}
+
+	return os.Rename(tmpPath, filepath.Join(n.MainDir, PeersFileName))
+}
+
+// RestartToRecover asks the process to restart the node, check Restarts, so it recovers the configuration saved
+// with ScheduleRecovery on the next boot.
+func (n *Node) RestartToRecover() {
+	n.requestRestart("recovering the consensus configuration on the next boot")
+}
+
+// recoverConfiguration overwrites the consensus configuration with the one saved in the peers file, if there is one.
+//
+// It must be called before the consensus is created.
+func (n *Node) recoverConfiguration(cfg *raft.Config, stores *LogStore, snaps raft.SnapshotStore,
+	transport raft.Transport) error {
+	peersPath := filepath.Join(n.MainDir, PeersFileName)
+	if !filekit.FileExist(peersPath) {
+		return nil
+	}
+
+	configuration, errRead := raft.ReadConfigJSON(peersPath)
+	if errRead != nil {
+		return errorskit.Wrap(errRead, "couldn't read consensus configuration to recover")
+	}
+
+	errRecover := raft.RecoverCluster(cfg, n.FSM, stores, stores, snaps, transport, configuration)
+	if errRecover != nil {
+		return errorskit.Wrap(errRecover, "couldn't recover consensus configuration")
+	}
+
+	// The file is renamed, so the configuration isn't recovered again on every boot.
+	errRename := os.Rename(peersPath, filepath.Join(n.MainDir, recoveredPeersFileName))
+	if errRename != nil {
+		return errorskit.Wrap(errRename, "couldn't rename recovered consensus configuration")
+	}
+
+	n.logger.Warn("consensus configuration recovered", "servers", len(configuration.Servers))
+	return nil
+}
@@ -0,0 +1,139 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/config"
+	"time"
+)
+
+// Steps of a replacement.
+const (
+	ReplaceAdding     = "adding"
+	ReplaceCatchingUp = "catching-up"
+	ReplacePromoting  = "promoting"
+	ReplaceRemoving   = "removing"
+	ReplaceDone       = "done"
+	ReplaceFailed     = "failed"
+)
+
+var (
+	// ErrReplaceNotFound is returned when a node hasn't been replaced by this node.
+	ErrReplaceNotFound = errcode.New(errcode.NotFound, "there isn't a replacement of that node")
+	// ErrReplaceInProgress is returned when replacing a node which is already being replaced.
+	ErrReplaceInProgress = errcode.New(errcode.Conflict, "node is already being replaced")
+)
+
+// ReplaceProgress is the progress of the replacement of a node by a new one.
+type ReplaceProgress struct {
+	OldID     string    `json:"oldID"`
+	NewID     string    `json:"newID"`
+	Step      string    `json:"step"`
+	Message   string    `json:"message"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Replace starts replacing a node of the cluster by a new one, it must be called on the leader.
+//
+// The new node is added as non-voter, so the quorum doesn't depend on it while it catches up.
+// Once it applied all the logs committed when it was added, it's promoted to voter,
+// and the old node is demoted and removed. The old node doesn't need to be running.
+//
+// It runs in the background, its progress can be checked with GetReplace.
+func (n *Node) Replace(oldID string, newID string, catchUpTimeout time.Duration) (ReplaceProgress, error) {
+	if n.Consensus.State() != raft.Leader {
+		return ReplaceProgress{}, ErrNotLeader
+	}
+	if oldID == n.ID {
+		return ReplaceProgress{}, errors.New("the leader can't be replaced, transfer the leadership first")
+	}
+	if newID == "" || newID == oldID {
+		return ReplaceProgress{}, errors.New("the new node must be a different node")
+	}
+	if !n.isNodeInConsensusServers(oldID) {
+		return ReplaceProgress{}, fmt.Errorf("node '%s' isn't part of the consensus", oldID)
+	}
+
+	n.Lock()
+	if n.replacements == nil {
+		n.replacements = make(map[string]*ReplaceProgress)
+	}
+	if p, ok := n.replacements[oldID]; ok && p.Step != ReplaceDone && p.Step != ReplaceFailed {
+		n.Unlock()
+		return *p, ErrReplaceInProgress
+	}
+	now := clock.Now()
+	progress := &ReplaceProgress{OldID: oldID, NewID: newID, StartedAt: now, UpdatedAt: now}
+	n.replacements[oldID] = progress
+	n.Unlock()
+
+	n.setReplaceStep(progress, ReplaceAdding, fmt.Sprintf("adding node '%s' as non-voter", newID))
+	go n.replace(progress, catchUpTimeout)
+	return n.GetReplace(oldID)
+}
+
+// GetReplace returns the progress of a node's replacement started on this node.
+func (n *Node) GetReplace(oldID string) (ReplaceProgress, error) {
+	n.RLock()
+	defer n.RUnlock()
+	p, ok := n.replacements[oldID]
+	if !ok {
+		return ReplaceProgress{}, ErrReplaceNotFound
+	}
+	return *p, nil
+}
+
+func (n *Node) replace(progress *ReplaceProgress, catchUpTimeout time.Duration) {
+	newID := raft.ServerID(progress.NewID)
+	newAddr := raft.ServerAddress(config.MakeConsensusAddr(progress.NewID))
+	// If the new node already joined as voter, it's kept as voter.
+	errAdd := n.Consensus.AddNonvoter(newID, newAddr, 0, 0).Error()
+	if errAdd != nil {
+		n.setReplaceStep(progress, ReplaceFailed, "couldn't add the new node: "+errAdd.Error())
+		return
+	}
+
+	target := n.ApplyLag().CommitIndex
+	n.setReplaceStep(progress, ReplaceCatchingUp, fmt.Sprintf("waiting for the new node to apply index %v", target))
+	errCatchUp := waitForApplied(progress.NewID, target, catchUpTimeout)
+	if errCatchUp != nil {
+		// The old node is kept, the replacement can be started again once the new node is reachable.
+		n.setReplaceStep(progress, ReplaceFailed, "the new node didn't catch up, it's kept as non-voter: "+errCatchUp.Error())
+		return
+	}
+
+	n.setReplaceStep(progress, ReplacePromoting, "promoting the new node to voter")
+	errPromote := n.Consensus.AddVoter(newID, newAddr, 0, 0).Error()
+	if errPromote != nil {
+		n.setReplaceStep(progress, ReplaceFailed, "couldn't promote the new node: "+errPromote.Error())
+		return
+	}
+
+	n.setReplaceStep(progress, ReplaceRemoving, fmt.Sprintf("demoting and removing node '%s'", progress.OldID))
+	oldID := raft.ServerID(progress.OldID)
+	errDemote := n.Consensus.DemoteVoter(oldID, 0, 0).Error()
+	if errDemote != nil {
+		n.setReplaceStep(progress, ReplaceFailed, "couldn't demote the old node: "+errDemote.Error())
+		return
+	}
+	errRemove := n.Consensus.RemoveServer(oldID, 0, 0).Error()
+	if errRemove != nil {
+		n.setReplaceStep(progress, ReplaceFailed, "couldn't remove the old node: "+errRemove.Error())
+		return
+	}
+	n.RemoveFromShards(progress.OldID)
+
+	n.setReplaceStep(progress, ReplaceDone, fmt.Sprintf("node '%s' replaced by '%s'", progress.OldID, progress.NewID))
+}
+
+func (n *Node) setReplaceStep(progress *ReplaceProgress, step string, msg string) {
+	n.Lock()
+	defer n.Unlock()
+	progress.Step = step
+	progress.Message = msg
+	progress.UpdatedAt = clock.Now()
+	n.logger.Info(fmt.Sprintf("replacement of '%s': %s", progress.OldID, msg))
+}
@@ -0,0 +1,244 @@
+package consensus
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"nubedb/cluster/shard"
+	"nubedb/internal/config"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ShardsDirName is the name of the directory, inside each of the node's dirs, where the data of the shards is stored.
+const ShardsDirName = "shards"
+
+// ErrShardNotFound is returned when a shard doesn't exist in this node.
+var ErrShardNotFound = errcode.New(errcode.NotFound, "shard not found")
+
+// Shard is a consensus group of the node, which stores the keys the ring assigns to it.
+//
+// Shard 0 is the node's main consensus, the other shards are hosted by the same nodes, each one on its own port.
+type Shard struct {
+	ID        int
+	Consensus *raft.Raft
+	FSM       *fsm.DatabaseFSM
+	// replicas caches the servers of the shard's consensus, it's reloaded when the shard applies new logs.
+	replicasMu    sync.Mutex
+	replicas      []raft.Server
+	replicasIndex uint64
+}
+
+// Replicas returns the servers of the shard's consensus.
+func (s *Shard) Replicas() ([]raft.Server, error) {
+	s.replicasMu.Lock()
+	defer s.replicasMu.Unlock()
+	applied := s.Consensus.AppliedIndex()
+	if s.replicas != nil && s.replicasIndex == applied {
+		return s.replicas, nil
+	}
+	future := s.Consensus.GetConfiguration()
+	if future.Error() != nil {
+		return nil, future.Error()
+	}
+	s.replicas = future.Configuration().Servers
+	s.replicasIndex = applied
+	return s.replicas, nil
+}
+
+// HasReplica returns whether a node is one of the servers of the shard's consensus.
+func (s *Shard) HasReplica(id string) bool {
+	servers, errServers := s.Replicas()
+	if errServers != nil {
+		return false
+	}
+	for _, srv := range servers {
+		if string(srv.ID) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Shards returns all the shards of the node, ordered by their ID.
+func (n *Node) Shards() []*Shard {
+	return n.shards
+}
+
+// Shard returns a shard of the node by its ID.
+func (n *Node) Shard(id int) (*Shard, error) {
+	if id < 0 || id >= len(n.shards) {
+		return nil, fmt.Errorf("%w: %v", ErrShardNotFound, id)
+	}
+	return n.shards[id], nil
+}
+
+// ShardFor returns the shard which stores a key.
+func (n *Node) ShardFor(key string) *Shard {
+	return n.shards[n.ShardMap().Locate(n.ring, shard.SlotOf(key))]
+}
+
+// ShardMap returns the placement of the moved slots, it's reloaded from shard 0 when it applies new logs.
+func (n *Node) ShardMap() shard.Map {
+	n.shardMapMu.Lock()
+	defer n.shardMapMu.Unlock()
+	if !n.IsSharded() {
+		return n.shardMap
+	}
+	applied := n.Consensus.AppliedIndex()
+	if applied == n.shardMapIndex {
+		return n.shardMap
+	}
+	m, errMap := n.FSM.ShardMap()
+	if errMap != nil {
+		n.logger.Error("couldn't read the shard map, using the previous one: " + errMap.Error())
+		return n.shardMap
+	}
+	n.shardMap = m
+	n.shardMapIndex = applied
+	return m
+}
+
+// IsSharded returns whether the keyspace is partitioned across more than one shard.
+func (n *Node) IsSharded() bool {
+	return len(n.shards) > 1
+}
+
+// startShards starts the consensus of every shard other than shard 0, joining this node to them.
+//
+// Shard 0 must have been started, since the node joins the shards through its leader.
+func (n *Node) startShards(cfg config.Config) error {
+	n.ring = shard.NewRing(cfg.Sharding.Shards)
+	n.shards = []*Shard{{ID: 0, Consensus: n.Consensus, FSM: n.FSM}}
+	for id := 1; id < n.ring.Shards(); id++ {
+		s, errShard := n.startShard(id, cfg)
+		if errShard != nil {
+			return errorskit.Wrap(errShard, "shard "+strconv.Itoa(id))
+		}
+		n.shards = append(n.shards, s)
+	}
+	return nil
+}
+
+// startShard creates the consensus of a shard, with its own storage, logs and snapshots inside the shards dirs.
+func (n *Node) startShard(id int, cfg config.Config) (*Shard, error) {
+	const retainedSnapshots = 3
+	paths := n.paths.Shard(id)
+	errDirs := createDirs(paths)
+	if errDirs != nil {
+		return nil, errDirs
+	}
+
+	f := fsm.NewWitness()
+	if !n.witness {
+		var errFSM error
+		f, errFSM = newFSM(paths.Storage, cfg.Storage, cfg.Encryption)
+		if errFSM != nil {
+			return nil, errFSM
+		}
+	}
+
+	transport, errTransport := newTCPTransport(config.MakeShardConsensusAddr(n.ID, id))
+	if errTransport != nil {
+		return nil, errTransport
+	}
+	dbStore, errStore := OpenLogStore(paths.LogStore(cfg.Consensus.LogStore), cfg.Consensus.LogStore, cfg.Encryption, false)
+	if errStore != nil {
+		return nil, errStore
+	}
+	snaps, errSnapStore := raft.NewFileSnapshotStore(paths.Snapshots, retainedSnapshots, os.Stderr)
+	if errSnapStore != nil {
+		return nil, errorskit.Wrap(errSnapStore, "couldn't create consensus snapshot storage")
+	}
+
+	existing, errExisting := raft.HasExistingState(dbStore, dbStore, snaps)
+	if errExisting != nil {
+		return nil, errorskit.Wrap(errExisting, "couldn't check the consensus state")
+	}
+
+	raftCfg := NewRaftConfig(n.ID)
+	applyTuning(raftCfg, n.tuning)
+	raftCfg.LogOutput = newConsensusFilterWriter()
+	raftCfg.Logger = n.logger.Named("shard-" + strconv.Itoa(id))
+	r, errRaft := raft.NewRaft(raftCfg, f, dbStore, dbStore, snaps, transport)
+	if errRaft != nil {
+		return nil, errorskit.Wrap(errRaft, "couldn't create new consensus")
+	}
+	s := &Shard{ID: id, Consensus: r, FSM: f}
+	cluster.RegisterShard(id, r)
+	n.registerEventsObserver(id, r)
+	if n.witness {
+		go n.stepDownShardIfWitness(s)
+	}
+
+	if !existing {
+		errJoin := n.joinShard(s)
+		if errJoin != nil {
+			return nil, errJoin
+		}
+	}
+	return s, nil
+}
+
+// joinShard adds the node to a new shard's consensus.
+//
+// The nodes which bootstrap shard 0 also bootstrap the other shards with the same nodes, the rest of the nodes join them
+// through the leader of shard 0, which forwards the request to the leader of the shard.
+func (n *Node) joinShard(s *Shard) error {
+	const bootstrappingLeader = "bootstrap-node"
+	addr := config.MakeShardConsensusAddr(n.ID, s.ID)
+	if n.bootstrapExpect == 0 && n.ID == bootstrappingLeader {
+		servers := []raft.Server{{ID: raft.ServerID(n.ID), Address: raft.ServerAddress(addr)}}
+		return s.Consensus.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	}
+	if len(n.bootstrapIDs) > 0 {
+		return s.Consensus.BootstrapCluster(raft.Configuration{Servers: bootstrapServers(n.bootstrapIDs, s.ID)}).Error()
+	}
+
+	leaderID, errSearchLeader := n.SearchLeader()
+	if errSearchLeader != nil {
+		return errSearchLeader
+	}
+	return cluster.ShardJoin(s.ID, n.ID, addr, config.MakeGrpcAddress(leaderID))
+}
+
+// RemoveFromShards removes a node from the consensus of every shard other than shard 0.
+//
+// The shards this node isn't the leader of are asked to their leaders. It's best effort, the errors are only logged.
+func (n *Node) RemoveFromShards(id string) {
+	for _, s := range n.shards[1:] {
+		var errRemove error
+		_, leaderID := s.Consensus.LeaderWithID()
+		switch {
+		case s.Consensus.State() == raft.Leader:
+			errRemove = s.Consensus.RemoveServer(raft.ServerID(id), 0, 0).Error()
+		case leaderID == "":
+			errRemove = cluster.ErrNoLeader
+		default:
+			errRemove = cluster.ShardRemove(s.ID, id, config.MakeGrpcAddress(string(leaderID)))
+		}
+		if errRemove != nil {
+			n.logger.Error("couldn't remove node from shard", "node", id, "shard", s.ID, "error", errRemove)
+		}
+	}
+}
+
+// stepDownShardIfWitness transfers the leadership of a shard to another node every time a witness is elected,
+// since it can't serve the data. Blocks indefinitely.
+func (n *Node) stepDownShardIfWitness(s *Shard) {
+	logger := n.logger.Named("shard-" + strconv.Itoa(s.ID))
+	for isLeader := range s.Consensus.LeaderCh() {
+		if !isLeader {
+			continue
+		}
+		logger.Warn("witness node elected as leader, transferring leadership")
+		errTransfer := s.Consensus.LeadershipTransfer().Error()
+		if errTransfer != nil {
+			logger.Error("couldn't transfer leadership from witness node: " + errTransfer.Error())
+		}
+	}
+}
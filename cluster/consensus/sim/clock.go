@@ -0,0 +1,59 @@
+package sim
+
+import (
+	"sync"
+	"time"
+)
+
+// VirtualClock is a clock which only advances when the simulation advances it, it times the events of the trace.
+//
+// It implements consensus.Clock, but raft's own timers can't use it, so it doesn't make a cluster deterministic.
+type VirtualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a Sleep call waiting until the clock reaches a time.
+type waiter struct {
+	until time.Time
+	done  chan struct{}
+}
+
+// NewVirtualClock returns a virtual clock starting at start.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the virtual time.
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep blocks until the virtual time is advanced by d.
+func (c *VirtualClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	w := waiter{until: c.now.Add(d), done: make(chan struct{})}
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+	<-w.done
+}
+
+// Advance moves the virtual time forward, waking up the sleeps which ended.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.until.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		close(w.done)
+	}
+	c.waiters = pending
+}
@@ -0,0 +1,325 @@
+// Package sim runs an in-process cluster under a simulator, to look for rare election and ordering bugs.
+//
+// The faults and the writes are chosen by a RNG seeded with Config.Seed, so running a simulation with the same seed
+// schedules the same sequence of events. It isn't deterministic though: the nodes talk through raft's in-memory
+// transports on their own goroutines, the steps are real sleeps, and raft's election and heartbeat timers use
+// the system's clock, so how the cluster reacts to the events, and the violations found, can differ between runs.
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"math/rand"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"strconv"
+	"time"
+)
+
+// Config configures a simulation.
+type Config struct {
+	Seed  int64
+	Nodes int
+	Steps int
+	// Step is the time between events, the simulation sleeps for it, and advances the clock of the trace by it.
+	Step time.Duration
+}
+
+// Report is the outcome of a simulation.
+type Report struct {
+	Seed int64
+	// Trace is the sequence of events of the simulation.
+	Trace []string
+	// Violations are the broken invariants, the simulation passed if it's empty.
+	Violations []string
+}
+
+// simNode is a node of the simulation, its stores outlive restarts like a disk would.
+type simNode struct {
+	id        string
+	addr      raft.ServerAddress
+	raft      *raft.Raft
+	fsm       *fsm.DatabaseFSM
+	store     *raft.InmemStore
+	snaps     *raft.InmemSnapshotStore
+	transport *raft.InmemTransport
+}
+
+// simulation is the state of a running simulation.
+type simulation struct {
+	cfg         Config
+	rng         *rand.Rand
+	clock       *VirtualClock
+	nodes       []*simNode
+	partitioned map[int]bool
+	report      *Report
+	// leaders holds the leader seen in each term, to check there's only one.
+	leaders map[string]string
+}
+
+// Run runs a simulation, returning its report.
+//
+// An error is only returned if the simulation couldn't be run, broken invariants are reported as violations.
+func Run(cfg Config) (*Report, error) {
+	if cfg.Nodes < 1 || cfg.Steps < 1 || cfg.Step <= 0 {
+		return nil, errors.New("the simulation needs at least one node, one step, and a step duration")
+	}
+
+	s := &simulation{
+		cfg:         cfg,
+		rng:         rand.New(rand.NewSource(cfg.Seed)),
+		clock:       NewVirtualClock(time.Unix(0, 0).UTC()),
+		partitioned: make(map[int]bool),
+		report:      &Report{Seed: cfg.Seed},
+		leaders:     make(map[string]string),
+	}
+	errStart := s.start()
+	if errStart != nil {
+		return nil, errStart
+	}
+	defer s.shutdown()
+
+	for step := 1; step <= cfg.Steps; step++ {
+		s.clock.Advance(cfg.Step)
+		time.Sleep(cfg.Step)
+		errStep := s.step(step)
+		if errStep != nil {
+			return nil, errStep
+		}
+		s.checkElectionSafety()
+	}
+
+	s.heal()
+	s.checkConvergence()
+	return s.report, nil
+}
+
+// start creates the nodes, and bootstraps the cluster with all of them.
+func (s *simulation) start() error {
+	servers := make([]raft.Server, 0, s.cfg.Nodes)
+	for i := 0; i < s.cfg.Nodes; i++ {
+		id := "node" + strconv.Itoa(i)
+		dbFSM, errFSM := fsm.New(engine.NewMemory(), 0, 0)
+		if errFSM != nil {
+			return errFSM
+		}
+		n := &simNode{
+			id:    id,
+			addr:  raft.ServerAddress(id),
+			fsm:   dbFSM,
+			store: raft.NewInmemStore(),
+			snaps: raft.NewInmemSnapshotStore(),
+		}
+		s.nodes = append(s.nodes, n)
+		servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: n.addr})
+	}
+
+	for i, n := range s.nodes {
+		errBoot := raft.BootstrapCluster(
+			s.raftConfig(n), n.store, n.store, n.snaps, s.newTransport(i), raft.Configuration{Servers: servers},
+		)
+		if errBoot != nil {
+			return fmt.Errorf("couldn't bootstrap %s: %w", n.id, errBoot)
+		}
+		errStart := s.startNode(i)
+		if errStart != nil {
+			return errStart
+		}
+	}
+	return nil
+}
+
+func (s *simulation) startNode(i int) error {
+	n := s.nodes[i]
+	n.transport = s.newTransport(i)
+	s.connect(i)
+	r, errRaft := raft.NewRaft(s.raftConfig(n), n.fsm, n.store, n.store, n.snaps, n.transport)
+	if errRaft != nil {
+		return fmt.Errorf("couldn't start %s: %w", n.id, errRaft)
+	}
+	n.raft = r
+	return nil
+}
+
+// raftConfig returns the node's configuration, with timeouts short enough for the simulation's steps.
+func (s *simulation) raftConfig(n *simNode) *raft.Config {
+	cfg := consensus.NewRaftConfig(n.id)
+	cfg.HeartbeatTimeout = 2 * s.cfg.Step
+	cfg.ElectionTimeout = 2 * s.cfg.Step
+	cfg.LeaderLeaseTimeout = s.cfg.Step
+	cfg.CommitTimeout = s.cfg.Step / 10
+	cfg.Logger = hclog.NewNullLogger()
+	return cfg
+}
+
+func (s *simulation) newTransport(i int) *raft.InmemTransport {
+	_, t := raft.NewInmemTransport(s.nodes[i].addr)
+	return t
+}
+
+// step runs the event of a step, chosen by the RNG.
+func (s *simulation) step(step int) error {
+	roll := s.rng.Intn(100)
+	target := s.rng.Intn(len(s.nodes))
+	key := "k" + strconv.Itoa(s.rng.Intn(5))
+
+	switch {
+	case roll < 60:
+		s.trace(step, fmt.Sprintf("write %s=%v", key, step))
+		s.write(key, step)
+	case roll < 75:
+		// A minority is partitioned at most, so the cluster can keep making progress.
+		if s.partitioned[target] || len(s.partitioned) >= (len(s.nodes)-1)/2 {
+			return nil
+		}
+		s.trace(step, "partition "+s.nodes[target].id)
+		s.partitioned[target] = true
+		s.disconnect(target)
+	case roll < 85:
+		if len(s.partitioned) == 0 {
+			return nil
+		}
+		s.trace(step, "heal")
+		s.heal()
+	case roll < 95:
+		s.trace(step, "restart "+s.nodes[target].id)
+		errShutdown := s.nodes[target].raft.Shutdown().Error()
+		if errShutdown != nil {
+			return errShutdown
+		}
+		s.disconnect(target)
+		return s.startNode(target)
+	}
+	return nil
+}
+
+// write applies a write on the current leader, a failed write is part of the simulation, not an error.
+func (s *simulation) write(key string, value int) {
+	leader := s.leader()
+	if leader == nil {
+		return
+	}
+	b, errEncode := fsm.EncodePayload(&fsm.Payload{Key: key, Value: value, Operation: "SET"})
+	if errEncode != nil {
+		return
+	}
+	_ = leader.raft.Apply(b, 10*s.cfg.Step).Error()
+}
+
+func (s *simulation) leader() *simNode {
+	for i, n := range s.nodes {
+		if !s.partitioned[i] && n.raft.State() == raft.Leader {
+			return n
+		}
+	}
+	return nil
+}
+
+// connect connects a node to all the nodes which aren't partitioned, in both directions.
+func (s *simulation) connect(i int) {
+	if s.partitioned[i] {
+		return
+	}
+	for j, peer := range s.nodes {
+		if j == i || s.partitioned[j] || peer.transport == nil {
+			continue
+		}
+		s.nodes[i].transport.Connect(peer.addr, peer.transport)
+		peer.transport.Connect(s.nodes[i].addr, s.nodes[i].transport)
+	}
+}
+
+// disconnect disconnects a node from all the nodes, in both directions.
+func (s *simulation) disconnect(i int) {
+	s.nodes[i].transport.DisconnectAll()
+	for j, peer := range s.nodes {
+		if j != i {
+			peer.transport.Disconnect(s.nodes[i].addr)
+		}
+	}
+}
+
+// heal reconnects all the partitioned nodes.
+func (s *simulation) heal() {
+	s.partitioned = make(map[int]bool)
+	for i := range s.nodes {
+		s.connect(i)
+	}
+}
+
+// checkElectionSafety checks there's at most one leader in each term.
+func (s *simulation) checkElectionSafety() {
+	for _, n := range s.nodes {
+		if n.raft.State() != raft.Leader {
+			continue
+		}
+		term := n.raft.Stats()["term"]
+		leader, seen := s.leaders[term]
+		if seen && leader != n.id {
+			s.violation(fmt.Sprintf("two leaders in term %s: %s and %s", term, leader, n.id))
+		}
+		s.leaders[term] = n.id
+	}
+}
+
+// checkConvergence waits until all the nodes applied the same logs, and checks they have the same data.
+func (s *simulation) checkConvergence() {
+	const settleTimeout = 10 * time.Second
+	deadline := time.Now().Add(settleTimeout)
+	for time.Now().Before(deadline) && !s.applied() {
+		time.Sleep(s.cfg.Step)
+	}
+	if !s.applied() {
+		s.violation(fmt.Sprintf("the nodes didn't apply the same logs after %s", settleTimeout))
+		return
+	}
+
+	var expected []byte
+	for i, n := range s.nodes {
+		data, errBackup := n.fsm.BackupDB()
+		if errBackup != nil {
+			s.violation(fmt.Sprintf("couldn't read the data of %s: %v", n.id, errBackup))
+			return
+		}
+		if i > 0 && !bytes.Equal(data, expected) {
+			s.violation(fmt.Sprintf("%s has different data than %s", n.id, s.nodes[0].id))
+		}
+		expected = data
+	}
+}
+
+// applied returns whether there's a leader, and all the nodes applied its last log.
+func (s *simulation) applied() bool {
+	leader := s.leader()
+	if leader == nil {
+		return false
+	}
+	last := leader.raft.LastIndex()
+	for _, n := range s.nodes {
+		if n.raft.AppliedIndex() < last {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *simulation) shutdown() {
+	for _, n := range s.nodes {
+		if n.raft != nil {
+			_ = n.raft.Shutdown().Error()
+		}
+	}
+}
+
+func (s *simulation) trace(step int, event string) {
+	elapsed := s.clock.Now().Sub(time.Unix(0, 0))
+	s.report.Trace = append(s.report.Trace, fmt.Sprintf("step %v (+%s): %s", step, elapsed, event))
+}
+
+func (s *simulation) violation(msg string) {
+	s.report.Violations = append(s.report.Violations, msg)
+}
@@ -0,0 +1,58 @@
+package consensus
+
+import (
+	"github.com/narvikd/errorskit"
+	"io/fs"
+	"nubedb/cluster/consensus/fsm"
+	"os"
+	"path/filepath"
+)
+
+// StorageStats holds the disk usage of a node.
+type StorageStats struct {
+	FSM            fsm.StorageStats `json:"fsm"`
+	ConsensusBytes int64            `json:"consensusBytes"`
+	SnapshotsBytes int64            `json:"snapshotsBytes"`
+}
+
+// StorageStats returns the disk usage of the node's FSM, consensus logs and snapshots.
+func (n *Node) StorageStats() (StorageStats, error) {
+	stats := StorageStats{FSM: n.FSM.GetStorageStats()}
+
+	consensusBytes, errConsensus := dirSize(n.paths.LogStore(n.logStoreKind))
+	if errConsensus != nil {
+		return stats, errorskit.Wrap(errConsensus, "couldn't get consensus db size")
+	}
+	stats.ConsensusBytes = consensusBytes
+
+	snapshotsBytes, errSnapshots := dirSize(n.paths.SnapshotStore())
+	if errSnapshots != nil {
+		return stats, errorskit.Wrap(errSnapshots, "couldn't get snapshots size")
+	}
+	stats.SnapshotsBytes = snapshotsBytes
+
+	return stats, nil
+}
+
+// dirSize returns the size of all the files inside a directory, or of a single file, 0 if it doesn't exist.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, errWalk error) error {
+		if errWalk != nil {
+			if os.IsNotExist(errWalk) {
+				return nil
+			}
+			return errWalk
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, errInfo := d.Info()
+		if errInfo != nil {
+			return errInfo
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,133 @@
+package consensus
+
+import (
+	"errors"
+	"github.com/cockroachdb/pebble"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"github.com/narvikd/filekit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/internal/config"
+	"nubedb/pkg/encrypt"
+	"path/filepath"
+)
+
+// LogStore is the consensus' log and stable store.
+//
+// If encryption is enabled, the data of the logs is encrypted before being written to disk.
+type LogStore struct {
+	rawLogStore
+	keyring *encrypt.Keyring
+}
+
+// OpenBadger opens the badgerDB used by the FSM, with encryption at rest if it's enabled.
+func OpenBadger(dir string, enc config.EncryptionCfg) (*badger.DB, error) {
+	// Badger requires an index cache when encryption is enabled.
+	const encryptionIndexCacheSize = 100 << 20 // 100MB
+	opts := badger.DefaultOptions(dir)
+	if enc.Enabled() {
+		opts = opts.
+			WithEncryptionKey(enc.Key).
+			WithEncryptionKeyRotationDuration(enc.DataKeyRotation).
+			WithIndexCacheSize(encryptionIndexCacheSize)
+	}
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, errorskit.Wrap(err, "couldn't open badgerDB")
+	}
+	return db, nil
+}
+
+// OpenPebble opens the Pebble DB used by the FSM.
+//
+// Pebble doesn't support encryption at rest, so it can't be used if encryption is enabled.
+func OpenPebble(dir string, enc config.EncryptionCfg) (*pebble.DB, error) {
+	if enc.Enabled() {
+		return nil, errors.New("the pebble storage engine doesn't support encryption at rest")
+	}
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return nil, errorskit.Wrap(err, "couldn't open pebble DB")
+	}
+	return db, nil
+}
+
+// OpenSQLite opens the SQLite DB used by the FSM, a single file inside dir.
+//
+// SQLite doesn't support encryption at rest, so it can't be used if encryption is enabled.
+func OpenSQLite(dir string, enc config.EncryptionCfg) (engine.Engine, error) {
+	if enc.Enabled() {
+		return nil, errors.New("the sqlite storage engine doesn't support encryption at rest")
+	}
+	errDir := filekit.CreateDirs(dir, false)
+	if errDir != nil {
+		return nil, errDir
+	}
+	return engine.OpenSQLite(filepath.Join(dir, SQLiteDBName))
+}
+
+// OpenLogStore opens a kind of consensus' log store, with encryption if it's enabled.
+//
+// readOnly is meant for offline tools, which need to read the logs of a stopped node.
+func OpenLogStore(path string, kind string, enc config.EncryptionCfg, readOnly bool) (*LogStore, error) {
+	store, errStore := openRawLogStore(path, kind, enc, readOnly)
+	if errStore != nil {
+		return nil, errStore
+	}
+
+	s := &LogStore{rawLogStore: store}
+	if enc.Enabled() {
+		keyring, errKeyring := encrypt.NewKeyring(enc.Key, enc.OldKeys...)
+		if errKeyring != nil {
+			_ = store.Close()
+			return nil, errKeyring
+		}
+		s.keyring = keyring
+	}
+
+	return s, nil
+}
+
+// GetLog gets a log entry at a given index, decrypting it if needed.
+func (s *LogStore) GetLog(index uint64, log *raft.Log) error {
+	errGet := s.rawLogStore.GetLog(index, log)
+	if errGet != nil || s.keyring == nil {
+		return errGet
+	}
+
+	data, errDecrypt := s.keyring.Decrypt(log.Data)
+	if errDecrypt != nil {
+		return errorskit.Wrap(errDecrypt, "couldn't decrypt consensus log")
+	}
+	log.Data = data
+	return nil
+}
+
+// StoreLog stores a log entry, encrypting it if needed.
+func (s *LogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries, encrypting them if needed.
+//
+// The logs are copied before being encrypted, since consensus keeps using them.
+func (s *LogStore) StoreLogs(logs []*raft.Log) error {
+	if s.keyring == nil {
+		return s.rawLogStore.StoreLogs(logs)
+	}
+
+	encrypted := make([]*raft.Log, 0, len(logs))
+	for _, l := range logs {
+		data, errEncrypt := s.keyring.Encrypt(l.Data)
+		if errEncrypt != nil {
+			return errorskit.Wrap(errEncrypt, "couldn't encrypt consensus log")
+		}
+		c := *l
+		c.Data = data
+		encrypted = append(encrypted, &c)
+	}
+
+	return s.rawLogStore.StoreLogs(encrypted)
+}
@@ -0,0 +1,158 @@
+package consensus
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/raft"
+	"nubedb/internal/config"
+	"sync"
+	"time"
+)
+
+const (
+	// RoleLeader is the role of the node which is the leader of shard 0.
+	RoleLeader = "leader"
+	// RoleFollower is the role of a voter which isn't the leader.
+	RoleFollower = "follower"
+	// RoleNonVoter is the role of a node which replicates the logs without voting.
+	RoleNonVoter = "nonvoter"
+	// RoleWitness is the role of a node which votes without storing data.
+	RoleWitness = "witness"
+)
+
+// Topology is a graph of the cluster: its nodes, and the replication edges between them.
+type Topology struct {
+	Leader string         `json:"leader"`
+	Nodes  []TopologyNode `json:"nodes"`
+	// Shards is the placement of the shards, it only has shard 0 when sharding isn't enabled.
+	Shards []ShardInfo    `json:"shards"`
+	Edges  []TopologyEdge `json:"edges"`
+	At     time.Time      `json:"at"`
+}
+
+// TopologyNode is a node of the cluster, as reported by its own status.
+type TopologyNode struct {
+	ID        string `json:"id"`
+	Address   string `json:"address"`
+	Role      string `json:"role"`
+	Reachable bool   `json:"reachable"`
+	// Error is why the node's status couldn't be retrieved, when it's unreachable.
+	Error string   `json:"error,omitempty"`
+	Apply ApplyLag `json:"apply"`
+	// ReplicationLag is how many logs the node has applied fewer than the leader has committed.
+	ReplicationLag uint64 `json:"replicationLag"`
+	// LastContact is the time since the node last heard from the leader, as reported by raft ("0" on the leader).
+	LastContact string `json:"lastContact"`
+}
+
+// TopologyEdge is the replication of a shard from its leader to one of its replicas.
+type TopologyEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Shard int    `json:"shard"`
+}
+
+// nodeStatus is the part of a node's cluster/status used by the topology.
+type nodeStatus struct {
+	Witness     bool     `json:"witness"`
+	Apply       ApplyLag `json:"apply"`
+	LastContact string   `json:"lastContact"`
+}
+
+// Topology returns the graph of the cluster, asking every node of the consensus for its status.
+//
+// The nodes which don't answer are included as unreachable, so the topology is returned even without quorum.
+func (n *Node) Topology() (Topology, error) {
+	future := n.Consensus.GetConfiguration()
+	if future.Error() != nil {
+		return Topology{}, future.Error()
+	}
+	servers := future.Configuration().Servers
+	_, leaderID := n.Consensus.LeaderWithID()
+
+	nodes := make([]TopologyNode, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv raft.Server) {
+			defer wg.Done()
+			nodes[i] = getTopologyNode(srv, leaderID)
+		}(i, srv)
+	}
+	wg.Wait()
+
+	// The lag is measured against the leader's commit index, or this node's one if the leader didn't answer.
+	commitIndex := n.ApplyLag().CommitIndex
+	for _, node := range nodes {
+		if node.ID == string(leaderID) && node.Reachable {
+			commitIndex = node.Apply.CommitIndex
+		}
+	}
+	for i := range nodes {
+		if nodes[i].Reachable && commitIndex > nodes[i].Apply.AppliedIndex {
+			nodes[i].ReplicationLag = commitIndex - nodes[i].Apply.AppliedIndex
+		}
+	}
+
+	shards, errShards := n.ShardsInfo()
+	if errShards != nil {
+		return Topology{}, errShards
+	}
+	var edges []TopologyEdge
+	for _, s := range shards.Shards {
+		for _, replica := range s.Replicas {
+			if s.Leader != "" && replica != s.Leader {
+				edges = append(edges, TopologyEdge{From: s.Leader, To: replica, Shard: s.ID})
+			}
+		}
+	}
+
+	return Topology{
+		Leader: string(leaderID),
+		Nodes:  nodes,
+		Shards: shards.Shards,
+		Edges:  edges,
+		At:     clock.Now(),
+	}, nil
+}
+
+func getTopologyNode(srv raft.Server, leaderID raft.ServerID) TopologyNode {
+	node := TopologyNode{ID: string(srv.ID), Address: string(srv.Address), Role: RoleFollower}
+	switch {
+	case srv.Suffrage != raft.Voter:
+		node.Role = RoleNonVoter
+	case srv.ID == leaderID:
+		node.Role = RoleLeader
+	}
+
+	status, errStatus := getNodeStatus(string(srv.ID))
+	if errStatus != nil {
+		node.Error = errStatus.Error()
+		return node
+	}
+	node.Reachable = true
+	node.Apply = status.Apply
+	node.LastContact = status.LastContact
+	if status.Witness {
+		node.Role = RoleWitness
+	}
+	return node
+}
+
+func getNodeStatus(id string) (nodeStatus, error) {
+	const timeout = 2 * time.Second
+	client := config.NewNodeAPIClient(timeout)
+	res, errGet := client.Get(config.MakeApiURL(id, "/cluster/status"))
+	if errGet != nil {
+		return nodeStatus{}, errGet
+	}
+	defer res.Body.Close()
+
+	var status struct {
+		Data nodeStatus `json:"data"`
+	}
+	errDecode := json.NewDecoder(res.Body).Decode(&status)
+	if errDecode != nil {
+		return nodeStatus{}, errDecode
+	}
+	return status.Data, nil
+}
@@ -0,0 +1,29 @@
+package consensus
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"net"
+	"os"
+	"time"
+)
+
+// newTCPTransport creates a TCP transport listening on address.
+func newTCPTransport(address string) (raft.Transport, error) {
+	const (
+		timeout            = 10 * time.Second
+		maxConnectionsPool = 10
+	)
+
+	// Resolve the TCP address for use in Raft's consensus.
+	tcpAddr, errAddr := net.ResolveTCPAddr("tcp", address)
+	if errAddr != nil {
+		return nil, errorskit.Wrap(errAddr, "couldn't resolve addr")
+	}
+
+	transport, errTransport := raft.NewTCPTransport(address, tcpAddr, maxConnectionsPool, timeout, os.Stderr)
+	if errTransport != nil {
+		return nil, errorskit.Wrap(errTransport, "couldn't create transport")
+	}
+	return transport, nil
+}
@@ -0,0 +1,37 @@
+package consensus
+
+import (
+	"fmt"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/internal/config"
+)
+
+// applyTuning sets the settings of a consensus configuration which can be changed at runtime.
+func applyTuning(raftCfg *raft.Config, c config.ConsensusCfg) {
+	raftCfg.SnapshotInterval = c.SnapshotInterval
+	raftCfg.SnapshotThreshold = c.SnapshotThreshold
+	raftCfg.TrailingLogs = c.TrailingLogs
+}
+
+// Tune applies the settings of the consensus which can be changed at runtime to every shard,
+// without restarting the node: the level of the logs and the snapshot tuning.
+func (n *Node) Tune(c config.ConsensusCfg) error {
+	n.logger.SetLevel(hclog.LevelFromString(c.LogLevel))
+	for _, s := range n.shards {
+		rc := s.Consensus.ReloadableConfig()
+		rc.SnapshotInterval = c.SnapshotInterval
+		rc.SnapshotThreshold = c.SnapshotThreshold
+		rc.TrailingLogs = c.TrailingLogs
+		errReload := s.Consensus.ReloadConfig(rc)
+		if errReload != nil {
+			return errorskit.Wrap(errReload, fmt.Sprintf("couldn't tune the consensus of shard %v", s.ID))
+		}
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	n.tuning = c
+	return nil
+}
@@ -0,0 +1,53 @@
+package consensus
+
+import (
+	"context"
+	"github.com/hashicorp/raft"
+)
+
+// LeaderVerification is the result of checking that a node is still the leader of a shard.
+type LeaderVerification struct {
+	NodeID   string `json:"nodeID"`
+	Shard    int    `json:"shard"`
+	Verified bool   `json:"verified"`
+	// Latency is how long it took to contact a quorum, or to give up.
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// VerifyLeader checks that the node is still the leader of a shard, by contacting a quorum of its nodes,
+// so a node which lost its quorum, like one on the minority side of a partition, isn't trusted as the leader.
+//
+// It returns ErrNotLeader if the node doesn't consider itself the leader. It gives up when ctx is done.
+func (n *Node) VerifyLeader(ctx context.Context, shardID int) (LeaderVerification, error) {
+	s, errShard := n.Shard(shardID)
+	if errShard != nil {
+		return LeaderVerification{}, errShard
+	}
+	if s.Consensus.State() != raft.Leader {
+		return LeaderVerification{}, ErrNotLeader
+	}
+
+	start := clock.Now()
+	verified := make(chan error, 1)
+	go func() {
+		verified <- s.Consensus.VerifyLeader().Error()
+	}()
+	var errVerify error
+	select {
+	case errVerify = <-verified:
+	case <-ctx.Done():
+		errVerify = ctx.Err()
+	}
+
+	v := LeaderVerification{
+		NodeID:   n.ID,
+		Shard:    shardID,
+		Verified: errVerify == nil,
+		Latency:  clock.Now().Sub(start).String(),
+	}
+	if errVerify != nil {
+		v.Error = errVerify.Error()
+	}
+	return v, nil
+}
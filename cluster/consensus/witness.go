@@ -0,0 +1,23 @@
+package consensus
+
+import (
+	"github.com/hashicorp/raft"
+)
+
+// IsWitness returns whether the node is a witness, which only votes in the consensus without storing data.
+func (n *Node) IsWitness() bool {
+	return n.witness
+}
+
+// stepDownIfWitness transfers the leadership to another node if a witness was elected as the leader,
+// since it can't serve the data.
+func (n *Node) stepDownIfWitness(state raft.RaftState) {
+	if !n.witness || state != raft.Leader {
+		return
+	}
+	n.logger.Warn("witness node elected as leader, transferring leadership")
+	errTransfer := n.Consensus.LeadershipTransfer().Error()
+	if errTransfer != nil {
+		n.logger.Error("couldn't transfer leadership from witness node: " + errTransfer.Error())
+	}
+}
@@ -0,0 +1,95 @@
+// Package diskwatch watches the free space of the node's data dirs, making the node read-only before one runs out,
+// since the storage engines and the consensus log store can be corrupted by a full disk.
+//
+// While the node is read-only, it refuses the writes it receives from the clients and, if it's a leader,
+// the ones forwarded to it. It keeps replicating the logs committed by the leader, so the consensus isn't broken.
+package diskwatch
+
+import (
+	"fmt"
+	"log"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/metrics"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrDiskFull is returned when a write is received while the node is low on disk space.
+var ErrDiskFull = errcode.New(errcode.DiskFull, "the node is low on disk space, it's read-only until space is freed")
+
+// low is whether the free space is under the minimum.
+var low atomic.Bool
+
+// Start checks the free space of dirs every interval, making the node read-only while any of them is under
+// minFree bytes, blocks indefinitely.
+//
+// The node accepts writes again once the free space of all of them exceeds minFree by 10%, so it doesn't flap around it.
+func Start(dirs []string, minFree uint64, interval time.Duration) {
+	if minFree == 0 || interval <= 0 {
+		return
+	}
+	resumeAt := minFree + minFree/10
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		check(dirs, minFree, resumeAt)
+		<-ticker.C
+	}
+}
+
+// check makes the node read-only if the free space of a dir is under minFree,
+// or writable if the one of every dir is above resumeAt.
+func check(dirs []string, minFree uint64, resumeAt uint64) {
+	lowest := ""
+	var lowestFree uint64
+	for _, dir := range dirs {
+		free, errFree := Free(dir)
+		if errFree != nil {
+			log.Println("[disk] couldn't check the free space:", errFree)
+			return
+		}
+		metrics.SetDiskFree(dir, free)
+		if lowest == "" || free < lowestFree {
+			lowest, lowestFree = dir, free
+		}
+	}
+	if lowest == "" {
+		return
+	}
+
+	switch {
+	case lowestFree < minFree && !low.Load():
+		low.Store(true)
+		metrics.SetDiskLow(true)
+		log.Printf("[disk] only %v bytes are free under '%s', the node is read-only until %v bytes are free\n",
+			lowestFree, lowest, resumeAt)
+	case lowestFree >= resumeAt && low.Load():
+		low.Store(false)
+		metrics.SetDiskLow(false)
+		log.Printf("[disk] %v bytes are free under '%s', the node accepts writes again\n", lowestFree, lowest)
+	}
+}
+
+// IsLow returns whether the node is low on disk space, and so read-only.
+func IsLow() bool {
+	return low.Load()
+}
+
+// Check returns ErrDiskFull if the node is low on disk space.
+func Check() error {
+	if low.Load() {
+		return ErrDiskFull
+	}
+	return nil
+}
+
+// Free returns the bytes available to the node in the filesystem of a path.
+func Free(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	errStat := syscall.Statfs(path, &stat)
+	if errStat != nil {
+		return 0, fmt.Errorf("couldn't stat the filesystem of '%s': %w", path, errStat)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,316 @@
+// Package errcode defines the machine-readable codes of nubedb's errors, so the clients can branch on them
+// instead of on their messages, and carries them across the nodes through gRPC.
+package errcode
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sync"
+)
+
+// Domain is the domain of the gRPC ErrorInfo details carrying the codes.
+const Domain = "nubedb"
+
+// leadershipMetadata is the key of the ErrorInfo metadata marking the errors due to a leadership change.
+const leadershipMetadata = "leadership"
+
+// Code is the machine-readable code of an error.
+type Code string
+
+const (
+	Internal         Code = "INTERNAL"
+	InvalidArgument  Code = "INVALID_ARGUMENT"
+	Unauthenticated  Code = "UNAUTHENTICATED"
+	PermissionDenied Code = "PERMISSION_DENIED"
+	NotFound         Code = "NOT_FOUND"
+	Conflict         Code = "CONFLICT"
+	NotEnabled       Code = "NOT_ENABLED"
+	NotLeader        Code = "NOT_LEADER"
+	SchemaViolation  Code = "SCHEMA_VIOLATION"
+	HookRejected     Code = "HOOK_REJECTED"
+	ScriptFailed     Code = "SCRIPT_FAILED"
+	NotAppendable    Code = "NOT_APPENDABLE"
+	Encrypted        Code = "ENCRYPTED"
+	ValueTooLarge    Code = "VALUE_TOO_LARGE"
+	QuotaExceeded    Code = "QUOTA_EXCEEDED"
+	KeyHeld          Code = "KEY_HELD"
+	ReservedKey      Code = "RESERVED_KEY"
+	DataCorrupted    Code = "DATA_CORRUPTED"
+	Aborted          Code = "ABORTED"
+	Unavailable      Code = "UNAVAILABLE"
+	Maintenance      Code = "MAINTENANCE"
+	Frozen           Code = "FROZEN"
+	ReadOnly         Code = "READ_ONLY"
+	SlotMoved        Code = "SLOT_MOVED"
+	RateLimited      Code = "RATE_LIMITED"
+	DiskFull         Code = "DISK_FULL"
+	DeadlineExceeded Code = "DEADLINE_EXCEEDED"
+	OutcomeUnknown   Code = "OUTCOME_UNKNOWN"
+)
+
+// definition is how a code is answered: its HTTP status, its gRPC code, and whether the request can be retried.
+type definition struct {
+	httpStatus int
+	grpcCode   codes.Code
+	retryable  bool
+}
+
+var definitions = map[Code]definition{
+	Internal:         {http.StatusInternalServerError, codes.Internal, false},
+	InvalidArgument:  {http.StatusBadRequest, codes.InvalidArgument, false},
+	Unauthenticated:  {http.StatusUnauthorized, codes.Unauthenticated, false},
+	PermissionDenied: {http.StatusForbidden, codes.PermissionDenied, false},
+	NotFound:         {http.StatusNotFound, codes.NotFound, false},
+	Conflict:         {http.StatusConflict, codes.Aborted, false},
+	NotEnabled:       {http.StatusBadRequest, codes.FailedPrecondition, false},
+	NotLeader:        {http.StatusBadRequest, codes.FailedPrecondition, false},
+	SchemaViolation:  {http.StatusBadRequest, codes.InvalidArgument, false},
+	HookRejected:     {http.StatusBadRequest, codes.InvalidArgument, false},
+	ScriptFailed:     {http.StatusBadRequest, codes.InvalidArgument, false},
+	NotAppendable:    {http.StatusBadRequest, codes.FailedPrecondition, false},
+	Encrypted:        {http.StatusBadRequest, codes.FailedPrecondition, false},
+	ValueTooLarge:    {http.StatusBadRequest, codes.InvalidArgument, false},
+	QuotaExceeded:    {http.StatusForbidden, codes.PermissionDenied, false},
+	KeyHeld:          {http.StatusForbidden, codes.PermissionDenied, false},
+	ReservedKey:      {http.StatusBadRequest, codes.InvalidArgument, false},
+	DataCorrupted:    {http.StatusInternalServerError, codes.DataLoss, false},
+	Aborted:          {http.StatusConflict, codes.Aborted, true},
+	Unavailable:      {http.StatusServiceUnavailable, codes.Unavailable, true},
+	Maintenance:      {http.StatusServiceUnavailable, codes.Unavailable, true},
+	Frozen:           {http.StatusServiceUnavailable, codes.Unavailable, true},
+	ReadOnly:         {http.StatusForbidden, codes.FailedPrecondition, false},
+	SlotMoved:        {http.StatusServiceUnavailable, codes.Unavailable, true},
+	RateLimited:      {http.StatusServiceUnavailable, codes.Unavailable, true},
+	DiskFull:         {http.StatusInsufficientStorage, codes.ResourceExhausted, true},
+	DeadlineExceeded: {http.StatusGatewayTimeout, codes.DeadlineExceeded, true},
+	OutcomeUnknown:   {http.StatusInternalServerError, codes.Unknown, false},
+}
+
+// fromGRPC is the code of the gRPC errors which don't carry one, like the ones of the connections.
+var fromGRPC = map[codes.Code]Code{
+	codes.InvalidArgument:    InvalidArgument,
+	codes.Unauthenticated:    Unauthenticated,
+	codes.PermissionDenied:   PermissionDenied,
+	codes.NotFound:           NotFound,
+	codes.AlreadyExists:      Conflict,
+	codes.FailedPrecondition: InvalidArgument,
+	codes.Aborted:            Aborted,
+	codes.DataLoss:           DataCorrupted,
+	codes.Unavailable:        Unavailable,
+	codes.ResourceExhausted:  Unavailable,
+	codes.DeadlineExceeded:   DeadlineExceeded,
+	codes.Canceled:           Aborted,
+}
+
+// Error is an error with a code.
+type Error struct {
+	Code    Code
+	Message string
+	// leadership marks the errors due to a leadership change, check NewLeadership.
+	leadership bool
+}
+
+// New creates an error with a code, it's meant for the sentinel errors, which are compared with errors.Is.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// NewLeadership is New for the errors due to a leadership change, which guarantee the write failing with them
+// wasn't appended to the consensus log, so it's safe to retry it. The mark is carried through gRPC with the code.
+func NewLeadership(code Code, message string) *Error {
+	return &Error{Code: code, Message: message, leadership: true}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// registered are the errors of other packages, which can't be created with New, with their codes.
+var registered = struct {
+	sync.RWMutex
+	errs map[error]Code
+	// leadership are the registered errors due to a leadership change.
+	leadership map[error]bool
+}{errs: make(map[error]Code), leadership: make(map[error]bool)}
+
+// Register sets the code of errors defined by other packages, like the ones of Raft.
+func Register(code Code, errs ...error) {
+	registered.Lock()
+	defer registered.Unlock()
+	for _, err := range errs {
+		registered.errs[err] = code
+	}
+}
+
+// RegisterLeadership is Register for the errors of other packages due to a leadership change, check NewLeadership.
+func RegisterLeadership(code Code, errs ...error) {
+	Register(code, errs...)
+	registered.Lock()
+	defer registered.Unlock()
+	for _, err := range errs {
+		registered.leadership[err] = true
+	}
+}
+
+// IsLeadership returns whether an error is due to a leadership change, check NewLeadership.
+//
+// The errors received from another node through gRPC are only if the node marked them,
+// the ones of the connection aren't, since they can be returned after the call was processed.
+func IsLeadership(err error) bool {
+	if err == nil {
+		return false
+	}
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.leadership
+	}
+	registered.RLock()
+	for e := range registered.leadership {
+		if errors.Is(err, e) {
+			registered.RUnlock()
+			return true
+		}
+	}
+	registered.RUnlock()
+	info, ok := errorInfo(err)
+	return ok && info.Metadata[leadershipMetadata] == "true"
+}
+
+// Carried returns whether an error received from another node through gRPC carries the code it had on it,
+// instead of being an error of the connection, or of a node which doesn't send them.
+func Carried(err error) bool {
+	_, ok := errorInfo(err)
+	return ok
+}
+
+// Of returns the code of an error.
+//
+// Errors received from another node through gRPC carry the code they had on it,
+// and the other gRPC errors get the code of their status. The errors without a code are Internal.
+func Of(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	if code, ok := registeredCode(err); ok {
+		return code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return DeadlineExceeded
+	}
+	if info, ok := errorInfo(err); ok {
+		return Code(info.Reason)
+	}
+	if st, ok := grpcStatus(err); ok {
+		if code, known := fromGRPC[st.Code()]; known {
+			return code
+		}
+	}
+	return Internal
+}
+
+// Message returns the message of an error, without the status added by gRPC if it came from another node.
+func Message(err error) string {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return err.Error()
+	}
+	if st, ok := grpcStatus(err); ok {
+		return st.Message()
+	}
+	return err.Error()
+}
+
+// Retryable returns whether a request which failed with a code can be retried as is, later or on another node.
+func Retryable(code Code) bool {
+	return definitions[code].retryable
+}
+
+// HTTPStatus returns the HTTP status code a code is answered with.
+func HTTPStatus(code Code) int {
+	if def, ok := definitions[code]; ok {
+		return def.httpStatus
+	}
+	return http.StatusInternalServerError
+}
+
+// Status converts an error to a gRPC status with the gRPC code of its code, which is attached as ErrorInfo details.
+func Status(err error) *status.Status {
+	code := Of(err)
+	def, ok := definitions[code]
+	if !ok {
+		def = definitions[Internal]
+	}
+	st := status.New(def.grpcCode, Message(err))
+	info := &errdetails.ErrorInfo{Reason: string(code), Domain: Domain}
+	if IsLeadership(err) {
+		info.Metadata = map[string]string{leadershipMetadata: "true"}
+	}
+	withInfo, errDetails := st.WithDetails(info)
+	if errDetails != nil {
+		return st
+	}
+	return withInfo
+}
+
+// UnaryServerInterceptor attaches the code of the errors returned by the calls.
+func UnaryServerInterceptor(
+	ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	res, err := handler(ctx, req)
+	if err != nil {
+		return res, Status(err).Err()
+	}
+	return res, nil
+}
+
+// StreamServerInterceptor attaches the code of the errors returned by the streams.
+func StreamServerInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	if err != nil {
+		return Status(err).Err()
+	}
+	return nil
+}
+
+func registeredCode(err error) (Code, bool) {
+	registered.RLock()
+	defer registered.RUnlock()
+	for e, code := range registered.errs {
+		if errors.Is(err, e) {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// errorInfo returns the ErrorInfo details of the gRPC status of an error, if it has them.
+func errorInfo(err error) (*errdetails.ErrorInfo, bool) {
+	st, ok := grpcStatus(err)
+	if !ok {
+		return nil, false
+	}
+	for _, d := range st.Details() {
+		if info, isInfo := d.(*errdetails.ErrorInfo); isInfo && info.Domain == Domain {
+			return info, true
+		}
+	}
+	return nil, false
+}
+
+// grpcStatus returns the gRPC status of an error, even if it was wrapped.
+func grpcStatus(err error) (*status.Status, bool) {
+	var withStatus interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &withStatus) {
+		return withStatus.GRPCStatus(), true
+	}
+	return nil, false
+}
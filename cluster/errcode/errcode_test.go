@@ -0,0 +1,45 @@
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestIsLeadership(t *testing.T) {
+	errLeadership := NewLeadership(Unavailable, "no leader")
+	errOther := New(Unavailable, "not ready")
+	errRegistered := errors.New("registered leadership error")
+	RegisterLeadership(Unavailable, errRegistered)
+
+	tests := []struct {
+		name        string
+		err         error
+		want        bool
+		wantCarried bool
+	}{
+		{name: "marked", err: errLeadership, want: true},
+		{name: "wrapped", err: fmt.Errorf("forwarding: %w", errLeadership), want: true},
+		{name: "registered", err: fmt.Errorf("raft: %w", errRegistered), want: true},
+		{name: "not marked", err: errOther},
+		{name: "marked, through gRPC", err: Status(errLeadership).Err(), want: true, wantCarried: true},
+		{name: "registered, through gRPC", err: Status(errRegistered).Err(), want: true, wantCarried: true},
+		{name: "not marked, through gRPC", err: Status(errOther).Err(), wantCarried: true},
+		{name: "connection", err: status.Error(codes.Unavailable, "connection reset")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsLeadership(tt.err); got != tt.want {
+				t.Errorf("got IsLeadership %v, want %v", got, tt.want)
+			}
+			if got := Carried(tt.err); got != tt.wantCarried {
+				t.Errorf("got Carried %v, want %v", got, tt.wantCarried)
+			}
+			if got := Of(tt.err); got != Unavailable {
+				t.Errorf("got code %v, want %v", got, Unavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/raft"
+	"log"
+	"nubedb/cluster/consensus/fsm"
+	"sync"
+	"time"
+)
+
+// HandoffPolicy defines how writes are buffered on a node while the cluster doesn't have a leader.
+//
+// Buffered writes are flushed in the order they were received once a leader is elected,
+// but they may be applied after writes received later by other nodes, so the ordering between nodes is lost.
+type HandoffPolicy struct {
+	// Enabled buffers the writes, instead of failing them with ErrUnavailable.
+	Enabled bool
+	// MaxWrites is the maximum number of writes buffered, writes received when it's full fail with ErrUnavailable.
+	MaxWrites int
+	// TTL is how long a buffered write is kept, writes which couldn't be flushed before it are discarded.
+	TTL time.Duration
+}
+
+// ErrHandedOff is returned when a write couldn't be applied because there isn't a leader,
+// and it was buffered to be applied once a leader is elected.
+var ErrHandedOff = errors.New("cluster doesn't have a leader, the write was buffered and will be applied once it has one")
+
+// handoffOperations are the operations which can be buffered.
+//
+// Other operations, like restoring a backup, depend on the state of the cluster when they are received.
+var handoffOperations = map[string]bool{"SET": true, "APPEND": true, "DELETE": true, "SOFTDELETE": true, "UNDELETE": true}
+
+// hint is a write buffered while the cluster didn't have a leader, with the consensus of its shard.
+type hint struct {
+	consensus  *raft.Raft
+	payload    *fsm.Payload
+	receivedAt time.Time
+}
+
+// handoffQueue is the bounded queue of the writes buffered on this node.
+type handoffQueue struct {
+	mu     sync.Mutex
+	policy HandoffPolicy
+	hints  []hint
+}
+
+// handoff is the queue used by Execute, its policy is set once on startup with StartHandoff.
+var handoff = &handoffQueue{}
+
+// StartHandoff sets the handoff policy, and flushes the buffered writes once their shards have a leader,
+// blocks indefinitely.
+//
+// If the handoff isn't enabled, it returns immediately.
+func StartHandoff(p HandoffPolicy, interval time.Duration) {
+	if !p.Enabled || p.MaxWrites < 1 {
+		return
+	}
+	handoff.mu.Lock()
+	handoff.policy = p
+	handoff.mu.Unlock()
+
+	log.Printf("[handoff] buffering up to %v writes for %s while there isn't a leader\n", p.MaxWrites, p.TTL)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		handoff.flush()
+	}
+}
+
+// push buffers a write, returning false if it can't be buffered.
+func (q *handoffQueue) push(consensus *raft.Raft, payload *fsm.Payload) bool {
+	if !handoffOperations[payload.Operation] {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.policy.Enabled {
+		return false
+	}
+	q.dropExpired()
+	if len(q.hints) >= q.policy.MaxWrites {
+		return false
+	}
+	q.hints = append(q.hints, hint{consensus: consensus, payload: payload, receivedAt: time.Now()})
+	return true
+}
+
+// flush applies the buffered writes in order, while their shards have a leader.
+//
+// It stops at the first write whose shard doesn't have a leader, or that fails due to a leadership change,
+// keeping it and the ones after it.
+// Writes failing for any other reason are discarded, since retrying them wouldn't succeed.
+//
+// The writes are taken out of the queue while they are sent, so the lock isn't held across the network calls.
+// It's only called by StartHandoff, so two flushes can't run at once.
+func (q *handoffQueue) flush() {
+	q.mu.Lock()
+	q.dropExpired()
+	if len(q.hints) == 0 || !hasLeader(q.hints[0].consensus) {
+		q.mu.Unlock()
+		return
+	}
+	hints := q.hints
+	q.hints = nil
+	q.mu.Unlock()
+
+	flushed := 0
+	for _, h := range hints {
+		if !hasLeader(h.consensus) {
+			break
+		}
+		_, _, err := execute(context.Background(), h.consensus, h.payload)
+		if err != nil && isLeadershipErr(err) {
+			break
+		}
+		if err != nil {
+			log.Printf("[handoff] discarding buffered write for key '%s': %v\n", h.payload.Key, err)
+		}
+		flushed++
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// The writes buffered while flushing were received after the ones which couldn't be flushed.
+	q.hints = append(hints[flushed:], q.hints...)
+	log.Printf("[handoff] flushed %v buffered writes, %v remaining\n", flushed, len(q.hints))
+}
+
+// dropExpired discards the buffered writes older than the TTL. The lock must be held by the caller.
+func (q *handoffQueue) dropExpired() {
+	if q.policy.TTL <= 0 {
+		return
+	}
+	expired := 0
+	for _, h := range q.hints {
+		if time.Since(h.receivedAt) < q.policy.TTL {
+			break
+		}
+		expired++
+	}
+	if expired > 0 {
+		log.Printf("[handoff] discarding %v buffered writes which expired before a leader was elected\n", expired)
+		q.hints = q.hints[expired:]
+	}
+}
+
+func hasLeader(consensus *raft.Raft) bool {
+	_, leaderID := consensus.LeaderWithID()
+	return leaderID != ""
+}
@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hashicorp/raft"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stages of an operation in flight.
+const (
+	// OpApplying is an operation waiting to be applied by this node, which is the leader.
+	OpApplying = "applying"
+	// OpForwarding is an operation waiting for the leader it was forwarded to.
+	OpForwarding = "forwarding"
+)
+
+var (
+	// ErrOpNotFound is returned when killing an operation which isn't in flight.
+	ErrOpNotFound = errcode.New(errcode.NotFound, "there isn't an operation in flight with that id")
+	// ErrOpKilled is returned to the client of an operation which was killed.
+	ErrOpKilled = errcode.New(errcode.Aborted, "the operation was killed, it may still be applied if it was committed")
+)
+
+// Op is an operation in flight on this node.
+type Op struct {
+	ID        uint64 `json:"id"`
+	Operation string `json:"operation"`
+	Key       string `json:"key"`
+	Shard     int    `json:"shard"`
+	Stage     string `json:"stage"`
+	// Leader is the node the operation is forwarded to.
+	Leader string `json:"leader,omitempty"`
+	// Remote is whether the operation was forwarded to this node by another node.
+	Remote    bool      `json:"remote"`
+	StartedAt time.Time `json:"startedAt"`
+	Elapsed   string    `json:"elapsed"`
+}
+
+// trackedOp is an operation in flight, which can be killed by cancelling its context.
+type trackedOp struct {
+	Op
+	cancel context.CancelFunc
+	killed bool
+}
+
+type opKey struct{}
+
+// ops are the operations in flight on this node.
+var ops = struct {
+	sync.Mutex
+	lastID uint64
+	byID   map[uint64]*trackedOp
+}{byID: make(map[uint64]*trackedOp)}
+
+// trackOp registers an operation in flight, returning a context which is cancelled if it's killed,
+// and a function which unregisters it once it's done, returning ErrOpKilled instead of its error if it was killed.
+func trackOp(ctx context.Context, consensus *raft.Raft, operation string, key string, remote bool) (context.Context, func(error) error) {
+	ctx, cancel := context.WithCancel(ctx)
+	o := &trackedOp{
+		Op: Op{
+			Operation: operation,
+			Key:       key,
+			Shard:     shardOf(consensus),
+			Stage:     OpApplying,
+			Remote:    remote,
+			StartedAt: time.Now().UTC(),
+		},
+		cancel: cancel,
+	}
+
+	ops.Lock()
+	ops.lastID++
+	o.ID = ops.lastID
+	ops.byID[o.ID] = o
+	ops.Unlock()
+
+	done := func(err error) error {
+		cancel()
+		ops.Lock()
+		defer ops.Unlock()
+		delete(ops.byID, o.ID)
+		if o.killed && err != nil {
+			return ErrOpKilled
+		}
+		return err
+	}
+	return context.WithValue(ctx, opKey{}, o), done
+}
+
+// setOpStage sets the stage of the operation in flight of ctx, if it's tracked.
+func setOpStage(ctx context.Context, stage string, leader string) {
+	o, ok := ctx.Value(opKey{}).(*trackedOp)
+	if !ok {
+		return
+	}
+	ops.Lock()
+	defer ops.Unlock()
+	o.Stage = stage
+	o.Leader = leader
+}
+
+// InFlight returns the operations in flight on this node, the oldest first.
+func InFlight() []Op {
+	ops.Lock()
+	defer ops.Unlock()
+	list := make([]Op, 0, len(ops.byID))
+	for _, o := range ops.byID {
+		op := o.Op
+		op.Elapsed = time.Since(op.StartedAt).String()
+		list = append(list, op)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// KillOp stops waiting for an operation in flight, its client receives ErrOpKilled.
+//
+// It doesn't undo the operation: if it was already committed, it's applied anyway.
+func KillOp(id uint64) error {
+	ops.Lock()
+	defer ops.Unlock()
+	o, ok := ops.byID[id]
+	if !ok {
+		return ErrOpNotFound
+	}
+	o.killed = true
+	o.cancel()
+	return nil
+}
+
+// ApplyForwarded applies a command forwarded by another node on the Leader, like ApplyLeaderFuture,
+// tracking it as an operation in flight.
+func ApplyForwarded(ctx context.Context, consensus *raft.Raft, payloadData []byte) (json.RawMessage, uint64, error) {
+	var operation, key string
+	if p, errDecode := fsm.DecodePayload(payloadData); errDecode == nil {
+		operation, key = p.Operation, p.Key
+	}
+	ctx, done := trackOp(ctx, consensus, operation, key, true)
+	result, index, err := ApplyLeaderFuture(ctx, consensus, payloadData)
+	return result, index, done(err)
+}
@@ -0,0 +1,179 @@
+// Package peerauth authenticates the nodes which ask to join or leave the cluster, with a secret shared by its nodes,
+// so a process which discovered the cluster can't add itself as a voter, nor remove the nodes.
+//
+// The secret isn't sent, the caller proves it knows it with an HMAC of the request and the time it was sent at.
+// Nodes which joined with a join token don't know the secret, they prove they know their node key instead,
+// which is derived from the secret for their ID, and is only accepted while they are members of the cluster.
+package peerauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"google.golang.org/grpc/metadata"
+	"nubedb/cluster/errcode"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MetadataKey is the gRPC metadata key which carries the proof of the caller.
+	MetadataKey = "nubedb-peer-proof"
+	// NodeMetadataKey is the gRPC metadata key which carries the ID of the caller, when its proof is signed with its node key.
+	NodeMetadataKey = "nubedb-peer-node"
+	// maxSkew is how old, or how far in the future, a proof can be, it must cover the clock skew of the nodes.
+	maxSkew = 5 * time.Minute
+)
+
+var (
+	// ErrUnauthorized is returned when a caller doesn't prove it knows the cluster secret, or the key of a member.
+	ErrUnauthorized = errcode.New(errcode.Unauthenticated, "the node didn't prove it knows the cluster secret")
+	// ErrCantVerify is returned when a node which doesn't know the cluster secret is asked to verify a caller.
+	ErrCantVerify = errcode.New(
+		errcode.Unauthenticated, "the node joined with a join token, it doesn't know the cluster secret to verify other nodes",
+	)
+)
+
+var (
+	mu        sync.RWMutex
+	secret    []byte
+	joinToken string
+	keyOwner  string
+	nodeKey   []byte
+	isMember  func(nodeID string) bool
+)
+
+// Configure sets the cluster secret, and the join token the node presents if it doesn't know it, on startup.
+// An empty secret disables the authentication, unless the node has a node key.
+func Configure(clusterSecret string, token string) {
+	mu.Lock()
+	defer mu.Unlock()
+	secret = []byte(clusterSecret)
+	joinToken = token
+}
+
+// SetNodeKey sets the key of this node, once it's received after joining with a join token, or on startup.
+func SetNodeKey(id string, key string) {
+	mu.Lock()
+	defer mu.Unlock()
+	keyOwner = id
+	nodeKey = []byte(key)
+}
+
+// SetMembership sets the func which returns whether a node is a member of the cluster,
+// the proofs signed with a node key are only accepted from members, so removing a node revokes its key.
+func SetMembership(fn func(nodeID string) bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	isMember = fn
+}
+
+// Enabled returns whether the peers are authenticated.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(secret) > 0 || len(nodeKey) > 0
+}
+
+// NodeKey returns the key of a node, derived from the cluster secret, empty if the secret isn't known.
+func NodeKey(id string) string {
+	s := currentSecret()
+	if len(s) == 0 {
+		return ""
+	}
+	return deriveNodeKey(s, id)
+}
+
+// WithProof returns a context which proves the caller knows the cluster secret, or its node key, for a request about a node.
+//
+// If the node doesn't know either, the context carries its join token instead, if it has one.
+func WithProof(ctx context.Context, nodeID string, nodeAddr string) context.Context {
+	mu.RLock()
+	key, caller, token := secret, "", joinToken
+	if len(key) == 0 {
+		key, caller = nodeKey, keyOwner
+	}
+	mu.RUnlock()
+
+	if len(key) == 0 {
+		if token != "" {
+			return metadata.AppendToOutgoingContext(ctx, JoinTokenMetadataKey, token)
+		}
+		return ctx
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	proof := ts + "." + base64.RawURLEncoding.EncodeToString(sign(key, ts, nodeID, nodeAddr))
+	if caller != "" {
+		return metadata.AppendToOutgoingContext(ctx, MetadataKey, proof, NodeMetadataKey, caller)
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, proof)
+}
+
+// Verify returns ErrUnauthorized if the caller of an incoming request about a node didn't prove it knows the cluster secret,
+// or the key of a node which is a member of the cluster.
+func Verify(ctx context.Context, nodeID string, nodeAddr string) error {
+	if !Enabled() {
+		return nil
+	}
+	s := currentSecret()
+	if len(s) == 0 {
+		return ErrCantVerify
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(MetadataKey)
+	if len(values) == 0 {
+		return ErrUnauthorized
+	}
+	key := s
+	if callers := md.Get(NodeMetadataKey); len(callers) > 0 {
+		if !memberOfCluster(callers[0]) {
+			return ErrUnauthorized
+		}
+		key = []byte(deriveNodeKey(s, callers[0]))
+	}
+	ts, rawSig, found := strings.Cut(values[0], ".")
+	if !found {
+		return ErrUnauthorized
+	}
+	unix, errParse := strconv.ParseInt(ts, 10, 64)
+	if errParse != nil {
+		return ErrUnauthorized
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > maxSkew || age < -maxSkew {
+		return ErrUnauthorized
+	}
+	sig, errSig := base64.RawURLEncoding.DecodeString(rawSig)
+	if errSig != nil || !hmac.Equal(sig, sign(key, ts, nodeID, nodeAddr)) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func currentSecret() []byte {
+	mu.RLock()
+	defer mu.RUnlock()
+	return secret
+}
+
+func memberOfCluster(id string) bool {
+	mu.RLock()
+	fn := isMember
+	mu.RUnlock()
+	return fn != nil && fn(id)
+}
+
+func sign(key []byte, ts string, nodeID string, nodeAddr string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(ts + "\n" + nodeID + "\n" + nodeAddr))
+	return mac.Sum(nil)
+}
+
+// deriveNodeKey derives the key of a node from the cluster secret, the domain is prefixed so a key can't be passed as a proof.
+func deriveNodeKey(clusterSecret []byte, id string) string {
+	mac := hmac.New(sha256.New, clusterSecret)
+	_, _ = mac.Write([]byte("node-key\n" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,99 @@
+package peerauth
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/metadata"
+	"testing"
+	"time"
+)
+
+// incoming returns the incoming context of the request sent with the outgoing context ctx.
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+// setup configures the node with a cluster secret, or with the node key of id if the secret is empty.
+func setup(t *testing.T, clusterSecret string, token string, id string, key string) {
+	t.Helper()
+	Configure(clusterSecret, token)
+	SetNodeKey(id, key)
+	t.Cleanup(func() {
+		Configure("", "")
+		SetNodeKey("", "")
+		SetMembership(nil)
+	})
+}
+
+func TestNodeKeyIsRevokedWithMembership(t *testing.T) {
+	const clusterSecret = "cluster-secret"
+	members := map[string]bool{"node5": true}
+	key := deriveNodeKey([]byte(clusterSecret), "node5")
+
+	// The new node only knows its key, it signs the request to remove itself with it.
+	setup(t, "", "", "node5", key)
+	ctx := incoming(WithProof(context.Background(), "node5", ""))
+	if err := Verify(ctx, "node5", ""); !errors.Is(err, ErrCantVerify) {
+		t.Fatalf("got %v, want ErrCantVerify", err)
+	}
+
+	Configure(clusterSecret, "")
+	SetNodeKey("", "")
+	SetMembership(func(id string) bool { return members[id] })
+	if err := Verify(ctx, "node5", ""); err != nil {
+		t.Fatalf("the proof of a member was refused: %v", err)
+	}
+	if err := Verify(ctx, "node6", ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("the proof was accepted for another node: %v", err)
+	}
+
+	members["node5"] = false
+	if err := Verify(ctx, "node5", ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("the proof of a removed node was accepted: %v", err)
+	}
+}
+
+func TestNodeKeyIsNotTheSecret(t *testing.T) {
+	const clusterSecret = "cluster-secret"
+	key := deriveNodeKey([]byte(clusterSecret), "node5")
+	setup(t, key, "", "", "")
+	// The proof is signed with the node key as if it was the secret, without saying which node's key it is.
+	ctx := incoming(WithProof(context.Background(), "node5", ""))
+
+	Configure(clusterSecret, "")
+	SetMembership(func(id string) bool { return true })
+	if err := Verify(ctx, "node5", ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("a node key was accepted as the cluster secret: %v", err)
+	}
+}
+
+func TestVerifyJoinWithToken(t *testing.T) {
+	const clusterSecret = "cluster-secret"
+	token, errToken := CreateJoinToken(clusterSecret, "node5", time.Hour)
+	if errToken != nil {
+		t.Fatal(errToken)
+	}
+
+	setup(t, "", token, "", "")
+	ctx := incoming(WithProof(context.Background(), "node5", "node5:3000"))
+
+	Configure(clusterSecret, "")
+	withToken, errJoin := VerifyJoin(ctx, "node5", "node5:3000")
+	if errJoin != nil || !withToken {
+		t.Fatalf("got (%v, %v), want the join to be accepted with the token", withToken, errJoin)
+	}
+	if _, err := VerifyJoin(ctx, "node6", "node6:3000"); !errors.Is(err, ErrInvalidJoinToken) {
+		t.Fatalf("the token of another node was accepted: %v", err)
+	}
+	if got := NodeKey("node5"); got == "" || got == clusterSecret {
+		t.Fatalf("got node key %q, want one derived from the secret", got)
+	}
+
+	// A node which only knows its key can't check the token's signature.
+	Configure("", "")
+	SetNodeKey("node1", deriveNodeKey([]byte(clusterSecret), "node1"))
+	if _, err := VerifyJoin(ctx, "node5", "node5:3000"); !errors.Is(err, ErrCantVerify) {
+		t.Fatalf("got %v, want ErrCantVerify", err)
+	}
+}
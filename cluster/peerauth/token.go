@@ -0,0 +1,121 @@
+package peerauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc/metadata"
+	"nubedb/cluster/errcode"
+	"os"
+	"strings"
+	"time"
+)
+
+// JoinTokenMetadataKey is the gRPC metadata key which carries the join token of a node which doesn't know the secret.
+const JoinTokenMetadataKey = "nubedb-join-token"
+
+// ErrInvalidJoinToken is returned when a join token is malformed, wasn't signed with the cluster secret, or expired.
+var ErrInvalidJoinToken = errcode.New(errcode.Unauthenticated, "invalid or expired join token")
+
+// tokenClaims are the claims of a join token,
+// which lets a node join the cluster until it expires without knowing the cluster secret.
+type tokenClaims struct {
+	// NodeID is the only node which can use the token, any node can if it's empty.
+	NodeID string `json:"n,omitempty"`
+	// Expires is the Unix time the token expires at.
+	Expires int64 `json:"e"`
+	// Nonce makes every token different.
+	Nonce string `json:"r"`
+}
+
+// CreateJoinToken returns a join token signed with the cluster secret, valid for ttl.
+//
+// If nodeID isn't empty, only the node with that ID can join with it.
+func CreateJoinToken(clusterSecret string, nodeID string, ttl time.Duration) (string, error) {
+	if clusterSecret == "" {
+		return "", errors.New("join tokens are signed with the cluster secret, set it in NUBEDB_CLUSTER_SECRET")
+	}
+	if ttl <= 0 {
+		return "", errors.New("the join token's TTL must be positive")
+	}
+	nonce := make([]byte, 8)
+	_, errRand := rand.Read(nonce)
+	if errRand != nil {
+		return "", errRand
+	}
+	b, errMarshal := json.Marshal(tokenClaims{
+		NodeID:  nodeID,
+		Expires: time.Now().Add(ttl).Unix(),
+		Nonce:   hex.EncodeToString(nonce),
+	})
+	if errMarshal != nil {
+		return "", errMarshal
+	}
+	sig := signToken([]byte(clusterSecret), b)
+	return base64.RawURLEncoding.EncodeToString(b) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyJoin verifies the caller of an incoming join of a node, like Verify, also accepting a join token instead of a proof.
+//
+// It returns whether the node joined with a token, so it doesn't have a node key yet.
+func VerifyJoin(ctx context.Context, nodeID string, nodeAddr string) (bool, error) {
+	if !Enabled() {
+		return false, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(JoinTokenMetadataKey)
+	if len(md.Get(MetadataKey)) > 0 || len(tokens) == 0 {
+		return false, Verify(ctx, nodeID, nodeAddr)
+	}
+	if len(currentSecret()) == 0 {
+		return false, ErrCantVerify
+	}
+
+	rawBody, rawSig, found := strings.Cut(tokens[0], ".")
+	if !found {
+		return false, ErrInvalidJoinToken
+	}
+	b, errBody := base64.RawURLEncoding.DecodeString(rawBody)
+	sig, errSig := base64.RawURLEncoding.DecodeString(rawSig)
+	if errBody != nil || errSig != nil || !hmac.Equal(sig, signToken(currentSecret(), b)) {
+		return false, ErrInvalidJoinToken
+	}
+	var t tokenClaims
+	if json.Unmarshal(b, &t) != nil || time.Now().Unix() > t.Expires {
+		return false, ErrInvalidJoinToken
+	}
+	if t.NodeID != "" && t.NodeID != nodeID {
+		return false, ErrInvalidJoinToken
+	}
+	return true, nil
+}
+
+// SaveNodeKey persists the node key received when joining with a token, so it's known after a restart.
+func SaveNodeKey(path string, key string) error {
+	return os.WriteFile(path, []byte(key), 0600)
+}
+
+// LoadNodeKey returns the node key persisted by SaveNodeKey, empty if there isn't one.
+func LoadNodeKey(path string) (string, error) {
+	b, errRead := os.ReadFile(path)
+	if errors.Is(errRead, os.ErrNotExist) {
+		return "", nil
+	}
+	if errRead != nil {
+		return "", errRead
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// signToken signs a join token, the domain is prefixed so a token can't be passed as a proof.
+func signToken(key []byte, b []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte("join-token\n"))
+	_, _ = mac.Write(b)
+	return mac.Sum(nil)
+}
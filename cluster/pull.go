@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"errors"
+	"github.com/narvikd/errorskit"
+	"io"
+	"nubedb/api/proto"
+	"nubedb/api/proto/protoclient"
+	"nubedb/cluster/peerauth"
+	"nubedb/cluster/shard"
+)
+
+// PullBackup copies the data of a shard of a peer to w, for the node nodeID to start from it,
+// returning the index the peer had applied when the copy started.
+func PullBackup(shardID int, nodeID string, peerGrpcAddr string, w io.Writer) (uint64, error) {
+	conn, errConn := protoclient.NewStreamConnection(peerGrpcAddr)
+	if errConn != nil {
+		return 0, errConn
+	}
+	defer conn.Cleanup()
+
+	ctx := peerauth.WithProof(shard.WithShard(conn.Ctx, shardID), nodeID, "")
+	stream, errStream := conn.Client.PullBackup(ctx, &proto.PullBackupRequest{NodeID: nodeID})
+	if errStream != nil {
+		return 0, errorskit.Wrap(errStream, errGrpcTalkNode)
+	}
+
+	var (
+		index    uint64
+		received bool
+	)
+	for {
+		chunk, errRecv := stream.Recv()
+		if errors.Is(errRecv, io.EOF) {
+			return index, nil
+		}
+		if errRecv != nil {
+			return index, errorskit.Wrap(errRecv, "couldn't receive the backup")
+		}
+		if !received {
+			index = chunk.AppliedIndex
+			received = true
+		}
+		_, errWrite := w.Write(chunk.Data)
+		if errWrite != nil {
+			return index, errWrite
+		}
+	}
+}
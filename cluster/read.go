@@ -0,0 +1,23 @@
+package cluster
+
+import (
+	"github.com/narvikd/errorskit"
+	"nubedb/api/proto"
+	"nubedb/api/proto/protoclient"
+	"nubedb/cluster/shard"
+)
+
+// ReadKey reads the value of a key from the local storage of a node, with the index its shard had applied.
+func ReadKey(shardID int, key string, grpcAddr string) (*proto.ReadKeyResponse, error) {
+	conn, errConn := protoclient.NewConnection(grpcAddr)
+	if errConn != nil {
+		return nil, errConn
+	}
+	defer conn.Cleanup()
+
+	res, errTalk := conn.Client.ReadKey(shard.WithShard(conn.Ctx, shardID), &proto.ReadKeyRequest{Key: key})
+	if errTalk != nil {
+		return nil, errorskit.Wrap(errTalk, errGrpcTalkNode)
+	}
+	return res, nil
+}
@@ -0,0 +1,123 @@
+// Package replication is responsible for replicating this cluster's changes into another cluster asynchronously.
+//
+// The leader tails the changes of the FSM, and sends them through a dedicated gRPC stream to the target cluster,
+// which applies them idempotently, so a change sent twice is only applied once.
+//
+// This allows running a disaster recovery copy of the cluster without stretching the consensus across regions.
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"log"
+	"nubedb/api/proto"
+	"nubedb/api/proto/protoclient"
+	"nubedb/cluster/cdc"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/peerauth"
+	"nubedb/internal/config"
+)
+
+// consumerName is the name used to store the checkpoint of the replication agent.
+const consumerName = "replication"
+
+// streamPublisher sends the changes to the target cluster through a gRPC stream.
+//
+// The stream is opened lazily and reopened if it fails.
+type streamPublisher struct {
+	target   string
+	sourceID string
+	conn     *protoclient.Connection
+	stream   proto.Service_ReplicateClient
+}
+
+// Start replicates the changes of this cluster while the node is the leader, blocks indefinitely.
+//
+// If no target is configured, it returns immediately.
+func Start(node *consensus.Node, cfg config.ReplicationCfg) {
+	if cfg.Target == "" {
+		return
+	}
+
+	publisher := &streamPublisher{target: cfg.Target, sourceID: cfg.SourceID}
+	defer publisher.Close()
+
+	log.Printf("[replication] replicating changes to '%s' as '%s'\n", cfg.Target, cfg.SourceID)
+	cdc.Tail(node, consumerName, publisher, cfg.Interval, cfg.BatchSize)
+}
+
+// Publish sends the changes and waits until the target acknowledges all of them.
+func (p *streamPublisher) Publish(changes []fsm.ChangeEvent) error {
+	errOpen := p.open()
+	if errOpen != nil {
+		return errOpen
+	}
+
+	req := &proto.ReplicateRequest{SourceID: p.sourceID}
+	for _, change := range changes {
+		b, errMarshal := json.Marshal(change)
+		if errMarshal != nil {
+			return errMarshal
+		}
+		req.Changes = append(req.Changes, b)
+	}
+
+	errSend := p.stream.Send(req)
+	if errSend != nil {
+		p.Close()
+		return errorskit.Wrap(errSend, "couldn't send changes to the target")
+	}
+
+	res, errRecv := p.stream.Recv()
+	if errRecv != nil {
+		p.Close()
+		return errorskit.Wrap(errRecv, "couldn't receive acknowledgement from the target")
+	}
+
+	lastIndex := changes[len(changes)-1].Index
+	if res.LastIndex != lastIndex {
+		p.Close()
+		errMsg := fmt.Sprintf("target acknowledged index %v, but %v was expected", res.LastIndex, lastIndex)
+		return errors.New(errMsg)
+	}
+
+	return nil
+}
+
+func (p *streamPublisher) open() error {
+	if p.stream != nil {
+		return nil
+	}
+
+	conn, errConn := protoclient.NewStreamConnection(p.target)
+	if errConn != nil {
+		return errConn
+	}
+
+	// The target authenticates the source with the cluster secret, which both clusters must share.
+	stream, errStream := conn.Client.Replicate(peerauth.WithProof(conn.Ctx, p.sourceID, ""))
+	if errStream != nil {
+		conn.Cleanup()
+		return errorskit.Wrap(errStream, "couldn't open replication stream")
+	}
+
+	p.conn = conn
+	p.stream = stream
+	return nil
+}
+
+// Close closes the stream, it will be reopened on the next Publish.
+func (p *streamPublisher) Close() error {
+	if p.stream != nil {
+		_ = p.stream.CloseSend()
+		p.stream = nil
+	}
+	if p.conn != nil {
+		p.conn.Cleanup()
+		p.conn = nil
+	}
+	return nil
+}
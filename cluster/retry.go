@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/raft"
+	"math/rand"
+	"nubedb/cluster/errcode"
+	"time"
+)
+
+// RetryPolicy defines how Execute retries the writes that fail because the cluster doesn't have a leader.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a write is tried, 1 disables the retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it's doubled on every retry.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay between retries.
+	MaxDelay time.Duration
+}
+
+var (
+	// ErrNoLeader is returned when the cluster doesn't have a leader.
+	ErrNoLeader = errcode.NewLeadership(errcode.Unavailable, "leader id was empty")
+	// ErrUnavailable is returned when a write couldn't be done because of a leadership change,
+	// even after retrying it. It's safe to retry it later.
+	ErrUnavailable = errcode.NewLeadership(errcode.Unavailable, "cluster is unavailable due to a leader election, retry later")
+	// errNotLeader is returned when applying a write on a node which isn't the leader anymore.
+	errNotLeader = errcode.NewLeadership(errcode.Unavailable, "node is not a leader")
+	// errLeaderUnreachable is returned when a write couldn't be forwarded to the leader, before sending it.
+	errLeaderUnreachable = errcode.NewLeadership(errcode.Unavailable, "couldn't connect to the leader")
+	// errForwardOutcomeUnknown is returned when the connection to the leader fails while a write is forwarded,
+	// the leader may have received it, so it isn't retried.
+	errForwardOutcomeUnknown = errcode.New(errcode.OutcomeUnknown,
+		"the connection to the leader failed while forwarding the write, it may or may not be applied")
+)
+
+// retryPolicy is the policy used by Execute, it's set once on startup with ConfigureRetries.
+var retryPolicy = RetryPolicy{MaxAttempts: 1}
+
+func init() {
+	// Raft's leadership errors which guarantee the write wasn't appended are retried like the ones of this package,
+	// even when forwarded by the leader.
+	errcode.RegisterLeadership(errcode.Unavailable, raft.ErrNotLeader, raft.ErrLeadershipTransferInProgress)
+	// The leadership can be lost after the write was appended, so it may still be committed by the next leader,
+	// retrying it could apply it twice.
+	errcode.Register(errcode.OutcomeUnknown, raft.ErrLeadershipLost)
+}
+
+// ConfigureRetries sets the retry policy used by Execute.
+func ConfigureRetries(p RetryPolicy) {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	retryPolicy = p
+}
+
+// IsUnavailable returns whether an error is due to the cluster being temporarily unavailable.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// RetryAfter returns the time a client should wait before retrying a write which failed with ErrUnavailable.
+func RetryAfter() time.Duration {
+	return retryPolicy.MaxDelay
+}
+
+// withRetries executes fn, retrying it with jittered exponential backoff while it fails due to a leadership change.
+//
+// If all the attempts fail due to a leadership change, it returns ErrUnavailable.
+func withRetries(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < retryPolicy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoffDelay(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err = fn()
+		if err == nil || !isLeadershipErr(err) {
+			return err
+		}
+	}
+	return errors.Join(ErrUnavailable, err)
+}
+
+// backoffDelay returns a random delay between 0 and the exponential backoff for the attempt (full jitter),
+// so the clients that failed at the same time don't retry at the same time.
+func backoffDelay(attempt int) time.Duration {
+	backoff := retryPolicy.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > retryPolicy.MaxDelay {
+		backoff = retryPolicy.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// isLeadershipErr returns whether an error is due to a leadership change, which guarantees the write wasn't applied.
+//
+// Only the errors marked with errcode.NewLeadership are, like the ones forwarded from the leader which marked them,
+// or failing to connect to it. The rest of the Unavailable errors aren't, like the ones of the connection to the leader,
+// which can fail after the leader received the write.
+func isLeadershipErr(err error) bool {
+	return errcode.IsLeadership(err)
+}
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"nubedb/cluster/errcode"
+	"testing"
+)
+
+func TestWithRetries(t *testing.T) {
+	previous := retryPolicy
+	ConfigureRetries(RetryPolicy{MaxAttempts: 3})
+	defer func() { retryPolicy = previous }()
+
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{name: "no leader", err: ErrNoLeader, wantAttempts: 3},
+		{name: "raft's not leader, forwarded", err: errcode.Status(raft.ErrNotLeader).Err(), wantAttempts: 3},
+		{name: "leader unreachable", err: fmt.Errorf("%w: dial failed", errLeaderUnreachable), wantAttempts: 3},
+		{name: "leadership lost", err: raft.ErrLeadershipLost, wantAttempts: 1},
+		{name: "connection failed while forwarding", err: errForwardOutcomeUnknown, wantAttempts: 1},
+		{name: "bare unavailable", err: status.Error(codes.Unavailable, "connection reset"), wantAttempts: 1},
+		{name: "resource exhausted", err: status.Error(codes.ResourceExhausted, "message too large"), wantAttempts: 1},
+		{name: "node not ready", err: errcode.Status(errcode.New(errcode.Unavailable, "node isn't ready")).Err(), wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := withRetries(context.Background(), func() error {
+				attempts++
+				return tt.err
+			})
+			if attempts != tt.wantAttempts {
+				t.Errorf("got %v attempts, want %v", attempts, tt.wantAttempts)
+			}
+			if got, want := IsUnavailable(err), tt.wantAttempts > 1; got != want {
+				t.Errorf("got IsUnavailable %v, want %v, so it's handed off only after a leadership change", got, want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want it to wrap %v", err, tt.err)
+			}
+		})
+	}
+}
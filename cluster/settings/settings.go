@@ -0,0 +1,248 @@
+// Package settings applies the cluster-wide operational settings of nubedb, which are stored in the FSM,
+// so every node applies the same ones, instead of each node's configured value.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/accesslog"
+	"nubedb/internal/config"
+	"nubedb/internal/metrics"
+	"nubedb/pkg/ratelimit"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pollInterval is how often the settings stored in the FSM are checked for changes.
+const pollInterval = 1 * time.Second
+
+var (
+	// ErrUnknown is returned when setting a setting which doesn't exist.
+	ErrUnknown = errcode.New(errcode.InvalidArgument, "unknown setting")
+	// ErrInvalid is returned when a setting is set to a value it doesn't accept.
+	ErrInvalid = errcode.New(errcode.InvalidArgument, "invalid setting value")
+	// ErrRateLimited is returned when a node receives more writes per second than writes.rateLimit.
+	ErrRateLimited = errcode.New(errcode.RateLimited, "the node is receiving more writes than the cluster's rate limit, retry later")
+	// ErrValueTooLarge is returned when a write is larger than writes.maxValueBytes.
+	ErrValueTooLarge = errcode.New(errcode.ValueTooLarge, "the value is larger than the cluster's maximum")
+)
+
+// Setting is a cluster-wide setting with its effective value.
+type Setting struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Value       string `json:"value"`
+	// Default is the value configured on this node, used while the setting isn't set for the cluster.
+	Default string `json:"default"`
+	IsSet   bool   `json:"isSet"`
+}
+
+// definition defines a setting: the values it accepts, its default, and how it's applied.
+type definition struct {
+	description  string
+	validate     func(string) error
+	defaultValue func(config.Config) string
+	apply        func(string)
+}
+
+var (
+	writeLimiter  = ratelimit.New(0)
+	maxValueBytes atomic.Int64
+)
+
+var definitions = map[string]definition{
+	"writes.rateLimit": {
+		description:  "Writes per second each node accepts from the clients, 0 is unlimited.",
+		validate:     validateInt,
+		defaultValue: func(config.Config) string { return "0" },
+		apply: func(v string) {
+			n, _ := strconv.Atoi(v)
+			writeLimiter.SetRate(n)
+		},
+	},
+	"writes.maxValueBytes": {
+		description:  "Maximum size in bytes of the value of a write, 0 is unlimited.",
+		validate:     validateInt,
+		defaultValue: func(config.Config) string { return "0" },
+		apply: func(v string) {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			maxValueBytes.Store(n)
+		},
+	},
+	"writes.coalesceWindow": {
+		description:  "How long the leader waits for more sets of the same key before applying one, 0 disables it.",
+		validate:     validateDuration,
+		defaultValue: func(cfg config.Config) string { return cfg.Coalescing.Window.String() },
+		apply: func(v string) {
+			d, _ := time.ParseDuration(v)
+			cluster.ConfigureCoalescing(d)
+		},
+	},
+	"metrics.slowOpThreshold": {
+		description:  "Duration from which the operations are logged as slow, 0 disables the log.",
+		validate:     validateDuration,
+		defaultValue: func(cfg config.Config) string { return cfg.Metrics.SlowOpThreshold.String() },
+		apply: func(v string) {
+			d, _ := time.ParseDuration(v)
+			metrics.SetSlowOpThreshold(d)
+		},
+	},
+	"metrics.accessLogSampleEvery": {
+		description:  "Logs 1 of every N requests served by the APIs, 0 disables the access log.",
+		validate:     validateInt,
+		defaultValue: func(cfg config.Config) string { return strconv.Itoa(cfg.Metrics.AccessLogSampleEvery) },
+		apply: func(v string) {
+			n, _ := strconv.Atoi(v)
+			accesslog.SetSampling(n)
+		},
+	},
+	"metrics.accessLogErrors": {
+		description:  "Logs every failed request served by the APIs, besides the sampled ones.",
+		validate:     validateBool,
+		defaultValue: func(cfg config.Config) string { return strconv.FormatBool(cfg.Metrics.AccessLogErrors) },
+		apply: func(v string) {
+			enabled, _ := strconv.ParseBool(v)
+			accesslog.SetLogErrors(enabled)
+		},
+	},
+	"metrics.hotKeysSampleEvery": {
+		description:  "Samples 1 of every N key accesses to find the hot keys, 0 disables the detection.",
+		validate:     validateInt,
+		defaultValue: func(cfg config.Config) string { return strconv.Itoa(cfg.Metrics.HotKeysSampleEvery) },
+		apply: func(v string) {
+			n, _ := strconv.Atoi(v)
+			metrics.SetHotKeysSampling(n)
+		},
+	},
+}
+
+// state holds the node's configuration, the settings set for the cluster, and the values applied.
+var state = struct {
+	sync.Mutex
+	defaults config.Config
+	set      map[string]string
+	applied  map[string]string
+}{applied: make(map[string]string)}
+
+// Start applies the settings set for the cluster, checking the FSM for changes until the node stops.
+func Start(node *consensus.Node, cfg config.Config) {
+	SetDefaults(cfg)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		set, errGet := node.FSM.GetSettings()
+		if errGet != nil {
+			log.Println("[settings] couldn't read the settings:", errGet)
+			continue
+		}
+		state.Lock()
+		state.set = set
+		applyLocked()
+		state.Unlock()
+	}
+}
+
+// SetDefaults sets the node's configuration the settings default to, like after reloading it.
+func SetDefaults(cfg config.Config) {
+	state.Lock()
+	defer state.Unlock()
+	state.defaults = cfg
+	applyLocked()
+}
+
+// applyLocked applies the settings whose value changed, state must be locked.
+func applyLocked() {
+	for name, def := range definitions {
+		v, isSet := state.set[name]
+		if !isSet || def.validate(v) != nil {
+			v = def.defaultValue(state.defaults)
+		}
+		if applied, ok := state.applied[name]; ok && applied == v {
+			continue
+		}
+		def.apply(v)
+		state.applied[name] = v
+	}
+}
+
+// Validate returns an error if a setting doesn't exist, or doesn't accept a value.
+func Validate(name string, value string) error {
+	def, ok := definitions[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknown, name)
+	}
+	errValue := def.validate(value)
+	if errValue != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalid, name, errValue)
+	}
+	return nil
+}
+
+// List returns all the settings sorted by name, with the values set for the cluster.
+func List(set map[string]string) []Setting {
+	state.Lock()
+	defaults := state.defaults
+	state.Unlock()
+
+	settings := make([]Setting, 0, len(definitions))
+	for name, def := range definitions {
+		s := Setting{Name: name, Description: def.description, Default: def.defaultValue(defaults)}
+		s.Value, s.IsSet = set[name]
+		if !s.IsSet {
+			s.Value = s.Default
+		}
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Name < settings[j].Name
+	})
+	return settings
+}
+
+// CheckWrite returns an error if a write of a value of size bytes exceeds the cluster's rate limit or maximum value size.
+func CheckWrite(size int) error {
+	if limit := maxValueBytes.Load(); limit > 0 && int64(size) > limit {
+		return fmt.Errorf("%w of %v bytes", ErrValueTooLarge, limit)
+	}
+	if !writeLimiter.Allow() {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+func validateInt(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return errors.New("it must be an integer")
+	}
+	if n < 0 {
+		return errors.New("it can't be negative")
+	}
+	return nil
+}
+
+func validateDuration(v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return errors.New("it must be a duration, ex: 100ms")
+	}
+	if d < 0 {
+		return errors.New("it can't be negative")
+	}
+	return nil
+}
+
+func validateBool(v string) error {
+	_, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.New("it must be true or false")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package shard
+
+import "sort"
+
+// Map is the replicated placement of the slots which were moved from the shard the ring placed them in.
+//
+// It's stored in shard 0, and changed by the administrative operations which split, merge and rebalance shards.
+type Map struct {
+	// Version is increased on every change, a change is refused if it wasn't based on the current version.
+	Version uint64 `json:"version"`
+	// Owners are the shards of the moved slots.
+	Owners map[int]int `json:"owners,omitempty"`
+	// Moving are the slots being moved, with the shard they are moved to.
+	Moving map[int]int `json:"moving,omitempty"`
+}
+
+// Locate returns the shard of a slot.
+func (m Map) Locate(r *Ring, slot int) int {
+	if owner, ok := m.Owners[slot]; ok {
+		return owner
+	}
+	return r.Locate(slot)
+}
+
+// SlotsOf returns the slots of a shard, in ascending order.
+func (m Map) SlotsOf(r *Ring, shard int) []int {
+	slots := make([]int, 0)
+	for slot := 0; slot < Slots; slot++ {
+		if m.Locate(r, slot) == shard {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// Clone returns a copy of the map, which can be changed without changing the original.
+func (m Map) Clone() Map {
+	c := Map{Version: m.Version, Owners: make(map[int]int, len(m.Owners)), Moving: make(map[int]int, len(m.Moving))}
+	for slot, owner := range m.Owners {
+		c.Owners[slot] = owner
+	}
+	for slot, target := range m.Moving {
+		c.Moving[slot] = target
+	}
+	return c
+}
+
+// Balance returns the moves which even out the number of slots of the shards, as a map of slot to target shard.
+//
+// The slots are taken from the shards which have more than their share, and given to the ones which have less.
+func (m Map) Balance(r *Ring) map[int]int {
+	shards := r.Shards()
+	slotsOf := make([][]int, shards)
+	for slot := 0; slot < Slots; slot++ {
+		owner := m.Locate(r, slot)
+		slotsOf[owner] = append(slotsOf[owner], slot)
+	}
+
+	// The first Slots%shards shards can keep one more slot, so the shares add up to all the slots.
+	share := func(shard int) int {
+		if shard < Slots%shards {
+			return Slots/shards + 1
+		}
+		return Slots / shards
+	}
+	var surplus []int
+	for s := 0; s < shards; s++ {
+		if extra := len(slotsOf[s]) - share(s); extra > 0 {
+			surplus = append(surplus, slotsOf[s][len(slotsOf[s])-extra:]...)
+		}
+	}
+	sort.Ints(surplus)
+
+	moves := make(map[int]int)
+	for s := 0; s < shards; s++ {
+		for missing := share(s) - len(slotsOf[s]); missing > 0 && len(surplus) > 0; missing-- {
+			moves[surplus[0]] = s
+			surplus = surplus[1:]
+		}
+	}
+	return moves
+}
@@ -0,0 +1,120 @@
+// Package shard partitions the keyspace across several consensus groups hosted by the same nodes,
+// so the writes aren't limited by the throughput of a single leader.
+package shard
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MetadataKey is the gRPC metadata key which carries the shard a call is for, calls without it are for shard 0.
+	MetadataKey = "nubedb-shard"
+	// Slots is the number of slots the keyspace is divided into, they are the unit moved between shards.
+	Slots = 1024
+	// virtualNodes is the number of points each shard owns in the ring, more points spread the slots more evenly.
+	virtualNodes = 128
+	// bucketSep separates the bucket from the rest of the key, it must match the FSM's one.
+	bucketSep = "/"
+)
+
+// Ring places the slots in the shards with consistent hashing, before they are moved by a Map.
+//
+// Each shard owns many points of the ring, and a slot belongs to the shard of the first point after its hash,
+// so adding a shard only moves the slots of the points it takes from the others.
+type Ring struct {
+	shards int
+	points []point
+}
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// NewRing returns the ring of a number of shards, every node must use the same number.
+func NewRing(shards int) *Ring {
+	if shards < 1 {
+		shards = 1
+	}
+	r := &Ring{shards: shards, points: make([]point, 0, shards*virtualNodes)}
+	for s := 0; s < shards; s++ {
+		for v := 0; v < virtualNodes; v++ {
+			r.points = append(r.points, point{hash: hash(strconv.Itoa(s) + "#" + strconv.Itoa(v)), shard: s})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+// Shards returns the number of shards of the ring.
+func (r *Ring) Shards() int {
+	return r.shards
+}
+
+// Locate returns the shard a slot is placed in by the ring.
+func (r *Ring) Locate(slot int) int {
+	if r.shards == 1 {
+		return 0
+	}
+	h := hash("slot#" + strconv.Itoa(slot))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
+
+// SlotOf returns the slot of a key.
+func SlotOf(key string) int {
+	return int(hash(RoutingKey(key)) % Slots)
+}
+
+// RoutingKey returns the part of a key which chooses its shard: its bucket, or the whole key if it isn't in one.
+//
+// All the keys of a bucket are stored in the same shard, so the bucket's settings, like its search index
+// or its encryption key, are stored next to its keys.
+func RoutingKey(key string) string {
+	bucket, _, found := strings.Cut(key, bucketSep)
+	if !found || bucket == "" {
+		return key
+	}
+	return bucket
+}
+
+// hash returns the FNV-1a hash of s, with its bits mixed so close strings land far apart in the ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// WithShard returns a context which sends the shard a gRPC call is for.
+func WithShard(ctx context.Context, id int) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, strconv.Itoa(id))
+}
+
+// FromContext returns the shard an incoming gRPC call is for, 0 if the caller didn't send one.
+func FromContext(ctx context.Context) (int, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(MetadataKey)
+	if len(values) == 0 {
+		return 0, nil
+	}
+	id, errParse := strconv.Atoi(values[0])
+	if errParse != nil || id < 0 {
+		return 0, fmt.Errorf("invalid shard: %s", values[0])
+	}
+	return id, nil
+}
@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/api/proto"
+	"nubedb/api/proto/protoclient"
+	"nubedb/cluster/peerauth"
+	"nubedb/cluster/shard"
+	"sync"
+)
+
+// shardIDs maps the consensus of every shard, other than shard 0, to its ID.
+var shardIDs sync.Map
+
+// RegisterShard registers the consensus of a shard, so the writes executed on it are forwarded to its leader's shard.
+func RegisterShard(id int, consensus *raft.Raft) {
+	shardIDs.Store(consensus, id)
+}
+
+// shardOf returns the ID of the shard of a consensus, 0 if it wasn't registered.
+func shardOf(consensus *raft.Raft) int {
+	id, ok := shardIDs.Load(consensus)
+	if !ok {
+		return 0
+	}
+	return id.(int)
+}
+
+// ShardJoin asks a node to add another one to the consensus of a shard.
+func ShardJoin(shardID int, nodeID string, nodeConsensusAddr string, grpcAddr string) error {
+	conn, errConn := protoclient.NewConnection(grpcAddr)
+	if errConn != nil {
+		return errConn
+	}
+	defer conn.Cleanup()
+
+	ctx := peerauth.WithProof(shard.WithShard(conn.Ctx, shardID), nodeID, nodeConsensusAddr)
+	_, errTalk := conn.Client.ConsensusJoin(ctx, &proto.ConsensusRequest{
+		NodeID:            nodeID,
+		NodeConsensusAddr: nodeConsensusAddr,
+	})
+	if errTalk != nil {
+		return errorskit.Wrap(errTalk, errGrpcTalkLeader)
+	}
+
+	return nil
+}
+
+// ShardRemove asks a node to remove another one from the consensus of a shard.
+func ShardRemove(shardID int, nodeID string, grpcAddr string) error {
+	conn, errConn := protoclient.NewConnection(grpcAddr)
+	if errConn != nil {
+		return errConn
+	}
+	defer conn.Cleanup()
+
+	ctx := peerauth.WithProof(shard.WithShard(conn.Ctx, shardID), nodeID, "")
+	_, errTalk := conn.Client.ConsensusRemove(ctx, &proto.ConsensusRequest{
+		NodeID: nodeID,
+	})
+	if errTalk != nil {
+		return errorskit.Wrap(errTalk, errGrpcTalkLeader)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package systemd integrates the node with systemd when it's run as a service of Type=notify:
+// it reports the node as ready once every shard has a leader, and pings the watchdog while the node is responsive,
+// so systemd restarts a hung node.
+package systemd
+
+import (
+	"fmt"
+	"log"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/pkg/sdnotify"
+	"time"
+)
+
+// leaderPollInterval is how often the shards are checked for a leader before the node is reported as ready.
+const leaderPollInterval = 500 * time.Millisecond
+
+// probeKey is read from the FSMs to check they are responsive, it doesn't need to exist.
+const probeKey = fsm.InternalPrefix + "watchdog"
+
+// Start notifies systemd once the node is ready, and then pings its watchdog while the node is responsive,
+// blocks indefinitely. It returns right away if enabled is false or the node isn't run by systemd.
+func Start(n *consensus.Node, enabled bool) {
+	if !enabled || !sdnotify.Enabled() {
+		return
+	}
+	waitForLeaders(n)
+	errReady := sdnotify.Notify(sdnotify.Ready + "\nSTATUS=every shard has a leader")
+	if errReady != nil {
+		log.Println("[systemd]", errReady)
+		return
+	}
+	log.Println("[systemd] notified the node is ready")
+
+	interval := sdnotify.WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	// Pinging at half the interval leaves time for a slow probe, without systemd considering the node hung.
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		errProbe := probe(n, interval/2)
+		if errProbe != nil {
+			log.Println("[systemd] the watchdog isn't pinged, the node isn't responsive:", errProbe)
+			continue
+		}
+		errPing := sdnotify.Notify(sdnotify.Watchdog)
+		if errPing != nil {
+			log.Println("[systemd]", errPing)
+		}
+	}
+}
+
+// Stopping notifies systemd the node is shutting down, if it's run by it.
+func Stopping() {
+	if !sdnotify.Enabled() {
+		return
+	}
+	errStopping := sdnotify.Notify(sdnotify.Stopping)
+	if errStopping != nil {
+		log.Println("[systemd]", errStopping)
+	}
+}
+
+// waitForLeaders blocks until every shard has a leader.
+func waitForLeaders(n *consensus.Node) {
+	for {
+		ready := true
+		for _, s := range n.Shards() {
+			if _, leaderID := s.Consensus.LeaderWithID(); leaderID == "" {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			return
+		}
+		time.Sleep(leaderPollInterval)
+	}
+}
+
+// probe checks the consensus and the FSM of every shard answer within timeout.
+func probe(n *consensus.Node, timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, s := range n.Shards() {
+			_ = s.Consensus.Stats()
+			_, _ = s.FSM.Get(probeKey)
+		}
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("the shards didn't answer within %v", timeout)
+	}
+}
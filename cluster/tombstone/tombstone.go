@@ -0,0 +1,39 @@
+// Package tombstone is responsible for purging the tombstones of the soft deleted keys once their retention expires.
+package tombstone
+
+import (
+	"github.com/hashicorp/raft"
+	"log"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"time"
+)
+
+// StartPurge periodically purges the expired tombstones while the node is the leader, blocks indefinitely.
+//
+// The purge is replicated with the cutoff time calculated by the leader, so every node purges the same tombstones.
+//
+// If soft delete is disabled, it returns immediately.
+func StartPurge(consensus *raft.Raft, cfg config.SoftDeleteCfg) {
+	if cfg.Retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.PurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if consensus.State() != raft.Leader {
+			continue
+		}
+		payload := &fsm.Payload{
+			Key:       "tombstones",
+			Value:     time.Now().Add(-cfg.Retention).UTC().Format(time.RFC3339Nano),
+			Operation: "PURGETOMBSTONES",
+		}
+		errPurge := cluster.Execute(consensus, payload)
+		if errPurge != nil {
+			log.Println("[tombstone] couldn't purge expired tombstones:", errPurge)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package valuecrypt implements the application-level envelope encryption of the values of a bucket.
+//
+// Every encrypted bucket has its own data key, which is stored wrapped (encrypted) with the master key.
+//
+// Values are encrypted by the node receiving the request, before they enter the consensus,
+// so they never appear in plaintext in the consensus logs, the snapshots or the backups.
+package valuecrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/errcode"
+	"nubedb/pkg/encrypt"
+	"strings"
+	"sync"
+)
+
+const (
+	// encryptedPrefix marks a stored value as encrypted.
+	encryptedPrefix = "nubedb:enc:v1:"
+	dataKeyLen      = 32
+)
+
+var (
+	// ErrNoMasterKey is returned when a bucket is encrypted, but the node doesn't have a master key configured.
+	ErrNoMasterKey = errcode.New(errcode.NotEnabled, "value encryption master key is not configured")
+	// ErrAppendEncrypted is returned when appending to an encrypted bucket, since the FSM can't decrypt its values.
+	ErrAppendEncrypted = errcode.New(errcode.Encrypted, "append is not supported on encrypted buckets")
+	// ErrStreamEncrypted is returned when streaming a value of an encrypted bucket, since it's encrypted as a whole.
+	ErrStreamEncrypted = errcode.New(errcode.Encrypted, "streaming is not supported on encrypted buckets")
+)
+
+// Crypter encrypts and decrypts the values of the encrypted buckets.
+type Crypter struct {
+	master *encrypt.Keyring
+	mu     sync.RWMutex
+	// dataKeys caches the unwrapped data keys by their wrapped form.
+	dataKeys map[string]*encrypt.Keyring
+}
+
+// New returns a Crypter. masterKey can be empty, in which case encrypted buckets can't be used on this node.
+func New(masterKey []byte) (*Crypter, error) {
+	c := &Crypter{dataKeys: make(map[string]*encrypt.Keyring)}
+	if len(masterKey) <= 0 {
+		return c, nil
+	}
+
+	master, errMaster := encrypt.NewKeyring(masterKey)
+	if errMaster != nil {
+		return nil, errMaster
+	}
+	c.master = master
+	return c, nil
+}
+
+// NewWrappedDataKey generates a new data key and returns it wrapped with the master key, encoded as base64.
+func (c *Crypter) NewWrappedDataKey() (string, error) {
+	if c.master == nil {
+		return "", ErrNoMasterKey
+	}
+
+	key := make([]byte, dataKeyLen)
+	_, errRand := rand.Read(key)
+	if errRand != nil {
+		return "", errRand
+	}
+
+	wrapped, errWrap := c.master.Encrypt(key)
+	if errWrap != nil {
+		return "", errWrap
+	}
+	return base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// IsEncrypted returns whether the bucket of a key is encrypted.
+func (c *Crypter) IsEncrypted(dbFSM *fsm.DatabaseFSM, k string) bool {
+	_, err := dbFSM.GetBucketKey(fsm.BucketOf(k))
+	return err == nil
+}
+
+// Encrypt encrypts a value if the bucket of its key is encrypted, otherwise it returns the value as it is.
+func (c *Crypter) Encrypt(dbFSM *fsm.DatabaseFSM, k string, value any) (any, error) {
+	dataKey, errKey := c.dataKey(dbFSM, k)
+	if errKey != nil || dataKey == nil {
+		return value, errKey
+	}
+
+	plain, errMarshal := json.Marshal(value)
+	if errMarshal != nil {
+		return nil, errorskit.Wrap(errMarshal, "couldn't marshal value to encrypt")
+	}
+	encrypted, errEncrypt := dataKey.Encrypt(plain)
+	if errEncrypt != nil {
+		return nil, errEncrypt
+	}
+
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// Decrypt decrypts a value if it was encrypted, otherwise it returns the value as it is.
+func (c *Crypter) Decrypt(dbFSM *fsm.DatabaseFSM, k string, value any) (any, error) {
+	s, isStr := value.(string)
+	if !isStr || !strings.HasPrefix(s, encryptedPrefix) {
+		return value, nil
+	}
+
+	dataKey, errKey := c.dataKey(dbFSM, k)
+	if errKey != nil {
+		return nil, errKey
+	}
+	if dataKey == nil {
+		return nil, errors.New("value is encrypted, but its bucket doesn't have a key")
+	}
+
+	encrypted, errDecode := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, encryptedPrefix))
+	if errDecode != nil {
+		return nil, errorskit.Wrap(errDecode, "couldn't decode encrypted value")
+	}
+	plain, errDecrypt := dataKey.Decrypt(encrypted)
+	if errDecrypt != nil {
+		return nil, errorskit.Wrap(errDecrypt, "couldn't decrypt value")
+	}
+
+	var result any
+	errUnmarshal := json.Unmarshal(plain, &result)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't unmarshal decrypted value")
+	}
+	return result, nil
+}
+
+// dataKey returns the unwrapped data key of the bucket of a key, or nil if the bucket isn't encrypted.
+func (c *Crypter) dataKey(dbFSM *fsm.DatabaseFSM, k string) (*encrypt.Keyring, error) {
+	wrapped, errGet := dbFSM.GetBucketKey(fsm.BucketOf(k))
+	if errGet != nil {
+		if errors.Is(errGet, engine.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, errGet
+	}
+	if c.master == nil {
+		return nil, ErrNoMasterKey
+	}
+
+	c.mu.RLock()
+	dataKey, ok := c.dataKeys[wrapped]
+	c.mu.RUnlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	decoded, errDecode := base64.StdEncoding.DecodeString(wrapped)
+	if errDecode != nil {
+		return nil, errorskit.Wrap(errDecode, "couldn't decode bucket key")
+	}
+	key, errUnwrap := c.master.Decrypt(decoded)
+	if errUnwrap != nil {
+		return nil, errorskit.Wrap(errUnwrap, "couldn't unwrap bucket key, is the master key the same on every node?")
+	}
+	dataKey, errKeyring := encrypt.NewKeyring(key)
+	if errKeyring != nil {
+		return nil, errKeyring
+	}
+
+	c.mu.Lock()
+	c.dataKeys[wrapped] = dataKey
+	c.mu.Unlock()
+	return dataKey, nil
+}
@@ -0,0 +1,174 @@
+// Package webhook is responsible for delivering the committed changes of the keys to the URLs registered for them.
+//
+// The webhooks are replicated in the FSM of every shard, and the leader of each shard delivers its changes,
+// with the same at-least-once checkpoints as CDC: the changes are delivered, and only then, the checkpoint is replicated.
+//
+// Every request is signed with the HMAC-SHA256 of its body, using the webhook's secret as the key.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"log"
+	"net/http"
+	"nubedb/cluster/cdc"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"nubedb/internal/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the signature of the body: "sha256=" followed by the hex encoded HMAC-SHA256.
+	SignatureHeader = "X-Nubedb-Signature"
+	// WebhookHeader carries the name of the webhook the change is delivered to.
+	WebhookHeader = "X-Nubedb-Webhook"
+	// DeliveryHeader carries the unique ID of the change, so the receiver can discard the ones delivered twice.
+	DeliveryHeader = "X-Nubedb-Delivery"
+)
+
+// publisher delivers the changes of a shard to its webhooks.
+type publisher struct {
+	shard  int
+	hooks  []fsm.Webhook
+	client *http.Client
+	cfg    config.WebhookCfg
+}
+
+// Start delivers the changes of the shards this node is the leader of to their webhooks, blocks indefinitely.
+//
+// The shards without webhooks are skipped, so their changes aren't recorded.
+func Start(node *consensus.Node, cfg config.WebhookCfg) {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	client := &http.Client{Timeout: cfg.Timeout}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, s := range node.Shards() {
+			if s.Consensus.State() != raft.Leader {
+				continue
+			}
+			hooks, errHooks := s.FSM.GetWebhooks()
+			if errHooks != nil {
+				log.Printf("[webhook] couldn't get the webhooks of shard %v: %v\n", s.ID, errHooks)
+				continue
+			}
+			if len(hooks) <= 0 {
+				continue
+			}
+
+			p := &publisher{shard: s.ID, hooks: hooks, client: client, cfg: cfg}
+			errPublish := cdc.PublishPending(s.Consensus, s.FSM, fsm.WebhooksConsumer, p, cfg.BatchSize)
+			if errPublish != nil {
+				log.Printf("[webhook] couldn't deliver the changes of shard %v: %v\n", s.ID, errPublish)
+			}
+		}
+	}
+}
+
+// Publish delivers the changes to the webhooks of their keys, every webhook receives its changes in order.
+//
+// A change which fails all its attempts is dropped, with the rest of the batch's changes for the same webhook,
+// so a webhook which is down doesn't hold the changes of the rest.
+func (p *publisher) Publish(changes []fsm.ChangeEvent) error {
+	var wg sync.WaitGroup
+	for _, hook := range p.hooks {
+		wg.Add(1)
+		go func(hook fsm.Webhook) {
+			defer wg.Done()
+			p.deliverAll(hook, changes)
+		}(hook)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (p *publisher) Close() error {
+	return nil
+}
+
+func (p *publisher) deliverAll(hook fsm.Webhook, changes []fsm.ChangeEvent) {
+	down := false
+	for _, change := range changes {
+		if !strings.HasPrefix(change.Key, hook.Prefix) {
+			continue
+		}
+		if !down {
+			errDeliver := p.deliver(hook, change)
+			if errDeliver == nil {
+				metrics.RecordWebhookDelivery(hook.Name, true)
+				continue
+			}
+			log.Printf("[webhook] dropping changes for '%s' from index %v: %v\n", hook.Name, change.Index, errDeliver)
+			down = true
+		}
+		metrics.RecordWebhookDelivery(hook.Name, false)
+	}
+}
+
+// deliver sends a change to a webhook, retrying it with exponential backoff until it's answered with a 2xx.
+func (p *publisher) deliver(hook fsm.Webhook, change fsm.ChangeEvent) error {
+	const (
+		baseDelay = 500 * time.Millisecond
+		maxDelay  = 10 * time.Second
+	)
+	body, errMarshal := json.Marshal(change)
+	if errMarshal != nil {
+		return errMarshal
+	}
+
+	delay := baseDelay
+	var lastErr error
+	for attempt := 1; attempt <= p.cfg.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+		lastErr = p.post(hook, change, body)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (p *publisher) post(hook fsm.Webhook, change fsm.ChangeEvent, body []byte) error {
+	req, errReq := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if errReq != nil {
+		return errReq
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeader, hook.Name)
+	req.Header.Set(DeliveryHeader, fmt.Sprintf("%v-%v", p.shard, change.Index))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	res, errDo := p.client.Do(req)
+	if errDo != nil {
+		return errDo
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered with status %v", res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature of a body with a webhook's secret, as sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
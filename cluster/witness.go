@@ -0,0 +1,18 @@
+package cluster
+
+import (
+	"nubedb/cluster/errcode"
+	"sync/atomic"
+)
+
+// ErrWitnessLeader is returned when a write reaches a witness elected as the leader, before it transfers the leadership,
+// since its FSM doesn't store data it would acknowledge the write without applying it. It's retried like a leader election.
+var ErrWitnessLeader = errcode.NewLeadership(errcode.Unavailable, "the leader is a witness which is transferring the leadership")
+
+// witness is whether the node is a witness, it's set once on startup with ConfigureWitness.
+var witness atomic.Bool
+
+// ConfigureWitness sets whether the node is a witness, which refuses the writes when it's elected as the leader.
+func ConfigureWitness(isWitness bool) {
+	witness.Store(isWitness)
+}
@@ -0,0 +1,241 @@
+package cloud
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsProvider finds the EC2 instances with a tag.
+//
+// The credentials are the ones of the config, of the environment, or of the instance's role.
+type awsProvider struct {
+	region       string
+	tagKey       string
+	tagValue     string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	// endpoint is the EC2 API endpoint, built from the region if empty. metadataURL is the instance metadata service's.
+	endpoint    string
+	metadataURL string
+}
+
+// awsCredentials are the credentials requests are signed with.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+type describeInstancesResponse struct {
+	Reservations []struct {
+		Instances []struct {
+			PrivateIPAddress string `xml:"privateIpAddress"`
+		} `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+func newAWS(args map[string]string) (*awsProvider, error) {
+	errRequired := required(args, "aws", "tag_key", "tag_value")
+	if errRequired != nil {
+		return nil, errRequired
+	}
+	p := &awsProvider{
+		region:       firstNonEmpty(args["region"], os.Getenv("AWS_REGION")),
+		tagKey:       args["tag_key"],
+		tagValue:     args["tag_value"],
+		accessKey:    firstNonEmpty(args["access_key_id"], os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey:    firstNonEmpty(args["secret_access_key"], os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		endpoint:     args["endpoint"],
+		metadataURL:  "http://169.254.169.254",
+	}
+	return p, nil
+}
+
+func (p *awsProvider) Addrs(ctx context.Context) ([]string, error) {
+	creds, errCreds := p.credentials(ctx)
+	if errCreds != nil {
+		return nil, errCreds
+	}
+	region := p.region
+	if region == "" {
+		metadataRegion, errRegion := p.metadata(ctx, "/latest/meta-data/placement/region")
+		if errRegion != nil {
+			return nil, errRegion
+		}
+		region = metadataRegion
+	}
+	endpoint := p.endpoint
+	if endpoint == "" {
+		endpoint = "https://ec2." + region + ".amazonaws.com"
+	}
+
+	var addrs []string
+	nextToken := ""
+	for {
+		params := url.Values{
+			"Action":           {"DescribeInstances"},
+			"Version":          {"2016-11-15"},
+			"Filter.1.Name":    {"tag:" + p.tagKey},
+			"Filter.1.Value.1": {p.tagValue},
+			"Filter.2.Name":    {"instance-state-name"},
+			"Filter.2.Value.1": {"running"},
+		}
+		if nextToken != "" {
+			params.Set("NextToken", nextToken)
+		}
+		req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/?"+awsQuery(params), nil)
+		if errReq != nil {
+			return nil, errReq
+		}
+		signV4(req, creds, region, "ec2", time.Now())
+		body, errDo := do(req)
+		if errDo != nil {
+			return nil, errDo
+		}
+
+		var res describeInstancesResponse
+		errUnmarshal := xml.Unmarshal(body, &res)
+		if errUnmarshal != nil {
+			return nil, errUnmarshal
+		}
+		for _, reservation := range res.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.PrivateIPAddress != "" {
+					addrs = append(addrs, instance.PrivateIPAddress)
+				}
+			}
+		}
+		if res.NextToken == "" {
+			return addrs, nil
+		}
+		nextToken = res.NextToken
+	}
+}
+
+// credentials returns the configured credentials, or the ones of the instance's role.
+func (p *awsProvider) credentials(ctx context.Context) (awsCredentials, error) {
+	if p.accessKey != "" && p.secretKey != "" {
+		return awsCredentials{AccessKeyID: p.accessKey, SecretAccessKey: p.secretKey, Token: p.sessionToken}, nil
+	}
+	const rolesPath = "/latest/meta-data/iam/security-credentials/"
+	roles, errRoles := p.metadata(ctx, rolesPath)
+	if errRoles != nil {
+		return awsCredentials{}, errRoles
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(roles), "\n")
+	if role == "" {
+		return awsCredentials{}, errors.New("no AWS credentials configured, and the instance has no role")
+	}
+
+	var creds awsCredentials
+	token, errToken := p.metadataToken(ctx)
+	if errToken != nil {
+		return creds, errToken
+	}
+	errCreds := getJSON(ctx, p.metadataURL+rolesPath+role, map[string]string{"X-aws-ec2-metadata-token": token}, &creds)
+	return creds, errCreds
+}
+
+// metadata returns a value of the instance metadata service, with IMDSv2.
+func (p *awsProvider) metadata(ctx context.Context, path string) (string, error) {
+	token, errToken := p.metadataToken(ctx)
+	if errToken != nil {
+		return "", errToken
+	}
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, p.metadataURL+path, nil)
+	if errReq != nil {
+		return "", errReq
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	body, errDo := do(req)
+	return string(body), errDo
+}
+
+func (p *awsProvider) metadataToken(ctx context.Context) (string, error) {
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodPut, p.metadataURL+"/latest/api/token", nil)
+	if errReq != nil {
+		return "", errReq
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	body, errDo := do(req)
+	return string(body), errDo
+}
+
+// signV4 signs a request without body with AWS Signature Version 4, signing its host and all its headers.
+func signV4(req *http.Request, creds awsCredentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// awsQuery encodes a query sorted by key, with the spaces encoded as %20 as Signature Version 4 requires.
+func awsQuery(params url.Values) string {
+	return strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// azureResource is the resource the tokens of the Azure Resource Manager API are requested for.
+const azureResource = "https://management.azure.com/"
+
+// azureProvider finds the network interfaces of Azure with a tag.
+//
+// It authenticates with a service principal if it's configured, or with the managed identity of the VM.
+type azureProvider struct {
+	subscriptionID string
+	resourceGroup  string
+	tagName        string
+	tagValue       string
+	tenantID       string
+	clientID       string
+	secret         string
+	// endpoint is the Azure Resource Manager API endpoint, loginURL is the Azure AD's,
+	// and metadataURL is the one of the VM's metadata service.
+	endpoint    string
+	loginURL    string
+	metadataURL string
+}
+
+type azureNetworkInterfaces struct {
+	Value []struct {
+		Tags       map[string]string `json:"tags"`
+		Properties struct {
+			IPConfigurations []struct {
+				Properties struct {
+					PrivateIPAddress string `json:"privateIPAddress"`
+				} `json:"properties"`
+			} `json:"ipConfigurations"`
+		} `json:"properties"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+func newAzure(args map[string]string) (*azureProvider, error) {
+	errRequired := required(args, "azure", "tag_name", "tag_value")
+	if errRequired != nil {
+		return nil, errRequired
+	}
+	if args["client_id"] != "" {
+		errPrincipal := required(args, "azure", "tenant_id", "secret_access_key")
+		if errPrincipal != nil {
+			return nil, errPrincipal
+		}
+	}
+	return &azureProvider{
+		subscriptionID: args["subscription_id"],
+		resourceGroup:  args["resource_group"],
+		tagName:        args["tag_name"],
+		tagValue:       args["tag_value"],
+		tenantID:       args["tenant_id"],
+		clientID:       args["client_id"],
+		secret:         args["secret_access_key"],
+		endpoint:       "https://management.azure.com",
+		loginURL:       "https://login.microsoftonline.com",
+		metadataURL:    "http://169.254.169.254",
+	}, nil
+}
+
+func (p *azureProvider) Addrs(ctx context.Context) ([]string, error) {
+	token, errToken := p.token(ctx)
+	if errToken != nil {
+		return nil, errToken
+	}
+	subscriptionID := p.subscriptionID
+	if subscriptionID == "" {
+		u := p.metadataURL + "/metadata/instance/compute/subscriptionId?api-version=2021-02-01&format=text"
+		req, errReq := newMetadataRequest(ctx, u, "Metadata", "true")
+		if errReq != nil {
+			return nil, errReq
+		}
+		body, errDo := do(req)
+		if errDo != nil {
+			return nil, errDo
+		}
+		subscriptionID = strings.TrimSpace(string(body))
+	}
+
+	u := p.endpoint + "/subscriptions/" + url.PathEscape(subscriptionID)
+	if p.resourceGroup != "" {
+		u += "/resourceGroups/" + url.PathEscape(p.resourceGroup)
+	}
+	u += "/providers/Microsoft.Network/networkInterfaces?api-version=2022-07-01"
+
+	var addrs []string
+	for u != "" {
+		var res azureNetworkInterfaces
+		errList := getJSON(ctx, u, map[string]string{"Authorization": "Bearer " + token}, &res)
+		if errList != nil {
+			return nil, errList
+		}
+		for _, nic := range res.Value {
+			if nic.Tags[p.tagName] != p.tagValue {
+				continue
+			}
+			for _, ipCfg := range nic.Properties.IPConfigurations {
+				if ipCfg.Properties.PrivateIPAddress != "" {
+					addrs = append(addrs, ipCfg.Properties.PrivateIPAddress)
+				}
+			}
+		}
+		u = res.NextLink
+	}
+	return addrs, nil
+}
+
+// token returns an access token of the service principal, or of the VM's managed identity.
+func (p *azureProvider) token(ctx context.Context) (string, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if p.clientID == "" {
+		u := p.metadataURL + "/metadata/identity/oauth2/token?api-version=2018-02-01&resource=" + url.QueryEscape(azureResource)
+		errToken := getJSON(ctx, u, map[string]string{"Metadata": "true"}, &token)
+		return token.AccessToken, errToken
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {p.clientID},
+		"client_secret": {p.secret},
+		"resource":      {azureResource},
+	}
+	u := p.loginURL + "/" + url.PathEscape(p.tenantID) + "/oauth2/token"
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if errReq != nil {
+		return "", errReq
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, errDo := do(req)
+	if errDo != nil {
+		return "", errDo
+	}
+	errUnmarshal := json.Unmarshal(body, &token)
+	return token.AccessToken, errUnmarshal
+}
@@ -0,0 +1,111 @@
+// Package cloud finds the nodes of a cloud deployment from the tags or labels of their instances,
+// so they can join each other without mDNS, which cloud networks don't support.
+//
+// It's configured like go-discover, with a string of space separated key=value pairs, where provider selects the cloud:
+//
+//	provider=aws region=eu-west-1 tag_key=nubedb tag_value=prod
+//	provider=gce project=my-project label_key=nubedb label_value=prod
+//	provider=azure subscription_id=... resource_group=my-group tag_name=nubedb tag_value=prod
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Provider finds the nodes in a cloud.
+type Provider interface {
+	// Addrs returns the private IPs of the running instances tagged as nodes.
+	Addrs(ctx context.Context) ([]string, error)
+}
+
+// httpClient is the client of the cloud APIs and their metadata services.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// New returns the provider configured by spec.
+func New(spec string) (Provider, error) {
+	args, errArgs := parseArgs(spec)
+	if errArgs != nil {
+		return nil, errArgs
+	}
+	switch args["provider"] {
+	case "aws":
+		return newAWS(args)
+	case "gce", "gcp":
+		return newGCE(args)
+	case "azure":
+		return newAzure(args)
+	default:
+		return nil, fmt.Errorf("cloud provider not recognized: '%s', it can be aws, gce or azure", args["provider"])
+	}
+}
+
+func parseArgs(spec string) (map[string]string, error) {
+	args := make(map[string]string)
+	for _, field := range strings.Fields(spec) {
+		k, v, found := strings.Cut(field, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid cloud discovery argument '%s', it must be key=value", field)
+		}
+		args[k] = v
+	}
+	return args, nil
+}
+
+// required returns an error naming the first of the keys which is missing in args.
+func required(args map[string]string, provider string, keys ...string) error {
+	for _, k := range keys {
+		if args[k] == "" {
+			return fmt.Errorf("%s cloud discovery requires %s", provider, k)
+		}
+	}
+	return nil
+}
+
+// do sends a request, returning its body if it succeeded.
+func do(req *http.Request) ([]byte, error) {
+	res, errDo := httpClient.Do(req)
+	if errDo != nil {
+		return nil, errDo
+	}
+	defer res.Body.Close()
+	body, errRead := io.ReadAll(res.Body)
+	if errRead != nil {
+		return nil, errRead
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, res.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// newMetadataRequest returns a GET request to a metadata service, with the header it requires.
+func newMetadataRequest(ctx context.Context, url string, header string, value string) (*http.Request, error) {
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if errReq != nil {
+		return nil, errReq
+	}
+	req.Header.Set(header, value)
+	return req, nil
+}
+
+// getJSON sends a GET request with the given headers, decoding its JSON response into out.
+func getJSON(ctx context.Context, url string, headers map[string]string, out any) error {
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if errReq != nil {
+		return errReq
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	body, errDo := do(req)
+	if errDo != nil {
+		return errDo
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,129 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// gceProvider finds the Compute Engine instances with a label, or a network tag.
+//
+// It authenticates with the service account of the instance it runs on.
+type gceProvider struct {
+	project    string
+	labelKey   string
+	labelValue string
+	tag        string
+	// endpoint is the Compute Engine API endpoint. metadataURL is the metadata server's.
+	endpoint    string
+	metadataURL string
+}
+
+type gceInstance struct {
+	Status string            `json:"status"`
+	Labels map[string]string `json:"labels"`
+	Tags   struct {
+		Items []string `json:"items"`
+	} `json:"tags"`
+	NetworkInterfaces []struct {
+		NetworkIP string `json:"networkIP"`
+	} `json:"networkInterfaces"`
+}
+
+type gceAggregatedInstances struct {
+	Items map[string]struct {
+		Instances []gceInstance `json:"instances"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func newGCE(args map[string]string) (*gceProvider, error) {
+	if args["label_key"] == "" && args["tag_value"] == "" {
+		return nil, errors.New("gce cloud discovery requires label_key and label_value, or tag_value")
+	}
+	return &gceProvider{
+		project:     args["project"],
+		labelKey:    args["label_key"],
+		labelValue:  args["label_value"],
+		tag:         args["tag_value"],
+		endpoint:    "https://compute.googleapis.com",
+		metadataURL: "http://metadata.google.internal",
+	}, nil
+}
+
+func (p *gceProvider) Addrs(ctx context.Context) ([]string, error) {
+	metadataHeaders := map[string]string{"Metadata-Flavor": "Google"}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	errToken := getJSON(ctx, p.metadataURL+"/computeMetadata/v1/instance/service-accounts/default/token", metadataHeaders, &token)
+	if errToken != nil {
+		return nil, errToken
+	}
+	project := p.project
+	if project == "" {
+		var errProject error
+		project, errProject = p.metadata(ctx, "/computeMetadata/v1/project/project-id")
+		if errProject != nil {
+			return nil, errProject
+		}
+	}
+
+	var addrs []string
+	pageToken := ""
+	for {
+		u := p.endpoint + "/compute/v1/projects/" + url.PathEscape(project) + "/aggregated/instances"
+		if pageToken != "" {
+			u += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		var res gceAggregatedInstances
+		errList := getJSON(ctx, u, map[string]string{"Authorization": "Bearer " + token.AccessToken}, &res)
+		if errList != nil {
+			return nil, errList
+		}
+		for _, zone := range res.Items {
+			for _, instance := range zone.Instances {
+				if !p.matches(instance) {
+					continue
+				}
+				for _, nic := range instance.NetworkInterfaces {
+					if nic.NetworkIP != "" {
+						addrs = append(addrs, nic.NetworkIP)
+					}
+				}
+			}
+		}
+		if res.NextPageToken == "" {
+			return addrs, nil
+		}
+		pageToken = res.NextPageToken
+	}
+}
+
+// matches returns whether an instance is running, and has the label and the network tag, if they are configured.
+func (p *gceProvider) matches(instance gceInstance) bool {
+	if instance.Status != "RUNNING" {
+		return false
+	}
+	if p.labelKey != "" && instance.Labels[p.labelKey] != p.labelValue {
+		return false
+	}
+	if p.tag == "" {
+		return true
+	}
+	for _, tag := range instance.Tags.Items {
+		if tag == p.tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *gceProvider) metadata(ctx context.Context, path string) (string, error) {
+	req, errReq := newMetadataRequest(ctx, p.metadataURL+path, "Metadata-Flavor", "Google")
+	if errReq != nil {
+		return "", errReq
+	}
+	body, errDo := do(req)
+	return string(body), errDo
+}
@@ -8,36 +8,54 @@ import (
 	"log"
 	"net"
 	"nubedb/cluster"
+	"nubedb/discover/cloud"
 	"nubedb/internal/config"
 	"strings"
 	"sync"
 	"time"
 )
 
+// iface is the network interface the nodes are discovered on, set once on startup with ConfigureInterface.
+var iface *net.Interface
+
+// cloudProvider finds the nodes in the cloud instead of mDNS if it's set, once on startup with ConfigureCloud.
+var cloudProvider cloud.Provider
+
 const (
 	// The service name identifier used for the discovery.
 	serviceName       = "_nubedb._tcp"
 	ErrLeaderNotFound = "couldn't find a leader"
+	// The prefixes of the info fields holding the ID and the advertise host of a node.
+	infoID   = "id="
+	infoHost = "host="
 )
 
-// ServeAndBlock creates a new discovery service with the given node ID and port, blocks indefinitely.
-func ServeAndBlock(nodeID string, port int) {
+// ServeAndBlock creates a new discovery service for the given node and port, blocks indefinitely.
+//
+// The node's ID and advertise host are announced, so nodes named differently than their hostname are found.
+//
+// If the nodes are found in the cloud, the node answers its identity on the discovery port instead.
+func ServeAndBlock(node config.NodeCfg, port int) {
 	const errGen = "Discover serve and block: "
-	info := []string{"nubedb Discover"}
+	if cloudProvider != nil {
+		serveIdentity(node)
+		return
+	}
+	info := []string{"nubedb Discover", infoID + node.ID, infoHost + node.AdvertiseHost}
 
-	ip, errGetIP := getIP(nodeID)
-	if errGetIP != nil {
-		errorskit.FatalWrap(errGetIP, errGen)
+	ips, errGetIPs := getIPs(node.AdvertiseHost)
+	if errGetIPs != nil {
+		errorskit.FatalWrap(errGetIPs, errGen)
 	}
 
 	// Create a new mDNS service for the node.
-	service, errService := mdns.NewMDNSService(nodeID, serviceName, "", "", port, []net.IP{ip}, info)
+	service, errService := mdns.NewMDNSService(node.ID, serviceName, "", "", port, ips, info)
 	if errService != nil {
 		errorskit.FatalWrap(errService, errGen+"discover service")
 	}
 
 	// Create a new mDNS server for the service.
-	server, errServer := mdns.NewServer(&mdns.Config{Zone: service})
+	server, errServer := mdns.NewServer(&mdns.Config{Zone: service, Iface: iface})
 	if errServer != nil {
 		errorskit.FatalWrap(errService, errGen+"discover server")
 	}
@@ -51,13 +69,53 @@ func ServeAndBlock(nodeID string, port int) {
 	select {}
 }
 
-func getIP(nodeID string) (net.IP, error) {
-	hosts, errLookup := net.LookupHost(nodeID)
+// ConfigureInterface sets the network interface the node is discovered on and discovers the other nodes on,
+// all of them if name is empty.
+func ConfigureInterface(name string) error {
+	if name == "" {
+		iface = nil
+		return nil
+	}
+	netIface, errIface := net.InterfaceByName(name)
+	if errIface != nil {
+		return errorskit.Wrap(errIface, "couldn't find network interface")
+	}
+	iface = netIface
+	return nil
+}
+
+// ConfigureCloud sets the cloud provider the nodes are found in instead of mDNS, from a go-discover style spec.
+// An empty spec uses mDNS.
+func ConfigureCloud(spec string) error {
+	if spec == "" {
+		cloudProvider = nil
+		return nil
+	}
+	provider, errProvider := cloud.New(spec)
+	if errProvider != nil {
+		return errProvider
+	}
+	cloudProvider = provider
+	return nil
+}
+
+// getIPs returns the IPv4 and IPv6 addresses of a host, which are announced.
+func getIPs(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	hosts, errLookup := net.LookupHost(host)
 	if errLookup != nil {
 		return nil, errorskit.Wrap(errLookup, "couldn't lookup host")
 	}
 
-	return net.ParseIP(hosts[0]), nil
+	ips := make([]net.IP, 0, len(hosts))
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
 }
 
 // SearchNodes returns a list of all discovered nodes, excluding the one passed as a parameter.
@@ -66,6 +124,10 @@ func SearchNodes(currentNode string) ([]string, error) {
 	hosts := make(map[string]bool)
 	var lastError error
 
+	if cloudProvider != nil {
+		return searchCloudNodes(currentNode)
+	}
+
 	// Try to discover nodes 3 times to add any missing nodes in the first scan.
 	for i := 0; i < 3; i++ {
 		hostsQuery, err := query()
@@ -75,10 +137,8 @@ func SearchNodes(currentNode string) ([]string, error) {
 			continue
 		}
 
-		for _, host := range hostsQuery {
-			// In some linux versions it reports "$name." (name and a dot)
-			host = strings.ReplaceAll(host, ".", "")
-			hosts[host] = true
+		for _, entry := range hostsQuery {
+			hosts[entryNodeID(entry)] = true
 		}
 		// Wait for 100 milliseconds before trying again to not spam/have some space between requests.
 		time.Sleep(100 * time.Millisecond) // TODO: Try to refactor this
@@ -96,21 +156,45 @@ func SearchNodes(currentNode string) ([]string, error) {
 	return result, lastError
 }
 
-// query sends an mDNS query to discover nubedb nodes and returns a list of their hosts.
-func query() ([]string, error) {
+// entryNodeID returns the ID of a discovered node, registering its advertise host.
+//
+// Nodes announcing no ID are identified by their host.
+func entryNodeID(entry *mdns.ServiceEntry) string {
+	var id, host string
+	for _, field := range entry.InfoFields {
+		switch {
+		case strings.HasPrefix(field, infoID):
+			id = strings.TrimPrefix(field, infoID)
+		case strings.HasPrefix(field, infoHost):
+			host = strings.TrimPrefix(field, infoHost)
+		}
+	}
+	if id == "" {
+		// In some linux versions it reports "$name." (name and a dot)
+		return strings.ReplaceAll(entry.Host, ".", "")
+	}
+	if host != "" {
+		config.RegisterNodeHost(id, host)
+	}
+	return id
+}
+
+// query sends an mDNS query to discover nubedb nodes and returns their entries.
+func query() ([]*mdns.ServiceEntry, error) {
 	var mu sync.Mutex
-	var hosts []string
+	var entries []*mdns.ServiceEntry
 	entriesCh := make(chan *mdns.ServiceEntry, 4)
 	go func() {
 		for entry := range entriesCh {
 			mu.Lock()
-			hosts = append(hosts, entry.Host)
+			entries = append(entries, entry)
 			mu.Unlock()
 		}
 	}()
 
+	// Both IPv4 and IPv6 are queried, the one the host doesn't support is skipped.
 	params := mdns.DefaultParams(serviceName)
-	params.DisableIPv6 = true
+	params.Interface = iface
 	params.Entries = entriesCh
 
 	defer close(entriesCh)
@@ -121,7 +205,7 @@ func query() ([]string, error) {
 
 	mu.Lock()
 	defer mu.Unlock()
-	return hosts, nil
+	return entries, nil
 }
 
 // SearchLeader will return an error if a leader is not found,
@@ -0,0 +1,90 @@
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/narvikd/errorskit"
+	"log"
+	"net"
+	"nubedb/internal/config"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// identityTimeout is how long a node found in the cloud has to answer with its identity.
+const identityTimeout = 2 * time.Second
+
+// identity is what a node answers on its discovery port, so the nodes found in the cloud by their IP are named.
+type identity struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+}
+
+// serveIdentity answers the identity of the node to every connection on the discovery port, blocks indefinitely.
+//
+// It's served from the start, so the nodes waiting for the expected ones to bootstrap are found.
+func serveIdentity(node config.NodeCfg) {
+	ln, errListen := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(config.DiscoveryPort)))
+	if errListen != nil {
+		errorskit.FatalWrap(errListen, "Discover serve identity")
+	}
+	b, _ := json.Marshal(identity{ID: node.ID, Host: node.AdvertiseHost})
+	for {
+		conn, errAccept := ln.Accept()
+		if errAccept != nil {
+			log.Println("[discover] couldn't accept identity connection:", errAccept)
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(identityTimeout))
+		_, _ = conn.Write(b)
+		_ = conn.Close()
+	}
+}
+
+// lookupIdentity returns the identity of the node at an IP.
+func lookupIdentity(ip string) (identity, error) {
+	var id identity
+	conn, errDial := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(config.DiscoveryPort)), identityTimeout)
+	if errDial != nil {
+		return id, errDial
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(identityTimeout))
+	errDecode := json.NewDecoder(conn).Decode(&id)
+	return id, errDecode
+}
+
+// searchCloudNodes returns the IDs of the nodes found in the cloud, excluding the one passed as a parameter,
+// registering their IPs as their hosts.
+func searchCloudNodes(currentNode string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	addrs, errAddrs := cloudProvider.Addrs(ctx)
+	if errAddrs != nil {
+		return nil, errorskit.Wrap(errAddrs, "discover search in the cloud")
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		ids []string
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			id, errIdentity := lookupIdentity(addr)
+			if errIdentity != nil || id.ID == "" || id.ID == currentNode {
+				// The instance doesn't run a node yet, or it's the current one.
+				return
+			}
+			config.RegisterNodeHost(id.ID, addr)
+			mu.Lock()
+			ids = append(ids, id.ID)
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	return ids, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// node is a node of the cluster, its service is also its hostname and its ID.
+type node struct {
+	service string
+	api     string
+}
+
+// nodes are the nodes defined in the harness' docker-compose file.
+var nodes = []node{
+	{service: "bootstrap-node", api: "http://localhost:3001"},
+	{service: "node1", api: "http://localhost:3011"},
+	{service: "node2", api: "http://localhost:3012"},
+	{service: "node3", api: "http://localhost:3013"},
+	{service: "node4", api: "http://localhost:3014"},
+}
+
+// cluster controls the cluster started with docker compose.
+type cluster struct {
+	project      string
+	composeFiles []string
+	httpClient   *http.Client
+}
+
+func newCluster(project string, composeFiles []string) *cluster {
+	return &cluster{
+		project:      project,
+		composeFiles: composeFiles,
+		httpClient:   &http.Client{Timeout: 1 * time.Second},
+	}
+}
+
+// up starts the cluster, and waits until all the nodes agree on a leader.
+func (c *cluster) up(timeout time.Duration) error {
+	_, errUp := c.compose("up", "-d")
+	if errUp != nil {
+		return errUp
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if c.ready() {
+			return nil
+		}
+		time.Sleep(1 * time.Second)
+	}
+	return fmt.Errorf("the cluster wasn't ready after %s", timeout)
+}
+
+// ready returns whether all the nodes answer, and agree on the leader.
+func (c *cluster) ready() bool {
+	leader := ""
+	for _, n := range nodes {
+		status, errStatus := c.status(n)
+		if errStatus != nil || status.LeaderID == "" {
+			return false
+		}
+		if leader != "" && status.LeaderID != leader {
+			return false
+		}
+		leader = status.LeaderID
+	}
+	return true
+}
+
+// down stops the cluster and removes its data.
+func (c *cluster) down() error {
+	_, errDown := c.compose("down", "-v")
+	return errDown
+}
+
+// nodeStatus is the status a node reports in cluster/status.
+type nodeStatus struct {
+	NodeID   string `json:"nodeID"`
+	State    string `json:"state"`
+	LeaderID string `json:"leaderID"`
+}
+
+func (c *cluster) status(n node) (nodeStatus, error) {
+	var res struct {
+		Data nodeStatus `json:"data"`
+	}
+	resp, errGet := c.httpClient.Get(n.api + "/cluster/status")
+	if errGet != nil {
+		return nodeStatus{}, errGet
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nodeStatus{}, fmt.Errorf("status code %v", resp.StatusCode)
+	}
+	errDecode := json.NewDecoder(resp.Body).Decode(&res)
+	return res.Data, errDecode
+}
+
+// leader returns the node any reachable node reports as the leader.
+func (c *cluster) leader() (node, error) {
+	for _, n := range nodes {
+		status, errStatus := c.status(n)
+		if errStatus != nil || status.LeaderID == "" {
+			continue
+		}
+		for _, leader := range nodes {
+			if leader.service == status.LeaderID {
+				return leader, nil
+			}
+		}
+	}
+	return node{}, errors.New("no node knows the leader")
+}
+
+// kill kills the container of a node with SIGKILL.
+func (c *cluster) kill(n node) error {
+	_, errKill := c.compose("kill", "-s", "SIGKILL", n.service)
+	return errKill
+}
+
+// start starts the container of a node which was killed.
+func (c *cluster) start(n node) error {
+	_, errStart := c.compose("start", n.service)
+	return errStart
+}
+
+// disconnect partitions a node, disconnecting it from the cluster's network.
+func (c *cluster) disconnect(n node) error {
+	id, errID := c.containerID(n)
+	if errID != nil {
+		return errID
+	}
+	_, errDisconnect := docker("network", "disconnect", c.network(), id)
+	return errDisconnect
+}
+
+// connect heals the partition of a node.
+func (c *cluster) connect(n node) error {
+	id, errID := c.containerID(n)
+	if errID != nil {
+		return errID
+	}
+	_, errConnect := docker("network", "connect", "--alias", n.service, c.network(), id)
+	return errConnect
+}
+
+// fillDisk fills the data volume of a node, it requires the tmpfs volumes so the host's disk isn't filled.
+func (c *cluster) fillDisk(n node) error {
+	id, errID := c.containerID(n)
+	if errID != nil {
+		return errID
+	}
+	// The command fails when the disk is full, which is the expected outcome.
+	_, _ = docker("exec", id, "sh", "-c", "cat /dev/zero > /app/data/e2e-fill")
+	return nil
+}
+
+// freeDisk removes the file created by fillDisk.
+func (c *cluster) freeDisk(n node) error {
+	id, errID := c.containerID(n)
+	if errID != nil {
+		return errID
+	}
+	_, errRm := docker("exec", id, "rm", "-f", "/app/data/e2e-fill")
+	return errRm
+}
+
+func (c *cluster) containerID(n node) (string, error) {
+	out, errPs := c.compose("ps", "-q", n.service)
+	if errPs != nil {
+		return "", errPs
+	}
+	if out == "" {
+		return "", fmt.Errorf("container of %s not found", n.service)
+	}
+	return out, nil
+}
+
+// network returns the name docker compose gives to the cluster's network.
+func (c *cluster) network() string {
+	return c.project + "_nubedb"
+}
+
+func (c *cluster) compose(args ...string) (string, error) {
+	base := []string{"compose", "-p", c.project}
+	for _, f := range c.composeFiles {
+		base = append(base, "-f", f)
+	}
+	return docker(append(base, args...)...)
+}
+
+func docker(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stderr = &stderr
+	out, errRun := cmd.Output()
+	if errRun != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", strings.Join(args, " "), errRun, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,90 @@
+// Command e2e runs nubedb's end-to-end tests: it starts a cluster with docker compose, runs concurrent clients
+// against its API while injecting faults, and checks that the history of the operations is linearizable.
+//
+// Usage, from the root of the repository:
+//
+//	go run ./e2e -duration 2m -faults kill-leader,partition
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"nubedb/pkg/linearizability"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	if !run() {
+		os.Exit(1)
+	}
+}
+
+// run runs the test, returning whether the history was linearizable.
+func run() bool {
+	var (
+		project       = flag.String("project", "nubedb-e2e", "docker compose project name")
+		composeFile   = flag.String("compose", "e2e/docker-compose.yml", "docker compose file of the cluster")
+		duration      = flag.Duration("duration", 1*time.Minute, "duration of the workload")
+		clients       = flag.Int("clients", 5, "number of concurrent clients")
+		keys          = flag.Int("keys", 5, "number of keys the clients read and write")
+		faultNames    = flag.String("faults", faultKillLeader+","+faultPartition, "comma separated faults to inject: kill-leader, partition, disk-full")
+		faultInterval = flag.Duration("fault-interval", 15*time.Second, "time between faults")
+		faultDuration = flag.Duration("fault-duration", 5*time.Second, "time each fault lasts before it's healed")
+		readAny       = flag.Bool("read-any", false, "read from any node, instead of only from the leader")
+		keep          = flag.Bool("keep", false, "keep the cluster running after the test")
+	)
+	flag.Parse()
+
+	enabledFaults := make([]string, 0)
+	if *faultNames != "" {
+		enabledFaults = strings.Split(*faultNames, ",")
+	}
+	errFaults := validateFaults(enabledFaults)
+	if errFaults != nil {
+		log.Fatalln(errFaults)
+	}
+
+	composeFiles := []string{*composeFile}
+	for _, name := range enabledFaults {
+		if name == faultDiskFull {
+			composeFiles = append(composeFiles, strings.TrimSuffix(*composeFile, ".yml")+".tmpfs.yml")
+		}
+	}
+
+	c := newCluster(*project, composeFiles)
+	log.Println("[e2e] starting cluster...")
+	errUp := c.up(2 * time.Minute)
+	if errUp != nil {
+		log.Fatalln(errUp)
+	}
+	if !*keep {
+		defer func() {
+			errDown := c.down()
+			if errDown != nil {
+				log.Println("[e2e] couldn't stop the cluster:", errDown)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	w := newWorkload(c, *keys, *readAny)
+	n := &nemesis{cluster: c, faults: enabledFaults, interval: *faultInterval, duration: *faultDuration}
+	go n.run(ctx)
+	log.Printf("[e2e] running %v clients for %s, faults: %v\n", *clients, *duration, enabledFaults)
+	w.run(ctx, *clients)
+
+	log.Printf("[e2e] checking %v operations...\n", len(w.history))
+	res := linearizability.Check(linearizability.KVModel, w.history)
+	if !res.Linearizable {
+		key := res.Failed[0].Input.(linearizability.KVInput).Key
+		log.Printf("[e2e] FAIL: the history of key '%s' isn't linearizable (%v operations)\n", key, len(res.Failed))
+		return false
+	}
+	log.Println("[e2e] OK: the history is linearizable")
+	return true
+}
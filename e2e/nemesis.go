@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Names of the faults the nemesis can inject.
+const (
+	faultKillLeader = "kill-leader"
+	faultPartition  = "partition"
+	faultDiskFull   = "disk-full"
+)
+
+// fault injects a failure in the cluster, returning the function which heals it.
+type fault func(c *cluster) (heal func() error, err error)
+
+var faults = map[string]fault{
+	faultKillLeader: killLeader,
+	faultPartition:  partitionLeader,
+	faultDiskFull:   fillRandomDisk,
+}
+
+// nemesis injects random faults into the cluster, one at a time.
+type nemesis struct {
+	cluster  *cluster
+	faults   []string
+	interval time.Duration
+	duration time.Duration
+}
+
+// run injects a fault every interval, healing it after its duration, until the context is done.
+func (n *nemesis) run(ctx context.Context) {
+	if len(n.faults) == 0 {
+		return
+	}
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		name := n.faults[rand.Intn(len(n.faults))]
+		heal, errInject := faults[name](n.cluster)
+		if errInject != nil {
+			log.Printf("[nemesis] couldn't inject %s: %v\n", name, errInject)
+			continue
+		}
+		log.Printf("[nemesis] injected %s\n", name)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(n.duration):
+		}
+
+		errHeal := heal()
+		if errHeal != nil {
+			log.Printf("[nemesis] couldn't heal %s: %v\n", name, errHeal)
+			continue
+		}
+		log.Printf("[nemesis] healed %s\n", name)
+	}
+}
+
+func killLeader(c *cluster) (func() error, error) {
+	leader, errLeader := c.leader()
+	if errLeader != nil {
+		return nil, errLeader
+	}
+	errKill := c.kill(leader)
+	if errKill != nil {
+		return nil, errKill
+	}
+	return func() error { return c.start(leader) }, nil
+}
+
+func partitionLeader(c *cluster) (func() error, error) {
+	leader, errLeader := c.leader()
+	if errLeader != nil {
+		return nil, errLeader
+	}
+	errDisconnect := c.disconnect(leader)
+	if errDisconnect != nil {
+		return nil, errDisconnect
+	}
+	return func() error { return c.connect(leader) }, nil
+}
+
+func fillRandomDisk(c *cluster) (func() error, error) {
+	n := nodes[rand.Intn(len(nodes))]
+	errFill := c.fillDisk(n)
+	if errFill != nil {
+		return nil, errFill
+	}
+	return func() error { return c.freeDisk(n) }, nil
+}
+
+// validateFaults returns an error if a fault isn't recognized.
+func validateFaults(names []string) error {
+	for _, name := range names {
+		if _, ok := faults[name]; !ok {
+			return fmt.Errorf("fault not recognized: %s", name)
+		}
+	}
+	return nil
+}
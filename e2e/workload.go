@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"nubedb/pkg/linearizability"
+	"sync"
+	"time"
+)
+
+// workload runs clients which read and write a few keys concurrently, recording the history of their operations.
+type workload struct {
+	cluster    *cluster
+	keys       int
+	readAny    bool
+	start      time.Time
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	history []linearizability.Operation
+}
+
+func newWorkload(c *cluster, keys int, readAny bool) *workload {
+	return &workload{
+		cluster:    c,
+		keys:       keys,
+		readAny:    readAny,
+		start:      time.Now(),
+		httpClient: &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// run runs the clients until the context is done.
+func (w *workload) run(ctx context.Context, clients int) {
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(clientID int) {
+			defer wg.Done()
+			w.client(ctx, clientID)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (w *workload) client(ctx context.Context, clientID int) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(clientID)))
+	for seq := 0; ctx.Err() == nil; seq++ {
+		key := fmt.Sprintf("e2e-%d", rng.Intn(w.keys))
+		if rng.Intn(2) == 0 {
+			w.write(clientID, key, fmt.Sprintf("%d-%d", clientID, seq), nodes[rng.Intn(len(nodes))])
+			continue
+		}
+
+		n := nodes[rng.Intn(len(nodes))]
+		if !w.readAny {
+			leader, errLeader := w.cluster.leader()
+			if errLeader != nil {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			n = leader
+		}
+		w.read(clientID, key, n)
+	}
+}
+
+// write sets a key, if the outcome isn't known the write may or may not have been applied.
+func (w *workload) write(clientID int, key string, value string, n node) {
+	body, _ := json.Marshal(map[string]string{"key": key, "value": value})
+	op := linearizability.Operation{
+		ClientID: clientID,
+		Input:    linearizability.KVInput{Write: true, Key: key, Value: value},
+		Call:     w.now(),
+	}
+
+	resp, errPost := w.httpClient.Post(n.api+"/store", "application/json", bytes.NewReader(body))
+	op.Return = linearizability.Unknown
+	if errPost == nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			op.Return = w.now()
+		}
+	}
+	w.record(op)
+}
+
+// read gets a key, failed reads aren't recorded since they don't have an effect.
+func (w *workload) read(clientID int, key string, n node) {
+	body, _ := json.Marshal(map[string]string{"key": key})
+	call := w.now()
+
+	req, errReq := http.NewRequest(http.MethodGet, n.api+"/store", bytes.NewReader(body))
+	if errReq != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, errGet := w.httpClient.Do(req)
+	if errGet != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var value string
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var res struct {
+			Data string `json:"data"`
+		}
+		errDecode := json.NewDecoder(resp.Body).Decode(&res)
+		if errDecode != nil {
+			return
+		}
+		value = res.Data
+	case http.StatusNotFound:
+		value = ""
+	default:
+		return
+	}
+
+	w.record(linearizability.Operation{
+		ClientID: clientID,
+		Input:    linearizability.KVInput{Key: key},
+		Call:     call,
+		Output:   linearizability.KVOutput{Value: value},
+		Return:   w.now(),
+	})
+}
+
+func (w *workload) record(op linearizability.Operation) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.history = append(w.history, op)
+}
+
+// now returns the nanoseconds since the workload started.
+func (w *workload) now() int64 {
+	return time.Since(w.start).Nanoseconds()
+}
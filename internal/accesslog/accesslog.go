@@ -0,0 +1,72 @@
+// Package accesslog logs a sample of the requests served by the REST and gRPC APIs.
+//
+// The values are never logged, and the keys are logged as a hash,
+// so the logs can be kept with less care than the data, while still relating the requests to the same key.
+package accesslog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// sampleEvery logs 1 of every N requests. 0 disables the sampling.
+	sampleEvery atomic.Int64
+	// logErrors logs every failed request, besides the sampled ones.
+	logErrors atomic.Bool
+)
+
+// Entry is a served request.
+type Entry struct {
+	// Protocol is the API which served the request, rest or grpc.
+	Protocol string
+	// Method is the HTTP method and route, or the gRPC method.
+	Method string
+	// Key is the key of the request, if it has one. It's logged as a hash.
+	Key      string
+	Duration time.Duration
+	// Status is the HTTP status code, 0 for gRPC.
+	Status int
+	// Code is the error code of a failed request, or OK.
+	Code string
+	// Forwarded is whether the request was a write sent to the leader, since this node isn't the leader of the key.
+	Forwarded bool
+}
+
+// SetSampling sets how many requests there are for each one logged, 0 disables the sampling.
+func SetSampling(every int) {
+	sampleEvery.Store(int64(every))
+}
+
+// SetLogErrors sets whether every failed request is logged, besides the sampled ones.
+func SetLogErrors(enabled bool) {
+	logErrors.Store(enabled)
+}
+
+// Sampled returns whether a request is logged. It's meant to be called before building its Entry.
+func Sampled(failed bool) bool {
+	if failed && logErrors.Load() {
+		return true
+	}
+	every := sampleEvery.Load()
+	return every > 0 && rand.Int63n(every) == 0
+}
+
+// Log logs a request.
+func Log(e Entry) {
+	log.Printf("[access] protocol=%s method=%q key=%s status=%v code=%s duration=%s forwarded=%v\n",
+		e.Protocol, e.Method, HashKey(e.Key), e.Status, e.Code, e.Duration, e.Forwarded)
+}
+
+// HashKey returns a short hash of a key, or - if there isn't a key.
+func HashKey(key string) string {
+	if key == "" {
+		return "-"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
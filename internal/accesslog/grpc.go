@@ -0,0 +1,80 @@
+package accesslog
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"nubedb/cluster/errcode"
+	"path"
+	"time"
+)
+
+// ForwardedFunc returns whether a request of a gRPC method for a key is a write sent to the leader.
+type ForwardedFunc func(method string, key string) bool
+
+// keyed are the requests which have a key.
+type keyed interface {
+	GetKey() string
+}
+
+// UnaryServerInterceptor logs the sampled unary calls.
+func UnaryServerInterceptor(forwarded ForwardedFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		if Sampled(err != nil) {
+			var key string
+			if k, ok := req.(keyed); ok {
+				key = k.GetKey()
+			}
+			logCall(info.FullMethod, key, start, err, forwarded)
+		}
+		return res, err
+	}
+}
+
+// StreamServerInterceptor logs the sampled streams once they end, with the key of their first message.
+func StreamServerInterceptor(forwarded ForwardedFunc) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ks := &keyStream{ServerStream: ss}
+		err := handler(srv, ks)
+		if Sampled(err != nil) {
+			logCall(info.FullMethod, ks.key, start, err, forwarded)
+		}
+		return err
+	}
+}
+
+func logCall(fullMethod string, key string, start time.Time, err error, forwarded ForwardedFunc) {
+	method := path.Base(fullMethod)
+	code := "OK"
+	if err != nil {
+		code = string(errcode.Of(err))
+	}
+	Log(Entry{
+		Protocol:  "grpc",
+		Method:    method,
+		Key:       key,
+		Duration:  time.Since(start),
+		Code:      code,
+		Forwarded: forwarded(method, key),
+	})
+}
+
+// keyStream keeps the key of the first message received by a stream.
+type keyStream struct {
+	grpc.ServerStream
+	key      string
+	received bool
+}
+
+func (s *keyStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && !s.received {
+		s.received = true
+		if k, ok := m.(keyed); ok {
+			s.key = k.GetKey()
+		}
+	}
+	return err
+}
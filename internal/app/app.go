@@ -4,9 +4,15 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/narvikd/fiberparser"
 	"log"
+	"nubedb/cluster"
+	"nubedb/cluster/chaos"
 	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/peerauth"
+	"nubedb/cluster/settings"
+	"nubedb/cluster/valuecrypt"
 	"nubedb/internal/config"
-	"time"
+	"nubedb/internal/metrics"
 )
 
 // App is a simple struct to include a collection of tools that the application could need to operate.
@@ -15,29 +21,95 @@ import (
 type App struct {
 	HttpServer *fiber.App
 	Node       *consensus.Node
+	Crypter    *valuecrypt.Crypter
 	Config     config.Config
 }
 
 func NewApp(cfg config.Config) *App {
+	metrics.SetSlowOpThreshold(cfg.Metrics.SlowOpThreshold)
+	metrics.SetHotKeysSampling(cfg.Metrics.HotKeysSampleEvery)
+	if cfg.Chaos.Enabled {
+		log.Println("[chaos] fault injection is enabled, this node shouldn't be used in production")
+		chaos.Enable()
+	}
+	errEncoding := fsm.SetPayloadEncoding(cfg.Consensus.PayloadEncoding)
+	if errEncoding != nil {
+		log.Fatalln(errEncoding)
+	}
+
+	// A node which joined with a join token uses the node key it received, if the secret isn't configured.
+	peerauth.Configure(cfg.Cluster.Secret, cfg.Cluster.JoinToken)
+	nodeKey, errNodeKey := peerauth.LoadNodeKey(consensus.NodeKeyPath(cfg.CurrentNode.ID))
+	if errNodeKey != nil {
+		log.Fatalln(errNodeKey)
+	}
+	if nodeKey != "" {
+		peerauth.SetNodeKey(cfg.CurrentNode.ID, nodeKey)
+	}
+
+	errNodeAPI := config.ConfigureNodeAPI(cfg.Rest)
+	if errNodeAPI != nil {
+		log.Fatalln(errNodeAPI)
+	}
+
 	node, errConsensus := consensus.New(cfg)
 	if errConsensus != nil {
 		log.Fatalln(errConsensus)
 	}
 
+	cluster.ConfigureRetries(cluster.RetryPolicy{
+		MaxAttempts: cfg.WriteRetry.MaxAttempts,
+		BaseDelay:   cfg.WriteRetry.BaseDelay,
+		MaxDelay:    cfg.WriteRetry.MaxDelay,
+	})
+	cluster.ConfigureCoalescing(cfg.Coalescing.Window)
+	cluster.ConfigureChunking(cfg.Storage.ChunkSize)
+	cluster.ConfigureWitness(node.IsWitness())
+
+	crypter, errCrypter := valuecrypt.New(cfg.Encryption.ValueKey)
+	if errCrypter != nil {
+		log.Fatalln(errCrypter)
+	}
+
 	serv := fiber.New(fiber.Config{
-		AppName:           "NubeDB",
+		AppName: "NubeDB",
+		// Listen on both IPv4 and IPv6, fiber listens only on IPv4 by default.
+		Network:           fiber.NetworkTCP,
 		EnablePrintRoutes: false,
-		IdleTimeout:       5 * time.Second,
-		WriteTimeout:      10 * time.Second,
+		ReadTimeout:       cfg.Rest.ReadTimeout,
+		WriteTimeout:      cfg.Rest.WriteTimeout,
+		IdleTimeout:       cfg.Rest.IdleTimeout,
 		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
 			return fiberparser.RegisterErrorHandler(ctx, err)
 		},
-		BodyLimit: 200 * 1024 * 1024, // In MB
+		BodyLimit: cfg.Rest.BodyLimit,
+		// The streamed routes read their body as it's received, the body limit of the rest is checked by a middleware.
+		StreamRequestBody: true,
 	})
 
 	return &App{
 		HttpServer: serv,
 		Node:       node,
+		Crypter:    crypter,
 		Config:     cfg,
 	}
 }
+
+// Reload reads the configuration again, and applies the settings which are safe to change at runtime:
+// the consensus' log level and snapshot tuning, the metrics' sampling and the write coalescing window,
+// unless the cluster-wide settings override them.
+//
+// The rest of the settings need a restart of the node to be applied.
+func (a *App) Reload() error {
+	cfg, errCfg := config.New()
+	if errCfg != nil {
+		return errCfg
+	}
+	errTune := a.Node.Tune(cfg.Consensus)
+	if errTune != nil {
+		return errTune
+	}
+	settings.SetDefaults(cfg)
+	log.Println("[config] configuration reloaded")
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package buildinfo describes the nubedb binary a node runs, so the nodes of a cluster can be told apart during upgrades.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Version and Commit are set when building a release, ex:
+//
+//	go build -ldflags "-X nubedb/internal/buildinfo.Version=v1.2.0 -X nubedb/internal/buildinfo.Commit=$(git rev-parse HEAD)"
+//
+// If Commit isn't set, the commit go build stamped the binary with is used.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// startedAt is when the node's process started.
+var startedAt = time.Now()
+
+// Info is the build of the binary and how long it has been running.
+type Info struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit"`
+	GoVersion string    `json:"goVersion"`
+	StartedAt time.Time `json:"startedAt"`
+	Uptime    string    `json:"uptime"`
+}
+
+// Get returns the build of the binary and how long it has been running.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    commit(),
+		GoVersion: runtime.Version(),
+		StartedAt: startedAt,
+		Uptime:    Uptime().Round(time.Second).String(),
+	}
+}
+
+// Uptime returns how long the node's process has been running.
+func Uptime() time.Duration {
+	return time.Since(startedAt)
+}
+
+// commit returns the commit the binary was built from, marked as dirty if it had uncommitted changes.
+func commit() string {
+	if Commit != "" {
+		return Commit
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision, modified string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision != "" && modified == "true" {
+		return revision + "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,281 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations of the benchmark.
+const (
+	benchGet    = "get"
+	benchSet    = "set"
+	benchDelete = "delete"
+)
+
+// benchOps are the operations of the benchmark, in the order they are reported.
+var benchOps = []string{benchGet, benchSet, benchDelete}
+
+// benchMix is the weight of each operation in the benchmark's workload.
+type benchMix map[string]int
+
+// benchResult holds the latencies of the successful requests of an operation, and the number of failed ones.
+type benchResult struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// bench drives a mix of reads, writes and deletes against a cluster through its API,
+// reporting the throughput and the latency percentiles of each operation.
+func bench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	addrs := fs.String("addr", "http://localhost:3001", "comma separated API addresses of the nodes, the requests are spread across them")
+	duration := fs.Duration("duration", 30*time.Second, "duration of the benchmark")
+	concurrency := fs.Int("concurrency", 16, "number of concurrent clients")
+	mixFlag := fs.String("mix", "get=70,set=25,delete=5", "weight of each operation: get, set and delete")
+	valueSize := fs.Int("value-size", 128, "size of the written values in bytes")
+	keys := fs.Int("keys", 10000, "number of distinct keys")
+	prefix := fs.String("prefix", "bench-", "prefix of the keys, they can be placed in a bucket, ex: bench/")
+	preload := fs.Bool("preload", false, "set all the keys before the benchmark, so the reads find them")
+	_ = fs.Parse(args)
+
+	nodes := strings.Split(*addrs, ",")
+	mix, errMix := parseBenchMix(*mixFlag)
+	if errMix != nil {
+		return errMix
+	}
+	if *concurrency < 1 || *keys < 1 || *valueSize < 0 {
+		return errors.New("concurrency and keys must be positive, and value-size can't be negative")
+	}
+
+	c := &benchClient{
+		http:   &http.Client{Timeout: 10 * time.Second},
+		nodes:  nodes,
+		prefix: *prefix,
+		value:  strings.Repeat("x", *valueSize),
+	}
+
+	if *preload {
+		fmt.Printf("preloading %v keys...\n", *keys)
+		errPreload := c.preload(*keys, *concurrency)
+		if errPreload != nil {
+			return errPreload
+		}
+	}
+
+	fmt.Printf("running for %s with %v clients against %v nodes, mix: %s\n", *duration, *concurrency, len(nodes), *mixFlag)
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	start := time.Now()
+	results := make([]map[string]*benchResult, *concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			results[worker] = c.run(ctx, worker, mix, *keys)
+		}(i)
+	}
+	wg.Wait()
+
+	printBenchReport(mergeBenchResults(results), time.Since(start))
+	return nil
+}
+
+// parseBenchMix parses a mix like get=70,set=25,delete=5.
+func parseBenchMix(s string) (benchMix, error) {
+	mix := make(benchMix)
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		name, weightStr, found := strings.Cut(strings.TrimSpace(part), "=")
+		weight, errWeight := strconv.Atoi(weightStr)
+		if !found || errWeight != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid mix entry: %s", part)
+		}
+		if name != benchGet && name != benchSet && name != benchDelete {
+			return nil, fmt.Errorf("operation not recognized: %s", name)
+		}
+		mix[name] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("the mix must have at least one operation with weight")
+	}
+	return mix, nil
+}
+
+// pick returns an operation chosen randomly following the mix's weights.
+func (m benchMix) pick(rng *rand.Rand) string {
+	total := 0
+	for _, op := range benchOps {
+		total += m[op]
+	}
+	n := rng.Intn(total)
+	for _, op := range benchOps {
+		if n < m[op] {
+			return op
+		}
+		n -= m[op]
+	}
+	return benchGet
+}
+
+// benchClient sends the requests of the benchmark.
+type benchClient struct {
+	http   *http.Client
+	nodes  []string
+	prefix string
+	value  string
+}
+
+func (c *benchClient) run(ctx context.Context, worker int, mix benchMix, keys int) map[string]*benchResult {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+	results := make(map[string]*benchResult)
+	for _, op := range benchOps {
+		results[op] = &benchResult{}
+	}
+
+	for ctx.Err() == nil {
+		op := mix.pick(rng)
+		key := c.prefix + strconv.Itoa(rng.Intn(keys))
+		node := c.nodes[rng.Intn(len(c.nodes))]
+
+		start := time.Now()
+		errOp := c.do(node, op, key)
+		elapsed := time.Since(start)
+		if ctx.Err() != nil {
+			// The request could have been cut by the end of the benchmark.
+			break
+		}
+		if errOp != nil {
+			results[op].errors++
+			continue
+		}
+		results[op].latencies = append(results[op].latencies, elapsed)
+	}
+	return results
+}
+
+// preload sets all the keys, spreading them across the clients.
+func (c *benchClient) preload(keys int, concurrency int) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for k := worker; k < keys; k += concurrency {
+				errSet := c.do(c.nodes[k%len(c.nodes)], benchSet, c.prefix+strconv.Itoa(k))
+				if errSet != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = errSet
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// do sends the request of an operation, keys which don't exist aren't errors.
+func (c *benchClient) do(node string, op string, key string) error {
+	var (
+		method string
+		body   = map[string]string{"key": key}
+	)
+	switch op {
+	case benchGet:
+		method = http.MethodGet
+	case benchSet:
+		method = http.MethodPost
+		body["value"] = c.value
+	case benchDelete:
+		method = http.MethodDelete
+	}
+
+	b, errMarshal := json.Marshal(body)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	req, errReq := http.NewRequest(method, strings.TrimSuffix(node, "/")+"/store", bytes.NewReader(b))
+	if errReq != nil {
+		return errReq
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, errDo := c.http.Do(req)
+	if errDo != nil {
+		return errDo
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("status code %v", res.StatusCode)
+	}
+	return nil
+}
+
+func mergeBenchResults(results []map[string]*benchResult) map[string]*benchResult {
+	merged := make(map[string]*benchResult)
+	for _, op := range benchOps {
+		merged[op] = &benchResult{}
+	}
+	for _, worker := range results {
+		for op, res := range worker {
+			merged[op].latencies = append(merged[op].latencies, res.latencies...)
+			merged[op].errors += res.errors
+		}
+	}
+	return merged
+}
+
+func printBenchReport(results map[string]*benchResult, elapsed time.Duration) {
+	fmt.Printf("\n%-8s %10s %10s %8s %10s %10s %10s %10s\n", "op", "requests", "req/s", "errors", "p50", "p90", "p99", "max")
+	for _, op := range benchOps {
+		res := results[op]
+		if len(res.latencies) == 0 && res.errors == 0 {
+			continue
+		}
+		sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+		fmt.Printf("%-8s %10v %10.1f %8v %10s %10s %10s %10s\n",
+			op, len(res.latencies), float64(len(res.latencies))/elapsed.Seconds(), res.errors,
+			percentile(res.latencies, 0.50), percentile(res.latencies, 0.90),
+			percentile(res.latencies, 0.99), percentile(res.latencies, 1),
+		)
+	}
+}
+
+// percentile returns the latency at a percentile (0 to 1) of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i].Round(time.Microsecond)
+}
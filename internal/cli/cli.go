@@ -0,0 +1,101 @@
+// Package cli implements nubedb's administrative commands, which run instead of starting the node.
+package cli
+
+import (
+	"fmt"
+	"nubedb/internal/config"
+	"sort"
+	"strings"
+)
+
+// command is an administrative command, it receives the arguments after its name.
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"bench": {
+		description: "drives a mix of reads, writes and deletes against a cluster, reporting throughput and latencies",
+		run:         bench,
+	},
+	"cluster": {
+		description: "replaces a node of a running cluster by a new one, with replace",
+		run:         clusterCmd,
+	},
+	"config": {
+		description: "checks the configuration and prints the effective one without starting the node, with check",
+		run:         configCmd,
+	},
+	"import": {
+		description: "imports a Redis RDB dump or an etcd snapshot into a cluster",
+		run:         importData,
+	},
+	"migrate": {
+		description: "imports the data dir of a node which ran alone into a running cluster, verifying every key",
+		run:         migrate,
+	},
+	"raft-log": {
+		description: "prints the consensus logs of a stopped node with their payloads decoded, with dump or tail",
+		run:         raftLog,
+	},
+	"render": {
+		description: "renders a file from a template with the keys under a prefix, re-rendering it when they change",
+		run:         render,
+	},
+	"restore": {
+		description: "restores a backup and replays the consensus logs up to an index or time",
+		run:         restore,
+	},
+	"rotate-key": {
+		description: "re-encrypts the data at rest with the currently configured encryption key",
+		run:         rotateKey,
+	},
+	"snapshot": {
+		description: "inspects a raft snapshot, a stopped node's data dir or a backup, or extracts keys from it",
+		run:         snapshot,
+	},
+	"simulate": {
+		description: "runs an in-process cluster under the deterministic simulator, to reproduce consensus bugs",
+		run:         simulate,
+	},
+	"standby": {
+		description: "seeds a new node's data dir with a copy of a peer's data pulled over gRPC, before its first start",
+		run:         standby,
+	},
+	"token": {
+		description: "creates a time-limited join token, which lets a new node join without the cluster secret",
+		run:         token,
+	},
+}
+
+// Run executes the command named by the first argument.
+func Run(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("command not recognized: %s\n%s", args[0], usage())
+	}
+	return cmd.run(args[1:])
+}
+
+// usage returns the list of available commands.
+func usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("Usage: nubedb [command] [flags]\n\nCommands:\n")
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("  %-12s %s\n", name, commands[name].description))
+	}
+	return sb.String()
+}
+
+// defaultNodeID returns the ID the node uses: NUBEDB_NODE_ID, or the hostname.
+func defaultNodeID() string {
+	nodeID, _ := config.DefaultNodeID()
+	return nodeID
+}
@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"log"
+	"net/http"
+	"net/url"
+	"nubedb/api/rest/jsonresponse"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/errcode"
+	"nubedb/internal/config"
+	"strings"
+	"time"
+)
+
+// clusterCmd runs the operations which change the members of a running cluster through its API.
+func clusterCmd(args []string) error {
+	if len(args) == 0 || args[0] != "replace" {
+		return errors.New("usage: nubedb cluster replace --old=<id> --new=<id> [--addr=http://localhost:3001]")
+	}
+	return clusterReplace(args[1:])
+}
+
+// clusterReplace replaces a node of the cluster by a new one, printing the progress of each step until it's done.
+//
+// The replacement is run by the leader, so it isn't interrupted if the command stops,
+// the command only starts it and follows its progress.
+func clusterReplace(args []string) error {
+	fs := flag.NewFlagSet("cluster replace", flag.ExitOnError)
+	oldID := fs.String("old", "", "ID of the node being replaced (required)")
+	newID := fs.String("new", "", "ID of the new node, it must be running (required)")
+	addr := fs.String("addr", "http://localhost:3001", "API address of a node of the cluster, the request is sent to its leader")
+	timeout := fs.Duration("timeout", 5*time.Minute, "how long the new node has to catch up before being promoted")
+	_ = fs.Parse(args)
+
+	if *oldID == "" || *newID == "" {
+		fs.Usage()
+		return errors.New("--old and --new are required")
+	}
+
+	c := &clusterClient{http: &http.Client{Timeout: 10 * time.Second}}
+	path := "/cluster/replace/" + url.PathEscape(*oldID)
+	query := url.Values{"new": {*newID}, "timeout": {timeout.String()}}
+	node := strings.TrimSuffix(*addr, "/")
+
+	var progress consensus.ReplaceProgress
+	leader, errStart := c.do(http.MethodPost, node+path+"?"+query.Encode(), &progress)
+	if leader != "" {
+		// The node isn't the leader, the request is sent to the leader it answered with.
+		node = apiScheme(node) + "://" + config.MakeApiAddr(leader)
+		leader, errStart = c.do(http.MethodPost, node+path+"?"+query.Encode(), &progress)
+	}
+	if errStart != nil {
+		return errStart
+	}
+	if leader != "" {
+		return fmt.Errorf("the leader changed to %s while starting the replacement, run the command again", leader)
+	}
+
+	const interval = 1 * time.Second
+	step := ""
+	for {
+		if progress.Step != step {
+			step = progress.Step
+			log.Printf("[cluster replace] %s: %s\n", step, progress.Message)
+		}
+		switch progress.Step {
+		case consensus.ReplaceDone:
+			return nil
+		case consensus.ReplaceFailed:
+			return errors.New("the replacement failed: " + progress.Message)
+		}
+
+		time.Sleep(interval)
+		_, errGet := c.do(http.MethodGet, node+path, &progress)
+		if errGet != nil {
+			return errorskit.Wrap(errGet, "couldn't follow the replacement, check it with a GET request to "+path+" on the leader")
+		}
+	}
+}
+
+// clusterClient sends the requests of the cluster commands.
+type clusterClient struct {
+	http *http.Client
+}
+
+// do sends a request, decoding the data of its response into v.
+// If the node answered that the request must be sent to the leader, the leader's ID is returned.
+func (c *clusterClient) do(method string, u string, v any) (string, error) {
+	req, errReq := http.NewRequest(method, u, nil)
+	if errReq != nil {
+		return "", errReq
+	}
+	res, errDo := c.http.Do(req)
+	if errDo != nil {
+		return "", errDo
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var body jsonresponse.ErrorBody
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		if body.Code == errcode.NotLeader && body.Leader != "" {
+			return body.Leader, nil
+		}
+		return "", fmt.Errorf("%s answered with status code %v: %s", u, res.StatusCode, body.Message)
+	}
+
+	data := struct {
+		Data any `json:"data"`
+	}{Data: v}
+	return "", json.NewDecoder(res.Body).Decode(&data)
+}
+
+// apiScheme returns the scheme of a node's API address, so the leader is reached like the node the command was given.
+func apiScheme(addr string) string {
+	if u, errParse := url.Parse(addr); errParse == nil && u.Scheme != "" {
+		return u.Scheme
+	}
+	return "http"
+}
@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"nubedb/cluster/consensus"
+	"nubedb/discover"
+	"nubedb/internal/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// certExpiryWarning is how long before its expiry a certificate is reported.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// configCheck gathers the problems found while checking the configuration.
+type configCheck struct {
+	errors   []string
+	warnings []string
+}
+
+func (c *configCheck) error(format string, a ...any) {
+	c.errors = append(c.errors, fmt.Sprintf(format, a...))
+}
+
+func (c *configCheck) warn(format string, a ...any) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, a...))
+}
+
+// configCmd runs the subcommands which work with the node's configuration.
+func configCmd(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return errors.New("usage: nubedb config check [--discover=false]")
+	}
+	return checkConfig(args[1:])
+}
+
+// checkConfig loads the configuration like the node does on start, without starting it,
+// validates it and prints the effective one, so misconfigurations surface before a deploy instead of as crashes.
+func checkConfig(args []string) error {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	discovery := fs.Bool("discover", true, "resolve the other nodes of the cluster like the node does on start")
+	_ = fs.Parse(args)
+
+	cfg, errCfg := config.New()
+	if errCfg != nil {
+		return errCfg
+	}
+
+	check := &configCheck{}
+	for _, v := range config.Vars() {
+		if v.Problem != "" {
+			check.error("%s: %s", v.Name, v.Problem)
+		}
+	}
+	for _, name := range config.UnusedVars() {
+		check.warn("%s is set but isn't used, is it misspelled?", name)
+	}
+	checkAddresses(check, cfg)
+	checkPaths(check, cfg)
+	checkTLS(check, cfg.Rest)
+	if *discovery && !cfg.Cluster.SingleNode {
+		checkDiscovery(check, cfg)
+	}
+
+	fmt.Println("Effective configuration:")
+	for _, v := range config.Vars() {
+		value := v.Value
+		if v.Secret && value != "" {
+			value = "<redacted>"
+		}
+		fmt.Printf("  %s=%s (%s)\n", v.Name, value, v.Source)
+	}
+	for _, w := range check.warnings {
+		fmt.Println("warning:", w)
+	}
+	for _, e := range check.errors {
+		fmt.Println("error:", e)
+	}
+	if len(check.errors) > 0 {
+		return fmt.Errorf("the configuration has %v errors", len(check.errors))
+	}
+	fmt.Println("The configuration is valid")
+	return nil
+}
+
+// checkAddresses validates the node's addresses and reports the ports already in use, like by another process.
+func checkAddresses(check *configCheck, cfg config.Config) {
+	node := cfg.CurrentNode
+	addresses := map[string]string{
+		"api":       node.ApiAddress,
+		"consensus": node.ConsensusAddress,
+		"grpc":      node.GrpcAddress,
+	}
+	for shard := 1; shard < cfg.Sharding.Shards; shard++ {
+		addresses["consensus of shard "+strconv.Itoa(shard)] = config.MakeShardConsensusAddr(node.ID, shard)
+	}
+
+	ports := make(map[string]string)
+	for name, addr := range addresses {
+		_, port, errSplit := net.SplitHostPort(addr)
+		if errSplit != nil {
+			check.error("the %s address %q is invalid: %v", name, addr, errSplit)
+			continue
+		}
+		if other, ok := ports[port]; ok {
+			check.error("the %s and %s addresses use the same port %s", name, other, port)
+			continue
+		}
+		ports[port] = name
+		ln, errListen := net.Listen("tcp", ":"+port)
+		if errListen != nil {
+			check.warn("the %s port %s can't be listened on, is the node already running? %v", name, port, errListen)
+			continue
+		}
+		_ = ln.Close()
+	}
+
+	for _, socket := range []string{cfg.Rest.UnixSocket, cfg.Grpc.UnixSocket} {
+		if socket != "" {
+			checkDir(check, filepath.Dir(socket), "the dir of the unix socket "+socket)
+		}
+	}
+}
+
+// checkPaths checks the node can write to the dirs its data is stored in.
+func checkPaths(check *configCheck, cfg config.Config) {
+	paths := consensus.NodePaths(cfg.CurrentNode.ID, cfg.Storage, cfg.Consensus)
+	for _, dir := range paths.Roots() {
+		checkDir(check, dir, "the data dir "+dir)
+	}
+}
+
+// checkDir checks dir, or its nearest parent if it doesn't exist yet, is a dir the node can write to.
+func checkDir(check *configCheck, dir string, what string) {
+	existing := dir
+	for {
+		info, errStat := os.Stat(existing)
+		if errStat == nil {
+			if !info.IsDir() {
+				check.error("%s isn't a dir", what)
+				return
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if !os.IsNotExist(errStat) || parent == existing {
+			check.error("%s can't be accessed: %v", what, errStat)
+			return
+		}
+		existing = parent
+	}
+
+	f, errCreate := os.CreateTemp(existing, ".nubedb-check-*")
+	if errCreate != nil {
+		check.error("%s isn't writable: %v", what, errCreate)
+		return
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
+
+// checkTLS loads the certificate the API is served with, reporting it if it's expired or about to.
+func checkTLS(check *configCheck, rest config.RestCfg) {
+	if rest.TLSCertFile == "" && rest.TLSKeyFile == "" {
+		if rest.AutocertDomains != "" {
+			checkDir(check, rest.AutocertCacheDir, "the autocert cache dir "+rest.AutocertCacheDir)
+		}
+		return
+	}
+	if rest.TLSCertFile == "" || rest.TLSKeyFile == "" {
+		check.error("TLS needs both NUBEDB_TLS_CERT_FILE and NUBEDB_TLS_KEY_FILE")
+		return
+	}
+	pair, errPair := tls.LoadX509KeyPair(rest.TLSCertFile, rest.TLSKeyFile)
+	if errPair != nil {
+		check.error("couldn't load the TLS certificate: %v", errPair)
+		return
+	}
+	cert, errParse := x509.ParseCertificate(pair.Certificate[0])
+	if errParse != nil {
+		check.error("couldn't parse the TLS certificate: %v", errParse)
+		return
+	}
+	switch left := time.Until(cert.NotAfter); {
+	case left <= 0:
+		check.error("the TLS certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	case left < certExpiryWarning:
+		check.warn("the TLS certificate expires on %s", cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// checkDiscovery resolves the other nodes like the node does on start.
+// Finding none isn't an error, the first node of a cluster doesn't find any.
+func checkDiscovery(check *configCheck, cfg config.Config) {
+	if errIface := discover.ConfigureInterface(cfg.CurrentNode.Interface); errIface != nil {
+		check.error("NUBEDB_NETWORK_INTERFACE: %v", errIface)
+		return
+	}
+	if errCloud := discover.ConfigureCloud(cfg.Cluster.CloudJoin); errCloud != nil {
+		check.error("NUBEDB_CLOUD_JOIN: %v", errCloud)
+		return
+	}
+	nodes, errSearch := discover.SearchNodes(cfg.CurrentNode.ID)
+	if errSearch != nil {
+		check.warn("couldn't discover the other nodes: %v", errSearch)
+		return
+	}
+	fmt.Printf("Discovered nodes: %v\n", nodes)
+}
@@ -0,0 +1,244 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"nubedb/pkg/etcdsnap"
+	"nubedb/pkg/rdb"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats which can be imported.
+const (
+	importRedis = "redis"
+	importEtcd  = "etcd"
+)
+
+// importRecord is a key to import.
+type importRecord struct {
+	key   string
+	value any
+	// expiresAt is when the key expires in the source, zero if it doesn't.
+	expiresAt time.Time
+}
+
+// importStats counts what happened to the keys of the source.
+type importStats struct {
+	imported int64
+	expired  int64
+	ttl      int64
+	encoded  int64
+}
+
+// importData ingests a Redis RDB dump or an etcd snapshot into a cluster through its API.
+//
+// nubedb doesn't expire keys, so the keys which already expired in the source are skipped,
+// and the rest are imported without their TTL, which is reported.
+func importData(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "format of the file: redis (an RDB dump) or etcd (a v3 snapshot)")
+	path := fs.String("file", "", "path of the file to import")
+	addrs := fs.String("addr", "http://localhost:3001", "comma separated API addresses of the nodes, the writes are spread across them")
+	prefix := fs.String("prefix", "", "prefix added to the imported keys, they can be placed in a bucket, ex: redis/")
+	db := fs.Int("db", -1, "only import this Redis database, -1 imports all of them into the same keyspace")
+	concurrency := fs.Int("concurrency", 8, "number of concurrent writes")
+	_ = fs.Parse(args)
+
+	if *path == "" {
+		return errors.New("file is required")
+	}
+	if *concurrency < 1 {
+		return errors.New("concurrency must be positive")
+	}
+
+	var (
+		stats   importStats
+		records = make(chan importRecord)
+		errs    = make(chan error, 1)
+		wg      sync.WaitGroup
+	)
+	client := &importClient{http: &http.Client{Timeout: 30 * time.Second}, nodes: strings.Split(*addrs, ",")}
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for r := range records {
+				errSet := client.set(worker, *prefix+r.key, r.value)
+				if errSet != nil {
+					select {
+					case errs <- fmt.Errorf("couldn't import key '%s': %w", r.key, errSet):
+					default:
+					}
+					continue
+				}
+				atomic.AddInt64(&stats.imported, 1)
+			}
+		}(i)
+	}
+
+	now := time.Now()
+	emit := func(r importRecord) error {
+		select {
+		case errImport := <-errs:
+			return errImport
+		default:
+		}
+		if !r.expiresAt.IsZero() {
+			if !r.expiresAt.After(now) {
+				stats.expired++
+				return nil
+			}
+			stats.ttl++
+		}
+		records <- r
+		return nil
+	}
+
+	var errRead error
+	switch *format {
+	case importRedis:
+		errRead = readRedisDump(*path, *db, &stats, emit)
+	case importEtcd:
+		errRead = readEtcdSnapshot(*path, &stats, emit)
+	default:
+		errRead = fmt.Errorf("format not recognized: '%s', it must be %s or %s", *format, importRedis, importEtcd)
+	}
+	close(records)
+	wg.Wait()
+	if errRead != nil {
+		return errRead
+	}
+	select {
+	case errImport := <-errs:
+		return errImport
+	default:
+	}
+
+	fmt.Printf("imported %v keys\n", stats.imported)
+	if stats.expired > 0 {
+		fmt.Printf("skipped %v keys which already expired\n", stats.expired)
+	}
+	if stats.ttl > 0 {
+		fmt.Printf("%v keys had a TTL, they were imported without it\n", stats.ttl)
+	}
+	if stats.encoded > 0 {
+		fmt.Printf("%v values weren't valid UTF-8 text, they were stored base64 encoded\n", stats.encoded)
+	}
+	return nil
+}
+
+func readRedisDump(path string, db int, stats *importStats, emit func(r importRecord) error) error {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return errOpen
+	}
+	defer f.Close()
+
+	return rdb.Parse(f, func(e rdb.Entry) error {
+		if db >= 0 && e.DB != db {
+			return nil
+		}
+		return emit(importRecord{key: e.Key, value: redisValue(e.Value, stats), expiresAt: e.ExpiresAt})
+	})
+}
+
+// redisValue converts a Redis value to one which can be encoded as JSON.
+func redisValue(v any, stats *importStats) any {
+	switch value := v.(type) {
+	case string:
+		return importText([]byte(value), stats)
+	case []string:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, importText([]byte(item), stats))
+		}
+		return items
+	case map[string]string:
+		hash := make(map[string]string, len(value))
+		for field, item := range value {
+			hash[field] = importText([]byte(item), stats)
+		}
+		return hash
+	case map[string]float64:
+		// JSON can't hold infinite scores, which Redis allows, so they are kept as Redis writes them.
+		members := make(map[string]any, len(value))
+		for member, score := range value {
+			switch {
+			case math.IsInf(score, 1):
+				members[member] = "inf"
+			case math.IsInf(score, -1):
+				members[member] = "-inf"
+			case math.IsNaN(score):
+				members[member] = "nan"
+			default:
+				members[member] = score
+			}
+		}
+		return members
+	default:
+		return v
+	}
+}
+
+func readEtcdSnapshot(path string, stats *importStats, emit func(r importRecord) error) error {
+	now := time.Now()
+	return etcdsnap.Read(path, func(e etcdsnap.Entry) error {
+		r := importRecord{key: e.Key, value: importText(e.Value, stats)}
+		if e.TTL > 0 {
+			r.expiresAt = now.Add(e.TTL)
+		}
+		return emit(r)
+	})
+}
+
+// importText returns b as a string, base64 encoding it if it isn't valid UTF-8, as JSON would corrupt it.
+func importText(b []byte, stats *importStats) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	atomic.AddInt64(&stats.encoded, 1)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// importClient writes the imported keys.
+type importClient struct {
+	http  *http.Client
+	nodes []string
+}
+
+// set writes a key, every worker writes to a node, so the writes are spread across them.
+func (c *importClient) set(worker int, key string, value any) error {
+	b, errMarshal := json.Marshal(map[string]any{"key": key, "value": value})
+	if errMarshal != nil {
+		return errMarshal
+	}
+	node := strings.TrimSuffix(c.nodes[worker%len(c.nodes)], "/")
+	req, errReq := http.NewRequest(http.MethodPost, node+"/store", bytes.NewReader(b))
+	if errReq != nil {
+		return errReq
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, errDo := c.http.Do(req)
+	if errDo != nil {
+		return errDo
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s answered with status code %v: %s", node, res.StatusCode, body)
+	}
+	return nil
+}
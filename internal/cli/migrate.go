@@ -0,0 +1,337 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"io"
+	"log"
+	"nubedb/api/proto"
+	"nubedb/api/proto/protoclient"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// migrateStreamThreshold is the size from which values are sent with PutStream, instead of in the Write stream,
+	// since a gRPC message can't hold them.
+	migrateStreamThreshold = 1024 * 1024
+	// migrateStreamChunk is the size of each message of PutStream.
+	migrateStreamChunk = 256 * 1024
+	// migrateVerifyRounds is how many times a key which doesn't match is read again,
+	// since the node read from can be a follower which didn't apply the write yet.
+	migrateVerifyRounds = 3
+	migrateVerifyDelay  = 1 * time.Second
+	migrateProgressStep = 10000
+)
+
+// migrateSource is the badger data dir of a single node being migrated.
+type migrateSource struct {
+	dbFSM *fsm.DatabaseFSM
+	keys  []string
+	// sums are the checksums of the values, compared with the ones read back from the cluster.
+	sums map[string][sha256.Size]byte
+}
+
+// migrate imports the data dir of a node which ran before it was part of a cluster into a running cluster,
+// through replicated writes, so the users of a single node can upgrade to a cluster without downtime for the writes
+// already made. Afterwards, every key is read back from the cluster and compared with the source.
+//
+// Only the keys are migrated, nubedb's metadata, like the indexes, schemas and hooks, must be created again.
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "badger data dir of the single node to migrate, ex: ./data/old (required)")
+	addr := fs.String("addr", "localhost:3003", "gRPC address of a node of the cluster the keys are migrated to")
+	verify := fs.Bool("verify", true, "read every key back from the cluster, comparing it with the source")
+	concurrency := fs.Int("concurrency", 8, "number of keys read back at once by the verification")
+	_ = fs.Parse(args)
+
+	if *from == "" {
+		fs.Usage()
+		return errors.New("--from is required")
+	}
+	if *concurrency < 1 {
+		return errors.New("concurrency must be positive")
+	}
+	if !fileExists(filepath.Join(*from, "MANIFEST")) {
+		return fmt.Errorf("'%s' isn't a badger data dir", *from)
+	}
+
+	enc, errEnc := config.NewEncryptionCfg()
+	if errEnc != nil {
+		return errEnc
+	}
+	db, errDB := consensus.OpenBadger(*from, enc)
+	if errDB != nil {
+		return errorskit.Wrap(errDB, "is the node stopped?")
+	}
+	defer db.Close()
+	dbFSM, errFSM := fsm.New(engine.NewBadger(db), 0, 0)
+	if errFSM != nil {
+		return errFSM
+	}
+	src := &migrateSource{dbFSM: dbFSM, keys: dbFSM.GetKeys(), sums: make(map[string][sha256.Size]byte)}
+	sort.Strings(src.keys)
+	log.Printf("[migrate] migrating %v keys from '%s' to %s\n", len(src.keys), *from, *addr)
+
+	failed, errWrite := migrateWrite(src, *addr)
+	if errWrite != nil {
+		return errWrite
+	}
+	migrated := len(src.keys) - len(failed)
+	fmt.Printf("migrated %v of %v keys\n", migrated, len(src.keys))
+	for _, k := range sortedFailures(failed) {
+		fmt.Printf("  couldn't migrate '%s': %s\n", k, failed[k])
+	}
+
+	var mismatched map[string]string
+	if *verify {
+		mismatched = migrateVerify(src, failed, *addr, *concurrency)
+		fmt.Printf("verified %v keys, %v don't match the source\n", migrated, len(mismatched))
+		for _, k := range sortedFailures(mismatched) {
+			fmt.Printf("  '%s': %s\n", k, mismatched[k])
+		}
+	}
+	if len(failed) > 0 || len(mismatched) > 0 {
+		return fmt.Errorf("%v keys weren't migrated and %v don't match, the migration can be run again",
+			len(failed), len(mismatched))
+	}
+	return nil
+}
+
+// migrateWrite writes the keys of the source through a Write stream, returning the ones which failed with their errors.
+// The values too large for a message are sent with PutStream.
+func migrateWrite(src *migrateSource, addr string) (map[string]string, error) {
+	conn, errConn := protoclient.NewStreamConnection(addr)
+	if errConn != nil {
+		return nil, errConn
+	}
+	defer conn.Cleanup()
+	stream, errStream := conn.Client.Write(conn.Ctx)
+	if errStream != nil {
+		return nil, errorskit.Wrap(errStream, "couldn't open the write stream")
+	}
+
+	var (
+		failedMu sync.Mutex
+		failed   = make(map[string]string)
+		acked    = make(chan error, 1)
+	)
+	fail := func(k string, reason string) {
+		failedMu.Lock()
+		failed[k] = reason
+		failedMu.Unlock()
+	}
+	go func() {
+		for {
+			res, errRecv := stream.Recv()
+			if errors.Is(errRecv, io.EOF) {
+				acked <- nil
+				return
+			}
+			if errRecv != nil {
+				acked <- errorskit.Wrap(errRecv, "the write stream failed")
+				return
+			}
+			if res.Error != "" {
+				fail(src.keys[res.Id-1], res.Error)
+			}
+		}
+	}()
+
+	for i, k := range src.keys {
+		value, contentType, errRead := readSourceValue(src.dbFSM, k)
+		if errRead != nil {
+			fail(k, errRead.Error())
+			continue
+		}
+		src.sums[k] = migrateChecksum(contentType, value)
+
+		if len(value) >= migrateStreamThreshold {
+			errPut := migratePutStream(conn, k, contentType, value)
+			if errPut != nil {
+				fail(k, errPut.Error())
+			}
+		} else {
+			errSend := stream.Send(&proto.WriteRequest{Id: uint64(i + 1), Key: k, Value: value, ContentType: contentType})
+			if errSend != nil {
+				return nil, errorskit.Wrap(errSend, "the write stream failed")
+			}
+		}
+		if (i+1)%migrateProgressStep == 0 {
+			log.Printf("[migrate] sent %v of %v keys\n", i+1, len(src.keys))
+		}
+	}
+	errClose := stream.CloseSend()
+	if errClose != nil {
+		return nil, errorskit.Wrap(errClose, "couldn't close the write stream")
+	}
+	if errAcked := <-acked; errAcked != nil {
+		return nil, errAcked
+	}
+	return failed, nil
+}
+
+// migratePutStream sets a large value with PutStream, in chunks.
+func migratePutStream(conn *protoclient.Connection, k string, contentType string, value []byte) error {
+	if contentType == "" {
+		contentType = fsm.ContentTypeJSON
+	}
+	stream, errStream := conn.Client.PutStream(conn.Ctx)
+	if errStream != nil {
+		return errStream
+	}
+	for offset := 0; offset < len(value); offset += migrateStreamChunk {
+		end := offset + migrateStreamChunk
+		if end > len(value) {
+			end = len(value)
+		}
+		req := &proto.PutStreamRequest{Data: value[offset:end]}
+		if offset == 0 {
+			req.Key, req.ContentType = k, contentType
+		}
+		errSend := stream.Send(req)
+		if errSend != nil {
+			return errSend
+		}
+	}
+	_, errClose := stream.CloseAndRecv()
+	return errClose
+}
+
+// migrateVerify reads back from the cluster the keys which were migrated, returning the ones which don't match the source.
+func migrateVerify(src *migrateSource, failed map[string]string, addr string, concurrency int) map[string]string {
+	pending := make([]string, 0, len(src.keys))
+	for _, k := range src.keys {
+		if _, ok := failed[k]; !ok {
+			pending = append(pending, k)
+		}
+	}
+
+	mismatched := make(map[string]string)
+	for round := 1; round <= migrateVerifyRounds && len(pending) > 0; round++ {
+		if round > 1 {
+			time.Sleep(migrateVerifyDelay)
+		}
+		mismatched = verifyKeys(src, pending, addr, concurrency)
+		pending = pending[:0]
+		for k := range mismatched {
+			pending = append(pending, k)
+		}
+	}
+	return mismatched
+}
+
+// verifyKeys compares the checksums of keys in the cluster with the source's, spread across concurrency workers.
+func verifyKeys(src *migrateSource, keys []string, addr string, concurrency int) map[string]string {
+	var (
+		mu         sync.Mutex
+		mismatched = make(map[string]string)
+		wg         sync.WaitGroup
+		queue      = make(chan string)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range queue {
+				errVerify := verifyKey(src, k, addr)
+				if errVerify != nil {
+					mu.Lock()
+					mismatched[k] = errVerify.Error()
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, k := range keys {
+		queue <- k
+	}
+	close(queue)
+	wg.Wait()
+	return mismatched
+}
+
+// verifyKey reads a key from the cluster, returning an error if it doesn't match the source.
+func verifyKey(src *migrateSource, k string, addr string) error {
+	conn, errConn := protoclient.NewConnection(addr)
+	if errConn != nil {
+		return errConn
+	}
+	defer conn.Cleanup()
+	ctx, cancel := context.WithCancel(conn.Ctx)
+	defer cancel()
+
+	stream, errStream := conn.Client.GetStream(ctx, &proto.GetStreamRequest{Key: k})
+	if errStream != nil {
+		return errStream
+	}
+	var (
+		value       bytes.Buffer
+		contentType string
+	)
+	for {
+		res, errRecv := stream.Recv()
+		if errors.Is(errRecv, io.EOF) {
+			break
+		}
+		if errRecv != nil {
+			return errRecv
+		}
+		if res.ContentType != "" {
+			contentType = res.ContentType
+		}
+		value.Write(res.Data)
+	}
+	if migrateChecksum(contentType, value.Bytes()) != src.sums[k] {
+		return errors.New("the checksum of its value doesn't match")
+	}
+	return nil
+}
+
+// readSourceValue reads the value of a key of the source with its content type, empty for JSON.
+func readSourceValue(dbFSM *fsm.DatabaseFSM, k string) ([]byte, string, error) {
+	r, contentType, errOpen := dbFSM.OpenValue(k)
+	if errOpen != nil {
+		return nil, "", errOpen
+	}
+	value, errRead := io.ReadAll(r)
+	if errRead != nil {
+		return nil, "", errRead
+	}
+	return value, contentType, nil
+}
+
+// migrateChecksum returns the checksum of a value. The JSON values are decoded and encoded again first,
+// since the cluster stores them in its own encoding, like with the object fields sorted.
+func migrateChecksum(contentType string, value []byte) [sha256.Size]byte {
+	if fsm.IsJSON(contentType) {
+		var decoded any
+		if json.Unmarshal(value, &decoded) == nil {
+			if encoded, errMarshal := json.Marshal(decoded); errMarshal == nil {
+				value = encoded
+			}
+		}
+	}
+	return sha256.Sum256(value)
+}
+
+func sortedFailures(failures map[string]string) []string {
+	keys := make([]string, 0, len(failures))
+	for k := range failures {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
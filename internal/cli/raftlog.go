@@ -0,0 +1,231 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const raftLogUsage = "usage: nubedb raft-log <dump|tail> [flags]"
+
+// raftLogEntry is a consensus log, with its payload decoded.
+type raftLogEntry struct {
+	Index      uint64          `json:"index"`
+	Term       uint64          `json:"term"`
+	Type       string          `json:"type"`
+	AppendedAt *time.Time      `json:"appendedAt,omitempty"`
+	Operation  string          `json:"operation,omitempty"`
+	Key        string          `json:"key,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	// Servers are the servers of a configuration change.
+	Servers []string `json:"servers,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// raftLog prints the consensus logs of a stopped node, decoding their payloads,
+// to find out what was committed during an incident.
+//
+// dump prints a range of logs, and tail prints the latest ones.
+func raftLog(args []string) error {
+	if len(args) < 1 || (args[0] != "dump" && args[0] != "tail") {
+		return errors.New(raftLogUsage)
+	}
+	mode := args[0]
+
+	fs := flag.NewFlagSet("raft-log "+mode, flag.ExitOnError)
+	nodeID := fs.String("node", defaultNodeID(), "ID of the node whose consensus logs are read")
+	shardID := fs.Int("shard", 0, "shard whose consensus logs are read")
+	from := fs.Uint64("from", 0, "dump: first index printed, 0 starts at the first retained log")
+	to := fs.Uint64("to", 0, "dump: last index printed, 0 ends at the last log")
+	n := fs.Int("n", 20, "tail: number of logs printed")
+	op := fs.String("op", "", "only prints the payloads with this operation, ex: SET")
+	prefix := fs.String("prefix", "", "only prints the payloads whose key starts with this prefix")
+	maxValue := fs.Int("max-value", 200, "truncates the printed values to this many bytes, 0 doesn't truncate them")
+	asJSON := fs.Bool("json", false, "prints a JSON object per log, instead of a table")
+	_ = fs.Parse(args[1:])
+
+	consensusCfg := config.NewConsensusCfg()
+	paths := consensus.NodePaths(*nodeID, config.NewStorageCfg(), consensusCfg)
+	if *shardID > 0 {
+		paths = paths.Shard(*shardID)
+	}
+	kind := consensusCfg.LogStore
+	if kind == consensus.LogStoreMemory {
+		return errors.New("the in-memory log store doesn't persist the logs")
+	}
+	path := paths.LogStore(kind)
+	if _, errStat := os.Stat(path); errStat != nil {
+		return errorskit.Wrap(errStat, "couldn't find the consensus logs, are the node ID and shard correct?")
+	}
+	enc, errEnc := config.NewEncryptionCfg()
+	if errEnc != nil {
+		return errEnc
+	}
+	store, errStore := consensus.OpenLogStore(path, kind, enc, true)
+	if errStore != nil {
+		return errorskit.Wrap(errStore, "is the node stopped?")
+	}
+	defer store.Close()
+
+	first, errFirst := store.FirstIndex()
+	if errFirst != nil {
+		return errFirst
+	}
+	last, errLast := store.LastIndex()
+	if errLast != nil {
+		return errLast
+	}
+	if last == 0 {
+		return errors.New("there aren't any consensus logs")
+	}
+
+	start, end := first, last
+	if mode == "tail" {
+		if *n < 1 {
+			return errors.New("n must be positive")
+		}
+		if last-first+1 > uint64(*n) {
+			start = last - uint64(*n) + 1
+		}
+	} else {
+		if *from > start {
+			start = *from
+		}
+		if *to > 0 && *to < end {
+			end = *to
+		}
+	}
+
+	out := newRaftLogPrinter(*asJSON)
+	defer out.flush()
+	for i := start; i <= end; i++ {
+		l := new(raft.Log)
+		errGet := store.GetLog(i, l)
+		if errors.Is(errGet, raft.ErrLogNotFound) {
+			continue
+		}
+		if errGet != nil {
+			return errorskit.Wrap(errGet, fmt.Sprintf("couldn't get log %v", i))
+		}
+		e := decodeRaftLog(l, *maxValue)
+		if *op != "" && e.Operation != *op {
+			continue
+		}
+		if *prefix != "" && (l.Type != raft.LogCommand || !strings.HasPrefix(e.Key, *prefix)) {
+			continue
+		}
+		errPrint := out.print(e)
+		if errPrint != nil {
+			return errPrint
+		}
+	}
+	return nil
+}
+
+// decodeRaftLog decodes a log, a payload which can't be decoded is reported in its error instead of failing.
+func decodeRaftLog(l *raft.Log, maxValue int) raftLogEntry {
+	e := raftLogEntry{Index: l.Index, Term: l.Term, Type: l.Type.String()}
+	if !l.AppendedAt.IsZero() {
+		at := l.AppendedAt
+		e.AppendedAt = &at
+	}
+
+	switch l.Type {
+	case raft.LogCommand:
+		p, errDecode := fsm.DecodePayload(l.Data)
+		if errDecode != nil {
+			e.Error = errDecode.Error()
+			return e
+		}
+		e.Operation = p.Operation
+		e.Key = p.Key
+		if p.Value != nil {
+			v, errMarshal := json.Marshal(p.Value)
+			if errMarshal != nil {
+				e.Error = errMarshal.Error()
+				return e
+			}
+			e.Value = truncateValue(v, maxValue)
+		}
+	case raft.LogConfiguration:
+		servers, errDecode := decodeConfiguration(l.Data)
+		if errDecode != nil {
+			e.Error = errDecode.Error()
+			return e
+		}
+		for _, srv := range servers {
+			e.Servers = append(e.Servers, fmt.Sprintf("%s@%s (%s)", srv.ID, srv.Address, srv.Suffrage))
+		}
+	}
+	return e
+}
+
+// decodeConfiguration decodes the servers of a configuration log, raft panics if it's malformed.
+func decodeConfiguration(data []byte) (servers []raft.Server, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("couldn't decode configuration: %v", r)
+		}
+	}()
+	return raft.DecodeConfiguration(data).Servers, nil
+}
+
+// truncateValue truncates a JSON value, quoting it so it's still valid JSON.
+func truncateValue(v []byte, limit int) json.RawMessage {
+	if limit <= 0 || len(v) <= limit {
+		return v
+	}
+	truncated, _ := json.Marshal(string(v[:limit]) + "...")
+	return truncated
+}
+
+// raftLogPrinter prints the logs either as a table or as JSON lines.
+type raftLogPrinter struct {
+	table *tabwriter.Writer
+	json  *json.Encoder
+}
+
+func newRaftLogPrinter(asJSON bool) *raftLogPrinter {
+	if asJSON {
+		return &raftLogPrinter{json: json.NewEncoder(os.Stdout)}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tTERM\tTYPE\tAPPENDED\tOPERATION\tKEY\tVALUE")
+	return &raftLogPrinter{table: w}
+}
+
+func (p *raftLogPrinter) print(e raftLogEntry) error {
+	if p.json != nil {
+		return p.json.Encode(e)
+	}
+	appended := "-"
+	if e.AppendedAt != nil {
+		appended = e.AppendedAt.UTC().Format(time.RFC3339Nano)
+	}
+	detail := string(e.Value)
+	switch {
+	case e.Error != "":
+		detail = "ERROR: " + e.Error
+	case len(e.Servers) > 0:
+		detail = strings.Join(e.Servers, ", ")
+	}
+	_, errWrite := fmt.Fprintf(p.table, "%v\t%v\t%s\t%s\t%s\t%s\t%s\n",
+		e.Index, e.Term, e.Type, appended, e.Operation, e.Key, detail)
+	return errWrite
+}
+
+func (p *raftLogPrinter) flush() {
+	if p.table != nil {
+		_ = p.table.Flush()
+	}
+}
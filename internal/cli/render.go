@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// render renders a local file from a template with the keys under a prefix, and runs a reload command when it changes.
+//
+// With -watch it keeps polling the cluster and re-renders the file whenever the keys change,
+// so nubedb can distribute configuration files like confd.
+func render(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	addrs := fs.String("addr", "http://localhost:3001", "comma separated API addresses of the nodes, the next one is tried if one fails")
+	prefix := fs.String("prefix", "", "prefix of the keys available to the template")
+	tmplPath := fs.String("template", "", "path of the template, in Go's text/template syntax")
+	dest := fs.String("dest", "", "path of the rendered file")
+	watch := fs.Bool("watch", false, "keep rendering the file whenever the keys change, instead of rendering it once")
+	interval := fs.Duration("interval", 5*time.Second, "how often the keys are checked for changes with -watch")
+	reload := fs.String("reload", "", "command run with sh -c after the file changes, ex: 'systemctl reload nginx'")
+	_ = fs.Parse(args)
+
+	if *tmplPath == "" || *dest == "" {
+		return errors.New("template and dest are required")
+	}
+	tmpl, errTmpl := template.New(filepath.Base(*tmplPath)).Funcs(renderFuncs(nil)).ParseFiles(*tmplPath)
+	if errTmpl != nil {
+		return errTmpl
+	}
+
+	r := &renderer{
+		http:   &http.Client{Timeout: 10 * time.Second},
+		nodes:  strings.Split(*addrs, ","),
+		prefix: *prefix,
+		tmpl:   tmpl,
+		dest:   *dest,
+		reload: *reload,
+	}
+	if !*watch {
+		return r.renderOnce()
+	}
+
+	log.Printf("[render] watching '%s' every %s, rendering '%s'\n", *prefix, *interval, *dest)
+	for {
+		errRender := r.renderOnce()
+		if errRender != nil {
+			log.Println("[render] couldn't render:", errRender)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// renderer renders a template with the keys of a prefix.
+type renderer struct {
+	http   *http.Client
+	nodes  []string
+	prefix string
+	tmpl   *template.Template
+	dest   string
+	reload string
+}
+
+// renderOnce renders the file, it's only written, and the reload command run, if its content changed.
+func (r *renderer) renderOnce() error {
+	values, errValues := r.getValues()
+	if errValues != nil {
+		return errValues
+	}
+
+	var buf bytes.Buffer
+	errExecute := template.Must(r.tmpl.Clone()).Funcs(renderFuncs(values)).Execute(&buf, values)
+	if errExecute != nil {
+		return errExecute
+	}
+
+	current, errRead := os.ReadFile(r.dest)
+	if errRead == nil && bytes.Equal(current, buf.Bytes()) {
+		return nil
+	}
+
+	errWrite := writeFileAtomic(r.dest, buf.Bytes())
+	if errWrite != nil {
+		return errWrite
+	}
+	log.Printf("[render] '%s' rendered with %v keys\n", r.dest, len(values))
+
+	if r.reload == "" {
+		return nil
+	}
+	out, errReload := exec.Command("sh", "-c", r.reload).CombinedOutput()
+	if errReload != nil {
+		return fmt.Errorf("reload command failed: %v: %s", errReload, out)
+	}
+	return nil
+}
+
+// getValues returns the values of all the keys under the prefix, trying the nodes in order.
+func (r *renderer) getValues() (map[string]any, error) {
+	var lastErr error
+	for _, node := range r.nodes {
+		values, errValues := r.getValuesFrom(strings.TrimSuffix(node, "/"))
+		if errValues == nil {
+			return values, nil
+		}
+		lastErr = errValues
+	}
+	return nil, lastErr
+}
+
+func (r *renderer) getValuesFrom(node string) (map[string]any, error) {
+	var keys []string
+	_, errKeys := r.get(node+"/store/keys?prefix="+url.QueryEscape(r.prefix), nil, &keys)
+	if errKeys != nil {
+		return nil, errKeys
+	}
+
+	values := make(map[string]any, len(keys))
+	for _, k := range keys {
+		var value any
+		found, errGet := r.get(node+"/store", map[string]string{"key": k}, &value)
+		if errGet != nil {
+			return nil, errGet
+		}
+		// The key could have been deleted after listing it.
+		if found {
+			values[k] = value
+		}
+	}
+	return values, nil
+}
+
+// get sends a GET request with an optional JSON body, and decodes the data of the response into v.
+//
+// It returns false if the request was answered with a 404.
+func (r *renderer) get(u string, body any, v any) (bool, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		errEncode := json.NewEncoder(&reqBody).Encode(body)
+		if errEncode != nil {
+			return false, errEncode
+		}
+	}
+	req, errReq := http.NewRequest(http.MethodGet, u, &reqBody)
+	if errReq != nil {
+		return false, errReq
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, errDo := r.http.Do(req)
+	if errDo != nil {
+		return false, errDo
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s answered with status code %v", u, res.StatusCode)
+	}
+
+	data := struct {
+		Data any `json:"data"`
+	}{Data: v}
+	errDecode := json.NewDecoder(res.Body).Decode(&data)
+	if errDecode != nil {
+		return false, errDecode
+	}
+	return true, nil
+}
+
+// renderFuncs returns the functions available to the templates, over the values of the keys.
+func renderFuncs(values map[string]any) template.FuncMap {
+	return template.FuncMap{
+		// get returns the value of a key, nil if it doesn't exist.
+		"get": func(k string) any {
+			return values[k]
+		},
+		// getv returns the value of a key as a string, the values which aren't strings are written as JSON.
+		"getv": func(k string) (string, error) {
+			return toText(values[k])
+		},
+		"exists": func(k string) bool {
+			_, ok := values[k]
+			return ok
+		},
+		// ls returns the keys starting with a prefix, sorted.
+		"ls": func(prefix string) []string {
+			keys := make([]string, 0)
+			for k := range values {
+				if strings.HasPrefix(k, prefix) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"json": func(v any) (string, error) {
+			b, errMarshal := json.Marshal(v)
+			return string(b), errMarshal
+		},
+		"base": func(k string) string {
+			return k[strings.LastIndex(k, "/")+1:]
+		},
+	}
+}
+
+func toText(v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	if v == nil {
+		return "", nil
+	}
+	b, errMarshal := json.Marshal(v)
+	return string(b), errMarshal
+}
+
+// writeFileAtomic writes a file through a temporary file in the same dir, so readers never see it half written.
+//
+// The file keeps its permissions if it already existed.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, errStat := os.Stat(path); errStat == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, errTmp := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if errTmp != nil {
+		return errTmp
+	}
+	defer os.Remove(tmp.Name())
+
+	errChmod := tmp.Chmod(mode)
+	if errChmod != nil {
+		_ = tmp.Close()
+		return errChmod
+	}
+
+	_, errWrite := tmp.Write(data)
+	errClose := tmp.Close()
+	if errWrite != nil {
+		return errWrite
+	}
+	if errClose != nil {
+		return errClose
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"log"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"os"
+	"strconv"
+	"time"
+)
+
+// restoreUntil is the point in time up to which the logs are replayed, either an index or a time.
+type restoreUntil struct {
+	index uint64
+	time  time.Time
+}
+
+// includes returns whether a log is before or at the point in time.
+func (u restoreUntil) includes(l *raft.Log) bool {
+	if !u.time.IsZero() {
+		return !l.AppendedAt.After(u.time)
+	}
+	return l.Index <= u.index
+}
+
+// restore restores a backup into a stopped node's data dir,
+// and then replays the retained consensus logs up to a chosen index or time.
+//
+// Afterwards, the consensus state is removed, so the node starts as a new node with the restored data
+// and the logs aren't replayed again (which would re-apply the operations that were excluded).
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	nodeID := fs.String("node", defaultNodeID(), "ID of the node whose data dir will be restored")
+	backupPath := fs.String("backup", "", "path to a backup file taken from store/backup. If empty, the current data is kept")
+	fromIndex := fs.Uint64("from", 0, "only replay the logs after this index, it should be the index the backup was taken at")
+	untilFlag := fs.String("until", "", "index or RFC3339 time up to which the logs are replayed (required)")
+	_ = fs.Parse(args)
+
+	if *untilFlag == "" {
+		fs.Usage()
+		return errors.New("--until is required")
+	}
+	until, errUntil := parseRestoreUntil(*untilFlag)
+	if errUntil != nil {
+		return errUntil
+	}
+
+	consensusCfg := config.NewConsensusCfg()
+	paths := consensus.NodePaths(*nodeID, config.NewStorageCfg(), consensusCfg)
+	consensusDBPath := paths.LogStore(consensusCfg.LogStore)
+	if _, errStat := os.Stat(consensusDBPath); errStat != nil {
+		return errorskit.Wrap(errStat, "couldn't find the consensus logs, is the node ID correct?")
+	}
+
+	enc, errEnc := config.NewEncryptionCfg()
+	if errEnc != nil {
+		return errEnc
+	}
+
+	db, errDB := consensus.OpenBadger(paths.Storage, enc)
+	if errDB != nil {
+		return errorskit.Wrap(errDB, "is the node stopped?")
+	}
+	defer db.Close()
+	dbFSM, errFSM := fsm.New(engine.NewBadger(db), 0, 0)
+	if errFSM != nil {
+		return errFSM
+	}
+
+	if *backupPath != "" {
+		errBackup := restoreBackupFile(db, dbFSM, *backupPath)
+		if errBackup != nil {
+			return errBackup
+		}
+		log.Println("[restore] backup restored:", *backupPath)
+	}
+
+	replayed, errReplay := replayLogs(dbFSM, consensusDBPath, consensusCfg.LogStore, enc, *fromIndex, until)
+	if errReplay != nil {
+		return errReplay
+	}
+	log.Printf("[restore] %v logs replayed\n", replayed)
+
+	errReset := resetConsensus(consensusDBPath, paths.SnapshotStore())
+	if errReset != nil {
+		return errReset
+	}
+
+	log.Println("[restore] node restored. Start it as the only node of a new cluster, and join the rest to it")
+	return nil
+}
+
+func parseRestoreUntil(s string) (restoreUntil, error) {
+	index, errIndex := strconv.ParseUint(s, 10, 64)
+	if errIndex == nil {
+		return restoreUntil{index: index}, nil
+	}
+	t, errTime := time.Parse(time.RFC3339, s)
+	if errTime != nil {
+		return restoreUntil{}, fmt.Errorf("--until must be an index or an RFC3339 time, got: %s", s)
+	}
+	return restoreUntil{time: t}, nil
+}
+
+// restoreBackupFile replaces all the data of the DB with the contents of a backup file.
+func restoreBackupFile(db *badger.DB, dbFSM *fsm.DatabaseFSM, backupPath string) error {
+	b, errRead := os.ReadFile(backupPath)
+	if errRead != nil {
+		return errorskit.Wrap(errRead, "couldn't read backup file")
+	}
+	contents := make(map[string]any)
+	errUnmarshal := json.Unmarshal(b, &contents)
+	if errUnmarshal != nil {
+		return errorskit.Wrap(errUnmarshal, "couldn't unmarshal backup file")
+	}
+
+	errDrop := db.DropAll()
+	if errDrop != nil {
+		return errorskit.Wrap(errDrop, "couldn't drop current data")
+	}
+
+	return dbFSM.RestoreDB(contents)
+}
+
+// replayLogs applies the retained consensus logs between fromIndex and until into the FSM.
+func replayLogs(
+	dbFSM *fsm.DatabaseFSM, consensusDBPath string, kind string, enc config.EncryptionCfg, fromIndex uint64,
+	until restoreUntil,
+) (int, error) {
+	store, errStore := consensus.OpenLogStore(consensusDBPath, kind, enc, true)
+	if errStore != nil {
+		return 0, errorskit.Wrap(errStore, "is the node stopped?")
+	}
+	defer store.Close()
+
+	first, errFirst := store.FirstIndex()
+	if errFirst != nil {
+		return 0, errFirst
+	}
+	last, errLast := store.LastIndex()
+	if errLast != nil {
+		return 0, errLast
+	}
+	if fromIndex+1 < first {
+		log.Printf("[restore] WARNING: logs before index %v were compacted and can't be replayed\n", first)
+	}
+
+	start := fromIndex + 1
+	if start < first {
+		start = first
+	}
+
+	replayed := 0
+	for i := start; i <= last && i > 0; i++ {
+		l := new(raft.Log)
+		errGet := store.GetLog(i, l)
+		if errGet != nil {
+			return replayed, errorskit.Wrap(errGet, fmt.Sprintf("couldn't get log %v", i))
+		}
+		if !until.includes(l) {
+			break
+		}
+		if l.Type != raft.LogCommand {
+			continue
+		}
+
+		res := dbFSM.Apply(l)
+		if applyRes, ok := res.(*fsm.ApplyRes); ok && applyRes.Error != nil {
+			// The operation also failed when it was originally applied, so it's not an error to skip it.
+			log.Printf("[restore] log %v didn't apply: %v\n", i, applyRes.Error)
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// resetConsensus removes the consensus logs and snapshots of the node.
+func resetConsensus(consensusDBPath string, snapshotsDir string) error {
+	errLogs := os.RemoveAll(consensusDBPath)
+	if errLogs != nil {
+		return errorskit.Wrap(errLogs, "couldn't remove consensus logs")
+	}
+	errSnaps := os.RemoveAll(snapshotsDir)
+	if errSnaps != nil {
+		return errorskit.Wrap(errSnaps, "couldn't remove consensus snapshots")
+	}
+	return nil
+}
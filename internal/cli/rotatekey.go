@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"log"
+	"nubedb/cluster/consensus"
+	"nubedb/internal/config"
+	"nubedb/pkg/encrypt"
+)
+
+// rotateKey re-encrypts a stopped node's data with the master key currently configured.
+//
+// Badger only re-encrypts its key registry, since the data keys it contains are the ones used for the data.
+// The consensus logs are rewritten completely.
+func rotateKey(args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	nodeID := fs.String("node", defaultNodeID(), "ID of the node whose data dir will be re-encrypted")
+	oldKeyFlag := fs.String("old-key", "", "hex encoded previous master key. If empty, the data is considered plaintext")
+	_ = fs.Parse(args)
+
+	enc, errEnc := config.NewEncryptionCfg()
+	if errEnc != nil {
+		return errEnc
+	}
+	if !enc.Enabled() {
+		return errors.New("no encryption key configured, set the new key in NUBEDB_ENCRYPTION_KEY")
+	}
+
+	var oldKey []byte
+	if *oldKeyFlag != "" {
+		k, errKey := encrypt.ParseKey(*oldKeyFlag)
+		if errKey != nil {
+			return errKey
+		}
+		oldKey = k
+	}
+
+	consensusCfg := config.NewConsensusCfg()
+	paths := consensus.NodePaths(*nodeID, config.NewStorageCfg(), consensusCfg)
+	errBadger := rotateBadgerKey(paths.Storage, oldKey, enc)
+	if errBadger != nil {
+		return errBadger
+	}
+	log.Println("[rotate-key] badger key registry re-encrypted")
+
+	// The old key is added to the configured ones, so the logs can be decrypted.
+	if oldKey != nil {
+		enc.OldKeys = append(enc.OldKeys, oldKey)
+	}
+	kind := consensusCfg.LogStore
+	if kind == consensus.LogStoreBadger {
+		errConsensusBadger := rotateBadgerKey(paths.LogStore(kind), oldKey, enc)
+		if errConsensusBadger != nil {
+			return errConsensusBadger
+		}
+	}
+	rewritten, errLogs := rewriteLogs(paths.LogStore(kind), kind, enc)
+	if errLogs != nil {
+		return errLogs
+	}
+	log.Printf("[rotate-key] %v consensus logs re-encrypted\n", rewritten)
+
+	return nil
+}
+
+func rotateBadgerKey(dir string, oldKey []byte, enc config.EncryptionCfg) error {
+	opts := badger.KeyRegistryOptions{
+		Dir:                           dir,
+		ReadOnly:                      true,
+		EncryptionKey:                 oldKey,
+		EncryptionKeyRotationDuration: enc.DataKeyRotation,
+	}
+	registry, errOpen := badger.OpenKeyRegistry(opts)
+	if errOpen != nil {
+		return errorskit.Wrap(errOpen, "couldn't open badger key registry, is the old key correct?")
+	}
+
+	opts.EncryptionKey = enc.Key
+	errWrite := badger.WriteKeyRegistry(registry, opts)
+	if errWrite != nil {
+		return errorskit.Wrap(errWrite, "couldn't write badger key registry")
+	}
+	return nil
+}
+
+// rewriteLogs reads every consensus log and stores it again, which encrypts it with the current key.
+func rewriteLogs(path string, kind string, enc config.EncryptionCfg) (int, error) {
+	store, errStore := consensus.OpenLogStore(path, kind, enc, false)
+	if errStore != nil {
+		return 0, errStore
+	}
+	defer store.Close()
+
+	first, errFirst := store.FirstIndex()
+	if errFirst != nil {
+		return 0, errFirst
+	}
+	last, errLast := store.LastIndex()
+	if errLast != nil {
+		return 0, errLast
+	}
+
+	rewritten := 0
+	for i := first; i <= last && i > 0; i++ {
+		l := new(raft.Log)
+		errGet := store.GetLog(i, l)
+		if errGet != nil {
+			return rewritten, errorskit.Wrap(errGet, fmt.Sprintf("couldn't get log %v", i))
+		}
+		errStoreLog := store.StoreLog(l)
+		if errStoreLog != nil {
+			return rewritten, errorskit.Wrap(errStoreLog, fmt.Sprintf("couldn't store log %v", i))
+		}
+		rewritten++
+	}
+
+	return rewritten, nil
+}
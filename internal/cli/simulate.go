@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"nubedb/cluster/consensus/sim"
+	"strings"
+	"time"
+)
+
+// simulate runs an in-process cluster under the seeded simulator, running a failed simulation again with the same seed
+// injects the same events, though raft's timers can make the cluster react to them differently.
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	seed := fs.Int64("seed", 0, "seed of the simulation's events. If 0, a random seed is used")
+	nodes := fs.Int("nodes", 3, "number of nodes of the cluster")
+	steps := fs.Int("steps", 200, "number of steps to simulate")
+	step := fs.Duration("step", 50*time.Millisecond, "time between steps")
+	verbose := fs.Bool("trace", false, "print the trace of the events, it's always printed if the simulation fails")
+	_ = fs.Parse(args)
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	fmt.Printf("simulating %v nodes for %v steps, seed: %v\n", *nodes, *steps, *seed)
+
+	report, errRun := sim.Run(sim.Config{Seed: *seed, Nodes: *nodes, Steps: *steps, Step: *step})
+	if errRun != nil {
+		return errRun
+	}
+
+	if *verbose || len(report.Violations) > 0 {
+		fmt.Println(strings.Join(report.Trace, "\n"))
+	}
+	if len(report.Violations) > 0 {
+		return fmt.Errorf("simulation with seed %v failed:\n%s", report.Seed, strings.Join(report.Violations, "\n"))
+	}
+	fmt.Println("simulation passed")
+	return nil
+}
@@ -0,0 +1,304 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/narvikd/errorskit"
+	"hash/crc64"
+	"io"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/engine"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/internal/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const snapshotUsage = "usage: nubedb snapshot inspect [flags] <path>"
+
+// Kinds of files which can be inspected.
+const (
+	sourceRaftSnapshot = "raft snapshot"
+	sourceDataDir      = "badger data dir"
+	sourceBackup       = "backup"
+)
+
+// snapshotSource is a snapshot, data dir or backup being inspected.
+type snapshotSource struct {
+	kind string
+	// meta is the metadata of a raft snapshot, nil for the rest.
+	meta *raft.SnapshotMeta
+	// crcValid reports whether the raft snapshot's state matches the checksum of its metadata.
+	crcValid bool
+	values   map[string]json.RawMessage
+}
+
+// snapshot runs the snapshot subcommands, currently only inspect.
+func snapshot(args []string) error {
+	if len(args) < 1 || args[0] != "inspect" {
+		return errors.New(snapshotUsage)
+	}
+	return snapshotInspect(args[1:])
+}
+
+// snapshotInspect opens a raft snapshot, a stopped node's data dir or a backup without booting a node,
+// listing its metadata and the keys per prefix, or extracting a key.
+func snapshotInspect(args []string) error {
+	fs := flag.NewFlagSet("snapshot inspect", flag.ExitOnError)
+	key := fs.String("key", "", "prints the value of this key instead of the summary")
+	prefix := fs.String("prefix", "", "only counts the keys under this prefix")
+	separator := fs.String("separator", "/", "separator of the segments of the keys' prefixes")
+	depth := fs.Int("depth", 1, "number of segments of the prefixes the keys are counted by")
+	list := fs.Bool("list", false, "lists the keys after the summary")
+	_ = fs.Parse(args)
+	// The path can also be given before the flags.
+	path := fs.Arg(0)
+	if fs.NArg() > 1 {
+		_ = fs.Parse(fs.Args()[1:])
+	}
+	if path == "" {
+		return errors.New(snapshotUsage)
+	}
+	if *depth < 1 {
+		return errors.New("depth must be positive")
+	}
+
+	src, errLoad := loadSnapshotSource(path)
+	if errLoad != nil {
+		return errLoad
+	}
+
+	if *key != "" {
+		v, ok := src.values[*key]
+		if !ok {
+			return fmt.Errorf("key '%s' not found", *key)
+		}
+		_, errWrite := fmt.Fprintf(os.Stdout, "%s\n", v)
+		return errWrite
+	}
+
+	printSnapshotSummary(src, path, *prefix, *separator, *depth)
+	if *list {
+		for _, k := range sortedKeys(src.values, *prefix) {
+			fmt.Println(k)
+		}
+	}
+	return nil
+}
+
+// loadSnapshotSource detects the kind of the file at path and reads its key-values.
+func loadSnapshotSource(path string) (*snapshotSource, error) {
+	info, errStat := os.Stat(path)
+	if errStat != nil {
+		return nil, errStat
+	}
+	if !info.IsDir() {
+		return readBackupFile(path)
+	}
+	if fileExists(filepath.Join(path, "meta.json")) {
+		return readRaftSnapshot(path)
+	}
+	if fileExists(filepath.Join(path, "MANIFEST")) {
+		return readDataDir(path)
+	}
+
+	// A snapshots dir holds the retained snapshots, which must be inspected one by one.
+	snaps, _ := filepath.Glob(filepath.Join(path, "*", "meta.json"))
+	if len(snaps) > 0 {
+		names := make([]string, 0, len(snaps))
+		for _, s := range snaps {
+			names = append(names, filepath.Dir(s))
+		}
+		return nil, fmt.Errorf("'%s' contains the retained snapshots, inspect one of them:\n  %s",
+			path, strings.Join(names, "\n  "))
+	}
+	return nil, fmt.Errorf("'%s' isn't a raft snapshot, a badger data dir or a backup", path)
+}
+
+// readRaftSnapshot reads a snapshot of raft's file snapshot store, verifying its checksum.
+func readRaftSnapshot(dir string) (*snapshotSource, error) {
+	b, errMeta := os.ReadFile(filepath.Join(dir, "meta.json"))
+	if errMeta != nil {
+		return nil, errorskit.Wrap(errMeta, "couldn't read snapshot metadata")
+	}
+	var meta struct {
+		raft.SnapshotMeta
+		CRC []byte
+	}
+	errUnmarshal := json.Unmarshal(b, &meta)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't decode snapshot metadata")
+	}
+
+	state, errOpen := os.Open(filepath.Join(dir, "state.bin"))
+	if errOpen != nil {
+		return nil, errorskit.Wrap(errOpen, "couldn't open snapshot state")
+	}
+	defer state.Close()
+
+	// The state is hashed while it's decoded, as raft does, to verify it against the metadata.
+	hash := crc64.New(crc64.MakeTable(crc64.ECMA))
+	r := io.TeeReader(state, hash)
+	src := &snapshotSource{kind: sourceRaftSnapshot, meta: &meta.SnapshotMeta, values: make(map[string]json.RawMessage)}
+	d := json.NewDecoder(r)
+	for d.More() {
+		var raw json.RawMessage
+		errRaw := d.Decode(&raw)
+		if errRaw != nil {
+			return nil, errorskit.Wrap(errRaw, "couldn't decode snapshot state")
+		}
+		p, errDecode := fsm.DecodePayload(raw)
+		if errDecode != nil {
+			return nil, errorskit.Wrap(errDecode, "couldn't decode snapshot state")
+		}
+		v, errMarshal := json.Marshal(p.Value)
+		if errMarshal != nil {
+			return nil, errorskit.Wrap(errMarshal, "couldn't encode value of key "+p.Key)
+		}
+		src.values[p.Key] = v
+	}
+	_, errDrain := io.Copy(io.Discard, r)
+	if errDrain != nil {
+		return nil, errorskit.Wrap(errDrain, "couldn't read snapshot state")
+	}
+	src.crcValid = bytes.Equal(hash.Sum(nil), meta.CRC)
+	return src, nil
+}
+
+// readDataDir reads the FSM's badger DB of a stopped node, decrypting it with the configured encryption key.
+func readDataDir(dir string) (*snapshotSource, error) {
+	enc, errEnc := config.NewEncryptionCfg()
+	if errEnc != nil {
+		return nil, errEnc
+	}
+	db, errDB := consensus.OpenBadger(dir, enc)
+	if errDB != nil {
+		return nil, errorskit.Wrap(errDB, "is the node stopped?")
+	}
+	defer db.Close()
+
+	dbFSM, errFSM := fsm.New(engine.NewBadger(db), 0, 0)
+	if errFSM != nil {
+		return nil, errFSM
+	}
+	b, errBackup := dbFSM.BackupDB()
+	if errBackup != nil {
+		return nil, errBackup
+	}
+	src := &snapshotSource{kind: sourceDataDir}
+	errUnmarshal := json.Unmarshal(b, &src.values)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't decode data dir")
+	}
+	return src, nil
+}
+
+// readBackupFile reads a backup taken from store/backup or shipped to the object storage.
+func readBackupFile(path string) (*snapshotSource, error) {
+	b, errRead := os.ReadFile(path)
+	if errRead != nil {
+		return nil, errorskit.Wrap(errRead, "couldn't read backup file")
+	}
+	src := &snapshotSource{kind: sourceBackup}
+	errUnmarshal := json.Unmarshal(b, &src.values)
+	if errUnmarshal != nil {
+		return nil, errorskit.Wrap(errUnmarshal, "couldn't decode backup file, is it a backup?")
+	}
+	return src, nil
+}
+
+func printSnapshotSummary(src *snapshotSource, path string, prefix string, separator string, depth int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "source:\t%s\n", src.kind)
+	fmt.Fprintf(w, "path:\t%s\n", path)
+	if src.meta != nil {
+		fmt.Fprintf(w, "id:\t%s\n", src.meta.ID)
+		fmt.Fprintf(w, "index:\t%v\n", src.meta.Index)
+		fmt.Fprintf(w, "term:\t%v\n", src.meta.Term)
+		fmt.Fprintf(w, "size:\t%v bytes\n", src.meta.Size)
+		crc := "valid"
+		if !src.crcValid {
+			crc = "MISMATCH, the snapshot is corrupted"
+		}
+		fmt.Fprintf(w, "crc:\t%s\n", crc)
+		fmt.Fprintf(w, "configuration index:\t%v\n", src.meta.ConfigurationIndex)
+		for _, srv := range src.meta.Configuration.Servers {
+			fmt.Fprintf(w, "server:\t%s %s %s\n", srv.ID, srv.Address, srv.Suffrage)
+		}
+	}
+
+	type prefixCount struct {
+		keys  int
+		bytes int
+	}
+	counts := make(map[string]*prefixCount)
+	keys := sortedKeys(src.values, prefix)
+	total := 0
+	for _, k := range keys {
+		group := keyPrefix(k, separator, depth)
+		if counts[group] == nil {
+			counts[group] = new(prefixCount)
+		}
+		counts[group].keys++
+		counts[group].bytes += len(src.values[k])
+		total += len(src.values[k])
+	}
+	fmt.Fprintf(w, "keys:\t%v (%v bytes of values)\n", len(keys), total)
+	if len(keys) <= 0 {
+		return
+	}
+
+	groups := make([]string, 0, len(counts))
+	for g := range counts {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	fmt.Fprintln(w, "\nPREFIX\tKEYS\tBYTES")
+	for _, g := range groups {
+		name := g
+		if name == "" {
+			name = "(no prefix)"
+		}
+		fmt.Fprintf(w, "%s\t%v\t%v\n", name, counts[g].keys, counts[g].bytes)
+	}
+}
+
+// keyPrefix returns the first depth segments of a key, or less if it hasn't as many, ending with the separator.
+// Keys without any separator have an empty prefix.
+func keyPrefix(key string, separator string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		idx := strings.Index(key[end:], separator)
+		if idx < 0 {
+			break
+		}
+		end += idx + len(separator)
+	}
+	return key[:end]
+}
+
+// sortedKeys returns the keys under prefix, sorted.
+func sortedKeys(values map[string]json.RawMessage, prefix string) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"github.com/narvikd/errorskit"
+	"github.com/narvikd/filekit"
+	"io"
+	"log"
+	"nubedb/cluster"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/consensus/fsm"
+	"nubedb/cluster/peerauth"
+	"nubedb/internal/config"
+	"os"
+	"strconv"
+)
+
+// standby seeds the data dir of a new node with a copy of a healthy peer's data, pulled over gRPC.
+//
+// It runs before the node is started for the first time, so when it joins the cluster,
+// it only has to catch up with the logs applied after the copy, instead of receiving all the data from the leader.
+func standby(args []string) error {
+	fs := flag.NewFlagSet("standby", flag.ExitOnError)
+	nodeID := fs.String("node", defaultNodeID(), "ID of the new node whose data dir will be seeded")
+	peerID := fs.String("peer", "", "ID of the healthy node the data is copied from (required)")
+	_ = fs.Parse(args)
+
+	if *peerID == "" {
+		fs.Usage()
+		return errors.New("--peer is required")
+	}
+
+	clusterCfg := config.NewClusterCfg()
+	peerauth.Configure(clusterCfg.Secret, clusterCfg.JoinToken)
+
+	enc, errEnc := config.NewEncryptionCfg()
+	if errEnc != nil {
+		return errEnc
+	}
+	storageCfg := config.NewStorageCfg()
+	consensusCfg := config.NewConsensusCfg()
+	paths := consensus.NodePaths(*nodeID, storageCfg, consensusCfg)
+	if _, errStat := os.Stat(paths.LogStore(consensusCfg.LogStore)); errStat == nil {
+		return errors.New("the node already has consensus logs, a standby can only be seeded before its first start")
+	}
+
+	peerAddr := config.MakeGrpcAddress(*peerID)
+	for id := 0; id < config.NewShardingCfg().Shards; id++ {
+		p := paths
+		if id > 0 {
+			p = paths.Shard(id)
+		}
+		index, errPull := pullShard(id, *nodeID, peerAddr, p.Storage, storageCfg, enc)
+		if errPull != nil {
+			return errorskit.Wrap(errPull, "shard "+strconv.Itoa(id))
+		}
+		log.Printf("[standby] shard %v seeded from %s, at index %v\n", id, *peerID, index)
+	}
+
+	return nil
+}
+
+// pullShard restores the copy of a shard pulled from a peer into the storage dir,
+// returning the index the peer had applied when the copy started.
+func pullShard(id int, nodeID string, peerAddr string, dir string, storageCfg config.StorageCfg, enc config.EncryptionCfg) (uint64, error) {
+	errDir := filekit.CreateDirs(dir, false)
+	if errDir != nil {
+		return 0, errDir
+	}
+	e, errEngine := consensus.OpenEngine(dir, storageCfg, enc)
+	if errEngine != nil {
+		return 0, errEngine
+	}
+	defer e.Close()
+	dbFSM, errFSM := fsm.New(e, 0, 0)
+	if errFSM != nil {
+		return 0, errFSM
+	}
+
+	// The copy is restored while it's received, so it's never kept whole on disk or in memory.
+	pr, pw := io.Pipe()
+	restored := make(chan error, 1)
+	go func() {
+		errRestore := dbFSM.RestoreCopy(pr)
+		// Unblocks the pull if the restore stops before reading the whole copy.
+		_ = pr.CloseWithError(errRestore)
+		restored <- errRestore
+	}()
+
+	index, errPull := cluster.PullBackup(id, nodeID, peerAddr, pw)
+	_ = pw.CloseWithError(errPull)
+	errRestore := <-restored
+	if errPull != nil {
+		return 0, errPull
+	}
+	if errRestore != nil {
+		return 0, errorskit.Wrap(errRestore, "couldn't restore the copy")
+	}
+	return index, nil
+}
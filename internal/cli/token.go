@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"nubedb/cluster/peerauth"
+	"nubedb/internal/config"
+	"time"
+)
+
+// token creates the join tokens new nodes present to join the cluster, so they don't need the cluster secret.
+func token(args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New("usage: nubedb token create [--ttl=1h] [--node=<id>]")
+	}
+	fs := flag.NewFlagSet("token create", flag.ExitOnError)
+	ttl := fs.Duration("ttl", time.Hour, "how long the token can be used to join")
+	nodeID := fs.String("node", "", "ID of the only node which can join with the token. Any node can if it's empty")
+	_ = fs.Parse(args[1:])
+
+	joinToken, errToken := peerauth.CreateJoinToken(config.NewClusterCfg().Secret, *nodeID, *ttl)
+	if errToken != nil {
+		return errToken
+	}
+	fmt.Println(joinToken)
+	return nil
+}
@@ -1,10 +1,15 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"github.com/narvikd/errorskit"
+	"net"
+	"nubedb/pkg/objectstore"
 	"nubedb/pkg/resolver"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,59 +17,794 @@ const (
 	ApiPort       = 3001
 	ConsensusPort = 3002
 	GrpcPort      = 3003
+	// DiscoveryPort is the port a node answers its identity on, when the nodes are found in the cloud.
+	DiscoveryPort = 3004
+	// ShardConsensusPortBase is the base port of the consensus of the shards, shard N listens on base+N.
+	ShardConsensusPortBase = 3100
 )
 
 type NodeCfg struct {
-	ID               string
+	// ID names the node in the cluster, it's the hostname unless it's configured.
+	ID string
+	// AdvertiseHost is the host the other nodes reach this node at: its advertise IP, or its advertise hostname,
+	// which is its ID unless they are configured.
+	AdvertiseHost string
+	// Interface is the network interface the node is discovered on and advertises its IP of, all of them if it's empty.
+	Interface        string
 	ApiPort          int
 	ApiAddress       string
 	ConsensusPort    int
 	ConsensusAddress string
 	GrpcPort         int
 	GrpcAddress      string
+	// Witness makes the node only vote in the consensus, without storing data nor serving reads.
+	Witness bool
+	// ReadOnly makes the node refuse the writes it receives, while it keeps serving reads.
+	ReadOnly bool
+	// SystemdNotify notifies systemd when the node is ready and pings its watchdog, if it's run by it as a service of Type=notify.
+	SystemdNotify bool
+}
+
+// CDCCfg configures the change data capture publisher.
+type CDCCfg struct {
+	// Sink is where the changes are published to, it can be "nats", "kafka", or empty to disable CDC.
+	Sink string
+	// Address is the address of the sink, ex: nats://localhost:4222 or localhost:9092
+	Address string
+	// Topic is the NATS subject or the Kafka topic the changes are published to.
+	Topic string
+	// Interval is how often the publisher checks for new changes.
+	Interval time.Duration
+	// BatchSize is the maximum number of changes published at once.
+	BatchSize int
+}
+
+// WebhookCfg configures the delivery of the changes to the registered webhooks.
+type WebhookCfg struct {
+	// Interval is how often the leader checks for new changes to deliver.
+	Interval time.Duration
+	// BatchSize is the maximum number of changes delivered at once.
+	BatchSize int
+	// MaxAttempts is the number of times a change is sent to a webhook before it's dropped.
+	MaxAttempts int
+	// Timeout is the maximum duration of a request to a webhook.
+	Timeout time.Duration
+}
+
+// AntiEntropyCfg configures the background verification of the replicas' data.
+type AntiEntropyCfg struct {
+	// Interval is how often the leader of each shard compares the digests of its replicas. 0 disables it.
+	Interval time.Duration
+	// Timeout is how long the replicas have to compute their digests.
+	Timeout time.Duration
+	// Repair reinstalls the replicas whose data diverged from the majority, so they are rebuilt from a snapshot.
+	Repair bool
+}
+
+// AlertCfg configures the alerts fired when the cluster stays without a leader or without quorum.
+type AlertCfg struct {
+	// NoLeaderAfter is how long a shard must be without a leader to fire an alert. 0 disables it.
+	NoLeaderAfter time.Duration
+	// QuorumLossAfter is how long the quorum must be impossible to fire an alert. 0 disables it.
+	QuorumLossAfter time.Duration
+	// WebhookURL receives the alerts as JSON POST requests, empty disables it.
+	WebhookURL string
+	// Exec is the path of a script run with every alert, empty disables it.
+	Exec string
+	// Timeout is the maximum duration of the webhook request and the script.
+	Timeout time.Duration
+}
+
+// ReplicationCfg configures the asynchronous replication of this cluster's changes into another cluster.
+type ReplicationCfg struct {
+	// Target is the gRPC address of a node of the target cluster. Replication is disabled if it's empty.
+	Target string
+	// SourceID identifies this cluster on the target, it must be unique between all the clusters replicating into it.
+	SourceID string
+	// Interval is how often the agent checks for new changes.
+	Interval time.Duration
+	// BatchSize is the maximum number of changes sent at once.
+	BatchSize int
+}
+
+// BackupCfg configures the shipping of backups to an object storage.
+type BackupCfg struct {
+	// Store defines the object storage. Shipping is disabled if its provider is empty.
+	Store objectstore.Options
+	// Prefix is prepended to the name of every backup, it can be used to store the backups of multiple clusters.
+	Prefix string
+	// Interval is how often a backup is shipped.
+	Interval time.Duration
+	// Retain is the number of backups kept in the object storage, the oldest ones are deleted.
+	Retain int
+	// RestoreOnBoot restores the latest backup from the object storage, if the node starts without data.
+	RestoreOnBoot bool
+}
+
+// WriteRetryCfg configures how the writes which fail during a leader election are retried.
+type WriteRetryCfg struct {
+	// MaxAttempts is the maximum number of times a write is tried, 1 disables the retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it's doubled on every retry and randomized.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay between retries, it's also sent to the clients as Retry-After.
+	MaxDelay time.Duration
+}
+
+// HandoffCfg configures the buffering of writes while the cluster doesn't have a leader.
+type HandoffCfg struct {
+	// Enabled buffers the writes which failed due to a leader election, instead of answering them with a 503.
+	Enabled bool
+	// MaxWrites is the maximum number of writes buffered on the node.
+	MaxWrites int
+	// TTL is how long a buffered write is kept before being discarded.
+	TTL time.Duration
+	// Interval is how often the node tries to flush the buffered writes.
+	Interval time.Duration
+}
+
+// IntegrityCfg configures the checks of the node's stores done on boot.
+type IntegrityCfg struct {
+	// VerifyChecksums verifies the checksums of all the FSM's data, instead of only checking it opens cleanly.
+	VerifyChecksums bool
+	// SelfHeal quarantines the node's data if it's corrupted, so it rejoins the cluster as a fresh node,
+	// instead of failing to start. It needs the latest backup restored on boot, check IntegrityCfg.validate.
+	SelfHeal bool
+}
+
+// CoalescingCfg configures the merging of the writes to the same key on the leader.
+type CoalescingCfg struct {
+	// Window is how long the leader waits for more writes to the same key before applying one. 0 disables it.
+	Window time.Duration
+}
+
+// ClusterCfg configures how the nodes form the cluster.
+type ClusterCfg struct {
+	// Secret is shared by the nodes, which must prove they know it to join or remove nodes. Empty disables it.
+	Secret string
+	// JoinToken lets a node which doesn't know the secret join the cluster, it's created with "nubedb token create".
+	JoinToken string
+	// BootstrapExpect is the number of nodes a new cluster waits for to bootstrap with all of them.
+	// 0 bootstraps it with the node named bootstrap-node alone.
+	BootstrapExpect int
+	// SingleNode runs the node as the only one of its cluster, without discovering nor joining other nodes.
+	SingleNode bool
+	// CloudJoin finds the nodes by the tags of their cloud instances instead of mDNS, check the discover/cloud package.
+	CloudJoin string
+}
+
+// ShardingCfg configures the partitioning of the keyspace across several consensus groups.
+type ShardingCfg struct {
+	// Shards is the number of consensus groups the keyspace is partitioned across, 1 disables sharding.
+	// It must be the same on every node.
+	Shards int
+}
+
+// ChaosCfg configures the fault injection, meant to rehearse failure modes in staging clusters.
+type ChaosCfg struct {
+	// Enabled exposes the endpoints which inject faults into the node.
+	Enabled bool
+}
+
+// ReadsCfg configures how reads are served.
+type ReadsCfg struct {
+	// MaxApplyLag is the maximum number of committed logs the node's FSM can be behind to serve reads,
+	// reads are refused with a 503 when it's exceeded. 0 disables the check.
+	MaxApplyLag uint64
+	// MinIndexTimeout is how long a read with X-Min-Index waits for the node to apply it before it's refused with a 503.
+	MinIndexTimeout time.Duration
+	// ReadyMaxApplyLag is the maximum number of committed logs the node's FSM can be behind to be reported as ready.
+	// 0 disables the check.
+	ReadyMaxApplyLag uint64
+}
+
+// MetricsCfg configures the instrumentation of the node.
+type MetricsCfg struct {
+	// SlowOpThreshold is the duration from which operations are logged as slow. 0 disables the log.
+	SlowOpThreshold time.Duration
+	// HotKeysSampleEvery samples 1 of every N key accesses to detect the hot keys. 0 disables the detection.
+	HotKeysSampleEvery int
+	// AccessLogSampleEvery logs 1 of every N requests served by the APIs. 0 disables the access log.
+	AccessLogSampleEvery int
+	// AccessLogErrors logs every failed request, besides the sampled ones.
+	AccessLogErrors bool
+}
+
+// RestCfg configures the REST server.
+type RestCfg struct {
+	// CORSAllowOrigins is a comma separated list of the origins allowed to call the API from a browser.
+	CORSAllowOrigins string
+	// CORSAllowHeaders is a comma separated list of the headers allowed in the browser requests.
+	CORSAllowHeaders string
+	// CORSAllowCredentials allows browser requests to include credentials, like cookies.
+	CORSAllowCredentials bool
+	// BodyLimit is the maximum size of a request body in bytes, bigger requests are rejected, but for the streamed values.
+	BodyLimit int
+	// ReadTimeout is the maximum duration for reading a request.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration for writing a response.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum time to wait for the next request on a keep-alive connection.
+	IdleTimeout time.Duration
+	// TLSCertFile and TLSKeyFile are the paths of the certificate and key used to serve HTTPS.
+	TLSCertFile string
+	TLSKeyFile  string
+	// AutocertDomains is a comma separated list of the domains to get certificates for from Let's Encrypt,
+	// it's only used if TLSCertFile and TLSKeyFile aren't set.
+	AutocertDomains string
+	// AutocertCacheDir is where the certificates obtained from Let's Encrypt are stored.
+	AutocertCacheDir string
+	// AutocertEmail is the contact email sent to Let's Encrypt.
+	AutocertEmail string
+	// TLSPeerCAFile is the path of the CA the certificates of the other nodes are verified with,
+	// when the API is served with TLS. The system's CAs are used if it's empty.
+	TLSPeerCAFile string
+	// UnixSocket is the path of a unix socket the API is also served on, without TLS. Empty disables it.
+	UnixSocket string
+	// ReadDeadline, WriteDeadline and AdminDeadline are how long the reads, the writes and the administrative requests
+	// can take before they're answered with a 504. 0 disables the deadline.
+	ReadDeadline  time.Duration
+	WriteDeadline time.Duration
+	AdminDeadline time.Duration
+	// PageTokenSecret is the secret the page tokens are signed with, it must be the same on every node.
+	// If it's empty, a random one is used, and the tokens only work on the node which issued them.
+	PageTokenSecret string
+	// DebugToken is the bearer token the runtime diagnostics under /admin/debug require. Empty doesn't expose them.
+	DebugToken string
+}
+
+// GrpcCfg configures the gRPC server.
+type GrpcCfg struct {
+	// UnixSocket is the path of a unix socket the gRPC server also listens on. Empty disables it.
+	UnixSocket string
+	// WriteStreamConcurrency is the maximum number of writes of a Write stream applied at once.
+	WriteStreamConcurrency int
+}
+
+// SoftDeleteCfg configures the soft delete mode, where deleted values are retained so they can be undeleted.
+type SoftDeleteCfg struct {
+	// Retention is how long deleted values are retained. 0 disables soft delete.
+	Retention time.Duration
+	// PurgeInterval is how often the expired deleted values are purged.
+	PurgeInterval time.Duration
+}
+
+// StorageCfg configures where the FSM stores its data.
+type StorageCfg struct {
+	// Engine is the storage engine of the FSM: badger, pebble, sqlite or memory.
+	//
+	// memory is meant for tests and ephemeral nodes: its transactions don't have snapshot isolation,
+	// they read the latest committed data, and its iterations scan the whole map, copying the matching keys.
+	Engine string
+	// Dir is the dir the FSM's data of the nodes is stored in, if it isn't in their main dir.
+	Dir string
+	// ReadCacheSize is the size in bytes of the in-memory cache of the read values. 0 disables it.
+	ReadCacheSize int64
+	// BloomKeys is the number of keys the keys' bloom filter is sized for, it grows past it. 0 disables it.
+	BloomKeys uint64
+	// ChunkSize is the size in bytes from which the values are split in chunks. 0 disables it.
+	ChunkSize int
+	// MinFreeBytes is the free space under the data dir below which the node becomes read-only. 0 disables it.
+	MinFreeBytes uint64
+	// DiskCheckInterval is how often the free space under the data dir is checked.
+	DiskCheckInterval time.Duration
+}
+
+// ConsensusCfg configures where the consensus stores its logs.
+type ConsensusCfg struct {
+	// LogStore is the store of the consensus logs: bolt or badger. Memory is refused, it's only meant for tests.
+	LogStore string
+	// LogDir is the dir the consensus logs of the nodes are stored in, if they aren't in their main dir.
+	LogDir string
+	// SnapshotDir is the dir the snapshots of the nodes are stored in, if they aren't in their main dir.
+	SnapshotDir string
+	// PayloadEncoding is the encoding of the payloads written to the consensus logs: json or msgpack.
+	PayloadEncoding string
+	// LogLevel is the level of the consensus logs: trace, debug, info, warn or error.
+	LogLevel string
+	// SnapshotInterval is how often the consensus checks whether to take a snapshot.
+	SnapshotInterval time.Duration
+	// SnapshotThreshold is the number of logs committed since the last snapshot from which a snapshot is taken.
+	SnapshotThreshold uint64
+	// TrailingLogs is the number of logs kept after a snapshot, so lagging followers can catch up without the snapshot.
+	TrailingLogs uint64
 }
 
 type Config struct {
 	CurrentNode NodeCfg
+	Storage     StorageCfg
+	Consensus   ConsensusCfg
+	SoftDelete  SoftDeleteCfg
+	Rest        RestCfg
+	Grpc        GrpcCfg
+	Metrics     MetricsCfg
+	Reads       ReadsCfg
+	Integrity   IntegrityCfg
+	WriteRetry  WriteRetryCfg
+	Handoff     HandoffCfg
+	CDC         CDCCfg
+	Webhook     WebhookCfg
+	AntiEntropy AntiEntropyCfg
+	Alert       AlertCfg
+	Replication ReplicationCfg
+	Backup      BackupCfg
+	Encryption  EncryptionCfg
+	Chaos       ChaosCfg
+	Coalescing  CoalescingCfg
+	Sharding    ShardingCfg
+	Cluster     ClusterCfg
 }
 
+// New reads the configuration from the environment and the configuration file.
+//
+// It can be called again to reload it, the file is read again.
 func New() (Config, error) {
 	const resolverTimeout = 300 * time.Millisecond
+	errFile := loadFile()
+	if errFile != nil {
+		return Config{}, errFile
+	}
+	nodeID, errNodeID := DefaultNodeID()
+	if errNodeID != nil {
+		return Config{}, errNodeID
+	}
+	advertiseHost, errAdvertise := advertiseHost(nodeID)
+	if errAdvertise != nil {
+		return Config{}, errAdvertise
+	}
+
+	if !resolver.IsHostAlive(advertiseHost, resolverTimeout) {
+		return Config{}, fmt.Errorf("no host found for: %s", advertiseHost)
+	}
+	encryptionCfg, errEncryption := NewEncryptionCfg()
+	if errEncryption != nil {
+		return Config{}, errEncryption
+	}
+
+	cfg := Config{
+		CurrentNode: NewNodeCfg(nodeID, advertiseHost),
+		Storage:     NewStorageCfg(),
+		Consensus:   NewConsensusCfg(),
+		SoftDelete:  newSoftDeleteCfg(),
+		Rest:        newRestCfg(),
+		Grpc:        newGrpcCfg(),
+		Metrics:     newMetricsCfg(),
+		Reads:       newReadsCfg(),
+		Integrity:   newIntegrityCfg(),
+		WriteRetry:  newWriteRetryCfg(),
+		Handoff:     newHandoffCfg(),
+		CDC:         newCDCCfg(),
+		Webhook:     newWebhookCfg(),
+		AntiEntropy: newAntiEntropyCfg(),
+		Alert:       newAlertCfg(),
+		Replication: newReplicationCfg(),
+		Backup:      newBackupCfg(),
+		Encryption:  encryptionCfg,
+		Chaos:       newChaosCfg(),
+		Coalescing:  newCoalescingCfg(),
+		Sharding:    NewShardingCfg(),
+		Cluster:     NewClusterCfg(),
+	}
+	errSharding := cfg.Sharding.validate(cfg)
+	if errSharding != nil {
+		return Config{}, errSharding
+	}
+	errCluster := cfg.Cluster.validate()
+	if errCluster != nil {
+		return Config{}, errCluster
+	}
+	errConsensus := cfg.Consensus.validate()
+	if errConsensus != nil {
+		return Config{}, errConsensus
+	}
+	errIntervals := validateIntervals(cfg)
+	if errIntervals != nil {
+		return Config{}, errIntervals
+	}
+	errIntegrity := cfg.Integrity.validate(cfg.Backup)
+	if errIntegrity != nil {
+		return Config{}, errIntegrity
+	}
+	return cfg, nil
+}
+
+// validateIntervals returns an error if an interval the node runs a task on isn't positive,
+// since a ticker can't be created with it.
+func validateIntervals(cfg Config) error {
+	intervals := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"CDC_INTERVAL", cfg.CDC.Interval},
+		{"WEBHOOK_INTERVAL", cfg.Webhook.Interval},
+		{"REPLICATION_INTERVAL", cfg.Replication.Interval},
+		{"BACKUP_INTERVAL", cfg.Backup.Interval},
+		{"HANDOFF_INTERVAL", cfg.Handoff.Interval},
+		{"SOFT_DELETE_PURGE_INTERVAL", cfg.SoftDelete.PurgeInterval},
+	}
+	for _, i := range intervals {
+		if i.interval <= 0 {
+			return fmt.Errorf("%s%s must be positive: %v", envPrefix, i.name, i.interval)
+		}
+	}
+	return nil
+}
+
+func newCDCCfg() CDCCfg {
+	return CDCCfg{
+		Sink:      getEnv("CDC_SINK", ""),
+		Address:   getEnv("CDC_ADDRESS", ""),
+		Topic:     getEnv("CDC_TOPIC", "nubedb.changes"),
+		Interval:  getEnvDuration("CDC_INTERVAL", 1*time.Second),
+		BatchSize: getEnvInt("CDC_BATCH_SIZE", 100),
+	}
+}
+
+func newWebhookCfg() WebhookCfg {
+	return WebhookCfg{
+		Interval:    getEnvDuration("WEBHOOK_INTERVAL", 1*time.Second),
+		BatchSize:   getEnvInt("WEBHOOK_BATCH_SIZE", 100),
+		MaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		Timeout:     getEnvDuration("WEBHOOK_TIMEOUT", 5*time.Second),
+	}
+}
+
+func newAntiEntropyCfg() AntiEntropyCfg {
+	return AntiEntropyCfg{
+		Interval: getEnvDuration("ANTIENTROPY_INTERVAL", 1*time.Hour),
+		Timeout:  getEnvDuration("ANTIENTROPY_TIMEOUT", 1*time.Minute),
+		Repair:   getEnvBool("ANTIENTROPY_REPAIR", false),
+	}
+}
+
+func newAlertCfg() AlertCfg {
+	return AlertCfg{
+		NoLeaderAfter:   getEnvDuration("ALERT_NO_LEADER_AFTER", 30*time.Second),
+		QuorumLossAfter: getEnvDuration("ALERT_QUORUM_LOSS_AFTER", 30*time.Second),
+		WebhookURL:      getEnv("ALERT_WEBHOOK_URL", ""),
+		Exec:            getEnv("ALERT_EXEC", ""),
+		Timeout:         getEnvDuration("ALERT_TIMEOUT", 10*time.Second),
+	}
+}
+
+// DefaultNodeID returns the ID of the node: NUBEDB_NODE_ID, or the hostname if it isn't set.
+func DefaultNodeID() (string, error) {
+	if nodeID := getEnv("NODE_ID", ""); nodeID != "" {
+		return nodeID, nil
+	}
 	hostname, errHostname := os.Hostname()
 	if errHostname != nil {
-		return Config{}, errorskit.Wrap(errHostname, "couldn't get hostname on Config generation")
+		return "", errorskit.Wrap(errHostname, "couldn't get hostname on Config generation")
 	}
+	return hostname, nil
+}
 
-	if !resolver.IsHostAlive(hostname, resolverTimeout) {
-		return Config{}, fmt.Errorf("no host found for: %s", hostname)
+// advertiseHost returns the host the other nodes reach a node at: NUBEDB_ADVERTISE_IP,
+// the IP of the node in NUBEDB_NETWORK_INTERFACE and NUBEDB_ADVERTISE_SUBNET if any of them is set,
+// or NUBEDB_ADVERTISE_HOSTNAME, which is the node's ID if it isn't set.
+func advertiseHost(nodeID string) (string, error) {
+	if ip := unbracket(getEnv("ADVERTISE_IP", "")); ip != "" {
+		if net.ParseIP(ip) == nil {
+			return "", fmt.Errorf("advertise IP '%s' isn't an IP", ip)
+		}
+		return ip, nil
+	}
+	iface, subnet := getEnv("NETWORK_INTERFACE", ""), getEnv("ADVERTISE_SUBNET", "")
+	if iface != "" || subnet != "" {
+		return interfaceIP(iface, subnet)
 	}
-	return Config{CurrentNode: NewNodeCfg(hostname)}, nil
+	return unbracket(getEnv("ADVERTISE_HOSTNAME", nodeID)), nil
 }
 
-func NewNodeCfg(nodeID string) NodeCfg {
+// interfaceIP returns the first IP of a network interface in a subnet, on multi-homed hosts it selects the network
+// the node is reached at. An empty interface matches all of them, and an empty subnet all their IPs.
+func interfaceIP(iface string, subnet string) (string, error) {
+	var ipNet *net.IPNet
+	if subnet != "" {
+		_, parsed, errParse := net.ParseCIDR(subnet)
+		if errParse != nil {
+			return "", errorskit.Wrap(errParse, "couldn't parse advertise subnet")
+		}
+		ipNet = parsed
+	}
+
+	var addrs []net.Addr
+	var errAddrs error
+	if iface != "" {
+		netIface, errIface := net.InterfaceByName(iface)
+		if errIface != nil {
+			return "", errorskit.Wrap(errIface, "couldn't find network interface")
+		}
+		addrs, errAddrs = netIface.Addrs()
+	} else {
+		addrs, errAddrs = net.InterfaceAddrs()
+	}
+	if errAddrs != nil {
+		return "", errorskit.Wrap(errAddrs, "couldn't get network interface addresses")
+	}
+
+	for _, addr := range addrs {
+		ifaceNet, ok := addr.(*net.IPNet)
+		if !ok || ifaceNet.IP.IsLinkLocalUnicast() || (iface == "" && ipNet == nil && ifaceNet.IP.IsLoopback()) {
+			continue
+		}
+		if ipNet == nil || ipNet.Contains(ifaceNet.IP) {
+			return ifaceNet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IP found in network interface '%s' and subnet '%s'", iface, subnet)
+}
+
+// unbracket removes the brackets of an IPv6 literal, like [::1], since they are only part of the addresses.
+func unbracket(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// NewNodeCfg returns the configuration of a node, reached by the other nodes at advertiseHost.
+func NewNodeCfg(nodeID string, advertiseHost string) NodeCfg {
+	RegisterNodeHost(nodeID, advertiseHost)
 	return NodeCfg{
 		ID:               nodeID,
+		AdvertiseHost:    advertiseHost,
+		Interface:        getEnv("NETWORK_INTERFACE", ""),
 		ApiPort:          ApiPort,
 		ApiAddress:       MakeApiAddr(nodeID),
 		ConsensusPort:    ConsensusPort,
 		ConsensusAddress: MakeConsensusAddr(nodeID),
 		GrpcPort:         GrpcPort,
 		GrpcAddress:      MakeGrpcAddress(nodeID),
+		Witness:          getEnvBool("WITNESS", false),
+		ReadOnly:         getEnvBool("READ_ONLY", false),
+		SystemdNotify:    getEnvBool("SYSTEMD_NOTIFY", true),
 	}
 }
 
 func MakeApiAddr(nodeID string) string {
-	return makeAddr(nodeID, ApiPort)
+	return makeAddr(NodeHost(nodeID), ApiPort)
 }
 
 func MakeConsensusAddr(nodeID string) string {
-	return makeAddr(nodeID, ConsensusPort)
+	return makeAddr(NodeHost(nodeID), ConsensusPort)
 }
 
 func MakeGrpcAddress(nodeID string) string {
-	return makeAddr(nodeID, GrpcPort)
+	return makeAddr(NodeHost(nodeID), GrpcPort)
+}
+
+// MakeShardConsensusAddr returns the consensus address of a node in a shard, shard 0 uses the node's main one.
+func MakeShardConsensusAddr(nodeID string, shard int) string {
+	if shard == 0 {
+		return MakeConsensusAddr(nodeID)
+	}
+	return makeAddr(NodeHost(nodeID), ShardConsensusPortBase+shard)
 }
 
+// makeAddr returns the address of a port of a host, IPv6 hosts are bracketed.
 func makeAddr(host string, port int) string {
-	return fmt.Sprintf("%s:%v", host, port)
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func newReplicationCfg() ReplicationCfg {
+	return ReplicationCfg{
+		Target:    getEnv("REPLICATION_TARGET", ""),
+		SourceID:  getEnv("REPLICATION_SOURCE_ID", "nubedb"),
+		Interval:  getEnvDuration("REPLICATION_INTERVAL", 1*time.Second),
+		BatchSize: getEnvInt("REPLICATION_BATCH_SIZE", 100),
+	}
+}
+
+func newBackupCfg() BackupCfg {
+	return BackupCfg{
+		Store: objectstore.Options{
+			Provider:  getEnv("BACKUP_PROVIDER", ""),
+			Bucket:    getEnv("BACKUP_BUCKET", ""),
+			Endpoint:  getEnv("BACKUP_ENDPOINT", ""),
+			Region:    getEnv("BACKUP_REGION", ""),
+			AccessKey: getSecretEnv("BACKUP_ACCESS_KEY"),
+			SecretKey: getSecretEnv("BACKUP_SECRET_KEY"),
+			Insecure:  getEnvBool("BACKUP_INSECURE", false),
+		},
+		Prefix:        getEnv("BACKUP_PREFIX", "nubedb/"),
+		Interval:      getEnvDuration("BACKUP_INTERVAL", 1*time.Hour),
+		Retain:        getEnvInt("BACKUP_RETAIN", 24),
+		RestoreOnBoot: getEnvBool("BACKUP_RESTORE_ON_BOOT", false),
+	}
+}
+
+func newWriteRetryCfg() WriteRetryCfg {
+	return WriteRetryCfg{
+		MaxAttempts: getEnvInt("WRITE_RETRY_MAX_ATTEMPTS", 5),
+		BaseDelay:   getEnvDuration("WRITE_RETRY_BASE_DELAY", 100*time.Millisecond),
+		MaxDelay:    getEnvDuration("WRITE_RETRY_MAX_DELAY", 2*time.Second),
+	}
+}
+
+func newHandoffCfg() HandoffCfg {
+	return HandoffCfg{
+		Enabled:   getEnvBool("HANDOFF_ENABLED", false),
+		MaxWrites: getEnvInt("HANDOFF_MAX_WRITES", 10000),
+		TTL:       getEnvDuration("HANDOFF_TTL", 1*time.Minute),
+		Interval:  getEnvDuration("HANDOFF_INTERVAL", 500*time.Millisecond),
+	}
+}
+
+func newIntegrityCfg() IntegrityCfg {
+	return IntegrityCfg{
+		VerifyChecksums: getEnvBool("INTEGRITY_VERIFY_CHECKSUMS", false),
+		SelfHeal:        getEnvBool("SELF_HEAL", false),
+	}
+}
+
+// validate returns an error if self healing is enabled without restoring the latest backup on boot.
+//
+// The consensus' snapshots are empty, since the data is already in the storage engine, and the leader only keeps
+// the logs after its last snapshot, so a node which rejoins without data can only get the older keys from a backup.
+func (c IntegrityCfg) validate(backup BackupCfg) error {
+	if c.SelfHeal && (!backup.RestoreOnBoot || backup.Store.Provider == "") {
+		return errors.New("self healing needs the latest backup to be restored on boot, " +
+			"set NUBEDB_BACKUP_PROVIDER and NUBEDB_BACKUP_RESTORE_ON_BOOT")
+	}
+	return nil
+}
+
+func newCoalescingCfg() CoalescingCfg {
+	return CoalescingCfg{
+		Window: getEnvDuration("WRITE_COALESCE_WINDOW", 0),
+	}
+}
+
+// NewClusterCfg returns the configuration of how the nodes form the cluster.
+func NewClusterCfg() ClusterCfg {
+	return ClusterCfg{
+		Secret:          getSecretEnv("CLUSTER_SECRET"),
+		JoinToken:       getSecretEnv("JOIN_TOKEN"),
+		BootstrapExpect: getEnvInt("BOOTSTRAP_EXPECT", 0),
+		SingleNode:      getEnvBool("SINGLE_NODE", false),
+		CloudJoin:       getEnv("CLOUD_JOIN", ""),
+	}
+}
+
+// validate returns an error if the node is configured to form the cluster in more than one way.
+func (c ClusterCfg) validate() error {
+	if c.BootstrapExpect < 0 {
+		return fmt.Errorf("the number of nodes to bootstrap with can't be negative: %v", c.BootstrapExpect)
+	}
+	if c.SingleNode && (c.BootstrapExpect > 1 || c.JoinToken != "" || c.CloudJoin != "") {
+		return errors.New("a single node can't wait for other nodes to bootstrap, nor join a cluster")
+	}
+	return nil
+}
+
+// NewShardingCfg returns the sharding configuration, it's exported for the offline tools which read every shard.
+func NewShardingCfg() ShardingCfg {
+	return ShardingCfg{
+		Shards: getEnvInt("SHARDS", 1),
+	}
+}
+
+// validate returns an error if sharding is enabled with a feature which only reads the data of shard 0.
+func (c ShardingCfg) validate(cfg Config) error {
+	if c.Shards < 1 {
+		return fmt.Errorf("the number of shards must be at least 1: %v", c.Shards)
+	}
+	if c.Shards == 1 {
+		return nil
+	}
+	if cfg.CDC.Sink != "" || cfg.Replication.Target != "" || cfg.Backup.Store.Provider != "" {
+		return errors.New("sharding can't be enabled with CDC, replication or backup shipping, they only read shard 0")
+	}
+	return nil
+}
+
+func newChaosCfg() ChaosCfg {
+	return ChaosCfg{
+		Enabled: getEnvBool("CHAOS_ENABLED", false),
+	}
+}
+
+func newReadsCfg() ReadsCfg {
+	return ReadsCfg{
+		MaxApplyLag:      uint64(getEnvInt("READ_MAX_APPLY_LAG", 0)),
+		MinIndexTimeout:  getEnvDuration("READ_MIN_INDEX_TIMEOUT", 5*time.Second),
+		ReadyMaxApplyLag: uint64(getEnvInt("READY_MAX_APPLY_LAG", 100)),
+	}
+}
+
+func newMetricsCfg() MetricsCfg {
+	return MetricsCfg{
+		SlowOpThreshold:      getEnvDuration("SLOW_OP_THRESHOLD", 100*time.Millisecond),
+		HotKeysSampleEvery:   getEnvInt("HOTKEYS_SAMPLE_EVERY", 100),
+		AccessLogSampleEvery: getEnvInt("ACCESS_LOG_SAMPLE_EVERY", 0),
+		AccessLogErrors:      getEnvBool("ACCESS_LOG_ERRORS", false),
+	}
+}
+
+func newRestCfg() RestCfg {
+	return RestCfg{
+		CORSAllowOrigins:     getEnv("CORS_ALLOW_ORIGINS", "*"),
+		CORSAllowHeaders:     getEnv("CORS_ALLOW_HEADERS", ""),
+		CORSAllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", true),
+		BodyLimit:            getEnvInt("REST_BODY_LIMIT", 200*1024*1024),
+		ReadTimeout:          getEnvDuration("REST_READ_TIMEOUT", 0),
+		WriteTimeout:         getEnvDuration("REST_WRITE_TIMEOUT", 10*time.Second),
+		IdleTimeout:          getEnvDuration("REST_IDLE_TIMEOUT", 5*time.Second),
+		TLSCertFile:          getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:           getEnv("TLS_KEY_FILE", ""),
+		AutocertDomains:      getEnv("AUTOCERT_DOMAINS", ""),
+		AutocertCacheDir:     getEnv("AUTOCERT_CACHE_DIR", "data/autocert"),
+		AutocertEmail:        getEnv("AUTOCERT_EMAIL", ""),
+		TLSPeerCAFile:        getEnv("TLS_PEER_CA_FILE", ""),
+		UnixSocket:           getEnv("REST_UNIX_SOCKET", ""),
+		ReadDeadline:         getEnvDuration("REST_READ_DEADLINE", 1*time.Second),
+		WriteDeadline:        getEnvDuration("REST_WRITE_DEADLINE", 5*time.Second),
+		AdminDeadline:        getEnvDuration("REST_ADMIN_DEADLINE", 30*time.Second),
+		PageTokenSecret:      getSecretEnv("REST_PAGE_TOKEN_SECRET"),
+		DebugToken:           getSecretEnv("REST_DEBUG_TOKEN"),
+	}
+}
+
+// TLSEnabled returns whether the API is served over HTTPS, with certificate files or from Let's Encrypt.
+func (c RestCfg) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSKeyFile != "" || c.AutocertDomains != ""
+}
+
+func newGrpcCfg() GrpcCfg {
+	return GrpcCfg{
+		UnixSocket:             getEnv("GRPC_UNIX_SOCKET", ""),
+		WriteStreamConcurrency: getEnvInt("GRPC_WRITE_STREAM_CONCURRENCY", 16),
+	}
+}
+
+func newSoftDeleteCfg() SoftDeleteCfg {
+	return SoftDeleteCfg{
+		Retention:     getEnvDuration("SOFT_DELETE_RETENTION", 0),
+		PurgeInterval: getEnvDuration("SOFT_DELETE_PURGE_INTERVAL", 10*time.Minute),
+	}
+}
+
+// NewStorageCfg returns the storage configuration, it's exported for the offline tools which read the data.
+func NewStorageCfg() StorageCfg {
+	return StorageCfg{
+		Engine:            getEnv("STORAGE_ENGINE", "badger"),
+		Dir:               getEnv("STORAGE_DIR", ""),
+		ReadCacheSize:     int64(getEnvInt("STORAGE_READ_CACHE_SIZE", 0)),
+		BloomKeys:         uint64(getEnvInt("STORAGE_BLOOM_KEYS", 100000)),
+		ChunkSize:         getEnvInt("STORAGE_CHUNK_SIZE", 1024*1024),
+		MinFreeBytes:      uint64(getEnvInt("STORAGE_MIN_FREE_BYTES", 512*1024*1024)),
+		DiskCheckInterval: getEnvDuration("STORAGE_DISK_CHECK_INTERVAL", 5*time.Second),
+	}
+}
+
+// NewConsensusCfg returns the consensus configuration, it's exported for the offline tools which read the logs.
+func NewConsensusCfg() ConsensusCfg {
+	return ConsensusCfg{
+		LogStore:          getEnv("CONSENSUS_LOG_STORE", "bolt"),
+		LogDir:            getEnv("CONSENSUS_LOG_DIR", ""),
+		SnapshotDir:       getEnv("CONSENSUS_SNAPSHOT_DIR", ""),
+		PayloadEncoding:   getEnv("CONSENSUS_PAYLOAD_ENCODING", "json"),
+		LogLevel:          strings.ToLower(getEnv("CONSENSUS_LOG_LEVEL", "debug")),
+		SnapshotInterval:  getEnvDuration("SNAPSHOT_INTERVAL", 10*time.Second),
+		SnapshotThreshold: uint64(getEnvInt("SNAPSHOT_THRESHOLD", 2)),
+		TrailingLogs:      uint64(getEnvInt("SNAPSHOT_TRAILING_LOGS", 10240)),
+	}
+}
+
+func (c ConsensusCfg) validate() error {
+	switch c.LogLevel {
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("consensus log level '%s' isn't valid, it must be trace, debug, info, warn or error", c.LogLevel)
+	}
+	if c.SnapshotInterval <= 0 {
+		return errors.New("the snapshot interval must be positive")
+	}
+	// The memory store keeps the term and the vote of the node in memory too, so a node restarted in the same term
+	// could vote twice, and elect two leaders. It can only be used by the tests, which don't read this configuration.
+	if c.LogStore == "memory" {
+		return errors.New("the memory consensus log store can't be used by a node, it's only meant for tests")
+	}
+	return nil
 }
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"github.com/narvikd/errorskit"
+	"nubedb/pkg/encrypt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// EncryptionCfg configures the encryption at rest of the FSM's data and the consensus logs.
+type EncryptionCfg struct {
+	// Key is the master key, encryption is disabled if it's empty.
+	Key []byte
+	// OldKeys are previous master keys, only used to decrypt consensus logs written before a key rotation.
+	OldKeys [][]byte
+	// DataKeyRotation is how often badger rotates the data keys it derives from the master key.
+	DataKeyRotation time.Duration
+	// ValueKey is the master key that wraps the data keys of the encrypted buckets.
+	// It's independent of Key, and it must be the same on every node.
+	ValueKey []byte
+}
+
+// Enabled returns whether encryption at rest is enabled.
+func (c EncryptionCfg) Enabled() bool {
+	return len(c.Key) > 0
+}
+
+// NewEncryptionCfg returns the encryption configuration.
+//
+// The hex encoded master key is read from the first of these sources that is set:
+//
+// NUBEDB_ENCRYPTION_KEY, the file at NUBEDB_ENCRYPTION_KEY_FILE,
+// or the output of the command at NUBEDB_ENCRYPTION_KEY_COMMAND (which can be used to fetch it from a KMS).
+func NewEncryptionCfg() (EncryptionCfg, error) {
+	cfg := EncryptionCfg{
+		DataKeyRotation: getEnvDuration("ENCRYPTION_DATA_KEY_ROTATION", 10*24*time.Hour),
+	}
+
+	if rawValueKey := getSecretEnv("VALUE_ENCRYPTION_KEY"); rawValueKey != "" {
+		valueKey, errValueKey := encrypt.ParseKey(rawValueKey)
+		if errValueKey != nil {
+			return cfg, errorskit.Wrap(errValueKey, "invalid value encryption key")
+		}
+		cfg.ValueKey = valueKey
+	}
+
+	rawKey, errRawKey := readEncryptionKey()
+	if errRawKey != nil {
+		return cfg, errRawKey
+	}
+	if rawKey == "" {
+		return cfg, nil
+	}
+
+	key, errKey := encrypt.ParseKey(rawKey)
+	if errKey != nil {
+		return cfg, errorskit.Wrap(errKey, "invalid encryption key")
+	}
+	cfg.Key = key
+
+	for _, rawOld := range strings.Split(getSecretEnv("ENCRYPTION_OLD_KEYS"), ",") {
+		if strings.TrimSpace(rawOld) == "" {
+			continue
+		}
+		old, errOld := encrypt.ParseKey(rawOld)
+		if errOld != nil {
+			return cfg, errorskit.Wrap(errOld, "invalid old encryption key")
+		}
+		cfg.OldKeys = append(cfg.OldKeys, old)
+	}
+
+	return cfg, nil
+}
+
+func readEncryptionKey() (string, error) {
+	if key := getSecretEnv("ENCRYPTION_KEY"); key != "" {
+		return key, nil
+	}
+
+	if path := getEnv("ENCRYPTION_KEY_FILE", ""); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", errorskit.Wrap(err, "couldn't read encryption key file")
+		}
+		return string(b), nil
+	}
+
+	if command := getEnv("ENCRYPTION_KEY_COMMAND", ""); command != "" {
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return "", errorskit.Wrap(err, "couldn't execute encryption key command")
+		}
+		if len(out) <= 0 {
+			return "", errors.New("encryption key command didn't output a key")
+		}
+		return string(out), nil
+	}
+
+	return "", nil
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// envPrefix is the prefix of all the environment variables nubedb reads its configuration from.
+const envPrefix = "NUBEDB_"
+
+// Sources of the value of a variable.
+const (
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// Var is a variable of the configuration which was read, with its effective value.
+type Var struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Secret is whether the value must not be shown.
+	Secret bool `json:"secret"`
+	// Problem is why the value set wasn't used, like it not being a number. The default is used instead.
+	Problem string `json:"problem,omitempty"`
+}
+
+// readVars are the variables read, so the effective configuration can be checked with "nubedb config check".
+var readVars = struct {
+	sync.Mutex
+	vars map[string]Var
+}{vars: make(map[string]Var)}
+
+// lookupEnv returns the value of the environment variable NUBEDB_key, or of the configuration file if it isn't set,
+// with where it came from.
+func lookupEnv(key string) (string, string) {
+	if v, ok := os.LookupEnv(envPrefix + key); ok && v != "" {
+		return v, SourceEnv
+	}
+	if v, ok := lookupFile(envPrefix + key); ok && v != "" {
+		return v, SourceFile
+	}
+	return "", SourceDefault
+}
+
+// recordVar records the effective value of a variable. If the value set was invalid, the default is recorded with why.
+func recordVar(key string, value string, source string, def string, problem string) {
+	v := Var{Name: envPrefix + key, Value: value, Source: source, Problem: problem}
+	if source == SourceDefault || problem != "" {
+		v.Value = def
+	}
+	readVars.Lock()
+	defer readVars.Unlock()
+	v.Secret = readVars.vars[v.Name].Secret
+	readVars.vars[v.Name] = v
+}
+
+// getEnv returns the value of the environment variable NUBEDB_key, or of the configuration file if it isn't set,
+// or def if neither sets it.
+func getEnv(key string, def string) string {
+	v, source := lookupEnv(key)
+	recordVar(key, v, source, def, "")
+	if source == SourceDefault {
+		return def
+	}
+	return v
+}
+
+// getSecretEnv is getEnv for the variables whose value must not be shown, like keys and passwords.
+func getSecretEnv(key string) string {
+	readVars.Lock()
+	readVars.vars[envPrefix+key] = Var{Name: envPrefix + key, Secret: true}
+	readVars.Unlock()
+	return getEnv(key, "")
+}
+
+// getEnvInt returns the value of the environment variable NUBEDB_key as an int, or def if it isn't set or valid.
+func getEnvInt(key string, def int) int {
+	raw, source := lookupEnv(key)
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		recordVar(key, raw, source, strconv.Itoa(def), invalidProblem(source, "an integer"))
+		return def
+	}
+	recordVar(key, raw, source, "", "")
+	return v
+}
+
+// getEnvBool returns the value of the environment variable NUBEDB_key as a bool, or def if it isn't set or valid.
+func getEnvBool(key string, def bool) bool {
+	raw, source := lookupEnv(key)
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		recordVar(key, raw, source, strconv.FormatBool(def), invalidProblem(source, "true or false"))
+		return def
+	}
+	recordVar(key, raw, source, "", "")
+	return v
+}
+
+// getEnvDuration returns the value of the environment variable NUBEDB_key as a duration (ex: 5s),
+// or def if it isn't set or valid.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	raw, source := lookupEnv(key)
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		recordVar(key, raw, source, def.String(), invalidProblem(source, "a duration, ex: 5s"))
+		return def
+	}
+	recordVar(key, raw, source, "", "")
+	return v
+}
+
+// invalidProblem returns why a value set isn't used, or nothing if it isn't set.
+func invalidProblem(source string, expected string) string {
+	if source == SourceDefault {
+		return ""
+	}
+	return "it isn't " + expected + ", the default is used"
+}
+
+// Vars returns the variables of the configuration read until now, sorted by name.
+func Vars() []Var {
+	readVars.Lock()
+	defer readVars.Unlock()
+	vars := make([]Var, 0, len(readVars.vars))
+	for _, v := range readVars.vars {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].Name < vars[j].Name
+	})
+	return vars
+}
+
+// UnusedVars returns the NUBEDB_ variables set in the environment or the configuration file which weren't read,
+// like misspelled ones, sorted by name.
+func UnusedVars() []string {
+	set := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, envPrefix) {
+			set[name] = true
+		}
+	}
+	fileEnv.RLock()
+	for name := range fileEnv.vars {
+		set[name] = true
+	}
+	fileEnv.RUnlock()
+
+	readVars.Lock()
+	defer readVars.Unlock()
+	unused := make([]string, 0)
+	for name := range set {
+		if _, read := readVars.vars[name]; !read && name != envPrefix+"CONFIG_FILE" {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
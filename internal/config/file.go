@@ -0,0 +1,74 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/narvikd/errorskit"
+	"os"
+	"strings"
+	"sync"
+)
+
+// fileEnv holds the variables of the configuration file, the environment takes precedence over them.
+var fileEnv = struct {
+	sync.RWMutex
+	vars map[string]string
+}{}
+
+// loadFile reads the variables of the configuration file set in NUBEDB_CONFIG_FILE, if there's one,
+// replacing the ones read before.
+//
+// The file has a variable per line, like an env file: NUBEDB_KEY=value. The NUBEDB_ prefix can be omitted,
+// and the lines starting with # are ignored.
+func loadFile() error {
+	vars := make(map[string]string)
+	if path := os.Getenv(envPrefix + "CONFIG_FILE"); path != "" {
+		errParse := parseFile(path, vars)
+		if errParse != nil {
+			return errorskit.Wrap(errParse, "couldn't read the configuration file")
+		}
+	}
+
+	fileEnv.Lock()
+	defer fileEnv.Unlock()
+	fileEnv.vars = vars
+	return nil
+}
+
+func parseFile(path string, vars map[string]string) error {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return errOpen
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return fmt.Errorf("line %v isn't a variable: %s", line, text)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if !strings.HasPrefix(key, envPrefix) {
+			key = envPrefix + key
+		}
+		vars[key] = value
+	}
+	return scanner.Err()
+}
+
+// lookupFile returns the value of a variable of the configuration file.
+func lookupFile(key string) (string, bool) {
+	fileEnv.RLock()
+	defer fileEnv.RUnlock()
+	v, ok := fileEnv.vars[key]
+	return v, ok
+}
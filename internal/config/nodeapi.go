@@ -0,0 +1,62 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/narvikd/errorskit"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// nodeAPI is how the nodes reach each other's REST API, it's set once on startup with ConfigureNodeAPI.
+var nodeAPI = struct {
+	mu     sync.RWMutex
+	scheme string
+	tls    *tls.Config
+}{scheme: "http"}
+
+// ConfigureNodeAPI sets how the nodes reach each other's REST API: over HTTPS if the API is served with TLS,
+// trusting the CA of TLSPeerCAFile if it's set, or the system's ones otherwise.
+func ConfigureNodeAPI(cfg RestCfg) error {
+	scheme, tlsCfg := "http", (*tls.Config)(nil)
+	if cfg.TLSEnabled() {
+		scheme, tlsCfg = "https", &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.TLSPeerCAFile != "" {
+			pem, errRead := os.ReadFile(cfg.TLSPeerCAFile)
+			if errRead != nil {
+				return errorskit.Wrap(errRead, "couldn't read the CA of the nodes")
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return errors.New("the CA file of the nodes doesn't have any PEM certificate")
+			}
+			tlsCfg.RootCAs = pool
+		}
+	}
+	nodeAPI.mu.Lock()
+	defer nodeAPI.mu.Unlock()
+	nodeAPI.scheme, nodeAPI.tls = scheme, tlsCfg
+	return nil
+}
+
+// MakeApiURL returns the URL of a path of a node's REST API, ex: MakeApiURL("node1", "/cluster/status").
+func MakeApiURL(nodeID string, path string) string {
+	nodeAPI.mu.RLock()
+	defer nodeAPI.mu.RUnlock()
+	return nodeAPI.scheme + "://" + MakeApiAddr(nodeID) + path
+}
+
+// NewNodeAPIClient returns a client for the other nodes' REST API, which trusts their certificates.
+func NewNodeAPIClient(timeout time.Duration) *http.Client {
+	nodeAPI.mu.RLock()
+	defer nodeAPI.mu.RUnlock()
+	if nodeAPI.tls == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = nodeAPI.tls.Clone()
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
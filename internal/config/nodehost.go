@@ -0,0 +1,35 @@
+package config
+
+import "sync"
+
+var (
+	nodeHostsMu sync.RWMutex
+	// nodeHosts holds the hosts the nodes are reached at by their ID, the nodes which aren't in it are reached at their ID.
+	nodeHosts = make(map[string]string)
+)
+
+// RegisterNodeHost records the host a node is reached at, the addresses of the node are built with it.
+//
+// The hosts are learned from the node's own configuration, the discovery, and the consensus configuration.
+func RegisterNodeHost(nodeID string, host string) {
+	if nodeID == "" || host == "" {
+		return
+	}
+	nodeHostsMu.Lock()
+	defer nodeHostsMu.Unlock()
+	if host == nodeID {
+		delete(nodeHosts, nodeID)
+		return
+	}
+	nodeHosts[nodeID] = host
+}
+
+// NodeHost returns the host a node is reached at, its ID unless another one was registered.
+func NodeHost(nodeID string) string {
+	nodeHostsMu.RLock()
+	defer nodeHostsMu.RUnlock()
+	if host, ok := nodeHosts[nodeID]; ok {
+		return host
+	}
+	return nodeID
+}
@@ -0,0 +1,19 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// alerts counts the alerts fired and resolved, by condition.
+var alerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "alerts_total",
+	Help:      "Alerts of the cluster's conditions, by condition (no_leader, quorum_loss or disk_low) and status (firing or resolved).",
+}, []string{"condition", "status"})
+
+func init() {
+	Registry.MustRegister(alerts)
+}
+
+// RecordAlert counts an alert of a condition with a status.
+func RecordAlert(condition string, status string) {
+	alerts.WithLabelValues(condition, status).Inc()
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Results of the anti-entropy checks.
+const (
+	AntiEntropyConsistent = "consistent"
+	AntiEntropyDivergent  = "divergent"
+	AntiEntropyIncomplete = "incomplete"
+)
+
+// antiEntropyChecks counts the anti-entropy checks of the shards, by result.
+var antiEntropyChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "antientropy_checks_total",
+	Help: "Anti-entropy checks of the shards' replicas, by result: consistent, divergent " +
+		"or incomplete (some replicas didn't report their digest).",
+}, []string{"result"})
+
+// antiEntropyDivergent counts the replicas found divergent by the anti-entropy checks.
+var antiEntropyDivergent = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "antientropy_divergent_replicas_total",
+	Help:      "Replicas whose data diverged from the majority of their shard in an anti-entropy check.",
+})
+
+func init() {
+	Registry.MustRegister(antiEntropyChecks, antiEntropyDivergent)
+}
+
+// RecordAntiEntropyCheck counts an anti-entropy check with one of the AntiEntropy results,
+// and the replicas found divergent in it.
+func RecordAntiEntropyCheck(result string, divergent int) {
+	antiEntropyChecks.WithLabelValues(result).Inc()
+	antiEntropyDivergent.Add(float64(divergent))
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// bloomLookups counts the lookups of the keys' bloom filter, by whether the key was absent or may exist.
+var bloomLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "bloom_lookups_total",
+	Help:      "Lookups of the keys' bloom filter, by result: absent or maybe.",
+}, []string{"result"})
+
+func init() {
+	Registry.MustRegister(bloomLookups)
+}
+
+// RecordBloomLookup counts a lookup of the keys' bloom filter.
+func RecordBloomLookup(mayExist bool) {
+	result := "absent"
+	if mayExist {
+		result = "maybe"
+	}
+	bloomLookups.WithLabelValues(result).Inc()
+}
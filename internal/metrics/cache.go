@@ -0,0 +1,23 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// cacheReads counts the reads of the read cache, by whether they were a hit or a miss.
+var cacheReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "read_cache_requests_total",
+	Help:      "Reads of the in-memory read cache, by result: hit or miss.",
+}, []string{"result"})
+
+func init() {
+	Registry.MustRegister(cacheReads)
+}
+
+// RecordCacheRead counts a read of the read cache.
+func RecordCacheRead(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheReads.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,19 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// coalescedWrites counts the writes merged into a later write to the same key, instead of being applied on their own.
+var coalescedWrites = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "coalesced_writes_total",
+	Help:      "Writes merged into a later write to the same key by the leader, instead of being applied on their own.",
+})
+
+func init() {
+	Registry.MustRegister(coalescedWrites)
+}
+
+// RecordCoalescedWrite counts a write merged into a later write to the same key.
+func RecordCoalescedWrite() {
+	coalescedWrites.Inc()
+}
@@ -0,0 +1,19 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// corruptions counts the stored values whose checksum didn't match.
+var corruptions = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "corrupted_values_total",
+	Help:      "Stored values read whose checksum didn't match, which indicates data corruption.",
+})
+
+func init() {
+	Registry.MustRegister(corruptions)
+}
+
+// RecordCorruption counts a stored value whose checksum didn't match.
+func RecordCorruption() {
+	corruptions.Inc()
+}
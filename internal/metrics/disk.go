@@ -0,0 +1,36 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// diskFree is the free space of each of the node's data dirs.
+	diskFree = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "disk_free_bytes",
+		Help:      "Bytes available to the node in the filesystem of each of its data dirs, by dir.",
+	}, []string{"dir"})
+	// diskLow is whether the node is read-only because it's low on disk space.
+	diskLow = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "disk_low",
+		Help:      "1 while the node refuses writes because a data dir is low on disk space, 0 otherwise.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(diskFree, diskLow)
+}
+
+// SetDiskFree sets the bytes available in the filesystem of a data dir.
+func SetDiskFree(dir string, bytes uint64) {
+	diskFree.WithLabelValues(dir).Set(float64(bytes))
+}
+
+// SetDiskLow sets whether the node is read-only because it's low on disk space.
+func SetDiskLow(isLow bool) {
+	if isLow {
+		diskLow.Set(1)
+		return
+	}
+	diskLow.Set(0)
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// hotKeysCapacity is the maximum number of keys tracked for each kind of access.
+const hotKeysCapacity = 1000
+
+// HotKey is a frequently accessed key, with the estimated number of accesses since the node started.
+type HotKey struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// HotKeys are the most frequently read and written keys.
+type HotKeys struct {
+	Reads  []HotKey `json:"reads"`
+	Writes []HotKey `json:"writes"`
+}
+
+// keyCounter keeps approximate counts of the most frequent keys in a bounded space, using the Space-Saving algorithm:
+// when it's full, the least frequent key is replaced, inheriting its count.
+type keyCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+var (
+	hotReads  = &keyCounter{counts: make(map[string]uint64)}
+	hotWrites = &keyCounter{counts: make(map[string]uint64)}
+	// hotKeysSampleEvery samples 1 of every N accesses. 0 disables the sampling.
+	hotKeysSampleEvery atomic.Int64
+)
+
+// SetHotKeysSampling sets how many accesses there are for each one sampled, 0 disables the hot keys detection.
+func SetHotKeysSampling(every int) {
+	hotKeysSampleEvery.Store(int64(every))
+}
+
+// RecordRead samples a read of a key.
+func RecordRead(key string) {
+	sampleKey(hotReads, key)
+}
+
+// RecordWrite samples a write of a key.
+func RecordWrite(key string) {
+	sampleKey(hotWrites, key)
+}
+
+// GetHotKeys returns the limit most frequently read and written keys.
+func GetHotKeys(limit int) HotKeys {
+	return HotKeys{
+		Reads:  hotReads.top(limit),
+		Writes: hotWrites.top(limit),
+	}
+}
+
+func sampleKey(c *keyCounter, key string) {
+	every := hotKeysSampleEvery.Load()
+	if every <= 0 || rand.Int63n(every) != 0 {
+		return
+	}
+	c.add(key, uint64(every))
+}
+
+func (c *keyCounter) add(key string, n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.counts[key]; ok || len(c.counts) < hotKeysCapacity {
+		c.counts[key] += n
+		return
+	}
+
+	minKey, minCount := "", uint64(0)
+	for k, count := range c.counts {
+		if minKey == "" || count < minCount {
+			minKey, minCount = k, count
+		}
+	}
+	delete(c.counts, minKey)
+	c.counts[key] = minCount + n
+}
+
+func (c *keyCounter) top(limit int) []HotKey {
+	c.mu.Lock()
+	keys := make([]HotKey, 0, len(c.counts))
+	for k, count := range c.counts {
+		keys = append(keys, HotKey{Key: k, Count: count})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Count > keys[j].Count
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Components of the instrumented operations.
+const (
+	ComponentFSMApply    = "fsm_apply"
+	ComponentBadgerGet   = "badger_get"
+	ComponentGrpcForward = "grpc_forward"
+)
+
+// operationDuration is the latency histogram of the instrumented operations.
+var operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "operation_duration_seconds",
+	Help:      "Latency of the operations, by component and operation.",
+	Buckets:   []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"component", "operation"})
+
+// slowOpThreshold is the duration from which operations are logged as slow, in nanoseconds. 0 disables the log.
+var slowOpThreshold atomic.Int64
+
+func init() {
+	Registry.MustRegister(operationDuration)
+}
+
+// SetSlowOpThreshold sets the duration from which operations are logged as slow, 0 disables the log.
+func SetSlowOpThreshold(d time.Duration) {
+	slowOpThreshold.Store(int64(d))
+}
+
+// Track records the latency of an operation which started at start,
+// and logs it if it's slower than the slow operation threshold.
+//
+// It's meant to be deferred: defer metrics.Track(component, operation, key, time.Now())
+func Track(component string, operation string, key string, start time.Time) {
+	elapsed := time.Since(start)
+	operationDuration.WithLabelValues(component, operation).Observe(elapsed.Seconds())
+
+	threshold := time.Duration(slowOpThreshold.Load())
+	if threshold > 0 && elapsed >= threshold {
+		log.Printf("[slow-op] component=%s operation=%s key=%q duration=%s\n", component, operation, key, elapsed)
+	}
+}
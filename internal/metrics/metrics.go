@@ -0,0 +1,43 @@
+// Package metrics holds nubedb's prometheus metrics, and the handler which exposes them.
+package metrics
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// namespace is the prefix of all the metrics.
+const namespace = "nubedb"
+
+// Registry is where all the metrics are registered, it also includes the Go runtime and process metrics.
+var Registry = newRegistry()
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return r
+}
+
+// RegisterGaugeFunc registers a gauge whose value is obtained by calling fn when the metrics are collected.
+//
+// The gauges with the same name must be registered with different values of the same labels.
+func RegisterGaugeFunc(name string, help string, labels map[string]string, fn func() float64) error {
+	return Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        name,
+		Help:        help,
+		ConstLabels: labels,
+	}, fn))
+}
+
+// Handler returns the fiber handler which exposes the metrics in prometheus' format.
+func Handler() fiber.Handler {
+	h := fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	return func(fiberCtx *fiber.Ctx) error {
+		h(fiberCtx.Context())
+		return nil
+	}
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Results of the quorum reads.
+const (
+	QuorumReadConsistent = "consistent"
+	QuorumReadStale      = "stale"
+	QuorumReadConflict   = "conflict"
+)
+
+// quorumReads counts the quorum reads, by whether the replicas agreed.
+var quorumReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "quorum_reads_total",
+	Help: "Quorum reads, by result: consistent, stale (a replica which applied less logs returned another value) " +
+		"or conflict (replicas which applied the same logs returned different values).",
+}, []string{"result"})
+
+func init() {
+	Registry.MustRegister(quorumReads)
+}
+
+// RecordQuorumRead counts a quorum read with one of the QuorumRead results.
+func RecordQuorumRead(result string) {
+	quorumReads.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// webhookDeliveries counts the deliveries of changes to the webhooks, by webhook and result.
+var webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "webhook_deliveries_total",
+	Help:      "Changes delivered to the webhooks, by webhook and result: delivered or dropped.",
+}, []string{"webhook", "result"})
+
+func init() {
+	Registry.MustRegister(webhookDeliveries)
+}
+
+// RecordWebhookDelivery counts a change delivered to a webhook, or dropped after all its attempts failed.
+func RecordWebhookDelivery(webhook string, delivered bool) {
+	result := "dropped"
+	if delivered {
+		result = "delivered"
+	}
+	webhookDeliveries.WithLabelValues(webhook, result).Inc()
+}
@@ -4,13 +4,30 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"log"
 	"nubedb/api/proto/protoserver"
+	"nubedb/api/rest"
 	"nubedb/api/rest/middleware"
 	"nubedb/api/rest/route"
+	"nubedb/cluster"
+	"nubedb/cluster/alert"
+	"nubedb/cluster/antientropy"
+	"nubedb/cluster/backup"
+	"nubedb/cluster/cdc"
+	"nubedb/cluster/consensus"
+	"nubedb/cluster/diskwatch"
+	"nubedb/cluster/replication"
+	"nubedb/cluster/settings"
+	"nubedb/cluster/systemd"
+	"nubedb/cluster/tombstone"
+	"nubedb/cluster/webhook"
 	"nubedb/discover"
 	"nubedb/internal/app"
+	"nubedb/internal/cli"
 	"nubedb/internal/config"
+	"os"
+	"os/signal"
 	"runtime"
 	"sync"
+	"syscall"
 )
 
 func init() {
@@ -20,12 +37,75 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		errCmd := cli.Run(os.Args[1:])
+		if errCmd != nil {
+			log.Fatalln(errCmd)
+		}
+		return
+	}
+
 	cfg, errCfg := config.New()
 	if errCfg != nil {
 		log.Fatalln(errCfg)
 	}
 
-	start(app.NewApp(cfg))
+	// The node is discoverable while it boots, so the nodes waiting for the expected ones to bootstrap find it.
+	// A single node isn't discovered, since there are no other nodes.
+	if !cfg.Cluster.SingleNode {
+		errInterface := discover.ConfigureInterface(cfg.CurrentNode.Interface)
+		if errInterface != nil {
+			log.Fatalln(errInterface)
+		}
+		errCloud := discover.ConfigureCloud(cfg.Cluster.CloudJoin)
+		if errCloud != nil {
+			log.Fatalln(errCloud)
+		}
+		go discover.ServeAndBlock(cfg.CurrentNode, 8001)
+	}
+
+	a := app.NewApp(cfg)
+	go stopOnSignal(a)
+	go reloadOnSignal(a)
+	start(a)
+}
+
+// reloadOnSignal reloads the configuration every time the node receives SIGHUP.
+func reloadOnSignal(a *app.App) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		errReload := a.Reload()
+		if errReload != nil {
+			log.Println("[config] couldn't reload the configuration:", errReload)
+		}
+	}
+}
+
+// stopOnSignal shuts down the node when it's asked to stop, like by Kubernetes after the preStop hook,
+// exiting with ExitStopped if it was shut down cleanly.
+//
+// It also shuts it down when the node asks to be restarted, exiting with ExitRestart instead,
+// so the node's supervisor restarts it.
+func stopOnSignal(a *app.App) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	exitCode := consensus.ExitStopped
+	select {
+	case sig := <-signals:
+		log.Printf("received %v, shutting down...\n", sig)
+	case reason := <-a.Node.Restarts():
+		log.Printf("restarting the node, %s. Shutting down...\n", reason)
+		exitCode = consensus.ExitRestart
+	}
+	systemd.Stopping()
+
+	errShutdown := a.Node.Shutdown()
+	if errShutdown != nil {
+		log.Println(errShutdown)
+		os.Exit(consensus.ExitFailure)
+	}
+	os.Exit(exitCode)
 }
 
 func start(a *app.App) {
@@ -46,12 +126,96 @@ func start(a *app.App) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		discover.ServeAndBlock(a.Config.CurrentNode.ID, 8001)
+		startCDC(a)
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		replication.Start(a.Node, a.Config.Replication)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		webhook.Start(a.Node, a.Config.Webhook)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		antientropy.Start(a.Node, a.Config.AntiEntropy)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		diskwatch.Start(a.Node.Paths().Roots(), a.Config.Storage.MinFreeBytes, a.Config.Storage.DiskCheckInterval)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		alert.Start(a.Node, a.Config.Alert, a.Config.Storage.MinFreeBytes > 0)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		settings.Start(a.Node, a.Config)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startBackupShipping(a)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startHandoff(a)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		systemd.Start(a.Node, a.Config.CurrentNode.SystemdNotify)
+	}()
+
+	for _, s := range a.Node.Shards() {
+		wg.Add(1)
+		go func(s *consensus.Shard) {
+			defer wg.Done()
+			tombstone.StartPurge(s.Consensus, a.Config.SoftDelete)
+		}(s)
+	}
+
 	wg.Wait()
 }
 
+func startHandoff(a *app.App) {
+	cluster.StartHandoff(cluster.HandoffPolicy{
+		Enabled:   a.Config.Handoff.Enabled,
+		MaxWrites: a.Config.Handoff.MaxWrites,
+		TTL:       a.Config.Handoff.TTL,
+	}, a.Config.Handoff.Interval)
+}
+
+func startBackupShipping(a *app.App) {
+	err := backup.StartShipping(a.Node.Consensus, a.Node.FSM, a.Config.Backup)
+	if err != nil {
+		log.Fatalln("backup shipping can't be started:", err)
+	}
+}
+
+func startCDC(a *app.App) {
+	err := cdc.Start(a.Node, a.Config.CDC)
+	if err != nil {
+		log.Fatalln("cdc can't be started:", err)
+	}
+}
+
 func startApiProto(a *app.App) {
 	log.Println("[proto] Starting proto server...")
 	err := protoserver.Start(a)
@@ -61,14 +225,14 @@ func startApiProto(a *app.App) {
 }
 
 func startApiRest(a *app.App) {
-	errListen := newApiRest(a).Listen(a.Config.CurrentNode.ApiAddress)
+	errListen := rest.Listen(newApiRest(a), a.Config.CurrentNode.ApiAddress, a.Config.Rest)
 	if errListen != nil {
 		log.Fatalln("api can't be started:", errListen)
 	}
 }
 
 func newApiRest(a *app.App) *fiber.App {
-	middleware.InitMiddlewares(a.HttpServer)
+	middleware.InitMiddlewares(a.HttpServer, a.Config.Rest)
 	route.Register(a)
 	return a.HttpServer
 }
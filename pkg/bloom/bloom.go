@@ -0,0 +1,127 @@
+// Package bloom implements a scalable bloom filter: a set which answers whether an item may have been added,
+// without false negatives, in constant time and a few bits per item.
+//
+// When the filter reaches its capacity, a new layer twice as big and with a tighter false positive rate is added,
+// so the overall false positive rate stays bounded no matter how many items are added.
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// tightening is the ratio the false positive rate of each new layer is multiplied by.
+const tightening = 0.5
+
+// Filter is a scalable bloom filter, it's safe for concurrent use.
+type Filter struct {
+	mu     sync.RWMutex
+	layers []*layer
+	fpRate float64
+	count  uint64
+}
+
+type layer struct {
+	bits     []uint64
+	m        uint64
+	k        uint64
+	capacity uint64
+	count    uint64
+}
+
+// New creates a filter sized for capacity items with a false positive rate of fpRate, between 0 and 1.
+func New(capacity uint64, fpRate float64) *Filter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	f := &Filter{fpRate: fpRate}
+	f.layers = append(f.layers, newLayer(capacity, fpRate*(1-tightening)))
+	return f
+}
+
+func newLayer(capacity uint64, fpRate float64) *layer {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &layer{bits: make([]uint64, (m+63)/64), m: m, k: k, capacity: capacity}
+}
+
+// Add adds an item to the filter.
+func (f *Filter) Add(item []byte) {
+	h1, h2 := hashes(item)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last := f.layers[len(f.layers)-1]
+	if last.count >= last.capacity {
+		fpRate := f.fpRate * (1 - tightening) * math.Pow(tightening, float64(len(f.layers)))
+		last = newLayer(last.capacity*2, fpRate)
+		f.layers = append(f.layers, last)
+	}
+	last.add(h1, h2)
+	f.count++
+}
+
+// MayContain returns false if the item was never added, and true if it may have been.
+func (f *Filter) MayContain(item []byte) bool {
+	h1, h2 := hashes(item)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, l := range f.layers {
+		if l.has(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns how many items were added, counting the repeated ones.
+func (f *Filter) Count() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.count
+}
+
+// Size returns the memory used by the filter's bits, in bytes.
+func (f *Filter) Size() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var size int64
+	for _, l := range f.layers {
+		size += int64(len(l.bits) * 8)
+	}
+	return size
+}
+
+func (l *layer) add(h1, h2 uint64) {
+	for i := uint64(0); i < l.k; i++ {
+		bit := (h1 + i*h2) % l.m
+		l.bits[bit/64] |= 1 << (bit % 64)
+	}
+	l.count++
+}
+
+func (l *layer) has(h1, h2 uint64) bool {
+	for i := uint64(0); i < l.k; i++ {
+		bit := (h1 + i*h2) % l.m
+		if l.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes returns the two hashes combined to get the positions of an item, with double hashing.
+func hashes(item []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write(item)
+	h1 := h.Sum64()
+	// The second hash must be odd, so it never cycles over a subset of the positions.
+	h2 := (h1>>33 | h1<<31) | 1
+	return h1, h2
+}
@@ -0,0 +1,124 @@
+// Package encrypt provides AES-GCM encryption with support for key rotation.
+//
+// Encrypted data is prefixed with a header containing the ID of the key used,
+// so data encrypted with older keys can still be decrypted while they're kept in the Keyring.
+//
+// Data without the header is considered plaintext, so encryption can be enabled on existing data.
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const keyIDLen = 4
+
+// header marks the data as encrypted, the version is included to allow changing the format in the future.
+var header = []byte{0x00, 'N', 'E', 0x01}
+
+// ErrUnknownKey is returned when the data was encrypted with a key which isn't in the Keyring.
+var ErrUnknownKey = errors.New("data was encrypted with an unknown key")
+
+// Keyring encrypts with the current key, and decrypts with any of its keys.
+type Keyring struct {
+	currentID [keyIDLen]byte
+	aeads     map[[keyIDLen]byte]cipher.AEAD
+}
+
+// NewKeyring returns a Keyring which encrypts with current, and can also decrypt data encrypted with old keys.
+//
+// Keys must be 16, 24 or 32 bytes long, to select AES-128, AES-192 or AES-256.
+func NewKeyring(current []byte, old ...[]byte) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[[keyIDLen]byte]cipher.AEAD)}
+	for i, key := range append([][]byte{current}, old...) {
+		block, errBlock := aes.NewCipher(key)
+		if errBlock != nil {
+			return nil, fmt.Errorf("invalid key: %w", errBlock)
+		}
+		aead, errGCM := cipher.NewGCM(block)
+		if errGCM != nil {
+			return nil, errGCM
+		}
+		id := KeyID(key)
+		k.aeads[id] = aead
+		if i == 0 {
+			k.currentID = id
+		}
+	}
+	return k, nil
+}
+
+// KeyID returns the public identifier of a key.
+func KeyID(key []byte) [keyIDLen]byte {
+	var id [keyIDLen]byte
+	sum := sha256.Sum256(key)
+	copy(id[:], sum[:keyIDLen])
+	return id
+}
+
+// Encrypt encrypts data with the current key.
+func (k *Keyring) Encrypt(data []byte) ([]byte, error) {
+	aead := k.aeads[k.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	_, errNonce := rand.Read(nonce)
+	if errNonce != nil {
+		return nil, errNonce
+	}
+
+	out := make([]byte, 0, len(header)+keyIDLen+len(nonce)+len(data)+aead.Overhead())
+	out = append(out, header...)
+	out = append(out, k.currentID[:]...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// Decrypt decrypts data encrypted with any key of the Keyring. Plaintext data is returned as it is.
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	data = data[len(header):]
+	if len(data) < keyIDLen {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	var id [keyIDLen]byte
+	copy(id[:], data[:keyIDLen])
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	data = data[keyIDLen:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// IsEncrypted returns whether data was encrypted by a Keyring.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, header)
+}
+
+// ParseKey decodes a hex encoded key, ignoring surrounding whitespace.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("key must be hex encoded: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("key must be 16, 24 or 32 bytes long, got %v bytes", len(key))
+	}
+}
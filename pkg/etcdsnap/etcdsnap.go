@@ -0,0 +1,179 @@
+// Package etcdsnap reads the keys of an etcd v3 snapshot, as saved by "etcdctl snapshot save",
+// so they can be imported into nubedb.
+//
+// The snapshot is the bbolt database of the etcd member: only the latest revision of each key is returned,
+// the deleted keys are skipped, and the keys attached to a lease carry its TTL.
+package etcdsnap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"sort"
+	"time"
+)
+
+var (
+	keyBucket   = []byte("key")
+	leaseBucket = []byte("lease")
+)
+
+// revisionLen is the length of the keys of the key bucket: the main revision, a '_' and the sub revision.
+// The tombstones, written when a key is deleted, have an extra 't' at the end.
+const revisionLen = 17
+
+// Entry is a key of the snapshot.
+type Entry struct {
+	Key   string
+	Value []byte
+	// ModRevision is the revision of the key's last modification.
+	ModRevision int64
+	// Lease is the ID of the key's lease, 0 if it hasn't one.
+	Lease int64
+	// TTL is the remaining time to live of the key's lease, 0 if it hasn't one.
+	TTL time.Duration
+}
+
+// Read reads a snapshot, calling fn with every key which exists at its latest revision, sorted by key.
+func Read(path string, fn func(e Entry) error) error {
+	db, errOpen := bbolt.Open(path, 0o600, &bbolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if errOpen != nil {
+		return fmt.Errorf("couldn't open the snapshot: %w", errOpen)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		keys := tx.Bucket(keyBucket)
+		if keys == nil {
+			return errors.New("the file isn't an etcd snapshot, it hasn't a key bucket")
+		}
+		leases, errLeases := readLeases(tx.Bucket(leaseBucket))
+		if errLeases != nil {
+			return errLeases
+		}
+
+		// The key bucket is sorted by revision, so the latest revision of each key is the last one seen.
+		latest := make(map[string]Entry)
+		errIterate := keys.ForEach(func(rev []byte, value []byte) error {
+			if len(rev) < revisionLen {
+				return fmt.Errorf("invalid revision: %x", rev)
+			}
+			e, errDecode := decodeKeyValue(value)
+			if errDecode != nil {
+				return errDecode
+			}
+			if len(rev) > revisionLen && rev[revisionLen] == 't' {
+				delete(latest, e.Key)
+				return nil
+			}
+			e.TTL = leases[e.Lease]
+			latest[e.Key] = e
+			return nil
+		})
+		if errIterate != nil {
+			return errIterate
+		}
+
+		names := make([]string, 0, len(latest))
+		for k := range latest {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			errFn := fn(latest[k])
+			if errFn != nil {
+				return errFn
+			}
+		}
+		return nil
+	})
+}
+
+// decodeKeyValue decodes a mvccpb.KeyValue.
+func decodeKeyValue(b []byte) (Entry, error) {
+	var e Entry
+	errDecode := decodeFields(b, func(num protowire.Number, v uint64, bytes []byte) {
+		switch num {
+		case 1:
+			e.Key = string(bytes)
+		case 3:
+			e.ModRevision = int64(v)
+		case 5:
+			e.Value = bytes
+		case 6:
+			e.Lease = int64(v)
+		}
+	})
+	if errDecode != nil {
+		return Entry{}, fmt.Errorf("couldn't decode key-value: %w", errDecode)
+	}
+	return e, nil
+}
+
+// readLeases returns the remaining TTL of each lease, by ID.
+func readLeases(bucket *bbolt.Bucket) (map[int64]time.Duration, error) {
+	leases := make(map[int64]time.Duration)
+	if bucket == nil {
+		return leases, nil
+	}
+	errIterate := bucket.ForEach(func(id []byte, value []byte) error {
+		var ttl, remaining int64
+		errDecode := decodeFields(value, func(num protowire.Number, v uint64, _ []byte) {
+			switch num {
+			case 2:
+				ttl = int64(v)
+			case 3:
+				remaining = int64(v)
+			}
+		})
+		if errDecode != nil {
+			return fmt.Errorf("couldn't decode lease: %w", errDecode)
+		}
+		// etcd only stores the remaining TTL of the leases with checkpoints, the rest start again from their TTL.
+		if remaining <= 0 {
+			remaining = ttl
+		}
+		if len(id) == 8 {
+			leases[int64(binary.BigEndian.Uint64(id))] = time.Duration(remaining) * time.Second
+		}
+		return nil
+	})
+	return leases, errIterate
+}
+
+// decodeFields calls fn with the varint and bytes fields of a protobuf message, skipping the rest.
+func decodeFields(b []byte, fn func(num protowire.Number, v uint64, bytes []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			fn(num, v, nil)
+			n = m
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(b)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			fn(num, 0, v)
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+		}
+		b = b[n:]
+	}
+	return nil
+}
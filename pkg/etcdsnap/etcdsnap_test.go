@@ -0,0 +1,256 @@
+package etcdsnap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// revision encodes a revision like the keys of etcd's key bucket, with a 't' suffix for the tombstones.
+func revision(main int64, tombstone bool) []byte {
+	b := make([]byte, revisionLen, revisionLen+1)
+	binary.BigEndian.PutUint64(b, uint64(main))
+	b[8] = '_'
+	if tombstone {
+		b = append(b, 't')
+	}
+	return b
+}
+
+// keyValue encodes a mvccpb.KeyValue.
+func keyValue(key string, value string, modRevision int64, lease int64) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, key)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(modRevision))
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(modRevision))
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendString(b, value)
+	if lease != 0 {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(lease))
+	}
+	return b
+}
+
+// lease encodes a leasepb.Lease.
+func lease(id int64, ttl int64, remaining int64) ([]byte, []byte) {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(id))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(ttl))
+	if remaining != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(remaining))
+	}
+	return key, b
+}
+
+type record struct {
+	key   []byte
+	value []byte
+}
+
+// snapshot writes a snapshot with the records of each bucket, returning its path.
+func snapshot(t *testing.T, buckets map[string][]record) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	db, errOpen := bbolt.Open(path, 0o600, nil)
+	if errOpen != nil {
+		t.Fatal(errOpen)
+	}
+	defer db.Close()
+	errUpdate := db.Update(func(tx *bbolt.Tx) error {
+		for name, records := range buckets {
+			bucket, errBucket := tx.CreateBucket([]byte(name))
+			if errBucket != nil {
+				return errBucket
+			}
+			for _, r := range records {
+				if errPut := bucket.Put(r.key, r.value); errPut != nil {
+					return errPut
+				}
+			}
+		}
+		return nil
+	})
+	if errUpdate != nil {
+		t.Fatal(errUpdate)
+	}
+	return path
+}
+
+func readAll(path string) ([]Entry, error) {
+	var entries []Entry
+	errRead := Read(path, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, errRead
+}
+
+func TestRead(t *testing.T) {
+	leaseKey, leaseValue := lease(7, 60, 0)
+	checkpointKey, checkpointValue := lease(8, 60, 15)
+	tests := []struct {
+		name    string
+		buckets map[string][]record
+		want    []Entry
+	}{
+		{
+			name:    "empty snapshot",
+			buckets: map[string][]record{"key": nil},
+			want:    nil,
+		},
+		{
+			name: "keys sorted by name",
+			buckets: map[string][]record{"key": {
+				{revision(2, false), keyValue("b", "2", 2, 0)},
+				{revision(3, false), keyValue("a", "1", 3, 0)},
+			}},
+			want: []Entry{
+				{Key: "a", Value: []byte("1"), ModRevision: 3},
+				{Key: "b", Value: []byte("2"), ModRevision: 2},
+			},
+		},
+		{
+			name: "latest revision of each key",
+			buckets: map[string][]record{"key": {
+				{revision(2, false), keyValue("a", "old", 2, 0)},
+				{revision(5, false), keyValue("a", "new", 5, 0)},
+			}},
+			want: []Entry{{Key: "a", Value: []byte("new"), ModRevision: 5}},
+		},
+		{
+			name: "deleted keys are skipped",
+			buckets: map[string][]record{"key": {
+				{revision(2, false), keyValue("a", "1", 2, 0)},
+				{revision(3, true), keyValue("a", "", 3, 0)},
+				{revision(4, false), keyValue("b", "2", 4, 0)},
+			}},
+			want: []Entry{{Key: "b", Value: []byte("2"), ModRevision: 4}},
+		},
+		{
+			name: "keys set again after being deleted",
+			buckets: map[string][]record{"key": {
+				{revision(2, false), keyValue("a", "1", 2, 0)},
+				{revision(3, true), keyValue("a", "", 3, 0)},
+				{revision(4, false), keyValue("a", "2", 4, 0)},
+			}},
+			want: []Entry{{Key: "a", Value: []byte("2"), ModRevision: 4}},
+		},
+		{
+			name: "leases",
+			buckets: map[string][]record{
+				"key": {
+					{revision(2, false), keyValue("a", "1", 2, 7)},
+					{revision(3, false), keyValue("b", "2", 3, 8)},
+					{revision(4, false), keyValue("c", "3", 4, 9)},
+				},
+				"lease": {{leaseKey, leaseValue}, {checkpointKey, checkpointValue}},
+			},
+			want: []Entry{
+				{Key: "a", Value: []byte("1"), ModRevision: 2, Lease: 7, TTL: 60 * time.Second},
+				{Key: "b", Value: []byte("2"), ModRevision: 3, Lease: 8, TTL: 15 * time.Second},
+				{Key: "c", Value: []byte("3"), ModRevision: 4, Lease: 9},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errRead := readAll(snapshot(t, tt.buckets))
+			if errRead != nil {
+				t.Fatal(errRead)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		buckets     map[string][]record
+		wantMessage string
+	}{
+		{name: "no key bucket", buckets: map[string][]record{"meta": nil}, wantMessage: "isn't an etcd snapshot"},
+		{
+			name:        "invalid revision",
+			buckets:     map[string][]record{"key": {{[]byte("short"), keyValue("a", "1", 1, 0)}}},
+			wantMessage: "invalid revision",
+		},
+		{
+			name:        "corrupted key-value",
+			buckets:     map[string][]record{"key": {{revision(1, false), []byte{0x0A, 0x05, 'a'}}}},
+			wantMessage: "couldn't decode key-value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errRead := readAll(snapshot(t, tt.buckets))
+			if errRead == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(errRead.Error(), tt.wantMessage) {
+				t.Errorf("got error %q, want it to contain %q", errRead, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestReadStopsOnCallbackError(t *testing.T) {
+	path := snapshot(t, map[string][]record{"key": {
+		{revision(2, false), keyValue("a", "1", 2, 0)},
+		{revision(3, false), keyValue("b", "2", 3, 0)},
+	}})
+	errStop := errors.New("stop")
+	calls := 0
+	errRead := Read(path, func(e Entry) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(errRead, errStop) || calls != 1 {
+		t.Errorf("got error %v after %d calls, want %v after 1", errRead, calls, errStop)
+	}
+}
+
+func TestReadMissingFile(t *testing.T) {
+	if _, errRead := readAll(filepath.Join(t.TempDir(), "missing.db")); errRead == nil {
+		t.Error("expected an error for a missing snapshot")
+	}
+}
+
+func TestReadMalformedSnapshots(t *testing.T) {
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	if errWrite := os.WriteFile(garbage, bytes.Repeat([]byte("not a snapshot"), 1024), 0o600); errWrite != nil {
+		t.Fatal(errWrite)
+	}
+	if _, errRead := readAll(garbage); errRead == nil || !strings.Contains(errRead.Error(), "couldn't open the snapshot") {
+		t.Errorf("got error %v for a file which isn't a bbolt database", errRead)
+	}
+
+	corruptedLease := snapshot(t, map[string][]record{
+		"key":   {{revision(1, false), keyValue("a", "1", 1, 7)}},
+		"lease": {{[]byte{0, 0, 0, 0, 0, 0, 0, 7}, []byte{0x08}}},
+	})
+	if _, errRead := readAll(corruptedLease); errRead == nil || !strings.Contains(errRead.Error(), "couldn't decode lease") {
+		t.Errorf("got error %v for a corrupted lease", errRead)
+	}
+}
@@ -0,0 +1,225 @@
+// Package featureflag evaluates feature flag documents for the attributes of a subject, like a user,
+// so the clients don't need to implement the evaluation themselves.
+//
+// A flag returns the value of its first rule whose conditions match, then the value of its rollout
+// if the subject falls inside its percentage, and otherwise its default value.
+package featureflag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Reasons a value was chosen.
+const (
+	ReasonDisabled = "disabled"
+	ReasonRule     = "rule"
+	ReasonRollout  = "rollout"
+	ReasonDefault  = "default"
+)
+
+// Operators of the conditions.
+const (
+	OpEquals     = "eq"
+	OpNotEquals  = "neq"
+	OpIn         = "in"
+	OpNotIn      = "notIn"
+	OpContains   = "contains"
+	OpStartsWith = "startsWith"
+	OpGreater    = "gt"
+	OpLower      = "lt"
+	OpExists     = "exists"
+)
+
+// Flag is a feature flag document.
+type Flag struct {
+	Enabled bool `json:"enabled"`
+	// Default is the value of the flag when it's disabled, or when neither its rules nor its rollout match.
+	Default any      `json:"default"`
+	Rules   []Rule   `json:"rules,omitempty"`
+	Rollout *Rollout `json:"rollout,omitempty"`
+}
+
+// Rule returns its value when all its conditions match.
+type Rule struct {
+	Conditions []Condition `json:"conditions"`
+	Value      any         `json:"value"`
+}
+
+// Condition compares an attribute of the subject with some values.
+type Condition struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values,omitempty"`
+}
+
+// Rollout returns its value to a percentage of the subjects.
+//
+// The subjects are assigned by the hash of the flag's name and their By attribute,
+// so the same subject always gets the same result, and increasing the percentage only adds subjects.
+type Rollout struct {
+	Percentage float64 `json:"percentage"`
+	By         string  `json:"by"`
+	Value      any     `json:"value"`
+}
+
+// Result is the value of a flag for a subject.
+type Result struct {
+	Value  any    `json:"value"`
+	Reason string `json:"reason"`
+	// Rule is the index of the rule which matched, -1 if none did.
+	Rule int `json:"rule"`
+}
+
+// Parse decodes and validates a flag document.
+func Parse(doc any) (Flag, error) {
+	b, errMarshal := json.Marshal(doc)
+	if errMarshal != nil {
+		return Flag{}, errMarshal
+	}
+	var f Flag
+	errUnmarshal := json.Unmarshal(b, &f)
+	if errUnmarshal != nil {
+		return Flag{}, fmt.Errorf("invalid flag document: %w", errUnmarshal)
+	}
+	return f, f.Validate()
+}
+
+// Validate returns an error if the flag has an unknown operator, or a rollout without a valid percentage.
+func (f Flag) Validate() error {
+	for i, rule := range f.Rules {
+		for _, c := range rule.Conditions {
+			if c.Attribute == "" {
+				return fmt.Errorf("rule %v has a condition without attribute", i)
+			}
+			if !isOperator(c.Operator) {
+				return fmt.Errorf("rule %v has an unknown operator: %s", i, c.Operator)
+			}
+		}
+	}
+	if f.Rollout != nil {
+		if f.Rollout.Percentage < 0 || f.Rollout.Percentage > 100 {
+			return errors.New("the rollout's percentage must be between 0 and 100")
+		}
+		if f.Rollout.By == "" {
+			return errors.New("the rollout must have the attribute it's assigned by")
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the value of a flag for the attributes of a subject.
+func (f Flag) Evaluate(name string, attrs map[string]string) Result {
+	if !f.Enabled {
+		return Result{Value: f.Default, Reason: ReasonDisabled, Rule: -1}
+	}
+	for i, rule := range f.Rules {
+		if matchesAll(rule.Conditions, attrs) {
+			return Result{Value: rule.Value, Reason: ReasonRule, Rule: i}
+		}
+	}
+	if f.Rollout != nil {
+		subject, ok := attrs[f.Rollout.By]
+		if ok && bucketOf(name, subject) < f.Rollout.Percentage {
+			return Result{Value: f.Rollout.Value, Reason: ReasonRollout, Rule: -1}
+		}
+	}
+	return Result{Value: f.Default, Reason: ReasonDefault, Rule: -1}
+}
+
+// ParseAttrs parses the attributes of a subject, written as a comma separated list of name:value pairs.
+func ParseAttrs(raw string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	if raw == "" {
+		return attrs, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		name, value, found := strings.Cut(pair, ":")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid attribute '%s', it must be name:value", pair)
+		}
+		attrs[name] = value
+	}
+	return attrs, nil
+}
+
+func matchesAll(conditions []Condition, attrs map[string]string) bool {
+	for _, c := range conditions {
+		if !matches(c, attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+func matches(c Condition, attrs map[string]string) bool {
+	attr, ok := attrs[c.Attribute]
+	if c.Operator == OpExists {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+
+	switch c.Operator {
+	case OpEquals, OpIn:
+		return contains(c.Values, attr)
+	case OpNotEquals, OpNotIn:
+		return !contains(c.Values, attr)
+	case OpContains:
+		return anyValue(c.Values, func(v string) bool { return strings.Contains(attr, v) })
+	case OpStartsWith:
+		return anyValue(c.Values, func(v string) bool { return strings.HasPrefix(attr, v) })
+	case OpGreater, OpLower:
+		n, errParse := strconv.ParseFloat(attr, 64)
+		if errParse != nil {
+			return false
+		}
+		return anyValue(c.Values, func(v string) bool {
+			limit, errLimit := strconv.ParseFloat(v, 64)
+			if errLimit != nil {
+				return false
+			}
+			if c.Operator == OpGreater {
+				return n > limit
+			}
+			return n < limit
+		})
+	default:
+		return false
+	}
+}
+
+func contains(values []string, s string) bool {
+	return anyValue(values, func(v string) bool { return v == s })
+}
+
+func anyValue(values []string, fn func(v string) bool) bool {
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketOf returns the position of a subject in the flag's rollout, between 0 and 100.
+func bucketOf(name string, subject string) float64 {
+	const buckets = 10000
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name + "/" + subject))
+	return float64(h.Sum32()%buckets) / (buckets / 100)
+}
+
+func isOperator(op string) bool {
+	switch op {
+	case OpEquals, OpNotEquals, OpIn, OpNotIn, OpContains, OpStartsWith, OpGreater, OpLower, OpExists:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,622 @@
+// Package jsonschema validates JSON documents against a JSON Schema.
+//
+// It implements the validation keywords shared by the drafts 4 to 2020-12, and local references ("#/definitions/x").
+// The keywords it doesn't know, like format or the remote references, are ignored, as the annotations are.
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDepth is the maximum depth of nested schemas followed while validating, so circular references can't loop forever.
+const maxDepth = 256
+
+// ErrInvalidSchema is returned when compiling a document which isn't a valid schema.
+var ErrInvalidSchema = errors.New("invalid JSON schema")
+
+// Schema is a compiled JSON Schema, it's safe for concurrent use.
+type Schema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// ValidationError describes the first part of a document which doesn't match the schema.
+type ValidationError struct {
+	// Path is the JSON pointer to the invalid value, empty for the document itself.
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Compile parses a JSON Schema, checking its patterns and references.
+func Compile(doc []byte) (*Schema, error) {
+	var root any
+	errUnmarshal := json.Unmarshal(doc, &root)
+	if errUnmarshal != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, errUnmarshal)
+	}
+	s := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	errCheck := s.check(root, "")
+	if errCheck != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, errCheck)
+	}
+	return s, nil
+}
+
+// Validate checks a document decoded with encoding/json against the schema.
+func (s *Schema) Validate(value any) error {
+	return s.validate(s.root, value, "", 0)
+}
+
+// ValidateJSON checks a JSON document against the schema.
+func (s *Schema) ValidateJSON(doc []byte) error {
+	var value any
+	errUnmarshal := json.Unmarshal(doc, &value)
+	if errUnmarshal != nil {
+		return &ValidationError{Message: "the value isn't valid JSON"}
+	}
+	return s.Validate(value)
+}
+
+// Keywords whose value holds nested schemas, which are checked when compiling.
+var (
+	schemaKeywords = map[string]bool{
+		"items": true, "additionalItems": true, "additionalProperties": true, "contains": true, "propertyNames": true,
+		"not": true, "if": true, "then": true, "else": true,
+	}
+	schemaListKeywords = map[string]bool{"allOf": true, "anyOf": true, "oneOf": true, "prefixItems": true, "items": true}
+	schemaMapKeywords  = map[string]bool{
+		"properties": true, "patternProperties": true, "definitions": true, "$defs": true, "dependentSchemas": true,
+		"dependencies": true,
+	}
+)
+
+// check walks a schema, compiling its patterns and resolving its references.
+func (s *Schema) check(schema any, path string) error {
+	if _, isBool := schema.(bool); isBool {
+		return nil
+	}
+	m, isMap := schema.(map[string]any)
+	if !isMap {
+		if path == "" {
+			return errors.New("the schema must be an object or a boolean")
+		}
+		return fmt.Errorf("%s must be a schema", path)
+	}
+
+	for keyword, sub := range m {
+		subPath := path + "/" + escapePointer(keyword)
+		switch keyword {
+		case "type":
+			errType := checkType(sub)
+			if errType != nil {
+				return fmt.Errorf("%s: %v", subPath, errType)
+			}
+		case "pattern":
+			pattern, isStr := sub.(string)
+			if !isStr {
+				return fmt.Errorf("%s must be a string", subPath)
+			}
+			errPattern := s.compilePattern(pattern)
+			if errPattern != nil {
+				return errPattern
+			}
+		case "$ref":
+			ref, isStr := sub.(string)
+			if !isStr {
+				return fmt.Errorf("%s must be a string", subPath)
+			}
+			_, errRef := s.resolve(ref)
+			if errRef != nil {
+				return errRef
+			}
+		case "patternProperties":
+			props, _ := sub.(map[string]any)
+			for pattern := range props {
+				errPattern := s.compilePattern(pattern)
+				if errPattern != nil {
+					return errPattern
+				}
+			}
+		}
+
+		list, isList := sub.([]any)
+		switch {
+		case isList && schemaListKeywords[keyword]:
+			for i, item := range list {
+				errCheck := s.check(item, subPath+"/"+strconv.Itoa(i))
+				if errCheck != nil {
+					return errCheck
+				}
+			}
+		case schemaKeywords[keyword]:
+			errCheck := s.check(sub, subPath)
+			if errCheck != nil {
+				return errCheck
+			}
+		case schemaMapKeywords[keyword]:
+			subs, isSubsMap := sub.(map[string]any)
+			if !isSubsMap {
+				return fmt.Errorf("%s must be an object", subPath)
+			}
+			for name, item := range subs {
+				// The dependencies can also be lists of names.
+				if _, isNames := item.([]any); isNames && keyword == "dependencies" {
+					continue
+				}
+				errCheck := s.check(item, subPath+"/"+escapePointer(name))
+				if errCheck != nil {
+					return errCheck
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// match returns whether a string matches a pattern, the patterns in parts of the schema which aren't checked
+// when compiling, like the ones only reachable by a reference, are compiled on the fly.
+func (s *Schema) match(pattern string, str string) bool {
+	re, exists := s.patterns[pattern]
+	if !exists {
+		var errCompile error
+		re, errCompile = regexp.Compile(pattern)
+		if errCompile != nil {
+			return false
+		}
+	}
+	return re.MatchString(str)
+}
+
+func (s *Schema) compilePattern(pattern string) error {
+	if _, exists := s.patterns[pattern]; exists {
+		return nil
+	}
+	re, errCompile := regexp.Compile(pattern)
+	if errCompile != nil {
+		return fmt.Errorf("pattern '%s' isn't valid: %v", pattern, errCompile)
+	}
+	s.patterns[pattern] = re
+	return nil
+}
+
+// resolve returns the schema a local reference points to.
+func (s *Schema) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("reference '%s' isn't supported, only local references are", ref)
+	}
+	target := s.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer == "" {
+		return target, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("reference '%s' isn't a JSON pointer", ref)
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := target.(type) {
+		case map[string]any:
+			sub, exists := v[token]
+			if !exists {
+				return nil, fmt.Errorf("reference '%s' doesn't exist", ref)
+			}
+			target = sub
+		case []any:
+			i, errAtoi := strconv.Atoi(token)
+			if errAtoi != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("reference '%s' doesn't exist", ref)
+			}
+			target = v[i]
+		default:
+			return nil, fmt.Errorf("reference '%s' doesn't exist", ref)
+		}
+	}
+	return target, nil
+}
+
+func (s *Schema) validate(schema any, value any, path string, depth int) error {
+	if depth > maxDepth {
+		return &ValidationError{Path: path, Message: "the schema is nested too deep"}
+	}
+	switch v := schema.(type) {
+	case bool:
+		if !v {
+			return &ValidationError{Path: path, Message: "no value is allowed"}
+		}
+		return nil
+	case map[string]any:
+		return s.validateObjectSchema(v, value, path, depth)
+	}
+	return nil
+}
+
+func (s *Schema) validateObjectSchema(schema map[string]any, value any, path string, depth int) error {
+	fail := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, errRef := s.resolve(ref)
+		if errRef != nil {
+			return fail("%v", errRef)
+		}
+		errValidate := s.validate(target, value, path, depth+1)
+		if errValidate != nil {
+			return errValidate
+		}
+	}
+
+	if t, exists := schema["type"]; exists && !matchesType(t, value) {
+		return fail("must be of type %v, got %s", t, typeOf(value))
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if equal(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("must be one of %s", toJSON(enum))
+		}
+	}
+	if c, exists := schema["const"]; exists && !equal(c, value) {
+		return fail("must be %s", toJSON(c))
+	}
+
+	switch v := value.(type) {
+	case float64:
+		errNumber := validateNumber(schema, v, fail)
+		if errNumber != nil {
+			return errNumber
+		}
+	case string:
+		errString := s.validateString(schema, v, fail)
+		if errString != nil {
+			return errString
+		}
+	case []any:
+		errArray := s.validateArray(schema, v, path, depth)
+		if errArray != nil {
+			return errArray
+		}
+	case map[string]any:
+		errObject := s.validateObject(schema, v, path, depth)
+		if errObject != nil {
+			return errObject
+		}
+	}
+
+	return s.validateCombinations(schema, value, path, depth)
+}
+
+func validateNumber(schema map[string]any, n float64, fail func(string, ...any) error) error {
+	if minimum, ok := schema["minimum"].(float64); ok {
+		// Draft 4 declares exclusiveMinimum as a boolean modifying minimum.
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive && n <= minimum {
+			return fail("must be greater than %v", minimum)
+		}
+		if n < minimum {
+			return fail("must be greater than or equal to %v", minimum)
+		}
+	}
+	if maximum, ok := schema["maximum"].(float64); ok {
+		if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive && n >= maximum {
+			return fail("must be lower than %v", maximum)
+		}
+		if n > maximum {
+			return fail("must be lower than or equal to %v", maximum)
+		}
+	}
+	if minimum, ok := schema["exclusiveMinimum"].(float64); ok && n <= minimum {
+		return fail("must be greater than %v", minimum)
+	}
+	if maximum, ok := schema["exclusiveMaximum"].(float64); ok && n >= maximum {
+		return fail("must be lower than %v", maximum)
+	}
+	if multipleOf, ok := schema["multipleOf"].(float64); ok && multipleOf > 0 {
+		quotient := n / multipleOf
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			return fail("must be a multiple of %v", multipleOf)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(schema map[string]any, str string, fail func(string, ...any) error) error {
+	length := float64(utf8.RuneCountInString(str))
+	if minLength, ok := schema["minLength"].(float64); ok && length < minLength {
+		return fail("must be at least %v characters long", minLength)
+	}
+	if maxLength, ok := schema["maxLength"].(float64); ok && length > maxLength {
+		return fail("must be at most %v characters long", maxLength)
+	}
+	if pattern, ok := schema["pattern"].(string); ok && !s.match(pattern, str) {
+		return fail("must match the pattern '%s'", pattern)
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(schema map[string]any, list []any, path string, depth int) error {
+	fail := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	length := float64(len(list))
+	if minItems, ok := schema["minItems"].(float64); ok && length < minItems {
+		return fail("must have at least %v items", minItems)
+	}
+	if maxItems, ok := schema["maxItems"].(float64); ok && length > maxItems {
+		return fail("must have at most %v items", maxItems)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if equal(list[i], list[j]) {
+					return fail("items %v and %v are equal, they must be unique", i, j)
+				}
+			}
+		}
+	}
+
+	// The items are validated by position against prefixItems, or items when it's a list (draft 4 to 2019-09),
+	// the rest against items or additionalItems.
+	positional, _ := schema["prefixItems"].([]any)
+	rest, hasRest := schema["items"]
+	if list, isList := rest.([]any); isList {
+		positional = list
+		rest, hasRest = schema["additionalItems"]
+	}
+	for i, item := range list {
+		itemPath := path + "/" + strconv.Itoa(i)
+		var errItem error
+		if i < len(positional) {
+			errItem = s.validate(positional[i], item, itemPath, depth+1)
+		} else if hasRest {
+			errItem = s.validate(rest, item, itemPath, depth+1)
+		}
+		if errItem != nil {
+			return errItem
+		}
+	}
+
+	if contains, exists := schema["contains"]; exists {
+		found := false
+		for i, item := range list {
+			if s.validate(contains, item, path+"/"+strconv.Itoa(i), depth+1) == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("must contain an item matching the schema of contains")
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(schema map[string]any, obj map[string]any, path string, depth int) error {
+	fail := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	count := float64(len(obj))
+	if minProperties, ok := schema["minProperties"].(float64); ok && count < minProperties {
+		return fail("must have at least %v properties", minProperties)
+	}
+	if maxProperties, ok := schema["maxProperties"].(float64); ok && count > maxProperties {
+		return fail("must have at most %v properties", maxProperties)
+	}
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if nameStr, isStr := name.(string); isStr {
+				if _, exists := obj[nameStr]; !exists {
+					return fail("property '%s' is required", nameStr)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	patternProperties, _ := schema["patternProperties"].(map[string]any)
+	additional, hasAdditional := schema["additionalProperties"]
+	propertyNames, hasPropertyNames := schema["propertyNames"]
+	for name, propValue := range obj {
+		propPath := path + "/" + escapePointer(name)
+		if hasPropertyNames && s.validate(propertyNames, name, propPath, depth+1) != nil {
+			return &ValidationError{Path: propPath, Message: "the property name doesn't match the schema of propertyNames"}
+		}
+
+		matched := false
+		if sub, exists := properties[name]; exists {
+			matched = true
+			errProp := s.validate(sub, propValue, propPath, depth+1)
+			if errProp != nil {
+				return errProp
+			}
+		}
+		for pattern, sub := range patternProperties {
+			if !s.match(pattern, name) {
+				continue
+			}
+			matched = true
+			errProp := s.validate(sub, propValue, propPath, depth+1)
+			if errProp != nil {
+				return errProp
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				return &ValidationError{Path: propPath, Message: "additional properties aren't allowed"}
+			}
+			errProp := s.validate(additional, propValue, propPath, depth+1)
+			if errProp != nil {
+				return errProp
+			}
+		}
+	}
+
+	// dependentRequired (draft 2019-09) replaced the lists of names of dependencies.
+	for _, keyword := range []string{"dependentRequired", "dependencies"} {
+		deps, _ := schema[keyword].(map[string]any)
+		for name, dep := range deps {
+			if _, exists := obj[name]; !exists {
+				continue
+			}
+			names, isList := dep.([]any)
+			if !isList {
+				errDep := s.validate(dep, obj, path, depth+1)
+				if errDep != nil {
+					return errDep
+				}
+				continue
+			}
+			for _, required := range names {
+				requiredStr, _ := required.(string)
+				if _, exists := obj[requiredStr]; !exists {
+					return fail("property '%s' is required when '%s' is present", requiredStr, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateCombinations(schema map[string]any, value any, path string, depth int) error {
+	fail := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			errSub := s.validate(sub, value, path, depth+1)
+			if errSub != nil {
+				return errSub
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if s.validate(sub, value, path, depth+1) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fail("must match at least one schema of anyOf")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if s.validate(sub, value, path, depth+1) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fail("must match exactly one schema of oneOf, matches %v", matches)
+		}
+	}
+	if not, exists := schema["not"]; exists && s.validate(not, value, path, depth+1) == nil {
+		return fail("must not match the schema of not")
+	}
+	if cond, exists := schema["if"]; exists {
+		branch := "else"
+		if s.validate(cond, value, path, depth+1) == nil {
+			branch = "then"
+		}
+		if sub, exists := schema[branch]; exists {
+			return s.validate(sub, value, path, depth+1)
+		}
+	}
+	return nil
+}
+
+func checkType(t any) error {
+	switch v := t.(type) {
+	case string:
+		if !knownType(v) {
+			return fmt.Errorf("unknown type '%s'", v)
+		}
+		return nil
+	case []any:
+		for _, name := range v {
+			nameStr, isStr := name.(string)
+			if !isStr || !knownType(nameStr) {
+				return fmt.Errorf("unknown type '%v'", name)
+			}
+		}
+		return nil
+	}
+	return errors.New("type must be a string or a list of strings")
+}
+
+func knownType(name string) bool {
+	switch name {
+	case "null", "boolean", "object", "array", "number", "integer", "string":
+		return true
+	}
+	return false
+}
+
+func matchesType(t any, value any) bool {
+	if list, isList := t.([]any); isList {
+		for _, name := range list {
+			if matchesType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	name, _ := t.(string)
+	actual := typeOf(value)
+	return actual == name || (name == "number" && actual == "integer")
+}
+
+func typeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func equal(a any, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func toJSON(value any) string {
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,45 @@
+package linearizability
+
+// KVInput is the input of an operation of the key-value model.
+type KVInput struct {
+	// Write is true for writes, and false for reads.
+	Write bool
+	Key   string
+	// Value is the value written, it's ignored on reads.
+	Value string
+}
+
+// KVOutput is the output of an operation of the key-value model, the value read, empty if the key didn't exist.
+type KVOutput struct {
+	Value string
+}
+
+// KVModel is a key-value store where each key is an independent register, and missing keys are read as empty.
+var KVModel = Model{
+	Partition: func(history []Operation) [][]Operation {
+		byKey := make(map[string][]Operation)
+		keys := make([]string, 0)
+		for _, op := range history {
+			k := op.Input.(KVInput).Key
+			if _, ok := byKey[k]; !ok {
+				keys = append(keys, k)
+			}
+			byKey[k] = append(byKey[k], op)
+		}
+		partitions := make([][]Operation, 0, len(keys))
+		for _, k := range keys {
+			partitions = append(partitions, byKey[k])
+		}
+		return partitions
+	},
+	Init: func() any {
+		return ""
+	},
+	Step: func(state any, input any, output any) (bool, any) {
+		in := input.(KVInput)
+		if in.Write {
+			return true, in.Value
+		}
+		return output.(KVOutput).Value == state.(string), state
+	},
+}
@@ -0,0 +1,194 @@
+// Package linearizability checks whether a concurrent history of operations is linearizable against a sequential model,
+// using the algorithm of Wing & Gong with Lowe's memoization, the same porcupine uses.
+package linearizability
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Unknown is the return time of an operation whose outcome isn't known, like a write which timed out.
+//
+// The operation may take effect at any point after it was called, or never.
+const Unknown = int64(1<<63 - 1)
+
+// Operation is an operation of the history, with the times it was called and it returned.
+type Operation struct {
+	ClientID int
+	Input    any
+	Call     int64
+	Output   any
+	Return   int64
+}
+
+// Model is the sequential specification the history is checked against.
+type Model struct {
+	// Partition splits the history in independent histories, like the operations of each key.
+	// If it's nil, the history is checked as a whole.
+	Partition func(history []Operation) [][]Operation
+	// Init returns the initial state, the states must be comparable.
+	Init func() any
+	// Step returns whether an operation with an output is valid on a state, and the state after it.
+	Step func(state any, input any, output any) (bool, any)
+}
+
+// Result is the result of checking a history.
+type Result struct {
+	Linearizable bool
+	// Failed is the partition which isn't linearizable.
+	Failed []Operation
+}
+
+// Check returns whether the history is linearizable.
+func Check(model Model, history []Operation) Result {
+	partitions := [][]Operation{history}
+	if model.Partition != nil {
+		partitions = model.Partition(history)
+	}
+
+	for _, partition := range partitions {
+		if !checkPartition(model, partition) {
+			return Result{Linearizable: false, Failed: partition}
+		}
+	}
+	return Result{Linearizable: true}
+}
+
+// entry is the call or the return of an operation, in a doubly linked list sorted by time.
+type entry struct {
+	id     int
+	isCall bool
+	time   int64
+	input  any
+	output any
+	match  *entry // the return of a call
+	prev   *entry
+	next   *entry
+}
+
+// cacheKey identifies a set of linearized operations with the state they lead to.
+type cacheKey struct {
+	linearized string
+	state      any
+}
+
+// frame is a linearized call, with the state before it, so it can be undone.
+type frame struct {
+	call  *entry
+	state any
+}
+
+func checkPartition(model Model, history []Operation) bool {
+	head := newEntryList(history)
+	linearized := newBitset(len(history))
+	cache := make(map[cacheKey]bool)
+	calls := make([]frame, 0, len(history))
+	state := model.Init()
+
+	e := head.next
+	for head.next != nil {
+		if e.isCall {
+			ok, newState := model.Step(state, e.input, e.match.output)
+			if ok {
+				linearized.set(e.id)
+				key := cacheKey{linearized: linearized.String(), state: newState}
+				if !cache[key] {
+					cache[key] = true
+					calls = append(calls, frame{call: e, state: state})
+					state = newState
+					lift(e)
+					e = head.next
+					continue
+				}
+				linearized.clear(e.id)
+			}
+			e = e.next
+			continue
+		}
+
+		// A return was reached before its call could be linearized, so the last linearized call is undone.
+		if len(calls) == 0 {
+			return false
+		}
+		top := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		state = top.state
+		linearized.clear(top.call.id)
+		unlift(top.call)
+		e = top.call.next
+	}
+	return true
+}
+
+// newEntryList returns the head of the list with the calls and returns of the history, sorted by time.
+//
+// Calls are sorted before returns at the same time, so those operations are considered concurrent.
+func newEntryList(history []Operation) *entry {
+	entries := make([]*entry, 0, len(history)*2)
+	for i, op := range history {
+		ret := &entry{id: i, time: op.Return, output: op.Output}
+		call := &entry{id: i, isCall: true, time: op.Call, input: op.Input, match: ret}
+		entries = append(entries, call, ret)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+		return entries[i].isCall && !entries[j].isCall
+	})
+
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+	return head
+}
+
+// lift removes a call and its return from the list.
+func lift(call *entry) {
+	call.prev.next = call.next
+	call.next.prev = call.prev
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// unlift inserts back a call and its return in the list, it must be called in the inverse order of lift.
+func unlift(call *entry) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	call.next.prev = call
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << (i % 64)
+}
+
+func (b bitset) clear(i int) {
+	b[i/64] &^= 1 << (i % 64)
+}
+
+func (b bitset) String() string {
+	var sb strings.Builder
+	for _, word := range b {
+		sb.WriteString(strconv.FormatUint(word, 36))
+		sb.WriteByte('.')
+	}
+	return sb.String()
+}
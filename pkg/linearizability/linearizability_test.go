@@ -0,0 +1,193 @@
+package linearizability
+
+import "testing"
+
+func write(client int, key string, value string, call int64, ret int64) Operation {
+	return Operation{ClientID: client, Input: KVInput{Write: true, Key: key, Value: value}, Call: call, Return: ret}
+}
+
+func read(client int, key string, value string, call int64, ret int64) Operation {
+	return Operation{ClientID: client, Input: KVInput{Key: key}, Call: call, Output: KVOutput{Value: value}, Return: ret}
+}
+
+func TestCheckKV(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []Operation
+		want    bool
+	}{
+		{
+			name: "empty history",
+			want: true,
+		},
+		{
+			name:    "read of a missing key",
+			history: []Operation{read(0, "k", "", 0, 1)},
+			want:    true,
+		},
+		{
+			name:    "sequential write and read",
+			history: []Operation{write(0, "k", "1", 0, 1), read(1, "k", "1", 2, 3)},
+			want:    true,
+		},
+		{
+			name:    "stale read after the write returned",
+			history: []Operation{write(0, "k", "1", 0, 1), read(1, "k", "", 2, 3)},
+			want:    false,
+		},
+		{
+			name:    "read of a value never written",
+			history: []Operation{write(0, "k", "1", 0, 1), read(1, "k", "2", 2, 3)},
+			want:    false,
+		},
+		{
+			name:    "concurrent read sees the old value",
+			history: []Operation{write(0, "k", "1", 0, 10), read(1, "k", "", 1, 2)},
+			want:    true,
+		},
+		{
+			name:    "concurrent read sees the new value",
+			history: []Operation{write(0, "k", "1", 0, 10), read(1, "k", "1", 1, 2)},
+			want:    true,
+		},
+		{
+			name: "new value seen, then the old one by a later read",
+			history: []Operation{
+				write(0, "k", "1", 0, 10),
+				read(1, "k", "1", 1, 2),
+				read(2, "k", "", 3, 4),
+			},
+			want: false,
+		},
+		{
+			name: "concurrent writes seen in either order",
+			history: []Operation{
+				write(0, "k", "1", 0, 5),
+				write(1, "k", "2", 0, 5),
+				read(2, "k", "1", 6, 7),
+			},
+			want: true,
+		},
+		{
+			name: "values swapped by sequential reads",
+			history: []Operation{
+				write(0, "k", "1", 0, 5),
+				write(1, "k", "2", 0, 5),
+				read(2, "k", "1", 6, 7),
+				read(2, "k", "2", 8, 9),
+				read(2, "k", "1", 10, 11),
+			},
+			want: false,
+		},
+		{
+			name:    "write with an unknown outcome which took effect",
+			history: []Operation{write(0, "k", "1", 0, Unknown), read(1, "k", "1", 5, 6)},
+			want:    true,
+		},
+		{
+			name:    "write with an unknown outcome which never took effect",
+			history: []Operation{write(0, "k", "1", 0, Unknown), read(1, "k", "", 5, 6)},
+			want:    true,
+		},
+		{
+			name: "write with an unknown outcome seen, then lost",
+			history: []Operation{
+				write(0, "k", "1", 0, Unknown),
+				read(1, "k", "1", 5, 6),
+				read(1, "k", "", 7, 8),
+			},
+			want: false,
+		},
+		{
+			name: "independent keys",
+			history: []Operation{
+				write(0, "a", "1", 0, 1),
+				write(1, "b", "2", 0, 1),
+				read(2, "a", "1", 2, 3),
+				read(2, "b", "2", 2, 3),
+			},
+			want: true,
+		},
+		{
+			name: "calls and returns at the same time are concurrent",
+			history: []Operation{
+				write(0, "k", "1", 0, 5),
+				read(1, "k", "", 5, 6),
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Check(KVModel, tt.history)
+			if got.Linearizable != tt.want {
+				t.Errorf("got linearizable %v, want %v", got.Linearizable, tt.want)
+			}
+			if !got.Linearizable && len(got.Failed) == 0 {
+				t.Error("the partition which failed wasn't returned")
+			}
+		})
+	}
+}
+
+func TestCheckReturnsFailedPartition(t *testing.T) {
+	history := []Operation{
+		write(0, "a", "1", 0, 1),
+		read(1, "a", "1", 2, 3),
+		write(0, "b", "1", 0, 1),
+		read(1, "b", "", 2, 3),
+	}
+	got := Check(KVModel, history)
+	if got.Linearizable {
+		t.Fatal("expected the history not to be linearizable")
+	}
+	for _, op := range got.Failed {
+		if k := op.Input.(KVInput).Key; k != "b" {
+			t.Errorf("the failed partition has an operation of key %q, want only %q", k, "b")
+		}
+	}
+}
+
+func TestCheckWithoutPartition(t *testing.T) {
+	// A counter which is incremented and read, checked as a single history.
+	model := Model{
+		Init: func() any { return 0 },
+		Step: func(state any, input any, output any) (bool, any) {
+			if input == "inc" {
+				return true, state.(int) + 1
+			}
+			return output == state, state
+		},
+	}
+	tests := []struct {
+		name    string
+		history []Operation
+		want    bool
+	}{
+		{
+			name: "concurrent increments read once",
+			history: []Operation{
+				{Input: "inc", Call: 0, Return: 5},
+				{Input: "inc", Call: 1, Return: 6},
+				{Input: "read", Output: 2, Call: 7, Return: 8},
+			},
+			want: true,
+		},
+		{
+			name: "increment lost",
+			history: []Operation{
+				{Input: "inc", Call: 0, Return: 5},
+				{Input: "inc", Call: 1, Return: 6},
+				{Input: "read", Output: 1, Call: 7, Return: 8},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Check(model, tt.history).Linearizable; got != tt.want {
+				t.Errorf("got linearizable %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,679 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Control flow of the executed statements.
+const (
+	ctrlNone = iota
+	ctrlBreak
+	ctrlReturn
+)
+
+type scope struct {
+	vars   map[string]*any
+	parent *scope
+	// isFunction marks the scope of a function's body, which holds its variable arguments.
+	isFunction bool
+	varargs    []any
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: make(map[string]*any), parent: parent}
+}
+
+func (s *scope) lookup(name string) *any {
+	for current := s; current != nil; current = current.parent {
+		if v, exists := current.vars[name]; exists {
+			return v
+		}
+	}
+	return nil
+}
+
+func (s *scope) declare(name string, value any) {
+	v := value
+	s.vars[name] = &v
+}
+
+type interp struct {
+	globals *Table
+	steps   int
+	depth   int
+}
+
+func (in *interp) charge(n int, line int) error {
+	in.steps += n
+	if in.steps > MaxSteps {
+		return &Error{Line: line, Message: ErrStepLimit.Error(), Err: ErrStepLimit}
+	}
+	return nil
+}
+
+func runtimeError(line int, format string, args ...any) error {
+	return &Error{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+func (in *interp) exec(b *block, env *scope) (int, []any, error) {
+	for _, s := range b.stmts {
+		ctrl, values, errStmt := in.execStmt(s, env)
+		if errStmt != nil || ctrl != ctrlNone {
+			return ctrl, values, errStmt
+		}
+	}
+	return ctrlNone, nil, nil
+}
+
+func (in *interp) execStmt(s stmt, env *scope) (int, []any, error) {
+	if errCharge := in.charge(1, stmtLine(s)); errCharge != nil {
+		return ctrlNone, nil, errCharge
+	}
+	switch s := s.(type) {
+	case *localStmt:
+		values, errEval := in.evalList(s.values, env)
+		if errEval != nil {
+			return ctrlNone, nil, errEval
+		}
+		for i, name := range s.names {
+			env.declare(name, valueAt(values, i))
+		}
+	case *localFunctionStmt:
+		env.declare(s.name, nil)
+		*env.vars[s.name] = &closure{fn: s.fn, env: env}
+	case *assignStmt:
+		return ctrlNone, nil, in.assign(s, env)
+	case *callStmt:
+		_, errCall := in.evalMulti(s.call, env)
+		return ctrlNone, nil, errCall
+	case *doStmt:
+		return in.exec(s.body, newScope(env))
+	case *whileStmt:
+		for {
+			// Every iteration is a step, so the loops with an empty body are limited too.
+			if errCharge := in.charge(1, 0); errCharge != nil {
+				return ctrlNone, nil, errCharge
+			}
+			cond, errCond := in.eval(s.cond, env)
+			if errCond != nil {
+				return ctrlNone, nil, errCond
+			}
+			if !truthy(cond) {
+				return ctrlNone, nil, nil
+			}
+			ctrl, values, errBody := in.exec(s.body, newScope(env))
+			if errBody != nil || ctrl == ctrlReturn {
+				return ctrl, values, errBody
+			}
+			if ctrl == ctrlBreak {
+				return ctrlNone, nil, nil
+			}
+		}
+	case *repeatStmt:
+		for {
+			if errCharge := in.charge(1, 0); errCharge != nil {
+				return ctrlNone, nil, errCharge
+			}
+			// The condition can use the locals of the body.
+			bodyEnv := newScope(env)
+			ctrl, values, errBody := in.exec(s.body, bodyEnv)
+			if errBody != nil || ctrl == ctrlReturn {
+				return ctrl, values, errBody
+			}
+			if ctrl == ctrlBreak {
+				return ctrlNone, nil, nil
+			}
+			cond, errCond := in.eval(s.cond, bodyEnv)
+			if errCond != nil {
+				return ctrlNone, nil, errCond
+			}
+			if truthy(cond) {
+				return ctrlNone, nil, nil
+			}
+		}
+	case *ifStmt:
+		for i, condExpr := range s.conds {
+			cond, errCond := in.eval(condExpr, env)
+			if errCond != nil {
+				return ctrlNone, nil, errCond
+			}
+			if truthy(cond) {
+				return in.exec(s.blocks[i], newScope(env))
+			}
+		}
+		if s.elseBlock != nil {
+			return in.exec(s.elseBlock, newScope(env))
+		}
+	case *numericForStmt:
+		return in.numericFor(s, env)
+	case *genericForStmt:
+		return in.genericFor(s, env)
+	case *returnStmt:
+		values, errEval := in.evalList(s.values, env)
+		return ctrlReturn, values, errEval
+	case *breakStmt:
+		return ctrlBreak, nil, nil
+	}
+	return ctrlNone, nil, nil
+}
+
+func stmtLine(s stmt) int {
+	switch s := s.(type) {
+	case *assignStmt:
+		return s.line
+	case *numericForStmt:
+		return s.line
+	case *genericForStmt:
+		return s.line
+	case *callStmt:
+		return exprLine(s.call)
+	}
+	return 0
+}
+
+func exprLine(e expr) int {
+	switch e := e.(type) {
+	case *callExpr:
+		return e.line
+	case *methodCallExpr:
+		return e.line
+	case *nameExpr:
+		return e.line
+	case *indexExpr:
+		return e.line
+	case *binaryExpr:
+		return e.line
+	case *unaryExpr:
+		return e.line
+	}
+	return 0
+}
+
+func (in *interp) assign(s *assignStmt, env *scope) error {
+	values, errEval := in.evalList(s.values, env)
+	if errEval != nil {
+		return errEval
+	}
+	for i, target := range s.targets {
+		value := valueAt(values, i)
+		switch t := target.(type) {
+		case *nameExpr:
+			if v := env.lookup(t.name); v != nil {
+				*v = value
+				continue
+			}
+			in.globals.set(t.name, value)
+		case *indexExpr:
+			obj, errObj := in.eval(t.obj, env)
+			if errObj != nil {
+				return errObj
+			}
+			key, errKey := in.eval(t.key, env)
+			if errKey != nil {
+				return errKey
+			}
+			errSet := setIndex(obj, key, value, t.line)
+			if errSet != nil {
+				return errSet
+			}
+		}
+	}
+	return nil
+}
+
+func setIndex(obj any, key any, value any, line int) error {
+	t, isTable := obj.(*Table)
+	if !isTable {
+		return runtimeError(line, "attempt to index a %s value", typeName(obj))
+	}
+	switch k := key.(type) {
+	case nil:
+		return runtimeError(line, "table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return runtimeError(line, "table index is NaN")
+		}
+	case string, bool:
+	default:
+		return runtimeError(line, "table keys must be numbers, strings or booleans, got %s", typeName(key))
+	}
+	t.set(key, value)
+	return nil
+}
+
+func (in *interp) index(obj any, key any, line int) (any, error) {
+	switch o := obj.(type) {
+	case *Table:
+		return o.get(key), nil
+	case string:
+		// The strings have the functions of the string library as methods.
+		lib, _ := in.globals.get("string").(*Table)
+		if lib == nil {
+			return nil, nil
+		}
+		return lib.get(key), nil
+	}
+	return nil, runtimeError(line, "attempt to index a %s value", typeName(obj))
+}
+
+func (in *interp) numericFor(s *numericForStmt, env *scope) (int, []any, error) {
+	bounds := make([]float64, 3)
+	for i, e := range []expr{s.start, s.limit, s.step} {
+		if e == nil {
+			bounds[i] = 1
+			continue
+		}
+		v, errEval := in.eval(e, env)
+		if errEval != nil {
+			return ctrlNone, nil, errEval
+		}
+		n, isNum := toNumber(v)
+		if !isNum {
+			return ctrlNone, nil, runtimeError(s.line, "'for' bounds must be numbers")
+		}
+		bounds[i] = n
+	}
+	start, limit, step := bounds[0], bounds[1], bounds[2]
+	if step == 0 {
+		return ctrlNone, nil, runtimeError(s.line, "'for' step is zero")
+	}
+	for i := start; (step > 0 && i <= limit) || (step < 0 && i >= limit); i += step {
+		if errCharge := in.charge(1, s.line); errCharge != nil {
+			return ctrlNone, nil, errCharge
+		}
+		bodyEnv := newScope(env)
+		bodyEnv.declare(s.name, i)
+		ctrl, values, errBody := in.exec(s.body, bodyEnv)
+		if errBody != nil || ctrl == ctrlReturn {
+			return ctrl, values, errBody
+		}
+		if ctrl == ctrlBreak {
+			break
+		}
+	}
+	return ctrlNone, nil, nil
+}
+
+func (in *interp) genericFor(s *genericForStmt, env *scope) (int, []any, error) {
+	values, errEval := in.evalList(s.exprs, env)
+	if errEval != nil {
+		return ctrlNone, nil, errEval
+	}
+	fn, state, control := valueAt(values, 0), valueAt(values, 1), valueAt(values, 2)
+	for {
+		results, errCall := in.call(fn, []any{state, control}, s.line)
+		if errCall != nil {
+			return ctrlNone, nil, errCall
+		}
+		control = valueAt(results, 0)
+		if control == nil {
+			return ctrlNone, nil, nil
+		}
+		bodyEnv := newScope(env)
+		for i, name := range s.names {
+			bodyEnv.declare(name, valueAt(results, i))
+		}
+		ctrl, returned, errBody := in.exec(s.body, bodyEnv)
+		if errBody != nil || ctrl == ctrlReturn {
+			return ctrl, returned, errBody
+		}
+		if ctrl == ctrlBreak {
+			return ctrlNone, nil, nil
+		}
+	}
+}
+
+// evalList evaluates a list of expressions, the last one can return several values.
+func (in *interp) evalList(exprs []expr, env *scope) ([]any, error) {
+	values := make([]any, 0, len(exprs))
+	for i, e := range exprs {
+		if i == len(exprs)-1 {
+			last, errEval := in.evalMulti(e, env)
+			if errEval != nil {
+				return nil, errEval
+			}
+			return append(values, last...), nil
+		}
+		v, errEval := in.eval(e, env)
+		if errEval != nil {
+			return nil, errEval
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// evalMulti evaluates an expression which can return several values: a call or "...".
+func (in *interp) evalMulti(e expr, env *scope) ([]any, error) {
+	switch e := e.(type) {
+	case *callExpr:
+		fn, errFn := in.eval(e.fn, env)
+		if errFn != nil {
+			return nil, errFn
+		}
+		args, errArgs := in.evalList(e.args, env)
+		if errArgs != nil {
+			return nil, errArgs
+		}
+		return in.call(fn, args, e.line)
+	case *methodCallExpr:
+		obj, errObj := in.eval(e.obj, env)
+		if errObj != nil {
+			return nil, errObj
+		}
+		fn, errIndex := in.index(obj, e.method, e.line)
+		if errIndex != nil {
+			return nil, errIndex
+		}
+		args, errArgs := in.evalList(e.args, env)
+		if errArgs != nil {
+			return nil, errArgs
+		}
+		return in.call(fn, append([]any{obj}, args...), e.line)
+	case *varargExpr:
+		for current := env; current != nil; current = current.parent {
+			if current.isFunction {
+				return append([]any(nil), current.varargs...), nil
+			}
+		}
+		return nil, nil
+	}
+	v, errEval := in.eval(e, env)
+	if errEval != nil {
+		return nil, errEval
+	}
+	return []any{v}, nil
+}
+
+func (in *interp) eval(e expr, env *scope) (any, error) {
+	switch e := e.(type) {
+	case *nilExpr:
+		return nil, nil
+	case *trueExpr:
+		return true, nil
+	case *falseExpr:
+		return false, nil
+	case *numberExpr:
+		return e.value, nil
+	case *stringExpr:
+		return e.value, nil
+	case *nameExpr:
+		if v := env.lookup(e.name); v != nil {
+			return *v, nil
+		}
+		return in.globals.get(e.name), nil
+	case *indexExpr:
+		obj, errObj := in.eval(e.obj, env)
+		if errObj != nil {
+			return nil, errObj
+		}
+		key, errKey := in.eval(e.key, env)
+		if errKey != nil {
+			return nil, errKey
+		}
+		return in.index(obj, key, e.line)
+	case *parenExpr:
+		return in.eval(e.inner, env)
+	case *functionExpr:
+		return &closure{fn: e, env: env}, nil
+	case *tableExpr:
+		return in.evalTable(e, env)
+	case *unaryExpr:
+		return in.evalUnary(e, env)
+	case *binaryExpr:
+		return in.evalBinary(e, env)
+	}
+	values, errEval := in.evalMulti(e, env)
+	if errEval != nil {
+		return nil, errEval
+	}
+	return valueAt(values, 0), nil
+}
+
+func (in *interp) evalTable(e *tableExpr, env *scope) (any, error) {
+	t := newTable()
+	n := 0
+	for i, valueExpr := range e.values {
+		keyExpr := e.keys[i]
+		if keyExpr != nil {
+			key, errKey := in.eval(keyExpr, env)
+			if errKey != nil {
+				return nil, errKey
+			}
+			value, errValue := in.eval(valueExpr, env)
+			if errValue != nil {
+				return nil, errValue
+			}
+			errSet := setIndex(t, key, value, e.line)
+			if errSet != nil {
+				return nil, errSet
+			}
+			continue
+		}
+		// The last positional field expands all the values it returns.
+		var values []any
+		if i == len(e.values)-1 {
+			var errValues error
+			values, errValues = in.evalMulti(valueExpr, env)
+			if errValues != nil {
+				return nil, errValues
+			}
+		} else {
+			value, errValue := in.eval(valueExpr, env)
+			if errValue != nil {
+				return nil, errValue
+			}
+			values = []any{value}
+		}
+		for _, value := range values {
+			n++
+			t.set(float64(n), value)
+		}
+	}
+	if errCharge := in.charge(len(e.values), e.line); errCharge != nil {
+		return nil, errCharge
+	}
+	return t, nil
+}
+
+func (in *interp) evalUnary(e *unaryExpr, env *scope) (any, error) {
+	operand, errOperand := in.eval(e.operand, env)
+	if errOperand != nil {
+		return nil, errOperand
+	}
+	switch e.op {
+	case "not":
+		return !truthy(operand), nil
+	case "-":
+		n, isNum := toNumber(operand)
+		if !isNum {
+			return nil, runtimeError(e.line, "attempt to perform arithmetic on a %s value", typeName(operand))
+		}
+		return -n, nil
+	}
+	switch v := operand.(type) {
+	case string:
+		return float64(len(v)), nil
+	case *Table:
+		return float64(v.length), nil
+	}
+	return nil, runtimeError(e.line, "attempt to get length of a %s value", typeName(operand))
+}
+
+func (in *interp) evalBinary(e *binaryExpr, env *scope) (any, error) {
+	left, errLeft := in.eval(e.left, env)
+	if errLeft != nil {
+		return nil, errLeft
+	}
+	// and and or only evaluate their right operand when needed.
+	switch e.op {
+	case "and":
+		if !truthy(left) {
+			return left, nil
+		}
+		return in.eval(e.right, env)
+	case "or":
+		if truthy(left) {
+			return left, nil
+		}
+		return in.eval(e.right, env)
+	}
+	right, errRight := in.eval(e.right, env)
+	if errRight != nil {
+		return nil, errRight
+	}
+
+	switch e.op {
+	case "==":
+		return left == right, nil
+	case "~=":
+		return left != right, nil
+	case "<", "<=", ">", ">=":
+		return compare(e.op, left, right, e.line)
+	case "..":
+		if !isConcatenable(left) || !isConcatenable(right) {
+			operand := left
+			if isConcatenable(left) {
+				operand = right
+			}
+			return nil, runtimeError(e.line, "attempt to concatenate a %s value", typeName(operand))
+		}
+		l, r := toString(left), toString(right)
+		if len(l)+len(r) > maxStringLen {
+			return nil, runtimeError(e.line, "string is too long")
+		}
+		return l + r, nil
+	}
+	return arith(e.op, left, right, e.line)
+}
+
+func isConcatenable(v any) bool {
+	switch v.(type) {
+	case string, float64:
+		return true
+	}
+	return false
+}
+
+func compare(op string, left any, right any, line int) (any, error) {
+	var less, equal bool
+	switch l := left.(type) {
+	case float64:
+		r, isNum := right.(float64)
+		if !isNum {
+			return nil, runtimeError(line, "attempt to compare number with %s", typeName(right))
+		}
+		less, equal = l < r, l == r
+	case string:
+		r, isStr := right.(string)
+		if !isStr {
+			return nil, runtimeError(line, "attempt to compare string with %s", typeName(right))
+		}
+		less, equal = l < r, l == r
+	default:
+		return nil, runtimeError(line, "attempt to compare two %s values", typeName(left))
+	}
+	switch op {
+	case "<":
+		return less, nil
+	case "<=":
+		return less || equal, nil
+	case ">":
+		return !less && !equal, nil
+	}
+	return !less, nil
+}
+
+func arith(op string, left any, right any, line int) (any, error) {
+	l, isLeftNum := toNumber(left)
+	r, isRightNum := toNumber(right)
+	if !isLeftNum || !isRightNum {
+		operand := left
+		if isLeftNum {
+			operand = right
+		}
+		return nil, runtimeError(line, "attempt to perform arithmetic on a %s value", typeName(operand))
+	}
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	case "//":
+		return math.Floor(l / r), nil
+	case "%":
+		if math.IsInf(r, 0) && !math.IsInf(l, 0) {
+			if (l >= 0) == (r > 0) {
+				return l, nil
+			}
+			return r, nil
+		}
+		return l - math.Floor(l/r)*r, nil
+	case "^":
+		return math.Pow(l, r), nil
+	}
+	return nil, runtimeError(line, "unknown operator '%s'", op)
+}
+
+// toNumber converts a value to a number, the strings holding a number are converted too.
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		return parseNumber(strings.TrimSpace(n))
+	}
+	return 0, false
+}
+
+func (in *interp) call(fn any, args []any, line int) ([]any, error) {
+	if errCharge := in.charge(1, line); errCharge != nil {
+		return nil, errCharge
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+	if in.depth > maxCallDepth {
+		return nil, &Error{Line: line, Message: ErrCallDepth.Error(), Err: ErrCallDepth}
+	}
+
+	switch f := fn.(type) {
+	case *builtin:
+		results, errCall := f.fn(in, args)
+		if errCall != nil {
+			// The errors of the builtins don't know the line they were called from.
+			if e, isErr := errCall.(*Error); isErr {
+				if e.Line <= 0 {
+					return nil, &Error{Line: line, Message: e.Message, Err: e.Err}
+				}
+				return nil, e
+			}
+			return nil, &Error{Line: line, Message: fmt.Sprintf("%s: %v", f.name, errCall)}
+		}
+		return results, nil
+	case *closure:
+		env := newScope(f.env)
+		env.isFunction = true
+		for i, param := range f.fn.params {
+			env.declare(param, valueAt(args, i))
+		}
+		if f.fn.isVararg && len(args) > len(f.fn.params) {
+			env.varargs = args[len(f.fn.params):]
+		}
+		_, results, errBody := in.exec(f.fn.body, env)
+		return results, errBody
+	}
+	return nil, runtimeError(line, "attempt to call a %s value", typeName(fn))
+}
+
+func valueAt(values []any, i int) any {
+	if i < len(values) {
+		return values[i]
+	}
+	return nil
+}
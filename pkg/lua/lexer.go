@@ -0,0 +1,241 @@
+package lua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokNumber
+	tokString
+	tokKeyword
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	line int
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true, "false": true, "for": true,
+	"function": true, "if": true, "in": true, "local": true, "nil": true, "not": true, "or": true, "repeat": true,
+	"return": true, "then": true, "true": true, "until": true, "while": true,
+}
+
+// operators are sorted longest first, so the longest operator matching the input is chosen.
+var operators = []string{
+	"...", "..", "==", "~=", "<=", ">=", "//",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=", "(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+// lex splits a script in tokens.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			i += 2
+			if level, isLong := longBracket(src[i:]); isLong {
+				text, n, errLong := readLong(src[i:], level)
+				if errLong != nil {
+					return nil, &Error{Line: line, Message: errLong.Error()}
+				}
+				line += strings.Count(text, "\n")
+				i += n
+				continue
+			}
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isLetter(c):
+			start := i
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			word := src[start:i]
+			kind := tokName
+			if keywords[word] {
+				kind = tokKeyword
+			}
+			tokens = append(tokens, token{kind: kind, text: word, line: line})
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				i += 2
+				for i < len(src) && isHex(src[i]) {
+					i++
+				}
+			} else {
+				for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+					i++
+				}
+				if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+					i++
+					if i < len(src) && (src[i] == '+' || src[i] == '-') {
+						i++
+					}
+					for i < len(src) && isDigit(src[i]) {
+						i++
+					}
+				}
+			}
+			num, ok := parseNumber(src[start:i])
+			if !ok {
+				return nil, &Error{Line: line, Message: fmt.Sprintf("malformed number '%s'", src[start:i])}
+			}
+			tokens = append(tokens, token{kind: tokNumber, num: num, text: src[start:i], line: line})
+		case c == '"' || c == '\'':
+			text, n, errString := readString(src[i:])
+			if errString != nil {
+				return nil, &Error{Line: line, Message: errString.Error()}
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, line: line})
+			i += n
+		case c == '[':
+			if level, isLong := longBracket(src[i:]); isLong {
+				text, n, errLong := readLong(src[i:], level)
+				if errLong != nil {
+					return nil, &Error{Line: line, Message: errLong.Error()}
+				}
+				tokens = append(tokens, token{kind: tokString, text: strings.TrimPrefix(text, "\n"), line: line})
+				line += strings.Count(text, "\n")
+				i += n
+				continue
+			}
+			fallthrough
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &Error{Line: line, Message: fmt.Sprintf("unexpected symbol '%c'", c)}
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, line: line})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, line: line}), nil
+}
+
+// longBracket returns the level of the long bracket ("[[", "[==[") the source starts with.
+func longBracket(src string) (int, bool) {
+	if !strings.HasPrefix(src, "[") {
+		return 0, false
+	}
+	level := 1
+	for level < len(src) && src[level] == '=' {
+		level++
+	}
+	if level < len(src) && src[level] == '[' {
+		return level - 1, true
+	}
+	return 0, false
+}
+
+// readLong reads a long string or comment, returning its text and the number of bytes read.
+func readLong(src string, level int) (string, int, error) {
+	open := level + 2
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(src[open:], closing)
+	if end < 0 {
+		return "", 0, fmt.Errorf("unfinished long string")
+	}
+	return src[open : open+end], open + end + len(closing), nil
+}
+
+// readString reads a quoted string, returning its text and the number of bytes read.
+func readString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	i := 1
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, fmt.Errorf("unfinished string")
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch e := src[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'v':
+				b.WriteByte('\v')
+			case '\\', '"', '\'', '\n':
+				b.WriteByte(e)
+			default:
+				if !isDigit(e) {
+					return "", 0, fmt.Errorf("invalid escape sequence '\\%c'", e)
+				}
+				start := i
+				for i < len(src) && i-start < 3 && isDigit(src[i]) {
+					i++
+				}
+				code, _ := strconv.Atoi(src[start:i])
+				if code > 255 {
+					return "", 0, fmt.Errorf("decimal escape too large")
+				}
+				b.WriteByte(byte(code))
+				continue
+			}
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, fmt.Errorf("unfinished string")
+}
+
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		n, errParse := strconv.ParseUint(s[2:], 16, 64)
+		return float64(n), errParse == nil
+	}
+	n, errParse := strconv.ParseFloat(s, 64)
+	return n, errParse == nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
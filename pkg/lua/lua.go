@@ -0,0 +1,344 @@
+// Package lua implements a sandboxed and deterministic subset of Lua 5.3, for the small scripts run by the database,
+// like the hooks of the buckets.
+//
+// The scripts can't access the filesystem, the network, the clock or random numbers, the tables are iterated
+// in the order of their keys, and every run is limited to MaxSteps steps, so a script run on several nodes
+// always returns the same result. Metatables, coroutines, goto, integer subtypes and the string patterns aren't supported.
+package lua
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	// MaxSteps is the maximum number of statements and calls a run can execute.
+	MaxSteps = 1_000_000
+	// maxCallDepth is the maximum number of nested calls.
+	maxCallDepth = 200
+	// maxStringLen is the maximum length of the strings a script builds.
+	maxStringLen = 16 << 20
+	// maxConvertDepth is the maximum nesting of the values converted from and to Go.
+	maxConvertDepth = 1000
+)
+
+var (
+	// ErrStepLimit is returned when a run executes more than MaxSteps steps.
+	ErrStepLimit = errors.New("script exceeded its step limit")
+	// ErrCallDepth is returned when a run nests more than maxCallDepth calls.
+	ErrCallDepth = errors.New("script exceeded its call depth, it may recurse endlessly")
+)
+
+// Error is an error compiling or running a script.
+type Error struct {
+	Line    int
+	Message string
+	// Err is the error returned by a Go function called by the script, or a limit exceeded.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Line <= 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Function is a Go function the scripts can call, its arguments and result are converted as the values of Run.
+type Function func(args ...any) (any, error)
+
+// Script is a compiled script, it's safe for concurrent use since every run has its own state.
+type Script struct {
+	body *block
+}
+
+// Compile parses a script.
+func Compile(src string) (*Script, error) {
+	body, errParse := parse(src)
+	if errParse != nil {
+		return nil, errParse
+	}
+	return &Script{body: body}, nil
+}
+
+// Run runs the script with some globals, added to the standard library, and returns the values it returns.
+//
+// The values are converted between Go and Lua as encoding/json decodes them: nil, bool, float64, string,
+// []any and map[string]any, which become tables. Functions are passed as Function.
+func (s *Script) Run(globals map[string]any) ([]any, error) {
+	in := &interp{globals: newTable()}
+	openStdlib(in)
+	for name, value := range globals {
+		v, errConvert := toLua(value, 0)
+		if errConvert != nil {
+			return nil, fmt.Errorf("couldn't convert global '%s': %w", name, errConvert)
+		}
+		in.globals.set(name, v)
+	}
+
+	env := &scope{vars: make(map[string]*any), isFunction: true}
+	_, results, errExec := in.exec(s.body, env)
+	if errExec != nil {
+		return nil, errExec
+	}
+	converted := make([]any, len(results))
+	for i, result := range results {
+		v, errConvert := fromLua(result, 0)
+		if errConvert != nil {
+			return nil, fmt.Errorf("couldn't convert the result: %w", errConvert)
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
+
+// Table is the only data structure of Lua, an associative array.
+type Table struct {
+	hash map[any]any
+	// length is the border of the table: t[length] isn't nil, and t[length+1] is.
+	length int
+	// isArray records the tables converted from a list, so they are converted back to a list even when empty.
+	isArray bool
+}
+
+func newTable() *Table {
+	return &Table{hash: make(map[any]any)}
+}
+
+func (t *Table) get(key any) any {
+	return t.hash[key]
+}
+
+func (t *Table) set(key any, value any) {
+	if value == nil {
+		delete(t.hash, key)
+		if n, isNum := key.(float64); isNum && n >= 1 && n <= float64(t.length) && n == math.Trunc(n) {
+			t.length = int(n) - 1
+		}
+		return
+	}
+	t.hash[key] = value
+	if n, isNum := key.(float64); isNum && n == float64(t.length+1) {
+		for t.hash[float64(t.length+1)] != nil {
+			t.length++
+		}
+	}
+}
+
+// sortedKeys returns the keys of the table sorted: numbers, then strings, then booleans.
+func (t *Table) sortedKeys() []any {
+	keys := make([]any, 0, len(t.hash))
+	for k := range t.hash {
+		keys = append(keys, k)
+	}
+	rank := func(k any) int {
+		switch k.(type) {
+		case float64:
+			return 0
+		case string:
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank(keys[i]), rank(keys[j])
+		if ri != rj {
+			return ri < rj
+		}
+		switch a := keys[i].(type) {
+		case float64:
+			return a < keys[j].(float64)
+		case string:
+			return a < keys[j].(string)
+		case bool:
+			return !a && keys[j].(bool)
+		}
+		return false
+	})
+	return keys
+}
+
+// closure is a function defined by a script.
+type closure struct {
+	fn  *functionExpr
+	env *scope
+}
+
+// builtin is a function implemented in Go.
+type builtin struct {
+	name string
+	fn   func(in *interp, args []any) ([]any, error)
+}
+
+// toLua converts a Go value to a Lua one.
+func toLua(value any, depth int) (any, error) {
+	if depth > maxConvertDepth {
+		return nil, errors.New("the value is nested too deep")
+	}
+	switch v := value.(type) {
+	case nil, bool, float64, string:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case json.Number:
+		n, errParse := v.Float64()
+		return n, errParse
+	case []byte:
+		return string(v), nil
+	case Function:
+		return &builtin{name: "function", fn: func(_ *interp, args []any) ([]any, error) {
+			goArgs := make([]any, len(args))
+			for i, arg := range args {
+				goArg, errConvert := fromLua(arg, 0)
+				if errConvert != nil {
+					return nil, fmt.Errorf("bad argument #%d: %v", i+1, errConvert)
+				}
+				goArgs[i] = goArg
+			}
+			result, errCall := v(goArgs...)
+			if errCall != nil {
+				return nil, &Error{Message: errCall.Error(), Err: errCall}
+			}
+			converted, errConvert := toLua(result, 0)
+			if errConvert != nil {
+				return nil, errConvert
+			}
+			return []any{converted}, nil
+		}}, nil
+	case []any:
+		t := newTable()
+		t.isArray = true
+		for i, item := range v {
+			converted, errConvert := toLua(item, depth+1)
+			if errConvert != nil {
+				return nil, errConvert
+			}
+			t.set(float64(i+1), converted)
+		}
+		return t, nil
+	case map[string]any:
+		t := newTable()
+		for k, item := range v {
+			converted, errConvert := toLua(item, depth+1)
+			if errConvert != nil {
+				return nil, errConvert
+			}
+			t.set(k, converted)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("values of type %T can't be passed to a script", value)
+}
+
+// fromLua converts a Lua value to a Go one, the tables with the keys 1 to n become lists, the rest maps.
+func fromLua(value any, depth int) (any, error) {
+	if depth > maxConvertDepth {
+		return nil, errors.New("the value is nested too deep, it may reference itself")
+	}
+	switch v := value.(type) {
+	case nil, bool, string:
+		return v, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("number %v can't be converted", v)
+		}
+		return v, nil
+	case *Table:
+		if v.isArray || (len(v.hash) > 0 && len(v.hash) == v.length) {
+			list := make([]any, v.length)
+			for i := range list {
+				item, errConvert := fromLua(v.get(float64(i+1)), depth+1)
+				if errConvert != nil {
+					return nil, errConvert
+				}
+				list[i] = item
+			}
+			if len(v.hash) == v.length {
+				return list, nil
+			}
+		}
+		m := make(map[string]any, len(v.hash))
+		for k, item := range v.hash {
+			var key string
+			switch kv := k.(type) {
+			case string:
+				key = kv
+			case float64:
+				key = formatNumber(kv)
+			default:
+				return nil, fmt.Errorf("table keys of type %s can't be converted", typeName(k))
+			}
+			converted, errConvert := fromLua(item, depth+1)
+			if errConvert != nil {
+				return nil, errConvert
+			}
+			m[key] = converted
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("values of type %s can't be converted", typeName(value))
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *closure, *builtin:
+		return "function"
+	}
+	return "userdata"
+}
+
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+func toString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	case *Table:
+		// The addresses aren't printed, they would differ between nodes.
+		return "table"
+	}
+	return "function"
+}
+
+func truthy(value any) bool {
+	if value == nil {
+		return false
+	}
+	if b, isBool := value.(bool); isBool {
+		return b
+	}
+	return true
+}
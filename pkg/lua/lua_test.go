@@ -0,0 +1,225 @@
+package lua
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, src string, globals map[string]any) ([]any, error) {
+	t.Helper()
+	script, errCompile := Compile(src)
+	if errCompile != nil {
+		return nil, errCompile
+	}
+	return script.Run(globals)
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		globals map[string]any
+		want    []any
+	}{
+		{name: "no results", src: "local x = 1", want: []any{}},
+		{name: "arithmetic", src: "return 1 + 2 * 3, 7 // 2, 7 % 3, 2 ^ 10", want: []any{7.0, 3.0, 1.0, 1024.0}},
+		{name: "concatenation", src: `return "a" .. 1 .. "b"`, want: []any{"a1b"}},
+		{name: "comparison", src: `return 1 < 2, "a" < "b", 1 == "1"`, want: []any{true, true, false}},
+		{name: "logical operators", src: "return nil or 2, false and 1, not nil", want: []any{2.0, false, true}},
+		{name: "length", src: `return #"abc", #{1, 2, 3}`, want: []any{3.0, 3.0}},
+		{
+			name: "numeric for",
+			src:  "local s = 0 for i = 1, 10 do s = s + i end return s",
+			want: []any{55.0},
+		},
+		{
+			name: "while and break",
+			src:  "local i = 0 while true do i = i + 1 if i == 5 then break end end return i",
+			want: []any{5.0},
+		},
+		{
+			name: "repeat",
+			src:  "local i = 0 repeat i = i + 2 until i >= 7 return i",
+			want: []any{8.0},
+		},
+		{
+			name: "closures",
+			src: `local function counter()
+				local n = 0
+				return function() n = n + 1 return n end
+			end
+			local c = counter()
+			c() c()
+			return c()`,
+			want: []any{3.0},
+		},
+		{
+			name: "recursion",
+			src:  "local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end return fib(15)",
+			want: []any{610.0},
+		},
+		{
+			name: "varargs",
+			src:  `local function f(...) return select("#", ...), ... end return f(1, 2)`,
+			want: []any{2.0, 1.0, 2.0},
+		},
+		{
+			name: "pairs iterates in the order of the keys",
+			src: `local t = {c = 3, a = 1, b = 2}
+			local s = ""
+			for k, v in pairs(t) do s = s .. k .. v end
+			return s`,
+			want: []any{"a1b2c3"},
+		},
+		{
+			name: "table library",
+			src: `local t = {3, 1, 2}
+			table.insert(t, 0)
+			table.sort(t)
+			table.remove(t, 1)
+			return table.concat(t, ",")`,
+			want: []any{"1,2,3"},
+		},
+		{
+			name: "string library",
+			src: `return string.upper("ab"), string.sub("hello", 2, -2), string.rep("ab", 3, "-"), string.rep("ab", 2),
+				string.format("%d-%s-%.1f", 1, "x", 2.25), string.find("hello", "ll"), ("abc"):reverse()`,
+			// A call which isn't the last expression of a list is truncated to its first result.
+			want: []any{"AB", "ell", "ab-ab-ab", "abab", "1-x-2.2", 3.0, "cba"},
+		},
+		{
+			name: "math library",
+			src:  "return math.floor(2.5), math.max(1, 5, 3), math.min(4, 2), math.abs(-1)",
+			want: []any{2.0, 5.0, 2.0, 1.0},
+		},
+		{
+			name: "pcall catches the errors of the script",
+			src:  `local ok, err = pcall(function() error("boom") end) return ok, err`,
+			want: []any{false, "line 1: boom"},
+		},
+		{
+			name:    "globals are converted",
+			src:     "return value.name, #value.tags, value.tags[2]",
+			globals: map[string]any{"value": map[string]any{"name": "ada", "tags": []any{"a", "b"}}},
+			want:    []any{"ada", 2.0, "b"},
+		},
+		{
+			name: "results are converted",
+			src:  `return {1, 2}, {a = {b = true}}, {}`,
+			want: []any{[]any{1.0, 2.0}, map[string]any{"a": map[string]any{"b": true}}, map[string]any{}},
+		},
+		{
+			name:    "empty lists stay lists",
+			src:     "return list",
+			globals: map[string]any{"list": []any{}},
+			want:    []any{[]any{}},
+		},
+		{
+			name: "go functions",
+			src:  "return double(21)",
+			globals: map[string]any{"double": Function(func(args ...any) (any, error) {
+				return args[0].(float64) * 2, nil
+			})},
+			want: []any{42.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errRun := run(t, tt.src, tt.globals)
+			if errRun != nil {
+				t.Fatal(errRun)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	errGo := errors.New("go failed")
+	tests := []struct {
+		name    string
+		src     string
+		globals map[string]any
+		// wantErr is the error the run must wrap, if any, and wantMessage a part of its message.
+		wantErr     error
+		wantMessage string
+	}{
+		{name: "syntax error", src: "local = 1", wantMessage: "line 1"},
+		{name: "unfinished block", src: "if true then", wantMessage: "end"},
+		{name: "error", src: `error("boom")`, wantMessage: "boom"},
+		{name: "failed assertion", src: `assert(false, "nope")`, wantMessage: "nope"},
+		{name: "arithmetic on nil", src: "local x return x + 1", wantMessage: "arithmetic"},
+		{name: "call of nil", src: "local f f()", wantMessage: "call"},
+		{name: "index of nil", src: "local t return t.x", wantMessage: "index"},
+		{name: "step limit", src: "while true do end", wantErr: ErrStepLimit},
+		{name: "call depth", src: "local function f() return f() + 1 end return f()", wantErr: ErrCallDepth},
+		{name: "repeating a string too many times", src: `return string.rep("", 1e9)`, wantMessage: "too long"},
+		{name: "repeats are charged", src: `return string.rep("", 2e6)`, wantErr: ErrStepLimit},
+		{name: "repeats are charged with a separator", src: `return string.rep("a", 2e6, "")`, wantErr: ErrStepLimit},
+		{
+			name: "go function error isn't caught by pcall",
+			src:  "return pcall(fail)",
+			globals: map[string]any{"fail": Function(func(args ...any) (any, error) {
+				return nil, errGo
+			})},
+			wantErr: errGo,
+		},
+		{name: "self referencing result", src: "local t = {} t.t = t return t", wantMessage: "nested too deep"},
+		{name: "unsupported global", src: "return 1", globals: map[string]any{"c": make(chan int)}, wantMessage: "global 'c'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errRun := run(t, tt.src, tt.globals)
+			if errRun == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(errRun, tt.wantErr) {
+				t.Errorf("got error %v, want %v", errRun, tt.wantErr)
+			}
+			if !strings.Contains(errRun.Error(), tt.wantMessage) {
+				t.Errorf("got error %q, want it to contain %q", errRun, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	src := `local t = {}
+	for i = 1, 50 do t["k" .. i] = i end
+	local s = ""
+	for k in pairs(t) do s = s .. k end
+	return s`
+	script, errCompile := Compile(src)
+	if errCompile != nil {
+		t.Fatal(errCompile)
+	}
+	first, errRun := script.Run(nil)
+	if errRun != nil {
+		t.Fatal(errRun)
+	}
+	for i := 0; i < 20; i++ {
+		again, errAgain := script.Run(nil)
+		if errAgain != nil {
+			t.Fatal(errAgain)
+		}
+		if !reflect.DeepEqual(first, again) {
+			t.Fatalf("run %d returned %v, the first returned %v", i, again, first)
+		}
+	}
+}
+
+func TestRunLimitsStrings(t *testing.T) {
+	// Each concatenation doubles the string, until it's longer than maxStringLen.
+	src := `local s = string.rep("a", 1024) while true do s = s .. s end`
+	_, errRun := run(t, src, nil)
+	if errRun == nil || !strings.Contains(errRun.Error(), "too long") {
+		t.Errorf("got error %v, want the string to be too long", errRun)
+	}
+	if _, errRep := run(t, `return string.rep("ab", 1e8)`, nil); errRep == nil || !strings.Contains(errRep.Error(), "too long") {
+		t.Errorf("got error %v, want the repeated string to be too long", errRep)
+	}
+}
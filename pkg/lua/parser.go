@@ -0,0 +1,727 @@
+package lua
+
+import (
+	"fmt"
+)
+
+type (
+	expr interface{}
+	stmt interface{}
+
+	block struct {
+		stmts []stmt
+	}
+
+	// Expressions.
+	nilExpr    struct{}
+	trueExpr   struct{}
+	falseExpr  struct{}
+	varargExpr struct{ line int }
+	numberExpr struct{ value float64 }
+	stringExpr struct{ value string }
+	nameExpr   struct {
+		name string
+		line int
+	}
+	indexExpr struct {
+		obj  expr
+		key  expr
+		line int
+	}
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+	methodCallExpr struct {
+		obj    expr
+		method string
+		args   []expr
+		line   int
+	}
+	functionExpr struct {
+		params   []string
+		isVararg bool
+		body     *block
+	}
+	binaryExpr struct {
+		op    string
+		left  expr
+		right expr
+		line  int
+	}
+	unaryExpr struct {
+		op      string
+		operand expr
+		line    int
+	}
+	tableExpr struct {
+		// keys holds nil for the positional fields.
+		keys   []expr
+		values []expr
+		line   int
+	}
+	parenExpr struct{ inner expr }
+
+	// Statements.
+	localStmt struct {
+		names  []string
+		values []expr
+	}
+	assignStmt struct {
+		targets []expr
+		values  []expr
+		line    int
+	}
+	// localFunctionStmt declares the local before assigning the function, so it can call itself.
+	localFunctionStmt struct {
+		name string
+		fn   *functionExpr
+	}
+	callStmt  struct{ call expr }
+	doStmt    struct{ body *block }
+	whileStmt struct {
+		cond expr
+		body *block
+	}
+	repeatStmt struct {
+		body *block
+		cond expr
+	}
+	ifStmt struct {
+		conds  []expr
+		blocks []*block
+		// elseBlock is nil if there isn't an else.
+		elseBlock *block
+	}
+	numericForStmt struct {
+		name               string
+		start, limit, step expr
+		body               *block
+		line               int
+	}
+	genericForStmt struct {
+		names []string
+		exprs []expr
+		body  *block
+		line  int
+	}
+	returnStmt struct{ values []expr }
+	breakStmt  struct{}
+)
+
+// binaryPriority holds the left and right priorities of the binary operators, the right associative ones
+// have a lower right priority.
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {9, 8},
+	"+":  {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "//": {11, 11}, "%": {11, 11},
+	"^": {14, 13},
+}
+
+const unaryPriority = 12
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(src string) (*block, error) {
+	tokens, errLex := lex(src)
+	if errLex != nil {
+		return nil, errLex
+	}
+	p := &parser{tokens: tokens}
+	b, errBlock := p.block()
+	if errBlock != nil {
+		return nil, errBlock
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected '%s'", p.peek().text)
+	}
+	return b, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is returns whether the next token is the given keyword or operator.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokKeyword || t.kind == tokOp) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("'%s' expected near '%s'", text, p.describe())
+	}
+	return nil
+}
+
+func (p *parser) expectName() (string, error) {
+	t := p.peek()
+	if t.kind != tokName {
+		return "", p.errorf("name expected near '%s'", p.describe())
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *parser) describe() string {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return "<eof>"
+	}
+	return t.text
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Line: p.peek().line, Message: fmt.Sprintf(format, args...)}
+}
+
+// blockEnd returns whether the next token ends a block.
+func (p *parser) blockEnd() bool {
+	return p.peek().kind == tokEOF || p.is("end") || p.is("else") || p.is("elseif") || p.is("until")
+}
+
+func (p *parser) block() (*block, error) {
+	b := &block{}
+	for !p.blockEnd() {
+		if p.accept(";") {
+			continue
+		}
+		if p.is("return") {
+			p.next()
+			var values []expr
+			if !p.blockEnd() && !p.is(";") {
+				var errList error
+				values, errList = p.exprList()
+				if errList != nil {
+					return nil, errList
+				}
+			}
+			p.accept(";")
+			b.stmts = append(b.stmts, &returnStmt{values: values})
+			if !p.blockEnd() {
+				return nil, p.errorf("'end' expected after return, near '%s'", p.describe())
+			}
+			break
+		}
+		s, errStmt := p.statement()
+		if errStmt != nil {
+			return nil, errStmt
+		}
+		b.stmts = append(b.stmts, s)
+	}
+	return b, nil
+}
+
+func (p *parser) statement() (stmt, error) {
+	line := p.peek().line
+	switch {
+	case p.accept("break"):
+		return &breakStmt{}, nil
+	case p.accept("do"):
+		body, errBody := p.blockUntil("end")
+		if errBody != nil {
+			return nil, errBody
+		}
+		return &doStmt{body: body}, nil
+	case p.accept("while"):
+		cond, errCond := p.expr(0)
+		if errCond != nil {
+			return nil, errCond
+		}
+		if errDo := p.expect("do"); errDo != nil {
+			return nil, errDo
+		}
+		body, errBody := p.blockUntil("end")
+		if errBody != nil {
+			return nil, errBody
+		}
+		return &whileStmt{cond: cond, body: body}, nil
+	case p.accept("repeat"):
+		body, errBody := p.blockUntil("until")
+		if errBody != nil {
+			return nil, errBody
+		}
+		cond, errCond := p.expr(0)
+		if errCond != nil {
+			return nil, errCond
+		}
+		return &repeatStmt{body: body, cond: cond}, nil
+	case p.accept("if"):
+		return p.ifStatement()
+	case p.accept("for"):
+		return p.forStatement(line)
+	case p.accept("function"):
+		return p.functionStatement(line)
+	case p.accept("local"):
+		if p.accept("function") {
+			name, errName := p.expectName()
+			if errName != nil {
+				return nil, errName
+			}
+			fn, errFn := p.functionBody()
+			if errFn != nil {
+				return nil, errFn
+			}
+			return &localFunctionStmt{name: name, fn: fn}, nil
+		}
+		return p.localStatement()
+	}
+	return p.exprStatement(line)
+}
+
+func (p *parser) blockUntil(end string) (*block, error) {
+	b, errBlock := p.block()
+	if errBlock != nil {
+		return nil, errBlock
+	}
+	if errEnd := p.expect(end); errEnd != nil {
+		return nil, errEnd
+	}
+	return b, nil
+}
+
+func (p *parser) ifStatement() (stmt, error) {
+	s := &ifStmt{}
+	for {
+		cond, errCond := p.expr(0)
+		if errCond != nil {
+			return nil, errCond
+		}
+		if errThen := p.expect("then"); errThen != nil {
+			return nil, errThen
+		}
+		body, errBody := p.block()
+		if errBody != nil {
+			return nil, errBody
+		}
+		s.conds = append(s.conds, cond)
+		s.blocks = append(s.blocks, body)
+		if p.accept("elseif") {
+			continue
+		}
+		if p.accept("else") {
+			elseBlock, errElse := p.block()
+			if errElse != nil {
+				return nil, errElse
+			}
+			s.elseBlock = elseBlock
+		}
+		return s, p.expect("end")
+	}
+}
+
+func (p *parser) forStatement(line int) (stmt, error) {
+	name, errName := p.expectName()
+	if errName != nil {
+		return nil, errName
+	}
+	if p.accept("=") {
+		s := &numericForStmt{name: name, line: line}
+		var errExpr error
+		if s.start, errExpr = p.expr(0); errExpr != nil {
+			return nil, errExpr
+		}
+		if errComma := p.expect(","); errComma != nil {
+			return nil, errComma
+		}
+		if s.limit, errExpr = p.expr(0); errExpr != nil {
+			return nil, errExpr
+		}
+		if p.accept(",") {
+			if s.step, errExpr = p.expr(0); errExpr != nil {
+				return nil, errExpr
+			}
+		}
+		if errDo := p.expect("do"); errDo != nil {
+			return nil, errDo
+		}
+		body, errBody := p.blockUntil("end")
+		if errBody != nil {
+			return nil, errBody
+		}
+		s.body = body
+		return s, nil
+	}
+
+	s := &genericForStmt{names: []string{name}, line: line}
+	for p.accept(",") {
+		other, errOther := p.expectName()
+		if errOther != nil {
+			return nil, errOther
+		}
+		s.names = append(s.names, other)
+	}
+	if errIn := p.expect("in"); errIn != nil {
+		return nil, errIn
+	}
+	exprs, errList := p.exprList()
+	if errList != nil {
+		return nil, errList
+	}
+	s.exprs = exprs
+	if errDo := p.expect("do"); errDo != nil {
+		return nil, errDo
+	}
+	body, errBody := p.blockUntil("end")
+	if errBody != nil {
+		return nil, errBody
+	}
+	s.body = body
+	return s, nil
+}
+
+// functionStatement parses "function a.b.c:d() end", which assigns the function to a.b.c.d.
+func (p *parser) functionStatement(line int) (stmt, error) {
+	name, errName := p.expectName()
+	if errName != nil {
+		return nil, errName
+	}
+	var target expr = &nameExpr{name: name, line: line}
+	isMethod := false
+	for p.is(".") || p.is(":") {
+		isMethod = p.next().text == ":"
+		field, errField := p.expectName()
+		if errField != nil {
+			return nil, errField
+		}
+		target = &indexExpr{obj: target, key: &stringExpr{value: field}, line: line}
+		if isMethod {
+			break
+		}
+	}
+	fn, errFn := p.functionBody()
+	if errFn != nil {
+		return nil, errFn
+	}
+	if isMethod {
+		fn.params = append([]string{"self"}, fn.params...)
+	}
+	return &assignStmt{targets: []expr{target}, values: []expr{fn}, line: line}, nil
+}
+
+func (p *parser) localStatement() (stmt, error) {
+	s := &localStmt{}
+	for {
+		name, errName := p.expectName()
+		if errName != nil {
+			return nil, errName
+		}
+		s.names = append(s.names, name)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.accept("=") {
+		values, errList := p.exprList()
+		if errList != nil {
+			return nil, errList
+		}
+		s.values = values
+	}
+	return s, nil
+}
+
+func (p *parser) exprStatement(line int) (stmt, error) {
+	first, errExpr := p.suffixedExpr()
+	if errExpr != nil {
+		return nil, errExpr
+	}
+	if !p.is("=") && !p.is(",") {
+		switch first.(type) {
+		case *callExpr, *methodCallExpr:
+			return &callStmt{call: first}, nil
+		}
+		return nil, p.errorf("syntax error near '%s'", p.describe())
+	}
+
+	targets := []expr{first}
+	for p.accept(",") {
+		target, errTarget := p.suffixedExpr()
+		if errTarget != nil {
+			return nil, errTarget
+		}
+		targets = append(targets, target)
+	}
+	for _, target := range targets {
+		switch target.(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			return nil, p.errorf("cannot assign to this expression")
+		}
+	}
+	if errAssign := p.expect("="); errAssign != nil {
+		return nil, errAssign
+	}
+	values, errList := p.exprList()
+	if errList != nil {
+		return nil, errList
+	}
+	return &assignStmt{targets: targets, values: values, line: line}, nil
+}
+
+func (p *parser) exprList() ([]expr, error) {
+	var list []expr
+	for {
+		e, errExpr := p.expr(0)
+		if errExpr != nil {
+			return nil, errExpr
+		}
+		list = append(list, e)
+		if !p.accept(",") {
+			return list, nil
+		}
+	}
+}
+
+// expr parses an expression whose binary operators have a priority higher than limit.
+func (p *parser) expr(limit int) (expr, error) {
+	var left expr
+	t := p.peek()
+	if (t.kind == tokKeyword && t.text == "not") || (t.kind == tokOp && (t.text == "-" || t.text == "#")) {
+		p.next()
+		operand, errOperand := p.expr(unaryPriority)
+		if errOperand != nil {
+			return nil, errOperand
+		}
+		left = &unaryExpr{op: t.text, operand: operand, line: t.line}
+	} else {
+		var errSimple error
+		left, errSimple = p.simpleExpr()
+		if errSimple != nil {
+			return nil, errSimple
+		}
+	}
+
+	for {
+		op := p.peek()
+		priority, isBinary := binaryPriority[op.text]
+		if (op.kind != tokOp && op.kind != tokKeyword) || !isBinary || priority[0] <= limit {
+			return left, nil
+		}
+		p.next()
+		right, errRight := p.expr(priority[1])
+		if errRight != nil {
+			return nil, errRight
+		}
+		left = &binaryExpr{op: op.text, left: left, right: right, line: op.line}
+	}
+}
+
+func (p *parser) simpleExpr() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		return &numberExpr{value: t.num}, nil
+	case tokString:
+		p.next()
+		return &stringExpr{value: t.text}, nil
+	case tokKeyword:
+		switch t.text {
+		case "nil":
+			p.next()
+			return &nilExpr{}, nil
+		case "true":
+			p.next()
+			return &trueExpr{}, nil
+		case "false":
+			p.next()
+			return &falseExpr{}, nil
+		case "function":
+			p.next()
+			return p.functionBody()
+		}
+	case tokOp:
+		switch t.text {
+		case "...":
+			p.next()
+			return &varargExpr{line: t.line}, nil
+		case "{":
+			return p.tableConstructor()
+		}
+	}
+	return p.suffixedExpr()
+}
+
+func (p *parser) primaryExpr() (expr, error) {
+	t := p.peek()
+	if t.kind == tokName {
+		p.next()
+		return &nameExpr{name: t.text, line: t.line}, nil
+	}
+	if p.accept("(") {
+		inner, errInner := p.expr(0)
+		if errInner != nil {
+			return nil, errInner
+		}
+		if errClose := p.expect(")"); errClose != nil {
+			return nil, errClose
+		}
+		return &parenExpr{inner: inner}, nil
+	}
+	return nil, p.errorf("unexpected symbol near '%s'", p.describe())
+}
+
+func (p *parser) suffixedExpr() (expr, error) {
+	e, errPrimary := p.primaryExpr()
+	if errPrimary != nil {
+		return nil, errPrimary
+	}
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			field, errField := p.expectName()
+			if errField != nil {
+				return nil, errField
+			}
+			e = &indexExpr{obj: e, key: &stringExpr{value: field}, line: t.line}
+		case p.accept("["):
+			key, errKey := p.expr(0)
+			if errKey != nil {
+				return nil, errKey
+			}
+			if errClose := p.expect("]"); errClose != nil {
+				return nil, errClose
+			}
+			e = &indexExpr{obj: e, key: key, line: t.line}
+		case p.accept(":"):
+			method, errMethod := p.expectName()
+			if errMethod != nil {
+				return nil, errMethod
+			}
+			args, errArgs := p.callArgs()
+			if errArgs != nil {
+				return nil, errArgs
+			}
+			e = &methodCallExpr{obj: e, method: method, args: args, line: t.line}
+		case p.is("(") || p.is("{") || t.kind == tokString:
+			args, errArgs := p.callArgs()
+			if errArgs != nil {
+				return nil, errArgs
+			}
+			e = &callExpr{fn: e, args: args, line: t.line}
+		default:
+			return e, nil
+		}
+	}
+}
+
+// callArgs parses the arguments of a call: "(a, b)", a table constructor or a string.
+func (p *parser) callArgs() ([]expr, error) {
+	t := p.peek()
+	if t.kind == tokString {
+		p.next()
+		return []expr{&stringExpr{value: t.text}}, nil
+	}
+	if p.is("{") {
+		table, errTable := p.tableConstructor()
+		if errTable != nil {
+			return nil, errTable
+		}
+		return []expr{table}, nil
+	}
+	if errOpen := p.expect("("); errOpen != nil {
+		return nil, errOpen
+	}
+	if p.accept(")") {
+		return nil, nil
+	}
+	args, errList := p.exprList()
+	if errList != nil {
+		return nil, errList
+	}
+	return args, p.expect(")")
+}
+
+func (p *parser) functionBody() (*functionExpr, error) {
+	if errOpen := p.expect("("); errOpen != nil {
+		return nil, errOpen
+	}
+	fn := &functionExpr{}
+	for !p.is(")") {
+		if p.accept("...") {
+			fn.isVararg = true
+			break
+		}
+		name, errName := p.expectName()
+		if errName != nil {
+			return nil, errName
+		}
+		fn.params = append(fn.params, name)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if errClose := p.expect(")"); errClose != nil {
+		return nil, errClose
+	}
+	body, errBody := p.blockUntil("end")
+	if errBody != nil {
+		return nil, errBody
+	}
+	fn.body = body
+	return fn, nil
+}
+
+func (p *parser) tableConstructor() (expr, error) {
+	line := p.peek().line
+	if errOpen := p.expect("{"); errOpen != nil {
+		return nil, errOpen
+	}
+	t := &tableExpr{line: line}
+	for !p.is("}") {
+		var key expr
+		switch {
+		case p.is("["):
+			p.next()
+			var errKey error
+			key, errKey = p.expr(0)
+			if errKey != nil {
+				return nil, errKey
+			}
+			if errClose := p.expect("]"); errClose != nil {
+				return nil, errClose
+			}
+			if errAssign := p.expect("="); errAssign != nil {
+				return nil, errAssign
+			}
+		case p.peek().kind == tokName && p.tokens[p.pos+1].kind == tokOp && p.tokens[p.pos+1].text == "=":
+			key = &stringExpr{value: p.next().text}
+			p.next()
+		}
+		value, errValue := p.expr(0)
+		if errValue != nil {
+			return nil, errValue
+		}
+		t.keys = append(t.keys, key)
+		t.values = append(t.values, value)
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	return t, p.expect("}")
+}
@@ -0,0 +1,706 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openStdlib adds the deterministic part of the standard library to the globals.
+func openStdlib(in *interp) {
+	register(in.globals, map[string]func(in *interp, args []any) ([]any, error){
+		"type":     luaType,
+		"tostring": luaToString,
+		"tonumber": luaToNumber,
+		"pairs":    luaPairs,
+		"ipairs":   luaIpairs,
+		"error":    luaError,
+		"assert":   luaAssert,
+		"pcall":    luaPcall,
+		"select":   luaSelect,
+		"unpack":   luaUnpack,
+	})
+
+	stringLib := newTable()
+	register(stringLib, map[string]func(in *interp, args []any) ([]any, error){
+		"len":     strLen,
+		"sub":     strSub,
+		"upper":   strUpper,
+		"lower":   strLower,
+		"rep":     strRep,
+		"reverse": strReverse,
+		"find":    strFind,
+		"format":  strFormat,
+		"byte":    strByte,
+		"char":    strChar,
+	})
+	in.globals.set("string", stringLib)
+
+	tableLib := newTable()
+	register(tableLib, map[string]func(in *interp, args []any) ([]any, error){
+		"insert": tblInsert,
+		"remove": tblRemove,
+		"concat": tblConcat,
+		"sort":   tblSort,
+		"unpack": luaUnpack,
+	})
+	in.globals.set("table", tableLib)
+
+	mathLib := newTable()
+	register(mathLib, map[string]func(in *interp, args []any) ([]any, error){
+		"floor": mathUnary(math.Floor),
+		"ceil":  mathUnary(math.Ceil),
+		"abs":   mathUnary(math.Abs),
+		"sqrt":  mathUnary(math.Sqrt),
+		"max":   mathMax,
+		"min":   mathMin,
+		"fmod":  mathFmod,
+	})
+	mathLib.set("huge", math.Inf(1))
+	mathLib.set("pi", math.Pi)
+	in.globals.set("math", mathLib)
+}
+
+func register(t *Table, fns map[string]func(in *interp, args []any) ([]any, error)) {
+	for name, fn := range fns {
+		t.set(name, &builtin{name: name, fn: fn})
+	}
+}
+
+func argError(n int, format string, args ...any) error {
+	return &Error{Message: fmt.Sprintf("bad argument #%d: %s", n, fmt.Sprintf(format, args...))}
+}
+
+func checkTable(args []any, n int) (*Table, error) {
+	t, isTable := valueAt(args, n-1).(*Table)
+	if !isTable {
+		return nil, argError(n, "table expected, got %s", typeName(valueAt(args, n-1)))
+	}
+	return t, nil
+}
+
+func checkNumber(args []any, n int) (float64, error) {
+	num, isNum := toNumber(valueAt(args, n-1))
+	if !isNum {
+		return 0, argError(n, "number expected, got %s", typeName(valueAt(args, n-1)))
+	}
+	return num, nil
+}
+
+func checkInt(args []any, n int) (int, error) {
+	num, errNum := checkNumber(args, n)
+	if errNum != nil {
+		return 0, errNum
+	}
+	if num != math.Trunc(num) || math.Abs(num) > math.MaxInt32 {
+		return 0, argError(n, "number has no integer representation")
+	}
+	return int(num), nil
+}
+
+func optInt(args []any, n int, def int) (int, error) {
+	if valueAt(args, n-1) == nil {
+		return def, nil
+	}
+	return checkInt(args, n)
+}
+
+func checkString(args []any, n int) (string, error) {
+	switch v := valueAt(args, n-1).(type) {
+	case string:
+		return v, nil
+	case float64:
+		return formatNumber(v), nil
+	}
+	return "", argError(n, "string expected, got %s", typeName(valueAt(args, n-1)))
+}
+
+func luaType(_ *interp, args []any) ([]any, error) {
+	if len(args) == 0 {
+		return nil, argError(1, "value expected")
+	}
+	return []any{typeName(args[0])}, nil
+}
+
+func luaToString(_ *interp, args []any) ([]any, error) {
+	return []any{toString(valueAt(args, 0))}, nil
+}
+
+func luaToNumber(_ *interp, args []any) ([]any, error) {
+	if base := valueAt(args, 1); base != nil {
+		b, errBase := checkInt(args, 2)
+		if errBase != nil {
+			return nil, errBase
+		}
+		s, errStr := checkString(args, 1)
+		if errStr != nil {
+			return nil, errStr
+		}
+		n, errParse := strconv.ParseInt(strings.TrimSpace(s), b, 64)
+		if errParse != nil {
+			return []any{nil}, nil
+		}
+		return []any{float64(n)}, nil
+	}
+	n, isNum := toNumber(valueAt(args, 0))
+	if !isNum {
+		return []any{nil}, nil
+	}
+	return []any{n}, nil
+}
+
+// luaPairs iterates the keys of a table in their order, instead of in the order of their hashes, so it's deterministic.
+func luaPairs(in *interp, args []any) ([]any, error) {
+	t, errTable := checkTable(args, 1)
+	if errTable != nil {
+		return nil, errTable
+	}
+	keys := t.sortedKeys()
+	if errCharge := in.charge(len(keys), 0); errCharge != nil {
+		return nil, errCharge
+	}
+	i := 0
+	next := &builtin{name: "next", fn: func(_ *interp, _ []any) ([]any, error) {
+		// The keys removed while iterating are skipped.
+		for i < len(keys) {
+			k := keys[i]
+			i++
+			if v := t.get(k); v != nil {
+				return []any{k, v}, nil
+			}
+		}
+		return []any{nil}, nil
+	}}
+	return []any{next, t, nil}, nil
+}
+
+func luaIpairs(_ *interp, args []any) ([]any, error) {
+	t, errTable := checkTable(args, 1)
+	if errTable != nil {
+		return nil, errTable
+	}
+	next := &builtin{name: "next", fn: func(_ *interp, iterArgs []any) ([]any, error) {
+		i, _ := valueAt(iterArgs, 1).(float64)
+		v := t.get(i + 1)
+		if v == nil {
+			return []any{nil}, nil
+		}
+		return []any{i + 1, v}, nil
+	}}
+	return []any{next, t, float64(0)}, nil
+}
+
+func luaError(_ *interp, args []any) ([]any, error) {
+	return nil, &Error{Message: toString(valueAt(args, 0))}
+}
+
+func luaAssert(_ *interp, args []any) ([]any, error) {
+	if truthy(valueAt(args, 0)) {
+		return args, nil
+	}
+	message := "assertion failed!"
+	if len(args) > 1 {
+		message = toString(args[1])
+	}
+	return nil, &Error{Message: message}
+}
+
+// luaPcall calls a function, returning false and the error instead of failing.
+//
+// The errors of the Go functions and the exceeded limits aren't caught, so they always stop the script.
+func luaPcall(in *interp, args []any) ([]any, error) {
+	if len(args) == 0 {
+		return nil, argError(1, "value expected")
+	}
+	results, errCall := in.call(args[0], args[1:], 0)
+	if errCall == nil {
+		return append([]any{true}, results...), nil
+	}
+	var e *Error
+	if !errors.As(errCall, &e) || e.Err != nil {
+		return nil, errCall
+	}
+	return []any{false, e.Error()}, nil
+}
+
+func luaSelect(_ *interp, args []any) ([]any, error) {
+	if s, isStr := valueAt(args, 0).(string); isStr && s == "#" {
+		return []any{float64(len(args) - 1)}, nil
+	}
+	n, errN := checkInt(args, 1)
+	if errN != nil {
+		return nil, errN
+	}
+	if n < 0 {
+		n = len(args) + n
+	}
+	if n <= 0 {
+		return nil, argError(1, "index out of range")
+	}
+	if n >= len(args) {
+		return nil, nil
+	}
+	return args[n:], nil
+}
+
+func luaUnpack(in *interp, args []any) ([]any, error) {
+	t, errTable := checkTable(args, 1)
+	if errTable != nil {
+		return nil, errTable
+	}
+	from, errFrom := optInt(args, 2, 1)
+	if errFrom != nil {
+		return nil, errFrom
+	}
+	to, errTo := optInt(args, 3, t.length)
+	if errTo != nil {
+		return nil, errTo
+	}
+	if errCharge := in.charge(to-from+1, 0); errCharge != nil {
+		return nil, errCharge
+	}
+	var values []any
+	for i := from; i <= to; i++ {
+		values = append(values, t.get(float64(i)))
+	}
+	return values, nil
+}
+
+// strRange converts the Lua indexes of a string, which start at 1 and can be negative, to Go ones.
+func strRange(length int, i int, j int) (int, int) {
+	if i < 0 {
+		i = length + i + 1
+	}
+	if j < 0 {
+		j = length + j + 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	if j > length {
+		j = length
+	}
+	if i > j {
+		return 0, 0
+	}
+	return i - 1, j
+}
+
+func strLen(_ *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	return []any{float64(len(s))}, nil
+}
+
+func strSub(_ *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	i, errI := optInt(args, 2, 1)
+	if errI != nil {
+		return nil, errI
+	}
+	j, errJ := optInt(args, 3, -1)
+	if errJ != nil {
+		return nil, errJ
+	}
+	start, end := strRange(len(s), i, j)
+	return []any{s[start:end]}, nil
+}
+
+func strUpper(_ *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	return []any{strings.ToUpper(s)}, nil
+}
+
+func strLower(_ *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	return []any{strings.ToLower(s)}, nil
+}
+
+func strRep(in *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	n, errN := checkInt(args, 2)
+	if errN != nil {
+		return nil, errN
+	}
+	sep := ""
+	if valueAt(args, 2) != nil {
+		var errSep error
+		sep, errSep = checkString(args, 3)
+		if errSep != nil {
+			return nil, errSep
+		}
+	}
+	if n <= 0 {
+		return []any{""}, nil
+	}
+	// n is checked on its own too, since repeating empty strings builds nothing, but still takes n steps.
+	if n > maxStringLen || (len(s)+len(sep))*n > maxStringLen {
+		return nil, &Error{Message: "string is too long"}
+	}
+	if errCharge := in.charge(n, 0); errCharge != nil {
+		return nil, errCharge
+	}
+	if sep == "" {
+		return []any{strings.Repeat(s, n)}, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s)*n + len(sep)*(n-1))
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(s)
+	}
+	return []any{b.String()}, nil
+}
+
+func strReverse(_ *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return []any{string(b)}, nil
+}
+
+// strFind finds a substring, the patterns aren't supported so it always searches the plain text.
+func strFind(_ *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	sub, errSub := checkString(args, 2)
+	if errSub != nil {
+		return nil, errSub
+	}
+	init, errInit := optInt(args, 3, 1)
+	if errInit != nil {
+		return nil, errInit
+	}
+	if init < 0 {
+		init = len(s) + init + 1
+	}
+	if init < 1 {
+		init = 1
+	}
+	if init > len(s)+1 {
+		return []any{nil}, nil
+	}
+	i := strings.Index(s[init-1:], sub)
+	if i < 0 {
+		return []any{nil}, nil
+	}
+	start := init + i
+	return []any{float64(start), float64(start + len(sub) - 1)}, nil
+}
+
+// strFormat supports the %d, %i, %f, %g, %e, %x, %X, %o, %c, %s, %q and %% directives, with their flags.
+func strFormat(_ *interp, args []any) ([]any, error) {
+	format, errFormat := checkString(args, 1)
+	if errFormat != nil {
+		return nil, errFormat
+	}
+	var b strings.Builder
+	n := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		for i < len(format) && strings.IndexByte("-+ #0.123456789", format[i]) >= 0 {
+			i++
+		}
+		if i >= len(format) {
+			return nil, &Error{Message: "invalid format, it ends with '%'"}
+		}
+		spec := format[start:i]
+		verb := format[i]
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		n++
+		switch verb {
+		case 'd', 'i', 'x', 'X', 'o', 'c':
+			v, errInt := checkNumber(args, n)
+			if errInt != nil {
+				return nil, errInt
+			}
+			if v != math.Trunc(v) {
+				return nil, argError(n, "number has no integer representation")
+			}
+			if verb == 'i' {
+				verb = 'd'
+			}
+			b.WriteString(fmt.Sprintf(spec+string(verb), int64(v)))
+		case 'f', 'g', 'e', 'G', 'E':
+			v, errNum := checkNumber(args, n)
+			if errNum != nil {
+				return nil, errNum
+			}
+			b.WriteString(fmt.Sprintf(spec+string(verb), v))
+		case 's':
+			b.WriteString(fmt.Sprintf(spec+"s", toString(valueAt(args, n-1))))
+		case 'q':
+			s, errStr := checkString(args, n)
+			if errStr != nil {
+				return nil, errStr
+			}
+			b.WriteString(strconv.Quote(s))
+		default:
+			return nil, &Error{Message: fmt.Sprintf("invalid conversion '%s%c' to format", spec, verb)}
+		}
+		if b.Len() > maxStringLen {
+			return nil, &Error{Message: "string is too long"}
+		}
+	}
+	return []any{b.String()}, nil
+}
+
+func strByte(_ *interp, args []any) ([]any, error) {
+	s, errStr := checkString(args, 1)
+	if errStr != nil {
+		return nil, errStr
+	}
+	i, errI := optInt(args, 2, 1)
+	if errI != nil {
+		return nil, errI
+	}
+	j, errJ := optInt(args, 3, i)
+	if errJ != nil {
+		return nil, errJ
+	}
+	start, end := strRange(len(s), i, j)
+	var codes []any
+	for _, c := range []byte(s[start:end]) {
+		codes = append(codes, float64(c))
+	}
+	return codes, nil
+}
+
+func strChar(_ *interp, args []any) ([]any, error) {
+	b := make([]byte, len(args))
+	for i := range args {
+		c, errInt := checkInt(args, i+1)
+		if errInt != nil {
+			return nil, errInt
+		}
+		if c < 0 || c > 255 {
+			return nil, argError(i+1, "value out of range")
+		}
+		b[i] = byte(c)
+	}
+	return []any{string(b)}, nil
+}
+
+func tblInsert(_ *interp, args []any) ([]any, error) {
+	t, errTable := checkTable(args, 1)
+	if errTable != nil {
+		return nil, errTable
+	}
+	switch len(args) {
+	case 2:
+		t.set(float64(t.length+1), args[1])
+	case 3:
+		pos, errPos := checkInt(args, 2)
+		if errPos != nil {
+			return nil, errPos
+		}
+		if pos < 1 || pos > t.length+1 {
+			return nil, argError(2, "position out of bounds")
+		}
+		for i := t.length; i >= pos; i-- {
+			t.set(float64(i+1), t.get(float64(i)))
+		}
+		t.set(float64(pos), args[2])
+	default:
+		return nil, &Error{Message: "wrong number of arguments to 'insert'"}
+	}
+	return nil, nil
+}
+
+func tblRemove(_ *interp, args []any) ([]any, error) {
+	t, errTable := checkTable(args, 1)
+	if errTable != nil {
+		return nil, errTable
+	}
+	length := t.length
+	pos, errPos := optInt(args, 2, length)
+	if errPos != nil {
+		return nil, errPos
+	}
+	if length == 0 && pos == 0 {
+		return []any{nil}, nil
+	}
+	if pos < 1 || pos > length+1 {
+		return nil, argError(2, "position out of bounds")
+	}
+	removed := t.get(float64(pos))
+	for i := pos; i < length; i++ {
+		t.set(float64(i), t.get(float64(i+1)))
+	}
+	if pos <= length {
+		t.set(float64(length), nil)
+	}
+	return []any{removed}, nil
+}
+
+func tblConcat(in *interp, args []any) ([]any, error) {
+	t, errTable := checkTable(args, 1)
+	if errTable != nil {
+		return nil, errTable
+	}
+	sep := ""
+	if valueAt(args, 1) != nil {
+		var errSep error
+		sep, errSep = checkString(args, 2)
+		if errSep != nil {
+			return nil, errSep
+		}
+	}
+	from, errFrom := optInt(args, 3, 1)
+	if errFrom != nil {
+		return nil, errFrom
+	}
+	to, errTo := optInt(args, 4, t.length)
+	if errTo != nil {
+		return nil, errTo
+	}
+	if errCharge := in.charge(to-from+1, 0); errCharge != nil {
+		return nil, errCharge
+	}
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		v := t.get(float64(i))
+		if !isConcatenable(v) {
+			return nil, &Error{Message: fmt.Sprintf("invalid value (at index %d) in table for 'concat'", i)}
+		}
+		if i > from {
+			b.WriteString(sep)
+		}
+		b.WriteString(toString(v))
+		if b.Len() > maxStringLen {
+			return nil, &Error{Message: "string is too long"}
+		}
+	}
+	return []any{b.String()}, nil
+}
+
+// tblSort sorts a list, with the < operator or a comparison function. The sort is stable, so it's deterministic.
+func tblSort(in *interp, args []any) ([]any, error) {
+	t, errTable := checkTable(args, 1)
+	if errTable != nil {
+		return nil, errTable
+	}
+	less := valueAt(args, 1)
+	items := make([]any, t.length)
+	for i := range items {
+		items[i] = t.get(float64(i + 1))
+	}
+
+	var errSort error
+	sort.SliceStable(items, func(i, j int) bool {
+		if errSort != nil {
+			return false
+		}
+		if less == nil {
+			isLess, errCompare := compare("<", items[i], items[j], 0)
+			if errCompare != nil {
+				errSort = errCompare
+				return false
+			}
+			return isLess.(bool)
+		}
+		results, errCall := in.call(less, []any{items[i], items[j]}, 0)
+		if errCall != nil {
+			errSort = errCall
+			return false
+		}
+		return truthy(valueAt(results, 0))
+	})
+	if errSort != nil {
+		return nil, errSort
+	}
+	for i, item := range items {
+		t.set(float64(i+1), item)
+	}
+	return nil, nil
+}
+
+func mathUnary(fn func(float64) float64) func(in *interp, args []any) ([]any, error) {
+	return func(_ *interp, args []any) ([]any, error) {
+		n, errNum := checkNumber(args, 1)
+		if errNum != nil {
+			return nil, errNum
+		}
+		return []any{fn(n)}, nil
+	}
+}
+
+func mathMax(_ *interp, args []any) ([]any, error) {
+	best, errNum := checkNumber(args, 1)
+	if errNum != nil {
+		return nil, errNum
+	}
+	for i := 2; i <= len(args); i++ {
+		n, errN := checkNumber(args, i)
+		if errN != nil {
+			return nil, errN
+		}
+		if n > best {
+			best = n
+		}
+	}
+	return []any{best}, nil
+}
+
+func mathMin(_ *interp, args []any) ([]any, error) {
+	best, errNum := checkNumber(args, 1)
+	if errNum != nil {
+		return nil, errNum
+	}
+	for i := 2; i <= len(args); i++ {
+		n, errN := checkNumber(args, i)
+		if errN != nil {
+			return nil, errN
+		}
+		if n < best {
+			best = n
+		}
+	}
+	return []any{best}, nil
+}
+
+func mathFmod(_ *interp, args []any) ([]any, error) {
+	a, errA := checkNumber(args, 1)
+	if errA != nil {
+		return nil, errA
+	}
+	b, errB := checkNumber(args, 2)
+	if errB != nil {
+		return nil, errB
+	}
+	return []any{math.Mod(a, b)}, nil
+}
@@ -0,0 +1,69 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/narvikd/errorskit"
+	"io"
+	"sort"
+)
+
+// azureStore is a Store for Azure Blob Storage.
+type azureStore struct {
+	client    *azblob.Client
+	container string
+}
+
+func newAzureStore(opts Options) (*azureStore, error) {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", opts.AccessKey)
+	}
+
+	cred, errCred := azblob.NewSharedKeyCredential(opts.AccessKey, opts.SecretKey)
+	if errCred != nil {
+		return nil, errorskit.Wrap(errCred, "couldn't create azure credentials")
+	}
+
+	client, errClient := azblob.NewClientWithSharedKeyCredential(endpoint, cred, nil)
+	if errClient != nil {
+		return nil, errorskit.Wrap(errClient, "couldn't create azure client")
+	}
+
+	return &azureStore{client: client, container: opts.Bucket}, nil
+}
+
+func (s *azureStore) Put(ctx context.Context, name string, r io.Reader, _ int64) error {
+	_, err := s.client.UploadStream(ctx, s.container, name, r, nil)
+	return err
+}
+
+func (s *azureStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	res, err := s.client.DownloadStream(ctx, s.container, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *azureStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	pager := s.client.NewListBlobsFlatPager(s.container, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			names = append(names, *item.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *azureStore) Delete(ctx context.Context, name string) error {
+	_, err := s.client.DeleteBlob(ctx, s.container, name, nil)
+	return err
+}
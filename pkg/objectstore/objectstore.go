@@ -0,0 +1,53 @@
+// Package objectstore provides a minimal common interface over cloud object storages (S3, GCS and Azure Blob Storage).
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Store is an object storage bucket (or container, in Azure's case).
+type Store interface {
+	// Put uploads an object, overwriting it if it already exists. size is -1 if it isn't known, the object is streamed.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	// Get downloads an object. The returned reader must be closed.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the names of the objects that start with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes an object.
+	Delete(ctx context.Context, name string) error
+}
+
+// Options defines how to connect to an object storage.
+type Options struct {
+	// Provider can be "s3", "gcs" or "azure".
+	Provider string
+	// Bucket is the bucket, or the container in Azure.
+	Bucket string
+	// Endpoint overrides the default endpoint of the provider, for example for S3 compatible storages like MinIO.
+	Endpoint string
+	// Region is only used by S3.
+	Region string
+	// AccessKey is the access key ID in S3, the HMAC key in GCS and the account name in Azure.
+	AccessKey string
+	// SecretKey is the secret key in S3, the HMAC secret in GCS and the account key in Azure.
+	SecretKey string
+	// Insecure disables TLS, only meant for local S3 compatible storages.
+	Insecure bool
+}
+
+// New returns the Store for the given provider.
+func New(opts Options) (Store, error) {
+	switch opts.Provider {
+	case "s3":
+		return newS3Store(opts, "s3.amazonaws.com")
+	case "gcs":
+		// GCS is accessed through its S3 interoperability API, using HMAC keys.
+		return newS3Store(opts, "storage.googleapis.com")
+	case "azure":
+		return newAzureStore(opts)
+	default:
+		return nil, fmt.Errorf("object storage provider not recognized: %s", opts.Provider)
+	}
+}
@@ -0,0 +1,67 @@
+package objectstore
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/narvikd/errorskit"
+	"io"
+	"sort"
+)
+
+// streamPartSize is the size of the parts the objects of unknown size are uploaded in.
+const streamPartSize = 16 * 1024 * 1024
+
+// s3Store is a Store for S3 and any storage compatible with its API.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3Store(opts Options, defaultEndpoint string) (*s3Store, error) {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+
+	client, errClient := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+	if errClient != nil {
+		return nil, errorskit.Wrap(errClient, "couldn't create s3 client")
+	}
+
+	return &s3Store{client: client, bucket: opts.Bucket}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	opts := minio.PutObjectOptions{}
+	if size < 0 {
+		// Otherwise, the parts of an object of unknown size are sized for the largest object, and buffered in memory.
+		opts.PartSize = streamPartSize
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, name, r, size, opts)
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		names = append(names, obj.Key)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, name string) error {
+	return s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+}
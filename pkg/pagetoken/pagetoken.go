@@ -0,0 +1,70 @@
+// Package pagetoken implements opaque continuation tokens for paginated endpoints, signed with HMAC-SHA256,
+// so any server sharing the secret can continue a pagination started on another one, without keeping any state.
+package pagetoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned when decoding a token which is malformed, wasn't signed with the secret, or was tampered with.
+var ErrInvalid = errors.New("invalid page token")
+
+// Token is the state of a pagination.
+type Token struct {
+	// Endpoint is the endpoint which issued the token, so it can't be used with another one.
+	Endpoint string `json:"e"`
+	// After is the last key returned, the next page starts after it.
+	After string `json:"a"`
+	// Options are the options of the first page, like a prefix, which the next pages keep.
+	Options map[string]string `json:"o,omitempty"`
+	// Limit is the maximum number of items of every page.
+	Limit int `json:"l"`
+}
+
+// Signer encodes and decodes the tokens, it's safe for concurrent use.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner creates a Signer which signs the tokens with secret.
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// Encode returns a token encoded as an URL safe string, made of its JSON and its signature.
+func (s *Signer) Encode(t Token) (string, error) {
+	b, errMarshal := json.Marshal(t)
+	if errMarshal != nil {
+		return "", errMarshal
+	}
+	return base64.RawURLEncoding.EncodeToString(b) + "." + base64.RawURLEncoding.EncodeToString(s.sign(b)), nil
+}
+
+// Decode returns the token encoded in a string, if its signature is valid.
+func (s *Signer) Decode(token string) (Token, error) {
+	var t Token
+	rawBody, rawSig, found := strings.Cut(token, ".")
+	if !found {
+		return t, ErrInvalid
+	}
+	b, errBody := base64.RawURLEncoding.DecodeString(rawBody)
+	sig, errSig := base64.RawURLEncoding.DecodeString(rawSig)
+	if errBody != nil || errSig != nil || !hmac.Equal(sig, s.sign(b)) {
+		return t, ErrInvalid
+	}
+	if json.Unmarshal(b, &t) != nil {
+		return t, ErrInvalid
+	}
+	return t, nil
+}
+
+func (s *Signer) sign(b []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	_, _ = mac.Write(b)
+	return mac.Sum(nil)
+}
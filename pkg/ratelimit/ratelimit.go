@@ -0,0 +1,57 @@
+// Package ratelimit implements a token bucket limiting how many events happen per second,
+// whose rate can be changed at any time.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows up to a rate of events per second, with bursts of up to a second of events.
+// It's safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// New creates a Limiter allowing perSecond events per second, 0 or lower is unlimited.
+func New(perSecond int) *Limiter {
+	l := &Limiter{}
+	l.SetRate(perSecond)
+	return l
+}
+
+// SetRate changes the events allowed per second, 0 or lower is unlimited.
+func (l *Limiter) SetRate(perSecond int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if float64(perSecond) == l.rate {
+		return
+	}
+	l.rate = float64(perSecond)
+	l.tokens = l.rate
+	l.last = time.Now()
+}
+
+// Allow returns whether an event can happen now, consuming a token if it can.
+func (l *Limiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,306 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errCorrupted = errors.New("corrupted encoded value")
+
+// ziplist returns the items of a ziplist, the encoding of the small lists, hashes and sorted sets before Redis 7.
+func ziplist(b []byte) ([]string, error) {
+	const headerLen = 10
+	if len(b) < headerLen+1 {
+		return nil, errCorrupted
+	}
+	items := make([]string, 0, binary.LittleEndian.Uint16(b[8:10]))
+	i := headerLen
+	for {
+		if i >= len(b) {
+			return nil, errCorrupted
+		}
+		if b[i] == 0xFF {
+			return items, nil
+		}
+
+		// Skips the length of the previous entry.
+		if b[i] == 0xFE {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(b) {
+			return nil, errCorrupted
+		}
+
+		item, n, errEntry := ziplistEntry(b[i:])
+		if errEntry != nil {
+			return nil, errEntry
+		}
+		items = append(items, item)
+		i += n
+	}
+}
+
+// ziplistEntry decodes the entry at the start of b, returning it and its size.
+func ziplistEntry(b []byte) (string, int, error) {
+	enc := b[0]
+	switch enc >> 6 {
+	case 0:
+		return sliceString(b, 1, int(enc&0x3F))
+	case 1:
+		if len(b) < 2 {
+			return "", 0, errCorrupted
+		}
+		return sliceString(b, 2, int(enc&0x3F)<<8|int(b[1]))
+	case 2:
+		if len(b) < 5 {
+			return "", 0, errCorrupted
+		}
+		return sliceString(b, 5, int(binary.BigEndian.Uint32(b[1:5])))
+	}
+
+	switch enc {
+	case 0xC0:
+		return intString(b, 1, 2)
+	case 0xD0:
+		return intString(b, 1, 4)
+	case 0xE0:
+		return intString(b, 1, 8)
+	case 0xF0:
+		return intString(b, 1, 3)
+	case 0xFE:
+		return intString(b, 1, 1)
+	}
+	if enc >= 0xF1 && enc <= 0xFD {
+		return strconv.Itoa(int(enc&0x0F) - 1), 1, nil
+	}
+	return "", 0, fmt.Errorf("%w: unknown ziplist encoding %#x", errCorrupted, enc)
+}
+
+// listpack returns the items of a listpack, the encoding of the small lists, sets, hashes and sorted sets since Redis 7.
+func listpack(b []byte) ([]string, error) {
+	const headerLen = 6
+	if len(b) < headerLen+1 {
+		return nil, errCorrupted
+	}
+	items := make([]string, 0, binary.LittleEndian.Uint16(b[4:6]))
+	i := headerLen
+	for {
+		if i >= len(b) {
+			return nil, errCorrupted
+		}
+		if b[i] == 0xFF {
+			return items, nil
+		}
+
+		item, n, errEntry := listpackEntry(b[i:])
+		if errEntry != nil {
+			return nil, errEntry
+		}
+		items = append(items, item)
+		i += n + backlenSize(n)
+	}
+}
+
+// listpackEntry decodes the entry at the start of b, returning it and its size without its back length.
+func listpackEntry(b []byte) (string, int, error) {
+	enc := b[0]
+	switch {
+	case enc&0x80 == 0:
+		return strconv.Itoa(int(enc)), 1, nil
+	case enc&0xC0 == 0x80:
+		return sliceString(b, 1, int(enc&0x3F))
+	case enc&0xE0 == 0xC0:
+		if len(b) < 2 {
+			return "", 0, errCorrupted
+		}
+		v := int(enc&0x1F)<<8 | int(b[1])
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		return strconv.Itoa(v), 2, nil
+	case enc&0xF0 == 0xE0:
+		if len(b) < 2 {
+			return "", 0, errCorrupted
+		}
+		return sliceString(b, 2, int(enc&0x0F)<<8|int(b[1]))
+	}
+
+	switch enc {
+	case 0xF0:
+		if len(b) < 5 {
+			return "", 0, errCorrupted
+		}
+		return sliceString(b, 5, int(binary.LittleEndian.Uint32(b[1:5])))
+	case 0xF1:
+		return intString(b, 1, 2)
+	case 0xF2:
+		return intString(b, 1, 3)
+	case 0xF3:
+		return intString(b, 1, 4)
+	case 0xF4:
+		return intString(b, 1, 8)
+	default:
+		return "", 0, fmt.Errorf("%w: unknown listpack encoding %#x", errCorrupted, enc)
+	}
+}
+
+// backlenSize returns how many bytes the back length of a listpack entry of size n takes.
+func backlenSize(n int) int {
+	switch {
+	case n < 1<<7:
+		return 1
+	case n < 1<<14:
+		return 2
+	case n < 1<<21:
+		return 3
+	case n < 1<<28:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// intset returns the integers of an intset, the encoding of the small sets of integers.
+func intset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(b[0:4]))
+	n := int(binary.LittleEndian.Uint32(b[4:8]))
+	if width != 2 && width != 4 && width != 8 || len(b) < 8+n*width {
+		return nil, errCorrupted
+	}
+	items := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		item, _, errInt := intString(b, 8+i*width, width)
+		if errInt != nil {
+			return nil, errInt
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// zipmap returns the hash of a zipmap, the encoding of the small hashes before Redis 2.6.
+func zipmap(b []byte) (map[string]string, error) {
+	hash := make(map[string]string)
+	i := 1
+	next := func() (int, error) {
+		if i >= len(b) {
+			return 0, errCorrupted
+		}
+		switch b[i] {
+		case 254:
+			if i+5 > len(b) {
+				return 0, errCorrupted
+			}
+			n := int(binary.LittleEndian.Uint32(b[i+1 : i+5]))
+			i += 5
+			return n, nil
+		case 255:
+			return -1, nil
+		default:
+			i++
+			return int(b[i-1]), nil
+		}
+	}
+
+	for {
+		keyLen, errKey := next()
+		if errKey != nil {
+			return nil, errKey
+		}
+		if keyLen < 0 {
+			return hash, nil
+		}
+		if i+keyLen > len(b) {
+			return nil, errCorrupted
+		}
+		key := string(b[i : i+keyLen])
+		i += keyLen
+
+		valueLen, errValue := next()
+		if errValue != nil || valueLen < 0 || i >= len(b) {
+			return nil, errCorrupted
+		}
+		free := int(b[i])
+		i++
+		if i+valueLen+free > len(b) {
+			return nil, errCorrupted
+		}
+		hash[key] = string(b[i : i+valueLen])
+		i += valueLen + free
+	}
+}
+
+// sliceString returns the n bytes after the header of an entry, and the size of the entry.
+func sliceString(b []byte, header int, n int) (string, int, error) {
+	if header+n > len(b) {
+		return "", 0, errCorrupted
+	}
+	return string(b[header : header+n]), header + n, nil
+}
+
+// intString decodes the little endian signed integer of width bytes at offset, returning it and the entry's size.
+func intString(b []byte, offset int, width int) (string, int, error) {
+	if offset+width > len(b) {
+		return "", 0, errCorrupted
+	}
+	var u uint64
+	for i := width - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[offset+i])
+	}
+	// Extends the sign of the integers narrower than 64 bits.
+	shift := 64 - 8*width
+	v := int64(u<<shift) >> shift
+	return strconv.FormatInt(v, 10), offset + width, nil
+}
+
+// lzfDecompress decompresses the strings compressed by Redis with LZF.
+//
+// outLen comes from the dump, so out grows as it's decompressed, and it's corrupted as soon as it's exceeded.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, clampPrealloc(uint64(outLen)))
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > outLen {
+				return nil, errCorrupted
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errCorrupted
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorrupted
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - 1 - int(in[i])
+		i++
+		if ref < 0 || len(out)+n+2 > outLen {
+			return nil, errCorrupted
+		}
+		// The reference can overlap the bytes being written, so they are copied one by one.
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errCorrupted
+	}
+	return out, nil
+}
@@ -0,0 +1,589 @@
+// Package rdb reads the keys of a Redis RDB dump, so they can be imported into nubedb.
+//
+// The strings, lists, sets, sorted sets and hashes are supported in all their encodings, up to RDB version 11.
+// The streams, the modules' types and the hashes with field expirations aren't, and make Parse fail.
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Opcodes of the RDB file.
+const (
+	opSlotInfo     = 0xF4
+	opFunction2    = 0xF5
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+)
+
+// Types of the values.
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZSet            = 3
+	typeHash            = 4
+	typeZSet2           = 5
+	typeHashZipmap      = 9
+	typeListZiplist     = 10
+	typeSetIntset       = 11
+	typeZSetZiplist     = 12
+	typeHashZiplist     = 13
+	typeListQuicklist   = 14
+	typeHashListpack    = 16
+	typeZSetListpack    = 17
+	typeListQuicklist2  = 18
+	typeSetListpack     = 20
+	quicklistNodePlain  = 1
+	maxSupportedVersion = 11
+	// maxPrealloc is the most items or bytes allocated ahead from a length read from the dump,
+	// which can't be trusted, the rest are allocated as they are read.
+	maxPrealloc = 1024
+)
+
+// ErrUnsupported is returned when the dump has a type which can't be read.
+var ErrUnsupported = errors.New("unsupported RDB type")
+
+// Entry is a key of the dump.
+//
+// Value is a string for the strings, a []string for the lists and sets, a map[string]string for the hashes,
+// and a map[string]float64 for the sorted sets. The integers stored by Redis are returned as strings.
+type Entry struct {
+	DB    int
+	Key   string
+	Value any
+	// ExpiresAt is when the key expires, zero if it doesn't.
+	ExpiresAt time.Time
+}
+
+type parser struct {
+	r *bufio.Reader
+	// counter counts the bytes read from the dump, including the ones buffered by r.
+	counter *countingReader
+	// size is the size of the dump, -1 if it isn't known.
+	size int64
+}
+
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Parse reads a dump, calling fn with every key in the order they are stored.
+//
+// If r has a size, like a file or a bytes.Reader, the lengths larger than the rest of the dump are refused
+// before reading them.
+func Parse(r io.Reader, fn func(e Entry) error) error {
+	counter := &countingReader{r: r}
+	p := &parser{r: bufio.NewReader(counter), counter: counter, size: sizeOf(r)}
+	errHeader := p.header()
+	if errHeader != nil {
+		return errHeader
+	}
+
+	var (
+		db        int
+		expiresAt time.Time
+	)
+	for {
+		op, errOp := p.r.ReadByte()
+		if errOp != nil {
+			return fmt.Errorf("unexpected end of the dump: %w", errOp)
+		}
+
+		switch op {
+		case opEOF:
+			return nil
+		case opSelectDB:
+			n, errDB := p.length()
+			if errDB != nil {
+				return errDB
+			}
+			db = int(n)
+		case opExpireTime:
+			b, errRead := p.read(4)
+			if errRead != nil {
+				return errRead
+			}
+			expiresAt = time.Unix(int64(binary.LittleEndian.Uint32(b)), 0)
+		case opExpireTimeMs:
+			b, errRead := p.read(8)
+			if errRead != nil {
+				return errRead
+			}
+			expiresAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+		case opResizeDB:
+			errSkip := p.skipLengths(2)
+			if errSkip != nil {
+				return errSkip
+			}
+		case opSlotInfo:
+			errSkip := p.skipLengths(3)
+			if errSkip != nil {
+				return errSkip
+			}
+		case opAux:
+			errSkip := p.skipStrings(2)
+			if errSkip != nil {
+				return errSkip
+			}
+		case opFunction2:
+			errSkip := p.skipStrings(1)
+			if errSkip != nil {
+				return errSkip
+			}
+		case opFreq:
+			_, errRead := p.r.ReadByte()
+			if errRead != nil {
+				return errRead
+			}
+		case opIdle:
+			errSkip := p.skipLengths(1)
+			if errSkip != nil {
+				return errSkip
+			}
+		case opModuleAux:
+			return fmt.Errorf("%w: module data", ErrUnsupported)
+		default:
+			key, errKey := p.string()
+			if errKey != nil {
+				return errKey
+			}
+			value, errValue := p.value(op)
+			if errValue != nil {
+				return fmt.Errorf("couldn't read key '%s': %w", key, errValue)
+			}
+			errFn := fn(Entry{DB: db, Key: key, Value: value, ExpiresAt: expiresAt})
+			if errFn != nil {
+				return errFn
+			}
+			expiresAt = time.Time{}
+		}
+	}
+}
+
+func (p *parser) header() error {
+	b, errRead := p.read(9)
+	if errRead != nil {
+		return errors.New("the file is too short to be a RDB dump")
+	}
+	if string(b[:5]) != "REDIS" {
+		return errors.New("the file isn't a RDB dump")
+	}
+	version, errVersion := strconv.Atoi(string(b[5:]))
+	if errVersion != nil {
+		return fmt.Errorf("invalid RDB version: %s", b[5:])
+	}
+	if version > maxSupportedVersion {
+		return fmt.Errorf("RDB version %v isn't supported, the latest supported is %v", version, maxSupportedVersion)
+	}
+	return nil
+}
+
+func (p *parser) value(t byte) (any, error) {
+	switch t {
+	case typeString:
+		return p.string()
+	case typeList, typeSet:
+		return p.strings(1)
+	case typeHash:
+		items, errItems := p.strings(2)
+		if errItems != nil {
+			return nil, errItems
+		}
+		return pairs(items), nil
+	case typeZSet, typeZSet2:
+		return p.zset(t == typeZSet2)
+	case typeHashZipmap:
+		b, errBlob := p.string()
+		if errBlob != nil {
+			return nil, errBlob
+		}
+		return zipmap([]byte(b))
+	case typeListZiplist, typeSetListpack, typeSetIntset:
+		return p.blob(t)
+	case typeHashZiplist, typeHashListpack:
+		items, errItems := p.blob(t)
+		if errItems != nil {
+			return nil, errItems
+		}
+		return pairs(items), nil
+	case typeZSetZiplist, typeZSetListpack:
+		items, errItems := p.blob(t)
+		if errItems != nil {
+			return nil, errItems
+		}
+		return scores(items)
+	case typeListQuicklist, typeListQuicklist2:
+		return p.quicklist(t == typeListQuicklist2)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, t)
+	}
+}
+
+// blob reads a value stored as a single string with a ziplist, a listpack or an intset, returning its items.
+func (p *parser) blob(t byte) ([]string, error) {
+	b, errBlob := p.string()
+	if errBlob != nil {
+		return nil, errBlob
+	}
+	switch t {
+	case typeSetIntset:
+		return intset([]byte(b))
+	case typeSetListpack, typeHashListpack, typeZSetListpack:
+		return listpack([]byte(b))
+	default:
+		return ziplist([]byte(b))
+	}
+}
+
+func (p *parser) quicklist(v2 bool) ([]string, error) {
+	n, errLen := p.length()
+	if errLen != nil {
+		return nil, errLen
+	}
+	items := make([]string, 0)
+	for i := uint64(0); i < n; i++ {
+		container := uint64(0)
+		if v2 {
+			c, errContainer := p.length()
+			if errContainer != nil {
+				return nil, errContainer
+			}
+			container = c
+		}
+		b, errNode := p.string()
+		if errNode != nil {
+			return nil, errNode
+		}
+
+		var (
+			nodeItems []string
+			errItems  error
+		)
+		switch {
+		case v2 && container == quicklistNodePlain:
+			nodeItems = []string{b}
+		case v2:
+			nodeItems, errItems = listpack([]byte(b))
+		default:
+			nodeItems, errItems = ziplist([]byte(b))
+		}
+		if errItems != nil {
+			return nil, errItems
+		}
+		items = append(items, nodeItems...)
+	}
+	return items, nil
+}
+
+func (p *parser) zset(binaryScores bool) (map[string]float64, error) {
+	n, errLen := p.length()
+	if errLen != nil {
+		return nil, errLen
+	}
+	members := make(map[string]float64, clampPrealloc(n))
+	for i := uint64(0); i < n; i++ {
+		member, errMember := p.string()
+		if errMember != nil {
+			return nil, errMember
+		}
+		score, errScore := p.score(binaryScores)
+		if errScore != nil {
+			return nil, errScore
+		}
+		members[member] = score
+	}
+	return members, nil
+}
+
+func (p *parser) score(binaryScore bool) (float64, error) {
+	if binaryScore {
+		b, errRead := p.read(8)
+		if errRead != nil {
+			return 0, errRead
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	}
+
+	n, errLen := p.r.ReadByte()
+	if errLen != nil {
+		return 0, errLen
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, errRead := p.read(uint64(n))
+	if errRead != nil {
+		return 0, errRead
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// strings reads a length followed by length*per strings.
+func (p *parser) strings(per uint64) ([]string, error) {
+	n, errLen := p.length()
+	if errLen != nil {
+		return nil, errLen
+	}
+	if n > math.MaxUint64/per {
+		return nil, fmt.Errorf("%w: %v items of %v strings overflow", errCorrupted, n, per)
+	}
+	total := n * per
+	// Every string takes at least a byte.
+	errTotal := p.checkLength(total)
+	if errTotal != nil {
+		return nil, errTotal
+	}
+	items := make([]string, 0, clampPrealloc(total))
+	for i := uint64(0); i < total; i++ {
+		s, errString := p.string()
+		if errString != nil {
+			return nil, errString
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+// Special encodings of the strings.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// lengthOrEncoding reads a length, or the special encoding of the string which follows, if encoded is true.
+func (p *parser) lengthOrEncoding() (n uint64, encoded bool, err error) {
+	b, errRead := p.r.ReadByte()
+	if errRead != nil {
+		return 0, false, fmt.Errorf("unexpected end of the dump: %w", errRead)
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, errNext := p.r.ReadByte()
+		if errNext != nil {
+			return 0, false, errNext
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			buf, errBuf := p.read(4)
+			if errBuf != nil {
+				return 0, false, errBuf
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, errBuf := p.read(8)
+			if errBuf != nil {
+				return 0, false, errBuf
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		default:
+			return 0, false, fmt.Errorf("invalid length encoding: %#x", b)
+		}
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+func (p *parser) length() (uint64, error) {
+	n, encoded, errLen := p.lengthOrEncoding()
+	if errLen != nil {
+		return 0, errLen
+	}
+	if encoded {
+		return 0, errors.New("expected a length, found an encoded string")
+	}
+	return n, nil
+}
+
+func (p *parser) string() (string, error) {
+	n, encoded, errLen := p.lengthOrEncoding()
+	if errLen != nil {
+		return "", errLen
+	}
+	if !encoded {
+		b, errRead := p.read(n)
+		return string(b), errRead
+	}
+
+	switch n {
+	case encInt8:
+		b, errRead := p.read(1)
+		if errRead != nil {
+			return "", errRead
+		}
+		return strconv.Itoa(int(int8(b[0]))), nil
+	case encInt16:
+		b, errRead := p.read(2)
+		if errRead != nil {
+			return "", errRead
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case encInt32:
+		b, errRead := p.read(4)
+		if errRead != nil {
+			return "", errRead
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case encLZF:
+		compressedLen, errCompressed := p.length()
+		if errCompressed != nil {
+			return "", errCompressed
+		}
+		uncompressedLen, errUncompressed := p.length()
+		if errUncompressed != nil {
+			return "", errUncompressed
+		}
+		if uncompressedLen > math.MaxInt32 {
+			return "", fmt.Errorf("%w: compressed string of %v bytes", errCorrupted, uncompressedLen)
+		}
+		compressed, errRead := p.read(compressedLen)
+		if errRead != nil {
+			return "", errRead
+		}
+		b, errLZF := lzfDecompress(compressed, int(uncompressedLen))
+		return string(b), errLZF
+	default:
+		return "", fmt.Errorf("invalid string encoding: %v", n)
+	}
+}
+
+func (p *parser) skipLengths(n int) error {
+	for i := 0; i < n; i++ {
+		_, errLen := p.length()
+		if errLen != nil {
+			return errLen
+		}
+	}
+	return nil
+}
+
+func (p *parser) skipStrings(n int) error {
+	for i := 0; i < n; i++ {
+		_, errString := p.string()
+		if errString != nil {
+			return errString
+		}
+	}
+	return nil
+}
+
+// read reads n bytes, into a buffer which grows as they are read, since n comes from the dump.
+func (p *parser) read(n uint64) ([]byte, error) {
+	errLen := p.checkLength(n)
+	if errLen != nil {
+		return nil, errLen
+	}
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: length %v", errCorrupted, n)
+	}
+	var buf bytes.Buffer
+	buf.Grow(clampPrealloc(n))
+	copied, errCopy := io.CopyN(&buf, p.r, int64(n))
+	if errCopy != nil {
+		if errors.Is(errCopy, io.EOF) {
+			errCopy = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("unexpected end of the dump, read %v of %v bytes: %w", copied, n, errCopy)
+	}
+	return buf.Bytes(), nil
+}
+
+// checkLength refuses a length of n bytes larger than the rest of the dump, if its size is known.
+func (p *parser) checkLength(n uint64) error {
+	if p.size < 0 {
+		return nil
+	}
+	remaining := p.size - (p.counter.n - int64(p.r.Buffered()))
+	if remaining < 0 || n > uint64(remaining) {
+		return fmt.Errorf("%w: length %v is larger than the %v bytes left in the dump", errCorrupted, n, remaining)
+	}
+	return nil
+}
+
+// sizeOf returns the size of a reader, or -1 if it isn't known.
+func sizeOf(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Size() int64 }:
+		return v.Size()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		info, errStat := v.Stat()
+		if errStat != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		// The reader can be past the start of the file.
+		if seeker, ok := r.(io.Seeker); ok {
+			offset, errSeek := seeker.Seek(0, io.SeekCurrent)
+			if errSeek == nil {
+				return info.Size() - offset
+			}
+		}
+		return -1
+	default:
+		return -1
+	}
+}
+
+// clampPrealloc returns how many items or bytes to allocate ahead for a length read from the dump.
+func clampPrealloc(n uint64) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(n)
+}
+
+// pairs returns the hash of a list of alternating fields and values.
+func pairs(items []string) map[string]string {
+	hash := make(map[string]string, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		hash[items[i]] = items[i+1]
+	}
+	return hash
+}
+
+// scores returns the sorted set of a list of alternating members and scores.
+func scores(items []string) (map[string]float64, error) {
+	members := make(map[string]float64, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		score, errScore := strconv.ParseFloat(items[i+1], 64)
+		if errScore != nil {
+			return nil, fmt.Errorf("invalid score of member '%s': %w", items[i], errScore)
+		}
+		members[items[i]] = score
+	}
+	return members, nil
+}
@@ -0,0 +1,323 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dump builds a RDB dump with a version 11 header and an EOF opcode around body.
+func dump(body ...[]byte) []byte {
+	b := []byte("REDIS0011")
+	for _, part := range body {
+		b = append(b, part...)
+	}
+	return append(b, opEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+// length encodes a length like Redis does.
+func length(n int) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n)}
+	case n < 1<<14:
+		return []byte{byte(n>>8) | 0x40, byte(n)}
+	default:
+		b := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+}
+
+func str(s string) []byte {
+	return append(length(len(s)), s...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// entry encodes a key of a type, with its value already encoded.
+func entry(t byte, key string, value ...[]byte) []byte {
+	return concat(append([][]byte{{t}, str(key)}, value...)...)
+}
+
+// listpackOf encodes strings of up to 63 bytes, and integers from 0 to 127, as a listpack.
+func listpackOf(items ...any) []byte {
+	var body []byte
+	for _, item := range items {
+		switch v := item.(type) {
+		case int:
+			body = append(body, byte(v), 1)
+		case string:
+			body = append(body, 0x80|byte(len(v)))
+			body = append(body, v...)
+			body = append(body, byte(1+len(v)))
+		}
+	}
+	header := make([]byte, 6)
+	binary.LittleEndian.PutUint32(header, uint32(6+len(body)+1))
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(items)))
+	return append(append(header, body...), 0xFF)
+}
+
+// ziplistOf encodes strings of up to 63 bytes as a ziplist.
+func ziplistOf(items ...string) []byte {
+	body := make([]byte, 0)
+	prev := 0
+	for _, item := range items {
+		e := append([]byte{byte(prev), byte(len(item))}, item...)
+		body = append(body, e...)
+		prev = len(e)
+	}
+	header := make([]byte, 10)
+	binary.LittleEndian.PutUint32(header, uint32(10+len(body)+1))
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(items)))
+	return append(append(header, body...), 0xFF)
+}
+
+func intsetOf(width int, values ...int64) []byte {
+	b := make([]byte, 8, 8+width*len(values))
+	binary.LittleEndian.PutUint32(b, uint32(width))
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(values)))
+	for _, v := range values {
+		for i := 0; i < width; i++ {
+			b = append(b, byte(uint64(v)>>(8*i)))
+		}
+	}
+	return b
+}
+
+func parseAll(data []byte) ([]Entry, error) {
+	var entries []Entry
+	errParse := Parse(bytes.NewReader(data), func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, errParse
+}
+
+func TestParse(t *testing.T) {
+	score := make([]byte, 8)
+	binary.LittleEndian.PutUint64(score, math.Float64bits(1.5))
+	expiry := make([]byte, 8)
+	binary.LittleEndian.PutUint64(expiry, 1700000000000)
+
+	tests := []struct {
+		name string
+		body []byte
+		want []Entry
+	}{
+		{
+			name: "empty dump",
+			want: nil,
+		},
+		{
+			name: "string",
+			body: entry(typeString, "k", str("v")),
+			want: []Entry{{Key: "k", Value: "v"}},
+		},
+		{
+			name: "integer encoded strings",
+			body: concat(
+				entry(typeString, "i8", []byte{0xC0 | encInt8, 0xFF}),
+				entry(typeString, "i16", []byte{0xC0 | encInt16, 0x00, 0x01}),
+				entry(typeString, "i32", []byte{0xC0 | encInt32, 0x01, 0x00, 0x00, 0x80}),
+			),
+			want: []Entry{{Key: "i8", Value: "-1"}, {Key: "i16", Value: "256"}, {Key: "i32", Value: "-2147483647"}},
+		},
+		{
+			name: "LZF compressed string",
+			// A literal 'a', then a back reference copying it 7 times.
+			body: entry(typeString, "k", []byte{0xC0 | encLZF}, length(4), length(8), []byte{0x00, 'a', 0xA0, 0x00}),
+			want: []Entry{{Key: "k", Value: "aaaaaaaa"}},
+		},
+		{
+			name: "long string",
+			body: entry(typeString, "k", str(strings.Repeat("x", 300))),
+			want: []Entry{{Key: "k", Value: strings.Repeat("x", 300)}},
+		},
+		{
+			name: "expiry and database",
+			body: concat([]byte{opSelectDB}, length(3), []byte{opExpireTimeMs}, expiry, entry(typeString, "k", str("v")),
+				entry(typeString, "k2", str("v2"))),
+			want: []Entry{
+				{DB: 3, Key: "k", Value: "v", ExpiresAt: time.UnixMilli(1700000000000)},
+				{DB: 3, Key: "k2", Value: "v2"},
+			},
+		},
+		{
+			name: "metadata is skipped",
+			body: concat([]byte{opAux}, str("redis-ver"), str("7.2.0"), []byte{opResizeDB}, length(1), length(0),
+				[]byte{opFreq, 5}, []byte{opIdle}, length(10), entry(typeString, "k", str("v"))),
+			want: []Entry{{Key: "k", Value: "v"}},
+		},
+		{
+			name: "list and set",
+			body: concat(
+				entry(typeList, "l", length(2), str("a"), str("b")),
+				entry(typeSet, "s", length(1), str("m")),
+			),
+			want: []Entry{{Key: "l", Value: []string{"a", "b"}}, {Key: "s", Value: []string{"m"}}},
+		},
+		{
+			name: "hash",
+			body: entry(typeHash, "h", length(2), str("f1"), str("v1"), str("f2"), str("v2")),
+			want: []Entry{{Key: "h", Value: map[string]string{"f1": "v1", "f2": "v2"}}},
+		},
+		{
+			name: "sorted set with binary scores",
+			body: entry(typeZSet2, "z", length(1), str("m"), score),
+			want: []Entry{{Key: "z", Value: map[string]float64{"m": 1.5}}},
+		},
+		{
+			name: "sorted set with string scores",
+			body: entry(typeZSet, "z", length(2), str("a"), []byte{3}, []byte("2.5"), str("b"), []byte{254}),
+			want: []Entry{{Key: "z", Value: map[string]float64{"a": 2.5, "b": math.Inf(1)}}},
+		},
+		{
+			name: "listpack set",
+			body: entry(typeSetListpack, "s", str(string(listpackOf("a", 7)))),
+			want: []Entry{{Key: "s", Value: []string{"a", "7"}}},
+		},
+		{
+			name: "listpack hash",
+			body: entry(typeHashListpack, "h", str(string(listpackOf("f", "v")))),
+			want: []Entry{{Key: "h", Value: map[string]string{"f": "v"}}},
+		},
+		{
+			name: "listpack sorted set",
+			body: entry(typeZSetListpack, "z", str(string(listpackOf("m", 3)))),
+			want: []Entry{{Key: "z", Value: map[string]float64{"m": 3}}},
+		},
+		{
+			name: "ziplist list",
+			body: entry(typeListZiplist, "l", str(string(ziplistOf("a", "bc")))),
+			want: []Entry{{Key: "l", Value: []string{"a", "bc"}}},
+		},
+		{
+			name: "intset",
+			body: entry(typeSetIntset, "s", str(string(intsetOf(2, -2, 300)))),
+			want: []Entry{{Key: "s", Value: []string{"-2", "300"}}},
+		},
+		{
+			name: "quicklist 2 with a packed and a plain node",
+			body: entry(typeListQuicklist2, "l", length(2),
+				length(2), str(string(listpackOf("a", "b"))),
+				length(quicklistNodePlain), str("large")),
+			want: []Entry{{Key: "l", Value: []string{"a", "b", "large"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errParse := parseAll(dump(tt.body))
+			if errParse != nil {
+				t.Fatal(errParse)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		// wantErr is the error the parse must wrap, if any, and wantMessage a part of its message.
+		wantErr     error
+		wantMessage string
+	}{
+		{name: "too short", data: []byte("RED"), wantMessage: "too short"},
+		{name: "not a dump", data: []byte("NOTREDIS1"), wantMessage: "isn't a RDB dump"},
+		{name: "newer version", data: []byte("REDIS0099"), wantMessage: "isn't supported"},
+		{name: "missing EOF", data: []byte("REDIS0011"), wantMessage: "unexpected end"},
+		{
+			name:    "string longer than the dump",
+			data:    []byte("REDIS0011" + "\x00\x01k\x05ab"),
+			wantErr: errCorrupted,
+		},
+		{
+			name:    "list longer than the dump",
+			data:    dump(entry(typeList, "l", []byte{0x80, 0xFF, 0xFF, 0xFF, 0xFF}, str("a"))),
+			wantErr: errCorrupted,
+		},
+		{
+			name:    "hash whose length overflows",
+			data:    dump(entry(typeHash, "h", []byte{0x81, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})),
+			wantErr: errCorrupted,
+		},
+		{
+			name:    "LZF string longer than its length",
+			data:    dump(entry(typeString, "k", []byte{0xC0 | encLZF}, length(4), length(2), []byte{0x00, 'a', 0xA0, 0x00})),
+			wantErr: errCorrupted,
+		},
+		{name: "stream", data: dump(entry(15, "k")), wantErr: ErrUnsupported},
+		{name: "module data", data: dump([]byte{opModuleAux}), wantErr: ErrUnsupported},
+		{
+			name:    "corrupted listpack",
+			data:    dump(entry(typeSetListpack, "s", str("\x07\x00\x00\x00\x01\x00\x80"))),
+			wantErr: errCorrupted,
+		},
+		{
+			name:    "LZF length mismatch",
+			data:    dump(entry(typeString, "k", []byte{0xC0 | encLZF}, length(2), length(5), []byte{0x00, 'a'})),
+			wantErr: errCorrupted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errParse := parseAll(tt.data)
+			if errParse == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(errParse, tt.wantErr) {
+				t.Errorf("got error %v, want %v", errParse, tt.wantErr)
+			}
+			if !strings.Contains(errParse.Error(), tt.wantMessage) {
+				t.Errorf("got error %q, want it to contain %q", errParse, tt.wantMessage)
+			}
+		})
+	}
+}
+
+// TestParseUnknownSize checks the lengths of a dump whose size isn't known are read without allocating them ahead.
+func TestParseUnknownSize(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "truncated string", data: []byte("REDIS0011" + "\x00\x01k\x05ab")},
+		{name: "huge string", data: dump(entry(typeString, "k", []byte{0x81, 0, 0, 0, 0x10, 0, 0, 0, 0}, str("a")))},
+		{name: "huge list", data: append([]byte("REDIS0011"), entry(typeList, "l", []byte{0x81, 0, 0, 0, 0x10, 0, 0, 0, 0}, str("a"))...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The multi reader hides the size of the bytes reader.
+			errParse := Parse(io.MultiReader(bytes.NewReader(tt.data)), func(e Entry) error { return nil })
+			if errParse == nil || !strings.Contains(errParse.Error(), "unexpected end") {
+				t.Errorf("got error %v, want an unexpected end of the dump", errParse)
+			}
+		})
+	}
+}
+
+func TestParseStopsOnCallbackError(t *testing.T) {
+	errStop := errors.New("stop")
+	calls := 0
+	errParse := Parse(bytes.NewReader(dump(entry(typeString, "a", str("1")), entry(typeString, "b", str("2")))),
+		func(e Entry) error {
+			calls++
+			return errStop
+		})
+	if !errors.Is(errParse, errStop) || calls != 1 {
+		t.Errorf("got error %v after %d calls, want %v after 1", errParse, calls, errStop)
+	}
+}
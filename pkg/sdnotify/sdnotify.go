@@ -0,0 +1,63 @@
+// Package sdnotify implements systemd's notification protocol, which services of Type=notify use
+// to report their readiness and ping the watchdog.
+package sdnotify
+
+import (
+	"errors"
+	"github.com/narvikd/errorskit"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States sent to systemd.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// ErrNoSocket is returned when the process isn't run by systemd with a notification socket.
+var ErrNoSocket = errors.New("NOTIFY_SOCKET isn't set")
+
+// Enabled returns whether the process is run by systemd with a notification socket.
+func Enabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Notify sends state to systemd, ex: Ready, or "STATUS=..." to describe the service's state.
+func Notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return ErrNoSocket
+	}
+	// A leading @ is a socket in the abstract namespace.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+	conn, errDial := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if errDial != nil {
+		return errorskit.Wrap(errDial, "couldn't connect to systemd's notification socket")
+	}
+	defer conn.Close()
+	_, errWrite := conn.Write([]byte(state))
+	if errWrite != nil {
+		return errorskit.Wrap(errWrite, "couldn't notify systemd")
+	}
+	return nil
+}
+
+// WatchdogInterval returns the interval systemd expects the watchdog to be pinged within,
+// or 0 if the watchdog isn't enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, errUsec := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if errUsec != nil || usec <= 0 {
+		return 0
+	}
+	// WATCHDOG_PID is set when the watchdog is meant for a specific process, like the main one of the service.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
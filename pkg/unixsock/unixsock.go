@@ -0,0 +1,39 @@
+// Package unixsock provides listeners on unix domain sockets.
+package unixsock
+
+import (
+	"errors"
+	"github.com/narvikd/errorskit"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Listen listens on the unix socket at path, creating its directory if needed.
+//
+// A socket left behind by a previous process is removed first, since it would make the listen fail.
+// The socket is only accessible by the user and group of the process.
+func Listen(path string) (net.Listener, error) {
+	errDir := os.MkdirAll(filepath.Dir(path), 0o755)
+	if errDir != nil {
+		return nil, errorskit.Wrap(errDir, "couldn't create unix socket dir")
+	}
+
+	errRemove := os.Remove(path)
+	if errRemove != nil && !errors.Is(errRemove, fs.ErrNotExist) {
+		return nil, errorskit.Wrap(errRemove, "couldn't remove stale unix socket")
+	}
+
+	ln, errListen := net.Listen("unix", path)
+	if errListen != nil {
+		return nil, errorskit.Wrap(errListen, "couldn't listen on unix socket")
+	}
+
+	errChmod := os.Chmod(path, 0o660)
+	if errChmod != nil {
+		_ = ln.Close()
+		return nil, errorskit.Wrap(errChmod, "couldn't set unix socket permissions")
+	}
+	return ln, nil
+}